@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"tcp/pkg/client"
+	"text/tabwriter"
+	"time"
+)
+
+// the per peer fields of the info command, after peer.<address>
+var peerFields = []string{"handoff", "redo", "outbound", "queue", "dropped"}
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), `Usage: admin [flags] command [arguments]
+
+Commands:
+  status              role, keys, clients and replication status of every server (the default)
+  members             servers of the cluster, from each server's topology
+  clients             client connections of every server
+  kill server id      close a client connection, with the id listed by clients
+  readonly on|off     turn read-only mode on or off on every server
+  inspect key         type, size, TTL and version of the key on every server
+  flush               remove every key from every server (needs -yes)
+  snapshot            write a snapshot of every server in -peers to a file in -out
+
+Flags:`)
+		flag.PrintDefaults()
+	}
+
+	servers := flag.String("servers", "localhost:8000", "Comma separated client addresses of the servers")
+	token := flag.String("token", "", "Token to authenticate with, if the servers require it")
+	peers := flag.String("peers", "", "Comma separated peer addresses of the servers, to snapshot")
+	peerToken := flag.String("peerToken", "", "Peer secret to authenticate with, if the servers have one")
+	timeout := flag.Duration("timeout", 5*time.Second, "How long each command waits for the server")
+	outputDir := flag.String("out", ".", "Directory to write snapshots to, a JSON file per server")
+	confirmed := flag.Bool("yes", false, "Confirm removing every key, for flush")
+
+	flag.Parse()
+
+	a := admin{
+		servers:     splitList(*servers),
+		options:     client.Options{Timeout: *timeout, Token: *token},
+		peers:       splitList(*peers),
+		peerOptions: client.Options{Timeout: *timeout, Token: *peerToken},
+		outputDir:   *outputDir,
+		confirmed:   *confirmed,
+		output:      os.Stdout,
+	}
+
+	arguments := flag.Args()
+	if len(arguments) == 0 {
+		arguments = []string{"status"}
+	}
+
+	err := a.run(arguments[0], arguments[1:])
+	if errors.Is(err, errUsage) {
+		log.Print(err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// admin sends admin commands to each of the servers.
+type admin struct {
+	servers []string
+	options client.Options
+
+	// snapshots are fetched from the peer addresses, written to files in the directory
+	peers       []string
+	peerOptions client.Options
+	outputDir   string
+
+	// whether removing every key was confirmed
+	confirmed bool
+
+	output io.Writer
+}
+
+// run performs the command with its arguments.
+func (a *admin) run(command string, arguments []string) error {
+	switch command {
+	case "status":
+		return a.status()
+	case "members":
+		return a.members()
+	case "clients":
+		return a.clients()
+	case "kill":
+		return a.kill(arguments)
+	case "readonly":
+		return a.readOnly(arguments)
+	case "inspect":
+		return a.inspect(arguments)
+	case "flush":
+		return a.flush()
+	case "snapshot":
+		return a.snapshot()
+	default:
+		return fmt.Errorf("%w: unknown command %s", errUsage, command)
+	}
+}
+
+// each calls fn with a connection to every server in turn, stopping at the first error.
+func (a *admin) each(fn func(address string, c *client.Client) error) error {
+	for _, address := range a.servers {
+		c, err := client.Dial(context.Background(), address, a.options)
+		if err != nil {
+			return fmt.Errorf("unable to connect to %s: %w", address, err)
+		}
+
+		err = fn(address, c)
+		_ = c.Close()
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", address, err)
+		}
+	}
+
+	return nil
+}
+
+// status shows each server's role, keys, clients and mode, then the state of replication to each of
+// its peers, in one view. Servers that can't be reached are shown as down, rather than failing.
+func (a *admin) status() error {
+	servers := tabwriter.NewWriter(a.output, 0, 0, 2, ' ', 0)
+	replication := &strings.Builder{}
+	peers := tabwriter.NewWriter(replication, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(servers, "SERVER\tROLE\tKEYS\tCLIENTS\tREAD ONLY\tOPS/SEC\tUPTIME")
+	fmt.Fprintln(peers, "SERVER\tPEER\tSTATE\tHANDOFF\tREDO\tOUTBOUND\tQUEUE\tDROPPED")
+
+	for _, address := range a.servers {
+		info, err := a.info(address)
+		if err != nil {
+			fmt.Fprintf(servers, "%s\tdown\t\t\t\t\t%v\n", address, err)
+			continue
+		}
+
+		fields := parseFields(info)
+
+		fmt.Fprintf(servers, "%s\t%s\t%s\t%s\t%s\t%s\t%ss\n", address, fields["role"], fields["keys"],
+			fields["clients"], fields["read_only"], fields["ops_per_sec"], fields["uptime_seconds"])
+
+		for _, peer := range peerAddresses(fields) {
+			row := []string{address, peer, fields["peer."+peer]}
+
+			for _, field := range peerFields {
+				row = append(row, valueOr(fields["peer."+peer+"."+field], "-"))
+			}
+
+			fmt.Fprintln(peers, strings.Join(row, "\t"))
+		}
+	}
+
+	if err := servers.Flush(); err != nil {
+		return fmt.Errorf("error writing status: %w", err)
+	}
+
+	if err := peers.Flush(); err != nil {
+		return fmt.Errorf("error writing status: %w", err)
+	}
+
+	if _, err := fmt.Fprint(a.output, "\n"+replication.String()); err != nil {
+		return fmt.Errorf("error writing status: %w", err)
+	}
+
+	return nil
+}
+
+// info returns the server's statistics.
+func (a *admin) info(address string) (string, error) {
+	c, err := client.Dial(context.Background(), address, a.options)
+	if err != nil {
+		return "", fmt.Errorf("unable to connect: %w", err)
+	}
+
+	defer c.Close()
+
+	info, err := c.Info(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("error fetching info: %w", err)
+	}
+
+	return info, nil
+}
+
+// members shows the servers of the cluster, by id, combining the topology reported by each server.
+func (a *admin) members() error {
+	members := make(map[string]string)
+	replicas := make(map[string]string)
+
+	err := a.each(func(address string, c *client.Client) error {
+		topology, err := c.Topology(context.Background())
+		if err != nil {
+			return fmt.Errorf("error fetching topology: %w", err)
+		}
+
+		fields := parseFields(topology)
+		replicas[address] = fields["replicas"]
+
+		for name, value := range fields {
+			if id, found := strings.CutPrefix(name, "server."); found && (value != "" || members[id] == "") {
+				members[id] = value
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	writer := tabwriter.NewWriter(a.output, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(writer, "ID\tCLIENT ADDRESS")
+
+	for _, id := range ids {
+		fmt.Fprintf(writer, "%s\t%s\n", valueOr(id, "-"), valueOr(members[id], "unknown"))
+	}
+
+	for _, address := range a.servers {
+		if replicas[address] != "0" {
+			fmt.Fprintf(writer, "\nsharded, %s replicas of each key\n", replicas[address])
+			break
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing members: %w", err)
+	}
+
+	return nil
+}
+
+// clients lists the client connections of every server.
+func (a *admin) clients() error {
+	writer := tabwriter.NewWriter(a.output, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(writer, "SERVER\tCLIENT")
+
+	err := a.each(func(address string, c *client.Client) error {
+		descriptions, err := c.Clients(context.Background())
+		if err != nil {
+			return fmt.Errorf("error listing clients: %w", err)
+		}
+
+		for _, description := range descriptions {
+			fmt.Fprintf(writer, "%s\t%s\n", address, description)
+		}
+
+		return nil
+	})
+
+	// the clients of the servers listed before any failed are still shown
+	if flushErr := writer.Flush(); flushErr != nil && err == nil {
+		return fmt.Errorf("error writing clients: %w", flushErr)
+	}
+
+	return err
+}
+
+// kill closes a client connection of one of the servers.
+func (a *admin) kill(arguments []string) error {
+	if len(arguments) != 2 {
+		return fmt.Errorf("%w: kill needs the server and the client id", errUsage)
+	}
+
+	id, err := strconv.Atoi(arguments[1])
+	if err != nil || id < 0 {
+		return fmt.Errorf("%w: invalid client id %s", errUsage, arguments[1])
+	}
+
+	target := admin{servers: arguments[:1], options: a.options}
+
+	return target.each(func(address string, c *client.Client) error {
+		if err := c.KillClient(context.Background(), id); err != nil {
+			return fmt.Errorf("error killing client %d: %w", id, err)
+		}
+
+		log.Printf("Killed client %d of %s", id, address)
+
+		return nil
+	})
+}
+
+// readOnly turns read-only mode on or off on every server.
+func (a *admin) readOnly(arguments []string) error {
+	if len(arguments) != 1 || (arguments[0] != "on" && arguments[0] != "off") {
+		return fmt.Errorf("%w: readonly needs on or off", errUsage)
+	}
+
+	return a.each(func(address string, c *client.Client) error {
+		if err := c.SetReadOnly(context.Background(), arguments[0] == "on"); err != nil {
+			return fmt.Errorf("error setting read-only mode: %w", err)
+		}
+
+		log.Printf("Read-only mode %s on %s", arguments[0], address)
+
+		return nil
+	})
+}
+
+// inspect shows the type, size, TTL and version of the key on every server, so differences between the
+// servers' copies can be seen.
+func (a *admin) inspect(arguments []string) error {
+	if len(arguments) != 1 {
+		return fmt.Errorf("%w: inspect needs the key", errUsage)
+	}
+
+	writer := tabwriter.NewWriter(a.output, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(writer, "SERVER\tTYPE\tSIZE\tTTL (MS)\tVERSION")
+
+	err := a.each(func(address string, c *client.Client) error {
+		description, found, err := c.Inspect(context.Background(), arguments[0])
+		if err != nil {
+			return fmt.Errorf("error inspecting key: %w", err)
+		}
+
+		if !found {
+			fmt.Fprintf(writer, "%s\tnone\t\t\t\n", address)
+			return nil
+		}
+
+		fields := parseFields(description)
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", address, fields["type"], fields["size"], fields["ttl_ms"],
+			valueOr(fields["version"], "-"))
+
+		return nil
+	})
+
+	// the key on the servers listed before any failed is still shown
+	if flushErr := writer.Flush(); flushErr != nil && err == nil {
+		return fmt.Errorf("error writing key: %w", flushErr)
+	}
+
+	return err
+}
+
+// flush removes every key from every server, once confirmed.
+func (a *admin) flush() error {
+	if !a.confirmed {
+		return fmt.Errorf("%w: flush removes every key, pass -yes to confirm", errUsage)
+	}
+
+	return a.each(func(address string, c *client.Client) error {
+		if err := c.FlushAll(context.Background()); err != nil {
+			return fmt.Errorf("error flushing: %w", err)
+		}
+
+		log.Print("Flushed every key on ", address)
+
+		return nil
+	})
+}
+
+// snapshot writes every key of each server to a file in the directory, named after its peer address, as
+// a JSON array of objects with each key's type, expiry, and a string's value or a collection's contents,
+// strings being in the format the dump tool loads.
+func (a *admin) snapshot() error {
+	if len(a.peers) == 0 {
+		return fmt.Errorf("%w: snapshot needs the servers' -peers addresses", errUsage)
+	}
+
+	for _, peer := range a.peers {
+		filename := filepath.Join(a.outputDir, strings.NewReplacer(":", "_", "/", "_").Replace(peer)+".json")
+
+		count, err := snapshotPeer(peer, a.peerOptions, filename)
+		if err != nil {
+			return fmt.Errorf("unable to snapshot %s: %w", peer, err)
+		}
+
+		log.Printf("Wrote snapshot of %d keys from %s to %s", count, peer, filename)
+	}
+
+	return nil
+}
+
+// snapshotPeer writes every key of the server to the file, returning how many were written.
+func snapshotPeer(peer string, options client.Options, filename string) (int, error) {
+	c, err := client.Dial(context.Background(), peer, options)
+	if err != nil {
+		return 0, fmt.Errorf("error connecting: %w", err)
+	}
+
+	defer c.Close()
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return 0, fmt.Errorf("error creating file: %w", err)
+	}
+
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	separator := "[\n"
+	count := 0
+
+	err = c.Snapshot(context.Background(), 0, func(record client.Record) error {
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("error encoding key: %w", err)
+		}
+
+		if _, err := writer.WriteString(separator + string(encoded)); err != nil {
+			return fmt.Errorf("error writing file: %w", err)
+		}
+
+		count++
+		separator = ",\n"
+
+		return nil
+	})
+	if err != nil {
+		return count, fmt.Errorf("error fetching snapshot: %w", err)
+	}
+
+	end := "\n]\n"
+	if count == 0 {
+		end = "[]\n"
+	}
+
+	if _, err := writer.WriteString(end); err != nil {
+		return count, fmt.Errorf("error writing file: %w", err)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return count, fmt.Errorf("error writing file: %w", err)
+	}
+
+	return count, nil
+}
+
+// parseFields returns the name=value fields reported by the info and topology commands.
+func parseFields(text string) map[string]string {
+	fields := make(map[string]string)
+
+	for _, field := range strings.Fields(text) {
+		name, value, _ := strings.Cut(field, "=")
+		fields[name] = value
+	}
+
+	return fields
+}
+
+// peerAddresses returns the addresses of the peers in the info fields, in order.
+func peerAddresses(fields map[string]string) []string {
+	var peers []string
+
+	for name := range fields {
+		peer, found := strings.CutPrefix(name, "peer.")
+		if !found || isPeerField(peer) {
+			continue
+		}
+
+		peers = append(peers, peer)
+	}
+
+	sort.Strings(peers)
+
+	return peers
+}
+
+// isPeerField returns whether the name, after peer., is one of the per peer fields rather than the
+// peer's state.
+func isPeerField(name string) bool {
+	for _, field := range peerFields {
+		if strings.HasSuffix(name, "."+field) {
+			return true
+		}
+	}
+
+	return strings.HasSuffix(name, ".redo_dropped") || strings.Contains(name, ".outbound_")
+}
+
+func valueOr(value string, otherwise string) string {
+	if value == "" {
+		return otherwise
+	}
+
+	return value
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+
+	return strings.Split(list, ",")
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"tcp/pkg/client"
+	"time"
+)
+
+const letters = "abcdefghijklmnopqrstuvwxyz"
+
+var errInvalidSetting = errors.New("invalid setting")
+
+// settings holds how the load is generated.
+type settings struct {
+	addresses []string
+	cluster   bool
+	options   client.Options
+
+	clients  int
+	duration time.Duration
+
+	// keys are chosen from key:0 to key:N-1, with key:0 the most popular if zipfian
+	keys    int
+	zipfian bool
+	zipfS   float64
+
+	// fraction of operations that are gets, the rest being puts
+	readRatio float64
+
+	// values put are between these sizes in bytes
+	minValueSize int
+	maxValueSize int
+}
+
+// results holds the latency of every operation a client performed, and how many failed.
+type results struct {
+	reads  []time.Duration
+	writes []time.Duration
+	errors int
+}
+
+func main() {
+	servers := flag.String("servers", "localhost:8000",
+		"Comma separated client addresses of the servers to send commands to, each client using the first")
+	cluster := flag.Bool("cluster", false,
+		"Whether the servers are a sharded cluster, routing each key to the server holding it")
+	token := flag.String("token", "", "Token to authenticate with, if the servers require it")
+	timeout := flag.Duration("timeout", 5*time.Second, "How long each operation waits for the server")
+
+	clients := flag.Int("clients", 50, "Number of concurrent clients, each with its own connection")
+	duration := flag.Duration("duration", 10*time.Second, "How long to generate load for")
+
+	keys := flag.Int("keys", 100000, "Number of distinct keys used")
+	distribution := flag.String("distribution", "uniform",
+		"How keys are chosen: uniform (equally likely) or zipfian (a few keys are far more popular)")
+	zipfS := flag.Float64("zipfS", 1.1, "Skew of the zipfian distribution, greater than 1, higher being more skewed")
+
+	readRatio := flag.Float64("reads", 0.9, "Fraction of operations that are gets, the rest being puts")
+	valueSize := flag.Int("valueSize", 100, "Size in bytes of the values put")
+	maxValueSize := flag.Int("maxValueSize", 0,
+		"If greater than valueSize, values put are a random size between valueSize and this")
+
+	preload := flag.Bool("preload", true, "Whether every key is put before the load starts, so gets find values")
+
+	flag.Parse()
+
+	s := settings{
+		addresses:    strings.Split(*servers, ","),
+		cluster:      *cluster,
+		options:      client.Options{Timeout: *timeout, Token: *token},
+		clients:      max(*clients, 1),
+		duration:     *duration,
+		keys:         max(*keys, 1),
+		zipfS:        *zipfS,
+		readRatio:    *readRatio,
+		minValueSize: *valueSize,
+		maxValueSize: max(*valueSize, *maxValueSize),
+	}
+
+	if err := s.validate(*distribution); err != nil {
+		log.Fatal("Invalid settings: ", err)
+	}
+
+	kvs := connect(s)
+
+	defer func() {
+		for _, kv := range kvs {
+			_ = kv.Close()
+		}
+	}()
+
+	if *preload {
+		start := time.Now()
+
+		if err := preloadKeys(kvs, s); err != nil {
+			log.Fatal("Unable to preload keys: ", err)
+		}
+
+		log.Printf("Preloaded %d keys in %v", s.keys, time.Since(start).Round(time.Millisecond))
+	}
+
+	log.Printf("Running %d clients for %v, %.0f%% gets, %s keys", s.clients, s.duration, s.readRatio*100,
+		*distribution)
+
+	total, elapsed := run(kvs, s)
+
+	fmt.Print(report(total, elapsed))
+}
+
+// validate sets how keys are chosen from the distribution's name, and checks the other settings are valid.
+func (s *settings) validate(distribution string) error {
+	switch distribution {
+	case "uniform":
+	case "zipfian":
+		s.zipfian = true
+	default:
+		return fmt.Errorf("%w: unknown distribution %s", errInvalidSetting, distribution)
+	}
+
+	switch {
+	case s.zipfian && s.zipfS <= 1:
+		return fmt.Errorf("%w: zipfS must be greater than 1, but got %v", errInvalidSetting, s.zipfS)
+
+	case s.readRatio < 0 || s.readRatio > 1:
+		return fmt.Errorf("%w: reads must be between 0 and 1, but got %v", errInvalidSetting, s.readRatio)
+
+	case s.minValueSize < 0:
+		return fmt.Errorf("%w: valueSize must not be negative, but got %d", errInvalidSetting, s.minValueSize)
+
+	default:
+		return nil
+	}
+}
+
+// connect opens a connection (or one to each server, for a cluster) for every client.
+func connect(s settings) []client.KV {
+	kvs := make([]client.KV, 0, s.clients)
+
+	for i := 0; i < s.clients; i++ {
+		var (
+			kv  client.KV
+			err error
+		)
+
+		if s.cluster {
+			kv, err = client.DialCluster(context.Background(), s.addresses, s.options)
+		} else {
+			kv, err = client.Dial(context.Background(), s.addresses[0], s.options)
+		}
+
+		if err != nil {
+			log.Fatal("Unable to connect: ", err)
+		}
+
+		kvs = append(kvs, kv)
+	}
+
+	return kvs
+}
+
+// preloadKeys puts every key, the keys being shared between the clients.
+func preloadKeys(kvs []client.KV, s settings) error {
+	var (
+		group sync.WaitGroup
+		mutex sync.Mutex
+		errs  []error
+	)
+
+	for i, kv := range kvs {
+		group.Add(1)
+
+		go func(i int, kv client.KV) {
+			defer group.Done()
+
+			random := rand.New(rand.NewSource(int64(i)))
+
+			for key := i; key < s.keys; key += len(kvs) {
+				if err := kv.Put(context.Background(), keyName(key), value(random, s)); err != nil {
+					mutex.Lock()
+					errs = append(errs, err)
+					mutex.Unlock()
+
+					return
+				}
+			}
+		}(i, kv)
+	}
+
+	group.Wait()
+
+	return errors.Join(errs...)
+}
+
+// run generates load from every client until the duration has passed, returning the combined results
+// and how long it took.
+func run(kvs []client.KV, s settings) (results, time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.duration)
+	defer cancel()
+
+	all := make([]results, len(kvs))
+
+	var group sync.WaitGroup
+
+	start := time.Now()
+
+	for i, kv := range kvs {
+		group.Add(1)
+
+		go func(i int, kv client.KV) {
+			defer group.Done()
+
+			all[i] = generate(ctx, kv, s, rand.New(rand.NewSource(time.Now().UnixNano()+int64(i))))
+		}(i, kv)
+	}
+
+	group.Wait()
+
+	elapsed := time.Since(start)
+
+	var total results
+
+	for _, r := range all {
+		total.reads = append(total.reads, r.reads...)
+		total.writes = append(total.writes, r.writes...)
+		total.errors += r.errors
+	}
+
+	return total, elapsed
+}
+
+// generate performs gets and puts of random keys until the context is done, timing each.
+func generate(ctx context.Context, kv client.KV, s settings, random *rand.Rand) results {
+	var (
+		r    results
+		zipf *rand.Zipf
+	)
+
+	if s.zipfian {
+		zipf = rand.NewZipf(random, s.zipfS, 1, uint64(s.keys-1))
+	}
+
+	for ctx.Err() == nil {
+		key := random.Intn(s.keys)
+		if zipf != nil {
+			key = int(zipf.Uint64())
+		}
+
+		var err error
+
+		read := random.Float64() < s.readRatio
+		start := time.Now()
+
+		if read {
+			_, _, err = kv.Get(ctx, keyName(key))
+		} else {
+			err = kv.Put(ctx, keyName(key), value(random, s))
+		}
+
+		elapsed := time.Since(start)
+
+		switch {
+		case ctx.Err() != nil:
+			// operations cut short by the end of the run aren't counted
+		case err != nil:
+			r.errors++
+		case read:
+			r.reads = append(r.reads, elapsed)
+		default:
+			r.writes = append(r.writes, elapsed)
+		}
+	}
+
+	return r
+}
+
+func keyName(key int) string {
+	return "key:" + strconv.Itoa(key)
+}
+
+// value returns a random value, of a random size within the settings.
+func value(random *rand.Rand, s settings) string {
+	size := s.minValueSize + random.Intn(s.maxValueSize-s.minValueSize+1)
+
+	var builder strings.Builder
+
+	builder.Grow(size)
+
+	for i := 0; i < size; i++ {
+		builder.WriteByte(letters[random.Intn(len(letters))])
+	}
+
+	return builder.String()
+}
+
+// report returns the throughput, and latency percentiles of gets and puts.
+func report(total results, elapsed time.Duration) string {
+	operations := len(total.reads) + len(total.writes)
+
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "%d operations in %v, %.0f ops/sec, %d errors\n", operations,
+		elapsed.Round(time.Millisecond), float64(operations)/elapsed.Seconds(), total.errors)
+	fmt.Fprintf(&builder, "get: %d, %s\n", len(total.reads), summarise(total.reads))
+	fmt.Fprintf(&builder, "put: %d, %s\n", len(total.writes), summarise(total.writes))
+
+	return builder.String()
+}
+
+// summarise returns the percentiles and maximum of the durations.
+func summarise(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "no operations"
+	}
+
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	percentile := func(p float64) time.Duration {
+		return sorted[int(float64(len(sorted)-1)*p/100)].Round(time.Microsecond)
+	}
+
+	return fmt.Sprintf("p50=%v p95=%v p99=%v p99.9=%v max=%v", percentile(50), percentile(95), percentile(99),
+		percentile(99.9), sorted[len(sorted)-1].Round(time.Microsecond))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const secretLength = 16
+
+// node holds the settings of one server in the cluster.
+type node struct {
+	id                 string
+	serverHostnamePort string
+	peerHostnamePort   string
+	otherServers       []string
+}
+
+func main() {
+	numNodes := flag.Int("nodes", 3, "Number of servers in the cluster")
+
+	hostname := flag.String("host", "localhost", "Hostname every server listens on")
+
+	basePort := flag.Int("basePort", 8000,
+		"First port to use, each server uses 2 consecutive ports (client then peer)")
+
+	outputDir := flag.String("out", "cluster", "Directory to write the cluster start script to")
+
+	withAuth := flag.Bool("auth", true, "Whether to generate a shared secret for clients and peers")
+
+	launch := flag.Bool("launch", false, "Whether to launch the servers locally, as well as writing the script")
+
+	serverBinary := flag.String("serverBinary", "./server", "Server binary to launch")
+
+	flag.Parse()
+
+	nodes := generateNodes(*numNodes, *hostname, *basePort)
+
+	var secret string
+
+	if *withAuth {
+		secret = generateSecret()
+	}
+
+	scriptFilename, err := writeStartScript(*outputDir, nodes, secret)
+	if err != nil {
+		log.Fatal("Unable to write start script: ", err)
+	}
+
+	log.Printf("Wrote start script for %d servers to %s", len(nodes), scriptFilename)
+
+	if *launch {
+		launchNodes(*serverBinary, nodes, secret)
+	}
+}
+
+// generateNodes allocates ports to each server, and lists every other server as its peers.
+func generateNodes(numNodes int, hostname string, basePort int) []node {
+	nodes := make([]node, numNodes)
+
+	for i := range nodes {
+		nodes[i] = node{
+			id:                 "node" + strconv.Itoa(i+1),
+			serverHostnamePort: hostname + ":" + strconv.Itoa(basePort+2*i),
+			peerHostnamePort:   hostname + ":" + strconv.Itoa(basePort+2*i+1),
+		}
+	}
+
+	for i := range nodes {
+		for j := range nodes {
+			if i != j {
+				nodes[i].otherServers = append(nodes[i].otherServers, nodes[j].peerHostnamePort)
+			}
+		}
+	}
+
+	return nodes
+}
+
+func generateSecret() string {
+	secret := make([]byte, secretLength)
+
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal("Unable to generate secret: ", err)
+	}
+
+	return hex.EncodeToString(secret)
+}
+
+// args returns the command line arguments to start the server.
+func (n node) args(secret string) []string {
+	args := []string{
+		"-server", n.serverHostnamePort,
+		"-peer", n.peerHostnamePort,
+		"-others", strings.Join(n.otherServers, ","),
+	}
+
+	if secret != "" {
+		args = append(args, "-auth", secret)
+	}
+
+	return args
+}
+
+// writeStartScript writes a shell script that starts every server, readable only by the
+// current user since it contains the secret.
+func writeStartScript(outputDir string, nodes []node, secret string) (string, error) {
+	if err := os.MkdirAll(outputDir, 0o700); err != nil {
+		return "", fmt.Errorf("error creating directory: %w", err)
+	}
+
+	var script strings.Builder
+
+	script.WriteString("#!/bin/sh\n")
+	script.WriteString("# starts a cluster of " + strconv.Itoa(len(nodes)) + " servers, generated by cmd/bootstrap\n")
+	script.WriteString("SERVER=${SERVER:-./server}\n\n")
+
+	for _, n := range nodes {
+		script.WriteString("# " + n.id + "\n")
+		script.WriteString("\"$SERVER\" " + strings.Join(n.args(secret), " ") + " &\n\n")
+	}
+
+	script.WriteString("wait\n")
+
+	filename := filepath.Join(outputDir, "start.sh")
+
+	if err := os.WriteFile(filename, []byte(script.String()), 0o700); err != nil {
+		return "", fmt.Errorf("error writing script: %w", err)
+	}
+
+	return filename, nil
+}
+
+// launchNodes starts every server as a child process, waiting until they have all exited.
+func launchNodes(serverBinary string, nodes []node, secret string) {
+	var running sync.WaitGroup
+
+	for _, n := range nodes {
+		cmd := exec.Command(serverBinary, n.args(secret)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Start(); err != nil {
+			log.Fatalf("Unable to launch %s: %v", n.id, err)
+		}
+
+		log.Printf("Launched %s (pid %d) on %s", n.id, cmd.Process.Pid, n.serverHostnamePort)
+
+		running.Add(1)
+
+		go func(id string) {
+			defer running.Done()
+
+			if err := cmd.Wait(); err != nil {
+				log.Printf("%s exited: %v", id, err)
+			}
+		}(n.id)
+	}
+
+	running.Wait()
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"tcp/pkg/client"
+	"time"
+)
+
+const (
+	jsonFormat = "json"
+	csvFormat  = "csv"
+)
+
+var errInvalidFile = errors.New("invalid file")
+
+func main() {
+	mode := flag.String("mode", "dump",
+		"dump (write every key to the file) or load (put every key in the file to the servers)")
+	servers := flag.String("servers", "localhost:8000",
+		"Comma separated client addresses of the servers; when dumping a sharded cluster, list every server")
+	cluster := flag.Bool("cluster", false, "Whether the servers are a sharded cluster, when loading")
+	token := flag.String("token", "", "Token to authenticate with, if the servers require it")
+	timeout := flag.Duration("timeout", 5*time.Second, "How long each command waits for the server")
+
+	filename := flag.String("file", "-", "File to dump to, or load from, - being standard output or input")
+	format := flag.String("format", jsonFormat,
+		"Format of the file: json (an array of objects with key and value) or csv (key,value rows after a header)")
+
+	scanCount := flag.Int("scanCount", 1000, "Number of keys fetched by each scan, when dumping")
+	workers := flag.Int("workers", 8, "Number of concurrent connections putting keys, when loading")
+
+	flag.Parse()
+
+	if *format != jsonFormat && *format != csvFormat {
+		log.Fatal("Unknown format: ", *format)
+	}
+
+	options := client.Options{Timeout: *timeout, Token: *token, Retries: 3}
+	addresses := strings.Split(*servers, ",")
+	start := time.Now()
+
+	switch *mode {
+	case "dump":
+		count, err := dump(addresses, options, *scanCount, *filename, *format)
+		if err != nil {
+			log.Fatal("Unable to dump keys: ", err)
+		}
+
+		log.Printf("Dumped %d keys in %v", count, time.Since(start).Round(time.Millisecond))
+
+	case "load":
+		count, err := load(addresses, *cluster, options, max(*workers, 1), *filename, *format)
+		if err != nil {
+			log.Fatal("Unable to load keys: ", err)
+		}
+
+		log.Printf("Loaded %d keys in %v", count, time.Since(start).Round(time.Millisecond))
+
+	default:
+		log.Fatal("Unknown mode: ", *mode)
+	}
+}
+
+// entryWriter writes entries to a file in one of the formats.
+type entryWriter interface {
+	write(entry client.Entry) error
+	close() error
+}
+
+// dump scans every key of each server, writing them to the file, returning how many were written. Keys
+// held by more than one server, as replicas, are only written once.
+func dump(addresses []string, options client.Options, scanCount int, filename string, format string) (int, error) {
+	file := os.Stdout
+
+	if filename != "-" {
+		var err error
+
+		if file, err = os.Create(filename); err != nil {
+			return 0, fmt.Errorf("error creating file: %w", err)
+		}
+
+		defer file.Close()
+	}
+
+	buffered := bufio.NewWriter(file)
+	writer := newEntryWriter(buffered, format)
+	seen := make(map[string]struct{})
+	count := 0
+
+	for _, address := range addresses {
+		err := scanServer(address, options, scanCount, func(entry client.Entry) error {
+			if len(addresses) > 1 {
+				if _, found := seen[entry.Key]; found {
+					return nil
+				}
+
+				seen[entry.Key] = struct{}{}
+			}
+
+			count++
+
+			return writer.write(entry)
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+
+	if err := writer.close(); err != nil {
+		return count, err
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return count, fmt.Errorf("error writing file: %w", err)
+	}
+
+	return count, nil
+}
+
+// scanServer calls fn with every key the server holds.
+func scanServer(address string, options client.Options, scanCount int, fn func(entry client.Entry) error) error {
+	c, err := client.Dial(context.Background(), address, options)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", address, err)
+	}
+
+	defer c.Close()
+
+	if err := c.ScanAll(context.Background(), scanCount, fn); err != nil {
+		return fmt.Errorf("error scanning %s: %w", address, err)
+	}
+
+	return nil
+}
+
+func newEntryWriter(writer io.Writer, format string) entryWriter {
+	if format == csvFormat {
+		return &csvWriter{writer: csv.NewWriter(writer)}
+	}
+
+	return &jsonWriter{writer: writer}
+}
+
+// jsonWriter writes a JSON array of objects, one per line, so large dumps can be streamed.
+type jsonWriter struct {
+	writer  io.Writer
+	started bool
+}
+
+type jsonEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (w *jsonWriter) write(entry client.Entry) error {
+	encoded, err := json.Marshal(jsonEntry(entry))
+	if err != nil {
+		return fmt.Errorf("error encoding key: %w", err)
+	}
+
+	separator := ",\n"
+	if !w.started {
+		separator, w.started = "[\n", true
+	}
+
+	if _, err := io.WriteString(w.writer, separator+string(encoded)); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	return nil
+}
+
+func (w *jsonWriter) close() error {
+	end := "\n]\n"
+	if !w.started {
+		end = "[]\n"
+	}
+
+	if _, err := io.WriteString(w.writer, end); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	return nil
+}
+
+// csvWriter writes a key,value header, then a row per entry.
+type csvWriter struct {
+	writer  *csv.Writer
+	started bool
+}
+
+func (w *csvWriter) write(entry client.Entry) error {
+	if !w.started {
+		w.started = true
+
+		if err := w.writer.Write([]string{"key", "value"}); err != nil {
+			return fmt.Errorf("error writing file: %w", err)
+		}
+	}
+
+	if err := w.writer.Write([]string{entry.Key, entry.Value}); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	return nil
+}
+
+func (w *csvWriter) close() error {
+	w.writer.Flush()
+
+	if err := w.writer.Error(); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	return nil
+}
+
+// load puts every key in the file, from concurrent connections, returning how many were put.
+func load(addresses []string, cluster bool, options client.Options, workers int, filename string,
+	format string,
+) (int, error) {
+	file := os.Stdin
+
+	if filename != "-" {
+		var err error
+
+		if file, err = os.Open(filename); err != nil {
+			return 0, fmt.Errorf("error opening file: %w", err)
+		}
+
+		defer file.Close()
+	}
+
+	entries := make(chan client.Entry, workers)
+	errs := make([]error, workers)
+	counts := make([]int, workers)
+
+	// reading stops when a worker fails
+	ctx, cancel := context.WithCancel(client.Idempotent(context.Background()))
+	defer cancel()
+
+	var group sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		group.Add(1)
+
+		go func(i int) {
+			defer group.Done()
+
+			counts[i], errs[i] = put(ctx, addresses, cluster, options, entries)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+
+	readErr := readEntries(ctx, bufio.NewReader(file), format, entries)
+	close(entries)
+
+	group.Wait()
+
+	total := 0
+
+	for _, count := range counts {
+		total += count
+	}
+
+	// if a worker failed, reading was stopped because of it
+	if err := errors.Join(errs...); err != nil {
+		return total, err
+	}
+
+	return total, readErr
+}
+
+// put puts each entry received, until the channel is closed, returning how many were put.
+func put(ctx context.Context, addresses []string, cluster bool, options client.Options,
+	entries <-chan client.Entry,
+) (int, error) {
+	var (
+		kv  client.KV
+		err error
+	)
+
+	if cluster {
+		kv, err = client.DialCluster(ctx, addresses, options)
+	} else {
+		kv, err = client.Dial(ctx, addresses[0], options)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error connecting: %w", err)
+	}
+
+	defer kv.Close()
+
+	count := 0
+
+	for entry := range entries {
+		if err := kv.Put(ctx, entry.Key, entry.Value); err != nil {
+			return count, fmt.Errorf("error putting %s: %w", entry.Key, err)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// readEntries sends each entry in the file to the channel, until the end of the file or the context
+// is done.
+func readEntries(ctx context.Context, reader io.Reader, format string, entries chan<- client.Entry) error {
+	send := func(entry client.Entry) error {
+		select {
+		case entries <- entry:
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("loading stopped: %w", ctx.Err())
+		}
+	}
+
+	if format == csvFormat {
+		return readCSV(reader, send)
+	}
+
+	return readJSON(reader, send)
+}
+
+// readJSON calls send with each object of a JSON array, decoding one at a time.
+func readJSON(reader io.Reader, send func(entry client.Entry) error) error {
+	decoder := json.NewDecoder(reader)
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return fmt.Errorf("%w: expected a JSON array", errInvalidFile)
+	}
+
+	for decoder.More() {
+		var entry jsonEntry
+
+		if err := decoder.Decode(&entry); err != nil {
+			return fmt.Errorf("%w: %w", errInvalidFile, err)
+		}
+
+		if err := send(client.Entry(entry)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readCSV calls send with each row after the header.
+func readCSV(reader io.Reader, send func(entry client.Entry) error) error {
+	rows := csv.NewReader(reader)
+	rows.FieldsPerRecord = 2
+
+	if _, err := rows.Read(); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %w", errInvalidFile, err)
+	}
+
+	for {
+		row, err := rows.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: %w", errInvalidFile, err)
+		}
+
+		if err := send(client.Entry{Key: row[0], Value: row[1]}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"tcp/pkg/backoff"
+	"tcp/pkg/client"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/server"
+	"time"
+)
+
+const (
+	// how long a killed server stays down, and a peer link stays slow, at most
+	chaosMaxDowntime = 2 * time.Second
+
+	// how often the servers are checked for convergence once the chaos stops
+	convergeInterval = 500 * time.Millisecond
+)
+
+// chaosSettings describes a chaos run: how long faults are injected for, how often, how many clients
+// write meanwhile, and how long the servers then have to agree.
+type chaosSettings struct {
+	duration       time.Duration
+	interval       time.Duration
+	clients        int
+	seed           int64
+	convergeWithin time.Duration
+	maxLatency     time.Duration
+}
+
+// chaosCluster is the 3 servers, each replicating to the others through a proxy per link, so links can be
+// dropped and delayed, and servers killed and restarted.
+type chaosCluster struct {
+	mutex   sync.Mutex
+	servers []*server.Server
+
+	// proxies[i][j] forwards server i's peer connections to server j
+	proxies [][]*chaosProxy
+}
+
+// chaosWriter is the keys written by a client: its own key, whose value is the number of each write, and
+// the latest write acknowledged and attempted, since a failed write may still have been applied.
+type chaosWriter struct {
+	key       string
+	acked     int
+	attempted int
+	errors    map[string]int
+}
+
+// runChaos starts the 3 servers, then while clients write to them, repeatedly kills and restarts servers,
+// and drops and delays the links between them. Once the faults stop, checks every server converges on
+// the same value for each key, no older than the last write acknowledged.
+func runChaos(settings chaosSettings) {
+	random := rand.New(rand.NewSource(settings.seed))
+	cluster := startChaosCluster()
+
+	defer cluster.shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), settings.duration)
+	defer cancel()
+
+	writers := make([]*chaosWriter, settings.clients)
+
+	var group sync.WaitGroup
+
+	for i := range writers {
+		writers[i] = &chaosWriter{key: "chaos" + strconv.Itoa(i), errors: make(map[string]int)}
+
+		group.Add(1)
+
+		go func(w *chaosWriter, address string) {
+			defer group.Done()
+
+			w.write(ctx, address)
+		}(writers[i], []string{server1, server2, server3}[i%3])
+	}
+
+	log.Printf("Injecting faults every %v for %v with %d clients, seed %d", settings.interval, settings.duration,
+		settings.clients, settings.seed)
+
+	faults := cluster.injectFaults(ctx, random, settings)
+
+	group.Wait()
+	cluster.heal()
+
+	log.Print("Faults injected:\n", summariseCounts(faults))
+	log.Print("Client errors:\n", summariseErrors(writers))
+
+	start := time.Now()
+
+	if err := cluster.converge(writers, settings.convergeWithin); err != nil {
+		log.Fatal("Servers didn't converge: ", err)
+	}
+
+	log.Printf("Chaos test passed, servers converged in %v", time.Since(start).Round(time.Millisecond))
+}
+
+// startChaosCluster starts the 3 servers, and the proxies between them.
+func startChaosCluster() *chaosCluster {
+	peers := []string{peer1, peer2, peer3}
+	cluster := &chaosCluster{proxies: make([][]*chaosProxy, len(peers))}
+
+	for i := range peers {
+		cluster.proxies[i] = make([]*chaosProxy, len(peers))
+
+		for j := range peers {
+			if i != j {
+				cluster.proxies[i][j] = startChaosProxy(peers[j])
+			}
+		}
+	}
+
+	for i := range peers {
+		cluster.servers = append(cluster.servers, cluster.start(i, false))
+	}
+
+	return cluster
+}
+
+// start starts server i, replicating to the others through its proxies. A restarted server fetches the
+// keys from the others first, since it lost its keys when killed.
+func (c *chaosCluster) start(i int, restart bool) *server.Server {
+	serverPorts := []string{server1, server2, server3}
+	peerPorts := []string{peer1, peer2, peer3}
+	otherServers := make([]string, 0, len(peerPorts)-1)
+
+	for j, proxy := range c.proxies[i] {
+		if j != i {
+			otherServers = append(otherServers, proxy.address())
+		}
+	}
+
+	srv := server.NewServer(kvstore.NewKVStore(), server.Config{
+		ServerHostnamePort:  serverPorts[i],
+		PeerHostnamePort:    peerPorts[i],
+		OtherServers:        otherServers,
+		NodeID:              peerPorts[i],
+		LastWriteWins:       true,
+		AntiEntropyInterval: time.Second,
+		SyncOnStart:         restart,
+
+		// the faults are expected, so only errors are logged
+		Logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+	})
+
+	if err := srv.Listen(); err != nil {
+		log.Fatal("Unable to start server: ", err)
+	}
+
+	go func() {
+		if err := srv.Serve(); !errors.Is(err, server.ErrServerClosed) {
+			log.Fatal("Server failed: ", err)
+		}
+	}()
+
+	return srv
+}
+
+// injectFaults injects a random fault every interval until the context is done, returning how many of
+// each kind were injected.
+func (c *chaosCluster) injectFaults(ctx context.Context, random *rand.Rand, settings chaosSettings) map[string]int {
+	faults := make(map[string]int)
+	ticker := time.NewTicker(settings.interval)
+
+	defer ticker.Stop()
+
+	var recovering sync.WaitGroup
+
+	defer recovering.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return faults
+		case <-ticker.C:
+		}
+
+		i := random.Intn(len(c.servers))
+		j := (i + 1 + random.Intn(len(c.servers)-1)) % len(c.servers)
+		downtime := time.Duration(random.Int63n(int64(chaosMaxDowntime)))
+
+		switch random.Intn(3) {
+		case 0:
+			if c.kill(i) {
+				faults["kill"]++
+
+				recovering.Add(1)
+
+				time.AfterFunc(downtime, func() {
+					defer recovering.Done()
+					c.restart(i)
+				})
+			}
+
+		case 1:
+			faults["drop"]++
+
+			c.proxies[i][j].drop()
+
+		default:
+			faults["delay"]++
+
+			c.proxies[i][j].setLatency(time.Duration(random.Int63n(int64(settings.maxLatency))))
+
+			recovering.Add(1)
+
+			time.AfterFunc(downtime, func() {
+				defer recovering.Done()
+				c.proxies[i][j].setLatency(0)
+			})
+		}
+	}
+}
+
+// kill shuts down server i, returning false if it is already down.
+func (c *chaosCluster) kill(i int) bool {
+	c.mutex.Lock()
+	srv := c.servers[i]
+	c.servers[i] = nil
+	c.mutex.Unlock()
+
+	if srv == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+
+	_ = srv.Shutdown(ctx)
+
+	return true
+}
+
+// restart starts server i again, if it is down.
+func (c *chaosCluster) restart(i int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.servers[i] == nil {
+		c.servers[i] = c.start(i, true)
+	}
+}
+
+// heal restarts any server that is down, and removes the latency from every link.
+func (c *chaosCluster) heal() {
+	for i := range c.servers {
+		c.restart(i)
+
+		for _, proxy := range c.proxies[i] {
+			if proxy != nil {
+				proxy.setLatency(0)
+			}
+		}
+	}
+}
+
+// converge waits for every server to have the same value for each writer's key, returning an error
+// describing a key they still disagree on, or whose value is older than its last acknowledged write,
+// if they haven't within the time allowed.
+func (c *chaosCluster) converge(writers []*chaosWriter, within time.Duration) error {
+	readers := make([]*client.Client, 0, len(c.servers))
+
+	for _, address := range []string{server1, server2, server3} {
+		reader, err := client.Dial(context.Background(), address, client.Options{Retries: 3})
+		if err != nil {
+			return fmt.Errorf("unable to connect to %s: %w", address, err)
+		}
+
+		defer reader.Close()
+
+		readers = append(readers, reader)
+	}
+
+	deadline := time.Now().Add(within)
+
+	for {
+		err := checkConverged(readers, writers)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+
+		time.Sleep(convergeInterval)
+	}
+}
+
+// checkConverged returns an error describing the first key the servers disagree on, or whose value is
+// older than its last acknowledged write.
+func checkConverged(readers []*client.Client, writers []*chaosWriter) error {
+	for _, w := range writers {
+		values := make([]string, 0, len(readers))
+
+		for _, reader := range readers {
+			value, _, err := reader.Get(context.Background(), w.key)
+			if err != nil {
+				return fmt.Errorf("unable to read %s: %w", w.key, err)
+			}
+
+			values = append(values, value)
+		}
+
+		for _, value := range values[1:] {
+			if value != values[0] {
+				return fmt.Errorf("servers disagree on %s: %s", w.key, strings.Join(values, ", "))
+			}
+		}
+
+		if w.attempted == 0 {
+			continue
+		}
+
+		if written, err := strconv.Atoi(values[0]); err != nil || written < w.acked || written > w.attempted {
+			return fmt.Errorf("%s is %q, but write %d was acknowledged and %d attempted", w.key, values[0],
+				w.acked, w.attempted)
+		}
+	}
+
+	return nil
+}
+
+func (c *chaosCluster) shutdown() {
+	c.mutex.Lock()
+	servers := make([]*server.Server, 0, len(c.servers))
+
+	for _, srv := range c.servers {
+		if srv != nil {
+			servers = append(servers, srv)
+		}
+	}
+
+	c.mutex.Unlock()
+
+	shutdownServers(servers)
+
+	for _, proxies := range c.proxies {
+		for _, proxy := range proxies {
+			if proxy != nil {
+				proxy.close()
+			}
+		}
+	}
+}
+
+// write writes increasing values to the writer's key, until the context is done, recording the writes
+// acknowledged and the kinds of error.
+func (w *chaosWriter) write(ctx context.Context, address string) {
+	options := client.Options{
+		Timeout: time.Second,
+		Retries: 5,
+		Backoff: backoff.Policy{Initial: 50 * time.Millisecond, Max: 500 * time.Millisecond},
+	}
+
+	var kv *client.Client
+
+	for ctx.Err() == nil {
+		if kv == nil {
+			var err error
+
+			if kv, err = client.Dial(ctx, address, options); err != nil {
+				w.failed(ctx, err)
+				time.Sleep(options.Backoff.Initial)
+
+				continue
+			}
+		}
+
+		w.attempted++
+
+		err := kv.Put(client.Idempotent(ctx), w.key, strconv.Itoa(w.attempted))
+		if err == nil || errors.Is(err, client.ErrNotReplicated) {
+			w.acked = w.attempted
+			continue
+		}
+
+		w.failed(ctx, err)
+	}
+
+	if kv != nil {
+		_ = kv.Close()
+	}
+}
+
+// failed records the kind of error, unless the run ended.
+func (w *chaosWriter) failed(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	var serverError *client.Error
+
+	switch {
+	case errors.As(err, &serverError):
+		w.errors["server error "+serverError.Code+" "+serverError.Reason]++
+	case errors.Is(err, context.DeadlineExceeded):
+		w.errors["timeout"]++
+	default:
+		w.errors["connection"]++
+	}
+}
+
+// summariseErrors returns how many of each kind of error the clients had, one kind per line.
+func summariseErrors(writers []*chaosWriter) string {
+	errorCounts := make(map[string]int)
+	writes := 0
+
+	for _, w := range writers {
+		writes += w.attempted
+
+		for kind, count := range w.errors {
+			errorCounts[kind] += count
+		}
+	}
+
+	return fmt.Sprintf("writes attempted: %d\n", writes) + summariseCounts(errorCounts)
+}
+
+// summariseCounts returns each name and its count, one per line, in name order.
+func summariseCounts(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+
+	for name := range counts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var summary strings.Builder
+
+	for _, name := range names {
+		fmt.Fprintf(&summary, "%s: %d\n", name, counts[name])
+	}
+
+	return summary.String()
+}
+
+// chaosProxy forwards connections to the target, with latency that can be changed, and can drop every
+// connection open through it.
+type chaosProxy struct {
+	listener net.Listener
+	target   string
+
+	mutex   sync.Mutex
+	latency time.Duration
+	conns   map[net.Conn]struct{}
+}
+
+// startChaosProxy listens on an ephemeral port, forwarding connections to the target.
+func startChaosProxy(target string) *chaosProxy {
+	listener, err := net.Listen("tcp4", "localhost:0")
+	if err != nil {
+		log.Fatal("Unable to start chaos proxy: ", err)
+	}
+
+	proxy := &chaosProxy{listener: listener, target: target, conns: make(map[net.Conn]struct{})}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go proxy.forward(conn)
+		}
+	}()
+
+	return proxy
+}
+
+func (p *chaosProxy) address() string {
+	return p.listener.Addr().String()
+}
+
+func (p *chaosProxy) forward(conn net.Conn) {
+	targetConn, err := net.Dial("tcp4", p.target)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	p.mutex.Lock()
+	p.conns[conn] = struct{}{}
+	p.conns[targetConn] = struct{}{}
+	p.mutex.Unlock()
+
+	defer func() {
+		p.mutex.Lock()
+		delete(p.conns, conn)
+		delete(p.conns, targetConn)
+		p.mutex.Unlock()
+	}()
+
+	go p.copy(targetConn, conn)
+
+	p.copy(conn, targetConn)
+}
+
+// copy copies from src to dst, delaying each chunk read by the current latency, until either side is
+// closed.
+func (p *chaosProxy) copy(dst net.Conn, src net.Conn) {
+	defer func() {
+		_ = dst.Close()
+		_ = src.Close()
+	}()
+
+	buffer := make([]byte, 4096)
+
+	for {
+		numRead, err := src.Read(buffer)
+		if numRead > 0 {
+			p.mutex.Lock()
+			latency := p.latency
+			p.mutex.Unlock()
+
+			time.Sleep(latency)
+
+			if _, writeErr := dst.Write(buffer[:numRead]); writeErr != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *chaosProxy) setLatency(latency time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.latency = latency
+}
+
+// drop closes every connection open through the proxy.
+func (p *chaosProxy) drop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+}
+
+func (p *chaosProxy) close() {
+	_ = p.listener.Close()
+	p.drop()
+}
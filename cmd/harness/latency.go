@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"tcp/pkg/server"
+	"time"
+)
+
+// latencyProfile describes the simulated network between the servers: the region of each
+// server, and the one-way latency added to peer traffic within and across regions.
+type latencyProfile struct {
+	regions     []string
+	intraRegion time.Duration
+	crossRegion time.Duration
+	operations  int
+}
+
+// latency returns the one-way latency between servers i and j.
+func (p latencyProfile) latency(i int, j int) time.Duration {
+	if p.regions[i] == p.regions[j] {
+		return p.intraRegion
+	}
+
+	return p.crossRegion
+}
+
+// runLatencyProfile starts the 3 servers with latency injected between each pair of peers, then
+// times a series of writes and reads against each server. Writes are replicated to every peer
+// before being acknowledged, whereas reads are served locally.
+func runLatencyProfile(profile latencyProfile) {
+	serverPorts := []string{server1, server2, server3}
+	peerPorts := []string{peer1, peer2, peer3}
+
+	if len(profile.regions) != len(serverPorts) {
+		log.Fatalf("Expected a region for each of the %d servers, but got %d", len(serverPorts), len(profile.regions))
+	}
+
+	servers := make([]*server.Server, 0, len(serverPorts))
+
+	for i := range serverPorts {
+		otherServers := make([]string, 0, len(peerPorts)-1)
+
+		for j := range peerPorts {
+			if i != j {
+				otherServers = append(otherServers, startDelayProxy(peerPorts[j], profile.latency(i, j)))
+			}
+		}
+
+		servers = append(servers, startServer(serverPorts[i], peerPorts[i], otherServers))
+	}
+
+	defer shutdownServers(servers)
+
+	var report strings.Builder
+
+	for i, serverPort := range serverPorts {
+		client := openClientConn(log.Default(), serverPort)
+
+		writes, reads := timeOperations(client, "node"+strconv.Itoa(i+1), profile.operations)
+
+		_ = client.Close()
+
+		fmt.Fprintf(&report, "%s (%s): write (all peers) %s, read (local) %s\n",
+			serverPort, profile.regions[i], summarise(writes), summarise(reads))
+	}
+
+	log.Print("Latency profile results:\n", report.String())
+}
+
+// timeOperations performs a number of puts then gets, returning how long each took.
+func timeOperations(client net.Conn, prefix string, operations int) ([]time.Duration, []time.Duration) {
+	writes := make([]time.Duration, 0, operations)
+	reads := make([]time.Duration, 0, operations)
+
+	for i := 0; i < operations; i++ {
+		key := formatArgument(prefix + "/" + strconv.Itoa(i))
+		writes = append(writes, timeRequest(client, "put"+key+formatArgument("value"), "ack"))
+	}
+
+	for i := 0; i < operations; i++ {
+		key := formatArgument(prefix + "/" + strconv.Itoa(i))
+		reads = append(reads, timeRequest(client, "get"+key+"0", "val"+formatArgument("value")))
+	}
+
+	return writes, reads
+}
+
+// timeRequest sends the request and waits for the expected response, returning how long it took.
+func timeRequest(client net.Conn, request string, expectedResponse string) time.Duration {
+	start := time.Now()
+
+	if _, err := client.Write([]byte(request)); err != nil {
+		log.Fatal("Error writing request: ", err)
+	}
+
+	buffer := make([]byte, len(expectedResponse))
+
+	if _, err := io.ReadFull(client, buffer); err != nil {
+		log.Fatal("Error reading response: ", err)
+	}
+
+	if string(buffer) != expectedResponse {
+		log.Fatalf("Expected response %s but got %s", expectedResponse, buffer)
+	}
+
+	return time.Since(start)
+}
+
+// summarise returns the median, 99th percentile and maximum of the durations.
+func summarise(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "no operations"
+	}
+
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+
+	return fmt.Sprintf("p50=%v p99=%v max=%v", percentile(50).Round(time.Microsecond),
+		percentile(99).Round(time.Microsecond), sorted[len(sorted)-1].Round(time.Microsecond))
+}
+
+// startDelayProxy listens on an ephemeral port, forwarding connections to the target after
+// delaying the data sent in each direction, returning the address to connect to.
+func startDelayProxy(target string, latency time.Duration) string {
+	listener, err := net.Listen("tcp4", "localhost:0")
+	if err != nil {
+		log.Fatal("Unable to start latency proxy: ", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go proxyWithDelay(conn, target, latency)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func proxyWithDelay(conn net.Conn, target string, latency time.Duration) {
+	targetConn, err := net.Dial("tcp4", target)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	go copyWithDelay(targetConn, conn, latency)
+
+	copyWithDelay(conn, targetConn, latency)
+}
+
+// copyWithDelay copies from src to dst, delaying each chunk read, until either side is closed.
+func copyWithDelay(dst net.Conn, src net.Conn, latency time.Duration) {
+	defer func() {
+		_ = dst.Close()
+		_ = src.Close()
+	}()
+
+	buffer := make([]byte, 4096)
+
+	for {
+		numRead, err := src.Read(buffer)
+		if numRead > 0 {
+			time.Sleep(latency)
+
+			if _, writeErr := dst.Write(buffer[:numRead]); writeErr != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// formatArgument outputs the specified string as a 3 part argument.
+func formatArgument(input string) string {
+	size := strconv.Itoa(len(input))
+
+	return strconv.Itoa(len(size)) + size + input
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"tcp/pkg/client"
+	"time"
+)
+
+// how many distinct keys the load is spread over, shared by the clients, so the same keys are written
+// through different servers
+const loadKeys = 1000
+
+var errInvalidRatio = errors.New("invalid ratio, expected reads:writes, e.g. 80:20")
+
+// loadSettings describes the load: how many clients, each sending how many operations, what fraction
+// of them are reads, and how long the servers have to agree once it finishes.
+type loadSettings struct {
+	clients   int
+	ops       int
+	readRatio float64
+	settle    time.Duration
+}
+
+// loadResults is the latency of every operation a client performed, how many failed, and the keys it wrote.
+type loadResults struct {
+	reads   []time.Duration
+	writes  []time.Duration
+	errors  int
+	written map[string]struct{}
+}
+
+// parseRatio returns the fraction of reads given a ratio of reads to writes, e.g. 80:20 is 0.8.
+func parseRatio(ratio string) (float64, error) {
+	reads, writes, found := strings.Cut(ratio, ":")
+	if !found {
+		return 0, fmt.Errorf("%w: %s", errInvalidRatio, ratio)
+	}
+
+	readWeight, err := strconv.ParseFloat(reads, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", errInvalidRatio, ratio)
+	}
+
+	writeWeight, err := strconv.ParseFloat(writes, 64)
+	if err != nil || readWeight < 0 || writeWeight < 0 || readWeight+writeWeight == 0 {
+		return 0, fmt.Errorf("%w: %s", errInvalidRatio, ratio)
+	}
+
+	return readWeight / (readWeight + writeWeight), nil
+}
+
+// runLoad sends the operations from every client concurrently, spread across the 3 servers, then reports
+// the throughput and latency, and whether the servers agree on the value of every key written.
+func runLoad(settings loadSettings) {
+	serverPorts := []string{server1, server2, server3}
+	all := make([]loadResults, settings.clients)
+
+	var group sync.WaitGroup
+
+	log.Printf("Sending %d operations from each of %d clients, %.0f%% reads", settings.ops, settings.clients,
+		settings.readRatio*100)
+
+	start := time.Now()
+
+	for i := range all {
+		group.Add(1)
+
+		go func(i int) {
+			defer group.Done()
+
+			all[i] = generateLoad(serverPorts[i%len(serverPorts)], settings, rand.New(rand.NewSource(int64(i))))
+		}(i)
+	}
+
+	group.Wait()
+
+	elapsed := time.Since(start)
+
+	var total loadResults
+
+	written := make(map[string]struct{})
+
+	for _, results := range all {
+		total.reads = append(total.reads, results.reads...)
+		total.writes = append(total.writes, results.writes...)
+		total.errors += results.errors
+
+		for key := range results.written {
+			written[key] = struct{}{}
+		}
+	}
+
+	operations := len(total.reads) + len(total.writes)
+
+	log.Printf("Load results:\n%d operations in %v, %.0f ops/sec, %d errors\nread: %s\nwrite: %s",
+		operations, elapsed.Round(time.Millisecond), float64(operations)/elapsed.Seconds(), total.errors,
+		summarise(total.reads), summarise(total.writes))
+
+	inconsistent, err := waitForConsistency(serverPorts, written, settings.settle)
+	if err != nil {
+		log.Fatal("Unable to check consistency: ", err)
+	}
+
+	log.Printf("Replication consistency: %d of %d keys written have the same value on every server",
+		len(written)-len(inconsistent), len(written))
+
+	for _, key := range inconsistent {
+		log.Print("Servers disagree on ", key)
+	}
+}
+
+// generateLoad sends the operations to the server, reading and writing random keys.
+func generateLoad(address string, settings loadSettings, random *rand.Rand) loadResults {
+	results := loadResults{written: make(map[string]struct{})}
+
+	c, err := client.Dial(context.Background(), address, client.Options{})
+	if err != nil {
+		log.Print("Unable to connect to server: ", err)
+
+		results.errors = settings.ops
+
+		return results
+	}
+
+	defer c.Close()
+
+	for i := 0; i < settings.ops; i++ {
+		key := "load" + strconv.Itoa(random.Intn(loadKeys))
+		read := random.Float64() < settings.readRatio
+		start := time.Now()
+
+		if read {
+			_, _, err = c.Get(context.Background(), key)
+		} else {
+			err = c.Put(context.Background(), key, strconv.Itoa(random.Int()))
+			results.written[key] = struct{}{}
+		}
+
+		elapsed := time.Since(start)
+
+		switch {
+		case err != nil:
+			results.errors++
+		case read:
+			results.reads = append(results.reads, elapsed)
+		default:
+			results.writes = append(results.writes, elapsed)
+		}
+	}
+
+	return results
+}
+
+// waitForConsistency waits for the servers to agree on the value of each key, for up to the settle time,
+// returning the keys they still disagree on, in key order.
+func waitForConsistency(addresses []string, keys map[string]struct{}, settle time.Duration) ([]string, error) {
+	readers := make([]*client.Client, 0, len(addresses))
+
+	for _, address := range addresses {
+		reader, err := client.Dial(context.Background(), address, client.Options{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to %s: %w", address, err)
+		}
+
+		defer reader.Close()
+
+		readers = append(readers, reader)
+	}
+
+	deadline := time.Now().Add(settle)
+
+	for {
+		inconsistent, err := inconsistentKeys(readers, keys)
+		if err != nil || len(inconsistent) == 0 || time.Now().After(deadline) {
+			return inconsistent, err
+		}
+
+		time.Sleep(convergeInterval)
+	}
+}
+
+// inconsistentKeys returns the keys whose value differs between the servers, in key order.
+func inconsistentKeys(readers []*client.Client, keys map[string]struct{}) ([]string, error) {
+	var inconsistent []string
+
+	for i := 0; i < loadKeys; i++ {
+		key := "load" + strconv.Itoa(i)
+		if _, found := keys[key]; !found {
+			continue
+		}
+
+		var first string
+
+		for j, reader := range readers {
+			value, _, err := reader.Get(context.Background(), key)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read %s: %w", key, err)
+			}
+
+			if j == 0 {
+				first = value
+			} else if value != first {
+				inconsistent = append(inconsistent, key)
+				break
+			}
+		}
+	}
+
+	return inconsistent, nil
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"strings"
 	"tcp/pkg/kvstore"
 	"tcp/pkg/server"
 	"time"
@@ -22,59 +25,160 @@ const (
 	server3 = "localhost:8004"
 	peer3   = "localhost:8005"
 
-	serverStartupDelay = 200 * time.Millisecond
+	serverShutdownTimeout = 2 * time.Second
+
+	// how long the harness waits for each response
+	responseTimeout = 5 * time.Second
 )
 
 func main() {
-	client1Logger := log.New(os.Stdout, "client1 ", log.Ldate|log.Ltime|log.Lshortfile)
-	client2Logger := log.New(os.Stdout, "client2 ", log.Ldate|log.Ltime|log.Lshortfile)
-	client3Logger := log.New(os.Stdout, "client3 ", log.Ldate|log.Ltime|log.Lshortfile)
-
 	log.Println("Starting test harness...")
 
 	startServers := flag.String("startServers", "n", "whether to start the servers directly")
 
+	scenarioFilename := flag.String("scenario", "",
+		"File of scripted steps to run instead of the built-in functional test, each line being the server "+
+			"to send to (server1, server2 or server3), the request, and the expected response")
+
+	regions := flag.String("latencyProfile", "",
+		"Comma-separated region of each server, e.g. eu,eu,us, to start the servers with latency injected "+
+			"between peers and report write and read latencies (instead of the functional test)")
+
+	intraRegionLatency := flag.Duration("intraRegionLatency", time.Millisecond,
+		"One-way latency between peers in the same region, for the latency profile")
+
+	crossRegionLatency := flag.Duration("crossRegionLatency", 80*time.Millisecond,
+		"One-way latency between peers in different regions, for the latency profile")
+
+	latencyOperations := flag.Int("latencyOps", 100,
+		"Number of writes and reads sent to each server, for the latency profile")
+
+	chaosDuration := flag.Duration("chaos", 0,
+		"If not zero, how long to kill and restart the servers, and drop and delay the links between them, "+
+			"while clients write, then check the servers converge (instead of the functional test)")
+
+	chaosInterval := flag.Duration("chaosInterval", 500*time.Millisecond, "How often a fault is injected")
+
+	chaosClients := flag.Int("chaosClients", 10, "Number of clients writing while faults are injected")
+
+	chaosSeed := flag.Int64("chaosSeed", time.Now().UnixNano(),
+		"Seed choosing the faults injected, to repeat a chaos run")
+
+	chaosConverge := flag.Duration("chaosConverge", 30*time.Second,
+		"How long the servers have to converge once the faults stop")
+
+	chaosLatency := flag.Duration("chaosLatency", 200*time.Millisecond,
+		"Most one-way latency added to a link between servers by a delay fault")
+
+	loadClients := flag.Int("clients", 0,
+		"If not zero, how many clients send operations concurrently to the 3 servers, then report throughput, "+
+			"latency and replication consistency (instead of the functional test)")
+
+	loadOps := flag.Int("ops", 1000, "Number of operations each client sends, for the load")
+
+	loadRatio := flag.String("ratio", "80:20", "Ratio of reads to writes, for the load")
+
+	loadSettle := flag.Duration("settle", 5*time.Second,
+		"How long the servers have to agree on every key once the load finishes")
+
 	flag.Parse()
 
-	if *startServers == "y" {
-		// start 3 servers
-		go server.StartServer(kvstore.NewKVStore(), server1, peer1, []string{peer2, peer3})
-		go server.StartServer(kvstore.NewKVStore(), server2, peer2, []string{peer1, peer3})
-		go server.StartServer(kvstore.NewKVStore(), server3, peer3, []string{peer1, peer2})
+	if *chaosDuration > 0 {
+		runChaos(chaosSettings{
+			duration:       *chaosDuration,
+			interval:       *chaosInterval,
+			clients:        max(*chaosClients, 1),
+			seed:           *chaosSeed,
+			convergeWithin: *chaosConverge,
+			maxLatency:     max(*chaosLatency, time.Millisecond),
+		})
+
+		return
+	}
+
+	if *regions != "" {
+		runLatencyProfile(latencyProfile{
+			regions:     strings.Split(*regions, ","),
+			intraRegion: *intraRegionLatency,
+			crossRegion: *crossRegionLatency,
+			operations:  *latencyOperations,
+		})
 
-		// wait for servers to start up
-		time.Sleep(serverStartupDelay)
+		return
 	}
 
-	// create 3 clients
-	client1 := openClientConn(client1Logger, server1)
-	client2 := openClientConn(client2Logger, server2)
-	client3 := openClientConn(client3Logger, server3)
+	steps, err := loadScenario(*scenarioFilename)
+	if err != nil {
+		log.Fatal("Invalid scenario: ", err)
+	}
 
-	defer func() {
-		_ = client1.Close()
-		_ = client2.Close()
-		_ = client3.Close()
-	}()
+	// exiting once the servers are shut down
+	os.Exit(withServers(*startServers == "y", func() int {
+		if *loadClients > 0 {
+			readRatio, err := parseRatio(*loadRatio)
+			if err != nil {
+				log.Fatal("Invalid load: ", err)
+			}
+
+			runLoad(loadSettings{
+				clients:   *loadClients,
+				ops:       max(*loadOps, 1),
+				readRatio: readRatio,
+				settle:    *loadSettle,
+			})
+
+			return 0
+		}
 
-	// send some test requests, check the responses
-	checkRequestResponse(client1Logger, client1, "get11a0", "nil") // get key not present
-	checkRequestResponse(client2Logger, client2, "get11a0", "nil") // get key not present
-	checkRequestResponse(client3Logger, client3, "get11a0", "nil") // get key not present
+		return runScenario(steps)
+	}))
+}
 
-	checkRequestResponse(client1Logger, client1, "put12bb13999", "ack")  // put key to server 1
-	checkRequestResponse(client1Logger, client1, "get12bb0", "val13999") // get key just written
-	checkRequestResponse(client2Logger, client2, "get12bb0", "val13999") // get replicated key
-	checkRequestResponse(client3Logger, client3, "get12bb0", "val13999") // get replicated key
+// withServers starts the 3 servers if required, calls fn, then shuts the servers down, returning fn's
+// exit code.
+func withServers(start bool, fn func() int) int {
+	if start {
+		servers := []*server.Server{
+			startServer(server1, peer1, []string{peer2, peer3}),
+			startServer(server2, peer2, []string{peer1, peer3}),
+			startServer(server3, peer3, []string{peer1, peer2}),
+		}
 
-	checkRequestResponse(client2Logger, client2, "del12bb", "ack")  // delete the key using server 2
-	checkRequestResponse(client2Logger, client2, "get12bb0", "nil") // get key, now not present
-	checkRequestResponse(client1Logger, client1, "get12bb0", "nil") // delete replicated
-	checkRequestResponse(client3Logger, client3, "get12bb0", "nil") // delete replicated
+		defer shutdownServers(servers)
+	}
+
+	return fn()
+}
+
+func startServer(serverHostnamePort string, peerHostnamePort string, otherServers []string) *server.Server {
+	srv := server.NewServer(kvstore.NewKVStore(), server.Config{
+		ServerHostnamePort: serverHostnamePort,
+		PeerHostnamePort:   peerHostnamePort,
+		OtherServers:       otherServers,
+	})
+
+	if err := srv.Listen(); err != nil {
+		log.Fatal("Unable to start server: ", err)
+	}
 
-	checkRequestResponse(client1Logger, client1, "bye", "") // shutdown
+	go func() {
+		if err := srv.Serve(); !errors.Is(err, server.ErrServerClosed) {
+			log.Fatal("Server failed: ", err)
+		}
+	}()
+
+	return srv
+}
+
+func shutdownServers(servers []*server.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
 
-	log.Println("Test harness completed, all passed!")
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Print("Unable to shut down server cleanly: ", err)
+		}
+	}
 }
 
 func openClientConn(logger *log.Logger, hostnamePort string) net.Conn {
@@ -86,39 +190,33 @@ func openClientConn(logger *log.Logger, hostnamePort string) net.Conn {
 	return clientConn
 }
 
-func checkRequestResponse(logger *log.Logger, client net.Conn, request string, expectedResponse string) {
+// checkRequestResponse sends the request and reads the response, returning an error if it isn't the
+// expected response, or the server doesn't respond in time.
+func checkRequestResponse(logger *log.Logger, client net.Conn, request string, expectedResponse string) error {
 	logger.Print("sent ", request)
 
-	numWritten, err := client.Write([]byte(request))
-	if err != nil {
-		logger.Fatal("Error writing request: ", err)
+	if err := client.SetDeadline(time.Now().Add(responseTimeout)); err != nil {
+		return fmt.Errorf("error setting deadline: %w", err)
 	}
 
-	if numWritten != len(request) {
-		logger.Printf("Expecting to write %d characters, but only wrote %d", len(request), numWritten)
+	if _, err := client.Write([]byte(request)); err != nil {
+		return fmt.Errorf("error writing request: %w", err)
 	}
 
 	buffer := make([]byte, len(expectedResponse))
 
-	numRead, err := client.Read(buffer)
-	if err != nil {
-		if errors.Is(err, io.EOF) {
-			logger.Print("Server closed connection")
-			return
-		}
-
-		logger.Fatal("Error reading response: ", err)
-	}
-
-	if numRead != len(expectedResponse) {
-		logger.Printf("Expecting to read %d characters, but only read %d", len(expectedResponse), numRead)
-	}
-
+	numRead, err := io.ReadFull(client, buffer)
 	actualResponse := string(buffer[:numRead])
 
 	logger.Print("received ", actualResponse)
 
+	if err != nil && !(errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return fmt.Errorf("error reading response to %s, after %q: %w", request, actualResponse, err)
+	}
+
 	if actualResponse != expectedResponse {
-		logger.Printf("Expected response %s but got %s", expectedResponse, actualResponse)
+		return fmt.Errorf("expected response %s to %s but got %s", expectedResponse, request, actualResponse)
 	}
+
+	return nil
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultScenario is the built-in functional test, run when no scenario file is given.
+const defaultScenario = `# get key not present
+server1 get11a0 nil
+server2 get11a0 nil
+server3 get11a0 nil
+
+# put key to server 1, get it, then get it from the servers it was replicated to
+server1 put12bb13999 ack
+server1 get12bb0 val13999
+server2 get12bb0 val13999
+server3 get12bb0 val13999
+
+# delete the key using server 2, then check the delete was replicated
+server2 del12bb ack
+server2 get12bb0 nil
+server1 get12bb0 nil
+server3 get12bb0 nil
+
+# shutdown
+server1 bye ""
+`
+
+var errInvalidStep = errors.New("invalid step")
+
+// step is a request sent to one of the servers, and the response it should get.
+type step struct {
+	line     int
+	server   string
+	request  string
+	expected string
+}
+
+// loadScenario reads the steps from the file, or the built-in functional test if there's no file.
+func loadScenario(filename string) ([]step, error) {
+	if filename == "" {
+		return parseScenario(strings.NewReader(defaultScenario))
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening scenario: %w", err)
+	}
+
+	defer file.Close()
+
+	return parseScenario(file)
+}
+
+// parseScenario parses a step from each line: the server to send to (server1, server2 or server3), the
+// request, and the expected response, separated by spaces. Any of them can be a double-quoted Go string,
+// e.g. to include spaces, or to expect no response (""). Blank lines and lines starting with # are ignored.
+func parseScenario(reader io.Reader) ([]step, error) {
+	var steps []step
+
+	scanner := bufio.NewScanner(reader)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields, err := splitFields(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: %w: expected server, request and response", line, errInvalidStep)
+		}
+
+		if serverAddress(fields[0]) == "" {
+			return nil, fmt.Errorf("line %d: %w: unknown server %s", line, errInvalidStep, fields[0])
+		}
+
+		steps = append(steps, step{line, fields[0], fields[1], fields[2]})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading scenario: %w", err)
+	}
+
+	return steps, nil
+}
+
+// splitFields splits the text at spaces, except within double-quoted strings, which are unquoted.
+func splitFields(text string) ([]string, error) {
+	var fields []string
+
+	for text != "" {
+		if text[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(text)
+			if err != nil {
+				return nil, fmt.Errorf("%w: unterminated string %s", errInvalidStep, text)
+			}
+
+			field, _ := strconv.Unquote(quoted)
+			fields = append(fields, field)
+			text = text[len(quoted):]
+		} else {
+			end := strings.IndexFunc(text, unicode.IsSpace)
+			if end < 0 {
+				end = len(text)
+			}
+
+			fields = append(fields, text[:end])
+			text = text[end:]
+		}
+
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	}
+
+	return fields, nil
+}
+
+// serverAddress returns the client address of the named server, or empty if unknown.
+func serverAddress(name string) string {
+	switch name {
+	case "server1":
+		return server1
+	case "server2":
+		return server2
+	case "server3":
+		return server3
+	default:
+		return ""
+	}
+}
+
+// runScenario runs each step in turn, over a connection per server, continuing after steps fail, then
+// logs a summary, returning the exit code: 1 if any step failed.
+func runScenario(steps []step) int {
+	conns := make(map[string]net.Conn)
+	loggers := make(map[string]*log.Logger)
+
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	var failures []string
+
+	for _, s := range steps {
+		logger := loggers[s.server]
+		if logger == nil {
+			logger = log.New(os.Stdout, s.server+" ", log.Ldate|log.Ltime)
+			loggers[s.server] = logger
+		}
+
+		if conns[s.server] == nil {
+			conns[s.server] = openClientConn(logger, serverAddress(s.server))
+		}
+
+		if err := checkRequestResponse(logger, conns[s.server], s.request, s.expected); err != nil {
+			logger.Print(err)
+			failures = append(failures, fmt.Sprintf("line %d: %s: %v", s.line, s.server, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		log.Printf("Test harness completed, %d of %d steps failed:\n%s", len(failures), len(steps),
+			strings.Join(failures, "\n"))
+
+		return 1
+	}
+
+	log.Printf("Test harness completed, all %d steps passed!", len(steps))
+
+	return 0
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"tcp/pkg/client"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/server"
+	"time"
+)
+
+var errInvalidSetting = errors.New("invalid setting")
+
+// filter selects the changes replayed: those to keys with the prefix, made within the time range.
+type filter struct {
+	prefix string
+
+	// zero if unbounded
+	from time.Time
+	to   time.Time
+}
+
+func main() {
+	filename := flag.String("file", "-", "Changelog written by the server's -changelog, - being standard input")
+
+	prefix := flag.String("prefix", "", "Only replay changes to keys starting with this")
+	from := flag.String("from", "", "Only replay changes made at or after this time, in RFC 3339 format")
+	to := flag.String("to", "",
+		"Only replay changes made at or before this time, in RFC 3339 format, to recover the keys as of then")
+
+	servers := flag.String("servers", "",
+		"Comma separated client addresses of the servers to replay the changes against, the first unless "+
+			"-cluster is set")
+	cluster := flag.Bool("cluster", false, "Whether the servers are a sharded cluster")
+	token := flag.String("token", "", "Token to authenticate with, if the servers require it")
+	timeout := flag.Duration("timeout", 5*time.Second, "How long each command waits for the server")
+
+	output := flag.String("out", "",
+		"Instead of replaying against servers, replay into a fresh store then write its keys to this file, "+
+			"in the JSON format the dump tool loads, - being standard output")
+
+	flag.Parse()
+
+	f, err := parseFilter(*prefix, *from, *to)
+	if err != nil {
+		log.Fatal("Invalid filter: ", err)
+	}
+
+	if (*servers == "") == (*output == "") {
+		log.Fatal("Invalid settings: give either -servers or -out")
+	}
+
+	start := time.Now()
+
+	var count int
+
+	if *servers != "" {
+		options := client.Options{Timeout: *timeout, Token: *token, Retries: 3}
+		count, err = replayToServers(*filename, f, strings.Split(*servers, ","), *cluster, options)
+	} else {
+		count, err = replayToFile(*filename, f, *output)
+	}
+
+	if err != nil {
+		log.Fatal("Unable to replay changelog: ", err)
+	}
+
+	log.Printf("Replayed %d changes in %v", count, time.Since(start).Round(time.Millisecond))
+}
+
+// parseFilter returns the filter, given the times in RFC 3339 format, either of which may be empty.
+func parseFilter(prefix string, from string, to string) (filter, error) {
+	f := filter{prefix: prefix}
+
+	var err error
+
+	if from != "" {
+		if f.from, err = time.Parse(time.RFC3339Nano, from); err != nil {
+			return f, fmt.Errorf("%w: from: %w", errInvalidSetting, err)
+		}
+	}
+
+	if to != "" {
+		if f.to, err = time.Parse(time.RFC3339Nano, to); err != nil {
+			return f, fmt.Errorf("%w: to: %w", errInvalidSetting, err)
+		}
+	}
+
+	if !f.from.IsZero() && !f.to.IsZero() && f.to.Before(f.from) {
+		return f, fmt.Errorf("%w: to is before from", errInvalidSetting)
+	}
+
+	return f, nil
+}
+
+// selects returns whether the change is replayed.
+func (f filter) selects(entry server.ChangelogEntry) bool {
+	return strings.HasPrefix(entry.Key, f.prefix) &&
+		(f.from.IsZero() || !entry.Time.Before(f.from)) &&
+		(f.to.IsZero() || !entry.Time.After(f.to))
+}
+
+// end returns the end of the time range, now if unbounded.
+func (f filter) end() time.Time {
+	if f.to.IsZero() {
+		return time.Now()
+	}
+
+	return f.to
+}
+
+// replay calls apply with each change in the changelog selected by the filter, in the order they were
+// made, returning how many were applied. Collections, such as hashes, are skipped, only strings being
+// replayed. A key put that had expired by the end of the time range is replayed as deleted, in case the
+// server stopped before recording it expiring.
+func replay(filename string, f filter, apply func(entry server.ChangelogEntry) error) (int, error) {
+	file := os.Stdin
+
+	if filename != "-" {
+		var err error
+
+		if file, err = os.Open(filename); err != nil {
+			return 0, fmt.Errorf("error opening changelog: %w", err)
+		}
+
+		defer file.Close()
+	}
+
+	count, skipped := 0, 0
+
+	err := server.ReadChangelog(file, func(entry server.ChangelogEntry) error {
+		if !f.selects(entry) {
+			return nil
+		}
+
+		if entry.Type != "" {
+			skipped++
+
+			return nil
+		}
+
+		if entry.Expiry != nil && !entry.Expiry.After(f.end()) {
+			entry.Operation, entry.Value, entry.Expiry = server.ChangelogDelete, "", nil
+		}
+
+		if err := apply(entry); err != nil {
+			return err
+		}
+
+		count++
+
+		return nil
+	})
+	if err != nil {
+		return count, fmt.Errorf("error replaying changelog: %w", err)
+	}
+
+	if skipped > 0 {
+		log.Printf("Skipped %d changes to collections", skipped)
+	}
+
+	return count, nil
+}
+
+// replayToServers sends each change selected to the servers, one at a time so they are made in order.
+func replayToServers(filename string, f filter, addresses []string, cluster bool, options client.Options,
+) (int, error) {
+	// the changes are replayed whole, so can be retried
+	ctx := client.Idempotent(context.Background())
+
+	var (
+		kv  client.KV
+		err error
+	)
+
+	if cluster {
+		kv, err = client.DialCluster(ctx, addresses, options)
+	} else {
+		kv, err = client.Dial(ctx, addresses[0], options)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error connecting: %w", err)
+	}
+
+	defer kv.Close()
+
+	return replay(filename, f, func(entry server.ChangelogEntry) error {
+		var err error
+
+		if entry.Operation == server.ChangelogDelete {
+			err = kv.Delete(ctx, entry.Key)
+		} else {
+			err = kv.Put(ctx, entry.Key, entry.Value)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error replaying %s of %s: %w", entry.Operation, entry.Key, err)
+		}
+
+		return nil
+	})
+}
+
+// replayToFile applies each change selected to a fresh store, then writes every key it holds to the file.
+func replayToFile(filename string, f filter, output string) (int, error) {
+	store := kvstore.NewKVStore()
+	defer kvstore.Close(store)
+
+	count, err := replay(filename, f, func(entry server.ChangelogEntry) error {
+		if entry.Operation == server.ChangelogDelete {
+			kvstore.Delete(store, entry.Key)
+		} else {
+			kvstore.Write(store, entry.Key, entry.Value)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	file := os.Stdout
+
+	if output != "-" {
+		if file, err = os.Create(output); err != nil {
+			return count, fmt.Errorf("error creating file: %w", err)
+		}
+
+		defer file.Close()
+	}
+
+	if err := writeKeys(file, store); err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+type jsonEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// writeKeys writes every key in the store as a JSON array of objects, one per line, in key order.
+func writeKeys(writer io.Writer, store *kvstore.KVStore) error {
+	buffered := bufio.NewWriter(writer)
+	separator := "[\n"
+
+	var err error
+
+	kvstore.Scan(store, "", func(key string, value string) bool {
+		var encoded []byte
+
+		if encoded, err = json.Marshal(jsonEntry{key, value}); err != nil {
+			return false
+		}
+
+		_, err = buffered.WriteString(separator + string(encoded))
+		separator = ",\n"
+
+		return err == nil
+	})
+
+	end := "\n]\n"
+	if separator == "[\n" {
+		end = "[]\n"
+	}
+
+	if err == nil {
+		_, err = buffered.WriteString(end)
+	}
+
+	if err == nil {
+		err = buffered.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	return nil
+}
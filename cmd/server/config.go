@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var errUnknownSetting = errors.New("unknown setting")
+
+// applyConfigFile sets flags from a JSON config file, an object keyed by flag name, e.g.
+//
+//	{"server": "localhost:8000", "others": ["server2:8001", "server3:8001"], "rateLimit": 100}
+//
+// Lists may be given as arrays, which are joined with commas. Flags set on the command line
+// override the file, so aren't changed. Applying the file again, such as when reloading, leaves
+// flags that have been removed from it unchanged.
+func applyConfigFile(flags *flag.FlagSet, filename string, commandLine map[string]bool) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	var settings map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&settings); err != nil {
+		return fmt.Errorf("error parsing config file: %w", err)
+	}
+
+	for name, value := range settings {
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("invalid config file: %w: %s", errUnknownSetting, name)
+		}
+
+		if commandLine[name] {
+			continue
+		}
+
+		if err := flags.Set(name, settingText(value)); err != nil {
+			return fmt.Errorf("invalid config file setting %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// commandLineFlags returns the names of the flags set on the command line, which must be called
+// before any flags are set from the config file.
+func commandLineFlags(flags *flag.FlagSet) map[string]bool {
+	names := make(map[string]bool)
+
+	flags.Visit(func(f *flag.Flag) {
+		names[f.Name] = true
+	})
+
+	return names
+}
+
+// settingText returns the setting's value as flag text.
+func settingText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = settingText(item)
+		}
+
+		return strings.Join(items, ",")
+
+	default:
+		return fmt.Sprint(v)
+	}
+}
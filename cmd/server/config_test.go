@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_applyConfigFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		commandLine []string
+		environment []string
+		file        string
+		expected    map[string]string
+	}{
+		{
+			name:     "strings and numbers",
+			file:     `{"server": ":8000", "rateLimit": 100}`,
+			expected: map[string]string{"server": ":8000", "rateLimit": "100"},
+		},
+		{
+			name:     "lists joined",
+			file:     `{"others": ["a:9001", "b:9001"]}`,
+			expected: map[string]string{"others": "a:9001,b:9001"},
+		},
+		{
+			name:     "list given as text",
+			file:     `{"others": "a:9001,b:9001"}`,
+			expected: map[string]string{"others": "a:9001,b:9001"},
+		},
+		{
+			name:        "command line takes precedence",
+			commandLine: []string{"-server", ":7000"},
+			file:        `{"server": ":8000", "rateLimit": 100}`,
+			expected:    map[string]string{"server": ":7000", "rateLimit": "100"},
+		},
+		{
+			name:        "environment takes precedence",
+			environment: []string{"KV_SERVER=:7000"},
+			file:        `{"server": ":8000", "peer": ":9000"}`,
+			expected:    map[string]string{"server": ":7000", "peer": ":9000"},
+		},
+	}
+
+	for _, test := range tests {
+		flags := testFlags(t, test.commandLine)
+		overridden := commandLineFlags(flags)
+
+		if _, err := applyEnvironment(flags, test.environment, overridden); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+
+		if err := applyConfigFile(flags, writeConfigFile(t, test.file), overridden); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		checkFlags(t, test.name, flags, test.expected)
+	}
+}
+
+func Test_applyConfigFile_Invalid(t *testing.T) {
+	flags := testFlags(t, nil)
+
+	err := applyConfigFile(flags, writeConfigFile(t, `{"unknown": 1}`), map[string]bool{})
+	if !errors.Is(err, errUnknownSetting) {
+		t.Error("Expected unknown setting error but got: ", err)
+	}
+
+	if err := applyConfigFile(flags, writeConfigFile(t, `{"rateLimit": "lots"}`), map[string]bool{}); err == nil {
+		t.Error("Expected an error for an invalid value")
+	}
+
+	if err := applyConfigFile(flags, writeConfigFile(t, `not json`), map[string]bool{}); err == nil {
+		t.Error("Expected an error for invalid JSON")
+	}
+}
+
+// writeConfigFile writes the config file to a temporary directory, returning its name.
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "config.json")
+
+	if err := os.WriteFile(filename, []byte(contents), 0o600); err != nil {
+		t.Fatal("Unable to write config file: ", err)
+	}
+
+	return filename
+}
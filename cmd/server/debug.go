@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// startDebugListener serves the pprof profiles on the address, such as localhost:6060, so goroutine
+// leaks and allocation hot spots can be profiled in a running server, e.g. with
+// go tool pprof http://localhost:6060/debug/pprof/heap
+func startDebugListener(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	debugServer := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		log.Print("serving pprof profiles on ", address)
+
+		if err := debugServer.ListenAndServe(); err != nil {
+			log.Print("Debug listener failed: ", err)
+		}
+	}()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const environmentPrefix = "KV_"
+
+var errDuplicateSetting = errors.New("setting given twice")
+
+// environmentAliases are shorter names for the most common settings, accepted as well as the names derived
+// from the flags.
+var environmentAliases = map[string]string{
+	"KV_SERVER_ADDR": "server",
+	"KV_PEER_ADDR":   "peer",
+	"KV_HTTP_ADDR":   "http",
+	"KV_PEERS":       "others",
+}
+
+// applyEnvironment sets flags from environment variables, each flag's variable being its name in upper
+// case with words separated by underscores, after KV_, e.g. KV_RATE_LIMIT for -rateLimit, or one of the
+// aliases. Flags set on the command line override the environment, so aren't changed. The names of the
+// flags set are added to overridden, so a config file doesn't change them either. Returns the variables
+// starting KV_ that don't match a flag, which are ignored, so other tools' settings don't stop the server.
+func applyEnvironment(flags *flag.FlagSet, environment []string, overridden map[string]bool) ([]string, error) {
+	names := make(map[string]string)
+
+	flags.VisitAll(func(f *flag.Flag) {
+		names[environmentName(f.Name)] = f.Name
+	})
+
+	for alias, name := range environmentAliases {
+		names[alias] = name
+	}
+
+	set := make(map[string]string)
+
+	var unknown []string
+
+	for _, variable := range environment {
+		key, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(key, environmentPrefix) {
+			continue
+		}
+
+		name, found := names[key]
+		if !found {
+			unknown = append(unknown, key)
+			continue
+		}
+
+		if previous, found := set[name]; found {
+			return nil, fmt.Errorf("invalid environment: %w: %s and %s", errDuplicateSetting, previous, key)
+		}
+
+		set[name] = key
+
+		if overridden[name] {
+			continue
+		}
+
+		if err := flags.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid environment variable %s: %w", key, err)
+		}
+	}
+
+	for name := range set {
+		overridden[name] = true
+	}
+
+	return unknown, nil
+}
+
+// environmentName returns the environment variable for a flag, e.g. KV_RATE_LIMIT for rateLimit, keeping
+// acronyms together, e.g. KV_NAMESPACE_TTL for namespaceTTL.
+func environmentName(flagName string) string {
+	var builder strings.Builder
+
+	builder.WriteString(environmentPrefix)
+
+	characters := []rune(flagName)
+
+	for i, character := range characters {
+		if i > 0 && unicode.IsUpper(character) && (!unicode.IsUpper(characters[i-1]) ||
+			(i+1 < len(characters) && unicode.IsLower(characters[i+1]))) {
+			builder.WriteByte('_')
+		}
+
+		builder.WriteRune(unicode.ToUpper(character))
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func Test_environmentName(t *testing.T) {
+	tests := []struct {
+		flagName string
+		expected string
+	}{
+		{flagName: "server", expected: "KV_SERVER"},
+		{flagName: "rateLimit", expected: "KV_RATE_LIMIT"},
+		{flagName: "maxCommandSize", expected: "KV_MAX_COMMAND_SIZE"},
+		{flagName: "namespaceTTL", expected: "KV_NAMESPACE_TTL"},
+		{flagName: "peerTLSCert", expected: "KV_PEER_TLS_CERT"},
+	}
+
+	for _, test := range tests {
+		if name := environmentName(test.flagName); name != test.expected {
+			t.Errorf("Expected %s for %s but got %s", test.expected, test.flagName, name)
+		}
+	}
+}
+
+func Test_applyEnvironment(t *testing.T) {
+	tests := []struct {
+		name        string
+		commandLine []string
+		environment []string
+		expected    map[string]string
+		unknown     []string
+		overridden  []string
+	}{
+		{
+			name:        "derived names",
+			environment: []string{"KV_SERVER=:8000", "KV_RATE_LIMIT=100"},
+			expected:    map[string]string{"server": ":8000", "rateLimit": "100"},
+			overridden:  []string{"rateLimit", "server"},
+		},
+		{
+			name:        "aliases",
+			environment: []string{"KV_SERVER_ADDR=:8000", "KV_PEERS=a:9001,b:9001"},
+			expected:    map[string]string{"server": ":8000", "others": "a:9001,b:9001"},
+			overridden:  []string{"others", "server"},
+		},
+		{
+			name:        "command line takes precedence",
+			commandLine: []string{"-server", ":7000"},
+			environment: []string{"KV_SERVER=:8000", "KV_RATE_LIMIT=100"},
+			expected:    map[string]string{"server": ":7000", "rateLimit": "100"},
+			overridden:  []string{"rateLimit", "server"},
+		},
+		{
+			name:        "unknown and other variables ignored",
+			environment: []string{"KV_UNKNOWN=1", "HOME=/root", "KV_SERVER=:8000"},
+			expected:    map[string]string{"server": ":8000", "rateLimit": "0"},
+			unknown:     []string{"KV_UNKNOWN"},
+			overridden:  []string{"server"},
+		},
+	}
+
+	for _, test := range tests {
+		flags := testFlags(t, test.commandLine)
+		overridden := commandLineFlags(flags)
+
+		unknown, err := applyEnvironment(flags, test.environment, overridden)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		checkFlags(t, test.name, flags, test.expected)
+
+		if !reflect.DeepEqual(unknown, test.unknown) {
+			t.Errorf("%s: expected unknown %v but got %v", test.name, test.unknown, unknown)
+		}
+
+		if names := sortedNames(overridden); !reflect.DeepEqual(names, test.overridden) {
+			t.Errorf("%s: expected overridden %v but got %v", test.name, test.overridden, names)
+		}
+	}
+}
+
+func Test_applyEnvironment_Invalid(t *testing.T) {
+	flags := testFlags(t, nil)
+
+	_, err := applyEnvironment(flags, []string{"KV_SERVER=:8000", "KV_SERVER_ADDR=:7000"}, map[string]bool{})
+	if !errors.Is(err, errDuplicateSetting) {
+		t.Error("Expected duplicate setting error but got: ", err)
+	}
+
+	if _, err := applyEnvironment(flags, []string{"KV_RATE_LIMIT=lots"}, map[string]bool{}); err == nil {
+		t.Error("Expected an error for an invalid value")
+	}
+}
+
+// testFlags returns some of the server's flags, parsed from the command line arguments.
+func testFlags(t *testing.T, arguments []string) *flag.FlagSet {
+	t.Helper()
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("server", "", "")
+	flags.String("peer", "", "")
+	flags.String("http", "", "")
+	flags.String("others", "", "")
+	flags.Int("rateLimit", 0, "")
+
+	if err := flags.Parse(arguments); err != nil {
+		t.Fatal("Unable to parse flags: ", err)
+	}
+
+	return flags
+}
+
+// checkFlags checks the flags have the expected values.
+func checkFlags(t *testing.T, name string, flags *flag.FlagSet, expected map[string]string) {
+	t.Helper()
+
+	for flagName, value := range expected {
+		if actual := flags.Lookup(flagName).Value.String(); actual != value {
+			t.Errorf("%s: expected -%s %q but got %q", name, flagName, value, actual)
+		}
+	}
+}
+
+// sortedNames returns the names in the set, in order.
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+
+	for name := range set {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -1,30 +1,627 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"tcp/pkg/backoff"
 	"tcp/pkg/kvstore"
+	"tcp/pkg/logging"
 	"tcp/pkg/server"
+	"tcp/pkg/version"
+	"time"
 )
 
 func main() {
 	log.Println("Starting up...")
 
 	serverHostnamePort := flag.String("server", "localhost:8000",
-		"TCP server hostname and port to listen on (for clients)")
+		"TCP server hostname and port, or unix:// socket path, to listen on (for clients)")
 
 	peerHostnamePort := flag.String("peer", "localhost:8001",
-		"TCP server hostname and port to listen on (for server peers)")
+		"TCP server hostname and port, or unix:// socket path, to listen on (for server peers)")
+
+	httpHostnamePort := flag.String("http", "",
+		"HTTP and WebSocket gateway hostname and port, or unix:// socket path, to listen on (disabled if empty)")
 
 	otherServers := flag.String("others", "",
-		"Comma-separated list of other server hostnames and ports to replicate with")
+		"Comma-separated list of other server hostnames and ports, or unix:// socket paths, to replicate with")
+
+	seeds := flag.String("seeds", "",
+		"Comma-separated list of servers' peer ports to find the rest of the cluster from by gossip, "+
+			"instead of only replicating to -others (gossip disabled if empty)")
+
+	gossipInterval := flag.Duration("gossipInterval", time.Second, "How often to gossip with another server")
+	antiEntropyInterval := flag.Duration("antiEntropyInterval", 0,
+		"How often to compare keys with a random peer, repairing any it has that differ (disabled if 0)")
+
+	authToken := flag.String("auth", "",
+		"Shared secret clients and peers must supply with the auth command (authentication disabled if empty)")
+
+	aclFilename := flag.String("acl", "",
+		"JSON file of client users, with their tokens and permitted commands and key prefixes (instead of -auth)")
+
+	peerSecret := flag.String("peerAuth", "",
+		"Shared secret peers must supply with the auth command (defaults to the -auth secret)")
+
+	sampleFilename := flag.String("sampleFile", "",
+		"File to write sampled command timings to, as JSON lines (sampling disabled if empty)")
+
+	sampleEvery := flag.Int("sampleEvery", 100, "Sample 1 in every N commands")
+
+	changelogFilename := flag.String("changelog", "",
+		"File to append every change to the store to, as JSON lines, for replaying with cmd/replay "+
+			"(disabled if empty)")
+
+	accessLogFilename := flag.String("accessLog", "",
+		"File to write every client command, with its result and latency, to as JSON lines (disabled if empty)")
+
+	replicationModeName := flag.String("replication", "sync",
+		"When client writes are acknowledged: sync (once replicated to peers) or async (once applied locally, "+
+			"then replicated in the background)")
+
+	replicationQueueLimit := flag.Int("replicationQueue", 10000,
+		"Maximum number of writes queued for each peer in async replication mode, further writes are dropped")
+
+	writeQuorumValue := flag.String("writeQuorum", "",
+		"Number of servers, including this one, that must apply a write before it is acknowledged, or all "+
+			"(if empty, waits for every reachable peer until the command timeout)")
+
+	outagePolicyName := flag.String("peerOutage", "fail",
+		"How writes are handled when every peer is unreachable: fail, handoff (apply locally and queue for peers) "+
+			"or warn (apply locally, respond wrn)")
+
+	roleName := flag.String("role", "primary",
+		"Which writes the server accepts: primary, or replica (only serving reads to clients, "+
+			"applying writes replicated by its primaries)")
+	primary := flag.String("primary", "", "Client address of the primary, which a replica redirects writes to")
+
+	handoffLimit := flag.Int("handoffLimit", 10000, "Maximum number of writes queued for each unreachable peer")
+
+	redoLogLimit := flag.Int("redoLogLimit", 10000,
+		"Maximum number of writes kept for each peer after replicating to it failed, to be retried in order")
+
+	peerQueueLimit := flag.Int("peerQueueLimit", 1000,
+		"Maximum number of writes waiting to be sent to each peer, in sync replication mode")
+	backpressureName := flag.String("backpressure", "block",
+		"What happens to writes when a peer's queue is full: block (wait for space), shed (don't replicate "+
+			"to the peer), or async (replicate without waiting)")
+
+	watchBuffer := flag.Int("watchBuffer", 1000,
+		"Maximum number of changes and responses waiting to be sent to each connection watching keys")
+	slowWatcherName := flag.String("slowWatcher", "disconnect",
+		"What happens to changes when a watching connection's buffer is full: disconnect (close the "+
+			"connection), drop (drop the oldest changes, sending a gap marker), or block (keep them waiting "+
+			"beyond the buffer, up to twice its size)")
+	watchBlockTimeout := flag.Duration("watchBlockTimeout", 100*time.Millisecond,
+		"How long a watching connection's buffer can stay full before it is disconnected, when blocking")
+	keyspaceEventsNames := flag.String("keyspaceEvents", "",
+		"Classes of change to keys published as keyspace notifications, comma separated: set, del, expire, "+
+			"evict, or all (none if empty)")
+
+	readTimeout := flag.Duration("readTimeout", 0,
+		"Maximum time to wait for each read from a connection, e.g. 30s (no limit if zero)")
+
+	writeTimeout := flag.Duration("writeTimeout", 0,
+		"Maximum time to wait for each write to a connection, e.g. 5s (no limit if zero)")
+
+	idleTimeout := flag.Duration("idleTimeout", 0,
+		"How long a client connection can be idle before it is closed, e.g. 5m (no limit if zero)")
+
+	maxConnections := flag.Int("maxConns", 0,
+		"Maximum number of concurrent client connections, further clients are sent bsy (no limit if zero)")
+
+	rateLimit := flag.Float64("rateLimit", 0,
+		"Maximum commands per second from each client IP address, further commands are sent thr (no limit if zero)")
+
+	rateLimitBurst := flag.Int("rateBurst", 0,
+		"Maximum burst of commands from each client IP address (defaults to 1 second's worth of -rateLimit)")
+
+	namespaceTTLs := flag.String("namespaceTTL", "",
+		"Comma-separated default TTL of keys in each namespace, by key prefix, e.g. cache/=5m,session/=30m "+
+			"(overridden by a TTL given with pex)")
+
+	commandTimeout := flag.Duration("commandTimeout", 500*time.Millisecond,
+		"How long commands wait for the local store and every peer to respond")
+
+	clientAddresses := flag.String("clientAddresses", "",
+		"Comma-separated client addresses of the other servers, by peer address, which clients are redirected "+
+			"to rather than commands being forwarded, e.g. peer2:9001=peer2:8001")
+
+	commandTimeouts := flag.String("commandTimeouts", "",
+		"Comma-separated timeouts of particular commands, overriding -commandTimeout, e.g. put=2s,pck=2s")
+
+	idempotencyWindow := flag.Duration("idempotencyWindow", 5*time.Minute,
+		"How long the responses to writes sent with idempotency keys are remembered")
+
+	idempotencyLimit := flag.Int("idempotencyLimit", 10000,
+		"Maximum number of responses to writes sent with idempotency keys remembered")
+
+	retryInitial := flag.Duration("retryInitial", backoff.DefaultPolicy.Initial,
+		"Delay before the first retry, such as handing off writes to peers, doubling after each failure")
+
+	retryMax := flag.Duration("retryMax", backoff.DefaultPolicy.Max, "Maximum delay between retries")
+
+	warmUpKeys := flag.Int("warmUpKeys", 0,
+		"Number of the most read keys to fetch from a peer when starting (warm up disabled if zero)")
+
+	syncOnStart := flag.Bool("sync", false,
+		"When starting, replace the local keys with a snapshot of the first reachable peer's keys")
+
+	raftMode := flag.Bool("raft", false,
+		"Agree client writes with the other servers using Raft consensus, instead of replicating them "+
+			"(-replication, -writeQuorum, -peerOutage and -redoLogLimit are then ignored)")
+
+	lastWriteWins := flag.Bool("lww", false,
+		"Resolve concurrent writes to the same key on different servers by last write wins, "+
+			"using hybrid logical clock timestamps (every server must enable it)")
+
+	trackOrigins := flag.Bool("trackOrigins", false,
+		"Tag replicated writes with the server they were made on, dropping any seen before")
+
+	shardReplicas := flag.Int("shardReplicas", 0,
+		"Number of servers each key is held on, assigned by consistent hashing (every server if zero), commands "+
+			"for keys held elsewhere being forwarded there, unless redirected by -clientAddresses")
+
+	virtualNodes := flag.Int("virtualNodes", 128, "Number of points each server has on the consistent hash ring")
+
+	nodeID := flag.String("id", "",
+		"Address the other servers have this server's peer port as in -others, for -raft and -shardReplicas "+
+			"(defaults to the bound -peer address)")
+
+	readOnly := flag.Bool("readOnly", false, "Start in read-only mode, rejecting client writes until turned off")
+
+	allowFlushAll := flag.Bool("allowFlushAll", false, "Allow clients to clear every key with the flush all command")
+
+	slowLogThreshold := flag.Duration("slowLogThreshold", 0,
+		"Client commands taking longer than this to handle are kept in the slow log (disabled if zero)")
+
+	slowLogLength := flag.Int("slowLogLength", 128, "Maximum number of commands kept in the slow log")
+
+	debugHostnamePort := flag.String("pprof", "",
+		"Hostname and port to serve pprof profiles on, e.g. localhost:6060 (disabled if empty, don't expose publicly)")
+
+	logFormat := flag.String("logFormat", logging.TextFormat, "Format of log output: text or json")
+
+	logLevel := flag.String("logLevel", "info", "Minimum level logged: debug, info, warn or error")
+
+	logLevels := flag.String("logLevels", "",
+		"Comma-separated minimum level logged by particular subsystems (server, peer or kvstore), "+
+			"overriding -logLevel, e.g. peer=debug,kvstore=warn")
+
+	shutdownGrace := flag.Duration("shutdownGrace", 10*time.Second,
+		"How long to wait for in-flight commands to complete when shutting down on SIGINT or SIGTERM")
+
+	configFilename := flag.String("config", "",
+		"JSON file of settings, keyed by flag name, overridden by environment variables then by flags given "+
+			"on the command line. "+
+			"Read again on SIGHUP, applying changes to -logLevel, -logLevels, -rateLimit, -rateBurst and -others")
+
+	maxCommandSize := flag.Int("maxCommandSize", 16<<20,
+		"Most unparsed input from a client, in bytes, before the connection is closed")
+
+	maxProtocolErrors := flag.Int("maxProtocolErrors", 3,
+		"Number of invalid commands in a row from a client before the connection is closed")
+
+	clientTCPOptions := tcpOptionFlags("client", "client connections")
+	peerTCPOptions := tcpOptionFlags("peer", "connections between peers")
+
+	peerCompressionThreshold := flag.Int("peerCompressionThreshold", 0,
+		"Size in bytes above which commands sent to peers are compressed, if they support it (never if zero)")
+
+	storageName := flag.String("storage", "memory",
+		"Where strings are held: memory, or disk so they can take up more space than there is memory")
+	storageDir := flag.String("storageDir", "data", "Directory holding the strings, when held on disk")
+
+	showVersion := flag.Bool("version", false, "Print the version and exit")
 
 	flag.Parse()
 
-	store := kvstore.NewKVStore()
-	server.StartServer(store, *serverHostnamePort, *peerHostnamePort, strings.Split(*otherServers, ","))
+	// flags given on the command line, or set from the environment, which the config file doesn't change
+	commandLine := commandLineFlags(flag.CommandLine)
+
+	unknownVariables, err := applyEnvironment(flag.CommandLine, os.Environ(), commandLine)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, variable := range unknownVariables {
+		log.Println("Warning: ignoring unknown environment variable", variable)
+	}
+
+	if *configFilename != "" {
+		if err := applyConfigFile(flag.CommandLine, *configFilename, commandLine); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *showVersion {
+		fmt.Println(version.Info(nil))
+		return
+	}
+
+	level, subsystemLevels, err := parseLogLevels(*logLevel, *logLevels)
+	if err != nil {
+		log.Fatal("Invalid logging options: ", err)
+	}
+
+	levels := logging.NewLevelSet(level, subsystemLevels)
+
+	logger, err := logging.New(os.Stdout, *logFormat, levels)
+	if err != nil {
+		log.Fatal("Invalid logging options: ", err)
+	}
+
+	slog.SetDefault(logger)
+
+	outagePolicy, err := server.ParseOutagePolicy(*outagePolicyName)
+	if err != nil {
+		log.Fatal("Invalid peer outage policy: ", err)
+	}
+
+	backpressure, err := server.ParseBackpressurePolicy(*backpressureName)
+	if err != nil {
+		log.Fatal("Invalid backpressure policy: ", err)
+	}
+
+	slowWatcher, err := server.ParseSlowWatcherPolicy(*slowWatcherName)
+	if err != nil {
+		log.Fatal("Invalid slow watcher policy: ", err)
+	}
+
+	keyspaceEvents, err := server.ParseKeyspaceEvents(*keyspaceEventsNames)
+	if err != nil {
+		log.Fatal("Invalid keyspace events: ", err)
+	}
+
+	role, err := server.ParseRole(*roleName)
+	if err != nil {
+		log.Fatal("Invalid role: ", err)
+	}
+
+	replicationMode, err := server.ParseReplicationMode(*replicationModeName)
+	if err != nil {
+		log.Fatal("Invalid replication mode: ", err)
+	}
+
+	var writeQuorum int
+
+	if *writeQuorumValue != "" {
+		writeQuorum, err = server.ParseWriteQuorum(*writeQuorumValue)
+		if err != nil {
+			log.Fatal("Invalid write quorum: ", err)
+		}
+	}
+
+	ttls, err := server.ParseNamespaceTTLs(*namespaceTTLs)
+	if err != nil {
+		log.Fatal("Invalid namespace TTLs: ", err)
+	}
+
+	addresses, err := server.ParseClientAddresses(*clientAddresses)
+	if err != nil {
+		log.Fatal("Invalid client addresses: ", err)
+	}
+
+	timeouts, err := server.ParseCommandTimeouts(*commandTimeouts)
+	if err != nil {
+		log.Fatal("Invalid command timeouts: ", err)
+	}
+
+	acl, err := loadACL(*authToken, *aclFilename)
+	if err != nil {
+		log.Fatal("Unable to load ACL: ", err)
+	}
+
+	if *peerSecret == "" {
+		*peerSecret = *authToken
+	}
+
+	// peers replicate writes without the checks made of clients, so an unauthenticated peer port lets
+	// anyone who can reach it change any key, even when clients must authenticate
+	if *peerSecret == "" {
+		log.Println("Warning: the peer port is unauthenticated, anyone who can reach it can change any key, " +
+			"set -peerAuth")
+	}
+
+	sampler, closeSampleFile := openSampler(*sampleFilename, *sampleEvery)
+	defer closeSampleFile()
+
+	accessLog, closeAccessLogFile := openAccessLog(*accessLogFilename)
+	defer closeAccessLogFile()
+
+	changelog, closeChangelogFile := openChangelog(*changelogFilename)
+	defer closeChangelogFile()
+
+	if *debugHostnamePort != "" {
+		startDebugListener(*debugHostnamePort)
+	}
+
+	engine, err := openStorageEngine(*storageName, *storageDir)
+	if err != nil {
+		log.Fatal("Unable to open storage engine: ", err)
+	}
+
+	store := kvstore.NewKVStoreWithEngine(logging.Subsystem(logger, "kvstore"), engine)
+
+	srv := server.NewServer(store, server.Config{
+		ServerHostnamePort:       *serverHostnamePort,
+		PeerHostnamePort:         *peerHostnamePort,
+		HTTPHostnamePort:         *httpHostnamePort,
+		OtherServers:             splitList(*otherServers),
+		ClientAddresses:          addresses,
+		Seeds:                    splitList(*seeds),
+		GossipInterval:           *gossipInterval,
+		AntiEntropyInterval:      *antiEntropyInterval,
+		ACL:                      acl,
+		PeerSecret:               *peerSecret,
+		Logger:                   logger,
+		Sampler:                  sampler,
+		AccessLog:                accessLog,
+		Changelog:                changelog,
+		ReadOnly:                 *readOnly,
+		Role:                     role,
+		Primary:                  *primary,
+		AllowFlushAll:            *allowFlushAll,
+		SlowLogThreshold:         *slowLogThreshold,
+		SlowLogLength:            *slowLogLength,
+		MaxCommandSize:           *maxCommandSize,
+		MaxProtocolErrors:        *maxProtocolErrors,
+		ClientTCPOptions:         *clientTCPOptions,
+		PeerTCPOptions:           *peerTCPOptions,
+		PeerCompressionThreshold: *peerCompressionThreshold,
+		ReplicationMode:          replicationMode,
+		ReplicationQueueLimit:    *replicationQueueLimit,
+		WriteQuorum:              writeQuorum,
+		PeerOutagePolicy:         outagePolicy,
+		HandoffLimit:             *handoffLimit,
+		RedoLogLimit:             *redoLogLimit,
+		PeerQueueLimit:           *peerQueueLimit,
+		Backpressure:             backpressure,
+		WatchBuffer:              *watchBuffer,
+		SlowWatcherPolicy:        slowWatcher,
+		WatchBlockTimeout:        *watchBlockTimeout,
+		KeyspaceEvents:           keyspaceEvents,
+		ReadTimeout:              *readTimeout,
+		WriteTimeout:             *writeTimeout,
+		IdleTimeout:              *idleTimeout,
+		MaxConnections:           *maxConnections,
+		RateLimit:                *rateLimit,
+		RateLimitBurst:           *rateLimitBurst,
+		NamespaceTTLs:            ttls,
+		CommandTimeout:           *commandTimeout,
+		CommandTimeouts:          timeouts,
+		IdempotencyWindow:        *idempotencyWindow,
+		IdempotencyLimit:         *idempotencyLimit,
+		WarmUpKeys:               *warmUpKeys,
+		SyncOnStart:              *syncOnStart,
+		Raft:                     *raftMode,
+		LastWriteWins:            *lastWriteWins,
+		TrackOrigins:             *trackOrigins,
+		ShardReplicas:            *shardReplicas,
+		VirtualNodes:             *virtualNodes,
+		NodeID:                   *nodeID,
+		RetryPolicy: backoff.Policy{
+			Initial:    *retryInitial,
+			Max:        *retryMax,
+			Multiplier: backoff.DefaultPolicy.Multiplier,
+			Jitter:     backoff.DefaultPolicy.Jitter,
+		},
+	})
+
+	// on SIGHUP, the config file is read again, and the settings that can be changed are applied
+	reload := func() error {
+		if *configFilename != "" {
+			if err := applyConfigFile(flag.CommandLine, *configFilename, commandLine); err != nil {
+				return err
+			}
+		}
+
+		level, subsystemLevels, err := parseLogLevels(*logLevel, *logLevels)
+		if err != nil {
+			return err
+		}
+
+		levels.Set(level, subsystemLevels)
+		srv.Reload(server.Config{
+			OtherServers:   splitList(*otherServers),
+			RateLimit:      *rateLimit,
+			RateLimitBurst: *rateLimitBurst,
+		})
+
+		return nil
+	}
+
+	if err := serveUntilSignalled(srv, *shutdownGrace, reload); err != nil {
+		log.Println("Server failed: ", err)
+		return
+	}
+
+	log.Println("Shut down")
+}
+
+// serveUntilSignalled serves until SIGINT or SIGTERM is received, then stops accepting connections
+// and waits up to the grace period for in-flight commands to complete before closing the connections
+// and the store. A second signal exits immediately. Settings are reloaded whenever SIGHUP is received.
+func serveUntilSignalled(srv *server.Server, grace time.Duration, reload func() error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	defer signal.Stop(hangup)
+
+	served := make(chan error, 1)
+
+	go func() {
+		served <- srv.ListenAndServe()
+	}()
+
+	for waiting := true; waiting; {
+		select {
+		case err := <-served:
+			return err
+
+		case <-hangup:
+			if err := reload(); err != nil {
+				log.Print("Unable to reload settings, keeping the current settings: ", err)
+			}
+
+		case <-srv.ShutdownRequested():
+			log.Print("Shutdown requested by client")
+
+			waiting = false
+
+		case <-ctx.Done():
+			waiting = false
+		}
+	}
+
+	// restore the default behaviour, so another signal exits immediately
+	stop()
+
+	log.Printf("Shutting down, waiting up to %v for commands to complete...", grace)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("unable to shut down cleanly: %w", err)
+	}
+
+	if err := <-served; !errors.Is(err, server.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// parseLogLevels parses the default level logged, and the levels of particular subsystems.
+func parseLogLevels(levelText string, levelsList string) (slog.Level, logging.Levels, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(levelText)); err != nil {
+		return level, nil, fmt.Errorf("invalid log level: %w", err)
+	}
+
+	levels, err := logging.ParseLevels(levelsList)
+	if err != nil {
+		return level, nil, err
+	}
+
+	return level, levels, nil
+}
+
+// tcpOptionFlags defines the flags tuning TCP connections, prefixed with the kind of connection,
+// e.g. -clientKeepAlive, returning the options they set.
+func tcpOptionFlags(prefix string, description string) *server.TCPOptions {
+	options := &server.TCPOptions{}
+
+	flag.DurationVar(&options.KeepAlive, prefix+"KeepAlive", 0,
+		"How often keep-alive probes are sent on idle "+description+" (15s if zero, disabled if negative)")
+	flag.BoolVar(&options.Nagle, prefix+"Nagle", false,
+		"Whether small writes on "+description+" are delayed so they can be combined (Nagle's algorithm)")
+	flag.IntVar(&options.ReadBuffer, prefix+"ReadBuffer", 0,
+		"Socket receive buffer size of "+description+", in bytes (system default if zero)")
+	flag.IntVar(&options.WriteBuffer, prefix+"WriteBuffer", 0,
+		"Socket send buffer size of "+description+", in bytes (system default if zero)")
+
+	return options
+}
+
+// splitList splits a comma-separated list, which may be empty.
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+
+	return strings.Split(list, ",")
+}
+
+var (
+	errConflictingAuth = errors.New("-auth and -acl cannot both be set")
+	errUnknownStorage  = errors.New("unknown storage engine")
+)
+
+func loadACL(authToken string, aclFilename string) (*server.ACL, error) {
+	switch {
+	case aclFilename != "" && authToken != "":
+		return nil, errConflictingAuth
+
+	case aclFilename != "":
+		return server.LoadACL(aclFilename)
+
+	case authToken != "":
+		return server.NewSharedSecretACL(authToken), nil
+
+	default:
+		// authentication disabled
+		return nil, nil
+	}
+}
+
+// openStorageEngine returns the named storage engine: memory, or disk holding the strings in the directory.
+func openStorageEngine(name string, dir string) (kvstore.StorageEngine, error) {
+	switch name {
+	case "memory":
+		return kvstore.NewMemoryEngine(), nil
+
+	case "disk":
+		return kvstore.OpenDiskEngine(dir)
+
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownStorage, name)
+	}
+}
+
+func openSampler(filename string, every int) (*server.Sampler, func()) {
+	if filename == "" {
+		return nil, func() {}
+	}
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Fatal("Unable to open sample file: ", err)
+	}
+
+	return server.NewSampler(file, every), func() {
+		_ = file.Close()
+	}
+}
+
+func openAccessLog(filename string) (*server.AccessLog, func()) {
+	if filename == "" {
+		return nil, func() {}
+	}
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Fatal("Unable to open access log file: ", err)
+	}
+
+	return server.NewAccessLog(file), func() {
+		_ = file.Close()
+	}
+}
+
+func openChangelog(filename string) (*server.Changelog, func()) {
+	if filename == "" {
+		return nil, func() {}
+	}
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Fatal("Unable to open changelog file: ", err)
+	}
 
-	log.Println("Shutting down...")
-	kvstore.Close(store)
+	return server.NewChangelog(file), func() {
+		_ = file.Close()
+	}
 }
@@ -1,3 +1,3 @@
 module tcp
 
-go 1.18
+go 1.21
@@ -0,0 +1,128 @@
+// Package backoff provides exponential backoff with jitter, shared by everything that retries
+// an operation, such as delivering writes to peers that were unreachable.
+package backoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ErrBudgetExhausted is returned by Retry when the operation still fails after the budget is used up.
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// Policy determines how long to wait before each retry. The delay starts at Initial, and is
+// multiplied by Multiplier after each failure up to Max. Each delay is then reduced by a random
+// fraction of up to Jitter (0 to 1), so clients retrying at the same time spread out.
+type Policy struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// DefaultPolicy is used wherever a policy isn't configured.
+var DefaultPolicy = Policy{
+	Initial:    100 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// OrDefault returns the policy, or DefaultPolicy if it is the zero value.
+func (p Policy) OrDefault() Policy {
+	if p == (Policy{}) {
+		return DefaultPolicy
+	}
+
+	return p
+}
+
+// Delay returns how long to wait before the retry following the specified number of failed attempts
+// (starting at 1).
+func (p Policy) Delay(attempt int) time.Duration {
+	return p.delay(attempt, rand.Float64()) //nolint:gosec // jitter doesn't need a secure random number
+}
+
+// delay returns the delay for the attempt, using random (0 to 1) for the jitter.
+func (p Policy) delay(attempt int, random float64) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	multiplier := math.Max(p.Multiplier, 1)
+	delay := math.Min(float64(p.Initial)*math.Pow(multiplier, float64(attempt-1)), float64(p.Max))
+	jitter := math.Min(math.Max(p.Jitter, 0), 1)
+
+	return time.Duration(delay * (1 - jitter*random))
+}
+
+// Budget limits how many times, and for how long, an operation is retried. Zero means no limit.
+type Budget struct {
+	MaxAttempts int
+	MaxElapsed  time.Duration
+}
+
+// Retry performs the operation until it succeeds, the budget is used up or the context is done,
+// waiting between attempts according to the policy. Returns the last error if it never succeeded.
+func Retry(ctx context.Context, policy Policy, budget Budget, operation func() error) error {
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil {
+			return nil
+		}
+
+		delay := policy.Delay(attempt)
+
+		if (budget.MaxAttempts > 0 && attempt >= budget.MaxAttempts) ||
+			(budget.MaxElapsed > 0 && time.Since(start)+delay > budget.MaxElapsed) {
+			return fmt.Errorf("%w after %d attempts: %v", ErrBudgetExhausted, attempt, err) //nolint:errorlint
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("retry cancelled: %w", ctx.Err())
+		}
+	}
+}
+
+// Backoff tracks the failures of an operation retried periodically, such as reconnecting to a
+// peer, so it is only attempted again once the delay since the last failure has passed.
+// The zero value is ready to attempt immediately, using DefaultPolicy.
+type Backoff struct {
+	Policy Policy
+
+	failures int
+	next     time.Time
+}
+
+// Ready returns whether the operation can be attempted now.
+func (b *Backoff) Ready(now time.Time) bool {
+	return !now.Before(b.next)
+}
+
+// Failure records a failed attempt, delaying the next one.
+func (b *Backoff) Failure(now time.Time) {
+	b.failures++
+	b.next = now.Add(b.Policy.OrDefault().Delay(b.failures))
+}
+
+// Success records a successful attempt, so the next can be attempted immediately.
+func (b *Backoff) Success() {
+	b.failures = 0
+	b.next = time.Time{}
+}
+
+// Failures returns the number of consecutive failed attempts.
+func (b *Backoff) Failures() int {
+	return b.failures
+}
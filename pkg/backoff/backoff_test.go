@@ -0,0 +1,118 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errFailed = errors.New("failed")
+
+func TestDelay(t *testing.T) {
+	policy := Policy{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2, Jitter: 0.5}
+
+	checkDelay(t, 100*time.Millisecond, policy.delay(1, 0))
+	checkDelay(t, 200*time.Millisecond, policy.delay(2, 0))
+	checkDelay(t, 400*time.Millisecond, policy.delay(3, 0))
+	checkDelay(t, time.Second, policy.delay(10, 0))            // capped
+	checkDelay(t, 50*time.Millisecond, policy.delay(1, 1))     // maximum jitter
+	checkDelay(t, 750*time.Millisecond, policy.delay(10, 0.5)) // jitter applied after cap
+}
+
+func TestOrDefault(t *testing.T) {
+	if (Policy{}).OrDefault() != DefaultPolicy {
+		t.Error("Expected default policy for zero value")
+	}
+
+	policy := Policy{Initial: time.Second}
+	if policy.OrDefault() != policy {
+		t.Error("Expected configured policy")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	attempts := 0
+
+	err := Retry(context.Background(), Policy{Initial: time.Millisecond, Max: time.Millisecond}, Budget{},
+		func() error {
+			attempts++
+			if attempts < 3 {
+				return errFailed
+			}
+
+			return nil
+		})
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts but got %d", attempts)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	attempts := 0
+
+	err := Retry(context.Background(), Policy{Initial: time.Millisecond, Max: time.Millisecond},
+		Budget{MaxAttempts: 4}, func() error {
+			attempts++
+			return errFailed
+		})
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Error("Wrong error returned: ", err)
+	}
+
+	if attempts != 4 {
+		t.Errorf("Expected 4 attempts but got %d", attempts)
+	}
+
+	err = Retry(context.Background(), Policy{Initial: time.Hour, Max: time.Hour},
+		Budget{MaxElapsed: time.Minute}, func() error { return errFailed })
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := Retry(ctx, Policy{Initial: time.Hour, Max: time.Hour}, Budget{}, func() error { return errFailed })
+	if !errors.Is(err, context.Canceled) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var retry Backoff
+
+	now := time.Now()
+
+	if !retry.Ready(now) {
+		t.Error("Expected ready before any failures")
+	}
+
+	retry.Policy = Policy{Initial: time.Second, Max: time.Minute, Multiplier: 2}
+	retry.Failure(now)
+	retry.Failure(now)
+
+	if retry.Ready(now.Add(time.Second)) || !retry.Ready(now.Add(2*time.Second)) {
+		t.Error("Expected ready only after 2 seconds")
+	}
+
+	retry.Success()
+
+	if !retry.Ready(now) || retry.Failures() != 0 {
+		t.Error("Expected ready after success")
+	}
+}
+
+func checkDelay(t *testing.T, expected time.Duration, actual time.Duration) {
+	t.Helper()
+
+	if actual != expected {
+		t.Errorf("Expected delay %v but got %v", expected, actual)
+	}
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"time"
+)
+
+// Info returns the server's statistics, space separated name=value fields, reported by the info command.
+func (c *Client) Info(ctx context.Context) (string, error) {
+	return c.callValue(ctx, "inf")
+}
+
+// Topology returns how the server assigns keys to servers, space separated name=value fields, reported by
+// the topology command.
+func (c *Client) Topology(ctx context.Context) (string, error) {
+	return c.callValue(ctx, "top")
+}
+
+// Clients returns a description of each client connected to the server, in the order they connected.
+func (c *Client) Clients(ctx context.Context) ([]string, error) {
+	return c.callList(ctx, "cls")
+}
+
+// KillClient closes the server's client connection with the id, as listed by Clients.
+func (c *Client) KillClient(ctx context.Context, id int) error {
+	return c.retried(ctx, false, func() error {
+		return c.call(ctx, "clk"+formatArgument(strconv.Itoa(id)), c.acknowledged)
+	})
+}
+
+// SetReadOnly turns the server's read-only mode on or off, in which writes from clients are rejected.
+func (c *Client) SetReadOnly(ctx context.Context, readOnly bool) error {
+	mode := "off"
+	if readOnly {
+		mode = "on"
+	}
+
+	return c.retried(ctx, true, func() error {
+		return c.call(ctx, "rdo"+formatArgument(mode), c.acknowledged)
+	})
+}
+
+// FlushAll removes every key from the server, if it allows the flush all command.
+func (c *Client) FlushAll(ctx context.Context) error {
+	return c.retried(ctx, true, func() error {
+		return c.call(ctx, "fla", c.acknowledged)
+	})
+}
+
+// Record is a key the server holds, as sent in a snapshot: its type, then a string's value, or a
+// collection's contents encoded as JSON, such as a hash's object of fields and values or a list's array of
+// elements, and when it expires, zero if never.
+type Record struct {
+	Key      string          `json:"key"`
+	Type     string          `json:"type"`
+	Value    string          `json:"value,omitempty"`
+	Contents json.RawMessage `json:"contents,omitempty"`
+	Expiry   time.Time       `json:"expiry"`
+}
+
+// Snapshot calls fn with every key the server holds, in key order, fetched a chunk of count keys at a time
+// (the server's default if zero) and each chunk checked against its checksum, until fn returns an error,
+// which is returned. Unlike the other calls, it must be sent to the server's peer address, with the peer
+// secret as the token if the servers have one.
+func (c *Client) Snapshot(ctx context.Context, count int, fn func(record Record) error) error {
+	after := ""
+
+	for {
+		items, err := c.callList(ctx, "snc"+formatArgument(after)+formatArgument(strconv.Itoa(count)))
+		if err != nil {
+			return err
+		}
+
+		if len(items) == 0 {
+			return fmt.Errorf("%w: snapshot chunk without a checksum", errUnexpectedResponse)
+		}
+
+		if checksum := chunkChecksum(items[1:]); checksum != items[0] {
+			return fmt.Errorf("%w: snapshot chunk checksum %s, expected %s", errUnexpectedResponse, checksum,
+				items[0])
+		}
+
+		if len(items) == 1 {
+			return nil
+		}
+
+		for _, item := range items[1:] {
+			var record Record
+
+			if err := json.Unmarshal([]byte(item), &record); err != nil {
+				return fmt.Errorf("%w: snapshot key %s", errUnexpectedResponse, item)
+			}
+
+			if err := fn(record); err != nil {
+				return err
+			}
+
+			after = record.Key
+		}
+	}
+}
+
+// chunkChecksum returns the checksum the server sends with a chunk of a snapshot, of its keys, each a
+// record encoded as JSON.
+func chunkChecksum(items []string) string {
+	hash := crc32.NewIEEE()
+
+	for _, item := range items {
+		_, _ = hash.Write([]byte(formatArgument(item)))
+	}
+
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
+
+// callValue sends a command whose response is a single value, retried if it fails transiently.
+func (c *Client) callValue(ctx context.Context, command string) (string, error) {
+	var value string
+
+	err := c.retried(ctx, true, func() error {
+		return c.call(ctx, command, func(response string) error {
+			if response != valueResponse {
+				return c.unexpected(response)
+			}
+
+			var err error
+			value, err = readArgument(c.reader)
+
+			return err
+		})
+	})
+
+	return value, err
+}
+
+// callList sends a command whose response is a list, retried if it fails transiently.
+func (c *Client) callList(ctx context.Context, command string) ([]string, error) {
+	var items []string
+
+	err := c.retried(ctx, true, func() error {
+		return c.call(ctx, command, func(response string) error {
+			if response != listResponse {
+				return c.unexpected(response)
+			}
+
+			var err error
+			items, err = readList(c.reader)
+
+			return err
+		})
+	})
+
+	return items, err
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/server"
+	"testing"
+)
+
+func Test_Client_Admin(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := Dial(ctx, startServer(t, server.Config{AllowFlushAll: true}), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	if info, err := c.Info(ctx); err != nil || !strings.Contains(info, "read_only=false") {
+		t.Errorf("Expected info, not read-only, but got %s, %v", info, err)
+	}
+
+	if topology, err := c.Topology(ctx); err != nil || !strings.Contains(topology, "replicas=0") {
+		t.Errorf("Expected topology, not sharded, but got %s, %v", topology, err)
+	}
+
+	clients, err := c.Clients(ctx)
+	if err != nil || len(clients) != 1 {
+		t.Fatalf("Expected this client listed but got %v, %v", clients, err)
+	}
+
+	if err := c.SetReadOnly(ctx, true); err != nil {
+		t.Fatal("Unexpected read-only error: ", err)
+	}
+
+	if err := c.Put(ctx, "a", "1"); err == nil {
+		t.Error("Expected put rejected in read-only mode")
+	}
+
+	if err := c.SetReadOnly(ctx, false); err != nil {
+		t.Fatal("Unexpected read-only error: ", err)
+	}
+
+	if err := c.Put(ctx, "a", "1"); err != nil {
+		t.Fatal("Unexpected put error: ", err)
+	}
+
+	if description, found, err := c.Inspect(ctx, "a"); err != nil || !found ||
+		description != "type=string size=1 ttl_ms=-1 version=0" {
+		t.Errorf("Expected a string of 1 byte but got %s, %t, %v", description, found, err)
+	}
+
+	if err := c.FlushAll(ctx); err != nil {
+		t.Fatal("Unexpected flush all error: ", err)
+	}
+
+	if _, found, err := c.Get(ctx, "a"); err != nil || found {
+		t.Errorf("Expected key flushed but got %t, %v", found, err)
+	}
+
+	if err := c.KillClient(ctx, 999); err == nil {
+		t.Error("Expected error killing unknown client")
+	}
+}
+
+func Test_Client_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	store := kvstore.NewKVStore()
+
+	var expected []Record
+
+	for i := 0; i < 25; i++ {
+		key := "key" + strconv.Itoa(100+i)
+		expected = append(expected, Record{Key: key, Type: "string", Value: strconv.Itoa(i)})
+
+		store.Write(key, strconv.Itoa(i))
+	}
+
+	// collections are included too
+	_ = store.ListPush("list", "a", false)
+	expected = append(expected, Record{Key: "list", Type: "list", Contents: json.RawMessage(`["a"]`)})
+
+	srv := server.NewServer(store, server.Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		PeerSecret:         "secret",
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(ctx)
+
+	c, err := Dial(ctx, srv.PeerAddr().String(), Options{Token: "secret"})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	var snapshot []Record
+
+	err = c.Snapshot(ctx, 10, func(record Record) error {
+		snapshot = append(snapshot, record)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(snapshot, expected) {
+		t.Errorf("Expected every key but got %v, %v", snapshot, err)
+	}
+}
@@ -0,0 +1,464 @@
+// Package client is a Go client for the key value store, sending commands to a server's client port.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"tcp/pkg/backoff"
+	"time"
+)
+
+const (
+	unixScheme     = "unix://"
+	defaultTimeout = 5 * time.Second
+
+	// commands larger than this are sent while reading their responses
+	concurrentWriteSize = 64 << 10
+)
+
+// ErrNotReplicated is returned by writes the server applied locally only, since its peers were unreachable.
+var ErrNotReplicated = errors.New("write not replicated")
+
+// KV is the key value store, however it is reached: implemented by Client, Cluster, Failover and Mock, so
+// applications can depend on it and be unit tested with a Mock.
+type KV interface {
+	// Get returns the value of the key, or false if the key isn't set.
+	Get(ctx context.Context, key string) (string, bool, error)
+
+	// Put sets the value of the key.
+	Put(ctx context.Context, key string, value string) error
+
+	// Delete removes the key.
+	Delete(ctx context.Context, key string) error
+
+	// Close releases the connections, after which every call returns ErrClosed.
+	Close() error
+}
+
+// Options holds the client settings, the zero value of each giving its default.
+type Options struct {
+	// how long a call waits for the server, unless its context has an earlier deadline (defaults to 5s)
+	Timeout time.Duration
+
+	// how many times a call failing transiently, e.g. the server being busy, is retried (defaults to 0, never)
+	Retries int
+
+	// the delay before each retry (defaults to backoff.DefaultPolicy)
+	Backoff backoff.Policy
+
+	// how often a Cluster fetches the topology from the servers (defaults to 30s)
+	RefreshInterval time.Duration
+
+	// if not nil, connections use TLS, verifying the server's certificate is for the hostname in its
+	// address unless ServerName is set
+	TLS *tls.Config
+
+	// if set, sent by the auth command as soon as each connection is opened, identifying the user to
+	// servers with an access control list
+	Token string
+}
+
+// withDefaults returns the options, with the default of each not set.
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+
+	if o.RefreshInterval <= 0 {
+		o.RefreshInterval = defaultRefreshInterval
+	}
+
+	return o
+}
+
+// Client sends commands to a single server over one connection, one at a time, reconnecting on the next call
+// after a call fails. Gets failing transiently are retried, as are writes made with a context marked by
+// Idempotent, if retries are configured. It is safe for concurrent use.
+type Client struct {
+	address string
+	options Options
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	closed bool
+}
+
+// Dial connects to the server's client address, either hostname:port or unix:///path/to/socket.
+func Dial(ctx context.Context, address string, options Options) (*Client, error) {
+	c := &Client{address: address, options: options.withDefaults()}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get returns the value of the key, or false if the key isn't set.
+func (c *Client) Get(ctx context.Context, key string) (string, bool, error) {
+	var value string
+
+	found := false
+
+	err := c.retried(ctx, true, func() error {
+		return c.call(ctx, getCommand(key), c.value(&value, &found))
+	})
+
+	return value, found, err
+}
+
+// Inspect describes the key's value as space separated name=value fields, like Info: its type, size (the
+// bytes of a string, or elements of a collection), TTL in milliseconds (-1 if it never expires) and version,
+// the timestamp of its last write if the server resolves conflicting writes by last write wins, otherwise 0.
+// Returns false if the key isn't set.
+func (c *Client) Inspect(ctx context.Context, key string) (string, bool, error) {
+	var description string
+
+	found := false
+
+	err := c.retried(ctx, true, func() error {
+		return c.call(ctx, "typ"+formatArgument(key), c.value(&description, &found))
+	})
+
+	return description, found, err
+}
+
+// value receives the response to a get, setting the value and whether it was found.
+func (c *Client) value(value *string, found *bool) func(response string) error {
+	return func(response string) error {
+		*found = false
+
+		switch response {
+		case valueResponse:
+			*found = true
+
+			var err error
+			*value, err = readArgument(c.reader)
+
+			return err
+		case nilResponse:
+			return nil
+		default:
+			return c.unexpected(response)
+		}
+	}
+}
+
+// Put sets the value of the key, returning ErrNotReplicated if the server couldn't replicate it to its peers.
+func (c *Client) Put(ctx context.Context, key string, value string) error {
+	return c.write(ctx, putCommand(key, value))
+}
+
+// Delete removes the key, returning ErrNotReplicated if the server couldn't replicate it to its peers.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.write(ctx, deleteCommand(key))
+}
+
+// Ping checks the server is responding.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.retried(ctx, true, func() error {
+		return c.call(ctx, "png", func(response string) error {
+			if response != pongResponse[:3] {
+				return c.unexpected(response)
+			}
+
+			_, err := readString(c.reader, len(pongResponse)-3)
+
+			return err
+		})
+	})
+}
+
+// write sends a put or delete, retried if the context was marked by Idempotent.
+func (c *Client) write(ctx context.Context, command string) error {
+	return c.retried(ctx, isIdempotent(ctx), func() error {
+		return c.call(ctx, command, c.acknowledged)
+	})
+}
+
+// Close tells the server the client is finished, then closes the connection.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+
+	if c.conn == nil {
+		return nil
+	}
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.options.Timeout))
+	_, _ = c.conn.Write([]byte("bye"))
+
+	return c.disconnect()
+}
+
+// connect opens the connection, if not already open.
+func (c *Client) connect(ctx context.Context) error {
+	if c.closed {
+		return ErrClosed
+	}
+
+	if c.conn != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+	defer cancel()
+
+	network, address := "tcp4", c.address
+	if strings.HasPrefix(address, unixScheme) {
+		network, address = "unix", strings.TrimPrefix(address, unixScheme)
+	}
+
+	conn, err := c.dial(ctx, network, address)
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s: %w", c.address, err)
+	}
+
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	if c.options.Token != "" {
+		if err := c.authenticate(ctx); err != nil {
+			_ = c.disconnect()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dial opens the connection, using TLS if configured.
+func (c *Client) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	if c.options.TLS != nil {
+		dialer := tls.Dialer{Config: c.options.TLS}
+
+		return dialer.DialContext(ctx, network, address) //nolint:wrapcheck // callers wrap errors
+	}
+
+	var dialer net.Dialer
+
+	return dialer.DialContext(ctx, network, address) //nolint:wrapcheck // callers wrap errors
+}
+
+// authenticate sends the auth command with the token, before any other command on a new connection.
+func (c *Client) authenticate(ctx context.Context) error {
+	deadline, _ := ctx.Deadline()
+
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("unable to set deadline: %w", err)
+	}
+
+	if err := send(c.conn, "auth"+formatArgument(c.options.Token)); err != nil {
+		return err
+	}
+
+	if err := c.readResponse(c.acknowledged); err != nil {
+		return fmt.Errorf("unable to authenticate: %w", err)
+	}
+
+	return nil
+}
+
+// disconnect closes the connection, for the next call to reconnect.
+func (c *Client) disconnect() error {
+	err := c.conn.Close()
+	c.conn, c.reader = nil, nil
+
+	if err != nil {
+		return fmt.Errorf("error closing connection: %w", err)
+	}
+
+	return nil
+}
+
+// call sends the command and reads the response, calling receive with the first 3 characters of the
+// response to read the rest. The call is bounded by the context's deadline, or the client timeout if
+// sooner, and abandoned if the context is cancelled, after which the connection can't be reused.
+func (c *Client) call(ctx context.Context, command string, receive func(response string) error) error {
+	read := func() error {
+		return c.readResponse(receive)
+	}
+
+	err := c.exchange(ctx, command, read)
+
+	// the server closed the connection while it was idle, e.g. restarting, so send the command again on a
+	// new connection
+	if errors.Is(err, errStaleConnection) {
+		err = c.exchange(ctx, command, read)
+	}
+
+	return err
+}
+
+// exchange sends the commands, then calls read to read their responses, bounded by the context like call.
+// Commands over concurrentWriteSize are sent while reading the responses, so neither the client nor the
+// server blocks on a full socket buffer.
+func (c *Client) exchange(ctx context.Context, commands string, read func() error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("call not sent: %w", err)
+	}
+
+	reused := c.conn != nil
+
+	if err := c.connect(ctx); err != nil {
+		if errors.Is(err, ErrClosed) {
+			return err
+		}
+
+		return c.failed(ctx, fmt.Errorf("%w: %w", errNotSent, err))
+	}
+
+	deadline := time.Now().Add(c.options.Timeout)
+	if contextDeadline, set := ctx.Deadline(); set && contextDeadline.Before(deadline) {
+		deadline = contextDeadline
+	}
+
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return c.failed(ctx, fmt.Errorf("unable to set deadline: %w", err))
+	}
+
+	conn := c.conn
+
+	// unblock the read or write in progress when the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	written := make(chan error, 1)
+
+	if len(commands) > concurrentWriteSize {
+		go func() {
+			written <- send(conn, commands)
+		}()
+	} else {
+		if err := send(conn, commands); err != nil {
+			if reused {
+				err = fmt.Errorf("%w: %w", errStaleConnection, err)
+			}
+
+			return c.failed(ctx, err)
+		}
+
+		written <- nil
+	}
+
+	err := read()
+
+	if writeErr := <-written; writeErr != nil {
+		return c.failed(ctx, writeErr)
+	}
+
+	if err != nil && !responded(err) {
+		return c.failed(ctx, err)
+	}
+
+	return err
+}
+
+// send writes the commands to the connection. The server only performs complete commands, so a command
+// that couldn't be written wasn't performed.
+func send(conn net.Conn, commands string) error {
+	if _, err := conn.Write([]byte(commands)); err != nil {
+		return fmt.Errorf("%w: error sending command: %w", errNotSent, err)
+	}
+
+	return nil
+}
+
+// readResponse reads a response, calling receive with its first 3 characters to read the rest.
+func (c *Client) readResponse(receive func(response string) error) error {
+	response, err := readString(c.reader, 3)
+	if err != nil {
+		return err
+	}
+
+	return c.received(response, receive)
+}
+
+// received handles the responses any command can receive, otherwise calls receive.
+func (c *Client) received(response string, receive func(response string) error) error {
+	switch response {
+	case errorResponse:
+		return readError(c.reader)
+	case movedResponse, askResponse:
+		address, err := readArgument(c.reader)
+		if err != nil {
+			return err
+		}
+
+		return &Redirect{Address: address, Moved: response == movedResponse}
+	case busyResponse:
+		return ErrBusy
+	case throttleResponse:
+		return ErrThrottled
+	default:
+		return receive(response)
+	}
+}
+
+// responded returns whether the call failed with a complete response from the server, so the connection can
+// still be used.
+func responded(err error) bool {
+	var serverError *Error
+
+	var redirect *Redirect
+
+	return errors.As(err, &serverError) || errors.As(err, &redirect) || errors.Is(err, ErrNotReplicated) ||
+		errors.Is(err, ErrThrottled)
+}
+
+// acknowledged receives the response to a write.
+func (c *Client) acknowledged(response string) error {
+	switch response {
+	case ackResponse:
+		return nil
+	case warningResponse:
+		return ErrNotReplicated
+	default:
+		return c.unexpected(response)
+	}
+}
+
+func (c *Client) unexpected(response string) error {
+	return fmt.Errorf("%w: %s", errUnexpectedResponse, response)
+}
+
+// failed closes the connection after a call failed part way through, since the response may yet arrive,
+// returning the context's error if the call was abandoned because of it, or the deadline exceeded error
+// if the call timed out.
+func (c *Client) failed(ctx context.Context, err error) error {
+	if c.conn != nil {
+		_ = c.disconnect()
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("call abandoned: %w", ctx.Err())
+	}
+
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+	}
+
+	return err
+}
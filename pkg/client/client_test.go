@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/server"
+	"testing"
+	"time"
+)
+
+// startServer starts a server on a random port, returning its client address.
+func startServer(t *testing.T, config server.Config) string {
+	t.Helper()
+
+	config.ServerHostnamePort = "127.0.0.1:0"
+	config.PeerHostnamePort = "127.0.0.1:0"
+
+	srv := server.NewServer(kvstore.NewKVStore(), config)
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	return srv.ClientAddr().String()
+}
+
+// startSilentServer starts a listener that accepts connections but never responds.
+func startSilentServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func Test_Client(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := Dial(ctx, startServer(t, server.Config{}), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	if err := c.Ping(ctx); err != nil {
+		t.Error("Unexpected ping error: ", err)
+	}
+
+	if err := c.Put(ctx, "a", "123"); err != nil {
+		t.Error("Unexpected put error: ", err)
+	}
+
+	if value, found, err := c.Get(ctx, "a"); value != "123" || !found || err != nil {
+		t.Errorf("Expected 123 but got %s, %v, %v", value, found, err)
+	}
+
+	if err := c.Delete(ctx, "a"); err != nil {
+		t.Error("Unexpected delete error: ", err)
+	}
+
+	if _, found, err := c.Get(ctx, "a"); found || err != nil {
+		t.Errorf("Expected a to be deleted but got %v, %v", found, err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Error("Unexpected close error: ", err)
+	}
+
+	if err := c.Ping(ctx); !errors.Is(err, ErrClosed) {
+		t.Error("Expected closed error but got: ", err)
+	}
+}
+
+func Test_Client_MultiByte(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := Dial(ctx, startServer(t, server.Config{}), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	values := map[string]string{"键": "值", "😀": "🎉🎉", "mixed é": "naïve 日本語 ✓", "a": ""}
+
+	for key, value := range values {
+		if err := c.Put(ctx, key, value); err != nil {
+			t.Error("Unexpected put error: ", err)
+		}
+
+		if actual, found, err := c.Get(ctx, key); actual != value || !found || err != nil {
+			t.Errorf("Expected %s for %s but got %s, %v, %v", value, key, actual, found, err)
+		}
+	}
+}
+
+func Test_Client_ServerError(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := Dial(ctx, startServer(t, server.Config{ReadOnly: true}), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	var serverError *Error
+	if err := c.Put(ctx, "a", "123"); !errors.As(err, &serverError) || serverError.Code != "500" {
+		t.Fatal("Expected read only error but got: ", err)
+	}
+
+	// the connection is still usable
+	if err := c.Ping(ctx); err != nil {
+		t.Error("Unexpected ping error: ", err)
+	}
+}
+
+func Test_Client_ContextDeadline(t *testing.T) {
+	c, err := Dial(context.Background(), startSilentServer(t), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if _, _, err := c.Get(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected deadline exceeded but got: ", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the call to give up at its deadline but took %v", elapsed)
+	}
+}
+
+func Test_Client_Timeout(t *testing.T) {
+	c, err := Dial(context.Background(), startSilentServer(t), Options{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	if err := c.Ping(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected deadline exceeded but got: ", err)
+	}
+}
+
+func Test_Client_Cancelled(t *testing.T) {
+	c, err := Dial(context.Background(), startSilentServer(t), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if err := c.Put(ctx, "a", "123"); !errors.Is(err, context.Canceled) {
+		t.Error("Expected cancelled but got: ", err)
+	}
+
+	if err := c.Put(ctx, "a", "123"); !errors.Is(err, context.Canceled) {
+		t.Error("Expected cancelled without sending but got: ", err)
+	}
+}
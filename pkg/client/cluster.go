@@ -0,0 +1,332 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"tcp/pkg/hashring"
+	"time"
+)
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+
+	// the most redirects followed by each call, in case servers disagree about who holds a key
+	maxRedirects = 5
+)
+
+var (
+	errNoServers       = errors.New("no servers known")
+	errInvalidTopology = errors.New("invalid topology")
+)
+
+// topology is how the servers assign keys to each other, reported by the topology command.
+type topology struct {
+	// nil if the keys aren't sharded, each server holding every key
+	ring *hashring.Ring
+
+	// the client address of each server on the ring, by server, if known
+	addresses map[string]string
+}
+
+// parseTopology parses the response to the topology command, space-separated name=value pairs.
+func parseTopology(text string) (*topology, error) {
+	t := &topology{addresses: make(map[string]string)}
+
+	var servers []string
+
+	replicas, virtualNodes := 0, 0
+
+	for _, field := range strings.Fields(text) {
+		name, value, found := strings.Cut(field, "=")
+		if !found {
+			return nil, fmt.Errorf("%w: %s", errInvalidTopology, field)
+		}
+
+		var err error
+
+		switch {
+		case name == "replicas":
+			replicas, err = strconv.Atoi(value)
+		case name == "virtual_nodes":
+			virtualNodes, err = strconv.Atoi(value)
+		case strings.HasPrefix(name, "server."):
+			server := strings.TrimPrefix(name, "server.")
+			servers = append(servers, server)
+
+			if value != "" {
+				t.addresses[server] = value
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidTopology, field)
+		}
+	}
+
+	if replicas > 0 {
+		t.ring = hashring.New(servers, replicas, virtualNodes)
+	}
+
+	return t, nil
+}
+
+// route returns the client addresses to send the commands for the key to, in order of preference: the
+// servers holding the key, then the others.
+func (t *topology) route(key string) []string {
+	var addresses []string
+
+	if t.ring != nil {
+		for _, owner := range t.ring.Owners(key) {
+			if address, found := t.addresses[owner]; found {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+
+	for _, address := range t.addresses {
+		if !contains(addresses, address) {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
+// Cluster sends the commands for each key straight to a server holding it, assigning keys to servers the
+// same way the servers do. The topology is fetched from the servers when dialled, then refreshed
+// periodically, and when a server redirects a command since the key has moved. It is safe for concurrent
+// use.
+type Cluster struct {
+	seeds   []string
+	options Options
+	clients *clientPool
+
+	mutex    sync.Mutex
+	topology *topology
+	closed   bool
+
+	refresh chan struct{}
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// DialCluster fetches the topology from the first of the seeds, the client addresses of one or more servers,
+// that responds.
+func DialCluster(ctx context.Context, seeds []string, options Options) (*Cluster, error) {
+	options = options.withDefaults()
+
+	c := &Cluster{
+		seeds:    seeds,
+		options:  options,
+		clients:  newClientPool(options),
+		topology: &topology{},
+		refresh:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	if err := c.Refresh(ctx); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	c.stopped.Add(1)
+
+	go c.refreshPeriodically()
+
+	return c, nil
+}
+
+// Get returns the value of the key, or false if the key isn't set.
+func (c *Cluster) Get(ctx context.Context, key string) (string, bool, error) {
+	var value string
+
+	found := false
+
+	err := c.do(ctx, key, func(client *Client) error {
+		var err error
+		value, found, err = client.Get(ctx, key)
+
+		return err
+	})
+
+	return value, found, err
+}
+
+// Put sets the value of the key, returning ErrNotReplicated if the server couldn't replicate it to its peers.
+func (c *Cluster) Put(ctx context.Context, key string, value string) error {
+	return c.do(ctx, key, func(client *Client) error {
+		return client.Put(ctx, key, value)
+	})
+}
+
+// Delete removes the key, returning ErrNotReplicated if the server couldn't replicate it to its peers.
+func (c *Cluster) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, key, func(client *Client) error {
+		return client.Delete(ctx, key)
+	})
+}
+
+// Refresh fetches the topology from the first server that responds.
+func (c *Cluster) Refresh(ctx context.Context) error {
+	err := errNoServers
+
+	for _, address := range c.known() {
+		var t *topology
+
+		if t, err = c.fetchTopology(ctx, address); err == nil {
+			c.setTopology(t)
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unable to refresh topology: %w", err)
+}
+
+// Close stops refreshing the topology, and closes the connection to each server.
+func (c *Cluster) Close() error {
+	c.mutex.Lock()
+
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	c.mutex.Unlock()
+
+	close(c.done)
+	c.stopped.Wait()
+
+	return c.clients.close()
+}
+
+// do makes the call to a server holding the key, following any redirects.
+func (c *Cluster) do(ctx context.Context, key string, call func(client *Client) error) error {
+	client, err := c.owner(ctx, key)
+
+	for redirects := 0; err == nil; redirects++ {
+		err = call(client)
+
+		var redirect *Redirect
+		if !errors.As(err, &redirect) || redirects == maxRedirects {
+			return err
+		}
+
+		if redirect.Moved {
+			c.refreshSoon()
+		}
+
+		client, err = c.clients.get(ctx, redirect.Address)
+	}
+
+	return err
+}
+
+// owner returns the client of the first server holding the key that can be connected to, otherwise any
+// other server.
+func (c *Cluster) owner(ctx context.Context, key string) (*Client, error) {
+	c.mutex.Lock()
+	addresses := c.topology.route(key)
+	c.mutex.Unlock()
+
+	err := errNoServers
+
+	for _, address := range append(addresses, c.seeds...) {
+		var client *Client
+
+		if client, err = c.clients.get(ctx, address); err == nil {
+			return client, nil
+		}
+	}
+
+	return nil, err
+}
+
+// known returns the client addresses of every server known, those in the topology, then the seeds.
+func (c *Cluster) known() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	addresses := c.topology.route("")
+
+	for _, seed := range c.seeds {
+		if !contains(addresses, seed) {
+			addresses = append(addresses, seed)
+		}
+	}
+
+	return addresses
+}
+
+// fetchTopology asks the server for the topology.
+func (c *Cluster) fetchTopology(ctx context.Context, address string) (*topology, error) {
+	client, err := c.clients.get(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := client.Topology(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTopology(text)
+}
+
+// setTopology replaces the topology, closing the connections to servers no longer in it.
+func (c *Cluster) setTopology(t *topology) {
+	c.mutex.Lock()
+	c.topology = t
+	c.mutex.Unlock()
+
+	addresses := t.route("")
+
+	c.clients.prune(func(address string) bool {
+		return contains(addresses, address) || contains(c.seeds, address)
+	})
+}
+
+// refreshSoon asks for the topology to be refreshed, without waiting for it.
+func (c *Cluster) refreshSoon() {
+	select {
+	case c.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// refreshPeriodically refreshes the topology every refresh interval, or sooner when asked, until closed.
+func (c *Cluster) refreshPeriodically() {
+	defer c.stopped.Done()
+
+	ticker := time.NewTicker(c.options.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		case <-c.refresh:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.options.Timeout)
+		_ = c.Refresh(ctx)
+
+		cancel()
+	}
+}
+
+func contains(list []string, item string) bool {
+	for _, listItem := range list {
+		if listItem == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/server"
+	"testing"
+	"time"
+)
+
+// startShardedServers starts two servers, each holding half the keys, returning their client addresses
+// and stores.
+func startShardedServers(t *testing.T) ([]string, []*kvstore.KVStore) {
+	t.Helper()
+
+	dir := t.TempDir()
+	clientAddresses := server.ClientAddresses{}
+
+	var peers, addresses []string
+
+	for _, name := range []string{"server1", "server2"} {
+		peer := "unix://" + filepath.Join(dir, name+"-peer")
+		peers = append(peers, peer)
+		addresses = append(addresses, "unix://"+filepath.Join(dir, name+"-client"))
+		clientAddresses[peer] = addresses[len(addresses)-1]
+	}
+
+	var stores []*kvstore.KVStore
+
+	for i := range peers {
+		store := kvstore.NewKVStore()
+		stores = append(stores, store)
+
+		srv := server.NewServer(store, server.Config{
+			ServerHostnamePort: addresses[i],
+			PeerHostnamePort:   peers[i],
+			OtherServers:       []string{peers[1-i]},
+			ShardReplicas:      1,
+			ClientAddresses:    clientAddresses,
+		})
+
+		if err := srv.Listen(); err != nil {
+			t.Fatal("Unable to listen: ", err)
+		}
+
+		go func() {
+			_ = srv.Serve()
+		}()
+
+		t.Cleanup(func() {
+			_ = srv.Shutdown(context.Background())
+		})
+	}
+
+	return addresses, stores
+}
+
+// checkSplit checks the keys are split between the servers' stores, each held by one only.
+func checkSplit(t *testing.T, stores []*kvstore.KVStore, keys int) {
+	t.Helper()
+
+	first, second := kvstore.Count(stores[0]), kvstore.Count(stores[1])
+
+	if first == 0 || second == 0 || first+second != keys {
+		t.Errorf("Expected %d keys split between the servers but got %d and %d", keys, first, second)
+	}
+}
+
+func Test_parseTopology(t *testing.T) {
+	topology, err := parseTopology("replicas=1 virtual_nodes=16 server.server1=client1:8001 server.server2=")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if topology.ring == nil || len(topology.addresses) != 1 || topology.addresses["server1"] != "client1:8001" {
+		t.Errorf("Unexpected topology %+v", topology)
+	}
+
+	// keys held by server2 are sent to server1, the only address known
+	for i := 0; i < 10; i++ {
+		if route := topology.route(fmt.Sprintf("key%d", i)); len(route) != 1 || route[0] != "client1:8001" {
+			t.Errorf("Expected route to client1:8001 but got %v", route)
+		}
+	}
+
+	if unsharded, _ := parseTopology("replicas=0 server.server1=client1:8001"); unsharded.ring != nil {
+		t.Error("Expected keys not to be sharded")
+	}
+
+	if _, err := parseTopology("replicas=x"); err == nil {
+		t.Error("Expected an error for invalid replicas")
+	}
+}
+
+func Test_Cluster(t *testing.T) {
+	addresses, stores := startShardedServers(t)
+	ctx := context.Background()
+
+	c, err := DialCluster(ctx, addresses[:1], Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+
+		if err := c.Put(ctx, key, key); err != nil {
+			t.Fatalf("Unexpected error putting %s: %v", key, err)
+		}
+
+		if value, found, err := c.Get(ctx, key); value != key || !found || err != nil {
+			t.Errorf("Expected %s but got %s, %v, %v", key, value, found, err)
+		}
+	}
+
+	// each key sent straight to the server holding it
+	checkSplit(t, stores, 20)
+}
+
+func Test_Cluster_Moved(t *testing.T) {
+	addresses, stores := startShardedServers(t)
+	ctx := context.Background()
+
+	c, err := DialCluster(ctx, addresses[:1], Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	// a stale topology, sending every key to server1
+	c.mutex.Lock()
+	c.topology = &topology{addresses: map[string]string{"server1": addresses[0]}}
+	c.mutex.Unlock()
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+
+		if err := c.Put(ctx, key, key); err != nil {
+			t.Fatalf("Unexpected error putting %s: %v", key, err)
+		}
+	}
+
+	// the keys held by server2 redirected there
+	checkSplit(t, stores, 20)
+
+	// the redirects refresh the topology
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		c.mutex.Lock()
+		refreshed := c.topology.ring != nil
+		c.mutex.Unlock()
+
+		if refreshed {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the topology to be refreshed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrClosed is returned by calls made after the client was closed.
+	ErrClosed = errors.New("client closed")
+
+	// ErrBusy is returned when the server refused the connection, having too many connections.
+	ErrBusy = errors.New("server busy")
+
+	// ErrThrottled is returned when the server rejected the command, the client having exceeded its rate limit.
+	ErrThrottled = errors.New("rate limit exceeded")
+)
+
+// Redirect is returned when the server didn't perform the command, since it doesn't hold the key or is shutting
+// down, giving the client address of a server to send the command to instead.
+type Redirect struct {
+	Address string
+
+	// whether the key has moved to the server for good, rather than just this time since the server is
+	// shutting down
+	Moved bool
+}
+
+func (r *Redirect) Error() string {
+	if r.Moved {
+		return "key moved to " + r.Address
+	}
+
+	return "ask " + r.Address
+}
+
+// Error is an error response from the server, with the 3 digit code of the reason the command failed, the
+// first digit giving the kind of error: 1 invalid command, 2 authentication, 3 timeout or replication, 4
+// rejected argument, 5 not allowed in the server's current mode.
+type Error struct {
+	Code   string
+	Reason string
+
+	// details following the reason, if any
+	Details string
+}
+
+func newError(code string, reason string) *Error {
+	name, details, _ := strings.Cut(reason, " ")
+
+	return &Error{Code: code, Reason: name, Details: details}
+}
+
+func (e *Error) Error() string {
+	if e.Details == "" {
+		return "server error " + e.Code + ": " + e.Reason
+	}
+
+	return "server error " + e.Code + ": " + e.Reason + " " + e.Details
+}
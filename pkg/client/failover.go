@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// the code of the error response rejecting a write sent to a replica, followed by the primary's client
+// address if the replica knows it
+const replicaCode = "501"
+
+// Failover sends commands to the primary, failing reads over to the replicas while the primary is
+// unreachable. Writes rejected by a replica are sent to the primary it names, and writes the primary
+// couldn't be sent are sent to each of the other servers in turn, until one accepts them as the new
+// primary. Writes that may have been applied before the primary failed are only sent elsewhere if made
+// with a context marked by Idempotent. It is safe for concurrent use.
+type Failover struct {
+	clients *clientPool
+
+	mutex   sync.Mutex
+	primary string
+	servers []string
+}
+
+// DialFailover connects to the primary, or to the first replica that responds if it can't, the addresses
+// being client addresses.
+func DialFailover(ctx context.Context, primary string, replicas []string, options Options) (*Failover, error) {
+	f := &Failover{
+		clients: newClientPool(options.withDefaults()),
+		primary: primary,
+		servers: append([]string{primary}, replicas...),
+	}
+
+	err := errNoServers
+
+	for _, address := range f.servers {
+		if _, err = f.clients.get(ctx, address); err == nil {
+			return f, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Get returns the value of the key, or false if the key isn't set.
+func (f *Failover) Get(ctx context.Context, key string) (string, bool, error) {
+	var value string
+
+	found := false
+
+	err := f.read(ctx, func(client *Client) error {
+		var err error
+		value, found, err = client.Get(ctx, key)
+
+		return err
+	})
+
+	return value, found, err
+}
+
+// Put sets the value of the key, returning ErrNotReplicated if the server couldn't replicate it to its peers.
+func (f *Failover) Put(ctx context.Context, key string, value string) error {
+	return f.write(ctx, func(client *Client) error {
+		return client.Put(ctx, key, value)
+	})
+}
+
+// Delete removes the key, returning ErrNotReplicated if the server couldn't replicate it to its peers.
+func (f *Failover) Delete(ctx context.Context, key string) error {
+	return f.write(ctx, func(client *Client) error {
+		return client.Delete(ctx, key)
+	})
+}
+
+// Primary returns the client address of the server currently sent writes.
+func (f *Failover) Primary() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.primary
+}
+
+// Close closes the connection to each server.
+func (f *Failover) Close() error {
+	return f.clients.close()
+}
+
+// read makes the call to the primary, then each replica in turn while the servers are unreachable.
+func (f *Failover) read(ctx context.Context, call func(client *Client) error) error {
+	err := errNoServers
+
+	for _, address := range f.candidates() {
+		if err = f.on(ctx, address, call); !unreachable(ctx, err, true) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// write makes the call to the primary, or if it isn't the primary any more, or can't be reached, to the
+// primary named by the replicas or each of the other servers, remembering the server that accepts it as
+// the primary.
+func (f *Failover) write(ctx context.Context, call func(client *Client) error) error {
+	candidates := f.candidates()
+	tried := make(map[string]bool)
+
+	err := errNoServers
+
+	for len(candidates) > 0 {
+		address := candidates[0]
+		candidates = candidates[1:]
+
+		if tried[address] {
+			continue
+		}
+
+		tried[address] = true
+
+		err = f.on(ctx, address, call)
+
+		var serverError *Error
+
+		switch {
+		case errors.As(err, &serverError) && serverError.Code == replicaCode:
+			if serverError.Details != "" {
+				candidates = append([]string{serverError.Details}, candidates...)
+			}
+
+		case unreachable(ctx, err, isIdempotent(ctx)):
+
+		default:
+			if err == nil || errors.Is(err, ErrNotReplicated) {
+				f.setPrimary(address)
+			}
+
+			return err
+		}
+	}
+
+	return err
+}
+
+// unreachable returns whether the call failed since the server couldn't be reached, so it can be made on
+// another server.
+func unreachable(ctx context.Context, err error, idempotent bool) bool {
+	return err != nil && ctx.Err() == nil && transient(err, idempotent)
+}
+
+// on makes the call on the server's client, connecting to it if not already connected.
+func (f *Failover) on(ctx context.Context, address string, call func(client *Client) error) error {
+	client, err := f.clients.get(ctx, address)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errNotSent, err)
+	}
+
+	return call(client)
+}
+
+// candidates returns the servers to send commands to, in order of preference: the primary, then the rest.
+func (f *Failover) candidates() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	candidates := []string{f.primary}
+
+	for _, server := range f.servers {
+		if server != f.primary {
+			candidates = append(candidates, server)
+		}
+	}
+
+	return candidates
+}
+
+// setPrimary records the server accepting writes, adding it to the servers if not already known.
+func (f *Failover) setPrimary(address string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.primary = address
+
+	if !contains(f.servers, address) {
+		f.servers = append(f.servers, address)
+	}
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/server"
+	"testing"
+)
+
+// startUnixServer starts a server listening on Unix domain sockets in the directory, returning the function
+// shutting it down.
+func startUnixServer(t *testing.T, dir string, name string, store *kvstore.KVStore, config server.Config) func() {
+	t.Helper()
+
+	config.ServerHostnamePort = "unix://" + filepath.Join(dir, name+"-client")
+	config.PeerHostnamePort = "unix://" + filepath.Join(dir, name+"-peer")
+
+	srv := server.NewServer(store, config)
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	var once sync.Once
+
+	stop := func() {
+		once.Do(func() {
+			_ = srv.Shutdown(context.Background())
+		})
+	}
+
+	t.Cleanup(stop)
+
+	return stop
+}
+
+func Test_Failover(t *testing.T) {
+	dir := t.TempDir()
+	primaryAddress := "unix://" + filepath.Join(dir, "primary-client")
+	replicaAddress := "unix://" + filepath.Join(dir, "replica-client")
+	stopReplica := startUnixServer(t, dir, "replica", kvstore.NewKVStore(), server.Config{
+		Role: server.RoleReplica, Primary: primaryAddress,
+	})
+
+	stopPrimary := startUnixServer(t, dir, "primary", kvstore.NewKVStore(), server.Config{
+		OtherServers: []string{"unix://" + filepath.Join(dir, "replica-peer")},
+	})
+
+	ctx := context.Background()
+
+	f, err := DialFailover(ctx, primaryAddress, []string{replicaAddress}, Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer f.Close()
+
+	if err := f.Put(ctx, "a", "123"); err != nil {
+		t.Fatal("Unexpected put error: ", err)
+	}
+
+	stopPrimary()
+
+	// reads fail over to the replica
+	if value, found, err := f.Get(ctx, "a"); value != "123" || !found || err != nil {
+		t.Errorf("Expected 123 from the replica but got %s, %v, %v", value, found, err)
+	}
+
+	// the replica names the old primary, so there is nowhere to write
+	var serverError *Error
+	if err := f.Put(ctx, "b", "456"); !errors.As(err, &serverError) || serverError.Code != replicaCode {
+		t.Error("Expected replica error but got: ", err)
+	}
+
+	// promote the replica
+	stopReplica()
+
+	promotedStore := kvstore.NewKVStore()
+	startUnixServer(t, dir, "replica", promotedStore, server.Config{})
+
+	if err := f.Put(ctx, "b", "456"); err != nil {
+		t.Fatal("Expected the write to go to the new primary but got: ", err)
+	}
+
+	if f.Primary() != replicaAddress {
+		t.Errorf("Expected the primary to be %s but got %s", replicaAddress, f.Primary())
+	}
+
+	if value, _ := kvstore.Read(promotedStore, "b"); value != "456" {
+		t.Errorf("Expected 456 written to the new primary but got %s", value)
+	}
+}
+
+func Test_Failover_Redirect(t *testing.T) {
+	dir := t.TempDir()
+	primaryAddress := "unix://" + filepath.Join(dir, "primary-client")
+	replicaAddress := "unix://" + filepath.Join(dir, "replica-client")
+	primaryStore := kvstore.NewKVStore()
+
+	startUnixServer(t, dir, "replica", kvstore.NewKVStore(), server.Config{
+		Role: server.RoleReplica, Primary: primaryAddress,
+	})
+
+	startUnixServer(t, dir, "primary", primaryStore, server.Config{})
+
+	ctx := context.Background()
+
+	// configured with the replica as the primary
+	f, err := DialFailover(ctx, replicaAddress, nil, Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer f.Close()
+
+	if err := f.Put(ctx, "a", "123"); err != nil {
+		t.Fatal("Expected the write redirected to the primary but got: ", err)
+	}
+
+	if value, _ := kvstore.Read(primaryStore, "a"); value != "123" || f.Primary() != primaryAddress {
+		t.Errorf("Expected 123 written to %s but got %s on %s", primaryAddress, value, f.Primary())
+	}
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"tcp/pkg/kvstore"
+)
+
+// Mock is an in-memory stand-in for a Client, holding the keys in a store of its own instead of sending
+// commands to a server, so applications depending on KV can be unit tested without sockets. It is safe
+// for concurrent use.
+type Mock struct {
+	store *kvstore.KVStore
+
+	mutex   sync.Mutex
+	watches map[*mockWatch]struct{}
+	closed  bool
+	done    chan struct{}
+}
+
+// mockWatch holds the changes to a watched key not yet sent to its channel.
+type mockWatch struct {
+	key string
+
+	mutex   sync.Mutex
+	pending []Event
+	ready   chan struct{}
+}
+
+// NewMock returns a mock with no keys set.
+func NewMock() *Mock {
+	m := &Mock{
+		store:   kvstore.NewKVStore(),
+		watches: make(map[*mockWatch]struct{}),
+		done:    make(chan struct{}),
+	}
+
+	kvstore.Observe(m.store, m.observe)
+
+	return m
+}
+
+// Get returns the value of the key, or false if the key isn't set.
+func (m *Mock) Get(ctx context.Context, key string) (string, bool, error) {
+	if err := m.check(ctx); err != nil {
+		return "", false, err
+	}
+
+	value, found := kvstore.Read(m.store, key)
+
+	return value, found, nil
+}
+
+// Put sets the value of the key.
+func (m *Mock) Put(ctx context.Context, key string, value string) error {
+	if err := m.check(ctx); err != nil {
+		return err
+	}
+
+	kvstore.Write(m.store, key, value)
+
+	return nil
+}
+
+// Delete removes the key.
+func (m *Mock) Delete(ctx context.Context, key string) error {
+	if err := m.check(ctx); err != nil {
+		return err
+	}
+
+	kvstore.Delete(m.store, key)
+
+	return nil
+}
+
+// Ping checks the mock isn't closed.
+func (m *Mock) Ping(ctx context.Context) error {
+	return m.check(ctx)
+}
+
+// Watch returns a channel receiving each change to the key, in the order the changes were made, until the
+// context is done or the mock is closed, when the channel is closed. Unlike a Client, no change is ever
+// dropped, however slowly the channel is read.
+func (m *Mock) Watch(ctx context.Context, key string) (<-chan Event, error) {
+	if err := m.check(ctx); err != nil {
+		return nil, err
+	}
+
+	w := &mockWatch{key: key, ready: make(chan struct{}, 1)}
+	events := make(chan Event)
+
+	m.mutex.Lock()
+	m.watches[w] = struct{}{}
+	m.mutex.Unlock()
+
+	go m.forward(ctx, w, events)
+
+	return events, nil
+}
+
+// WatchFunc calls fn with each change to the key, in the order the changes were made, like Watch, until
+// the context is done.
+func (m *Mock) WatchFunc(ctx context.Context, key string, fn func(event Event)) error {
+	events, err := m.Watch(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	for event := range events {
+		fn(event)
+	}
+
+	return fmt.Errorf("watch ended: %w", ctx.Err())
+}
+
+// Close discards the keys, after which every call returns ErrClosed.
+func (m *Mock) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return nil
+	}
+
+	m.closed = true
+	close(m.done)
+	kvstore.Close(m.store)
+
+	return nil
+}
+
+// check returns an error if the context is done or the mock is closed.
+func (m *Mock) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("call cancelled: %w", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// observe queues the change for each watch of its key. Called by the store for every change.
+func (m *Mock) observe(change kvstore.Change) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for w := range m.watches {
+		if w.key != change.Key {
+			continue
+		}
+
+		w.mutex.Lock()
+		w.pending = append(w.pending, Event{Key: change.Key, Value: change.Value, Deleted: change.Deleted})
+		w.mutex.Unlock()
+
+		select {
+		case w.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// forward sends the watch's changes to the channel as they are made, until the context is done or the
+// mock is closed.
+func (m *Mock) forward(ctx context.Context, w *mockWatch, events chan<- Event) {
+	defer close(events)
+
+	defer func() {
+		m.mutex.Lock()
+		delete(m.watches, w)
+		m.mutex.Unlock()
+	}()
+
+	for {
+		w.mutex.Lock()
+		pending := w.pending
+		w.pending = nil
+		w.mutex.Unlock()
+
+		for _, event := range pending {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			case <-m.done:
+				return
+			}
+		}
+
+		select {
+		case <-w.ready:
+		case <-ctx.Done():
+			return
+		case <-m.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// every way of reaching the store can be replaced by a mock
+var _ = []KV{&Client{}, &Cluster{}, &Failover{}, &Mock{}}
+
+func Test_Mock(t *testing.T) {
+	ctx := context.Background()
+
+	var kv KV = NewMock()
+
+	if err := kv.Put(ctx, "a", "123"); err != nil {
+		t.Error("Unexpected put error: ", err)
+	}
+
+	if value, found, err := kv.Get(ctx, "a"); value != "123" || !found || err != nil {
+		t.Errorf("Expected 123 but got %s, %v, %v", value, found, err)
+	}
+
+	if err := kv.Delete(ctx, "a"); err != nil {
+		t.Error("Unexpected delete error: ", err)
+	}
+
+	if _, found, err := kv.Get(ctx, "a"); found || err != nil {
+		t.Errorf("Expected a to be deleted but got %v, %v", found, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if err := kv.Put(cancelled, "a", "123"); !errors.Is(err, context.Canceled) {
+		t.Error("Expected cancelled but got: ", err)
+	}
+
+	if err := kv.Close(); err != nil {
+		t.Error("Unexpected close error: ", err)
+	}
+
+	if _, _, err := kv.Get(ctx, "a"); !errors.Is(err, ErrClosed) {
+		t.Error("Expected closed error but got: ", err)
+	}
+}
+
+func Test_Mock_Watch(t *testing.T) {
+	m := NewMock()
+	defer m.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	events, err := m.Watch(ctx, "a")
+	if err != nil {
+		t.Fatal("Unable to watch: ", err)
+	}
+
+	// changes are kept until read
+	_ = m.Put(context.Background(), "a", "123")
+	_ = m.Put(context.Background(), "b", "456")
+	_ = m.Delete(context.Background(), "a")
+
+	if event := nextEvent(t, events); event != (Event{Key: "a", Value: "123"}) {
+		t.Errorf("Expected a set to 123 but got %+v", event)
+	}
+
+	if event := nextEvent(t, events); event != (Event{Key: "a", Deleted: true}) {
+		t.Errorf("Expected a deleted but got %+v", event)
+	}
+
+	cancel()
+
+	for range events {
+		// drain until closed
+	}
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"strings"
+)
+
+// Result is the outcome of a command queued in a pipeline, set once the pipeline is executed.
+type Result struct {
+	// the value of the key and whether it was found, for gets
+	Value string
+	Found bool
+
+	// why the command failed, e.g. an Error response, or ErrNotReplicated for writes
+	Err error
+
+	command string
+	receive func(response string) error
+}
+
+// Pipeline queues commands to send to the server together in one write, reading their responses in
+// order, so many commands take a single round trip. It isn't safe for concurrent use.
+type Pipeline struct {
+	client  *Client
+	results []*Result
+}
+
+// Pipeline returns an empty pipeline, sending its commands over the client's connection.
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: c}
+}
+
+// Get queues getting the value of the key.
+func (p *Pipeline) Get(key string) *Result {
+	result := &Result{command: getCommand(key)}
+	result.receive = p.client.value(&result.Value, &result.Found)
+
+	return p.queue(result)
+}
+
+// Put queues setting the value of the key.
+func (p *Pipeline) Put(key string, value string) *Result {
+	return p.queue(&Result{command: putCommand(key, value), receive: p.client.acknowledged})
+}
+
+// Delete queues removing the key.
+func (p *Pipeline) Delete(key string) *Result {
+	return p.queue(&Result{command: deleteCommand(key), receive: p.client.acknowledged})
+}
+
+// Len returns how many commands are queued.
+func (p *Pipeline) Len() int {
+	return len(p.results)
+}
+
+// Exec sends the queued commands, then sets the result of each from its response, emptying the pipeline.
+// Returns an error if the responses couldn't all be read, e.g. the context's deadline was exceeded, in
+// which case the results not read have the same error, since those commands may or may not have been
+// performed. Pipelines aren't retried.
+func (p *Pipeline) Exec(ctx context.Context) error {
+	results := p.results
+	p.results = nil
+
+	if len(results) == 0 {
+		return nil
+	}
+
+	commands := make([]string, len(results))
+
+	for i, result := range results {
+		commands[i] = result.command
+	}
+
+	received := 0
+
+	err := p.client.exchange(ctx, strings.Join(commands, ""), func() error {
+		for _, result := range results {
+			result.Err = p.client.readResponse(result.receive)
+
+			if result.Err != nil && !responded(result.Err) {
+				return result.Err
+			}
+
+			received++
+		}
+
+		return nil
+	})
+
+	for _, result := range results[received:] {
+		result.Err = err
+	}
+
+	return err
+}
+
+func (p *Pipeline) queue(result *Result) *Result {
+	p.results = append(p.results, result)
+
+	return result
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"tcp/pkg/server"
+	"testing"
+	"time"
+)
+
+func Test_Pipeline(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := Dial(ctx, startServer(t, server.Config{}), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	pipeline := c.Pipeline()
+
+	puts := make([]*Result, 100)
+
+	for i := range puts {
+		puts[i] = pipeline.Put(fmt.Sprintf("key%d", i), strings.Repeat("x", 1000))
+	}
+
+	get := pipeline.Get("key1")
+	missing := pipeline.Get("missing")
+	deleted := pipeline.Delete("key2")
+
+	if pipeline.Len() != 103 {
+		t.Errorf("Expected 103 commands queued but got %d", pipeline.Len())
+	}
+
+	if err := pipeline.Exec(ctx); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	for i, put := range puts {
+		if put.Err != nil {
+			t.Errorf("Unexpected error putting key%d: %v", i, put.Err)
+		}
+	}
+
+	if get.Value != strings.Repeat("x", 1000) || !get.Found || get.Err != nil {
+		t.Errorf("Expected key1 found but got %d bytes, %v, %v", len(get.Value), get.Found, get.Err)
+	}
+
+	if missing.Found || missing.Err != nil {
+		t.Errorf("Expected missing not found but got %v, %v", missing.Found, missing.Err)
+	}
+
+	if deleted.Err != nil {
+		t.Error("Unexpected delete error: ", deleted.Err)
+	}
+
+	if pipeline.Len() != 0 {
+		t.Errorf("Expected the pipeline emptied but got %d commands", pipeline.Len())
+	}
+}
+
+func Test_Pipeline_ServerError(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := Dial(ctx, startServer(t, server.Config{ReadOnly: true}), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	pipeline := c.Pipeline()
+	put := pipeline.Put("a", "1")
+	get := pipeline.Get("a")
+
+	if err := pipeline.Exec(ctx); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// only the failed command has an error
+	var serverError *Error
+	if !errors.As(put.Err, &serverError) || serverError.Code != "500" {
+		t.Error("Expected read only error but got: ", put.Err)
+	}
+
+	if get.Found || get.Err != nil {
+		t.Errorf("Expected a not found but got %v, %v", get.Found, get.Err)
+	}
+}
+
+func Test_Pipeline_Timeout(t *testing.T) {
+	c, err := Dial(context.Background(), startSilentServer(t), Options{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	pipeline := c.Pipeline()
+	first, second := pipeline.Get("a"), pipeline.Get("b")
+
+	if err := pipeline.Exec(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected deadline exceeded but got: ", err)
+	}
+
+	if !errors.Is(first.Err, context.DeadlineExceeded) || !errors.Is(second.Err, context.DeadlineExceeded) {
+		t.Errorf("Expected every result to have timed out but got %v, %v", first.Err, second.Err)
+	}
+}
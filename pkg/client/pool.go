@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// clientPool holds a client for each server, connecting to each server when first used.
+type clientPool struct {
+	options Options
+
+	mutex   sync.Mutex
+	clients map[string]*Client
+	closed  bool
+}
+
+func newClientPool(options Options) *clientPool {
+	return &clientPool{options: options, clients: make(map[string]*Client)}
+}
+
+// get returns the client of the server, connecting to it if not already connected.
+func (p *clientPool) get(ctx context.Context, address string) (*Client, error) {
+	p.mutex.Lock()
+	client, found := p.clients[address]
+	closed := p.closed
+	p.mutex.Unlock()
+
+	switch {
+	case closed:
+		return nil, ErrClosed
+	case found:
+		return client, nil
+	}
+
+	// not holding the lock, so other servers can be used while connecting
+	client, err := Dial(ctx, address, p.options)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if existing, found := p.clients[address]; found || p.closed {
+		_ = client.Close()
+
+		if p.closed {
+			return nil, ErrClosed
+		}
+
+		return existing, nil
+	}
+
+	p.clients[address] = client
+
+	return client, nil
+}
+
+// prune closes the clients of the servers not kept.
+func (p *clientPool) prune(keep func(address string) bool) {
+	p.mutex.Lock()
+
+	var removed []*Client
+
+	for address, client := range p.clients {
+		if !keep(address) {
+			removed = append(removed, client)
+			delete(p.clients, address)
+		}
+	}
+
+	p.mutex.Unlock()
+
+	for _, client := range removed {
+		_ = client.Close()
+	}
+}
+
+// close closes every client, after which no more are connected.
+func (p *clientPool) close() error {
+	p.mutex.Lock()
+	p.closed = true
+	clients := p.clients
+	p.clients = make(map[string]*Client)
+	p.mutex.Unlock()
+
+	var errs []error
+
+	for _, client := range clients {
+		errs = append(errs, client.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// responses sent by the server, each identified by its first 3 characters
+const (
+	ackResponse      = "ack"
+	nilResponse      = "nil"
+	valueResponse    = "val"
+	errorResponse    = "err"
+	warningResponse  = "wrn"
+	busyResponse     = "bsy"
+	throttleResponse = "thr"
+	pongResponse     = "pong"
+	movedResponse    = "mov"
+	askResponse      = "ask"
+	listResponse     = "lst"
+)
+
+var errUnexpectedResponse = errors.New("unexpected response")
+
+// formatArgument returns the argument in the form the server parses: the number of digits in its
+// length, its length in bytes, then the argument itself, e.g. 13abc.
+func formatArgument(argument string) string {
+	length := strconv.Itoa(len(argument))
+
+	return strconv.Itoa(len(length)) + length + argument
+}
+
+// getCommand returns the command getting the whole value of the key.
+func getCommand(key string) string {
+	return "get" + formatArgument(key) + "0"
+}
+
+// putCommand returns the command setting the value of the key.
+func putCommand(key string, value string) string {
+	return "put" + formatArgument(key) + formatArgument(value)
+}
+
+// deleteCommand returns the command removing the key.
+func deleteCommand(key string) string {
+	return "del" + formatArgument(key)
+}
+
+// scanCommand returns the command scanning up to count keys after the key specified.
+func scanCommand(after string, count int) string {
+	return "scn" + formatArgument(after) + formatArgument(strconv.Itoa(count))
+}
+
+// readList reads the items of a list response, after its first 3 characters.
+func readList(reader *bufio.Reader) ([]string, error) {
+	countText, err := readArgument(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(countText)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid list length %s", errUnexpectedResponse, countText)
+	}
+
+	items := make([]string, 0, count)
+
+	for i := 0; i < count; i++ {
+		item, err := readArgument(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// readString reads exactly n bytes, which may not be whole UTF-8 characters.
+func readString(reader *bufio.Reader, n int) (string, error) {
+	buffer := make([]byte, n)
+
+	if _, err := io.ReadFull(reader, buffer); err != nil {
+		return "", fmt.Errorf("error reading response: %w", err)
+	}
+
+	return string(buffer), nil
+}
+
+// readArgument reads an argument formatted by formatArgument.
+func readArgument(reader *bufio.Reader) (string, error) {
+	digits, err := readString(reader, 1)
+	if err != nil {
+		return "", err
+	}
+
+	digitCount, err := strconv.Atoi(digits)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid argument length %s", errUnexpectedResponse, digits)
+	}
+
+	lengthText, err := readString(reader, digitCount)
+	if err != nil {
+		return "", err
+	}
+
+	length, err := strconv.Atoi(lengthText)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid argument length %s", errUnexpectedResponse, lengthText)
+	}
+
+	return readString(reader, length)
+}
+
+// readError reads the rest of an error response: its 3 digit code, then its reason.
+func readError(reader *bufio.Reader) error {
+	code, err := readString(reader, 3)
+	if err != nil {
+		return err
+	}
+
+	reason, err := readArgument(reader)
+	if err != nil {
+		return err
+	}
+
+	return newError(code, reason)
+}
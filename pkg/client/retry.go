@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+var (
+	// errNotSent marks a call that failed before its command was sent, so is always safe to retry.
+	errNotSent = errors.New("command not sent")
+
+	// errStaleConnection marks a call that couldn't be sent on a connection opened by an earlier call.
+	errStaleConnection = errors.New("stale connection")
+)
+
+type idempotentKey struct{}
+
+// Idempotent returns a context marking the writes made with it as safe to retry after a failure that may
+// have happened after the server applied them, such as the connection being reset, i.e. applying them
+// twice has the same effect as once. Gets are always safe to retry.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// isIdempotent returns whether the context was marked by Idempotent.
+func isIdempotent(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentKey{}).(bool)
+
+	return idempotent
+}
+
+// retried makes the call, retrying it up to the configured number of times while it fails transiently,
+// waiting between attempts according to the backoff policy. Calls that may have been applied are only
+// retried if idempotent.
+func (c *Client) retried(ctx context.Context, idempotent bool, call func() error) error {
+	policy := c.options.Backoff.OrDefault()
+
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || attempt > c.options.Retries || ctx.Err() != nil || !transient(err, idempotent) {
+			return err
+		}
+
+		timer := time.NewTimer(policy.Delay(attempt))
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("retry cancelled: %w", ctx.Err())
+		}
+	}
+}
+
+// transient returns whether the call failed for a reason that may not recur: the command wasn't sent, the
+// server was busy, or if idempotent, the connection failed or timed out.
+func transient(err error, idempotent bool) bool {
+	var serverError *Error
+
+	// such as the server rejecting the token when connecting
+	if errors.Is(err, ErrClosed) || errors.As(err, &serverError) {
+		return false
+	}
+
+	if errors.Is(err, errNotSent) || errors.Is(err, ErrBusy) {
+		return true
+	}
+
+	var netError net.Error
+
+	return idempotent && (errors.As(err, &netError) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF))
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"tcp/pkg/backoff"
+	"tcp/pkg/server"
+	"testing"
+	"time"
+)
+
+// fastRetries retries calls 3 times without waiting long.
+var fastRetries = Options{Retries: 3, Backoff: backoff.Policy{Initial: time.Millisecond, Max: time.Millisecond}}
+
+// startResettingServer starts a listener that closes each connection after reading a command, returning its
+// address and the number of connections accepted.
+func startResettingServer(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	var accepted atomic.Int32
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			accepted.Add(1)
+
+			go func() {
+				_, _ = conn.Read(make([]byte, 100))
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), &accepted
+}
+
+func Test_Client_RetryBusy(t *testing.T) {
+	address := startServer(t, server.Config{MaxConnections: 1})
+
+	// take the only connection, until after the client's first attempt
+	other, err := net.Dial("tcp4", address)
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	options := fastRetries
+	options.Backoff = backoff.Policy{Initial: 100 * time.Millisecond, Max: 100 * time.Millisecond}
+
+	c, err := Dial(context.Background(), address, options)
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		_, _ = other.Write([]byte("bye"))
+	})
+
+	// a busy server hasn't applied the write, so it is retried even though not marked idempotent
+	if err := c.Put(context.Background(), "a", "123"); err != nil {
+		t.Error("Expected the put to be retried once the server wasn't busy, but got: ", err)
+	}
+}
+
+func Test_Client_RetryIdempotent(t *testing.T) {
+	address, accepted := startResettingServer(t)
+
+	c, err := Dial(context.Background(), address, fastRetries)
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	// the write may have been applied before the connection was reset
+	if err := c.Put(context.Background(), "a", "123"); err == nil {
+		t.Error("Expected the put to fail")
+	}
+
+	if actual := accepted.Load(); actual != 1 {
+		t.Errorf("Expected the put not to be retried but got %d connections", actual)
+	}
+
+	if err := c.Put(Idempotent(context.Background()), "a", "123"); err == nil {
+		t.Error("Expected the put to fail")
+	}
+
+	if actual := accepted.Load(); actual != 5 {
+		t.Errorf("Expected the put to be retried 3 times but got %d connections", actual)
+	}
+
+	if _, _, err := c.Get(context.Background(), "a"); err == nil {
+		t.Error("Expected the get to fail")
+	}
+
+	if actual := accepted.Load(); actual != 9 {
+		t.Errorf("Expected the get to be retried 3 times but got %d connections", actual)
+	}
+}
+
+func Test_Client_RetryCancelled(t *testing.T) {
+	address, _ := startResettingServer(t)
+
+	options := fastRetries
+	options.Backoff = backoff.Policy{Initial: time.Hour, Max: time.Hour}
+
+	c, err := Dial(context.Background(), address, options)
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := c.Get(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected deadline exceeded while waiting to retry, but got: ", err)
+	}
+}
+
+func Test_transient(t *testing.T) {
+	tests := []struct {
+		err        error
+		idempotent bool
+		expected   bool
+	}{
+		{ErrBusy, false, true},
+		{errNotSent, false, true},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset")}, false, false},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset")}, true, true},
+		{newError("500", "read_only"), true, false},
+		{ErrNotReplicated, true, false},
+		{ErrClosed, true, false},
+	}
+
+	for _, test := range tests {
+		if actual := transient(test.err, test.idempotent); actual != test.expected {
+			t.Errorf("Expected %v for %v (idempotent %v) but got %v", test.expected, test.err, test.idempotent, actual)
+		}
+	}
+}
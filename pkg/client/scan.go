@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// Entry is a key and its value, returned by a scan.
+type Entry struct {
+	Key   string
+	Value string
+}
+
+// Scan returns up to count keys (the server's default if zero) after the key specified, or from the first
+// key if empty, with their values in key order, and the key to pass to continue the scan, empty once every
+// key has been scanned. Keys the user isn't permitted to access are skipped, and when the servers are
+// sharded only the keys held by this server are scanned.
+func (c *Client) Scan(ctx context.Context, after string, count int) ([]Entry, string, error) {
+	var (
+		entries []Entry
+		next    string
+	)
+
+	err := c.retried(ctx, true, func() error {
+		return c.call(ctx, scanCommand(after, count), func(response string) error {
+			if response != listResponse {
+				return c.unexpected(response)
+			}
+
+			items, err := readList(c.reader)
+			if err != nil {
+				return err
+			}
+
+			if len(items)%2 != 1 {
+				return fmt.Errorf("%w: scan of %d items", errUnexpectedResponse, len(items))
+			}
+
+			next, entries = items[0], make([]Entry, 0, len(items)/2)
+
+			for i := 1; i < len(items); i += 2 {
+				entries = append(entries, Entry{items[i], items[i+1]})
+			}
+
+			return nil
+		})
+	})
+
+	return entries, next, err
+}
+
+// ScanAll calls fn with every key and its value, in key order, a page of count keys at a time, until fn
+// returns an error, which is returned.
+func (c *Client) ScanAll(ctx context.Context, count int, fn func(entry Entry) error) error {
+	after := ""
+
+	for {
+		entries, next, err := c.Scan(ctx, after, count)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+
+		after = next
+	}
+}
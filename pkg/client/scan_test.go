@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"tcp/pkg/server"
+	"testing"
+)
+
+func Test_Client_Scan(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := Dial(ctx, startServer(t, server.Config{}), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	var expected []Entry
+
+	for i := 0; i < 25; i++ {
+		key := "key" + strconv.Itoa(100+i)
+		expected = append(expected, Entry{key, strconv.Itoa(i)})
+
+		if err := c.Put(ctx, key, strconv.Itoa(i)); err != nil {
+			t.Fatal("Unexpected put error: ", err)
+		}
+	}
+
+	entries, next, err := c.Scan(ctx, "", 10)
+	if err != nil || next != "key109" || !reflect.DeepEqual(entries, expected[:10]) {
+		t.Errorf("Expected the first 10 keys, continuing after key109, but got %v, %s, %v", entries, next, err)
+	}
+
+	var scanned []Entry
+
+	err = c.ScanAll(ctx, 10, func(entry Entry) error {
+		scanned = append(scanned, entry)
+		return nil
+	})
+
+	if err != nil || !reflect.DeepEqual(scanned, expected) {
+		t.Errorf("Expected every key but got %v, %v", scanned, err)
+	}
+
+	stop := errors.New("stop")
+
+	if err := c.ScanAll(ctx, 10, func(entry Entry) error { return stop }); !errors.Is(err, stop) {
+		t.Error("Expected the scan to stop but got: ", err)
+	}
+}
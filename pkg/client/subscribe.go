@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// message published to a subscribed channel, followed by the channel and the message
+const messageEvent = "evm"
+
+// Message is a message published to a subscribed channel, such as a keyspace notification.
+type Message struct {
+	Channel string
+	Message string
+}
+
+// KeyspaceChannel returns the channel the server's keyspace notifications about the key are published to,
+// each message being the class of change: set, del, expire or evict.
+func KeyspaceChannel(key string) string {
+	return "__keyspace__:" + key
+}
+
+// KeyeventChannel returns the channel the server's keyspace notifications of the class of change (set,
+// del, expire or evict) are published to, each message being the key changed.
+func KeyeventChannel(event string) string {
+	return "__keyevent__:" + event
+}
+
+// Subscribe returns a channel receiving each message published to the channels, until the context is
+// done, when the channel is closed. The channels are subscribed to over a connection of their own,
+// reopened if it fails, after which they are subscribed to again, messages published while reconnecting
+// being missed, as are those the server drops because they weren't read fast enough. Returns an error if
+// the channels can't be subscribed to to begin with.
+func (c *Client) Subscribe(ctx context.Context, channels ...string) (<-chan Message, error) {
+	c.mutex.Lock()
+	closed := c.closed
+	c.mutex.Unlock()
+
+	if closed {
+		return nil, ErrClosed
+	}
+
+	subscriber, err := c.listen(ctx, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(chan Message, watchBuffer)
+
+	go c.relay(ctx, channels, subscriber, messages)
+
+	return messages, nil
+}
+
+// listen opens a connection subscribed to the channels.
+func (c *Client) listen(ctx context.Context, channels []string) (*Client, error) {
+	subscriber, err := Dial(ctx, c.address, c.options)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, channel := range channels {
+		if err := subscriber.call(ctx, "sub"+formatArgument(channel), subscriber.acknowledged); err != nil {
+			_ = subscriber.Close()
+			return nil, err
+		}
+	}
+
+	return subscriber, nil
+}
+
+// relay sends the messages received by the subscriber to the channel, subscribing again over a new
+// connection whenever the connection fails, until the context is done.
+func (c *Client) relay(ctx context.Context, channels []string, subscriber *Client, messages chan<- Message) {
+	defer close(messages)
+
+	for subscriber != nil {
+		_ = subscriber.receiveMessages(ctx, messages)
+		_ = subscriber.Close()
+
+		subscriber = c.relisten(ctx, channels)
+	}
+}
+
+// relisten subscribes to the channels over a new connection, retrying according to the backoff policy
+// until it succeeds, or returns nil once the context is done.
+func (c *Client) relisten(ctx context.Context, channels []string) *Client {
+	policy := c.options.Backoff.OrDefault()
+
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(policy.Delay(attempt))
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+
+		if subscriber, err := c.listen(ctx, channels); err == nil {
+			return subscriber
+		}
+	}
+}
+
+// receiveMessages sends each message received to the channel, until the connection fails or the context
+// is done.
+func (c *Client) receiveMessages(ctx context.Context, messages chan<- Message) error {
+	conn := c.conn
+
+	// the read waiting for the next message is unblocked by closing the connection
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return fmt.Errorf("unable to clear deadline: %w", err)
+	}
+
+	for {
+		message, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+
+		if message == nil {
+			continue
+		}
+
+		select {
+		case messages <- *message:
+		case <-ctx.Done():
+			return fmt.Errorf("subscription ended: %w", ctx.Err())
+		}
+	}
+}
+
+// readMessage reads a message published to a subscribed channel, or nil after a gap marker, messages
+// having been dropped.
+func (c *Client) readMessage() (*Message, error) {
+	response, err := readString(c.reader, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	switch response {
+	case messageEvent:
+		channel, err := readArgument(c.reader)
+		if err != nil {
+			return nil, err
+		}
+
+		message, err := readArgument(c.reader)
+
+		return &Message{Channel: channel, Message: message}, err
+	case gapEvent:
+		_, err := readArgument(c.reader)
+
+		return nil, err
+	default:
+		return nil, c.unexpected(response)
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"tcp/pkg/server"
+	"testing"
+	"time"
+)
+
+// nextMessage returns the next message from the channel, failing the test if there isn't one soon.
+func nextMessage(t *testing.T, messages <-chan Message) Message {
+	t.Helper()
+
+	select {
+	case message, ok := <-messages:
+		if !ok {
+			t.Fatal("Expected a message but the channel was closed")
+		}
+
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a message")
+	}
+
+	return Message{}
+}
+
+func Test_Client_Subscribe(t *testing.T) {
+	c, err := Dial(context.Background(), startServer(t, server.Config{KeyspaceEvents: server.KeyspaceDelete}),
+		Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	messages, err := c.Subscribe(ctx, KeyeventChannel("del"), KeyspaceChannel("a"))
+	if err != nil {
+		t.Fatal("Unable to subscribe: ", err)
+	}
+
+	// only deletes are published
+	_ = c.Put(context.Background(), "a", "123")
+	_ = c.Put(context.Background(), "b", "456")
+	_ = c.Delete(context.Background(), "b")
+	_ = c.Delete(context.Background(), "a")
+
+	expected := []Message{
+		{Channel: "__keyevent__:del", Message: "b"},
+		{Channel: "__keyspace__:a", Message: "del"},
+		{Channel: "__keyevent__:del", Message: "a"},
+	}
+
+	for _, want := range expected {
+		if message := nextMessage(t, messages); message != want {
+			t.Errorf("Expected %+v but got %+v", want, message)
+		}
+	}
+
+	cancel()
+
+	for range messages {
+		// drain until closed
+	}
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"tcp/pkg/server"
+	"testing"
+	"time"
+)
+
+// startTLSProxy starts a listener terminating TLS with a self-signed certificate for 127.0.0.1, forwarding
+// each connection to the target, returning its address and the pool trusting its certificate.
+func startTLSProxy(t *testing.T, target string) (string, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Unable to generate key: ", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Unable to create certificate: ", err)
+	}
+
+	certificate, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+
+	listener, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go proxy(conn, target)
+		}
+	}()
+
+	return listener.Addr().String(), pool
+}
+
+// proxy copies data between the connection and the target, until either closes.
+func proxy(conn net.Conn, target string) {
+	defer conn.Close()
+
+	upstream, err := net.Dial("tcp4", target)
+	if err != nil {
+		return
+	}
+
+	defer upstream.Close()
+
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		_ = upstream.Close()
+	}()
+
+	_, _ = io.Copy(conn, upstream)
+}
+
+func Test_Client_TLS(t *testing.T) {
+	address, pool := startTLSProxy(t, startServer(t, server.Config{}))
+	ctx := context.Background()
+
+	c, err := Dial(ctx, address, Options{TLS: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	if err := c.Put(ctx, "a", "123"); err != nil {
+		t.Error("Unexpected put error: ", err)
+	}
+
+	// the certificate isn't for the server name
+	_, err = Dial(ctx, address, Options{TLS: &tls.Config{
+		RootCAs: pool, ServerName: "other.example", MinVersion: tls.VersionTLS12,
+	}})
+
+	var verificationError *tls.CertificateVerificationError
+	if !errors.As(err, &verificationError) {
+		t.Error("Expected certificate verification error but got: ", err)
+	}
+
+	// nor trusted
+	if _, err := Dial(ctx, address, Options{TLS: &tls.Config{MinVersion: tls.VersionTLS12}}); err == nil {
+		t.Error("Expected an error for an untrusted certificate")
+	}
+}
+
+func Test_Client_Auth(t *testing.T) {
+	acl := server.NewACL([]server.User{{Name: "reader", Token: "secret", Commands: []string{"get"}}})
+	address := startServer(t, server.Config{ACL: acl})
+	ctx := context.Background()
+
+	c, err := Dial(ctx, address, Options{Token: "secret", Retries: 3})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	if _, _, err := c.Get(ctx, "a"); err != nil {
+		t.Error("Unexpected get error: ", err)
+	}
+
+	// the user may only get
+	var serverError *Error
+	if err := c.Put(ctx, "a", "123"); !errors.As(err, &serverError) || serverError.Reason != "unauthorised" {
+		t.Error("Expected unauthorised error but got: ", err)
+	}
+
+	if _, err := Dial(ctx, address, Options{Token: "wrong"}); !errors.As(err, &serverError) {
+		t.Error("Expected authentication error but got: ", err)
+	}
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// key set, followed by the key and its new value
+	setEvent = "evs"
+
+	// key removed, followed by the key
+	deleteEvent = "evd"
+
+	// changes dropped by the server because they weren't read fast enough, followed by how many
+	gapEvent = "evg"
+
+	// the most changes received but not yet taken from a watch's channel
+	watchBuffer = 100
+)
+
+// errChangesDropped marks a gap in the changes received, after which the key's current value is fetched.
+var errChangesDropped = errors.New("changes dropped")
+
+// Event is a change to a watched key.
+type Event struct {
+	Key   string
+	Value string
+
+	// whether the key was removed, by being deleted, expiring or every key being flushed
+	Deleted bool
+}
+
+// Watch returns a channel receiving each change to the key, in the order the changes were made, until the
+// context is done, when the channel is closed. The key is watched over a connection of its own, reopened
+// if it fails, after which the key is watched again and its current value received, since changes made
+// while reconnecting are missed. Likewise the current value is received after the server drops changes
+// that weren't read fast enough. Returns an error if the key can't be watched to begin with.
+func (c *Client) Watch(ctx context.Context, key string) (<-chan Event, error) {
+	c.mutex.Lock()
+	closed := c.closed
+	c.mutex.Unlock()
+
+	if closed {
+		return nil, ErrClosed
+	}
+
+	watcher, err := c.subscribe(ctx, key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, watchBuffer)
+
+	go c.watch(ctx, key, watcher, events)
+
+	return events, nil
+}
+
+// WatchFunc calls fn with each change to the key, in the order the changes were made, like Watch, until
+// the context is done.
+func (c *Client) WatchFunc(ctx context.Context, key string, fn func(event Event)) error {
+	events, err := c.Watch(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	for event := range events {
+		fn(event)
+	}
+
+	return fmt.Errorf("watch ended: %w", ctx.Err())
+}
+
+// subscribe opens a connection watching the key, also asking for its current value if current.
+func (c *Client) subscribe(ctx context.Context, key string, current bool) (*Client, error) {
+	watcher, err := Dial(ctx, c.address, c.options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.call(ctx, "wch"+formatArgument(key), watcher.acknowledged); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	// the value is received after any changes made since the key was watched
+	if current {
+		if err := send(watcher.conn, getCommand(key)); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+
+	return watcher, nil
+}
+
+// watch sends the changes received by the watcher to the channel, watching the key again over a new
+// connection whenever the connection fails, until the context is done.
+func (c *Client) watch(ctx context.Context, key string, watcher *Client, events chan<- Event) {
+	defer close(events)
+
+	for watcher != nil {
+		_ = watcher.receive(ctx, key, events)
+		_ = watcher.Close()
+
+		watcher = c.resubscribe(ctx, key)
+	}
+}
+
+// resubscribe watches the key over a new connection, retrying according to the backoff policy until it
+// succeeds, or returns nil once the context is done.
+func (c *Client) resubscribe(ctx context.Context, key string) *Client {
+	policy := c.options.Backoff.OrDefault()
+
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(policy.Delay(attempt))
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+
+		if watcher, err := c.subscribe(ctx, key, true); err == nil {
+			return watcher
+		}
+	}
+}
+
+// receive sends each change received to the channel, until the connection fails or the context is done.
+func (c *Client) receive(ctx context.Context, key string, events chan<- Event) error {
+	conn := c.conn
+
+	// the read waiting for the next change is unblocked by closing the connection
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return fmt.Errorf("unable to clear deadline: %w", err)
+	}
+
+	for {
+		event, err := c.readEvent(key)
+		if errors.Is(err, errChangesDropped) {
+			// the value is received after the changes following the gap
+			if err := send(conn, getCommand(key)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return fmt.Errorf("watch ended: %w", ctx.Err())
+		}
+	}
+}
+
+// readEvent reads a change to the key, or the response to getting its current value, or returns
+// errChangesDropped if changes were dropped.
+func (c *Client) readEvent(key string) (Event, error) {
+	response, err := readString(c.reader, 3)
+	if err != nil {
+		return Event{}, err
+	}
+
+	switch response {
+	case setEvent, valueResponse:
+		if response == setEvent {
+			if key, err = readArgument(c.reader); err != nil {
+				return Event{}, err
+			}
+		}
+
+		value, err := readArgument(c.reader)
+
+		return Event{Key: key, Value: value}, err
+	case deleteEvent:
+		key, err := readArgument(c.reader)
+
+		return Event{Key: key, Deleted: true}, err
+	case nilResponse:
+		return Event{Key: key, Deleted: true}, nil
+	case gapEvent:
+		if _, err := readArgument(c.reader); err != nil {
+			return Event{}, err
+		}
+
+		return Event{}, errChangesDropped
+	default:
+		return Event{}, c.unexpected(response)
+	}
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"tcp/pkg/backoff"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/server"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event from the channel, failing the test if there isn't one soon.
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Expected an event but the channel was closed")
+		}
+
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an event")
+	}
+
+	return Event{}
+}
+
+func Test_Client_Watch(t *testing.T) {
+	c, err := Dial(context.Background(), startServer(t, server.Config{}), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	events, err := c.Watch(ctx, "a")
+	if err != nil {
+		t.Fatal("Unable to watch: ", err)
+	}
+
+	if err := c.Put(context.Background(), "a", "123"); err != nil {
+		t.Fatal("Unexpected put error: ", err)
+	}
+
+	if event := nextEvent(t, events); event != (Event{Key: "a", Value: "123"}) {
+		t.Errorf("Expected a set to 123 but got %+v", event)
+	}
+
+	// changes to other keys aren't received
+	_ = c.Put(context.Background(), "b", "456")
+	_ = c.Delete(context.Background(), "a")
+
+	if event := nextEvent(t, events); event != (Event{Key: "a", Deleted: true}) {
+		t.Errorf("Expected a deleted but got %+v", event)
+	}
+
+	cancel()
+
+	for range events {
+		// drain until closed
+	}
+}
+
+func Test_Client_WatchReconnects(t *testing.T) {
+	dir := t.TempDir()
+	address := "unix://" + filepath.Join(dir, "server-client")
+
+	stop := startUnixServer(t, dir, "server", kvstore.NewKVStore(), server.Config{})
+
+	c, err := Dial(context.Background(), address, Options{
+		Backoff: backoff.Policy{Initial: 10 * time.Millisecond, Max: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := c.Watch(ctx, "a")
+	if err != nil {
+		t.Fatal("Unable to watch: ", err)
+	}
+
+	stop()
+
+	// the key was set while the connection was down
+	store := kvstore.NewKVStore()
+	kvstore.Write(store, "a", "123")
+	startUnixServer(t, dir, "server", store, server.Config{})
+
+	if event := nextEvent(t, events); event != (Event{Key: "a", Value: "123"}) {
+		t.Errorf("Expected the current value 123 but got %+v", event)
+	}
+
+	// watching again
+	if err := c.Put(context.Background(), "a", "456"); err != nil {
+		t.Fatal("Unexpected put error: ", err)
+	}
+
+	if event := nextEvent(t, events); event != (Event{Key: "a", Value: "456"}) {
+		t.Errorf("Expected a set to 456 but got %+v", event)
+	}
+}
+
+func Test_Client_WatchFunc(t *testing.T) {
+	c, err := Dial(context.Background(), startServer(t, server.Config{}), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan Event)
+	done := make(chan error)
+
+	go func() {
+		done <- c.WatchFunc(ctx, "a", func(event Event) {
+			received <- event
+		})
+	}()
+
+	// watching has started once a change is received
+	for {
+		_ = c.Put(context.Background(), "a", "123")
+
+		select {
+		case event := <-received:
+			if event.Value != "123" {
+				t.Errorf("Expected a set to 123 but got %+v", event)
+			}
+		case <-time.After(10 * time.Millisecond):
+			continue
+		}
+
+		break
+	}
+
+	cancel()
+
+	go func() {
+		for range received {
+			// changes received before the watch ended
+		}
+	}()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Error("Expected cancelled but got: ", err)
+	}
+}
+
+func Test_Client_WatchDropped(t *testing.T) {
+	c, err := Dial(context.Background(), startServer(t, server.Config{
+		WatchBuffer:       1,
+		SlowWatcherPolicy: server.SlowWatcherDropOldest,
+	}), Options{})
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := c.Watch(ctx, "a")
+	if err != nil {
+		t.Fatal("Unable to watch: ", err)
+	}
+
+	// changes are dropped once the channel and buffers are full
+	pipeline := c.Pipeline()
+
+	for i := 0; i < 10000; i++ {
+		pipeline.Put("a", strconv.Itoa(i))
+	}
+
+	pipeline.Put("a", "last")
+
+	if err := pipeline.Exec(context.Background()); err != nil {
+		t.Fatal("Unexpected pipeline error: ", err)
+	}
+
+	// the last change, or the value fetched after a gap, is eventually received
+	for {
+		if event := nextEvent(t, events); event.Value == "last" {
+			break
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package hashring assigns keys to servers using consistent hashing, shared by the servers and the clients
+// routing keys to them, so both assign every key to the same servers.
+package hashring
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes is how many points each server has on the ring, if not configured.
+const DefaultVirtualNodes = 128
+
+// Ring assigns each key to a subset of the servers, so adding or removing a server only moves the keys
+// next to its points on the ring. Each server has many points (virtual nodes), spreading the keys evenly
+// between servers. It is safe for concurrent use.
+type Ring struct {
+	replicas     int
+	virtualNodes int
+
+	mutex  sync.RWMutex
+	points []point
+}
+
+// point is a virtual node of a server, at the hash of its name.
+type point struct {
+	hash   uint32
+	server string
+}
+
+// New returns a ring assigning each key to the number of replicas of the servers.
+func New(servers []string, replicas int, virtualNodes int) *Ring {
+	if virtualNodes < 1 {
+		virtualNodes = DefaultVirtualNodes
+	}
+
+	r := &Ring{replicas: replicas, virtualNodes: virtualNodes}
+	r.SetServers(servers)
+
+	return r
+}
+
+// SetServers replaces the servers on the ring.
+func (r *Ring) SetServers(servers []string) {
+	points := make([]point, 0, len(servers)*r.virtualNodes)
+
+	for _, server := range servers {
+		for i := 0; i < r.virtualNodes; i++ {
+			points = append(points, point{Hash(server + "#" + strconv.Itoa(i)), server})
+		}
+	}
+
+	// ties broken by server, so every server builds the same ring
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+
+		return points[i].server < points[j].server
+	})
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.points = points
+}
+
+// Replicas returns how many servers each key is assigned to.
+func (r *Ring) Replicas() int {
+	return r.replicas
+}
+
+// VirtualNodes returns how many points each server has on the ring.
+func (r *Ring) VirtualNodes() int {
+	return r.virtualNodes
+}
+
+// Owners returns the servers holding the key, the first servers found clockwise from the key's hash.
+func (r *Ring) Owners(key string) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	hash := Hash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+
+	owners := make([]string, 0, r.replicas)
+
+	for i := 0; i < len(r.points) && len(owners) < r.replicas; i++ {
+		server := r.points[(start+i)%len(r.points)].server
+
+		if !contains(owners, server) {
+			owners = append(owners, server)
+		}
+	}
+
+	return owners
+}
+
+// Hash returns the position of the key on the ring, from a cryptographic hash, since faster hashes
+// place similar keys (such as those differing only by their last character) close together.
+func Hash(key string) uint32 {
+	hash := sha256.Sum256([]byte(key))
+
+	return binary.BigEndian.Uint32(hash[:4])
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,29 @@
+package hashring
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_Ring_Owners(t *testing.T) {
+	ring := New([]string{"server1", "server2", "server3"}, 2, 0)
+	other := New([]string{"server3", "server1", "server2"}, 2, 0)
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owners := ring.Owners(key)
+
+		if len(owners) != 2 || owners[0] == owners[1] {
+			t.Fatalf("Expected 2 different owners of %s but got %v", key, owners)
+		}
+
+		// the order the servers are listed in makes no difference
+		if fmt.Sprint(owners) != fmt.Sprint(other.Owners(key)) {
+			t.Errorf("Expected owners of %s %v but got %v", key, owners, other.Owners(key))
+		}
+	}
+
+	if owners := New(nil, 2, 0).Owners("key"); len(owners) != 0 {
+		t.Errorf("Expected no owners but got %v", owners)
+	}
+}
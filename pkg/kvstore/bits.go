@@ -0,0 +1,125 @@
+package kvstore
+
+import (
+	"math/bits"
+	"time"
+)
+
+// BitStore is implemented by stores that can read and change strings as bitmaps, a bit at a time, such
+// as to hold flags or track presence using a bit per id. Bits are numbered from the most significant bit
+// of the first byte, as in Redis, and bits past the end of the string are 0.
+type BitStore interface {
+	// SetBit sets the bit at the offset in the key's string, returning its previous value. The string
+	// is created if the key is absent, and padded with zero bytes if too short, keeping any expiry.
+	SetBit(key string, offset int, bit bool) (bool, error)
+
+	// GetBit returns the bit at the offset in the key's string.
+	GetBit(key string, offset int) (bool, error)
+
+	// BitCount returns how many bits are set in the key's string.
+	BitCount(key string) (int, error)
+}
+
+// the byte holding the bit at the offset, and the mask selecting the bit in it
+func bitPosition(offset int) (int, byte) {
+	return offset / 8, 0x80 >> (offset % 8)
+}
+
+// SetBit implements BitStore.
+func (s *KVStore) SetBit(key string, offset int, bit bool) (bool, error) {
+	var (
+		previous bool
+		err      error
+	)
+
+	s.run(func(now time.Time) {
+		var value string
+
+		if value, err = s.bitmap(key, now); err != nil {
+			return
+		}
+
+		index, mask := bitPosition(offset)
+
+		bitmap := []byte(value)
+		if index >= len(bitmap) {
+			bitmap = append(bitmap, make([]byte, index-len(bitmap)+1)...)
+		}
+
+		previous = bitmap[index]&mask != 0
+		if previous == bit && index < len(value) {
+			return
+		}
+
+		if bit {
+			bitmap[index] |= mask
+		} else {
+			bitmap[index] &^= mask
+		}
+
+		if err = s.put(key, string(bitmap)); err != nil {
+			return
+		}
+
+		s.notify(Change{Key: key, Value: string(bitmap)})
+	})
+
+	return previous, err
+}
+
+// GetBit implements BitStore.
+func (s *KVStore) GetBit(key string, offset int) (bool, error) {
+	var (
+		bit bool
+		err error
+	)
+
+	s.run(func(now time.Time) {
+		var value string
+
+		if value, err = s.bitmap(key, now); err != nil {
+			return
+		}
+
+		if index, mask := bitPosition(offset); index < len(value) {
+			bit = value[index]&mask != 0
+		}
+	})
+
+	return bit, err
+}
+
+// BitCount implements BitStore.
+func (s *KVStore) BitCount(key string) (int, error) {
+	var (
+		count int
+		err   error
+	)
+
+	s.run(func(now time.Time) {
+		var value string
+
+		if value, err = s.bitmap(key, now); err != nil {
+			return
+		}
+
+		for i := 0; i < len(value); i++ {
+			count += bits.OnesCount8(value[i])
+		}
+	})
+
+	return count, err
+}
+
+// bitmap returns the key's string, empty if absent, failing if the key holds a collection.
+func (s *KVStore) bitmap(key string, now time.Time) (string, error) {
+	s.removeIfExpired(key, now)
+
+	if _, found := s.collections[key]; found {
+		return "", ErrWrongType
+	}
+
+	value, _, err := s.get(key)
+
+	return value, err
+}
@@ -0,0 +1,100 @@
+package kvstore_test
+
+import (
+	"errors"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func TestBits(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	if previous, err := store.SetBit(key1, 1, true); previous || err != nil {
+		t.Fatalf("Should have set bit that wasn't set but was: %t %v", previous, err)
+	}
+
+	_, _ = store.SetBit(key1, 7, true)
+	_, _ = store.SetBit(key1, 17, true) // pads with a zero byte
+
+	if value, _ := kvstore.Read(store, key1); value != "A\x00@" {
+		t.Fatalf("Value should have been %q but was: %q", "A\x00@", value)
+	}
+
+	if previous, _ := store.SetBit(key1, 7, false); !previous {
+		t.Fatal("Should have cleared bit that was set")
+	}
+
+	for offset, expected := range map[int]bool{0: false, 1: true, 7: false, 17: true, 1000: false} {
+		if bit, err := store.GetBit(key1, offset); bit != expected || err != nil {
+			t.Errorf("Bit %d should have been %t but was: %t %v", offset, expected, bit, err)
+		}
+	}
+
+	if count, err := store.BitCount(key1); count != 2 || err != nil {
+		t.Fatalf("Should have been 2 bits set but was: %d %v", count, err)
+	}
+
+	if count, err := store.BitCount(key2); count != 0 || err != nil {
+		t.Fatalf("Should have been no bits set but was: %d %v", count, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestSetBitKeepsTTL(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	kvstore.WriteWithTTL(store, key1, value1, 50*time.Millisecond)
+	_, _ = store.SetBit(key1, 0, true)
+
+	time.Sleep(60 * time.Millisecond)
+
+	if value, ok := kvstore.Read(store, key1); ok {
+		t.Fatalf("Key should have expired but was: %t (value %s)", ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestBitsWrongType(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	_ = store.SetAdd(key1, value1)
+
+	if _, err := store.SetBit(key1, 0, true); !errors.Is(err, kvstore.ErrWrongType) {
+		t.Fatalf("Should have been wrong type but got: %v", err)
+	}
+
+	if _, err := store.BitCount(key1); !errors.Is(err, kvstore.ErrWrongType) {
+		t.Fatalf("Should have been wrong type but got: %v", err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestObserveBits(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	var changes []kvstore.Change
+
+	kvstore.Observe(store, func(change kvstore.Change) {
+		changes = append(changes, change)
+	})
+
+	_, _ = store.SetBit(key1, 1, true)
+	_, _ = store.SetBit(key1, 1, true) // already set, so unchanged
+	_, _ = store.SetBit(key1, 1, false)
+
+	expected := []kvstore.Change{{Key: key1, Value: "@"}, {Key: key1, Value: "\x00"}}
+
+	// read after a store operation, so the observer has been called
+	kvstore.Count(store)
+
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %v but got %v", expected, changes)
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,272 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Type is the type of value a key holds.
+type Type string
+
+// Types of value a key can hold.
+const (
+	// the key is absent
+	TypeNone Type = "none"
+
+	TypeString    Type = "string"
+	TypeHash      Type = "hash"
+	TypeList      Type = "list"
+	TypeSet       Type = "set"
+	TypeSortedSet Type = "zset"
+)
+
+// ErrWrongType is returned by an operation on a key holding a different type of value.
+var ErrWrongType = errors.New("key holds the wrong type of value")
+
+// TypedStore is implemented by stores holding collections as well as strings.
+type TypedStore interface {
+	// Type returns the type of value the key holds, TypeNone if absent.
+	Type(key string) Type
+
+	// Metadata describes the value the key holds, with the type TypeNone if absent.
+	Metadata(key string) Metadata
+}
+
+// Metadata describes the value a key holds.
+type Metadata struct {
+	Type Type
+
+	// the length of a string in bytes, or the number of elements of a collection
+	Size int
+
+	// how long until the key expires, zero if it never does
+	TTL time.Duration
+}
+
+// HashStore is implemented by stores holding hashes: fields with string values, kept under a key, so
+// each field can be read and written without rewriting the others. A hash is absent once it has no
+// fields, and its expiry, like a string's, applies to the whole key.
+type HashStore interface {
+	TypedStore
+
+	// HashSet sets the field of the key's hash, creating the hash if the key is absent.
+	HashSet(key string, field string, value string) error
+
+	// HashGet returns the value of the field of the key's hash, and whether it was present.
+	HashGet(key string, field string) (string, bool, error)
+
+	// HashDelete removes the field of the key's hash, if present.
+	HashDelete(key string, field string) error
+
+	// HashGetAll returns every field of the key's hash and its value, empty if the key is absent.
+	HashGetAll(key string) (map[string]string, error)
+}
+
+// collection is a value holding several elements, changed in place by the operations on its type,
+// rather than rewritten whole.
+type collection interface {
+	valueType() Type
+
+	// the number of elements, the key being removed once there are none
+	size() int
+
+	// the elements, to be encoded as JSON
+	contents() any
+}
+
+type hash map[string]string
+
+func (h hash) valueType() Type {
+	return TypeHash
+}
+
+func (h hash) size() int {
+	return len(h)
+}
+
+func (h hash) contents() any {
+	return map[string]string(h)
+}
+
+// Contents returns the JSON encoding of the collection changed, such as a hash's object of fields and
+// values or a list's array of elements, or an empty string if a string was changed. It must be called
+// while the observer is being called, as the collection is then changed again.
+func (c Change) Contents() string {
+	if c.collection == nil {
+		return ""
+	}
+
+	encoded, err := json.Marshal(c.collection.contents())
+	if err != nil {
+		// never happens, the contents only holding strings and numbers
+		return ""
+	}
+
+	return string(encoded)
+}
+
+// Type implements TypedStore.
+func (s *KVStore) Type(key string) Type {
+	valueType := TypeNone
+
+	s.run(func(now time.Time) {
+		s.removeIfExpired(key, now)
+
+		if c, found := s.collections[key]; found {
+			valueType = c.valueType()
+		} else if s.engine.Has(key) {
+			valueType = TypeString
+		}
+	})
+
+	return valueType
+}
+
+// Metadata implements TypedStore.
+func (s *KVStore) Metadata(key string) Metadata {
+	metadata := Metadata{Type: TypeNone}
+
+	s.run(func(now time.Time) {
+		s.removeIfExpired(key, now)
+
+		if c, found := s.collections[key]; found {
+			metadata = Metadata{Type: c.valueType(), Size: c.size()}
+		} else if value, found := s.value(key); found {
+			metadata = Metadata{Type: TypeString, Size: len(value)}
+		} else {
+			return
+		}
+
+		if expiry, found := s.expiries[key]; found {
+			metadata.TTL = expiry.Sub(now)
+		}
+	})
+
+	return metadata
+}
+
+// HashSet implements HashStore.
+func (s *KVStore) HashSet(key string, field string, value string) error {
+	var err error
+
+	s.run(func(now time.Time) {
+		var h hash
+
+		if h, _, err = collectionOf(s, key, func() hash { return make(hash) }, now); err == nil {
+			h[field] = value
+			s.changed(key, h)
+		}
+	})
+
+	return err
+}
+
+// HashGet implements HashStore.
+func (s *KVStore) HashGet(key string, field string) (string, bool, error) {
+	var (
+		value   string
+		present bool
+		err     error
+	)
+
+	s.run(func(now time.Time) {
+		var h hash
+
+		if h, _, err = collectionOf[hash](s, key, nil, now); err == nil {
+			value, present = h[field]
+		}
+	})
+
+	return value, present, err
+}
+
+// HashDelete implements HashStore.
+func (s *KVStore) HashDelete(key string, field string) error {
+	var err error
+
+	s.run(func(now time.Time) {
+		var (
+			h     hash
+			found bool
+		)
+
+		if h, found, err = collectionOf[hash](s, key, nil, now); found {
+			if _, present := h[field]; present {
+				delete(h, field)
+				s.changed(key, h)
+			}
+		}
+	})
+
+	return err
+}
+
+// HashGetAll implements HashStore.
+func (s *KVStore) HashGetAll(key string) (map[string]string, error) {
+	fields := make(map[string]string)
+
+	var err error
+
+	s.run(func(now time.Time) {
+		var h hash
+
+		if h, _, err = collectionOf[hash](s, key, nil, now); err == nil {
+			for field, value := range h {
+				fields[field] = value
+			}
+		}
+	})
+
+	return fields, err
+}
+
+// run calls fn on the store's go routine, so it can read and change the store atomically.
+func (s *KVStore) run(fn func(now time.Time)) {
+	responseChannel := make(chan *operationResponse)
+	s.requestChannel <- &operationRequest{op: updateOperation, update: fn, responseChannel: responseChannel}
+
+	<-responseChannel
+}
+
+// collectionOf returns the key's collection of type C, and whether the key holds it. If the key is absent
+// the collection is created by create, unless nil. Fails if the key holds another type of value.
+func collectionOf[C collection](s *KVStore, key string, create func() C, now time.Time) (C, bool, error) {
+	var none C
+
+	s.removeIfExpired(key, now)
+
+	if s.engine.Has(key) {
+		return none, false, ErrWrongType
+	}
+
+	if existing, found := s.collections[key]; found {
+		c, ok := existing.(C)
+		if !ok {
+			return none, false, ErrWrongType
+		}
+
+		return c, true, nil
+	}
+
+	if create == nil {
+		return none, false, nil
+	}
+
+	c := create()
+	s.collections[key] = c
+
+	return c, true, nil
+}
+
+// changed notifies the observer of the collection changed in place, removing the key if now empty.
+func (s *KVStore) changed(key string, c collection) {
+	if c.size() == 0 {
+		// never fails, the key not being in the storage engine
+		_ = s.remove(key)
+
+		return
+	}
+
+	s.notify(Change{Key: key, Type: c.valueType(), collection: c})
+}
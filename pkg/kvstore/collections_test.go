@@ -0,0 +1,140 @@
+package kvstore_test
+
+import (
+	"errors"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func TestHash(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	if err := store.HashSet(key1, "a", value1); err != nil {
+		t.Fatalf("Should have set field but got: %v", err)
+	}
+
+	_ = store.HashSet(key1, "b", value2)
+	_ = store.HashSet(key1, "a", value2) // update field
+
+	if value, ok, err := store.HashGet(key1, "a"); !ok || value != value2 || err != nil {
+		t.Fatalf("Field should have been %s but was: %t (value %s) %v", value2, ok, value, err)
+	}
+
+	if value, ok, err := store.HashGet(key1, "c"); ok || err != nil {
+		t.Fatalf("Field should not be present but was: %t (value %s) %v", ok, value, err)
+	}
+
+	_ = store.HashDelete(key1, "b")
+	_ = store.HashDelete(key1, "c") // not present
+
+	expected := map[string]string{"a": value2}
+	if fields, err := store.HashGetAll(key1); !reflect.DeepEqual(fields, expected) || err != nil {
+		t.Fatalf("Fields should have been %v but were: %v %v", expected, fields, err)
+	}
+
+	if valueType := store.Type(key1); valueType != kvstore.TypeHash {
+		t.Fatalf("Type should have been hash but was: %s", valueType)
+	}
+
+	if count := kvstore.Count(store); count != 1 {
+		t.Fatalf("Should have been 1 key but was: %d", count)
+	}
+
+	// once empty, the key is absent
+	_ = store.HashDelete(key1, "a")
+
+	if valueType := store.Type(key1); valueType != kvstore.TypeNone {
+		t.Fatalf("Type should have been none but was: %s", valueType)
+	}
+
+	if fields, err := store.HashGetAll(key1); len(fields) != 0 || err != nil {
+		t.Fatalf("Should have been no fields but were: %v %v", fields, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestHashWrongType(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	kvstore.Write(store, key1, value1)
+
+	if err := store.HashSet(key1, "a", value1); !errors.Is(err, kvstore.ErrWrongType) {
+		t.Fatalf("Should have been wrong type but got: %v", err)
+	}
+
+	if _, _, err := store.HashGet(key1, "a"); !errors.Is(err, kvstore.ErrWrongType) {
+		t.Fatalf("Should have been wrong type but got: %v", err)
+	}
+
+	// writing a string replaces the hash
+	_ = store.HashSet(key2, "a", value1)
+	kvstore.Write(store, key2, value2)
+
+	if valueType := store.Type(key2); valueType != kvstore.TypeString {
+		t.Fatalf("Type should have been string but was: %s", valueType)
+	}
+
+	if value, ok := kvstore.Read(store, key2); !ok || value != value2 {
+		t.Fatalf("Key should have been %s but was: %t (value %s)", value2, ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestObserveHash(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	var contents []string
+
+	kvstore.Observe(store, func(change kvstore.Change) {
+		if change.Deleted {
+			contents = append(contents, "deleted")
+		} else {
+			contents = append(contents, string(change.Type)+" "+change.Contents())
+		}
+	})
+
+	_ = store.HashSet(key1, "a", value1)
+	_ = store.HashSet(key1, "b", value2)
+	_ = store.HashDelete(key1, "a")
+	_ = store.HashDelete(key1, "b")
+
+	expected := []string{`hash {"a":"ABC"}`, `hash {"a":"ABC","b":"DEF"}`, `hash {"b":"DEF"}`, "deleted"}
+
+	// read after a store operation, so the observer has been called
+	kvstore.Count(store)
+
+	if !reflect.DeepEqual(contents, expected) {
+		t.Errorf("Expected changes %v but got %v", expected, contents)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestMetadata(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	kvstore.WriteWithTTL(store, key1, value1, time.Minute)
+	_ = store.HashSet(key2, "a", value1)
+	_ = store.HashSet(key2, "b", value2)
+
+	if metadata := store.Metadata(key1); metadata.Type != kvstore.TypeString || metadata.Size != 3 ||
+		metadata.TTL <= 59*time.Second || metadata.TTL > time.Minute {
+		t.Errorf("Should have been a string of 3 bytes expiring in a minute but was: %v", metadata)
+	}
+
+	expected := kvstore.Metadata{Type: kvstore.TypeHash, Size: 2}
+	if metadata := store.Metadata(key2); metadata != expected {
+		t.Errorf("Should have been %v but was: %v", expected, metadata)
+	}
+
+	expected = kvstore.Metadata{Type: kvstore.TypeNone}
+	if metadata := store.Metadata("key3"); metadata != expected {
+		t.Errorf("Should have been %v but was: %v", expected, metadata)
+	}
+
+	kvstore.Close(store)
+}
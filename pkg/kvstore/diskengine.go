@@ -0,0 +1,209 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// the file in the directory holding the values, and the file it is compacted into
+	diskValuesFile    = "values.log"
+	diskCompactedFile = "values.log.compact"
+
+	// the least space taken up by values overwritten or deleted before the file is compacted
+	minDiskGarbage = 1 << 20
+)
+
+var errCorruptValue = errors.New("value read from disk is corrupt")
+
+// diskEngine appends the values to a file, as in Bitcask, keeping only the position of each key's value
+// in memory, so the values can take up far more space than there is memory. Each value is read back from
+// the file when needed, checked against the checksum taken when it was written.
+//
+// Values overwritten or deleted are left in the file until they take up more space than the values still
+// present, when the present values are copied to a new file replacing it (while the store waits).
+//
+// The file only holds the strings of a store while it is running, it is emptied when opened rather than
+// being read back, since the store's expiries and collections, held in memory, are lost when it stops.
+type diskEngine struct {
+	dir     string
+	file    *os.File
+	entries map[string]diskEntry
+
+	// the end of the file, and the space taken up by the values overwritten or deleted
+	end     int64
+	garbage int64
+}
+
+// diskEntry is the position of a value in the file, and its checksum.
+type diskEntry struct {
+	offset   int64
+	length   int
+	checksum uint32
+}
+
+// OpenDiskEngine returns a storage engine holding the values in a file in the directory, created if it
+// doesn't exist, any values already in the file being removed.
+func OpenDiskEngine(dir string) (StorageEngine, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create storage directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, diskValuesFile), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open values file: %w", err)
+	}
+
+	return &diskEngine{dir: dir, file: file, entries: make(map[string]diskEntry)}, nil
+}
+
+func (d *diskEngine) Get(key string) (string, bool, error) {
+	entry, found := d.entries[key]
+	if !found {
+		return "", false, nil
+	}
+
+	value, err := d.read(entry)
+	if err != nil {
+		return "", false, err
+	}
+
+	return value, true, nil
+}
+
+func (d *diskEngine) Has(key string) bool {
+	_, found := d.entries[key]
+
+	return found
+}
+
+func (d *diskEngine) Put(key string, value string) error {
+	if _, err := d.file.WriteAt([]byte(value), d.end); err != nil {
+		return fmt.Errorf("unable to write value: %w", err)
+	}
+
+	d.discard(key)
+	d.entries[key] = diskEntry{offset: d.end, length: len(value), checksum: crc32.ChecksumIEEE([]byte(value))}
+	d.end += int64(len(value))
+
+	return d.compactIfNeeded()
+}
+
+func (d *diskEngine) Delete(key string) error {
+	d.discard(key)
+	delete(d.entries, key)
+
+	return d.compactIfNeeded()
+}
+
+func (d *diskEngine) Keys(fn func(key string) bool) {
+	for key := range d.entries {
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+func (d *diskEngine) Len() int {
+	return len(d.entries)
+}
+
+func (d *diskEngine) Clear() error {
+	if err := d.file.Truncate(0); err != nil {
+		return fmt.Errorf("unable to empty values file: %w", err)
+	}
+
+	d.entries = make(map[string]diskEntry)
+	d.end = 0
+	d.garbage = 0
+
+	return nil
+}
+
+func (d *diskEngine) Close() error {
+	if err := d.file.Close(); err != nil {
+		return fmt.Errorf("unable to close values file: %w", err)
+	}
+
+	return nil
+}
+
+// read returns the value at the entry's position in the file, checking it against its checksum.
+func (d *diskEngine) read(entry diskEntry) (string, error) {
+	buffer := make([]byte, entry.length)
+
+	if _, err := d.file.ReadAt(buffer, entry.offset); err != nil {
+		return "", fmt.Errorf("unable to read value: %w", err)
+	}
+
+	if crc32.ChecksumIEEE(buffer) != entry.checksum {
+		return "", errCorruptValue
+	}
+
+	return string(buffer), nil
+}
+
+// discard counts the key's current value, if any, as garbage.
+func (d *diskEngine) discard(key string) {
+	if entry, found := d.entries[key]; found {
+		d.garbage += int64(entry.length)
+	}
+}
+
+// compactIfNeeded compacts the file once the values overwritten or deleted take up more space than those
+// present, and at least the minimum.
+func (d *diskEngine) compactIfNeeded() error {
+	if d.garbage < minDiskGarbage || d.garbage <= d.end-d.garbage {
+		return nil
+	}
+
+	return d.compact()
+}
+
+// compact copies the values present to a new file, which then replaces the file.
+func (d *diskEngine) compact() error {
+	path := filepath.Join(d.dir, diskCompactedFile)
+
+	compacted, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to create compacted file: %w", err)
+	}
+
+	entries := make(map[string]diskEntry, len(d.entries))
+	end := int64(0)
+
+	for key, entry := range d.entries {
+		value, err := d.read(entry)
+		if err == nil {
+			_, err = compacted.WriteAt([]byte(value), end)
+		}
+
+		if err != nil {
+			_ = compacted.Close()
+			_ = os.Remove(path)
+
+			return fmt.Errorf("unable to compact values file: %w", err)
+		}
+
+		entries[key] = diskEntry{offset: end, length: entry.length, checksum: entry.checksum}
+		end += int64(entry.length)
+	}
+
+	if err := os.Rename(path, filepath.Join(d.dir, diskValuesFile)); err != nil {
+		_ = compacted.Close()
+
+		return fmt.Errorf("unable to replace values file: %w", err)
+	}
+
+	_ = d.file.Close()
+
+	d.file = compacted
+	d.entries = entries
+	d.end = end
+	d.garbage = 0
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package kvstore_test
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/kvstore/kvstoretest"
+	"testing"
+)
+
+// newDiskStore returns a store holding its strings on disk, in a new directory.
+func newDiskStore(t *testing.T) (*kvstore.KVStore, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	engine, err := kvstore.OpenDiskEngine(dir)
+	if err != nil {
+		t.Fatalf("Unable to open disk engine: %v", err)
+	}
+
+	return kvstore.NewKVStoreWithEngine(slog.Default(), engine), dir
+}
+
+func TestDiskEngineConformance(t *testing.T) {
+	kvstoretest.Run(t, func() kvstore.Store {
+		store, _ := newDiskStore(t)
+		return store
+	})
+}
+
+func TestDiskEngineCollections(t *testing.T) {
+	store, _ := newDiskStore(t)
+
+	kvstore.Write(store, key1, value1)
+	_ = store.HashSet(key2, "a", value2)
+
+	if valueType := store.Type(key1); valueType != kvstore.TypeString {
+		t.Fatalf("Type should have been string but was: %s", valueType)
+	}
+
+	if err := store.HashSet(key1, "a", value2); err == nil {
+		t.Fatal("Should have been wrong type")
+	}
+
+	if count := kvstore.Count(store); count != 2 {
+		t.Fatalf("Count should have been 2 but was: %d", count)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestDiskEngineCompacts(t *testing.T) {
+	store, dir := newDiskStore(t)
+
+	value := strings.Repeat("x", 1024)
+
+	// overwriting the key leaves its old values in the file, until compacted
+	for i := 0; i < 3000; i++ {
+		kvstore.Write(store, key1, value)
+	}
+
+	kvstore.Write(store, key2, value2)
+
+	info, err := os.Stat(filepath.Join(dir, "values.log"))
+	if err != nil || info.Size() > 2<<20 {
+		t.Fatalf("File should have been compacted but was: %v %v", info, err)
+	}
+
+	if read, ok := kvstore.Read(store, key1); !ok || read != value {
+		t.Fatalf("Value should have been kept but was: %t (length %d)", ok, len(read))
+	}
+
+	if read, ok := kvstore.Read(store, key2); !ok || read != value2 {
+		t.Fatalf("Value should have been %s but was: %t (value %s)", value2, ok, read)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestDiskEngineOpensEmpty(t *testing.T) {
+	store, dir := newDiskStore(t)
+
+	kvstore.Write(store, key1, value1)
+	kvstore.Close(store)
+
+	engine, err := kvstore.OpenDiskEngine(dir)
+	if err != nil {
+		t.Fatalf("Unable to reopen disk engine: %v", err)
+	}
+
+	if count := engine.Len(); count != 0 {
+		t.Fatalf("Should have been empty but had: %d", count)
+	}
+
+	_ = engine.Close()
+}
+
+var errEngineFailed = errors.New("engine failed")
+
+// failingEngine holds values in memory, failing every change, and every read, while failing is set.
+type failingEngine struct {
+	kvstore.StorageEngine
+	failing atomic.Bool
+}
+
+func (f *failingEngine) Get(key string) (string, bool, error) {
+	if f.failing.Load() {
+		return "", false, errEngineFailed
+	}
+
+	return f.StorageEngine.Get(key)
+}
+
+func (f *failingEngine) Put(key string, value string) error {
+	if f.failing.Load() {
+		return errEngineFailed
+	}
+
+	return f.StorageEngine.Put(key, value)
+}
+
+func (f *failingEngine) Delete(key string) error {
+	if f.failing.Load() {
+		return errEngineFailed
+	}
+
+	return f.StorageEngine.Delete(key)
+}
+
+func (f *failingEngine) Clear() error {
+	if f.failing.Load() {
+		return errEngineFailed
+	}
+
+	return f.StorageEngine.Clear()
+}
+
+func TestEngineFailures(t *testing.T) {
+	engine := &failingEngine{StorageEngine: kvstore.NewMemoryEngine()}
+	store := kvstore.NewKVStoreWithEngine(slog.Default(), engine)
+
+	kvstore.Write(store, key1, value1)
+	engine.failing.Store(true)
+
+	if _, _, err := store.ReadChecked(key1); !errors.Is(err, errEngineFailed) {
+		t.Fatalf("Read should have failed but was: %v", err)
+	}
+
+	if err := store.WriteChecked(key1, value2, 0); !errors.Is(err, errEngineFailed) {
+		t.Fatalf("Write should have failed but was: %v", err)
+	}
+
+	if written, err := store.WriteIfChecked(key2, value2, kvstore.WriteOptions{IfAbsent: true}); written ||
+		!errors.Is(err, errEngineFailed) {
+		t.Fatalf("Conditional write should have failed but was: %t %v", written, err)
+	}
+
+	if err := store.DeleteChecked(key1); !errors.Is(err, errEngineFailed) {
+		t.Fatalf("Delete should have failed but was: %v", err)
+	}
+
+	if err := store.ClearChecked(); !errors.Is(err, errEngineFailed) {
+		t.Fatalf("Clear should have failed but was: %v", err)
+	}
+
+	if _, err := store.SetBit(key1, 0, true); !errors.Is(err, errEngineFailed) {
+		t.Fatalf("Set bit should have failed but was: %v", err)
+	}
+
+	// the failed changes left the key unchanged
+	engine.failing.Store(false)
+
+	if value, ok := kvstore.Read(store, key1); !ok || value != value1 {
+		t.Fatalf("Key should have been unchanged but was: %t (value %s)", ok, value)
+	}
+
+	if count := kvstore.Count(store); count != 1 {
+		t.Fatalf("Count should have been 1 but was: %d", count)
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,235 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var errUnknownType = errors.New("unknown type")
+
+// Record is a key and the value it holds, as dumped by Dump, so the whole store can be copied by Load,
+// unlike a scan, which only sees strings. A string is held in Value, and a collection in Contents, encoded
+// as by Change.Contents.
+type Record struct {
+	Key      string          `json:"key"`
+	Type     Type            `json:"type"`
+	Value    string          `json:"value,omitempty"`
+	Contents json.RawMessage `json:"contents,omitempty"`
+
+	// when the key expires, zero if never
+	Expiry time.Time `json:"expiry"`
+}
+
+// Dump returns every key in the store, in key order, with its value and expiry.
+func Dump(s *KVStore) []Record {
+	var records []Record
+
+	s.run(func(now time.Time) {
+		s.removeExpired(now)
+
+		records = make([]Record, 0, s.engine.Len()+len(s.collections))
+
+		s.engine.Keys(func(key string) bool {
+			if record, found := s.record(key); found {
+				records = append(records, record)
+			}
+
+			return true
+		})
+
+		for key := range s.collections {
+			if record, found := s.record(key); found {
+				records = append(records, record)
+			}
+		}
+	})
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+
+	return records
+}
+
+// DumpPage returns up to limit keys after the key specified (from the first key if empty), in key order,
+// as Dump does, so the store can be copied a page at a time.
+func DumpPage(s *KVStore, after string, limit int) []Record {
+	var records []Record
+
+	s.run(func(now time.Time) {
+		s.removeExpired(now)
+
+		for _, key := range s.keysAfter(after, limit) {
+			if record, found := s.record(key); found {
+				records = append(records, record)
+			}
+		}
+	})
+
+	return records
+}
+
+// DumpKey returns the key as Dump does, and whether present.
+func DumpKey(s *KVStore, key string) (Record, bool) {
+	var record Record
+
+	var found bool
+
+	s.run(func(now time.Time) {
+		s.removeIfExpired(key, now)
+		record, found = s.record(key)
+	})
+
+	return record, found
+}
+
+// Load replaces every key in the store with the records dumped by Dump, skipping any that have since
+// expired. The observer sees every key removed, then each record set. Returns an error if a record can't
+// be decoded, the records before it having been loaded.
+func Load(s *KVStore, records []Record) error {
+	var err error
+
+	s.run(func(now time.Time) {
+		s.clear()
+
+		for _, record := range records {
+			if err = s.restore(record, now); err != nil {
+				return
+			}
+		}
+	})
+
+	return err
+}
+
+// Restore sets the record's key to the value dumped by Dump, replacing any value it holds, unless the
+// record has since expired. The observer sees the record set. Returns an error if the record can't be
+// decoded, the key then being unchanged.
+func Restore(s *KVStore, record Record) error {
+	var err error
+
+	s.run(func(now time.Time) {
+		err = s.restore(record, now)
+	})
+
+	return err
+}
+
+// record returns the key, with its value and expiry, and whether present.
+func (s *KVStore) record(key string) (Record, bool) {
+	if c, found := s.collections[key]; found {
+		contents, err := json.Marshal(c.contents())
+		if err != nil {
+			// never happens, the contents only holding strings and numbers
+			return Record{}, false
+		}
+
+		return Record{Key: key, Type: c.valueType(), Contents: contents, Expiry: s.expiries[key]}, true
+	}
+
+	value, found := s.value(key)
+	if !found {
+		return Record{}, false
+	}
+
+	return Record{Key: key, Type: TypeString, Value: value, Expiry: s.expiries[key]}, true
+}
+
+// restore sets the record's key to its value and expiry, replacing any value it holds, unless the record
+// has expired.
+func (s *KVStore) restore(record Record, now time.Time) error {
+	if !record.Expiry.IsZero() && !now.Before(record.Expiry) {
+		return nil
+	}
+
+	var c collection
+
+	if record.Type != TypeString {
+		var err error
+
+		if c, err = decodeCollection(record.Type, record.Contents); err != nil {
+			return fmt.Errorf("unable to load %s: %w", record.Key, err)
+		}
+	}
+
+	if c == nil {
+		if err := s.put(record.Key, record.Value); err != nil {
+			return err
+		}
+	} else if s.engine.Has(record.Key) {
+		if err := s.engine.Delete(record.Key); err != nil {
+			return fmt.Errorf("unable to delete %s: %w", record.Key, err)
+		}
+	}
+
+	delete(s.collections, record.Key)
+	delete(s.expiries, record.Key)
+
+	if c != nil && c.size() > 0 {
+		s.collections[record.Key] = c
+	}
+
+	if !record.Expiry.IsZero() {
+		s.expiries[record.Key] = record.Expiry
+	}
+
+	s.notifySet(record.Key)
+
+	return nil
+}
+
+// decodeCollection returns the collection of the type with the contents encoded as by Change.Contents.
+func decodeCollection(valueType Type, contents json.RawMessage) (collection, error) {
+	switch valueType {
+	case TypeHash:
+		h := hash{}
+		if err := json.Unmarshal(contents, &h); err != nil {
+			return nil, fmt.Errorf("invalid hash: %w", err)
+		}
+
+		return h, nil
+
+	case TypeList:
+		var elements []string
+		if err := json.Unmarshal(contents, &elements); err != nil {
+			return nil, fmt.Errorf("invalid list: %w", err)
+		}
+
+		l := &list{}
+		for _, element := range elements {
+			l.push(element, false)
+		}
+
+		return l, nil
+
+	case TypeSet:
+		var members []string
+		if err := json.Unmarshal(contents, &members); err != nil {
+			return nil, fmt.Errorf("invalid set: %w", err)
+		}
+
+		s := set{}
+		for _, member := range members {
+			s[member] = struct{}{}
+		}
+
+		return s, nil
+
+	case TypeSortedSet:
+		var members []ScoredMember
+		if err := json.Unmarshal(contents, &members); err != nil {
+			return nil, fmt.Errorf("invalid sorted set: %w", err)
+		}
+
+		z := newSortedSet()
+		for _, member := range members {
+			z.add(member.Member, member.Score)
+		}
+
+		return z, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownType, valueType)
+	}
+}
@@ -0,0 +1,157 @@
+package kvstore_test
+
+import (
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func TestDumpLoad(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	kvstore.WriteWithTTL(store, key1, value1, time.Hour)
+	_ = store.HashSet(key2, "a", value1)
+	_ = store.ListPush("list", value1, false)
+	_ = store.ListPush("list", value2, true)
+	_ = store.SetAdd("set", value1)
+	_ = store.SortedSetAdd("zset", "a", 2)
+	_ = store.SortedSetAdd("zset", "b", 1)
+
+	records := kvstore.Dump(store)
+	kvstore.Close(store)
+
+	if len(records) != 5 {
+		t.Fatalf("Should have dumped 5 keys but got: %v", records)
+	}
+
+	copied := kvstore.NewKVStore()
+	defer kvstore.Close(copied)
+
+	kvstore.Write(copied, "replaced", value1)
+
+	if err := kvstore.Load(copied, records); err != nil {
+		t.Fatalf("Should have loaded but got: %v", err)
+	}
+
+	if _, ok := kvstore.Read(copied, "replaced"); ok {
+		t.Fatal("Key not dumped should have been removed")
+	}
+
+	if metadata := copied.Metadata(key1); metadata.Type != kvstore.TypeString || metadata.TTL <= 0 {
+		t.Fatalf("Should have been a string with an expiry but was: %v", metadata)
+	}
+
+	if fields, _ := copied.HashGetAll(key2); !reflect.DeepEqual(fields, map[string]string{"a": value1}) {
+		t.Fatalf("Hash was not copied: %v", fields)
+	}
+
+	if elements, _ := copied.ListRange("list", 0, -1); !reflect.DeepEqual(elements, []string{value2, value1}) {
+		t.Fatalf("List was not copied: %v", elements)
+	}
+
+	if member, _ := copied.SetIsMember("set", value1); !member {
+		t.Fatal("Set was not copied")
+	}
+
+	expected := []kvstore.ScoredMember{{Member: "b", Score: 1}, {Member: "a", Score: 2}}
+	if members, _ := copied.SortedSetRange("zset", 0, -1); !reflect.DeepEqual(members, expected) {
+		t.Fatalf("Sorted set was not copied: %v", members)
+	}
+
+	if !reflect.DeepEqual(kvstore.Dump(copied), records) {
+		t.Fatal("Should have dumped the same records once loaded")
+	}
+}
+
+func TestLoadSkipsExpired(t *testing.T) {
+	store := kvstore.NewKVStore()
+	defer kvstore.Close(store)
+
+	records := []kvstore.Record{
+		{Key: key1, Type: kvstore.TypeString, Value: value1, Expiry: time.Now().Add(-time.Second)},
+	}
+
+	if err := kvstore.Load(store, records); err != nil {
+		t.Fatalf("Should have loaded but got: %v", err)
+	}
+
+	if count := kvstore.Count(store); count != 0 {
+		t.Fatalf("Should have been no keys but was: %d", count)
+	}
+
+	if err := kvstore.Load(store, []kvstore.Record{{Key: key1, Type: "unknown"}}); err == nil {
+		t.Fatal("Should have failed to load an unknown type")
+	}
+}
+
+func TestDumpPage(t *testing.T) {
+	store := kvstore.NewKVStore()
+	defer kvstore.Close(store)
+
+	kvstore.Write(store, "a", value1)
+	_ = store.HashSet("b", "f", value1)
+	_ = store.SetAdd("c", value1)
+	kvstore.Write(store, "d", value2)
+
+	records := kvstore.Dump(store)
+
+	if page := kvstore.DumpPage(store, "", 2); !reflect.DeepEqual(page, records[:2]) {
+		t.Fatalf("Should have dumped the first 2 keys but got: %v", page)
+	}
+
+	if page := kvstore.DumpPage(store, "b", 10); !reflect.DeepEqual(page, records[2:]) {
+		t.Fatalf("Should have dumped the keys after b but got: %v", page)
+	}
+
+	if page := kvstore.DumpPage(store, "d", 10); len(page) != 0 {
+		t.Fatalf("Should have been no keys after d but got: %v", page)
+	}
+
+	if record, found := kvstore.DumpKey(store, "b"); !found || !reflect.DeepEqual(record, records[1]) {
+		t.Fatalf("Should have dumped the hash but got: %v %v", record, found)
+	}
+
+	if _, found := kvstore.DumpKey(store, "z"); found {
+		t.Fatal("Should not have dumped a missing key")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	store := kvstore.NewKVStore()
+	defer kvstore.Close(store)
+
+	kvstore.Write(store, key1, value1)
+	_ = store.HashSet(key2, "a", value1)
+
+	list := kvstore.NewKVStore()
+	_ = list.ListPush(key1, value2, false)
+	record, _ := kvstore.DumpKey(list, key1)
+	kvstore.Close(list)
+
+	// replacing a string with a collection, and a collection with a string
+	if err := kvstore.Restore(store, record); err != nil {
+		t.Fatalf("Should have restored but got: %v", err)
+	}
+
+	if err := kvstore.Restore(store, kvstore.Record{Key: key2, Type: kvstore.TypeString, Value: value2}); err != nil {
+		t.Fatalf("Should have restored but got: %v", err)
+	}
+
+	if elements, _ := store.ListRange(key1, 0, -1); !reflect.DeepEqual(elements, []string{value2}) {
+		t.Fatalf("List was not restored: %v", elements)
+	}
+
+	if value, _ := kvstore.Read(store, key2); value != value2 {
+		t.Fatalf("String was not restored: %s", value)
+	}
+
+	invalid := kvstore.Record{Key: key2, Type: kvstore.TypeHash, Contents: []byte("[")}
+	if err := kvstore.Restore(store, invalid); err == nil {
+		t.Fatal("Should have failed to restore invalid contents")
+	}
+
+	if value, _ := kvstore.Read(store, key2); value != value2 {
+		t.Fatalf("Key should have been unchanged but was: %s", value)
+	}
+}
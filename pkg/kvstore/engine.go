@@ -0,0 +1,86 @@
+package kvstore
+
+// StorageEngine holds the strings of a KVStore, which keeps their expiries, and any collections, in memory
+// itself. It is only used by the store's go routine, so needn't be safe for concurrent use.
+type StorageEngine interface {
+	// Get returns the key's value, and whether the key is present.
+	Get(key string) (string, bool, error)
+
+	// Has returns whether the key is present, without reading its value.
+	Has(key string) bool
+
+	// Put sets or updates the key's value.
+	Put(key string, value string) error
+
+	// Delete removes the key, if present.
+	Delete(key string) error
+
+	// Keys calls fn with every key, in no particular order, until fn returns false. The keys must not be
+	// changed until it returns.
+	Keys(fn func(key string) bool)
+
+	// Len returns the number of keys.
+	Len() int
+
+	// Clear removes every key.
+	Clear() error
+
+	// Close releases the engine's resources, it must not be used afterwards.
+	Close() error
+}
+
+// memoryEngine holds every value in a map, the default engine.
+type memoryEngine struct {
+	values map[string]string
+}
+
+// NewMemoryEngine returns a storage engine holding every value in memory.
+func NewMemoryEngine() StorageEngine {
+	return &memoryEngine{values: make(map[string]string)}
+}
+
+func (m *memoryEngine) Get(key string) (string, bool, error) {
+	value, found := m.values[key]
+
+	return value, found, nil
+}
+
+func (m *memoryEngine) Has(key string) bool {
+	_, found := m.values[key]
+
+	return found
+}
+
+func (m *memoryEngine) Put(key string, value string) error {
+	m.values[key] = value
+
+	return nil
+}
+
+func (m *memoryEngine) Delete(key string) error {
+	delete(m.values, key)
+
+	return nil
+}
+
+func (m *memoryEngine) Keys(fn func(key string) bool) {
+	for key := range m.values {
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+func (m *memoryEngine) Len() int {
+	return len(m.values)
+}
+
+func (m *memoryEngine) Clear() error {
+	m.values = make(map[string]string)
+
+	return nil
+}
+
+func (m *memoryEngine) Close() error {
+	return nil
+}
@@ -1,38 +1,187 @@
 // Package kvstore provides a thread-safe key value store.
+//
+// Scans iterate over a snapshot of the store taken when the scan starts, so writes, deletes and
+// expiry during a scan never cause keys to be skipped, repeated or seen with a partially applied
+// change. Keys are visited in ascending byte order.
+//
+// As well as strings, a key can hold a collection, such as a hash, list, set or sorted set, changed in
+// place by the operations on its type. Reads, scans and pages only see strings, while writing a string
+// to a key replaces any collection it holds. Dump and Load copy every key, whatever it holds.
+//
+// The strings are held by a storage engine, in memory by default, or in a file on disk so they can take
+// up more space than there is memory, while the collections and expiries are always held in memory.
 package kvstore
 
-// KVStore is a thread-safe key value store.
+import (
+	"container/heap"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// how often expired keys are removed, keys are also treated as absent as soon as they expire.
+const expirySweepInterval = time.Second
+
+// Store is implemented by every key value store backend, which must be safe for concurrent use
+// and follow the semantics described in the package documentation. Backends can be checked using
+// the conformance tests in package kvstoretest.
+type Store interface {
+	// Read returns the value of the key, and whether the key was present.
+	Read(key string) (string, bool)
+
+	// Write sets or updates the key value, removing any expiry.
+	Write(key string, value string)
+
+	// WriteWithTTL sets or updates the key value, which expires after the time to live.
+	WriteWithTTL(key string, value string, ttl time.Duration)
+
+	// WriteIf sets or updates the key value if the options' conditions are met, checked and written
+	// atomically, returning whether it was written.
+	WriteIf(key string, value string, options WriteOptions) bool
+
+	// Persist removes the key's expiry, returning whether it had one.
+	Persist(key string) bool
+
+	// Delete removes the key, if present.
+	Delete(key string)
+
+	// Clear atomically removes every key.
+	Clear()
+
+	// Scan calls fn with a snapshot of the keys starting with the prefix, in key order, until fn returns false.
+	Scan(prefix string, fn func(key string, value string) bool)
+
+	// Close releases the store's resources, it must not be used afterwards.
+	Close()
+}
+
+// CheckedStore is implemented by stores that can fail to read or change a string, such as those holding
+// strings on disk, returning the failure rather than only logging it as the Store methods do, so a change
+// that wasn't made isn't acknowledged.
+type CheckedStore interface {
+	// ReadChecked returns the value of the key, and whether the key was present.
+	ReadChecked(key string) (string, bool, error)
+
+	// WriteChecked sets or updates the key value, which expires after the time to live unless zero.
+	WriteChecked(key string, value string, ttl time.Duration) error
+
+	// WriteIfChecked sets or updates the key value if the options' conditions are met, checked and written
+	// atomically, returning whether it was written.
+	WriteIfChecked(key string, value string, options WriteOptions) (bool, error)
+
+	// DeleteChecked removes the key, if present.
+	DeleteChecked(key string) error
+
+	// ClearChecked atomically removes every key.
+	ClearChecked() error
+}
+
+// KVStore is a thread-safe key value store, holding its strings in a storage engine.
 type KVStore struct {
-	data           map[string]string
+	engine         StorageEngine
+	collections    map[string]collection
+	expiries       map[string]time.Time
 	requestChannel chan *operationRequest
+	logger         *slog.Logger
+	observer       func(Change)
+}
+
+// Change is a key set, or removed by being deleted, expiring or the store being cleared. A collection
+// changed in place has its type, and no value, its contents being returned by Contents.
+type Change struct {
+	Key     string
+	Value   string
+	Deleted bool
+	Type    Type
+
+	// whether the key was removed by expiring, rather than being deleted
+	Expired bool
+
+	// when the key set expires (zero if never), or when the key removed by expiring expired
+	Expiry time.Time
+
+	// whether the key was removed to free up space, which the in-memory store never does, holding every
+	// key until deleted or expired
+	Evicted bool
+
+	collection collection
 }
 
 type operation int
 
 const (
-	readOperation   operation = iota
-	writeOperation  operation = iota
-	deleteOperation operation = iota
-	closeOperation  operation = iota
+	readOperation    operation = iota
+	writeOperation   operation = iota
+	deleteOperation  operation = iota
+	closeOperation   operation = iota
+	scanOperation    operation = iota
+	countOperation   operation = iota
+	clearOperation   operation = iota
+	pageOperation    operation = iota
+	observeOperation operation = iota
+	writeIfOperation operation = iota
+	persistOperation operation = iota
+	updateOperation  operation = iota
 )
 
 type operationRequest struct {
 	op              operation
 	key             string
 	value           string
+	ttl             time.Duration
+	limit           int
 	responseChannel chan<- *operationResponse
+	observer        func(Change)
+	options         WriteOptions
+	update          func(now time.Time)
 }
 
 type operationResponse struct {
 	value   string
 	present bool
+	entries []Entry
+	count   int
+	err     error
 }
 
-// NewKVStore returns a new key value store instance.
+// Entry is a key and its value, as returned by a scan.
+type Entry struct {
+	Key   string
+	Value string
+}
+
+// WriteOptions are the conditions a write is only made under, and the expiry it sets.
+type WriteOptions struct {
+	// only write if the key is absent, or only if it is present
+	IfAbsent  bool
+	IfPresent bool
+
+	// time to live of the value written, not expiring if zero, or keep any expiry the key already has
+	TTL     time.Duration
+	KeepTTL bool
+}
+
+// NewKVStore returns a new key value store instance, which logs to the default logger.
 func NewKVStore() *KVStore {
+	return NewKVStoreWithLogger(slog.Default())
+}
+
+// NewKVStoreWithLogger returns a new key value store instance, which logs to the logger.
+func NewKVStoreWithLogger(logger *slog.Logger) *KVStore {
+	return NewKVStoreWithEngine(logger, NewMemoryEngine())
+}
+
+// NewKVStoreWithEngine returns a new key value store instance holding its strings in the storage engine,
+// which it closes when closed, and logging to the logger.
+func NewKVStoreWithEngine(logger *slog.Logger, engine StorageEngine) *KVStore {
 	store := &KVStore{
-		make(map[string]string),
-		make(chan *operationRequest),
+		engine:         engine,
+		collections:    make(map[string]collection),
+		expiries:       make(map[string]time.Time),
+		requestChannel: make(chan *operationRequest),
+		logger:         logger,
 	}
 
 	// start the internal go routine
@@ -43,60 +192,624 @@ func NewKVStore() *KVStore {
 
 // Close shuts down the key value store cleanly.
 func Close(s *KVStore) {
-	s.requestChannel <- &operationRequest{closeOperation, "", "", nil}
+	s.requestChannel <- &operationRequest{op: closeOperation}
 }
 
 // Read returns the value of the specified key, and a flag indicating if the key was present.
+// A key the storage engine fails to read is treated as absent, the failure being logged.
 func Read(s *KVStore, key string) (string, bool) {
+	value, present, err := ReadChecked(s, key)
+	if err != nil {
+		s.logger.Error("unable to read from storage engine", "error", err)
+	}
+
+	return value, present
+}
+
+// ReadChecked returns the value of the specified key, and a flag indicating if the key was present,
+// failing if the storage engine can't read it.
+func ReadChecked(s *KVStore, key string) (string, bool, error) {
 	responseChannel := make(chan *operationResponse)
-	s.requestChannel <- &operationRequest{readOperation, key, "", responseChannel}
+	s.requestChannel <- &operationRequest{op: readOperation, key: key, responseChannel: responseChannel}
 
 	response := <-responseChannel
 
-	return response.value, response.present
+	return response.value, response.present, response.err
 }
 
-// Write sets or updates the key value.
+// Write sets or updates the key value. A storage engine failure is logged, leaving the key unchanged.
 func Write(s *KVStore, key string, value string) {
+	WriteWithTTL(s, key, value, 0)
+}
+
+// WriteWithTTL sets or updates the key value, which expires after the time to live.
+// The key is then treated as absent, and is removed in the background.
+func WriteWithTTL(s *KVStore, key string, value string, ttl time.Duration) {
+	if err := WriteChecked(s, key, value, ttl); err != nil {
+		s.logger.Error("unable to write to storage engine", "error", err)
+	}
+}
+
+// WriteChecked sets or updates the key value, which expires after the time to live unless zero,
+// failing if the storage engine can't write it.
+func WriteChecked(s *KVStore, key string, value string, ttl time.Duration) error {
 	responseChannel := make(chan *operationResponse)
-	s.requestChannel <- &operationRequest{writeOperation, key, value, responseChannel}
+	s.requestChannel <- &operationRequest{op: writeOperation, key: key, value: value, ttl: ttl,
+		responseChannel: responseChannel}
 
-	<-responseChannel
+	response := <-responseChannel
+
+	return response.err
+}
+
+// WriteIf sets or updates the key value if the options' conditions are met, checked and written atomically,
+// returning whether it was written.
+func WriteIf(s *KVStore, key string, value string, options WriteOptions) bool {
+	written, err := WriteIfChecked(s, key, value, options)
+	if err != nil {
+		s.logger.Error("unable to write to storage engine", "error", err)
+	}
+
+	return written
+}
+
+// WriteIfChecked sets or updates the key value if the options' conditions are met, checked and written
+// atomically, returning whether it was written, failing if the storage engine can't write it.
+func WriteIfChecked(s *KVStore, key string, value string, options WriteOptions) (bool, error) {
+	responseChannel := make(chan *operationResponse)
+	s.requestChannel <- &operationRequest{op: writeIfOperation, key: key, value: value, options: options,
+		responseChannel: responseChannel}
+
+	response := <-responseChannel
+
+	return response.present, response.err
+}
+
+// Persist removes the key's expiry, so it is kept until deleted, returning whether it had an expiry to remove.
+func Persist(s *KVStore, key string) bool {
+	responseChannel := make(chan *operationResponse)
+	s.requestChannel <- &operationRequest{op: persistOperation, key: key, responseChannel: responseChannel}
+
+	response := <-responseChannel
+
+	return response.present
+}
+
+// Scan calls fn with every key starting with the prefix (all keys if empty) and its value, in key order,
+// until fn returns false. The keys and values are a snapshot taken when the scan started, so fn can
+// safely modify the store.
+func Scan(s *KVStore, prefix string, fn func(key string, value string) bool) {
+	responseChannel := make(chan *operationResponse)
+	s.requestChannel <- &operationRequest{op: scanOperation, key: prefix, responseChannel: responseChannel}
+
+	response := <-responseChannel
+
+	for _, entry := range response.entries {
+		if !fn(entry.Key, entry.Value) {
+			return
+		}
+	}
+}
+
+// Page returns up to limit keys after the key specified (from the first key if empty) and their values,
+// in key order, so every key can be visited a page at a time without copying them all at once.
+func Page(s *KVStore, after string, limit int) []Entry {
+	responseChannel := make(chan *operationResponse)
+	s.requestChannel <- &operationRequest{op: pageOperation, key: after, limit: limit, responseChannel: responseChannel}
+
+	response := <-responseChannel
+
+	return response.entries
+}
+
+// Count returns the number of keys in the store.
+func Count(s *KVStore) int {
+	responseChannel := make(chan *operationResponse)
+	s.requestChannel <- &operationRequest{op: countOperation, responseChannel: responseChannel}
+
+	response := <-responseChannel
+
+	return response.count
 }
 
-// Delete removes a key (if present).
+// Delete removes a key (if present). A storage engine failure is logged, leaving the key unchanged.
 func Delete(s *KVStore, key string) {
+	if err := DeleteChecked(s, key); err != nil {
+		s.logger.Error("unable to delete from storage engine", "error", err)
+	}
+}
+
+// DeleteChecked removes a key (if present), failing if the storage engine can't delete it.
+func DeleteChecked(s *KVStore, key string) error {
+	responseChannel := make(chan *operationResponse)
+	s.requestChannel <- &operationRequest{op: deleteOperation, key: key, responseChannel: responseChannel}
+
+	response := <-responseChannel
+
+	return response.err
+}
+
+// Clear removes every key, atomically so no other operation sees a partially cleared store.
+// A storage engine failure is logged, leaving the keys unchanged.
+func Clear(s *KVStore) {
+	if err := ClearChecked(s); err != nil {
+		s.logger.Error("unable to clear storage engine", "error", err)
+	}
+}
+
+// ClearChecked removes every key, atomically so no other operation sees a partially cleared store,
+// failing if the storage engine can't clear them.
+func ClearChecked(s *KVStore) error {
 	responseChannel := make(chan *operationResponse)
-	s.requestChannel <- &operationRequest{deleteOperation, key, "", responseChannel}
+	s.requestChannel <- &operationRequest{op: clearOperation, responseChannel: responseChannel}
+
+	response := <-responseChannel
+
+	return response.err
+}
+
+// Observe calls observer with every change to the store from now on, in the order the changes are made,
+// replacing any previous observer. It is called by the store's go routine, so must be quick and must not
+// use the store.
+func Observe(s *KVStore, observer func(Change)) {
+	responseChannel := make(chan *operationResponse)
+	s.requestChannel <- &operationRequest{op: observeOperation, responseChannel: responseChannel, observer: observer}
 
 	<-responseChannel
 }
 
+// Read implements Store.
+func (s *KVStore) Read(key string) (string, bool) {
+	return Read(s, key)
+}
+
+// Write implements Store.
+func (s *KVStore) Write(key string, value string) {
+	Write(s, key, value)
+}
+
+// WriteWithTTL implements Store.
+func (s *KVStore) WriteWithTTL(key string, value string, ttl time.Duration) {
+	WriteWithTTL(s, key, value, ttl)
+}
+
+// Delete implements Store.
+func (s *KVStore) Delete(key string) {
+	Delete(s, key)
+}
+
+// Clear implements Store.
+func (s *KVStore) Clear() {
+	Clear(s)
+}
+
+// Scan implements Store.
+func (s *KVStore) Scan(prefix string, fn func(key string, value string) bool) {
+	Scan(s, prefix, fn)
+}
+
+// WriteIf implements Store.
+func (s *KVStore) WriteIf(key string, value string, options WriteOptions) bool {
+	return WriteIf(s, key, value, options)
+}
+
+// Persist implements Store.
+func (s *KVStore) Persist(key string) bool {
+	return Persist(s, key)
+}
+
+// ReadChecked implements CheckedStore.
+func (s *KVStore) ReadChecked(key string) (string, bool, error) {
+	return ReadChecked(s, key)
+}
+
+// WriteChecked implements CheckedStore.
+func (s *KVStore) WriteChecked(key string, value string, ttl time.Duration) error {
+	return WriteChecked(s, key, value, ttl)
+}
+
+// WriteIfChecked implements CheckedStore.
+func (s *KVStore) WriteIfChecked(key string, value string, options WriteOptions) (bool, error) {
+	return WriteIfChecked(s, key, value, options)
+}
+
+// DeleteChecked implements CheckedStore.
+func (s *KVStore) DeleteChecked(key string) error {
+	return DeleteChecked(s, key)
+}
+
+// ClearChecked implements CheckedStore.
+func (s *KVStore) ClearChecked() error {
+	return ClearChecked(s)
+}
+
+// Close implements Store.
+func (s *KVStore) Close() {
+	Close(s)
+}
+
 // handleStoreOperations provides thread-safety for the key value store, by performing operations
 // on the store in a single go routine in serial, with input provided through messages on a channel.
 func handleStoreOperations(store *KVStore) {
 	go func() {
+		ticker := time.NewTicker(expirySweepInterval)
+		defer ticker.Stop()
+
 		for {
-			request := <-store.requestChannel
+			var request *operationRequest
+
+			select {
+			case request = <-store.requestChannel:
+			case now := <-ticker.C:
+				if removed := store.removeExpired(now); removed > 0 {
+					store.logger.Debug("removed expired keys", "count", removed)
+				}
+
+				continue
+			}
+
 			switch request.op {
 			case readOperation:
-				// read key, if present
-				value, present := store.data[request.key]
-				request.responseChannel <- &operationResponse{value, present}
+				// read key, if present and not expired
+				var (
+					value   string
+					present bool
+					err     error
+				)
+
+				if !store.removeIfExpired(request.key, time.Now()) {
+					value, present, err = store.get(request.key)
+				}
+
+				request.responseChannel <- &operationResponse{value, present, nil, 0, err}
 
 			case writeOperation:
-				// add or update key
-				store.data[request.key] = request.value
-				request.responseChannel <- &operationResponse{"", false}
+				// add or update key, replacing any previous expiry or collection
+				err := store.write(request.key, request.value, request.ttl)
+				request.responseChannel <- &operationResponse{"", false, nil, 0, err}
+
+			case writeIfOperation:
+				written, err := store.writeIf(request.key, request.value, request.options, time.Now())
+				request.responseChannel <- &operationResponse{"", written, nil, 0, err}
+
+			case persistOperation:
+				removed := store.persist(request.key, time.Now())
+				request.responseChannel <- &operationResponse{"", removed, nil, 0, nil}
 
 			case deleteOperation:
 				// delete key, does nothing if not present
-				delete(store.data, request.key)
-				request.responseChannel <- &operationResponse{"", false}
+				err := store.remove(request.key)
+				request.responseChannel <- &operationResponse{"", false, nil, 0, err}
+
+			case scanOperation:
+				// copy matching keys, so the scan isn't affected by later changes
+				store.removeExpired(time.Now())
+				request.responseChannel <- &operationResponse{"", false, store.entries(request.key), 0, nil}
+
+			case pageOperation:
+				store.removeExpired(time.Now())
+				request.responseChannel <- &operationResponse{"", false, store.page(request.key, request.limit), 0, nil}
+
+			case countOperation:
+				store.removeExpired(time.Now())
+				count := store.engine.Len() + len(store.collections)
+				request.responseChannel <- &operationResponse{"", false, nil, count, nil}
+
+			case clearOperation:
+				err := store.clear()
+				request.responseChannel <- &operationResponse{"", false, nil, 0, err}
+
+			case observeOperation:
+				store.observer = request.observer
+				request.responseChannel <- &operationResponse{"", false, nil, 0, nil}
+
+			case updateOperation:
+				request.update(time.Now())
+				request.responseChannel <- &operationResponse{"", false, nil, 0, nil}
 
 			case closeOperation:
+				if err := store.engine.Close(); err != nil {
+					store.logger.Error("unable to close storage engine", "error", err)
+				}
+
+				store.logger.Debug("store closed")
 				return
 			}
 		}
 	}()
 }
+
+// entries returns the keys starting with the prefix and their values, sorted by key.
+func (s *KVStore) entries(prefix string) []Entry {
+	var entries []Entry
+
+	s.engine.Keys(func(key string) bool {
+		if strings.HasPrefix(key, prefix) {
+			if value, found := s.value(key); found {
+				entries = append(entries, Entry{key, value})
+			}
+		}
+
+		return true
+	})
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	return entries
+}
+
+// page returns up to limit keys after the key specified and their values, sorted by key.
+func (s *KVStore) page(after string, limit int) []Entry {
+	keys := smallestKeys(after, limit, func(add func(key string)) {
+		s.engine.Keys(func(key string) bool {
+			add(key)
+			return true
+		})
+	})
+
+	entries := make([]Entry, 0, len(keys))
+
+	for _, key := range keys {
+		if value, found := s.value(key); found {
+			entries = append(entries, Entry{key, value})
+		}
+	}
+
+	return entries
+}
+
+// keysAfter returns up to limit keys of any type after the key specified, sorted.
+func (s *KVStore) keysAfter(after string, limit int) []string {
+	return smallestKeys(after, limit, func(add func(key string)) {
+		s.engine.Keys(func(key string) bool {
+			add(key)
+			return true
+		})
+
+		for key := range s.collections {
+			add(key)
+		}
+	})
+}
+
+// smallestKeys returns up to limit of the keys passed to add by keys that are after the key specified,
+// sorted. Only the smallest limit keys seen so far are kept, in a heap, so a page of n keys costs
+// O(n log limit) rather than sorting them all.
+func smallestKeys(after string, limit int, keys func(add func(key string))) []string {
+	var h keyHeap
+
+	keys(func(key string) {
+		switch {
+		case key <= after || limit <= 0:
+		case len(h) < limit:
+			heap.Push(&h, key)
+		case key < h[0]:
+			h[0] = key
+			heap.Fix(&h, 0)
+		}
+	})
+
+	sorted := make([]string, len(h))
+
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i], _ = heap.Pop(&h).(string)
+	}
+
+	return sorted
+}
+
+// keyHeap implements heap.Interface, holding keys with the largest first.
+type keyHeap []string
+
+func (h keyHeap) Len() int           { return len(h) }
+func (h keyHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h keyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *keyHeap) Push(key any) {
+	if key, ok := key.(string); ok {
+		*h = append(*h, key)
+	}
+}
+
+func (h *keyHeap) Pop() any {
+	old := *h
+	key := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return key
+}
+
+// write sets the key value, replacing any previous expiry or collection, unless the storage engine fails.
+func (s *KVStore) write(key string, value string, ttl time.Duration) error {
+	if err := s.put(key, value); err != nil {
+		return err
+	}
+
+	delete(s.collections, key)
+	s.setExpiry(key, ttl)
+	s.notify(Change{Key: key, Value: value})
+
+	return nil
+}
+
+// writeIf sets the key value if the options' conditions are met, returning whether it was written.
+func (s *KVStore) writeIf(key string, value string, options WriteOptions, now time.Time) (bool, error) {
+	s.removeIfExpired(key, now)
+	present := s.engine.Has(key)
+	_, collection := s.collections[key]
+
+	if (options.IfAbsent && (present || collection)) || (options.IfPresent && !present && !collection) {
+		return false, nil
+	}
+
+	if err := s.put(key, value); err != nil {
+		return false, err
+	}
+
+	delete(s.collections, key)
+
+	if !options.KeepTTL {
+		s.setExpiry(key, options.TTL)
+	}
+
+	s.notify(Change{Key: key, Value: value})
+
+	return true, nil
+}
+
+// persist removes the key's expiry, returning whether it had one. Observers see the key written again,
+// as it now never expires.
+func (s *KVStore) persist(key string, now time.Time) bool {
+	if _, found := s.expiries[key]; !found || s.removeIfExpired(key, now) {
+		return false
+	}
+
+	delete(s.expiries, key)
+	s.notifySet(key)
+
+	return true
+}
+
+func (s *KVStore) setExpiry(key string, ttl time.Duration) {
+	if ttl > 0 {
+		s.expiries[key] = time.Now().Add(ttl)
+	} else {
+		delete(s.expiries, key)
+	}
+}
+
+// clear removes every key, passing each to the observer, unless the storage engine fails to clear.
+func (s *KVStore) clear() error {
+	keys := make([]string, 0, s.engine.Len()+len(s.collections))
+
+	s.engine.Keys(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	if err := s.engine.Clear(); err != nil {
+		return fmt.Errorf("unable to clear: %w", err)
+	}
+
+	for key := range s.collections {
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		s.notify(Change{Key: key, Deleted: true})
+	}
+
+	s.logger.Debug("store cleared", "count", len(keys))
+
+	s.collections = make(map[string]collection)
+	s.expiries = make(map[string]time.Time)
+
+	return nil
+}
+
+// removeIfExpired removes the key if it has expired, returning whether it was removed. A key the storage
+// engine fails to delete is treated as removed, keeping its expiry so the removal is retried.
+func (s *KVStore) removeIfExpired(key string, now time.Time) bool {
+	if expiry, found := s.expiries[key]; found && !now.Before(expiry) {
+		if err := s.removeAs(Change{Key: key, Deleted: true, Expired: true, Expiry: expiry}); err != nil {
+			s.logger.Error("unable to remove expired key", "error", err)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// remove deletes the key, if present.
+func (s *KVStore) remove(key string) error {
+	return s.removeAs(Change{Key: key, Deleted: true})
+}
+
+// removeAs deletes the change's key, if present, passing the change to the observer, unless the storage
+// engine fails to delete it.
+func (s *KVStore) removeAs(change Change) error {
+	found := s.engine.Has(change.Key)
+	_, collection := s.collections[change.Key]
+
+	if found {
+		if err := s.engine.Delete(change.Key); err != nil {
+			return fmt.Errorf("unable to delete %s: %w", change.Key, err)
+		}
+	}
+
+	if found || collection {
+		delete(s.collections, change.Key)
+		s.notify(change)
+	}
+
+	delete(s.expiries, change.Key)
+
+	return nil
+}
+
+// notifySet passes the key's current value, or its collection, to the observer, if any.
+func (s *KVStore) notifySet(key string) {
+	if c, found := s.collections[key]; found {
+		s.notify(Change{Key: key, Type: c.valueType(), collection: c})
+	} else {
+		value, _ := s.value(key)
+		s.notify(Change{Key: key, Value: value})
+	}
+}
+
+// value returns the key's string, and whether present, treating one the storage engine fails to read as
+// absent.
+func (s *KVStore) value(key string) (string, bool) {
+	value, found, err := s.get(key)
+	if err != nil {
+		s.logger.Error("unable to read from storage engine", "error", err)
+	}
+
+	return value, found
+}
+
+// get returns the key's string, and whether present, failing if the storage engine can't read it.
+func (s *KVStore) get(key string) (string, bool, error) {
+	value, found, err := s.engine.Get(key)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to read %s: %w", key, err)
+	}
+
+	return value, found, nil
+}
+
+// put sets the key's string in the storage engine.
+func (s *KVStore) put(key string, value string) error {
+	if err := s.engine.Put(key, value); err != nil {
+		return fmt.Errorf("unable to write %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// notify passes the change to the observer, if any, along with the expiry of a key set.
+func (s *KVStore) notify(change Change) {
+	if s.observer == nil {
+		return
+	}
+
+	if !change.Deleted {
+		change.Expiry = s.expiries[change.Key]
+	}
+
+	s.observer(change)
+}
+
+// removeExpired removes every expired key, returning how many were removed.
+func (s *KVStore) removeExpired(now time.Time) int {
+	removed := 0
+
+	for key := range s.expiries {
+		if s.removeIfExpired(key, now) {
+			removed++
+		}
+	}
+
+	return removed
+}
@@ -1,11 +1,17 @@
 package kvstore_test
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
 	"tcp/pkg/kvstore"
+	"tcp/pkg/kvstore/kvstoretest"
 	"testing"
+	"time"
 )
 
 const key1 = "key1"
+const key2 = "key2"
 const value1 = "ABC"
 const value2 = "DEF"
 
@@ -76,3 +82,182 @@ func TestDelete(t *testing.T) {
 
 	kvstore.Close(store)
 }
+
+func TestWriteWithTTL(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	kvstore.WriteWithTTL(store, key1, value1, 50*time.Millisecond)
+
+	if value, ok := kvstore.Read(store, key1); !ok || value != value1 {
+		t.Fatalf("Key should have been present but was: %t (value %s)", ok, value)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if value, ok := kvstore.Read(store, key1); ok {
+		t.Fatalf("Key should have expired but was: %t (value %s)", ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestWriteRemovesTTL(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	kvstore.WriteWithTTL(store, key1, value1, 50*time.Millisecond)
+	kvstore.Write(store, key1, value2) // update value, without expiry
+
+	time.Sleep(60 * time.Millisecond)
+
+	if value, ok := kvstore.Read(store, key1); !ok || value != value2 {
+		t.Fatalf("Key should have been present but was: %t (value %s)", ok, value)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestConformance(t *testing.T) {
+	kvstoretest.Run(t, func() kvstore.Store {
+		return kvstore.NewKVStore()
+	})
+}
+
+func TestObservePersist(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	var changes []kvstore.Change
+
+	kvstore.WriteWithTTL(store, key1, value1, time.Minute)
+
+	kvstore.Observe(store, func(change kvstore.Change) {
+		changes = append(changes, change)
+	})
+
+	kvstore.Persist(store, key1)
+	kvstore.Persist(store, key1) // no expiry, so unchanged
+
+	expected := []kvstore.Change{{Key: key1, Value: value1}}
+
+	// read after a store operation, so the observer has been called
+	kvstore.Count(store)
+
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %v but got %v", expected, changes)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestCount(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	kvstore.Write(store, key1, value1)
+	kvstore.Write(store, "key2", value2)
+	kvstore.Delete(store, key1)
+
+	if count := kvstore.Count(store); count != 1 {
+		t.Fatalf("Should have been 1 key but was: %d", count)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestPage(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	for _, key := range []string{"c", "a", "d", "b"} {
+		kvstore.Write(store, key, value1)
+	}
+
+	if page := kvstore.Page(store, "", 2); len(page) != 2 || page[0].Key != "a" || page[1].Key != "b" {
+		t.Fatalf("Should have been keys a and b but was: %v", page)
+	}
+
+	if page := kvstore.Page(store, "b", 5); len(page) != 2 || page[0].Key != "c" || page[1].Key != "d" {
+		t.Fatalf("Should have been keys c and d but was: %v", page)
+	}
+
+	if page := kvstore.Page(store, "d", 5); len(page) != 0 {
+		t.Fatalf("Should have been no keys but was: %v", page)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestPageEveryKey(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	// written out of order, each page holding the smallest keys after the last
+	for i := 0; i < 100; i++ {
+		kvstore.Write(store, fmt.Sprintf("key%02d", i*37%100), value1)
+	}
+
+	var keys []string
+
+	for after := ""; ; {
+		page := kvstore.Page(store, after, 7)
+		if len(page) == 0 {
+			break
+		}
+
+		for _, entry := range page {
+			keys = append(keys, entry.Key)
+		}
+
+		after = page[len(page)-1].Key
+	}
+
+	if len(keys) != 100 || !sort.StringsAreSorted(keys) {
+		t.Fatalf("Should have been every key in order but was: %v", keys)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestObserve(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	var changes []kvstore.Change
+
+	kvstore.Observe(store, func(change kvstore.Change) {
+		changes = append(changes, change)
+	})
+
+	kvstore.Write(store, key1, value1)
+	kvstore.Delete(store, key1)
+	kvstore.Delete(store, key1) // not present, so unchanged
+	kvstore.WriteWithTTL(store, key2, value2, time.Millisecond)
+
+	time.Sleep(5 * time.Millisecond)
+	kvstore.Read(store, key2) // expired
+
+	kvstore.Write(store, key1, value1)
+	kvstore.Clear(store)
+
+	expected := []kvstore.Change{
+		{Key: key1, Value: value1},
+		{Key: key1, Deleted: true},
+		{Key: key2, Value: value2},
+		{Key: key2, Deleted: true, Expired: true},
+		{Key: key1, Value: value1},
+		{Key: key1, Deleted: true},
+	}
+
+	// read after a store operation, so the observer has been called
+	kvstore.Count(store)
+
+	// the key written with a time to live, and removed by expiring, has its expiry
+	for i := 2; i < 4 && i < len(changes); i++ {
+		if changes[i].Expiry.IsZero() {
+			t.Errorf("Expected an expiry but got %v", changes[i])
+		}
+
+		changes[i].Expiry = time.Time{}
+	}
+
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %v but got %v", expected, changes)
+	}
+
+	kvstore.Close(store)
+}
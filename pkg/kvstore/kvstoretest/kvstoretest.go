@@ -0,0 +1,327 @@
+// Package kvstoretest provides conformance tests for key value store backends, so every
+// implementation of kvstore.Store behaves the same way.
+package kvstoretest
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+const (
+	key1   = "key1"
+	key2   = "key2"
+	value1 = "ABC"
+	value2 = "DEF"
+
+	// how long a store can take to close
+	closeTimeout = 5 * time.Second
+)
+
+// Run runs the conformance tests against stores created by newStore, which must return
+// a new empty store each time it is called.
+func Run(t *testing.T, newStore func() kvstore.Store) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		test func(*testing.T, kvstore.Store)
+	}{
+		{"EmptyRead", testEmptyRead},
+		{"ReadAndWrite", testReadAndWrite},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"Clear", testClear},
+		{"EmptyValue", testEmptyValue},
+		{"TTL", testTTL},
+		{"WriteRemovesTTL", testWriteRemovesTTL},
+		{"WriteIf", testWriteIf},
+		{"WriteIfKeepTTL", testWriteIfKeepTTL},
+		{"Persist", testPersist},
+		{"Scan", testScan},
+		{"ScanSnapshot", testScanSnapshot},
+		{"ScanStop", testScanStop},
+		{"Concurrent", testConcurrent},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			store := newStore()
+			test.test(t, store)
+			closeStore(t, store)
+		})
+	}
+}
+
+func testEmptyRead(t *testing.T, store kvstore.Store) {
+	if value, ok := store.Read(key1); ok {
+		t.Errorf("Should have been empty but was: %t value %s", ok, value)
+	}
+}
+
+func testReadAndWrite(t *testing.T, store kvstore.Store) {
+	store.Write(key1, value1)
+
+	checkValue(t, store, key1, value1)
+}
+
+func testUpdate(t *testing.T, store kvstore.Store) {
+	store.Write(key1, value1)
+	store.Write(key1, value2)
+
+	checkValue(t, store, key1, value2)
+}
+
+func testDelete(t *testing.T, store kvstore.Store) {
+	store.Delete(key1) // key not present
+
+	store.Write(key1, value1)
+	store.Delete(key1)
+
+	checkAbsent(t, store, key1)
+}
+
+func testClear(t *testing.T, store kvstore.Store) {
+	store.Write(key1, value1)
+	store.WriteWithTTL(key2, value2, time.Hour)
+	store.Clear()
+
+	checkAbsent(t, store, key1)
+	checkAbsent(t, store, key2)
+	checkScan(t, store, "", nil)
+
+	store.Write(key1, value2)
+
+	checkValue(t, store, key1, value2)
+}
+
+func testEmptyValue(t *testing.T, store kvstore.Store) {
+	store.Write(key1, "")
+
+	checkValue(t, store, key1, "")
+}
+
+func testTTL(t *testing.T, store kvstore.Store) {
+	store.WriteWithTTL(key1, value1, 50*time.Millisecond)
+
+	checkValue(t, store, key1, value1)
+
+	time.Sleep(60 * time.Millisecond)
+
+	checkAbsent(t, store, key1)
+}
+
+func testWriteRemovesTTL(t *testing.T, store kvstore.Store) {
+	store.WriteWithTTL(key1, value1, 50*time.Millisecond)
+	store.Write(key1, value2)
+
+	time.Sleep(60 * time.Millisecond)
+
+	checkValue(t, store, key1, value2)
+}
+
+func testWriteIf(t *testing.T, store kvstore.Store) {
+	if store.WriteIf(key1, value1, kvstore.WriteOptions{IfPresent: true}) {
+		t.Errorf("Should not have written absent key")
+	}
+
+	if !store.WriteIf(key1, value1, kvstore.WriteOptions{IfAbsent: true}) {
+		t.Errorf("Should have written absent key")
+	}
+
+	if store.WriteIf(key1, value2, kvstore.WriteOptions{IfAbsent: true}) {
+		t.Errorf("Should not have written present key")
+	}
+
+	checkValue(t, store, key1, value1)
+
+	if !store.WriteIf(key1, value2, kvstore.WriteOptions{IfPresent: true}) {
+		t.Errorf("Should have written present key")
+	}
+
+	checkValue(t, store, key1, value2)
+}
+
+func testWriteIfKeepTTL(t *testing.T, store kvstore.Store) {
+	store.WriteIf(key1, value1, kvstore.WriteOptions{TTL: 50 * time.Millisecond})
+	store.WriteIf(key1, value2, kvstore.WriteOptions{KeepTTL: true})
+
+	checkValue(t, store, key1, value2)
+
+	time.Sleep(60 * time.Millisecond)
+
+	checkAbsent(t, store, key1)
+
+	// an expired key is absent
+	if !store.WriteIf(key1, value1, kvstore.WriteOptions{IfAbsent: true}) {
+		t.Errorf("Should have written expired key")
+	}
+}
+
+func testPersist(t *testing.T, store kvstore.Store) {
+	if store.Persist(key1) {
+		t.Errorf("Should not have removed expiry of absent key")
+	}
+
+	store.Write(key1, value1)
+
+	if store.Persist(key1) {
+		t.Errorf("Should not have removed expiry of key without one")
+	}
+
+	store.WriteWithTTL(key1, value1, 50*time.Millisecond)
+
+	if !store.Persist(key1) {
+		t.Errorf("Should have removed expiry")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	checkValue(t, store, key1, value1)
+}
+
+func testScan(t *testing.T, store kvstore.Store) {
+	store.Write("b/2", value2)
+	store.Write("a/1", value1)
+	store.Write("b/1", value1)
+	store.WriteWithTTL("b/3", value1, time.Nanosecond)
+
+	time.Sleep(time.Millisecond)
+
+	// in key order, excluding other prefixes and expired keys
+	checkScan(t, store, "b/", []string{"b/1=" + value1, "b/2=" + value2})
+	checkScan(t, store, "", []string{"a/1=" + value1, "b/1=" + value1, "b/2=" + value2})
+}
+
+func testScanSnapshot(t *testing.T, store kvstore.Store) {
+	store.Write("a", value1)
+	store.Write("b", value1)
+
+	var entries []string
+
+	store.Scan("", func(key string, value string) bool {
+		// changes during the scan aren't seen by it
+		store.Delete("b")
+		store.Write("a", value2)
+		store.Write("c", value2)
+
+		entries = append(entries, key+"="+value)
+
+		return true
+	})
+
+	if !reflect.DeepEqual(entries, []string{"a=" + value1, "b=" + value1}) {
+		t.Errorf("Scan should have returned the snapshot but was: %v", entries)
+	}
+
+	checkScan(t, store, "", []string{"a=" + value2, "c=" + value2})
+}
+
+func testScanStop(t *testing.T, store kvstore.Store) {
+	store.Write("a", value1)
+	store.Write("b", value1)
+
+	visited := 0
+
+	store.Scan("", func(key string, value string) bool {
+		visited++
+		return false
+	})
+
+	if visited != 1 {
+		t.Errorf("Scan should have stopped after 1 key but visited: %d", visited)
+	}
+}
+
+func testConcurrent(t *testing.T, store kvstore.Store) {
+	const goroutines, writes = 10, 100
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < writes; i++ {
+				key := strconv.Itoa(g) + "/" + strconv.Itoa(i)
+
+				store.Write(key, value1)
+				store.Read(key)
+				store.Scan(strconv.Itoa(g)+"/", func(string, string) bool { return true })
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	count := 0
+
+	store.Scan("", func(string, string) bool {
+		count++
+		return true
+	})
+
+	if count != goroutines*writes {
+		t.Errorf("Expected %d keys but found %d", goroutines*writes, count)
+	}
+}
+
+func closeStore(t *testing.T, store kvstore.Store) {
+	t.Helper()
+
+	closed := make(chan struct{})
+
+	go func() {
+		store.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(closeTimeout):
+		t.Errorf("Store didn't close within %v", closeTimeout)
+	}
+}
+
+func checkValue(t *testing.T, store kvstore.Store, key string, expected string) {
+	t.Helper()
+
+	value, ok := store.Read(key)
+	if !ok {
+		t.Errorf("Key %s should have been present", key)
+	}
+
+	if value != expected {
+		t.Errorf("Key %s value should have been %s but was: %s", key, expected, value)
+	}
+}
+
+func checkAbsent(t *testing.T, store kvstore.Store, key string) {
+	t.Helper()
+
+	if value, ok := store.Read(key); ok {
+		t.Errorf("Key %s should not be present but was: %t (value %s)", key, ok, value)
+	}
+}
+
+func checkScan(t *testing.T, store kvstore.Store, prefix string, expected []string) {
+	t.Helper()
+
+	var entries []string
+
+	store.Scan(prefix, func(key string, value string) bool {
+		entries = append(entries, key+"="+value)
+		return true
+	})
+
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Scan of %q should have returned %v but was: %v", prefix, expected, entries)
+	}
+}
@@ -0,0 +1,198 @@
+package kvstore
+
+import "time"
+
+// the capacity of a list when created, doubled each time it fills up
+const initialListCapacity = 8
+
+// ListStore is implemented by stores holding lists: ordered values kept under a key, pushed and popped
+// at either end, so can be used as queues or to keep the most recent items. A list is absent once it has
+// no elements.
+//
+// Ranges are of the elements from the start index to the stop index inclusive, counting back from the end
+// of the list if negative (so -1 is the last element), as in Redis.
+type ListStore interface {
+	TypedStore
+
+	// ListPush adds the value to the start of the key's list if left, otherwise the end, creating the list
+	// if the key is absent.
+	ListPush(key string, value string, left bool) error
+
+	// ListPop removes and returns the first element of the key's list if left, otherwise the last, and
+	// whether there was one.
+	ListPop(key string, left bool) (string, bool, error)
+
+	// ListRange returns the elements of the key's list in the range, empty if the key is absent.
+	ListRange(key string, start int, stop int) ([]string, error)
+
+	// ListTrim removes the elements of the key's list outside the range.
+	ListTrim(key string, start int, stop int) error
+}
+
+// list is held in a ring buffer, so elements can be pushed and popped at either end without copying the
+// others.
+type list struct {
+	elements []string
+	head     int
+	length   int
+}
+
+func (l *list) valueType() Type {
+	return TypeList
+}
+
+func (l *list) size() int {
+	return l.length
+}
+
+func (l *list) contents() any {
+	return l.slice(0, l.length-1)
+}
+
+// index returns the position in the buffer of the element at the index.
+func (l *list) index(i int) int {
+	return (l.head + i) % len(l.elements)
+}
+
+// slice returns the elements from the index first to last inclusive, none if first is after last.
+func (l *list) slice(first int, last int) []string {
+	elements := make([]string, 0, max(last-first+1, 0))
+
+	for i := first; i <= last; i++ {
+		elements = append(elements, l.elements[l.index(i)])
+	}
+
+	return elements
+}
+
+func (l *list) push(value string, left bool) {
+	if l.length == len(l.elements) {
+		grown := make([]string, max(initialListCapacity, 2*len(l.elements)))
+		copy(grown, l.slice(0, l.length-1))
+
+		l.elements = grown
+		l.head = 0
+	}
+
+	if left {
+		l.head = (l.head + len(l.elements) - 1) % len(l.elements)
+		l.elements[l.head] = value
+	} else {
+		l.elements[l.index(l.length)] = value
+	}
+
+	l.length++
+}
+
+func (l *list) pop(left bool) string {
+	i := l.index(l.length - 1)
+	if left {
+		i = l.head
+		l.head = l.index(1)
+	}
+
+	value := l.elements[i]
+	l.elements[i] = "" // so the value can be garbage collected
+	l.length--
+
+	return value
+}
+
+// rangeBounds returns the first and last index of the range of a collection with the length, the first
+// being after the last if the range is empty.
+func rangeBounds(length int, start int, stop int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+
+	if stop < 0 {
+		stop += length
+	}
+
+	return max(start, 0), min(stop, length-1)
+}
+
+// ListPush implements ListStore.
+func (s *KVStore) ListPush(key string, value string, left bool) error {
+	var err error
+
+	s.run(func(now time.Time) {
+		var l *list
+
+		if l, _, err = collectionOf(s, key, func() *list { return &list{} }, now); err == nil {
+			l.push(value, left)
+			s.changed(key, l)
+		}
+	})
+
+	return err
+}
+
+// ListPop implements ListStore.
+func (s *KVStore) ListPop(key string, left bool) (string, bool, error) {
+	var (
+		value string
+		found bool
+		err   error
+	)
+
+	s.run(func(now time.Time) {
+		var l *list
+
+		if l, found, err = collectionOf[*list](s, key, nil, now); found {
+			value = l.pop(left)
+			s.changed(key, l)
+		}
+	})
+
+	return value, found, err
+}
+
+// ListRange implements ListStore.
+func (s *KVStore) ListRange(key string, start int, stop int) ([]string, error) {
+	var (
+		elements []string
+		err      error
+	)
+
+	s.run(func(now time.Time) {
+		var (
+			l     *list
+			found bool
+		)
+
+		if l, found, err = collectionOf[*list](s, key, nil, now); found {
+			elements = l.slice(rangeBounds(l.length, start, stop))
+		}
+	})
+
+	return elements, err
+}
+
+// ListTrim implements ListStore.
+func (s *KVStore) ListTrim(key string, start int, stop int) error {
+	var err error
+
+	s.run(func(now time.Time) {
+		var (
+			l     *list
+			found bool
+		)
+
+		if l, found, err = collectionOf[*list](s, key, nil, now); !found {
+			return
+		}
+
+		first, last := rangeBounds(l.length, start, stop)
+		if first == 0 && last == l.length-1 {
+			// nothing removed
+			return
+		}
+
+		elements := l.slice(first, last)
+		*l = list{elements: elements, length: len(elements)}
+		s.changed(key, l)
+	})
+
+	return err
+}
@@ -0,0 +1,185 @@
+package kvstore_test
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	if err := store.ListPush(key1, "b", true); err != nil {
+		t.Fatalf("Should have pushed but got: %v", err)
+	}
+
+	_ = store.ListPush(key1, "a", true)
+	_ = store.ListPush(key1, "c", false)
+
+	expected := []string{"a", "b", "c"}
+	if elements, err := store.ListRange(key1, 0, -1); !reflect.DeepEqual(elements, expected) || err != nil {
+		t.Fatalf("Elements should have been %v but were: %v %v", expected, elements, err)
+	}
+
+	if value, ok, err := store.ListPop(key1, true); !ok || value != "a" || err != nil {
+		t.Fatalf("Should have popped a but was: %t (value %s) %v", ok, value, err)
+	}
+
+	if value, ok, err := store.ListPop(key1, false); !ok || value != "c" || err != nil {
+		t.Fatalf("Should have popped c but was: %t (value %s) %v", ok, value, err)
+	}
+
+	if valueType := store.Type(key1); valueType != kvstore.TypeList {
+		t.Fatalf("Type should have been list but was: %s", valueType)
+	}
+
+	// once empty, the key is absent
+	_, _, _ = store.ListPop(key1, true)
+
+	if valueType := store.Type(key1); valueType != kvstore.TypeNone {
+		t.Fatalf("Type should have been none but was: %s", valueType)
+	}
+
+	if value, ok, err := store.ListPop(key1, true); ok || err != nil {
+		t.Fatalf("Should have been nothing to pop but was: %t (value %s) %v", ok, value, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestListGrows(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	// pushing at both ends, and popping, wraps around the buffer
+	for i := 0; i < 20; i++ {
+		_ = store.ListPush(key1, strconv.Itoa(i), i%2 == 0)
+
+		if i%3 == 0 {
+			_, _, _ = store.ListPop(key1, false)
+		}
+	}
+
+	expected := []string{"18", "16", "14", "12", "10", "8", "6", "4", "2", "1", "7", "13", "19"}
+	if elements, _ := store.ListRange(key1, 0, -1); !reflect.DeepEqual(elements, expected) {
+		t.Fatalf("Elements should have been %v but were: %v", expected, elements)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestListRange(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	for _, value := range []string{"a", "b", "c", "d", "e"} {
+		_ = store.ListPush(key1, value, false)
+	}
+
+	tests := []struct {
+		start    int
+		stop     int
+		expected []string
+	}{
+		{start: 1, stop: 2, expected: []string{"b", "c"}},
+		{start: -2, stop: -1, expected: []string{"d", "e"}},
+		{start: -10, stop: 0, expected: []string{"a"}},
+		{start: 3, stop: 10, expected: []string{"d", "e"}},
+		{start: 3, stop: 1, expected: []string{}},
+		{start: 5, stop: 10, expected: []string{}},
+	}
+
+	for _, test := range tests {
+		if elements, _ := store.ListRange(key1, test.start, test.stop); !reflect.DeepEqual(elements, test.expected) {
+			t.Errorf("Range %d to %d should have been %v but was: %v", test.start, test.stop, test.expected, elements)
+		}
+	}
+
+	if elements, err := store.ListRange(key2, 0, -1); len(elements) != 0 || err != nil {
+		t.Errorf("Should have been no elements but were: %v %v", elements, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestListTrim(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	for _, value := range []string{"a", "b", "c", "d"} {
+		_ = store.ListPush(key1, value, false)
+	}
+
+	_ = store.ListTrim(key1, 1, -2)
+
+	expected := []string{"b", "c"}
+	if elements, _ := store.ListRange(key1, 0, -1); !reflect.DeepEqual(elements, expected) {
+		t.Fatalf("Elements should have been %v but were: %v", expected, elements)
+	}
+
+	// still grows once trimmed
+	_ = store.ListPush(key1, "e", false)
+
+	expected = []string{"b", "c", "e"}
+	if elements, _ := store.ListRange(key1, 0, -1); !reflect.DeepEqual(elements, expected) {
+		t.Fatalf("Elements should have been %v but were: %v", expected, elements)
+	}
+
+	// trimming every element removes the key
+	_ = store.ListTrim(key1, 5, 10)
+
+	if valueType := store.Type(key1); valueType != kvstore.TypeNone {
+		t.Fatalf("Type should have been none but was: %s", valueType)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestListWrongType(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	kvstore.Write(store, key1, value1)
+	_ = store.HashSet(key2, "a", value1)
+
+	for _, key := range []string{key1, key2} {
+		if err := store.ListPush(key, value1, true); !errors.Is(err, kvstore.ErrWrongType) {
+			t.Fatalf("Should have been wrong type but got: %v", err)
+		}
+
+		if _, _, err := store.ListPop(key, true); !errors.Is(err, kvstore.ErrWrongType) {
+			t.Fatalf("Should have been wrong type but got: %v", err)
+		}
+	}
+
+	kvstore.Close(store)
+}
+
+func TestObserveList(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	var contents []string
+
+	kvstore.Observe(store, func(change kvstore.Change) {
+		if change.Deleted {
+			contents = append(contents, "deleted")
+		} else {
+			contents = append(contents, string(change.Type)+" "+change.Contents())
+		}
+	})
+
+	_ = store.ListPush(key1, "a", false)
+	_ = store.ListPush(key1, "b", true)
+	_, _, _ = store.ListPop(key1, false)
+	_ = store.ListTrim(key1, 0, -1) // nothing removed, so unchanged
+	_, _, _ = store.ListPop(key1, false)
+
+	expected := []string{`list ["a"]`, `list ["b","a"]`, `list ["b"]`, "deleted"}
+
+	// read after a store operation, so the observer has been called
+	kvstore.Count(store)
+
+	if !reflect.DeepEqual(contents, expected) {
+		t.Errorf("Expected changes %v but got %v", expected, contents)
+	}
+
+	kvstore.Close(store)
+}
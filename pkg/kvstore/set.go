@@ -0,0 +1,131 @@
+package kvstore
+
+import (
+	"sort"
+	"time"
+)
+
+// SetStore is implemented by stores holding sets: unordered unique values kept under a key, so whether
+// a value is a member can be checked by the store, without reading the whole set. A set is absent once
+// it has no members.
+type SetStore interface {
+	TypedStore
+
+	// SetAdd adds the member to the key's set, creating the set if the key is absent.
+	SetAdd(key string, member string) error
+
+	// SetRemove removes the member from the key's set, if present.
+	SetRemove(key string, member string) error
+
+	// SetIsMember returns whether the member is in the key's set.
+	SetIsMember(key string, member string) (bool, error)
+
+	// SetMembers returns the members of the key's set in order, empty if the key is absent.
+	SetMembers(key string) ([]string, error)
+}
+
+type set map[string]struct{}
+
+func (s set) valueType() Type {
+	return TypeSet
+}
+
+func (s set) size() int {
+	return len(s)
+}
+
+func (s set) contents() any {
+	return s.members()
+}
+
+// members returns the members in order.
+func (s set) members() []string {
+	members := make([]string, 0, len(s))
+
+	for member := range s {
+		members = append(members, member)
+	}
+
+	sort.Strings(members)
+
+	return members
+}
+
+// SetAdd implements SetStore.
+func (s *KVStore) SetAdd(key string, member string) error {
+	var err error
+
+	s.run(func(now time.Time) {
+		var members set
+
+		if members, _, err = collectionOf(s, key, func() set { return make(set) }, now); err != nil {
+			return
+		}
+
+		if _, present := members[member]; !present {
+			members[member] = struct{}{}
+			s.changed(key, members)
+		}
+	})
+
+	return err
+}
+
+// SetRemove implements SetStore.
+func (s *KVStore) SetRemove(key string, member string) error {
+	var err error
+
+	s.run(func(now time.Time) {
+		var (
+			members set
+			found   bool
+		)
+
+		if members, found, err = collectionOf[set](s, key, nil, now); !found {
+			return
+		}
+
+		if _, present := members[member]; present {
+			delete(members, member)
+			s.changed(key, members)
+		}
+	})
+
+	return err
+}
+
+// SetIsMember implements SetStore.
+func (s *KVStore) SetIsMember(key string, member string) (bool, error) {
+	var (
+		present bool
+		err     error
+	)
+
+	s.run(func(now time.Time) {
+		var members set
+
+		if members, _, err = collectionOf[set](s, key, nil, now); err == nil {
+			_, present = members[member]
+		}
+	})
+
+	return present, err
+}
+
+// SetMembers implements SetStore.
+func (s *KVStore) SetMembers(key string) ([]string, error) {
+	var (
+		members []string
+		err     error
+	)
+
+	s.run(func(now time.Time) {
+		var found set
+
+		if found, _, err = collectionOf[set](s, key, nil, now); err == nil {
+			members = found.members()
+		}
+	})
+
+	return members, err
+}
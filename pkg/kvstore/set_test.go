@@ -0,0 +1,101 @@
+package kvstore_test
+
+import (
+	"errors"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	if err := store.SetAdd(key1, "b"); err != nil {
+		t.Fatalf("Should have added but got: %v", err)
+	}
+
+	_ = store.SetAdd(key1, "a")
+	_ = store.SetAdd(key1, "b") // already a member
+
+	if present, err := store.SetIsMember(key1, "a"); !present || err != nil {
+		t.Fatalf("Should have been a member but was: %t %v", present, err)
+	}
+
+	if present, err := store.SetIsMember(key1, "c"); present || err != nil {
+		t.Fatalf("Should not have been a member but was: %t %v", present, err)
+	}
+
+	expected := []string{"a", "b"}
+	if members, err := store.SetMembers(key1); !reflect.DeepEqual(members, expected) || err != nil {
+		t.Fatalf("Members should have been %v but were: %v %v", expected, members, err)
+	}
+
+	if valueType := store.Type(key1); valueType != kvstore.TypeSet {
+		t.Fatalf("Type should have been set but was: %s", valueType)
+	}
+
+	// once empty, the key is absent
+	_ = store.SetRemove(key1, "a")
+	_ = store.SetRemove(key1, "c") // not a member
+	_ = store.SetRemove(key1, "b")
+
+	if valueType := store.Type(key1); valueType != kvstore.TypeNone {
+		t.Fatalf("Type should have been none but was: %s", valueType)
+	}
+
+	if members, err := store.SetMembers(key1); len(members) != 0 || err != nil {
+		t.Fatalf("Should have been no members but were: %v %v", members, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestSetWrongType(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	kvstore.Write(store, key1, value1)
+	_ = store.ListPush(key2, value1, true)
+
+	for _, key := range []string{key1, key2} {
+		if err := store.SetAdd(key, value1); !errors.Is(err, kvstore.ErrWrongType) {
+			t.Fatalf("Should have been wrong type but got: %v", err)
+		}
+
+		if _, err := store.SetIsMember(key, value1); !errors.Is(err, kvstore.ErrWrongType) {
+			t.Fatalf("Should have been wrong type but got: %v", err)
+		}
+	}
+
+	kvstore.Close(store)
+}
+
+func TestObserveSet(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	var contents []string
+
+	kvstore.Observe(store, func(change kvstore.Change) {
+		if change.Deleted {
+			contents = append(contents, "deleted")
+		} else {
+			contents = append(contents, string(change.Type)+" "+change.Contents())
+		}
+	})
+
+	_ = store.SetAdd(key1, "b")
+	_ = store.SetAdd(key1, "a")
+	_ = store.SetAdd(key1, "a") // already a member, so unchanged
+	_ = store.SetRemove(key1, "b")
+	_ = store.SetRemove(key1, "a")
+
+	expected := []string{`set ["b"]`, `set ["a","b"]`, `set ["a"]`, "deleted"}
+
+	// read after a store operation, so the observer has been called
+	kvstore.Count(store)
+
+	if !reflect.DeepEqual(contents, expected) {
+		t.Errorf("Expected changes %v but got %v", expected, contents)
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,258 @@
+package kvstore
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// enough levels for billions of members
+	maxSkipListLevel = 32
+
+	// each level links about a quarter of the members of the level below
+	skipListBranching = 4
+)
+
+// SortedSetStore is implemented by stores holding sorted sets: unique members each with a score, kept
+// under a key in order of score (then member, for equal scores), so ranges of them can be read by rank
+// or by score, such as for leaderboards or values indexed by time. A sorted set is absent once it has
+// no members.
+type SortedSetStore interface {
+	TypedStore
+
+	// SortedSetAdd adds the member with the score to the key's sorted set, or updates its score if
+	// already a member, creating the sorted set if the key is absent.
+	SortedSetAdd(key string, member string, score float64) error
+
+	// SortedSetRemove removes the member from the key's sorted set, if present.
+	SortedSetRemove(key string, member string) error
+
+	// SortedSetRange returns the members of the key's sorted set from the start rank to the stop rank
+	// inclusive, counting back from the last member if negative, as with a list's range.
+	SortedSetRange(key string, start int, stop int) ([]ScoredMember, error)
+
+	// SortedSetRangeByScore returns the members of the key's sorted set with scores from lowest to highest
+	// inclusive.
+	SortedSetRangeByScore(key string, lowest float64, highest float64) ([]ScoredMember, error)
+}
+
+// ScoredMember is a member of a sorted set, with its score.
+type ScoredMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// sortedSet is held in a skip list, so members are added, removed and found by score in logarithmic
+// time, along with a map of each member's score, to find a member already present.
+type sortedSet struct {
+	head   *skipListNode
+	level  int
+	scores map[string]float64
+}
+
+// skipListNode links to the next node at each of its levels, the head linking at every level.
+type skipListNode struct {
+	ScoredMember
+	next []*skipListNode
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{
+		head:   &skipListNode{next: make([]*skipListNode, maxSkipListLevel)},
+		level:  1,
+		scores: make(map[string]float64),
+	}
+}
+
+func (z *sortedSet) valueType() Type {
+	return TypeSortedSet
+}
+
+func (z *sortedSet) size() int {
+	return len(z.scores)
+}
+
+func (z *sortedSet) contents() any {
+	return z.byRank(0, len(z.scores)-1)
+}
+
+// before returns whether the node is ordered before the member with the score.
+func (n *skipListNode) before(member string, score float64) bool {
+	return n.Score < score || (n.Score == score && n.Member < member)
+}
+
+// predecessors returns the last node at each level ordered before the member with the score.
+func (z *sortedSet) predecessors(member string, score float64) []*skipListNode {
+	previous := make([]*skipListNode, maxSkipListLevel)
+	node := z.head
+
+	for level := z.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].before(member, score) {
+			node = node.next[level]
+		}
+
+		previous[level] = node
+	}
+
+	return previous
+}
+
+// add adds the member, or updates its score, returning whether the sorted set changed.
+func (z *sortedSet) add(member string, score float64) bool {
+	if existing, found := z.scores[member]; found {
+		if existing == score {
+			return false
+		}
+
+		z.remove(member)
+	}
+
+	level := 1
+	for level < maxSkipListLevel && rand.Intn(skipListBranching) == 0 { //nolint:gosec // only balances the list
+		level++
+	}
+
+	z.level = max(z.level, level)
+
+	previous := z.predecessors(member, score)
+	node := &skipListNode{ScoredMember: ScoredMember{Member: member, Score: score},
+		next: make([]*skipListNode, level)}
+
+	for i := 0; i < level; i++ {
+		node.next[i] = previous[i].next[i]
+		previous[i].next[i] = node
+	}
+
+	z.scores[member] = score
+
+	return true
+}
+
+// remove removes the member, returning whether it was present.
+func (z *sortedSet) remove(member string) bool {
+	score, found := z.scores[member]
+	if !found {
+		return false
+	}
+
+	previous := z.predecessors(member, score)
+
+	for level := 0; level < z.level; level++ {
+		if next := previous[level].next[level]; next != nil && next.Member == member {
+			previous[level].next[level] = next.next[level]
+		}
+	}
+
+	for z.level > 1 && z.head.next[z.level-1] == nil {
+		z.level--
+	}
+
+	delete(z.scores, member)
+
+	return true
+}
+
+// byRank returns the members from the rank first to last inclusive, none if first is after last.
+func (z *sortedSet) byRank(first int, last int) []ScoredMember {
+	members := make([]ScoredMember, 0, max(last-first+1, 0))
+
+	node := z.head.next[0]
+	for rank := 0; node != nil && rank <= last; rank++ {
+		if rank >= first {
+			members = append(members, node.ScoredMember)
+		}
+
+		node = node.next[0]
+	}
+
+	return members
+}
+
+// byScore returns the members with scores from lowest to highest inclusive.
+func (z *sortedSet) byScore(lowest float64, highest float64) []ScoredMember {
+	members := []ScoredMember{}
+
+	// the first node with a score of at least lowest
+	node := z.predecessors("", lowest)[0].next[0]
+
+	for ; node != nil && node.Score <= highest; node = node.next[0] {
+		members = append(members, node.ScoredMember)
+	}
+
+	return members
+}
+
+// SortedSetAdd implements SortedSetStore.
+func (s *KVStore) SortedSetAdd(key string, member string, score float64) error {
+	var err error
+
+	s.run(func(now time.Time) {
+		var z *sortedSet
+
+		if z, _, err = collectionOf(s, key, newSortedSet, now); err == nil && z.add(member, score) {
+			s.changed(key, z)
+		}
+	})
+
+	return err
+}
+
+// SortedSetRemove implements SortedSetStore.
+func (s *KVStore) SortedSetRemove(key string, member string) error {
+	var err error
+
+	s.run(func(now time.Time) {
+		var (
+			z     *sortedSet
+			found bool
+		)
+
+		if z, found, err = collectionOf[*sortedSet](s, key, nil, now); found && z.remove(member) {
+			s.changed(key, z)
+		}
+	})
+
+	return err
+}
+
+// SortedSetRange implements SortedSetStore.
+func (s *KVStore) SortedSetRange(key string, start int, stop int) ([]ScoredMember, error) {
+	var (
+		members []ScoredMember
+		err     error
+	)
+
+	s.run(func(now time.Time) {
+		var (
+			z     *sortedSet
+			found bool
+		)
+
+		if z, found, err = collectionOf[*sortedSet](s, key, nil, now); found {
+			members = z.byRank(rangeBounds(len(z.scores), start, stop))
+		}
+	})
+
+	return members, err
+}
+
+// SortedSetRangeByScore implements SortedSetStore.
+func (s *KVStore) SortedSetRangeByScore(key string, lowest float64, highest float64) ([]ScoredMember, error) {
+	var (
+		members []ScoredMember
+		err     error
+	)
+
+	s.run(func(now time.Time) {
+		var (
+			z     *sortedSet
+			found bool
+		)
+
+		if z, found, err = collectionOf[*sortedSet](s, key, nil, now); found {
+			members = z.byScore(lowest, highest)
+		}
+	})
+
+	return members, err
+}
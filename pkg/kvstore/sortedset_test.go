@@ -0,0 +1,135 @@
+package kvstore_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func TestSortedSet(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	if err := store.SortedSetAdd(key1, "c", 3); err != nil {
+		t.Fatalf("Should have added but got: %v", err)
+	}
+
+	_ = store.SortedSetAdd(key1, "a", 1)
+	_ = store.SortedSetAdd(key1, "b", 2)
+	_ = store.SortedSetAdd(key1, "d", 2) // same score, so ordered by member
+	_ = store.SortedSetAdd(key1, "a", 5) // update score
+
+	expected := []kvstore.ScoredMember{{Member: "b", Score: 2}, {Member: "d", Score: 2}, {Member: "c", Score: 3},
+		{Member: "a", Score: 5}}
+	if members, err := store.SortedSetRange(key1, 0, -1); !reflect.DeepEqual(members, expected) || err != nil {
+		t.Fatalf("Members should have been %v but were: %v %v", expected, members, err)
+	}
+
+	if valueType := store.Type(key1); valueType != kvstore.TypeSortedSet {
+		t.Fatalf("Type should have been zset but was: %s", valueType)
+	}
+
+	_ = store.SortedSetRemove(key1, "d")
+	_ = store.SortedSetRemove(key1, "z") // not a member
+
+	expected = []kvstore.ScoredMember{{Member: "c", Score: 3}, {Member: "a", Score: 5}}
+	if members, _ := store.SortedSetRange(key1, -2, 10); !reflect.DeepEqual(members, expected) {
+		t.Fatalf("Members should have been %v but were: %v", expected, members)
+	}
+
+	// once empty, the key is absent
+	for _, member := range []string{"a", "b", "c"} {
+		_ = store.SortedSetRemove(key1, member)
+	}
+
+	if valueType := store.Type(key1); valueType != kvstore.TypeNone {
+		t.Fatalf("Type should have been none but was: %s", valueType)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestSortedSetRangeByScore(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	// enough members for several levels of the skip list
+	for i := 0; i < 1000; i++ {
+		_ = store.SortedSetAdd(key1, fmt.Sprintf("m%04d", i), float64(i%100))
+	}
+
+	members, err := store.SortedSetRangeByScore(key1, 10, 11)
+	if len(members) != 20 || err != nil {
+		t.Fatalf("Should have been 20 members but were: %d %v", len(members), err)
+	}
+
+	if members[0] != (kvstore.ScoredMember{Member: "m0010", Score: 10}) ||
+		members[19] != (kvstore.ScoredMember{Member: "m0911", Score: 11}) {
+		t.Fatalf("Should have been from m0010 to m0911 but were: %v", members)
+	}
+
+	if members, _ := store.SortedSetRangeByScore(key1, 99.5, 200); len(members) != 0 {
+		t.Fatalf("Should have been no members but were: %v", members)
+	}
+
+	if members, _ := store.SortedSetRange(key1, 998, 1005); len(members) != 2 || members[1].Member != "m0999" {
+		t.Fatalf("Should have been the last 2 members but were: %v", members)
+	}
+
+	if members, err := store.SortedSetRangeByScore(key2, 0, 10); len(members) != 0 || err != nil {
+		t.Fatalf("Should have been no members but were: %v %v", members, err)
+	}
+
+	kvstore.Close(store)
+}
+
+func TestSortedSetWrongType(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	kvstore.Write(store, key1, value1)
+	_ = store.SetAdd(key2, value1)
+
+	for _, key := range []string{key1, key2} {
+		if err := store.SortedSetAdd(key, value1, 1); !errors.Is(err, kvstore.ErrWrongType) {
+			t.Fatalf("Should have been wrong type but got: %v", err)
+		}
+
+		if _, err := store.SortedSetRangeByScore(key, 0, 1); !errors.Is(err, kvstore.ErrWrongType) {
+			t.Fatalf("Should have been wrong type but got: %v", err)
+		}
+	}
+
+	kvstore.Close(store)
+}
+
+func TestObserveSortedSet(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	var contents []string
+
+	kvstore.Observe(store, func(change kvstore.Change) {
+		if change.Deleted {
+			contents = append(contents, "deleted")
+		} else {
+			contents = append(contents, string(change.Type)+" "+change.Contents())
+		}
+	})
+
+	_ = store.SortedSetAdd(key1, "a", 2)
+	_ = store.SortedSetAdd(key1, "a", 2) // same score, so unchanged
+	_ = store.SortedSetAdd(key1, "b", 1.5)
+	_ = store.SortedSetRemove(key1, "a")
+	_ = store.SortedSetRemove(key1, "b")
+
+	expected := []string{`zset [{"member":"a","score":2}]`,
+		`zset [{"member":"b","score":1.5},{"member":"a","score":2}]`, `zset [{"member":"b","score":1.5}]`, "deleted"}
+
+	// read after a store operation, so the observer has been called
+	kvstore.Count(store)
+
+	if !reflect.DeepEqual(contents, expected) {
+		t.Errorf("Expected changes %v but got %v", expected, contents)
+	}
+
+	kvstore.Close(store)
+}
@@ -0,0 +1,143 @@
+// Package logging provides structured, levelled logging using log/slog, where the level of each
+// subsystem (e.g. server, peer or kvstore) can be set independently of the rest, and changed
+// while loggers are in use.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Formats of log output.
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+var (
+	errInvalidFormat = errors.New("invalid log format, expected text or json")
+	errInvalidLevels = errors.New("invalid log levels, expected subsystem=level")
+)
+
+// Levels holds the minimum level logged by each subsystem, keyed by subsystem name.
+type Levels map[string]slog.Level
+
+// ParseLevels parses a comma-separated list of subsystem=level pairs, e.g. "peer=debug,kvstore=warn".
+func ParseLevels(list string) (Levels, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	levels := make(Levels)
+
+	for _, pair := range strings.Split(list, ",") {
+		subsystem, levelText, found := strings.Cut(pair, "=")
+		if !found || subsystem == "" {
+			return nil, fmt.Errorf("%w: %s", errInvalidLevels, pair)
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(levelText)); err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidLevels, pair)
+		}
+
+		levels[subsystem] = level
+	}
+
+	return levels, nil
+}
+
+// LevelSet holds the minimum level logged, and the levels of subsystems logging at a different level,
+// which can be changed while loggers are in use.
+type LevelSet struct {
+	mutex      sync.RWMutex
+	level      slog.Level
+	subsystems Levels
+}
+
+// NewLevelSet returns the levels logged, by default and by particular subsystems.
+func NewLevelSet(level slog.Level, subsystems Levels) *LevelSet {
+	return &LevelSet{level: level, subsystems: subsystems}
+}
+
+// Set changes the levels logged, by default and by particular subsystems.
+func (l *LevelSet) Set(level slog.Level, subsystems Levels) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.level = level
+	l.subsystems = subsystems
+}
+
+// Level returns the minimum level logged by the subsystem.
+func (l *LevelSet) Level(subsystem string) slog.Level {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if level, found := l.subsystems[subsystem]; found {
+		return level
+	}
+
+	return l.level
+}
+
+// New returns a logger writing records at or above the levels to the writer, in the format.
+func New(writer io.Writer, format string, levels *LevelSet) (*slog.Logger, error) {
+	// the level is checked by levelHandler, so subsystems can log below the default level
+	options := &slog.HandlerOptions{Level: slog.LevelDebug - 4}
+
+	var handler slog.Handler
+
+	switch format {
+	case TextFormat:
+		handler = slog.NewTextHandler(writer, options)
+
+	case JSONFormat:
+		handler = slog.NewJSONHandler(writer, options)
+
+	default:
+		return nil, fmt.Errorf("%w: %s", errInvalidFormat, format)
+	}
+
+	return slog.New(&levelHandler{levels, "", handler}), nil
+}
+
+// Subsystem returns the logger for the subsystem, which adds the subsystem to every record and,
+// if the logger was returned by New, logs at the subsystem's level.
+func Subsystem(logger *slog.Logger, subsystem string) *slog.Logger {
+	handler := logger.Handler()
+
+	if filtered, ok := handler.(*levelHandler); ok {
+		handler = &levelHandler{filtered.levels, subsystem, filtered.handler}
+	}
+
+	return slog.New(handler).With("subsystem", subsystem)
+}
+
+// levelHandler only passes records at or above the subsystem's level to the handler.
+type levelHandler struct {
+	levels    *LevelSet
+	subsystem string
+	handler   slog.Handler
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.levels.Level(h.subsystem)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler.Handle(ctx, record) //nolint:wrapcheck // passed through unchanged
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{h.levels, h.subsystem, h.handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{h.levels, h.subsystem, h.handler.WithGroup(name)}
+}
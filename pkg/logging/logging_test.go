@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels("peer=debug,kvstore=WARN")
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	expected := Levels{"peer": slog.LevelDebug, "kvstore": slog.LevelWarn}
+	if !reflect.DeepEqual(levels, expected) {
+		t.Errorf("Expected %v but got %v", expected, levels)
+	}
+}
+
+func TestParseLevels_Invalid(t *testing.T) {
+	for _, list := range []string{"peer", "=debug", "peer=loud"} {
+		if _, err := ParseLevels(list); !errors.Is(err, errInvalidLevels) {
+			t.Errorf("Wrong error returned for %s: %v", list, err)
+		}
+	}
+}
+
+func TestNew_InvalidFormat(t *testing.T) {
+	if _, err := New(&strings.Builder{}, "xml", NewLevelSet(slog.LevelInfo, nil)); !errors.Is(err, errInvalidFormat) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func TestSubsystem(t *testing.T) {
+	var output strings.Builder
+
+	levels := NewLevelSet(slog.LevelInfo, Levels{"peer": slog.LevelDebug, "kvstore": slog.LevelError})
+
+	logger, err := New(&output, JSONFormat, levels)
+	if err != nil {
+		t.Fatal("Expected successful but got: ", err)
+	}
+
+	Subsystem(logger, "peer").Debug("peer debug") // below the default level
+	Subsystem(logger, "kvstore").Warn("kvstore warning")
+	Subsystem(logger, "server").Debug("server debug")
+	Subsystem(logger, "server").Info("server info")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 records but got: %s", output.String())
+	}
+
+	if !strings.Contains(lines[0], `"msg":"peer debug","subsystem":"peer"`) {
+		t.Error("Wrong record: ", lines[0])
+	}
+
+	if !strings.Contains(lines[1], `"msg":"server info","subsystem":"server"`) {
+		t.Error("Wrong record: ", lines[1])
+	}
+}
+
+func TestLevelSet_Set(t *testing.T) {
+	var output strings.Builder
+
+	levels := NewLevelSet(slog.LevelInfo, nil)
+
+	logger, err := New(&output, TextFormat, levels)
+	if err != nil {
+		t.Fatal("Expected successful but got: ", err)
+	}
+
+	server := Subsystem(logger, "server")
+	server.Debug("not logged")
+
+	// applies to existing loggers
+	levels.Set(slog.LevelWarn, Levels{"server": slog.LevelDebug})
+	server.Debug("server debug")
+	logger.Info("not logged")
+
+	if strings.Contains(output.String(), "not logged") || !strings.Contains(output.String(), "server debug") {
+		t.Error("Wrong records: ", output.String())
+	}
+}
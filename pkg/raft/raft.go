@@ -0,0 +1,476 @@
+// Package raft replicates a log of commands between servers using the Raft consensus algorithm
+// (https://raft.github.io/raft.pdf): the servers elect a leader, which appends the commands proposed
+// to its log and replicates them to the followers, each server applying a command once it is on a
+// majority of servers, so every server applies the same commands in the same order.
+//
+// Once enough entries have been applied, they are discarded from the log, if the state machine can be
+// snapshotted, a follower needing entries the leader has discarded being sent a snapshot of the leader's
+// state machine instead.
+//
+// The log and election state, including the current term and the vote cast in it, are held in memory
+// only, never persisted. A server that restarts rejoins as a follower of term 0 with an empty log, which
+// the leader then replicates, or sends a snapshot of. Having forgotten its vote, a server restarted during
+// an election could vote for two candidates in the same term, so two leaders could be elected.
+package raft
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// State is the role of a node in the cluster.
+type State int
+
+const (
+	// Follower accepts the log entries replicated by the leader, starting an election if it hears
+	// nothing from a leader for an election timeout.
+	Follower State = iota
+
+	// Candidate is standing for election as leader.
+	Candidate State = iota
+
+	// Leader accepts proposed commands, and replicates its log to the followers.
+	Leader State = iota
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+
+	case Candidate:
+		return "candidate"
+
+	default:
+		return "leader"
+	}
+}
+
+var (
+	// ErrNotLeader is returned when a command is proposed to a node that isn't the leader,
+	// see Status for which node is.
+	ErrNotLeader = errors.New("not the leader")
+
+	// ErrTimeout is returned when a proposed command isn't known to be applied in time, it may still be
+	// applied later, or have been applied as part of a snapshot sent by a new leader.
+	ErrTimeout = errors.New("timed out waiting for command to be applied")
+
+	// ErrLost is returned when a proposed command was replaced by a command from a new leader,
+	// so will never be applied.
+	ErrLost = errors.New("command lost by a change of leader")
+
+	// ErrStopped is returned when the node is stopped while waiting for a command to be applied.
+	ErrStopped = errors.New("node stopped")
+)
+
+const (
+	// DefaultElectionTimeout is the least time a follower waits to hear from a leader before standing
+	// for election, each wait being a random time of up to twice this.
+	DefaultElectionTimeout = 300 * time.Millisecond
+
+	// DefaultHeartbeatInterval is how often a leader replicates its log, even if there's nothing new.
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+
+	// DefaultSnapshotThreshold is how many applied entries are kept in the log before being discarded.
+	DefaultSnapshotThreshold = 10000
+)
+
+// most entries sent to a follower at a time
+const maxAppendEntries = 512
+
+// Config holds the settings of a node.
+type Config struct {
+	// identifies this node to the others, as the address they send requests to
+	ID string
+
+	// identifies the other nodes in the cluster, which is fixed
+	Peers []string
+
+	// sends requests to the other nodes
+	Transport Transport
+
+	// called with each command once committed, in log order, returning the result of applying it, which
+	// is returned by Propose on the node it was proposed to, must not call the node
+	Apply func(command string) string
+
+	// return the state machine's state, having applied every command so far, and replace the state with
+	// one returned by another node, neither being called while a command is being applied, and neither
+	// calling the node. Applied entries are only discarded from the log if both are set, which they must
+	// be on every node, or none.
+	Snapshot func() string
+	Restore  func(snapshot string)
+
+	// defaults are used if zero
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	SnapshotThreshold int
+
+	// where the node logs to (slog.Default() if nil)
+	Logger *slog.Logger
+}
+
+// Entry is a command in the log, with the term of the leader that added it.
+type Entry struct {
+	Term    uint64
+	Command string
+}
+
+// Status describes a node's view of the cluster.
+type Status struct {
+	State       State
+	Term        uint64
+	Leader      string
+	LastIndex   uint64
+	CommitIndex uint64
+
+	// the last entry discarded from the log, 0 if none
+	SnapshotIndex uint64
+}
+
+// Node is a member of a Raft cluster.
+type Node struct {
+	config Config
+	logger *slog.Logger
+
+	mutex       sync.Mutex
+	state       State
+	term        uint64
+	votedFor    string
+	votes       int
+	leader      string
+	log         []Entry
+	commitIndex uint64
+	lastApplied uint64
+
+	// the index and term of the last entry discarded from the log, which holds the entries after it
+	snapshotIndex uint64
+	snapshotTerm  uint64
+
+	// of each command proposed to this node that is waiting to be applied, by index
+	proposals map[uint64]proposal
+
+	// of each peer when leader, the next entry to send, the last entry known to be replicated,
+	// and whether entries are being sent
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	sending    map[string]bool
+
+	electionDeadline time.Time
+	lastHeartbeat    time.Time
+
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// proposal is the term a command was proposed in, to check the entry applied at its index is the same
+// command, and where the outcome is sent once applied.
+type proposal struct {
+	term    uint64
+	outcome chan outcome
+}
+
+// outcome is the result of applying a proposed command, or the error if it wasn't applied.
+type outcome struct {
+	result string
+	err    error
+}
+
+// NewNode returns a follower with an empty log, which takes part in the cluster once started.
+func NewNode(config Config) *Node {
+	if config.ElectionTimeout == 0 {
+		config.ElectionTimeout = DefaultElectionTimeout
+	}
+
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+
+	if config.SnapshotThreshold == 0 {
+		config.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	n := &Node{
+		config:     config,
+		logger:     logger.With("node", config.ID),
+		nextIndex:  make(map[string]uint64),
+		matchIndex: make(map[string]uint64),
+		sending:    make(map[string]bool),
+		proposals:  make(map[uint64]proposal),
+		stopped:    make(chan struct{}),
+	}
+
+	n.resetElectionDeadline(time.Now())
+
+	return n
+}
+
+// Start takes part in the cluster, until stopped.
+func (n *Node) Start() {
+	go n.run()
+}
+
+// Stop stops taking part in the cluster.
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.stopped)
+	})
+}
+
+// Status returns the node's view of the cluster.
+func (n *Node) Status() Status {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return Status{n.state, n.term, n.leader, n.lastIndex(), n.commitIndex, n.snapshotIndex}
+}
+
+// Propose appends the command to the log if this node is the leader, then waits until it has been
+// applied, returning the result of applying it, or ErrNotLeader if this node isn't the leader, or
+// ErrTimeout if it isn't applied within the timeout.
+func (n *Node) Propose(command string, timeout time.Duration) (string, error) {
+	n.mutex.Lock()
+
+	if n.state != Leader {
+		n.mutex.Unlock()
+		return "", ErrNotLeader
+	}
+
+	n.log = append(n.log, Entry{n.term, command})
+	index, result := n.lastIndex(), make(chan outcome, 1)
+
+	// a command proposed earlier at the same index was replaced by a new leader's
+	if replaced, found := n.proposals[index]; found {
+		replaced.outcome <- outcome{err: ErrLost}
+	}
+
+	n.proposals[index] = proposal{n.term, result}
+
+	// applied immediately if there are no followers
+	n.advanceCommitIndex()
+	n.mutex.Unlock()
+
+	n.broadcast()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case applied := <-result:
+		return applied.result, applied.err
+
+	case <-timer.C:
+		n.abandon(index, result)
+		return "", ErrTimeout
+
+	case <-n.stopped:
+		n.abandon(index, result)
+		return "", ErrStopped
+	}
+}
+
+// abandon stops waiting for the command proposed at the index to be applied.
+func (n *Node) abandon(index uint64, result chan outcome) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if waiting, found := n.proposals[index]; found && waiting.outcome == result {
+		delete(n.proposals, index)
+	}
+}
+
+// run starts elections when followers don't hear from a leader, and sends heartbeats when leader,
+// until stopped.
+func (n *Node) run() {
+	ticker := time.NewTicker(n.config.HeartbeatInterval / 5)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			n.tick(now)
+
+		case <-n.stopped:
+			return
+		}
+	}
+}
+
+func (n *Node) tick(now time.Time) {
+	n.mutex.Lock()
+	heartbeat := n.state == Leader && now.Sub(n.lastHeartbeat) >= n.config.HeartbeatInterval
+	election := n.state != Leader && now.After(n.electionDeadline)
+
+	if heartbeat {
+		n.lastHeartbeat = now
+	}
+	n.mutex.Unlock()
+
+	switch {
+	case heartbeat:
+		n.broadcast()
+
+	case election:
+		n.startElection(now)
+	}
+}
+
+// becomeFollower follows the leader (if known) of the term, must be called with the mutex locked.
+func (n *Node) becomeFollower(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+	}
+
+	if n.state != Follower {
+		n.logger.Info("became follower", "term", n.term, "leader", leader)
+		n.resetElectionDeadline(time.Now())
+	}
+
+	n.state = Follower
+	n.leader = leader
+}
+
+// becomeLeader starts leading the current term, must be called with the mutex locked.
+func (n *Node) becomeLeader() {
+	n.logger.Info("became leader", "term", n.term, "votes", n.votes)
+
+	n.state = Leader
+	n.leader = n.config.ID
+
+	for _, peer := range n.config.Peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+
+	// an entry of its own term, so entries from earlier terms can be committed
+	n.log = append(n.log, Entry{n.term, ""})
+	n.advanceCommitIndex()
+
+	n.lastHeartbeat = time.Time{}
+}
+
+// resetElectionDeadline waits a random time of between 1 and 2 election timeouts before standing
+// for election, so nodes rarely stand at the same time, must be called with the mutex locked.
+func (n *Node) resetElectionDeadline(now time.Time) {
+	timeout := n.config.ElectionTimeout
+	jitter := rand.Int63n(int64(timeout)) //nolint:gosec // jitter doesn't need a secure random number
+
+	n.electionDeadline = now.Add(timeout + time.Duration(jitter))
+}
+
+// majority returns the number of nodes, including this one, that make a majority of the cluster.
+func (n *Node) majority() int {
+	return (len(n.config.Peers)+1)/2 + 1
+}
+
+// advanceCommitIndex commits the latest entry of the current term on a majority of nodes, and every
+// entry before it, then applies them, must be called with the mutex locked.
+func (n *Node) advanceCommitIndex() {
+	for index := n.lastIndex(); index > n.commitIndex && n.termAt(index) == n.term; index-- {
+		replicas := 1
+
+		for _, match := range n.matchIndex {
+			if match >= index {
+				replicas++
+			}
+		}
+
+		if replicas >= n.majority() {
+			n.commitIndex = index
+			n.applyCommitted()
+
+			return
+		}
+	}
+}
+
+// applyCommitted applies the committed entries not yet applied, then discards them from the log if there
+// are enough, must be called with the mutex locked.
+func (n *Node) applyCommitted() {
+	for n.lastApplied < n.commitIndex {
+		n.lastApplied++
+		entry := n.entry(n.lastApplied)
+
+		// empty commands are only added by new leaders
+		var result string
+		if entry.Command != "" {
+			result = n.config.Apply(entry.Command)
+		}
+
+		if waiting, found := n.proposals[n.lastApplied]; found {
+			delete(n.proposals, n.lastApplied)
+
+			if entry.Term == waiting.term {
+				waiting.outcome <- outcome{result: result}
+			} else {
+				waiting.outcome <- outcome{err: ErrLost}
+			}
+		}
+	}
+
+	n.compact()
+}
+
+// compact discards the applied entries from the log once there are at least the snapshot threshold of
+// them, when leader keeping those a follower not that far behind has yet to be sent, since a follower
+// needing an entry discarded is sent a snapshot instead. Must be called with the mutex locked.
+func (n *Node) compact() {
+	threshold := uint64(n.config.SnapshotThreshold)
+
+	if n.config.Snapshot == nil || n.config.Restore == nil || n.lastApplied-n.snapshotIndex < threshold {
+		return
+	}
+
+	index := n.lastApplied
+
+	if n.state == Leader {
+		for _, match := range n.matchIndex {
+			if match < index && n.lastApplied-match < threshold {
+				index = match
+			}
+		}
+	}
+
+	if index <= n.snapshotIndex {
+		return
+	}
+
+	n.snapshotTerm = n.termAt(index)
+	n.log = append([]Entry(nil), n.log[index-n.snapshotIndex:]...)
+	n.snapshotIndex = index
+
+	n.logger.Debug("compacted log", "index", index)
+}
+
+// lastIndex returns the index of the last entry in the log (1 based, 0 if empty), must be called
+// with the mutex locked.
+func (n *Node) lastIndex() uint64 {
+	return n.snapshotIndex + uint64(len(n.log))
+}
+
+// entry returns the entry at the index, which must be in the log, must be called with the mutex locked.
+func (n *Node) entry(index uint64) Entry {
+	return n.log[index-n.snapshotIndex-1]
+}
+
+// termAt returns the term of the entry at the index, 0 if none or discarded before the last entry
+// discarded, must be called with the mutex locked.
+func (n *Node) termAt(index uint64) uint64 {
+	if index == n.snapshotIndex {
+		return n.snapshotTerm
+	}
+
+	if index < n.snapshotIndex || index > n.lastIndex() {
+		return 0
+	}
+
+	return n.entry(index).Term
+}
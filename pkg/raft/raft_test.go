@@ -0,0 +1,347 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	errPartitioned = errors.New("node partitioned")
+)
+
+const testTimeout = 5 * time.Second
+
+func Test_Node_SingleNode(t *testing.T) {
+	cluster := newTestCluster(1, 0)
+	defer cluster.stop()
+
+	leader := cluster.waitForLeader(t)
+
+	if result, err := leader.Propose("a", time.Second); result != "A" || err != nil {
+		t.Fatalf("Expected command applied with result A but got %q, %v", result, err)
+	}
+
+	cluster.waitForApplied(t, "n0", "a")
+}
+
+func Test_Node_Replicates(t *testing.T) {
+	cluster := newTestCluster(3, 0)
+	defer cluster.stop()
+
+	leader := cluster.waitForLeader(t)
+
+	for _, command := range []string{"a", "b", "c"} {
+		if _, err := leader.Propose(command, time.Second); err != nil {
+			t.Fatal("Expected command applied but got: ", err)
+		}
+	}
+
+	for id := range cluster.nodes {
+		cluster.waitForApplied(t, id, "a", "b", "c")
+	}
+}
+
+func Test_Node_ProposeToFollower(t *testing.T) {
+	cluster := newTestCluster(3, 0)
+	defer cluster.stop()
+
+	leader := cluster.waitForLeader(t)
+
+	// so every follower has heard from the leader
+	if _, err := leader.Propose("a", time.Second); err != nil {
+		t.Fatal("Expected command applied but got: ", err)
+	}
+
+	for id := range cluster.nodes {
+		cluster.waitForApplied(t, id, "a")
+	}
+
+	for _, node := range cluster.nodes {
+		if node == leader {
+			continue
+		}
+
+		if _, err := node.Propose("b", time.Second); !errors.Is(err, ErrNotLeader) {
+			t.Error("Expected not leader error but got: ", err)
+		}
+
+		if status := node.Status(); status.Leader != leader.config.ID {
+			t.Errorf("Expected leader %s but got %s", leader.config.ID, status.Leader)
+		}
+	}
+}
+
+func Test_Node_Failover(t *testing.T) {
+	cluster := newTestCluster(3, 0)
+	defer cluster.stop()
+
+	leader := cluster.waitForLeader(t)
+
+	if _, err := leader.Propose("a", time.Second); err != nil {
+		t.Fatal("Expected command applied but got: ", err)
+	}
+
+	oldTerm := leader.Status().Term
+	cluster.partition(leader.config.ID, true)
+
+	newLeader := cluster.waitForLeader(t)
+
+	if newLeader.Status().Term <= oldTerm {
+		t.Error("Expected a later term")
+	}
+
+	if _, err := newLeader.Propose("b", time.Second); err != nil {
+		t.Fatal("Expected command applied but got: ", err)
+	}
+
+	// the old leader catches up once it can be reached again
+	cluster.partition(leader.config.ID, false)
+
+	for id := range cluster.nodes {
+		cluster.waitForApplied(t, id, "a", "b")
+	}
+}
+
+func Test_Node_Snapshot(t *testing.T) {
+	cluster := newTestCluster(3, 4)
+	defer cluster.stop()
+
+	leader := cluster.waitForLeader(t)
+
+	var behind string
+
+	for id, node := range cluster.nodes {
+		if node != leader {
+			behind = id
+			break
+		}
+	}
+
+	cluster.partition(behind, true)
+
+	commands := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+
+	for _, command := range commands {
+		if _, err := leader.Propose(command, time.Second); err != nil {
+			t.Fatal("Expected command applied but got: ", err)
+		}
+	}
+
+	if status := leader.Status(); status.SnapshotIndex == 0 || status.LastIndex != 11 {
+		t.Errorf("Expected applied entries discarded from 11 entries but got %+v", status)
+	}
+
+	// the follower is sent a snapshot, the entries it needs having been discarded
+	cluster.partition(behind, false)
+	cluster.waitForApplied(t, behind, commands...)
+
+	cluster.mutex.Lock()
+	restored := cluster.restored[behind]
+	cluster.mutex.Unlock()
+
+	if restored != 1 {
+		t.Errorf("Expected a snapshot installed once but got %d", restored)
+	}
+
+	// then entries are replicated as before
+	if _, err := leader.Propose("k", time.Second); err != nil {
+		t.Fatal("Expected command applied but got: ", err)
+	}
+
+	for id := range cluster.nodes {
+		cluster.waitForApplied(t, id, append(commands, "k")...)
+	}
+}
+
+// testCluster is a cluster of nodes, connected by in-memory transports that can be partitioned.
+type testCluster struct {
+	mutex       sync.Mutex
+	nodes       map[string]*Node
+	partitioned map[string]bool
+	applied     map[string][]string
+	restored    map[string]int
+}
+
+// newTestCluster returns a cluster of nodes discarding applied entries once there are the threshold of
+// them (the default if zero), their state being the commands applied.
+func newTestCluster(size int, threshold int) *testCluster {
+	cluster := &testCluster{
+		nodes:       make(map[string]*Node),
+		partitioned: make(map[string]bool),
+		applied:     make(map[string][]string),
+		restored:    make(map[string]int),
+	}
+
+	for i := 0; i < size; i++ {
+		id := fmt.Sprintf("n%d", i)
+
+		var peers []string
+
+		for j := 0; j < size; j++ {
+			if j != i {
+				peers = append(peers, fmt.Sprintf("n%d", j))
+			}
+		}
+
+		cluster.nodes[id] = NewNode(Config{
+			ID:        id,
+			Peers:     peers,
+			Transport: &testTransport{cluster, id},
+			Apply: func(command string) string {
+				cluster.mutex.Lock()
+				defer cluster.mutex.Unlock()
+
+				cluster.applied[id] = append(cluster.applied[id], command)
+
+				return strings.ToUpper(command)
+			},
+			Snapshot: func() string {
+				cluster.mutex.Lock()
+				defer cluster.mutex.Unlock()
+
+				return strings.Join(cluster.applied[id], ",")
+			},
+			Restore: func(snapshot string) {
+				cluster.mutex.Lock()
+				defer cluster.mutex.Unlock()
+
+				cluster.applied[id] = strings.Split(snapshot, ",")
+				cluster.restored[id]++
+			},
+			ElectionTimeout:   50 * time.Millisecond,
+			HeartbeatInterval: 10 * time.Millisecond,
+			SnapshotThreshold: threshold,
+			Logger:            testLogger,
+		})
+	}
+
+	for _, node := range cluster.nodes {
+		node.Start()
+	}
+
+	return cluster
+}
+
+func (c *testCluster) stop() {
+	for _, node := range c.nodes {
+		node.Stop()
+	}
+}
+
+func (c *testCluster) partition(id string, partitioned bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.partitioned[id] = partitioned
+}
+
+func (c *testCluster) isPartitioned(id string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.partitioned[id]
+}
+
+// waitForLeader returns the leader of the latest term among the nodes that aren't partitioned.
+func (c *testCluster) waitForLeader(t *testing.T) *Node {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+
+	for time.Now().Before(deadline) {
+		var leader *Node
+
+		for id, node := range c.nodes {
+			// not holding the mutex, since nodes hold theirs while applying commands
+			if status := node.Status(); !c.isPartitioned(id) && status.State == Leader &&
+				(leader == nil || status.Term > leader.Status().Term) {
+				leader = node
+			}
+		}
+
+		if leader != nil {
+			return leader
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatal("No leader elected")
+
+	return nil
+}
+
+// waitForApplied waits for the node to have applied exactly the commands.
+func (c *testCluster) waitForApplied(t *testing.T, id string, commands ...string) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+
+	for {
+		c.mutex.Lock()
+		applied := fmt.Sprint(c.applied[id])
+		c.mutex.Unlock()
+
+		if applied == fmt.Sprint(commands) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s to apply %v but got %s", id, commands, applied)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// testTransport sends requests from a node directly to the other nodes, unless either is partitioned.
+type testTransport struct {
+	cluster *testCluster
+	from    string
+}
+
+func (t *testTransport) node(peer string) (*Node, error) {
+	t.cluster.mutex.Lock()
+	defer t.cluster.mutex.Unlock()
+
+	if t.cluster.partitioned[t.from] || t.cluster.partitioned[peer] {
+		return nil, errPartitioned
+	}
+
+	return t.cluster.nodes[peer], nil
+}
+
+func (t *testTransport) RequestVote(peer string, request VoteRequest) (VoteResponse, error) {
+	node, err := t.node(peer)
+	if err != nil {
+		return VoteResponse{}, err
+	}
+
+	return node.HandleRequestVote(request), nil
+}
+
+func (t *testTransport) AppendEntries(peer string, request AppendRequest) (AppendResponse, error) {
+	node, err := t.node(peer)
+	if err != nil {
+		return AppendResponse{}, err
+	}
+
+	return node.HandleAppendEntries(request), nil
+}
+
+func (t *testTransport) InstallSnapshot(peer string, request SnapshotRequest) (SnapshotResponse, error) {
+	node, err := t.node(peer)
+	if err != nil {
+		return SnapshotResponse{}, err
+	}
+
+	return node.HandleInstallSnapshot(request), nil
+}
@@ -0,0 +1,311 @@
+package raft
+
+import "time"
+
+// Transport sends requests to the other nodes, which pass them to HandleRequestVote, HandleAppendEntries
+// and HandleInstallSnapshot, returning an error if a node couldn't be reached.
+type Transport interface {
+	RequestVote(peer string, request VoteRequest) (VoteResponse, error)
+	AppendEntries(peer string, request AppendRequest) (AppendResponse, error)
+	InstallSnapshot(peer string, request SnapshotRequest) (SnapshotResponse, error)
+}
+
+// VoteRequest asks for a node's vote, from a candidate with the last entry in its log.
+type VoteRequest struct {
+	Term         uint64
+	Candidate    string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// VoteResponse is whether the vote was granted, and the term of the node voting.
+type VoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+// AppendRequest replicates entries following the entry at PrevLogIndex (none for a heartbeat),
+// from the leader.
+type AppendRequest struct {
+	Term         uint64
+	Leader       string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+// AppendResponse is whether the entries were appended, the term of the follower, and the index
+// of its last entry, so a leader can find where the follower's log diverges.
+type AppendResponse struct {
+	Term         uint64
+	Success      bool
+	LastLogIndex uint64
+}
+
+// SnapshotRequest replaces the log up to the entry at LastIncludedIndex with the snapshot of the leader's
+// state machine having applied it, from the leader, when the follower needs entries it has discarded.
+type SnapshotRequest struct {
+	Term              uint64
+	Leader            string
+	LastIncludedIndex uint64
+	LastIncludedTerm  uint64
+	Snapshot          string
+}
+
+// SnapshotResponse is the term of the follower.
+type SnapshotResponse struct {
+	Term uint64
+}
+
+// HandleRequestVote votes for the candidate if this node hasn't already voted for another in the
+// term, and the candidate's log is at least as up to date as this node's.
+func (n *Node) HandleRequestVote(request VoteRequest) VoteResponse {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if request.Term > n.term {
+		n.becomeFollower(request.Term, "")
+	}
+
+	upToDate := request.LastLogTerm > n.termAt(n.lastIndex()) ||
+		(request.LastLogTerm == n.termAt(n.lastIndex()) && request.LastLogIndex >= n.lastIndex())
+
+	granted := request.Term == n.term && (n.votedFor == "" || n.votedFor == request.Candidate) && upToDate
+
+	if granted {
+		n.votedFor = request.Candidate
+		n.resetElectionDeadline(time.Now())
+	}
+
+	return VoteResponse{n.term, granted}
+}
+
+// HandleAppendEntries appends the leader's entries to the log, replacing any that conflict, if the log
+// contains the entry before them, then applies those the leader has committed.
+func (n *Node) HandleAppendEntries(request AppendRequest) AppendResponse {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if request.Term < n.term {
+		return AppendResponse{n.term, false, n.lastIndex()}
+	}
+
+	if request.Term > n.term || n.state != Follower || n.leader != request.Leader {
+		n.becomeFollower(request.Term, request.Leader)
+	}
+
+	n.resetElectionDeadline(time.Now())
+
+	// entries discarded from the log were applied, so match the leader's
+	if request.PrevLogIndex > n.lastIndex() ||
+		(request.PrevLogIndex >= n.snapshotIndex && n.termAt(request.PrevLogIndex) != request.PrevLogTerm) {
+		return AppendResponse{n.term, false, min(n.lastIndex(), request.PrevLogIndex-1)}
+	}
+
+	for i, entry := range request.Entries {
+		index := request.PrevLogIndex + uint64(i) + 1
+
+		if index <= n.snapshotIndex {
+			continue
+		}
+
+		if index <= n.lastIndex() {
+			if n.termAt(index) == entry.Term {
+				continue
+			}
+
+			// conflicts with the leader's log, so remove it and every entry after it
+			n.log = n.log[:index-n.snapshotIndex-1]
+		}
+
+		n.log = append(n.log, request.Entries[i:]...)
+
+		break
+	}
+
+	// only entries known to match the leader's log
+	commitIndex := min(request.LeaderCommit, request.PrevLogIndex+uint64(len(request.Entries)))
+
+	if commitIndex > n.commitIndex {
+		n.commitIndex = commitIndex
+		n.applyCommitted()
+	}
+
+	return AppendResponse{n.term, true, n.lastIndex()}
+}
+
+// HandleInstallSnapshot replaces the state machine with the leader's snapshot, unless the entries it
+// covers have already been applied, keeping the entries after it if the log contains its last entry.
+func (n *Node) HandleInstallSnapshot(request SnapshotRequest) SnapshotResponse {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if request.Term < n.term {
+		return SnapshotResponse{n.term}
+	}
+
+	if request.Term > n.term || n.state != Follower || n.leader != request.Leader {
+		n.becomeFollower(request.Term, request.Leader)
+	}
+
+	n.resetElectionDeadline(time.Now())
+
+	index := request.LastIncludedIndex
+	if index <= n.commitIndex {
+		return SnapshotResponse{n.term}
+	}
+
+	if index < n.lastIndex() && n.termAt(index) == request.LastIncludedTerm {
+		n.log = append([]Entry(nil), n.log[index-n.snapshotIndex:]...)
+	} else {
+		n.log = nil
+	}
+
+	n.snapshotIndex, n.snapshotTerm = index, request.LastIncludedTerm
+	n.commitIndex, n.lastApplied = index, index
+	n.config.Restore(request.Snapshot)
+
+	n.logger.Info("installed snapshot", "index", index, "term", request.LastIncludedTerm)
+
+	return SnapshotResponse{n.term}
+}
+
+// startElection stands for election as leader of the next term.
+func (n *Node) startElection(now time.Time) {
+	n.mutex.Lock()
+
+	n.state = Candidate
+	n.term++
+	n.votedFor = n.config.ID
+	n.votes = 1
+	n.leader = ""
+	n.resetElectionDeadline(now)
+
+	n.logger.Info("standing for election", "term", n.term)
+
+	request := VoteRequest{n.term, n.config.ID, n.lastIndex(), n.termAt(n.lastIndex())}
+
+	if n.votes >= n.majority() {
+		// no other nodes
+		n.becomeLeader()
+	}
+	n.mutex.Unlock()
+
+	for _, peer := range n.config.Peers {
+		go n.requestVote(peer, request)
+	}
+}
+
+// requestVote asks the peer for its vote, becoming leader once a majority have voted for this node.
+func (n *Node) requestVote(peer string, request VoteRequest) {
+	response, err := n.config.Transport.RequestVote(peer, request)
+	if err != nil {
+		n.logger.Debug("unable to request vote", "peer", peer, "error", err)
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	switch {
+	case response.Term > n.term:
+		n.becomeFollower(response.Term, "")
+
+	case n.state == Candidate && n.term == request.Term && response.Granted:
+		n.votes++
+
+		if n.votes >= n.majority() {
+			n.becomeLeader()
+		}
+	}
+}
+
+// broadcast replicates the log to every peer, when leader.
+func (n *Node) broadcast() {
+	for _, peer := range n.config.Peers {
+		go n.replicate(peer)
+	}
+}
+
+// replicate sends the peer the entries it doesn't yet have, unless already sending it entries.
+func (n *Node) replicate(peer string) {
+	n.mutex.Lock()
+
+	if n.state != Leader || n.sending[peer] {
+		n.mutex.Unlock()
+		return
+	}
+
+	n.sending[peer] = true
+
+	next := min(n.nextIndex[peer], n.lastIndex()+1)
+
+	if next <= n.snapshotIndex {
+		request := SnapshotRequest{n.term, n.config.ID, n.lastApplied, n.termAt(n.lastApplied),
+			n.config.Snapshot()}
+		n.mutex.Unlock()
+
+		n.sendSnapshot(peer, request)
+
+		return
+	}
+
+	end := min(n.lastIndex(), next-1+maxAppendEntries)
+	request := AppendRequest{n.term, n.config.ID, next - 1, n.termAt(next - 1),
+		append([]Entry(nil), n.log[next-n.snapshotIndex-1:end-n.snapshotIndex]...), n.commitIndex}
+	n.mutex.Unlock()
+
+	response, err := n.config.Transport.AppendEntries(peer, request)
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.sending[peer] = false
+
+	switch {
+	case err != nil:
+		n.logger.Debug("unable to append entries", "peer", peer, "error", err)
+
+	case response.Term > n.term:
+		n.becomeFollower(response.Term, "")
+
+	case n.state != Leader || n.term != request.Term:
+		// no longer leading the term the entries were sent in
+
+	case response.Success:
+		n.matchIndex[peer] = max(n.matchIndex[peer], request.PrevLogIndex+uint64(len(request.Entries)))
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.advanceCommitIndex()
+
+	default:
+		// retried from before where the logs diverge on the next heartbeat
+		n.nextIndex[peer] = max(1, min(request.PrevLogIndex, response.LastLogIndex+1))
+	}
+}
+
+// sendSnapshot sends the peer a snapshot, when it needs entries discarded from the log.
+func (n *Node) sendSnapshot(peer string, request SnapshotRequest) {
+	response, err := n.config.Transport.InstallSnapshot(peer, request)
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.sending[peer] = false
+
+	switch {
+	case err != nil:
+		n.logger.Debug("unable to install snapshot", "peer", peer, "error", err)
+
+	case response.Term > n.term:
+		n.becomeFollower(response.Term, "")
+
+	case n.state != Leader || n.term != request.Term:
+		// no longer leading the term the snapshot was sent in
+
+	default:
+		n.matchIndex[peer] = max(n.matchIndex[peer], request.LastIncludedIndex)
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.advanceCommitIndex()
+	}
+}
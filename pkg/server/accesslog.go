@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// AccessLog records every client command, as one JSON object per line, so operators can trace
+// traffic and find slow keys. A nil AccessLog records nothing.
+type AccessLog struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	Command    string    `json:"command"`
+	Key        string    `json:"key,omitempty"`
+	Result     string    `json:"result"`
+	Reason     string    `json:"reason,omitempty"`
+	DurationNs int64     `json:"durationNs"`
+}
+
+// NewAccessLog returns an access log writing to the writer.
+func NewAccessLog(writer io.Writer) *AccessLog {
+	return &AccessLog{encoder: json.NewEncoder(writer)}
+}
+
+// record writes the command, the kind of response it was sent (e.g. ack or err), the reason if it
+// failed, and how long it took from being parsed until its response was written.
+func (a *AccessLog) record(client string, request *commandRequest, response string, reason string,
+	duration time.Duration) error {
+	if a == nil {
+		return nil
+	}
+
+	result := response
+	if len(result) > 3 {
+		result = result[:3]
+	}
+
+	entry := accessLogEntry{
+		Time:       time.Now(),
+		Client:     client,
+		Command:    request.name(),
+		Key:        request.key,
+		Result:     result,
+		DurationNs: duration.Nanoseconds(),
+	}
+
+	if result == errorResponse {
+		entry.Reason = reason
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err := a.encoder.Encode(entry); err != nil {
+		return fmt.Errorf("error writing access log: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	errMissingToken   = errors.New("user has no token")
+	errDuplicateToken = errors.New("token is used by more than one user")
+	errUnknownCommand = errors.New("unknown command")
+)
+
+// User is a client identity, authenticated by its token, with optional restrictions on
+// the commands it may run and the keys it may access.
+type User struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+
+	// Commands lists the commands the user may run (e.g. "get" for a read-only user),
+	// empty means all commands are allowed.
+	Commands []string `json:"commands"`
+
+	// KeyPrefixes lists the key prefixes the user may access, empty means all keys. Commands without a key,
+	// such as hot or cls, can reveal or change keys with any prefix, so are denied unless listed in Commands.
+	KeyPrefixes []string `json:"keyPrefixes"`
+}
+
+// ACL holds the users allowed to connect to a server.
+type ACL struct {
+	users []User
+}
+
+// NewACL returns an access control list containing the specified users.
+func NewACL(users []User) *ACL {
+	return &ACL{users}
+}
+
+// NewSharedSecretACL returns an access control list with a single unrestricted user,
+// authenticated with the shared secret.
+func NewSharedSecretACL(secret string) *ACL {
+	return NewACL([]User{{Name: "default", Token: secret}})
+}
+
+// LoadACL reads an access control list from a JSON file containing an array of users.
+// Users without a token, with a token shared by another user, or listing unknown commands are rejected.
+func LoadACL(filename string) (*ACL, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ACL file: %w", err)
+	}
+
+	var users []User
+
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("error parsing ACL file: %w", err)
+	}
+
+	if err := validateUsers(users); err != nil {
+		return nil, fmt.Errorf("invalid ACL file: %w", err)
+	}
+
+	return NewACL(users), nil
+}
+
+func validateUsers(users []User) error {
+	tokens := make(map[string]bool, len(users))
+
+	for _, user := range users {
+		if user.Token == "" {
+			return fmt.Errorf("%w: %s", errMissingToken, user.Name)
+		}
+
+		if tokens[user.Token] {
+			return fmt.Errorf("%w: %s", errDuplicateToken, user.Name)
+		}
+
+		tokens[user.Token] = true
+
+		for _, name := range user.Commands {
+			if !isCommandName(name) {
+				return fmt.Errorf("%w %q for user %s", errUnknownCommand, name, user.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func isCommandName(name string) bool {
+	return commands.isName(name)
+}
+
+// authenticate returns the user with the specified token, or nil if there isn't one.
+func (a *ACL) authenticate(token string) *User {
+	for i := range a.users {
+		user := &a.users[i]
+
+		if user.Token != "" && subtle.ConstantTimeCompare([]byte(user.Token), []byte(token)) == 1 {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// permits returns whether the user is allowed to run the specified command.
+func (u *User) permits(request *commandRequest) bool {
+	switch request.command {
+	case closeCommand, authCommand, optionCommand, idempotencyCommand, durabilityCommand, batchCommand,
+		compressedCommand:
+		// always allowed, the commands in a batch or compressed being checked one by one
+		return true
+	}
+
+	if request.command == subscribeCommand || request.command == unsubscribeCommand {
+		return u.permitsCommand(request.name()) && u.permitsChannel(request.value)
+	}
+
+	if !hasKey(request) {
+		return u.permitsCommand(request.name()) && (len(u.KeyPrefixes) == 0 || u.listsCommand(request.name()))
+	}
+
+	return u.permitsCommand(request.name()) && u.permitsKey(request.key)
+}
+
+// hasKey returns whether the command accesses a key.
+func hasKey(request *commandRequest) bool {
+	switch request.command {
+	case putCommand, getCommand, deleteCommand, checksumPutCommand, checksumGetCommand, putExpiryCommand,
+		putOptionsCommand, persistCommand, hashSetCommand, hashGetCommand, hashDeleteCommand, hashGetAllCommand,
+		listPushLeftCommand, listPushRightCommand, listPopLeftCommand, listPopRightCommand, listRangeCommand,
+		listTrimCommand, memberAddCommand, memberRemoveCommand, isMemberCommand, membersCommand, scoreAddCommand,
+		scoreRemoveCommand, rankRangeCommand, scoreRangeCommand, setBitCommand, getBitCommand, bitCountCommand,
+		typeCommand,
+		watchCommand, unwatchCommand:
+		return true
+
+	case customCommand:
+		return request.key != ""
+
+	default:
+		return isCRDTCommand(request)
+	}
+}
+
+func (u *User) permitsCommand(name string) bool {
+	return len(u.Commands) == 0 || u.listsCommand(name)
+}
+
+// listsCommand returns whether the user's commands explicitly include the command.
+func (u *User) listsCommand(name string) bool {
+	for _, allowed := range u.Commands {
+		if allowed == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// permitsChannel returns whether the user may subscribe to the channel: a key's keyspace channel if it may
+// access the key, and the keyevent channels, naming any key changed, only if it may access every key.
+func (u *User) permitsChannel(channel string) bool {
+	if key, found := isKeyspaceChannel(channel); found {
+		return u.permitsKey(key)
+	}
+
+	return !isKeyeventChannel(channel) || len(u.KeyPrefixes) == 0
+}
+
+func (u *User) permitsKey(key string) bool {
+	if len(u.KeyPrefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range u.KeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
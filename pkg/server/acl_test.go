@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LoadACL_Valid(t *testing.T) {
+	acl, err := LoadACL(writeACLFile(t, `[
+		{"name": "admin", "token": "a"},
+		{"name": "reader", "token": "r", "commands": ["get"], "keyPrefixes": ["team1/"]}
+	]`))
+	if err != nil {
+		t.Fatal("Expected successful but got: ", err)
+	}
+
+	reader := acl.authenticate("r")
+	if reader == nil || reader.Name != "reader" {
+		t.Fatalf("Expected reader but got %v", reader)
+	}
+
+	if reader.permits(&commandRequest{command: putCommand, key: "team1/a", value: "1"}) {
+		t.Error("Expected put to be denied")
+	}
+
+	if !reader.permits(&commandRequest{command: getCommand, key: "team1/a"}) {
+		t.Error("Expected get to be permitted")
+	}
+
+	if acl.authenticate("x") != nil {
+		t.Error("Expected unknown token to be rejected")
+	}
+}
+
+func Test_User_permits(t *testing.T) {
+	restricted := &User{KeyPrefixes: []string{"team1/"}}
+	listed := &User{Commands: []string{"get", "scn", "inf"}, KeyPrefixes: []string{"team1/"}}
+	unrestricted := &User{}
+
+	tests := []struct {
+		user     *User
+		request  *commandRequest
+		expected bool
+	}{
+		{user: restricted, request: &commandRequest{command: getCommand, key: "team1/a"}, expected: true},
+		{user: restricted, request: &commandRequest{command: getCommand, key: "team2/a"}, expected: false},
+		{user: restricted, request: &commandRequest{command: hotKeysCommand, length: 5}, expected: false},
+		{user: restricted, request: &commandRequest{command: clientListCommand}, expected: false},
+		{user: restricted, request: &commandRequest{command: clientKillCommand, value: "1"}, expected: false},
+		{user: restricted, request: &commandRequest{command: readOnlyCommand, value: "on"}, expected: false},
+		{user: restricted, request: &commandRequest{command: infoCommand}, expected: false},
+		{user: restricted, request: &commandRequest{command: closeCommand}, expected: true},
+		{user: listed, request: &commandRequest{command: scanCommand}, expected: true},
+		{user: listed, request: &commandRequest{command: infoCommand}, expected: true},
+		{user: listed, request: &commandRequest{command: hotKeysCommand, length: 5}, expected: false},
+		{user: unrestricted, request: &commandRequest{command: hotKeysCommand, length: 5}, expected: true},
+		{user: unrestricted, request: &commandRequest{command: infoCommand}, expected: true},
+	}
+
+	for _, test := range tests {
+		if permitted := test.user.permits(test.request); permitted != test.expected {
+			t.Errorf("Expected %t for %s by %v but got %t", test.expected, test.request.name(), test.user, permitted)
+		}
+	}
+}
+
+func Test_LoadACL_MissingToken(t *testing.T) {
+	_, err := LoadACL(writeACLFile(t, `[{"name": "admin"}]`))
+	if !errors.Is(err, errMissingToken) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_LoadACL_DuplicateToken(t *testing.T) {
+	_, err := LoadACL(writeACLFile(t, `[{"name": "a", "token": "t"}, {"name": "b", "token": "t"}]`))
+	if !errors.Is(err, errDuplicateToken) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_LoadACL_UnknownCommand(t *testing.T) {
+	_, err := LoadACL(writeACLFile(t, `[{"name": "a", "token": "t", "commands": ["GET"]}]`))
+	if !errors.Is(err, errUnknownCommand) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_LoadACL_InvalidJSON(t *testing.T) {
+	if _, err := LoadACL(writeACLFile(t, `{`)); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func writeACLFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "acl.json")
+	if err := os.WriteFile(filename, []byte(contents), 0o600); err != nil {
+		t.Fatal("Unable to write ACL file: ", err)
+	}
+
+	return filename
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net"
+	"strings"
+)
+
+// unixScheme prefixes addresses of Unix domain sockets, such as unix:///var/run/kv.sock,
+// which co-located clients can use to avoid the overhead of TCP.
+const unixScheme = "unix://"
+
+// splitAddress returns the network and address to listen on or dial, based on the address scheme.
+// Addresses without a scheme are TCP hostnames and ports.
+func splitAddress(address string) (string, string) {
+	if strings.HasPrefix(address, unixScheme) {
+		return "unix", strings.TrimPrefix(address, unixScheme)
+	}
+
+	return "tcp4", address
+}
+
+// listenerAddress returns the address the listener is bound to, in the form splitAddress accepts.
+func listenerAddress(listener net.Listener) string {
+	if listener.Addr().Network() == "unix" {
+		return unixScheme + listener.Addr().String()
+	}
+
+	return listener.Addr().String()
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"tcp/pkg/hashring"
+	"tcp/pkg/kvstore"
+	"time"
+)
+
+const (
+	// how many ranges the keyspace is divided into (by the hash of each key), the leaves of the Merkle tree
+	merkleLeaves = 256
+
+	// kinds of anti-entropy request sent between peers
+	antiEntropyHashes = "hashes"
+	antiEntropyRange  = "range"
+)
+
+var errUnknownAntiEntropyRequest = errors.New("unknown anti-entropy request")
+
+// merkleTree summarises the keys in the store: each leaf is the hash of the keys and values in a range
+// of the keyspace, and each other node the hash of its two children, so servers with the same keys have
+// the same root, and the ranges that differ are found by only comparing the children of nodes that differ.
+// Nodes are numbered from 1 (the root), the children of node n being 2n and 2n+1, so the leaves are
+// numbered from merkleLeaves.
+type merkleTree struct {
+	nodes [2 * merkleLeaves][sha256.Size]byte
+}
+
+// merkleItem is a key in a range sent to a peer, with the timestamp of its last write if known.
+type merkleItem struct {
+	kvstore.Record
+	Timestamp string
+}
+
+// newMerkleTree returns the tree of the keys, as dumped from the store.
+func newMerkleTree(records []kvstore.Record) *merkleTree {
+	ranges := make([][]string, merkleLeaves)
+
+	for _, record := range records {
+		leaf := merkleRange(record.Key)
+		ranges[leaf] = append(ranges[leaf], formatArgument(record.Key)+recordValue(record))
+	}
+
+	tree := &merkleTree{}
+
+	for leaf, entries := range ranges {
+		// sorted, so the hash doesn't depend on the order the store returned the keys in
+		sort.Strings(entries)
+
+		hash := sha256.New()
+
+		for _, entry := range entries {
+			hash.Write([]byte(entry))
+		}
+
+		copy(tree.nodes[merkleLeaves+leaf][:], hash.Sum(nil))
+	}
+
+	for node := merkleLeaves - 1; node >= 1; node-- {
+		tree.nodes[node] = sha256.Sum256(append(tree.nodes[2*node][:], tree.nodes[2*node+1][:]...))
+	}
+
+	return tree
+}
+
+// recordValue returns what the key holds, its type and its string or collection's contents, but not its
+// expiry, the time each server sets it to differing a little.
+func recordValue(record kvstore.Record) string {
+	return formatArgument(string(record.Type)) + formatArgument(record.Value) + formatArgument(string(record.Contents))
+}
+
+// merkleRange returns the range of the keyspace the key is in.
+func merkleRange(key string) int {
+	return int(hashring.Hash(key) % merkleLeaves)
+}
+
+// hashes returns the hashes of the nodes, hex encoded, or an error if any don't exist.
+func (t *merkleTree) hashes(nodes []int) ([]string, error) {
+	hashes := make([]string, 0, len(nodes))
+
+	for _, node := range nodes {
+		if node < 1 || node >= len(t.nodes) {
+			return nil, fmt.Errorf("%w: no node %d", errUnknownAntiEntropyRequest, node)
+		}
+
+		hashes = append(hashes, hex.EncodeToString(t.nodes[node][:]))
+	}
+
+	return hashes, nil
+}
+
+// antiEntropyEnabled returns whether the keys are compared with the peers', which Raft and sharding
+// don't support.
+func (s *Server) antiEntropyEnabled() bool {
+	return s.config.AntiEntropyInterval > 0 && !s.config.Raft && s.config.ShardReplicas == 0
+}
+
+// handleAntiEntropy handles an anti-entropy request from a peer: the hashes of nodes of this server's
+// Merkle tree, or the keys in ranges of the keyspace, returning the JSON response.
+func (s *Server) handleAntiEntropy(kind string, body string) (string, error) {
+	var request []int
+
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		return "", fmt.Errorf("error decoding anti-entropy request: %w", err)
+	}
+
+	var response any
+
+	switch kind {
+	case antiEntropyHashes:
+		hashes, err := newMerkleTree(kvstore.Dump(s.store)).hashes(request)
+		if err != nil {
+			return "", err
+		}
+
+		response = hashes
+
+	case antiEntropyRange:
+		response = s.rangeItems(request)
+
+	default:
+		return "", fmt.Errorf("%w: %s", errUnknownAntiEntropyRequest, kind)
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return "", fmt.Errorf("error encoding anti-entropy response: %w", err)
+	}
+
+	return string(encoded), nil
+}
+
+// rangeItems returns the keys in the ranges of the keyspace, with their values and the timestamps of
+// their last writes, when resolving conflicts by last write wins.
+func (s *Server) rangeItems(ranges []int) []merkleItem {
+	wanted := make(map[int]bool, len(ranges))
+
+	for _, r := range ranges {
+		wanted[r] = true
+	}
+
+	var items []merkleItem
+
+	for _, record := range kvstore.Dump(s.store) {
+		if !wanted[merkleRange(record.Key)] {
+			continue
+		}
+
+		item := merkleItem{Record: record}
+
+		if s.lww != nil {
+			if t, found := s.lww.version(record.Key); found {
+				item.Timestamp = t.String()
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// antiEntropy compares this server's keys with those of a random peer every interval, until shut
+// down, repairing the ranges of the keyspace that differ.
+func (s *Server) antiEntropy(logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			peers := s.peerList()
+			if len(peers) == 0 {
+				continue
+			}
+
+			//nolint:gosec // choosing a peer doesn't need a secure random number
+			peer := s.peerPool.get([]string{peers[rand.Intn(len(peers))]})[0]
+
+			if err := s.syncRanges(logger, peer, now); err != nil {
+				logger.Debug("unable to compare keys", "peer", peer.address, "error", err)
+			}
+
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// syncRanges finds the ranges of the keyspace that differ from the peer, by descending the Merkle trees
+// from the root through the nodes that differ, then repairs them from the peer's keys.
+func (s *Server) syncRanges(logger *slog.Logger, peer *pooledPeer, now time.Time) error {
+	tree := newMerkleTree(kvstore.Dump(s.store))
+	nodes := []int{1}
+
+	for len(nodes) > 0 && nodes[0] < merkleLeaves {
+		var hashes []string
+
+		if err := antiEntropyCall(peer, antiEntropyHashes, nodes, &hashes, now); err != nil {
+			return err
+		}
+
+		local, err := tree.hashes(nodes)
+		if err != nil {
+			return err
+		}
+
+		if len(hashes) != len(nodes) {
+			return fmt.Errorf("%w: %d hashes for %d nodes", errUnexpectedResponse, len(hashes), len(nodes))
+		}
+
+		var differing []int
+
+		for i, node := range nodes {
+			if hashes[i] != local[i] {
+				differing = append(differing, 2*node, 2*node+1)
+			}
+		}
+
+		nodes = differing
+	}
+
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	// most of the keys may differ, so are streamed rather than sent all at once
+	if len(nodes) > merkleLeaves/2 {
+		return s.resync(logger, peer.address)
+	}
+
+	ranges := make([]int, 0, len(nodes))
+
+	for _, node := range nodes {
+		ranges = append(ranges, node-merkleLeaves)
+	}
+
+	var items []merkleItem
+
+	if err := antiEntropyCall(peer, antiEntropyRange, ranges, &items, now); err != nil {
+		return err
+	}
+
+	repaired := s.repair(logger, items)
+
+	logger.Info("compared keys with peer", "peer", peer.address, "ranges", len(ranges), "repaired", repaired)
+
+	return nil
+}
+
+// resync repairs the keys from a snapshot of every key the peer has, streamed a chunk at a time, when
+// most ranges of the keyspace differ. The snapshot doesn't include the timestamps of the keys' last
+// writes, so only keys missing here are repaired.
+func (s *Server) resync(logger *slog.Logger, peer string) error {
+	repaired := 0
+
+	_, err := streamSnapshot(peer, s.dialer(), func(records []kvstore.Record) error {
+		items := make([]merkleItem, 0, len(records))
+
+		for _, record := range records {
+			items = append(items, merkleItem{Record: record})
+		}
+
+		repaired += s.repair(logger, items)
+
+		return nil
+	})
+
+	logger.Info("resynced keys with peer", "peer", peer, "repaired", repaired)
+
+	return err
+}
+
+// restore sets the key to the peer's record, returning whether it could be decoded.
+func (s *Server) restore(logger *slog.Logger, record kvstore.Record) bool {
+	if err := kvstore.Restore(s.store, record); err != nil {
+		logger.Warn("unable to repair key", "key", record.Key, "error", err)
+		return false
+	}
+
+	return true
+}
+
+// antiEntropyCall sends the anti-entropy request to the peer as JSON, decoding its JSON response.
+func antiEntropyCall(peer *pooledPeer, kind string, request any, response any, now time.Time) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error encoding anti-entropy request: %w", err)
+	}
+
+	value, err := peer.call("aen"+formatArgument(kind)+formatArgument(string(body)), now)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(value), response); err != nil {
+		return fmt.Errorf("error decoding anti-entropy response: %w", err)
+	}
+
+	return nil
+}
+
+// repair applies the peer's keys in ranges that differ, returning how many were repaired. Keys missing
+// here are added, and when resolving conflicts by last write wins, keys last written later on the peer
+// are overwritten. Otherwise which value is newer isn't known, so keys with different values are left,
+// to be repaired by the next write to them. Keys only held here aren't removed, since it isn't known
+// whether the peer missed writing or deleting them.
+func (s *Server) repair(logger *slog.Logger, items []merkleItem) int {
+	repaired := 0
+
+	for _, item := range items {
+		local, found := kvstore.DumpKey(s.store, item.Key)
+
+		switch {
+		case found && recordValue(local) == recordValue(item.Record):
+
+		case s.lww != nil && item.Timestamp != "":
+			t, err := parseTimestamp(item.Timestamp)
+			if err != nil {
+				logger.Warn("ignoring key with invalid timestamp", "key", item.Key, "error", err)
+				continue
+			}
+
+			if s.lww.apply(item.Key, t, time.Time{}, func() { s.restore(logger, item.Record) }) {
+				repaired++
+			}
+
+		case !found:
+			if s.restore(logger, item.Record) {
+				repaired++
+			}
+
+		default:
+			logger.Debug("unable to repair key with a different value, not knowing which is newer", "key", item.Key)
+		}
+	}
+
+	s.mutex.Lock()
+	s.repairedKeys += repaired
+	s.mutex.Unlock()
+
+	return repaired
+}
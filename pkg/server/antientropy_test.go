@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_newMerkleTree(t *testing.T) {
+	a := kvstore.Record{Key: "a", Type: kvstore.TypeString, Value: "1"}
+	b := kvstore.Record{Key: "b", Type: kvstore.TypeString, Value: "2"}
+	c := kvstore.Record{Key: "c", Type: kvstore.TypeList, Contents: []byte(`["3"]`)}
+	tree := newMerkleTree([]kvstore.Record{a, b, c})
+
+	// the order of the keys doesn't matter, nor their expiries
+	expiring := kvstore.Record{Key: "a", Type: kvstore.TypeString, Value: "1", Expiry: time.Now().Add(time.Hour)}
+	if same := newMerkleTree([]kvstore.Record{c, expiring, b}); same.nodes != tree.nodes {
+		t.Error("Expected the same tree for the same keys")
+	}
+
+	// only the nodes above the range of the changed key differ
+	for _, changedB := range []kvstore.Record{
+		{Key: "b", Type: kvstore.TypeString, Value: "9"},
+		{Key: "b", Type: kvstore.TypeSet, Contents: []byte(`["2"]`)},
+	} {
+		changed := newMerkleTree([]kvstore.Record{a, changedB, c})
+		differing := 0
+
+		for node := range tree.nodes {
+			if tree.nodes[node] != changed.nodes[node] {
+				differing++
+			}
+		}
+
+		if differing != 9 {
+			t.Error("Expected 9 differing nodes but got: ", differing)
+		}
+
+		if changed.nodes[merkleLeaves+merkleRange("b")] == tree.nodes[merkleLeaves+merkleRange("b")] {
+			t.Error("Expected the range of the changed key to differ")
+		}
+	}
+
+	if _, err := tree.hashes([]int{0}); err == nil {
+		t.Error("Expected error for a node that doesn't exist")
+	}
+}
+
+func Test_Server_AntiEntropy(t *testing.T) {
+	// each server missed a write the other applied
+	stores := []*kvstore.KVStore{kvstore.NewKVStore(), kvstore.NewKVStore()}
+	kvstore.Write(stores[0], "a", "1")
+	kvstore.Write(stores[0], "b", "2")
+	kvstore.Write(stores[1], "b", "2")
+	kvstore.Write(stores[1], "c", "3")
+
+	// including collections
+	_ = stores[0].HashSet("h", "f", "4")
+	_ = stores[1].ListPush("l", "5", false)
+
+	serveAntiEntropy(t, stores)
+	waitForKeys(t, stores, 5)
+
+	for i, store := range stores {
+		if fields, _ := store.HashGetAll("h"); !reflect.DeepEqual(fields, map[string]string{"f": "4"}) {
+			t.Errorf("Expected hash repaired on server %d but got: %v", i, fields)
+		}
+
+		if elements, _ := store.ListRange("l", 0, -1); !reflect.DeepEqual(elements, []string{"5"}) {
+			t.Errorf("Expected list repaired on server %d but got: %v", i, elements)
+		}
+	}
+}
+
+func Test_Server_AntiEntropyResync(t *testing.T) {
+	// most ranges differ, so every key is streamed
+	stores := []*kvstore.KVStore{kvstore.NewKVStore(), kvstore.NewKVStore()}
+
+	for i := 0; i < 2*merkleLeaves; i++ {
+		kvstore.Write(stores[0], fmt.Sprintf("key%d", i), "x")
+	}
+
+	_ = stores[1].SetAdd("other", "y")
+
+	serveAntiEntropy(t, stores)
+	waitForKeys(t, stores, 2*merkleLeaves+1)
+
+	if member, _ := stores[0].SetIsMember("other", "y"); !member {
+		t.Error("Expected set resynced")
+	}
+}
+
+// serveAntiEntropy starts a server for each store, comparing keys with each other, until the test ends.
+func serveAntiEntropy(t *testing.T, stores []*kvstore.KVStore) {
+	t.Helper()
+
+	dir := t.TempDir()
+	peers := []string{unixScheme + filepath.Join(dir, "peer0.sock"), unixScheme + filepath.Join(dir, "peer1.sock")}
+
+	for i, peer := range peers {
+		srv := NewServer(stores[i], Config{
+			ServerHostnamePort:  "127.0.0.1:0",
+			PeerHostnamePort:    peer,
+			OtherServers:        []string{peers[1-i]},
+			AntiEntropyInterval: 10 * time.Millisecond,
+		})
+
+		if err := srv.Listen(); err != nil {
+			t.Fatal("Unable to listen: ", err)
+		}
+
+		go func() {
+			_ = srv.Serve()
+		}()
+
+		t.Cleanup(func() {
+			_ = srv.Shutdown(context.Background())
+		})
+	}
+}
+
+// waitForKeys waits for every store to have the number of keys.
+func waitForKeys(t *testing.T, stores []*kvstore.KVStore, expected int) {
+	t.Helper()
+
+	for i, store := range stores {
+		deadline := time.Now().Add(5 * time.Second)
+
+		for kvstore.Count(store) != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d keys on server %d but got %d", expected, i, kvstore.Count(store))
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BackpressurePolicy determines what happens to a write when a peer's outbound queue is full, because
+// writes are being made faster than the peer applies them.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock waits for space in the queue, so clients are slowed to the peer's pace.
+	BackpressureBlock BackpressurePolicy = iota
+
+	// BackpressureShed doesn't replicate the write to the peer, to be repaired by anti-entropy if enabled.
+	BackpressureShed BackpressurePolicy = iota
+
+	// BackpressureAsync queues the write anyway, up to the limit again, without waiting for the peer to
+	// apply it, as if replicating asynchronously, so clients aren't slowed but the peer falls behind.
+	BackpressureAsync BackpressurePolicy = iota
+)
+
+const defaultPeerQueueLimit = 1000
+
+var (
+	errUnknownBackpressurePolicy = errors.New("unknown backpressure policy")
+	errPeerQueueFull             = errors.New("peer queue full, write shed")
+	errSentInBackground          = errors.New("peer queue full, write sent in the background")
+)
+
+// ParseBackpressurePolicy returns the policy with the specified name: block, shed or async.
+func ParseBackpressurePolicy(name string) (BackpressurePolicy, error) {
+	switch name {
+	case "block":
+		return BackpressureBlock, nil
+
+	case "shed":
+		return BackpressureShed, nil
+
+	case "async":
+		return BackpressureAsync, nil
+
+	default:
+		return BackpressureBlock, fmt.Errorf("%w: %s", errUnknownBackpressurePolicy, name)
+	}
+}
+
+// peerQueue holds the writes waiting to be replicated to a peer, from every client connection, which are
+// sent in order, a batch at a time, by whichever connection next holds the peer's mutex. Once it holds
+// limit writes (default 1000), further writes are handled according to the policy. The zero value is an
+// empty queue with the default limit, that blocks when full.
+type peerQueue struct {
+	limit  int
+	policy BackpressurePolicy
+
+	mutex  sync.Mutex
+	space  *sync.Cond
+	writes []*pendingWrite
+
+	// the most writes queued at once, and how many writes were shed or degraded to async
+	peak     int
+	shed     int
+	degraded int
+}
+
+// peerQueueStats is the state of a peer's outbound queue, reported by the info command.
+type peerQueueStats struct {
+	depth    int
+	peak     int
+	shed     int
+	degraded int
+}
+
+// add queues the write, waiting for space when full if blocking, returning whether the connection
+// should wait for it to be sent, or false and an error if the write was shed.
+func (q *peerQueue) add(write *pendingWrite) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	limit := q.limit
+	if limit < 1 {
+		limit = defaultPeerQueueLimit
+	}
+
+	wait := true
+
+	switch {
+	case len(q.writes) < limit:
+
+	case q.policy == BackpressureShed, q.policy == BackpressureAsync && len(q.writes) >= 2*limit:
+		q.shed++
+		return false, errPeerQueueFull
+
+	case q.policy == BackpressureAsync:
+		q.degraded++
+		wait = false
+
+	default:
+		if q.space == nil {
+			q.space = sync.NewCond(&q.mutex)
+		}
+
+		for len(q.writes) >= limit {
+			q.space.Wait()
+		}
+	}
+
+	q.writes = append(q.writes, write)
+	q.peak = max(q.peak, len(q.writes))
+
+	return wait, nil
+}
+
+// take removes the oldest writes, as many as fit in a single batch of at most limit writes, waking
+// any connections waiting for space.
+func (q *peerQueue) take(limit int) []*pendingWrite {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	mutations := make([]string, min(len(q.writes), limit))
+
+	for i := range mutations {
+		mutations[i] = q.writes[i].mutation
+	}
+
+	batch := q.writes[:batchSize(mutations, limit)]
+	q.writes = q.writes[len(batch):]
+
+	if q.space != nil {
+		q.space.Broadcast()
+	}
+
+	return batch
+}
+
+// stats returns the state of the queue.
+func (q *peerQueue) stats() peerQueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return peerQueueStats{depth: len(q.writes), peak: q.peak, shed: q.shed, degraded: q.degraded}
+}
+
+// sendInBackground sends the queued writes up to and including the write, for a connection that
+// isn't waiting for it.
+func (p *pooledPeer) sendInBackground(write *pendingWrite) {
+	go func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		for !write.sent {
+			p.sendPending(time.Now())
+		}
+	}()
+}
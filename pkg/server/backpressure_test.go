@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_ParseBackpressurePolicy(t *testing.T) {
+	if policy, err := ParseBackpressurePolicy("shed"); policy != BackpressureShed || err != nil {
+		t.Errorf("Expected shed but got %d: %v", policy, err)
+	}
+
+	if _, err := ParseBackpressurePolicy("drop"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func Test_peerQueue_Shed(t *testing.T) {
+	queue := &peerQueue{limit: 1, policy: BackpressureShed}
+
+	if wait, err := queue.add(&pendingWrite{mutation: "put11a111"}); !wait || err != nil {
+		t.Errorf("Expected to wait for the write but got %v: %v", wait, err)
+	}
+
+	if _, err := queue.add(&pendingWrite{mutation: "put11a112"}); !errors.Is(err, errPeerQueueFull) {
+		t.Error("Expected queue full error but got: ", err)
+	}
+
+	if stats := queue.stats(); stats != (peerQueueStats{depth: 1, peak: 1, shed: 1}) {
+		t.Errorf("Expected 1 write queued and 1 shed but got %+v", stats)
+	}
+}
+
+func Test_peerQueue_Async(t *testing.T) {
+	queue := &peerQueue{limit: 1, policy: BackpressureAsync}
+
+	for i, expected := range []bool{true, false} {
+		if wait, err := queue.add(&pendingWrite{mutation: "put11a111"}); wait != expected || err != nil {
+			t.Errorf("Expected write %d waited for %v but got %v: %v", i, expected, wait, err)
+		}
+	}
+
+	// up to the limit again is queued without waiting
+	if _, err := queue.add(&pendingWrite{mutation: "put11a111"}); !errors.Is(err, errPeerQueueFull) {
+		t.Error("Expected queue full error but got: ", err)
+	}
+
+	if stats := queue.stats(); stats != (peerQueueStats{depth: 2, peak: 2, shed: 1, degraded: 1}) {
+		t.Errorf("Expected 2 writes queued, 1 shed and 1 degraded but got %+v", stats)
+	}
+}
+
+func Test_peerQueue_Block(t *testing.T) {
+	queue := &peerQueue{limit: 1}
+	_, _ = queue.add(&pendingWrite{mutation: "put11a111"})
+
+	added := make(chan struct{})
+
+	go func() {
+		_, _ = queue.add(&pendingWrite{mutation: "put11b112"})
+		close(added)
+	}()
+
+	select {
+	case <-added:
+		t.Fatal("Expected to wait for space in the queue")
+
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if batch := queue.take(maxBatchWrites); len(batch) != 1 || batch[0].mutation != "put11a111" {
+		t.Errorf("Expected the oldest write but got %v", batch)
+	}
+
+	<-added
+
+	if stats := queue.stats(); stats.depth != 1 {
+		t.Errorf("Expected 1 write queued but got %d", stats.depth)
+	}
+}
+
+func Test_pooledPeer_ReplicateOrRecordAsyncBackpressure(t *testing.T) {
+	conn, fakePeer := net.Pipe()
+	peer := connectedPeers(conn)[0]
+	peer.queue = peerQueue{limit: 1, policy: BackpressureAsync}
+
+	// another write is being sent, so the queue fills up
+	peer.mutex.Lock()
+
+	go func() {
+		_, _ = peer.replicateOrRecord("put11a111", time.Now())
+	}()
+
+	for peer.queue.stats().depth < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := peer.replicateOrRecord("put11b112", time.Now()); !errors.Is(err, errSentInBackground) {
+		t.Error("Expected write sent in the background but got: ", err)
+	}
+
+	peer.mutex.Unlock()
+
+	// still sent in order
+	read(t, fakePeer, "put11a111")
+	write(t, fakePeer, ackResponse)
+	read(t, fakePeer, "put11b112")
+	write(t, fakePeer, ackResponse)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// the most writes, and bytes of writes, sent to a peer in a single batch
+const (
+	maxBatchWrites = 100
+	maxBatchBytes  = 1 << 20
+)
+
+// batchFeature is the peer protocol feature of replicating several writes in a single batch command,
+// acknowledged once, rather than each write waiting for its own acknowledgement.
+const batchFeature = "batch"
+
+// pendingWrite is a write waiting to be replicated to a peer, along with the outcome once it has been.
+type pendingWrite struct {
+	mutation string
+	sent     bool
+	response string
+	err      error
+}
+
+// batchOf returns the writes framed as a single batch command, or the write itself if there is
+// only one.
+func batchOf(mutations []string) string {
+	if len(mutations) == 1 {
+		return mutations[0]
+	}
+
+	return "bat" + formatArgument(strings.Join(mutations, ""))
+}
+
+// batchSize returns how many of the writes, oldest first, fit in a single batch of at most limit
+// writes, which is always at least one.
+func batchSize(mutations []string, limit int) int {
+	size, bytes := 0, 0
+
+	for size < len(mutations) && size < limit {
+		bytes += len(mutations[size])
+
+		if size > 0 && bytes > maxBatchBytes {
+			break
+		}
+
+		size++
+	}
+
+	return size
+}
+
+// batchLimit returns the most writes that can be sent to the peer at once, which is one unless it
+// supports batches, must be called with the mutex locked.
+func (p *pooledPeer) batchLimit(now time.Time) int {
+	if p.connect(now) == nil && supportsFeature(p.conn, batchFeature) {
+		return maxBatchWrites
+	}
+
+	return 1
+}
+
+// replicateBatch sends as many of the writes, oldest first, as fit in a single batch to the peer, as
+// replicate does, returning how many were sent and the peer's response: ack if it applied them all.
+func (p *pooledPeer) replicateBatch(mutations []string, now time.Time) (int, string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	size := batchSize(mutations, p.batchLimit(now))
+
+	response, err := p.exchange(batchOf(mutations[:size]), now, readAck)
+
+	return size, response, err
+}
+
+// sendPending sends the oldest writes waiting to be replicated to the peer, as many as fit in a
+// single batch, recording them in the redo log if they can't be sent, must be called with the
+// mutex locked.
+func (p *pooledPeer) sendPending(now time.Time) {
+	batch := p.queue.take(p.batchLimit(now))
+	if len(batch) == 0 {
+		return
+	}
+
+	response, err := "", errPeerBehind
+
+	if len(p.redo) == 0 {
+		mutations := make([]string, len(batch))
+
+		for i, write := range batch {
+			mutations[i] = write.mutation
+		}
+
+		response, err = p.exchange(batchOf(mutations), now, readAck)
+	}
+
+	for _, write := range batch {
+		if err != nil && !errors.Is(err, errPeerClosed) {
+			p.record(write.mutation)
+		}
+
+		write.sent, write.response, write.err = true, response, err
+	}
+}
+
+// applyBatch applies the writes replicated by a peer in a single batch, in order, returning the
+// response, ack if every write was applied, and the reason if any weren't.
+func (s *session) applyBatch(batch string) (string, string) {
+	s.batchResponses = nil
+	s.batching = true
+
+	defer func() {
+		s.batching = false
+	}()
+
+	for batch != "" {
+		command, err := parseCommand(batch)
+		if command == nil || !(isMutation(command) || isPrefixCommand(command)) {
+			s.logger.Warn("rejecting batch with invalid command", "error", err)
+
+			return errorResponse, reasonInvalidCommand
+		}
+
+		batch = batch[len(command.originalText):]
+
+		s.handleCommand(command)
+	}
+
+	for _, response := range s.batchResponses {
+		if !writeApplied(response) {
+			return errorResponse, reasonBatchFailed
+		}
+	}
+
+	return ackResponse, ""
+}
+
+// isPrefixCommand returns whether the command applies to the write following it, rather than being
+// performed itself.
+func isPrefixCommand(request *commandRequest) bool {
+	switch request.command {
+	case idempotencyCommand, timestampCommand, durabilityCommand, originCommand:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// respond writes the response to the command, unless applying a batch, when it is kept for the batch's
+// response instead.
+func (s *session) respond(response string) error {
+	if s.batching {
+		s.batchResponses = append(s.batchResponses, response)
+		return nil
+	}
+
+	// after any changes already sent to the connection
+	if s.watcher != nil {
+		return s.watcher.queue(response)
+	}
+
+	return reliableWrite(s.conn, response)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_handle_Batch(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
+
+	kvstore.Write(store, "b", "2")
+
+	// acknowledged once, after every write is applied in order
+	checkRequestResponse(t, client, batchOf([]string{"put11a111", "del11b", "put11a13333"}), ackResponse)
+
+	if value, _ := kvstore.Read(store, "a"); value != "333" {
+		t.Errorf("Expected 333 but got %s", value)
+	}
+
+	if _, found := kvstore.Read(store, "b"); found {
+		t.Error("Expected b to be deleted")
+	}
+
+	// flushing isn't enabled, so one write isn't applied
+	checkRequestResponse(t, client, batchOf([]string{"put11c111", "fla"}), formatError(reasonBatchFailed))
+
+	if value, _ := kvstore.Read(store, "c"); value != "1" {
+		t.Errorf("Expected 1 but got %s", value)
+	}
+
+	checkRequestResponse(t, client, batchOf([]string{"put11c111", "get11c"}), formatError(reasonInvalidCommand))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_pooledPeer_ReplicateBatch(t *testing.T) {
+	conn, fakePeer := net.Pipe()
+	peer := connectedPeers(&peerConn{Conn: conn, features: []string{batchFeature}})[0]
+
+	go func() {
+		read(t, fakePeer, "bat227put11a111put11b112put11c113")
+		write(t, fakePeer, ackResponse)
+	}()
+
+	sent, response, err := peer.replicateBatch([]string{"put11a111", "put11b112", "put11c113"}, time.Now())
+	if sent != 3 || response != ackResponse || err != nil {
+		t.Errorf("Expected 3 writes acknowledged but got %d, %s: %v", sent, response, err)
+	}
+}
+
+func Test_pooledPeer_ReplicateBatchUnsupported(t *testing.T) {
+	conn, fakePeer := net.Pipe()
+	peer := connectedPeers(conn)[0]
+
+	go func() {
+		read(t, fakePeer, "put11a111")
+		write(t, fakePeer, ackResponse)
+	}()
+
+	// older peers are sent one write at a time
+	sent, response, err := peer.replicateBatch([]string{"put11a111", "put11b112"}, time.Now())
+	if sent != 1 || response != ackResponse || err != nil {
+		t.Errorf("Expected 1 write acknowledged but got %d, %s: %v", sent, response, err)
+	}
+}
+
+func Test_pooledPeer_ReplicateOrRecordCoalesces(t *testing.T) {
+	conn, fakePeer := net.Pipe()
+	peer := connectedPeers(&peerConn{Conn: conn, features: []string{batchFeature}})[0]
+
+	// another write is being sent, so these wait, then are sent together
+	peer.mutex.Lock()
+
+	responses := make(chan string)
+
+	for _, mutation := range []string{"put11a111", "put11b112"} {
+		go func(mutation string) {
+			response, _ := peer.replicateOrRecord(mutation, time.Now())
+			responses <- response
+		}(mutation)
+	}
+
+	for pending := 0; pending < 2; {
+		time.Sleep(time.Millisecond)
+
+		pending = peer.queue.stats().depth
+	}
+
+	peer.mutex.Unlock()
+
+	command := make([]byte, len(batchOf([]string{"put11a111", "put11b112"})))
+	if _, err := fakePeer.Read(command); err != nil || !strings.HasPrefix(string(command), "bat218") {
+		t.Fatalf("Expected a batch of both writes but got %s: %v", command, err)
+	}
+
+	write(t, fakePeer, ackResponse)
+
+	for i := 0; i < 2; i++ {
+		if response := <-responses; response != ackResponse {
+			t.Errorf("Expected ack but got %s", response)
+		}
+	}
+}
+
+func Test_batchSize(t *testing.T) {
+	mutations := make([]string, maxBatchWrites+1)
+
+	if size := batchSize(mutations, maxBatchWrites); size != maxBatchWrites {
+		t.Errorf("Expected %d writes but got %d", maxBatchWrites, size)
+	}
+
+	large := strings.Repeat("x", maxBatchBytes)
+
+	// always at least one write, however large
+	if size := batchSize([]string{large, "put11a111"}, maxBatchWrites); size != 1 {
+		t.Errorf("Expected 1 write but got %d", size)
+	}
+
+	if size := batchSize([]string{"put11a111", "put11b112"}, 1); size != 1 {
+		t.Errorf("Expected 1 write but got %d", size)
+	}
+}
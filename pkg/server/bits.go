@@ -0,0 +1,59 @@
+package server
+
+import (
+	"strconv"
+	"tcp/pkg/kvstore"
+)
+
+// executeSetBit sets the bit, returning its previous value, 0 or 1.
+func executeSetBit(store kvstore.Store, request *commandRequest) (string, error) {
+	bitmaps, err := bitStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	previous, err := bitmaps.SetBit(request.key, request.length, request.value == "1")
+	if err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return formatBit(previous), nil
+}
+
+// executeGetBit returns the bit, 0 or 1.
+func executeGetBit(store kvstore.Store, request *commandRequest) (string, error) {
+	bitmaps, err := bitStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	bit, err := bitmaps.GetBit(request.key, request.length)
+	if err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return formatBit(bit), nil
+}
+
+// formatBit returns the response holding the bit, 0 or 1.
+func formatBit(bit bool) string {
+	if bit {
+		return "val" + formatArgument("1")
+	}
+
+	return "val" + formatArgument("0")
+}
+
+func executeBitCount(store kvstore.Store, request *commandRequest) (string, error) {
+	bitmaps, err := bitStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	count, err := bitmaps.BitCount(request.key)
+	if err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return "val" + formatArgument(strconv.Itoa(count)), nil
+}
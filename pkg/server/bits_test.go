@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_Bits(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2), &handlerConfig{})
+
+	// bits set are replicated as the operations, not the whole value, returning the previous bit
+	checkReplicated(t, client, server2, "sbt11f111111", "sbt11f111111", "val110")
+	checkReplicated(t, client, server2, "sbt11f117111", "sbt11f117111", "val110")
+	checkRequestResponse(t, client, "get11f0", "val11A")
+	checkRequestResponse(t, client, "gbt11f111", "val111")
+	checkRequestResponse(t, client, "gbt11f13100", "val110")
+	checkRequestResponse(t, client, "bct11f", "val112")
+
+	checkReplicated(t, client, server2, "sbt11f111110", "sbt11f111110", "val111")
+	checkRequestResponse(t, client, "bct11f", "val111")
+	checkRequestResponse(t, client, "bct11z", "val110")
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_BitsWriteQuorum(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2),
+		&handlerConfig{writeQuorum: WriteQuorumAll})
+
+	// the peer's previous bit counts as it applying the write
+	write(t, client, "sbt11f111111")
+	read(t, server2, "sbt11f111111")
+	write(t, server2, "val110")
+	read(t, client, "val110")
+
+	write(t, client, "sbt11f111110")
+	read(t, server2, "sbt11f111110")
+	write(t, server2, "err")
+	read(t, client, formatError(reasonQuorumNotMet))
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_BitsWrongType(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "hst11h11a11x", ackResponse)
+	checkRequestResponse(t, client, "sbt11h110111", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "gbt11h110", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "bct11h", formatError(reasonWrongType))
+
+	checkRequestResponse(t, client, "bye", "")
+}
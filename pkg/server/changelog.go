@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"tcp/pkg/kvstore"
+	"time"
+)
+
+// operations recorded in the changelog
+const (
+	ChangelogPut    = "put"
+	ChangelogDelete = "del"
+)
+
+var errInvalidChangelog = errors.New("invalid changelog")
+
+// Changelog records every change to the store, however it was made (by a client, a peer, or a key
+// expiring), as one JSON object per line, appended in the order the changes were made, so the store can
+// be rebuilt as of any point in time by replaying it. A nil Changelog records nothing.
+type Changelog struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// ChangelogEntry is a change recorded in the changelog: a key put, with its value, or deleted. Keys
+// put with a time to live are recorded with when they expire, then deleted as of that time once found to
+// have expired, so the delete can follow entries for later changes to other keys. A collection, such as a
+// hash, changed in place is recorded as put with its type, its value being its contents as JSON.
+type ChangelogEntry struct {
+	Time      time.Time  `json:"time"`
+	Operation string     `json:"op"`
+	Key       string     `json:"key"`
+	Value     string     `json:"value,omitempty"`
+	Type      string     `json:"type,omitempty"`
+	Expiry    *time.Time `json:"expiry,omitempty"`
+}
+
+// NewChangelog returns a changelog writing to the writer.
+func NewChangelog(writer io.Writer) *Changelog {
+	return &Changelog{encoder: json.NewEncoder(writer)}
+}
+
+// record writes the change, at the time it was made.
+func (c *Changelog) record(change kvstore.Change, now time.Time) error {
+	if c == nil {
+		return nil
+	}
+
+	entry := ChangelogEntry{Time: now, Operation: ChangelogPut, Key: change.Key, Value: change.Value}
+
+	switch {
+	case change.Deleted && change.Expired:
+		// as of when the key expired, rather than when it was found to have expired
+		entry.Time, entry.Operation, entry.Value = change.Expiry, ChangelogDelete, ""
+
+	case change.Deleted:
+		entry.Operation, entry.Value = ChangelogDelete, ""
+
+	case change.Type != "":
+		entry.Value, entry.Type = change.Contents(), string(change.Type)
+	}
+
+	if !change.Deleted && !change.Expiry.IsZero() {
+		entry.Expiry = &change.Expiry
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.encoder.Encode(entry); err != nil {
+		return fmt.Errorf("error writing changelog: %w", err)
+	}
+
+	return nil
+}
+
+// ReadChangelog calls fn with each entry of the changelog in order, until the end of the changelog or fn
+// returns an error, which is returned. A final line cut short, such as by the server crashing while
+// writing it, is ignored.
+func ReadChangelog(reader io.Reader, fn func(entry ChangelogEntry) error) error {
+	lines := bufio.NewReader(reader)
+
+	for number := 1; ; number++ {
+		line, err := lines.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("error reading changelog: %w", err)
+		}
+
+		var entry ChangelogEntry
+
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("%w: line %d: %w", errInvalidChangelog, number, err)
+		}
+
+		if entry.Operation != ChangelogPut && entry.Operation != ChangelogDelete {
+			return fmt.Errorf("%w: line %d: unknown operation %s", errInvalidChangelog, number, entry.Operation)
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_Changelog(t *testing.T) {
+	var buffer bytes.Buffer
+
+	changelog := NewChangelog(&buffer)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, change := range []kvstore.Change{{Key: "a", Value: "1"}, {Key: "b", Value: ""}, {Key: "a", Deleted: true}} {
+		if err := changelog.record(change, now); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+	}
+
+	// the server crashing part way through writing a change
+	buffer.WriteString(`{"time":"2024-01-02T03:04:05Z","op":"put","ke`)
+
+	var entries []ChangelogEntry
+
+	err := ReadChangelog(&buffer, func(entry ChangelogEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+
+	expected := []ChangelogEntry{
+		{Time: now, Operation: ChangelogPut, Key: "a", Value: "1"},
+		{Time: now, Operation: ChangelogPut, Key: "b"},
+		{Time: now, Operation: ChangelogDelete, Key: "a"},
+	}
+
+	if err != nil || !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v but got %v, %v", expected, entries, err)
+	}
+}
+
+func Test_Changelog_Hash(t *testing.T) {
+	var buffer bytes.Buffer
+
+	changelog := NewChangelog(&buffer)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	store := kvstore.NewKVStore()
+	defer kvstore.Close(store)
+
+	kvstore.Observe(store, func(change kvstore.Change) {
+		if err := changelog.record(change, now); err != nil {
+			t.Error("Unexpected error: ", err)
+		}
+	})
+
+	_ = store.HashSet("a", "f", "1")
+
+	var entries []ChangelogEntry
+
+	err := ReadChangelog(&buffer, func(entry ChangelogEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+
+	expected := []ChangelogEntry{{Time: now, Operation: ChangelogPut, Key: "a", Value: `{"f":"1"}`, Type: "hash"}}
+
+	if err != nil || !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v but got %v, %v", expected, entries, err)
+	}
+}
+
+func Test_Changelog_Expiry(t *testing.T) {
+	var buffer bytes.Buffer
+
+	changelog := NewChangelog(&buffer)
+	now := time.Now().Add(time.Hour)
+
+	store := kvstore.NewKVStore()
+	defer kvstore.Close(store)
+
+	kvstore.Observe(store, func(change kvstore.Change) {
+		if err := changelog.record(change, now); err != nil {
+			t.Error("Unexpected error: ", err)
+		}
+	})
+
+	kvstore.WriteWithTTL(store, "a", "1", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	kvstore.Read(store, "a") // expired
+
+	var entries []ChangelogEntry
+
+	err := ReadChangelog(&buffer, func(entry ChangelogEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+
+	// the delete is recorded as of when the key expired, not when it was found to have expired
+	if err != nil || len(entries) != 2 || entries[0].Expiry == nil || !entries[1].Time.Equal(*entries[0].Expiry) ||
+		entries[1].Operation != ChangelogDelete || entries[1].Expiry != nil {
+		t.Errorf("Expected a put with its expiry, then a delete at that time, but got %+v, %v", entries, err)
+	}
+}
+
+func Test_Changelog_Nil(t *testing.T) {
+	var changelog *Changelog
+
+	if err := changelog.record(kvstore.Change{Key: "a"}, time.Now()); err != nil {
+		t.Error("Expected nothing recorded but got: ", err)
+	}
+}
+
+func Test_ReadChangelog_Invalid(t *testing.T) {
+	for _, text := range []string{"not json\n", `{"op":"get","key":"a"}` + "\n"} {
+		err := ReadChangelog(strings.NewReader(text), func(ChangelogEntry) error { return nil })
+		if !errors.Is(err, errInvalidChangelog) {
+			t.Errorf("Expected invalid changelog error for %s but got %v", text, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"tcp/pkg/kvstore"
+)
+
+// checksum returns the CRC-32 (IEEE) checksum of the value as 8 lowercase hex characters,
+// as supplied by clients to verify values end to end.
+func checksum(value string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(value)))
+}
+
+// verifyChecksum returns whether the client supplied checksum (if any) matches the value,
+// which is checked by the coordinator and again by each replica before the value is stored.
+func verifyChecksum(request *commandRequest) bool {
+	if request.command != checksumPutCommand {
+		return true
+	}
+
+	return strings.ToLower(request.checksum) == checksum(request.value)
+}
+
+// handleChecksumGet returns the whole value along with its checksum. Only verified values are
+// stored, so the checksum is recomputed from the stored value.
+func handleChecksumGet(store kvstore.Store, request *commandRequest) (string, error) {
+	value, present, err := readString(store, request.key)
+	if err != nil || !present {
+		return "nil", err
+	}
+
+	return "val" + formatArgument(value) + formatArgument(checksum(value)), nil
+}
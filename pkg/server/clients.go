@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// recordCommand records that the connection has started performing the command, for the client list.
+func (c *connection) recordCommand(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastCommand = name
+	c.commands++
+}
+
+// describe returns the connection's metadata as space-separated key=value pairs.
+func (c *connection) describe(now time.Time) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	lastCommand := c.lastCommand
+	if lastCommand == "" {
+		lastCommand = "none"
+	}
+
+	return fmt.Sprintf("id=%d addr=%s age_seconds=%d idle_seconds=%d last=%s commands=%d",
+		c.id, c.remoteAddr(), int(now.Sub(c.connected).Seconds()), int(now.Sub(c.lastActive).Seconds()),
+		lastCommand, c.commands)
+}
+
+// clients describes every client connection, in the order they connected.
+func (s *Server) clients() []string {
+	now := time.Now()
+
+	s.mutex.Lock()
+	connections := make([]*connection, 0, len(s.connections))
+
+	for c := range s.connections {
+		if c.config == s.clientConfig {
+			connections = append(connections, c)
+		}
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(connections, func(i, j int) bool { return connections[i].id < connections[j].id })
+
+	descriptions := make([]string, len(connections))
+	for i, c := range connections {
+		descriptions[i] = c.describe(now)
+	}
+
+	return descriptions
+}
+
+// killClient closes the client connection with the id, once any command in progress has finished,
+// returning whether it was found.
+func (s *Server) killClient(id int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.connections {
+		if c.config == s.clientConfig && c.id == uint64(id) {
+			c.closeWhenIdle()
+			return true
+		}
+	}
+
+	return false
+}
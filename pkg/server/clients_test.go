@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_Server_ClientList(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(context.Background())
+
+	client1, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	client2, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	checkRequestResponse(t, client1, "get12bb0", "nil")
+	checkRequestResponse(t, client2, "put12bb13999", "ack")
+	write(t, client1, "cls")
+
+	clients, err := readList(client1)
+	if err != nil {
+		t.Fatal("Unable to read client list: ", err)
+	}
+
+	if len(clients) != 2 {
+		t.Fatalf("Expected 2 clients but got: %v", clients)
+	}
+
+	for i, expected := range []string{"id=1 ", "id=2 "} {
+		if !strings.HasPrefix(clients[i], expected) {
+			t.Errorf("Expected client %d to start with %s but got: %s", i, expected, clients[i])
+		}
+	}
+
+	if fields := strings.Fields(clients[1]); !reflect.DeepEqual(fields[4:], []string{"last=put", "commands=1"}) {
+		t.Error("Wrong client metadata: ", clients[1])
+	}
+
+	checkRequestResponse(t, client1, "clk112", "ack")
+	checkRequestResponse(t, client1, "clk119", formatError(reasonUnknownClient))
+
+	// the killed client's connection is closed
+	_ = client2.SetReadDeadline(time.Now().Add(time.Second))
+
+	if _, err := client2.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"tcp/pkg/kvstore"
+	"time"
+)
+
+var errUnsupportedType = errors.New("type of value not supported by the store")
+
+// readString reads the key's value, failing if it holds a collection, such as a hash, rather than a string.
+func readString(store kvstore.Store, key string) (string, bool, error) {
+	var (
+		value   string
+		present bool
+	)
+
+	if checked, ok := store.(kvstore.CheckedStore); ok {
+		var err error
+
+		if value, present, err = checked.ReadChecked(key); err != nil {
+			return "", false, err
+		}
+	} else {
+		value, present = store.Read(key)
+	}
+
+	if present {
+		return value, true, nil
+	}
+
+	if typed, ok := store.(kvstore.TypedStore); ok && typed.Type(key) != kvstore.TypeNone {
+		return "", false, kvstore.ErrWrongType
+	}
+
+	return "", false, nil
+}
+
+// writeString sets the key's value, expiring after the time to live unless zero, failing if the store
+// can't write it.
+func writeString(store kvstore.Store, key string, value string, ttl time.Duration) error {
+	if checked, ok := store.(kvstore.CheckedStore); ok {
+		return checked.WriteChecked(key, value, ttl)
+	}
+
+	if ttl > 0 {
+		store.WriteWithTTL(key, value, ttl)
+	} else {
+		store.Write(key, value)
+	}
+
+	return nil
+}
+
+// writeStringIf sets the key's value if the options' conditions are met, returning whether it was written,
+// failing if the store can't write it.
+func writeStringIf(store kvstore.Store, key string, value string, options kvstore.WriteOptions) (bool, error) {
+	if checked, ok := store.(kvstore.CheckedStore); ok {
+		return checked.WriteIfChecked(key, value, options)
+	}
+
+	return store.WriteIf(key, value, options), nil
+}
+
+// deleteKey removes the key, if present, failing if the store can't delete it.
+func deleteKey(store kvstore.Store, key string) error {
+	if checked, ok := store.(kvstore.CheckedStore); ok {
+		return checked.DeleteChecked(key)
+	}
+
+	store.Delete(key)
+
+	return nil
+}
+
+// clearStore removes every key, failing if the store can't remove them.
+func clearStore(store kvstore.Store) error {
+	if checked, ok := store.(kvstore.CheckedStore); ok {
+		return checked.ClearChecked()
+	}
+
+	store.Clear()
+
+	return nil
+}
+
+// executeType describes the key's value as space separated name=value fields, like the info command: its
+// type, size (the bytes of a string, or elements of a collection), TTL in milliseconds, -1 if it never
+// expires, and version, 0 unless replaced by the timestamp of its last write when resolving conflicting
+// writes by last write wins. The response is nil if the key is absent.
+func executeType(store kvstore.Store, request *commandRequest) (string, error) {
+	typed, ok := store.(kvstore.TypedStore)
+	if !ok {
+		return "", errUnsupportedType
+	}
+
+	metadata := typed.Metadata(request.key)
+	if metadata.Type == kvstore.TypeNone {
+		return "nil", nil
+	}
+
+	ttl := int64(-1)
+	if metadata.TTL > 0 {
+		ttl = max(metadata.TTL.Milliseconds(), 1)
+	}
+
+	return "val" + formatArgument(fmt.Sprintf("type=%s size=%d ttl_ms=%d version=%s",
+		metadata.Type, metadata.Size, ttl, unversioned)), nil
+}
+
+// hashStore returns the store, if it holds hashes.
+func hashStore(store kvstore.Store) (kvstore.HashStore, error) {
+	hashes, ok := store.(kvstore.HashStore)
+	if !ok {
+		return nil, errUnsupportedType
+	}
+
+	return hashes, nil
+}
+
+// listStore returns the store, if it holds lists.
+func listStore(store kvstore.Store) (kvstore.ListStore, error) {
+	lists, ok := store.(kvstore.ListStore)
+	if !ok {
+		return nil, errUnsupportedType
+	}
+
+	return lists, nil
+}
+
+// setStore returns the store, if it holds sets.
+func setStore(store kvstore.Store) (kvstore.SetStore, error) {
+	sets, ok := store.(kvstore.SetStore)
+	if !ok {
+		return nil, errUnsupportedType
+	}
+
+	return sets, nil
+}
+
+// sortedSetStore returns the store, if it holds sorted sets.
+func sortedSetStore(store kvstore.Store) (kvstore.SortedSetStore, error) {
+	sortedSets, ok := store.(kvstore.SortedSetStore)
+	if !ok {
+		return nil, errUnsupportedType
+	}
+
+	return sortedSets, nil
+}
+
+// bitStore returns the store, if it can change strings as bitmaps.
+func bitStore(store kvstore.Store) (kvstore.BitStore, error) {
+	bitmaps, ok := store.(kvstore.BitStore)
+	if !ok {
+		return nil, errUnsupportedType
+	}
+
+	return bitmaps, nil
+}
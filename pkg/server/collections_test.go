@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_Type(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "put11s13abc", ackResponse)
+	checkRequestResponse(t, client, "typ11s", "val"+formatArgument("type=string size=3 ttl_ms=-1 version=0"))
+
+	checkRequestResponse(t, client, "lpu11l11a", ackResponse)
+	checkRequestResponse(t, client, "lpu11l11b", ackResponse)
+	checkRequestResponse(t, client, "typ11l", "val"+formatArgument("type=list size=2 ttl_ms=-1 version=0"))
+
+	checkRequestResponse(t, client, "pex11e11v16600000", ackResponse)
+
+	write(t, client, "typ11e")
+
+	if description := readValue(t, client); !strings.HasPrefix(description, "type=string size=1 ttl_ms=") ||
+		strings.Contains(description, "=-1") || !strings.HasSuffix(description, " version=0") {
+		t.Errorf("Expected a string expiring but got %s", description)
+	}
+
+	checkRequestResponse(t, client, "typ11z", "nil")
+	checkRequestResponse(t, client, "bye", "")
+}
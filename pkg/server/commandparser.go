@@ -0,0 +1,240 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"slices"
+)
+
+// field is a part of a command following its name.
+type field int
+
+const (
+	// a 3 part argument, see parseArgument
+	argumentField field = iota
+
+	// the length of a get: 1 digit, then that many digits
+	lengthField field = iota
+)
+
+// most buffer kept by a connection's parser between commands, so a large command's buffer is released
+const retainedBufferSize = 64 << 10
+
+// commandParser parses the commands read from a connection as the input arrives. It remembers how far
+// through the command being read it has got, and how much input is needed before it can get any further,
+// so each read only scans the new input, rather than the whole command being parsed again from the start.
+// Each command is parsed by parseCommand once all of it has been read, the input being kept as bytes
+// until then, so a multi-byte character split across reads is whole again before it is parsed. Commands
+// registered with RegisterCommand, whose fields aren't known, are parsed whole after each read instead.
+type commandParser struct {
+	buffer []byte
+
+	// the fields of the command being read, once its name has been read, the next field and where it starts
+	fields []field
+	named  bool
+	field  int
+	offset int
+
+	// how long the buffer must be before the command can be scanned any further
+	needed int
+}
+
+// add appends input read from the connection.
+func (p *commandParser) add(input []byte) {
+	p.buffer = append(p.buffer, input...)
+}
+
+// readFrom reads from the reader until the buffer holds size bytes, straight into the buffer, so a large
+// command is read into a buffer of its size rather than growing it a read at a time.
+func (p *commandParser) readFrom(reader io.Reader, size int) error {
+	p.buffer = slices.Grow(p.buffer, size-len(p.buffer))
+
+	numRead, err := io.ReadFull(reader, p.buffer[len(p.buffer):size])
+	p.buffer = p.buffer[:len(p.buffer)+numRead]
+
+	if err != nil {
+		return fmt.Errorf("error reading command: %w", err)
+	}
+
+	return nil
+}
+
+// buffered returns how much input has been read but not yet parsed.
+func (p *commandParser) buffered() int {
+	return len(p.buffer)
+}
+
+// required returns how long the buffer must be for the command being read to be complete, as far as
+// is known so far.
+func (p *commandParser) required() int {
+	return max(p.needed, len(p.buffer))
+}
+
+// pending returns the input read but not yet parsed.
+func (p *commandParser) pending() string {
+	return string(p.buffer)
+}
+
+// reset discards the input read but not yet parsed, such as after an invalid command.
+func (p *commandParser) reset() {
+	p.consume(len(p.buffer))
+}
+
+// next returns the next command, or nil if it hasn't all been read yet, or an error if it is invalid.
+func (p *commandParser) next() (*commandRequest, error) {
+	if len(p.buffer) < p.needed {
+		return nil, nil
+	}
+
+	end, complete, whole := p.scan()
+	if !complete {
+		return nil, nil
+	}
+
+	// only the command is copied, not any pipelined after it
+	if whole {
+		end = len(p.buffer)
+	}
+
+	buffer := string(p.buffer[:end])
+
+	// parsed once all of it has been read, or as a whole if it can't be scanned, such as so an invalid
+	// command's error is reported
+	command, err := parseCommand(buffer)
+	if command == nil && err == nil {
+		// not expected, but if so then parsed again once more has been read
+		p.needed = len(p.buffer) + 1
+
+		return nil, nil
+	}
+
+	if command != nil {
+		p.consume(len(command.originalText))
+	}
+
+	return command, err
+}
+
+// scan scans the fields of the command being read, from where it got to, returning where the command
+// ends once all of it has been read, or that it can't be scanned, so must be parsed as a whole.
+func (p *commandParser) scan() (int, bool, bool) {
+	if !p.named {
+		if complete, whole := p.scanName(); !complete || whole {
+			return 0, complete, whole
+		}
+	}
+
+	for p.field < len(p.fields) {
+		var complete, whole bool
+
+		if p.fields[p.field] == lengthField {
+			complete, whole = p.scanLength()
+		} else {
+			complete, whole = p.scanArgument()
+		}
+
+		if !complete || whole {
+			return 0, complete, whole
+		}
+
+		p.field++
+	}
+
+	return p.offset, true, false
+}
+
+// scanName finds the command's name, and so its fields.
+func (p *commandParser) scanName() (bool, bool) {
+	longest := commands.longestName()
+	start := string(p.buffer[:min(len(p.buffer), longest)])
+
+	switch command := commands.find(start).(type) {
+	case *builtinCommand:
+		p.fields, p.named, p.offset = command.fields, true, len(command.name)
+
+		return true, false
+
+	case nil:
+		if len(p.buffer) < longest && commands.isPrefix(start) {
+			p.needed = len(p.buffer) + 1
+
+			return false, false
+		}
+	}
+
+	// invalid, or registered
+	return true, true
+}
+
+// scanLength scans a get's length.
+func (p *commandParser) scanLength() (bool, bool) {
+	if !p.available(p.offset + 1) {
+		return false, false
+	}
+
+	digits, err := parseCount(string(p.buffer[p.offset : p.offset+1]))
+	if err != nil {
+		return true, true
+	}
+
+	if !p.available(p.offset + 1 + digits) {
+		return false, false
+	}
+
+	p.offset += 1 + digits
+
+	return true, false
+}
+
+// scanArgument scans a 3 part argument.
+func (p *commandParser) scanArgument() (bool, bool) {
+	if !p.available(p.offset + 1) {
+		return false, false
+	}
+
+	digits, err := parseCount(string(p.buffer[p.offset : p.offset+1]))
+	if err != nil {
+		return true, true
+	}
+
+	if !p.available(p.offset + 1 + digits) {
+		return false, false
+	}
+
+	size, err := parseCount(string(p.buffer[p.offset+1 : p.offset+1+digits]))
+	if err != nil {
+		return true, true
+	}
+
+	if !p.available(p.offset + 1 + digits + size) {
+		return false, false
+	}
+
+	p.offset += 1 + digits + size
+
+	return true, false
+}
+
+// available returns whether the buffer is at least the length, otherwise recording that it is needed.
+func (p *commandParser) available(length int) bool {
+	if len(p.buffer) < length {
+		p.needed = length
+
+		return false
+	}
+
+	return true
+}
+
+// consume removes the start of the buffer, once parsed, ready to read the next command.
+func (p *commandParser) consume(length int) {
+	remaining := p.buffer[length:]
+
+	if cap(p.buffer) > retainedBufferSize {
+		p.buffer = append([]byte(nil), remaining...)
+	} else {
+		p.buffer = p.buffer[:copy(p.buffer, remaining)]
+	}
+
+	p.fields, p.named, p.field, p.offset, p.needed = nil, false, 0, 0, 0
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_commandParser_Pipelined(t *testing.T) {
+	text := "put11a13foo" + "get11a0" + "auth16secret" + "pex11b11c13100" + "bye"
+
+	for _, chunkSize := range []int{1, 2, 5, len(text)} {
+		var parser commandParser
+
+		var parsed []string
+
+		for start := 0; start < len(text); start += chunkSize {
+			parser.add([]byte(text[start:min(start+chunkSize, len(text))]))
+
+			for {
+				command, err := parser.next()
+				if err != nil {
+					t.Fatal("Unexpected error: ", err)
+				}
+
+				if command == nil {
+					break
+				}
+
+				parsed = append(parsed, command.originalText)
+			}
+		}
+
+		expected := []string{"put11a13foo", "get11a0", "auth16secret", "pex11b11c13100", "bye"}
+		if !reflect.DeepEqual(parsed, expected) || parser.buffered() != 0 {
+			t.Errorf("Expected %v for chunks of %d but got %v, %d left", expected, chunkSize, parsed, parser.buffered())
+		}
+	}
+}
+
+func Test_commandParser_required(t *testing.T) {
+	for buffer, expected := range map[string]int{
+		"put11a15ab":      13, // value incomplete
+		"put11a1":         8,  // value size not yet read
+		"put3100":         107,
+		"pck11a13foo18ab": 21,
+		"get11a2":         9,
+		"pu":              3,
+		"":                1,
+	} {
+		var parser commandParser
+
+		parser.add([]byte(buffer))
+
+		if command, err := parser.next(); command != nil || err != nil {
+			t.Errorf("Expected %s to be incomplete but got %v, %v", buffer, command, err)
+		}
+
+		if actual := parser.required(); actual != expected {
+			t.Errorf("Expected %d for %s but got %d", expected, buffer, actual)
+		}
+	}
+}
+
+func Test_commandParser_Invalid(t *testing.T) {
+	var parser commandParser
+
+	parser.add([]byte("put1x"))
+
+	if command, err := parser.next(); command != nil || err == nil {
+		t.Errorf("Expected an error but got %v, %v", command, err)
+	}
+
+	parser.reset()
+	parser.add([]byte("del11a"))
+
+	command, err := parser.next()
+	if err != nil || command == nil || command.originalText != "del11a" {
+		t.Errorf("Expected the next command once reset but got %v, %v", command, err)
+	}
+}
+
+func Test_commandParser_readFrom(t *testing.T) {
+	var parser commandParser
+
+	parser.add([]byte("put11a15a"))
+
+	if err := parser.readFrom(strings.NewReader("bcdeget11a0"), 13); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	command, err := parser.next()
+	if err != nil || command == nil || command.value != "abcde" || parser.buffered() != 0 {
+		t.Errorf("Expected only the put to be read but got %v, %v, %d left", command, err, parser.buffered())
+	}
+}
+
+// FuzzCommandParser checks parsing input as it arrives, a byte at a time, finds the same commands as
+// parsing all of it at once, up to the first invalid command.
+func FuzzCommandParser(f *testing.F) {
+	for _, seed := range []string{
+		"put11a13foo" + "get11b3123", "del11a" + "bye", "auth16secret" + "pck11a13foo188c736521",
+		"pex11a11b14100" + "hot15", "scn10112" + "snc11a15", "bat215put11a111del11b", "inc11a12-1",
+		"put12-1a", "912345", "pu", "get11b0" + "ver", "put16キー14😀" + "get16キー13",
+		"pto11a11b17nx,px=5" + "prs11a",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, buffer string) {
+		var expected []string
+
+		expectedErr := false
+
+		for remaining := buffer; ; {
+			command, err := parseCommand(remaining)
+			if err != nil || command == nil {
+				expectedErr = err != nil
+
+				break
+			}
+
+			expected = append(expected, command.originalText)
+			remaining = remaining[len(command.originalText):]
+		}
+
+		var (
+			parser commandParser
+			parsed []string
+			failed bool
+		)
+
+		for i := 0; i < len(buffer) && !failed; i++ {
+			parser.add([]byte{buffer[i]})
+
+			for {
+				command, err := parser.next()
+				if err != nil {
+					failed = true
+				}
+
+				if command == nil {
+					break
+				}
+
+				parsed = append(parsed, command.originalText)
+			}
+		}
+
+		if !reflect.DeepEqual(parsed, expected) || failed != expectedErr {
+			t.Errorf("Expected %q, %t for %q but got %q, %t", expected, expectedErr, buffer, parsed, failed)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// compressionFeature is the peer protocol feature of sending large commands compressed, as a cmp
+// command holding the commands, to reduce the bandwidth used replicating large values between sites.
+const compressionFeature = "compress"
+
+var errCommandTooLarge = errors.New("decompressed command too large")
+
+// compressed returns the command compressed, if the connection is to a peer that supports compression
+// and the command is larger than the connection's threshold, otherwise the command itself, as it is
+// when compressing doesn't make it any smaller.
+func compressed(conn net.Conn, command string) string {
+	negotiated, ok := conn.(*peerConn)
+	if !ok || negotiated.compressAbove < 1 || len(command) <= negotiated.compressAbove ||
+		!contains(negotiated.features, compressionFeature) {
+		return command
+	}
+
+	var buffer bytes.Buffer
+
+	// only fails for an invalid level
+	writer, _ := flate.NewWriter(&buffer, flate.BestSpeed)
+
+	_, _ = writer.Write([]byte(command))
+	_ = writer.Close()
+
+	wrapped := "cmp" + formatArgument(buffer.String())
+	if len(wrapped) >= len(command) {
+		return command
+	}
+
+	return wrapped
+}
+
+// decompress returns the commands compressed by a peer, or an error if they are invalid or larger
+// than the limit.
+func decompress(compressed string, limit int) (string, error) {
+	reader := flate.NewReader(strings.NewReader(compressed))
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil {
+		return "", fmt.Errorf("error decompressing command: %w", err)
+	}
+
+	if len(decompressed) > limit {
+		return "", fmt.Errorf("%w: over %d bytes", errCommandTooLarge, limit)
+	}
+
+	return string(decompressed), nil
+}
+
+// handleCompressed handles the commands compressed by a peer in turn, each writing its own response,
+// returning closeRequest if the connection should now be closed, otherwise the response and reason
+// if they were invalid.
+func (s *session) handleCompressed(compressed string) (string, string) {
+	commands, err := decompress(compressed, s.config.commandSizeLimit())
+	if err != nil {
+		s.logger.Warn("rejecting invalid compressed command", "error", err)
+
+		return errorResponse, reasonInvalidCommand
+	}
+
+	for commands != "" {
+		command, err := parseCommand(commands)
+		if command == nil || command.command == compressedCommand {
+			s.logger.Warn("rejecting compressed command with invalid command", "error", err)
+
+			return errorResponse, reasonInvalidCommand
+		}
+
+		commands = commands[len(command.originalText):]
+
+		if s.handleCommand(command) {
+			return closeRequest, ""
+		}
+	}
+
+	return "", ""
+}
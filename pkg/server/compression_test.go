@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+// compressingPeer is a connection to a peer supporting compression, compressing commands over 10 bytes.
+var compressingPeer = &peerConn{features: []string{compressionFeature}, compressAbove: 10}
+
+func Test_compressed(t *testing.T) {
+	command := "put11a" + formatArgument(strings.Repeat("x", 1000))
+
+	wrapped := compressed(compressingPeer, command)
+	if !strings.HasPrefix(wrapped, "cmp") || len(wrapped) >= len(command) {
+		t.Fatalf("Expected a smaller compressed command but got %d bytes", len(wrapped))
+	}
+
+	parsed, err := parseCommand(wrapped)
+	if err != nil {
+		t.Fatal("Unable to parse compressed command: ", err)
+	}
+
+	if decompressed, err := decompress(parsed.value, 2000); decompressed != command || err != nil {
+		t.Errorf("Expected the original command but got %d bytes: %v", len(decompressed), err)
+	}
+
+	// too small, or the peer doesn't support compression
+	for _, conn := range []net.Conn{compressingPeer, &peerConn{compressAbove: 10}, nil} {
+		if actual := compressed(conn, "put11a111"); actual != "put11a111" {
+			t.Errorf("Expected uncompressed command but got %s", actual)
+		}
+	}
+
+	if actual := compressed(&peerConn{compressAbove: 10}, command); actual != command {
+		t.Error("Expected uncompressed command when the peer doesn't support compression")
+	}
+}
+
+func Test_decompress_TooLarge(t *testing.T) {
+	parsed, _ := parseCommand(compressed(compressingPeer, strings.Repeat("x", 1000)))
+
+	if _, err := decompress(parsed.value, 999); !errors.Is(err, errCommandTooLarge) {
+		t.Error("Expected too large error but got: ", err)
+	}
+}
+
+func Test_handle_Compressed(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
+
+	value := strings.Repeat("x", 1000)
+
+	checkRequestResponse(t, client, compressed(compressingPeer, "put11a"+formatArgument(value)), ackResponse)
+
+	if actual, _ := kvstore.Read(store, "a"); actual != value {
+		t.Errorf("Expected %d bytes but got %d", len(value), len(actual))
+	}
+
+	// a batch, compressed
+	batch := batchOf([]string{"put11b" + formatArgument(value), "del11a"})
+	checkRequestResponse(t, client, compressed(compressingPeer, batch), ackResponse)
+
+	if _, found := kvstore.Read(store, "a"); found {
+		t.Error("Expected a to be deleted")
+	}
+
+	checkRequestResponse(t, client, "cmp15xxxxx", formatError(reasonInvalidCommand))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_Server_PeerCompression(t *testing.T) {
+	peerStore := kvstore.NewKVStore()
+	peerServer := NewServer(peerStore, Config{ServerHostnamePort: "127.0.0.1:0", PeerHostnamePort: "127.0.0.1:0"})
+
+	if err := peerServer.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = peerServer.Serve()
+	}()
+
+	defer peerServer.Shutdown(context.Background())
+
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort:       "127.0.0.1:0",
+		PeerHostnamePort:         "127.0.0.1:0",
+		OtherServers:             []string{peerServer.PeerAddr().String()},
+		PeerCompressionThreshold: 100,
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(context.Background())
+
+	client, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	value := strings.Repeat("x", 1000)
+
+	checkRequestResponse(t, client, "put11a"+formatArgument(value), ackResponse)
+	checkRequestResponse(t, client, "bye", "")
+
+	if actual, _ := kvstore.Read(peerStore, "a"); actual != value {
+		t.Errorf("Expected %d bytes replicated but got %d", len(value), len(actual))
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// connection wraps an accepted connection, tracking whether a command is in progress
+// so that it can be closed cleanly between commands, and applying any read and write deadlines.
+type connection struct {
+	io.ReadWriteCloser
+
+	// maximum time to wait for each read or write, if not zero
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// how long the connection can be idle before being closed by the reaper, if not zero
+	idleTimeout time.Duration
+
+	// settings of the listener that accepted the connection
+	config *handlerConfig
+
+	// identifies the connection in the client list
+	id        uint64
+	connected time.Time
+
+	// held while writing, so responses and watched changes written by different go routines aren't
+	// interleaved
+	writeMutex sync.Mutex
+
+	mutex       sync.Mutex
+	busy        bool
+	closing     bool
+	lastActive  time.Time
+	lastCommand string
+	commands    int
+}
+
+// deadliner is implemented by connections supporting deadlines, such as net.Conn.
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+func newConnection(conn io.ReadWriteCloser) *connection {
+	now := time.Now()
+
+	return &connection{ReadWriteCloser: conn, connected: now, lastActive: now}
+}
+
+// beginCommand marks a command as in progress, returning false if the connection is closing.
+func (c *connection) beginCommand() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.busy = !c.closing
+
+	return c.busy
+}
+
+// endCommand marks the command as finished, returning whether the connection should now close.
+func (c *connection) endCommand() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.busy = false
+
+	return c.closing
+}
+
+// closeWhenIdle closes the connection now if no command is in progress, otherwise the
+// connection is closed once the current command has finished.
+func (c *connection) closeWhenIdle() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closing = true
+
+	if !c.busy {
+		_ = c.Close()
+	}
+}
+
+// Read reads from the connection, failing if no data arrives within the read timeout.
+func (c *connection) Read(p []byte) (int, error) {
+	if d, ok := c.ReadWriteCloser.(deadliner); ok && c.readTimeout > 0 {
+		_ = d.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+
+	numRead, err := c.ReadWriteCloser.Read(p)
+	if numRead > 0 {
+		c.mutex.Lock()
+		c.lastActive = time.Now()
+		c.mutex.Unlock()
+	}
+
+	return numRead, err //nolint:wrapcheck // callers wrap errors
+}
+
+// isIdle returns whether nothing has been received for longer than the idle timeout,
+// with no command in progress.
+func (c *connection) isIdle(now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.idleTimeout > 0 && !c.busy && now.Sub(c.lastActive) > c.idleTimeout
+}
+
+// limits on discarding what a client is still sending after its command has been rejected
+const (
+	drainSize = 1 << 20
+	drainTime = time.Second
+)
+
+// drain stops writing to the connection, then discards what the client is still sending, up to a limit,
+// before the connection is closed, so closing it with input unread doesn't reset it and lose the response
+// already written, such as when rejecting a command too large to read. Connections that can't be half
+// closed are left as they are.
+func (c *connection) drain() {
+	closer, ok := c.ReadWriteCloser.(interface{ CloseWrite() error })
+	if !ok || closer.CloseWrite() != nil {
+		return
+	}
+
+	if d, ok := c.ReadWriteCloser.(deadliner); ok {
+		_ = d.SetReadDeadline(time.Now().Add(drainTime))
+	}
+
+	_, _ = io.CopyN(io.Discard, c.ReadWriteCloser, drainSize)
+}
+
+// remoteAddr returns the address of the other end of the connection, if known.
+func (c *connection) remoteAddr() string {
+	if conn, ok := c.ReadWriteCloser.(interface{ RemoteAddr() net.Addr }); ok && conn.RemoteAddr() != nil {
+		return conn.RemoteAddr().String()
+	}
+
+	return "unknown"
+}
+
+// Write writes to the connection, failing if the data can't be written within the write timeout.
+func (c *connection) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if d, ok := c.ReadWriteCloser.(deadliner); ok && c.writeTimeout > 0 {
+		_ = d.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+
+	return c.ReadWriteCloser.Write(p) //nolint:wrapcheck // callers wrap errors
+}
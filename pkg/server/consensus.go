@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/raft"
+	"time"
+)
+
+// kinds of Raft request sent between peers
+const (
+	raftVote     = "vote"
+	raftAppend   = "append"
+	raftSnapshot = "snapshot"
+	raftPropose  = "propose"
+)
+
+var errUnknownRaftRequest = errors.New("unknown raft request")
+
+// raftProposal is a write forwarded by a follower to the leader, and the response once applied, or the
+// reason it failed, if it did.
+type raftProposal struct {
+	Mutation string
+	Timeout  time.Duration
+}
+
+type raftProposalResult struct {
+	Response string
+	Reason   string
+}
+
+// raftTransport sends Raft requests to the other servers' peer ports, over the pooled peer connections.
+type raftTransport struct {
+	pool *peerPool
+}
+
+func (t raftTransport) RequestVote(peer string, request raft.VoteRequest) (raft.VoteResponse, error) {
+	var response raft.VoteResponse
+
+	err := t.call(peer, raftVote, request, &response)
+
+	return response, err
+}
+
+func (t raftTransport) AppendEntries(peer string, request raft.AppendRequest) (raft.AppendResponse, error) {
+	var response raft.AppendResponse
+
+	err := t.call(peer, raftAppend, request, &response)
+
+	return response, err
+}
+
+func (t raftTransport) InstallSnapshot(peer string, request raft.SnapshotRequest) (raft.SnapshotResponse, error) {
+	var response raft.SnapshotResponse
+
+	err := t.call(peer, raftSnapshot, request, &response)
+
+	return response, err
+}
+
+// call sends the request to the peer as JSON, decoding its JSON response.
+func (t raftTransport) call(peer string, kind string, request any, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error encoding raft request: %w", err)
+	}
+
+	value, err := t.pool.get([]string{peer})[0].call("rft"+formatArgument(kind)+formatArgument(string(body)),
+		time.Now())
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(value), response); err != nil {
+		return fmt.Errorf("error decoding raft response: %w", err)
+	}
+
+	return nil
+}
+
+// newRaftNode returns the Raft node of this server, identified by the address peers reach it at,
+// which applies committed writes to the store, and snapshots every key in the store so applied writes
+// can be discarded from the log.
+func (s *Server) newRaftNode(id string) *raft.Node {
+	localStoreChannel, responseChannel := initialiseLocalStoreHandler(s.peerLogger, s.store)
+
+	return raft.NewNode(raft.Config{
+		ID:        id,
+		Peers:     s.config.OtherServers,
+		Transport: raftTransport{s.peerPool},
+		Apply: func(mutation string) string {
+			return applyMutation(s.peerLogger, localStoreChannel, responseChannel, mutation)
+		},
+		Snapshot: s.raftSnapshot,
+		Restore:  s.restoreRaftSnapshot,
+		Logger:   s.peerLogger,
+	})
+}
+
+// applyMutation applies a write committed to the Raft log to the store, returning the store's response,
+// such as the value popped from a list, or whether a conditional write was made.
+func applyMutation(logger *slog.Logger, localStoreChannel chan<- *commandRequest, responseChannel <-chan string,
+	mutation string) string {
+	request, err := parseCommand(mutation)
+	if err != nil || request == nil {
+		logger.Error("unable to apply raft log entry", "command", mutation, "error", err)
+		return formatError(reasonInvalidCommand)
+	}
+
+	localStoreChannel <- request
+
+	return <-responseChannel
+}
+
+// raftSnapshot returns every key in the store, encoded as JSON.
+func (s *Server) raftSnapshot() string {
+	encoded, err := json.Marshal(kvstore.Dump(s.store))
+	if err != nil {
+		// never happens, the records only holding strings, JSON and times
+		s.peerLogger.Error("unable to encode raft snapshot", "error", err)
+	}
+
+	return string(encoded)
+}
+
+// restoreRaftSnapshot replaces every key in the store with those in the leader's snapshot.
+func (s *Server) restoreRaftSnapshot(snapshot string) {
+	var records []kvstore.Record
+
+	if err := json.Unmarshal([]byte(snapshot), &records); err != nil {
+		s.peerLogger.Error("unable to decode raft snapshot", "error", err)
+		return
+	}
+
+	if err := kvstore.Load(s.store, records); err != nil {
+		s.peerLogger.Error("unable to restore raft snapshot", "error", err)
+	}
+}
+
+// handleRaft handles a Raft request from a peer, returning the JSON response.
+func (s *Server) handleRaft(kind string, body string) (string, error) {
+	node := s.raft()
+
+	var response any
+
+	switch kind {
+	case raftVote:
+		var request raft.VoteRequest
+		if err := json.Unmarshal([]byte(body), &request); err != nil {
+			return "", fmt.Errorf("error decoding raft request: %w", err)
+		}
+
+		response = node.HandleRequestVote(request)
+
+	case raftAppend:
+		var request raft.AppendRequest
+		if err := json.Unmarshal([]byte(body), &request); err != nil {
+			return "", fmt.Errorf("error decoding raft request: %w", err)
+		}
+
+		response = node.HandleAppendEntries(request)
+
+	case raftSnapshot:
+		var request raft.SnapshotRequest
+		if err := json.Unmarshal([]byte(body), &request); err != nil {
+			return "", fmt.Errorf("error decoding raft request: %w", err)
+		}
+
+		response = node.HandleInstallSnapshot(request)
+
+	case raftPropose:
+		var request raftProposal
+		if err := json.Unmarshal([]byte(body), &request); err != nil {
+			return "", fmt.Errorf("error decoding raft request: %w", err)
+		}
+
+		applied, reason := s.proposeLocally(request.Mutation, request.Timeout)
+		response = raftProposalResult{applied, reason}
+
+	default:
+		return "", fmt.Errorf("%w: %s", errUnknownRaftRequest, kind)
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return "", fmt.Errorf("error encoding raft response: %w", err)
+	}
+
+	return string(encoded), nil
+}
+
+// propose proposes a client write to the Raft log, forwarding it to the leader if this server isn't,
+// returning the response once applied, and the reason if it failed.
+func (s *Server) propose(mutation string, timeout time.Duration) (string, string) {
+	response, reason := s.proposeLocally(mutation, timeout)
+	if reason != reasonNoLeader {
+		return response, reason
+	}
+
+	leader := s.raft().Status().Leader
+	if leader == "" {
+		return response, reason
+	}
+
+	var result raftProposalResult
+
+	err := raftTransport{s.peerPool}.call(leader, raftPropose, raftProposal{mutation, timeout}, &result)
+
+	switch {
+	case err != nil:
+		s.serverLogger.Warn("unable to forward write to raft leader", "leader", leader, "error", err)
+		return errorResponse, reasonNoLeader
+
+	case result.Reason != "":
+		return errorResponse, result.Reason
+
+	default:
+		return result.Response, ""
+	}
+}
+
+// proposeLocally proposes the write to the Raft log, returning the store's response once applied, and the
+// reason if it failed, no_leader if this server isn't the leader.
+func (s *Server) proposeLocally(mutation string, timeout time.Duration) (string, string) {
+	response, err := s.raft().Propose(mutation, timeout)
+
+	switch {
+	case err == nil:
+		return response, ""
+
+	case errors.Is(err, raft.ErrNotLeader):
+		return errorResponse, reasonNoLeader
+
+	default:
+		s.serverLogger.Warn("raft write not applied", "command", mutation, "error", err)
+		return errorResponse, reasonTimeout
+	}
+}
+
+// raft returns the Raft node of this server, or nil if not in Raft mode or not yet listening.
+func (s *Server) raft() *raft.Node {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.raftNode
+}
+
+// raftInfo returns the Raft status reported by the info command.
+func raftInfo(node *raft.Node) []string {
+	status := node.Status()
+
+	return []string{
+		fmt.Sprintf("raft_state=%s", status.State),
+		fmt.Sprintf("raft_term=%d", status.Term),
+		fmt.Sprintf("raft_leader=%s", status.Leader),
+		fmt.Sprintf("raft_commit_index=%d", status.CommitIndex),
+		fmt.Sprintf("raft_snapshot_index=%d", status.SnapshotIndex),
+	}
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/raft"
+	"testing"
+	"time"
+)
+
+func Test_handle_Raft(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{
+		raft: func(kind string, body string) (string, error) {
+			if kind != raftVote {
+				return "", errUnknownRaftRequest
+			}
+
+			return "{}", nil
+		},
+	})
+
+	checkRequestResponse(t, client, "rft14vote12{}", "val12{}")
+	checkRequestResponse(t, client, "rft13bad12{}", formatError(reasonInvalidCommand))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_Server_Raft(t *testing.T) {
+	servers, stores := startRaftServers(t, 3)
+	client := dialRaftFollower(t, servers)
+
+	checkRequestResponse(t, client, "put11a111", "ack")
+	checkRequestResponse(t, client, "bye", "")
+
+	for i, store := range stores {
+		waitForValue(t, store, "a", "1", fmt.Sprintf("server %d", i))
+	}
+}
+
+func Test_Server_RaftListResponses(t *testing.T) {
+	servers, stores := startRaftServers(t, 3)
+	client := dialRaftFollower(t, servers)
+
+	// the values popped are returned, not just acknowledged
+	checkRequestResponse(t, client, "rpu11l11a", ackResponse)
+	checkRequestResponse(t, client, "rpu11l11b", ackResponse)
+	checkRequestResponse(t, client, "rpu11l11c", ackResponse)
+	checkRequestResponse(t, client, "lpo11l", "val11a")
+	checkRequestResponse(t, client, "rpo11l", "val11c")
+	checkRequestResponse(t, client, "lpo11m", "nil")
+	checkRequestResponse(t, client, "bye", "")
+
+	for i, store := range stores {
+		waitForList(t, store, "l", []string{"b"}, fmt.Sprintf("server %d", i))
+	}
+}
+
+func Test_Server_RaftPutOptions(t *testing.T) {
+	servers, stores := startRaftServers(t, 3)
+	client := dialRaftFollower(t, servers)
+
+	// conditional writes not made answer nil, not ack
+	checkRequestResponse(t, client, "pto11a11b12xx", "nil") // not present
+	checkRequestResponse(t, client, "pto11a11b12nx", ackResponse)
+	checkRequestResponse(t, client, "pto11a11c12nx", "nil") // already present
+	checkRequestResponse(t, client, "pto11a11c12xx", ackResponse)
+	checkRequestResponse(t, client, "prs11a", "nil") // no expiry
+	checkRequestResponse(t, client, "pto11b11x18px=60000", ackResponse)
+	checkRequestResponse(t, client, "prs11b", ackResponse)
+	checkRequestResponse(t, client, "prs11b", "nil") // no longer has an expiry
+	checkRequestResponse(t, client, "bye", "")
+
+	for i, store := range stores {
+		waitForValue(t, store, "a", "c", fmt.Sprintf("server %d", i))
+		waitForValue(t, store, "b", "x", fmt.Sprintf("server %d", i))
+	}
+}
+
+func Test_Server_RaftMutationResponses(t *testing.T) {
+	servers, stores := startRaftServers(t, 3)
+	client := dialRaftFollower(t, servers)
+
+	checkRequestResponse(t, client, "put11s11x", ackResponse)
+
+	// every write answering other than ack answers the same through Raft
+	tests := []struct {
+		command  string
+		response string
+	}{
+		{"hst11s11f11v", formatError(reasonWrongType)},
+		{"hdl11s11f", formatError(reasonWrongType)},
+		{"lpu11s11a", formatError(reasonWrongType)},
+		{"rpu11s11a", formatError(reasonWrongType)},
+		{"lpo11s", formatError(reasonWrongType)},
+		{"rpo11s", formatError(reasonWrongType)},
+		{"ltr11s11012-1", formatError(reasonWrongType)},
+		{"sta11s11a", formatError(reasonWrongType)},
+		{"str11s11a", formatError(reasonWrongType)},
+		{"zad11s11311a", formatError(reasonWrongType)},
+		{"zrm11s11a", formatError(reasonWrongType)},
+		{"hst11h11f11v", ackResponse},
+		{"sbt11h110111", formatError(reasonWrongType)},
+		{"sbt11b110111", "val110"},
+		{"sbt11b110111", "val111"},
+		{"sbt11b110110", "val111"},
+		{"lpo11l", "nil"},
+		{"pto11s11y12nx", "nil"},
+		{"prs11s", "nil"},
+	}
+
+	for _, test := range tests {
+		checkRequestResponse(t, client, test.command, test.response)
+	}
+
+	checkRequestResponse(t, client, "bye", "")
+
+	for i, store := range stores {
+		waitForValue(t, store, "s", "x", fmt.Sprintf("server %d", i))
+	}
+}
+
+func Test_Server_RaftNoLeader(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		OtherServers:       []string{"127.0.0.1:1"},
+		Raft:               true,
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	defer srv.Shutdown(context.Background())
+
+	// not started, so never elected
+	if _, reason := srv.propose("put11a111", time.Second); reason != reasonNoLeader {
+		t.Error("Expected no leader but got: ", reason)
+	}
+
+	if _, err := srv.raft().Propose("put11a111", time.Second); !errors.Is(err, raft.ErrNotLeader) {
+		t.Error("Expected not leader but got: ", err)
+	}
+}
+
+func Test_Server_RaftSnapshot(t *testing.T) {
+	store := kvstore.NewKVStore()
+	defer kvstore.Close(store)
+
+	kvstore.Write(store, "a", "1")
+	_ = store.HashSet("h", "f", "2")
+
+	snapshot := NewServer(store, Config{}).raftSnapshot()
+
+	restored := kvstore.NewKVStore()
+	defer kvstore.Close(restored)
+
+	kvstore.Write(restored, "b", "3")
+	NewServer(restored, Config{}).restoreRaftSnapshot(snapshot)
+
+	if value, _ := kvstore.Read(restored, "a"); value != "1" {
+		t.Error("Expected a restored but got: ", value)
+	}
+
+	if value, _, _ := restored.HashGet("h", "f"); value != "2" {
+		t.Error("Expected h restored but got: ", value)
+	}
+
+	if _, found := kvstore.Read(restored, "b"); found {
+		t.Error("Expected b removed")
+	}
+}
+
+// startRaftServers starts the servers in Raft mode, shut down once the test ends, returning them and their
+// stores once a leader has been elected.
+func startRaftServers(t *testing.T, count int) ([]*Server, []*kvstore.KVStore) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	var peers []string
+
+	for i := 0; i < count; i++ {
+		peers = append(peers, unixScheme+filepath.Join(dir, fmt.Sprintf("peer%d.sock", i)))
+	}
+
+	servers := make([]*Server, 0, len(peers))
+	stores := make([]*kvstore.KVStore, 0, len(peers))
+
+	for i, peer := range peers {
+		others := append(append([]string(nil), peers[:i]...), peers[i+1:]...)
+		store := kvstore.NewKVStore()
+
+		srv := NewServer(store, Config{
+			ServerHostnamePort: "127.0.0.1:0",
+			PeerHostnamePort:   peer,
+			OtherServers:       others,
+			Raft:               true,
+			NodeID:             peer,
+		})
+
+		if err := srv.Listen(); err != nil {
+			t.Fatal("Unable to listen: ", err)
+		}
+
+		go func() {
+			_ = srv.Serve()
+		}()
+
+		t.Cleanup(func() {
+			_ = srv.Shutdown(context.Background())
+		})
+
+		servers = append(servers, srv)
+		stores = append(stores, store)
+	}
+
+	waitForRaftLeader(t, servers)
+
+	return servers, stores
+}
+
+// dialRaftFollower connects to a follower, which forwards writes to the leader.
+func dialRaftFollower(t *testing.T, servers []*Server) net.Conn {
+	t.Helper()
+
+	follower := servers[(waitForRaftLeader(t, servers)+1)%len(servers)]
+
+	client, err := net.Dial("tcp4", follower.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	return client
+}
+
+// waitForList waits for the store to hold the list, failing the test if it doesn't in time.
+func waitForList(t *testing.T, store *kvstore.KVStore, key string, expected []string, description string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		elements, err := store.ListRange(key, 0, -1)
+		if err == nil && reflect.DeepEqual(elements, expected) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s to hold %v on %s but got %v, %v", key, expected, description, elements, err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForRaftLeader returns the index of the server elected leader, once every server knows it.
+func waitForRaftLeader(t *testing.T, servers []*Server) int {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for time.Now().Before(deadline) {
+		leaders := make(map[string]bool)
+		leader := -1
+
+		for i, srv := range servers {
+			status := srv.raft().Status()
+			leaders[status.Leader] = true
+
+			if status.State == raft.Leader {
+				leader = i
+			}
+		}
+
+		if leader >= 0 && len(leaders) == 1 {
+			return leader
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("No leader elected")
+
+	return -1
+}
+
+func waitForValue(t *testing.T, store *kvstore.KVStore, key string, expected string, description string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		value, ok := kvstore.Read(store, key)
+		if ok && value == expected {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s to have %s=%s but got %q", description, key, expected, value)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"tcp/pkg/kvstore"
+	"time"
+)
+
+// crdtState is the value of a key holding a conflict-free replicated data type, which servers merge
+// deterministically, so updates made concurrently on different servers never conflict.
+type crdtState struct {
+	Counter *pnCounter `json:",omitempty"`
+	Set     *orSet     `json:",omitempty"`
+}
+
+// pnCounter is a counter incremented (P) and decremented (N) separately by each server, its value being
+// the total increments less the total decrements, merged by taking the highest of each server's totals.
+// Only ever incrementing it makes it a grow-only counter.
+type pnCounter struct {
+	P map[string]int64
+	N map[string]int64
+}
+
+// orSet is an observed-remove set: each time an element is added it is tagged uniquely, and removing
+// an element only removes the tags observed, so an add made concurrently with a remove wins.
+type orSet struct {
+	// the tags of each element added
+	Adds map[string]map[string]bool
+
+	// the tags removed
+	Removed map[string]bool
+}
+
+// crdtStore applies updates to the keys holding conflict-free replicated data types, and merges the
+// states replicated by peers, one at a time so concurrent updates aren't lost.
+type crdtStore struct {
+	node  string
+	store *kvstore.KVStore
+
+	mutex    sync.Mutex
+	sequence int64
+}
+
+// newCRDTStore returns the store of this server (node), with the tags of added elements starting from
+// when it started, so a restarted server's tags differ from before.
+func newCRDTStore(node string, store *kvstore.KVStore, now time.Time) *crdtStore {
+	return &crdtStore{node: node, store: store, sequence: now.UnixNano()}
+}
+
+// isCRDTUpdate returns whether the command updates a conflict-free replicated data type.
+func isCRDTUpdate(request *commandRequest) bool {
+	switch request.command {
+	case incrementCommand, setAddCommand, setRemoveCommand:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// isCRDTCommand returns whether the command reads or writes a conflict-free replicated data type.
+func isCRDTCommand(request *commandRequest) bool {
+	switch request.command {
+	case counterCommand, setMembersCommand, crdtMergeCommand:
+		return true
+
+	default:
+		return isCRDTUpdate(request)
+	}
+}
+
+// value returns the counter's value.
+func (c *pnCounter) value() int64 {
+	var total int64
+
+	for _, count := range c.P {
+		total += count
+	}
+
+	for _, count := range c.N {
+		total -= count
+	}
+
+	return total
+}
+
+// add adds the delta, which can be negative, to the counter on the server.
+func (c *pnCounter) add(node string, delta int64) {
+	if delta >= 0 {
+		c.P[node] += delta
+	} else {
+		c.N[node] -= delta
+	}
+}
+
+func (c *pnCounter) merge(other *pnCounter) {
+	for node, count := range other.P {
+		c.P[node] = max(c.P[node], count)
+	}
+
+	for node, count := range other.N {
+		c.N[node] = max(c.N[node], count)
+	}
+}
+
+// members returns the elements in the set, sorted.
+func (s *orSet) members() []string {
+	members := make([]string, 0, len(s.Adds))
+
+	for element, tags := range s.Adds {
+		for tag := range tags {
+			if !s.Removed[tag] {
+				members = append(members, element)
+				break
+			}
+		}
+	}
+
+	sort.Strings(members)
+
+	return members
+}
+
+func (s *orSet) add(element string, tag string) {
+	if s.Adds[element] == nil {
+		s.Adds[element] = make(map[string]bool)
+	}
+
+	s.Adds[element][tag] = true
+}
+
+// remove removes every tag of the element observed.
+func (s *orSet) remove(element string) {
+	for tag := range s.Adds[element] {
+		s.Removed[tag] = true
+	}
+}
+
+func (s *orSet) merge(other *orSet) {
+	for element, tags := range other.Adds {
+		for tag := range tags {
+			s.add(element, tag)
+		}
+	}
+
+	for tag := range other.Removed {
+		s.Removed[tag] = true
+	}
+}
+
+// merge merges the other state of the key into this one, returning the reason if they are different types.
+func (c *crdtState) merge(other *crdtState) string {
+	switch {
+	case c.Counter != nil && other.Counter != nil:
+		c.Counter.merge(other.Counter)
+
+	case c.Set != nil && other.Set != nil:
+		c.Set.merge(other.Set)
+
+	default:
+		return reasonWrongType
+	}
+
+	return ""
+}
+
+// read returns the state of the key, if it has one, and the reason if it doesn't hold a conflict-free
+// replicated data type.
+func (c *crdtStore) read(key string) (*crdtState, bool, string) {
+	value, found := kvstore.Read(c.store, key)
+	if !found {
+		return nil, false, ""
+	}
+
+	state, valid := decodeCRDTState(value)
+	if !valid {
+		return nil, false, reasonWrongType
+	}
+
+	return state, true, ""
+}
+
+// decodeCRDTState returns the encoded state, and whether it is valid: either a counter or a set.
+func decodeCRDTState(encoded string) (*crdtState, bool) {
+	state := &crdtState{}
+
+	if err := json.Unmarshal([]byte(encoded), state); err != nil || (state.Counter == nil) == (state.Set == nil) {
+		return nil, false
+	}
+
+	// empty maps may have been encoded as null
+	if state.Counter != nil {
+		state.Counter.P = initialised(state.Counter.P)
+		state.Counter.N = initialised(state.Counter.N)
+	} else {
+		state.Set.Adds = initialised(state.Set.Adds)
+		state.Set.Removed = initialised(state.Set.Removed)
+	}
+
+	return state, true
+}
+
+// initialised returns the map, or an empty map if it is nil.
+func initialised[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return make(map[string]V)
+	}
+
+	return m
+}
+
+// write sets the state of the key.
+func (c *crdtStore) write(key string, state *crdtState) (string, error) {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("error encoding state: %w", err)
+	}
+
+	kvstore.Write(c.store, key, string(encoded))
+
+	return string(encoded), nil
+}
+
+// update applies the update to its key's state on this server, returning the new state (encoded) to
+// replicate, the response, and the reason if it failed.
+func (c *crdtStore) update(command *commandRequest) (string, string, string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, found, reason := c.read(command.key)
+	if reason != "" {
+		return "", errorResponse, reason
+	}
+
+	if !found {
+		state = newCRDTState(command)
+	}
+
+	var response string
+
+	switch {
+	case command.command == incrementCommand && state.Counter != nil:
+		state.Counter.add(c.node, int64(command.length))
+		response = "val" + formatArgument(strconv.FormatInt(state.Counter.value(), 10))
+
+	case command.command == setAddCommand && state.Set != nil:
+		c.sequence++
+		state.Set.add(command.value, c.node+"#"+strconv.FormatInt(c.sequence, 10))
+		response = ackResponse
+
+	case command.command == setRemoveCommand && state.Set != nil:
+		state.Set.remove(command.value)
+		response = ackResponse
+
+	default:
+		return "", errorResponse, reasonWrongType
+	}
+
+	encoded, err := c.write(command.key, state)
+	if err != nil {
+		return "", errorResponse, reasonInvalidCommand
+	}
+
+	return encoded, response, ""
+}
+
+// newCRDTState returns the empty state of the type the command updates.
+func newCRDTState(command *commandRequest) *crdtState {
+	if command.command == incrementCommand {
+		return &crdtState{Counter: &pnCounter{P: map[string]int64{}, N: map[string]int64{}}}
+	}
+
+	return &crdtState{Set: &orSet{Adds: map[string]map[string]bool{}, Removed: map[string]bool{}}}
+}
+
+// merge merges the state replicated by a peer into the key's state, returning the reason if it failed.
+func (c *crdtStore) merge(key string, encoded string) string {
+	replicated, valid := decodeCRDTState(encoded)
+	if !valid {
+		return reasonInvalidCommand
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, found, reason := c.read(key)
+
+	switch {
+	case reason != "":
+		return reason
+
+	case found:
+		if reason := state.merge(replicated); reason != "" {
+			return reason
+		}
+
+	default:
+		state = replicated
+	}
+
+	if _, err := c.write(key, state); err != nil {
+		return reasonInvalidCommand
+	}
+
+	return ""
+}
+
+// readCRDT returns the value of a counter, or the members of a set, returning the response and the
+// reason if it failed.
+func (s *session) readCRDT(command *commandRequest) (string, string) {
+	state, found, reason := s.config.crdts.read(command.key)
+
+	switch {
+	case reason != "":
+		return errorResponse, reason
+
+	case command.command == counterCommand && !found:
+		return "nil", ""
+
+	case command.command == counterCommand && state.Counter != nil:
+		return "val" + formatArgument(strconv.FormatInt(state.Counter.value(), 10)), ""
+
+	case command.command == setMembersCommand && !found:
+		return listResponse(nil), ""
+
+	case command.command == setMembersCommand && state.Set != nil:
+		return listResponse(state.Set.members()), ""
+
+	default:
+		return errorResponse, reasonWrongType
+	}
+}
+
+// updateCRDT applies the update to its key's state on this server, then replicates the new state,
+// which the peers merge with theirs, returning the response and the reason if it failed.
+func (s *session) updateCRDT(command *commandRequest, prefixes commandPrefixes,
+	timing *commandTiming) (string, string) {
+	state, response, reason := s.config.crdts.update(command)
+	if reason != "" {
+		s.logger.Info("rejecting update", "command", command.originalText, "reason", reason)
+		return response, reason
+	}
+
+	merge := &commandRequest{command: crdtMergeCommand, key: command.key, value: state,
+		originalText: "crm" + formatArgument(command.key) + formatArgument(state)}
+
+	// merging the state here again changes nothing
+	if mergeResponse, reason := s.perform(s.prefixed(merge, prefixes), timing); mergeResponse != ackResponse {
+		return mergeResponse, reason
+	}
+
+	return response, ""
+}
+
+// mergeReplicated passes the requests sent on the returned channel to the local store, except for the
+// states replicated by peers, which are merged with the key's state here.
+func (c *crdtStore) mergeReplicated(logger *slog.Logger, localStoreChannel chan<- *commandRequest,
+	responseChannel <-chan string) (chan<- *commandRequest, <-chan string) {
+	requests := make(chan *commandRequest)
+	responses := make(chan string)
+
+	go func() {
+		for request := range requests {
+			if request.command != crdtMergeCommand {
+				localStoreChannel <- request
+				responses <- <-responseChannel
+
+				if request.command == closeCommand {
+					return
+				}
+
+				continue
+			}
+
+			response := ackResponse
+
+			if reason := c.merge(request.key, request.value); reason != "" {
+				logger.Warn("unable to merge replicated state", "key", request.key, "reason", reason)
+
+				response = errorResponse
+			}
+
+			responses <- response
+		}
+	}()
+
+	return requests, responses
+}
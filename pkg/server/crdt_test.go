@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_pnCounter_merge(t *testing.T) {
+	a := &pnCounter{P: map[string]int64{}, N: map[string]int64{}}
+	b := &pnCounter{P: map[string]int64{}, N: map[string]int64{}}
+
+	// concurrent updates on different servers
+	a.add("a", 5)
+	a.add("a", -2)
+	b.add("b", 4)
+
+	a.merge(b)
+	b.merge(a)
+
+	if a.value() != 7 || b.value() != 7 {
+		t.Errorf("Expected both counters to be 7 but got %d and %d", a.value(), b.value())
+	}
+
+	// merging again changes nothing
+	a.merge(b)
+
+	if a.value() != 7 {
+		t.Error("Expected counter to be 7 but got: ", a.value())
+	}
+}
+
+func Test_orSet_merge(t *testing.T) {
+	a := &orSet{Adds: map[string]map[string]bool{}, Removed: map[string]bool{}}
+	b := &orSet{Adds: map[string]map[string]bool{}, Removed: map[string]bool{}}
+
+	a.add("x", "a#1")
+	a.add("y", "a#2")
+	b.merge(a)
+
+	// x removed on one server while added again on the other, y removed
+	a.remove("x")
+	b.add("x", "b#1")
+	b.remove("y")
+
+	a.merge(b)
+	b.merge(a)
+
+	if members := fmt.Sprint(a.members()); members != "[x]" || fmt.Sprint(b.members()) != members {
+		t.Errorf("Expected both sets to be [x] but got %v and %v", a.members(), b.members())
+	}
+}
+
+func Test_handle_CRDT(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+	server3, peer3 := net.Pipe()
+	store := kvstore.NewKVStore()
+	crdts := newCRDTStore("a", store, time.Unix(0, 0))
+
+	// client connection, replicating to a peer
+	go handle(testLogger, newConnection(server1), store, connectedPeers(peer2), &handlerConfig{crdts: crdts})
+
+	// peer connection, merging the states replicated by another server
+	go handle(testLogger, newConnection(server3), store, nil, &handlerConfig{crdts: crdts, bareErrors: true})
+
+	// the new state is replicated
+	write(t, client, "inc13cnt115")
+	read(t, server2, "crm13cnt"+formatArgument(`{"Counter":{"P":{"a":5},"N":{}}}`))
+	write(t, server2, "ack")
+	read(t, client, "val115")
+
+	checkRequestResponse(t, peer3, "crm13cnt"+formatArgument(`{"Counter":{"P":{"a":1,"b":3},"N":{"b":1}}}`), "ack")
+	checkRequestResponse(t, client, "cnt13cnt", "val117")
+	checkRequestResponse(t, client, "cnt15other", "nil")
+
+	write(t, client, "sad13set11x")
+	read(t, server2, "crm13set"+formatArgument(`{"Set":{"Adds":{"x":{"a#1":true}},"Removed":{}}}`))
+	write(t, server2, "ack")
+	read(t, client, "ack")
+
+	checkRequestResponse(t, peer3, "crm13set"+formatArgument(`{"Set":{"Adds":{"x":{"b#1":true},"y":{"b#2":true}},`+
+		`"Removed":{"b#2":true}}}`), "ack")
+	checkRequestResponse(t, client, "smb13set", listResponse([]string{"x"}))
+
+	// only the tags observed are removed
+	checkRequestResponse(t, peer3, "crm13set"+formatArgument(`{"Set":{"Adds":{},"Removed":{"a#1":true}}}`), "ack")
+	checkRequestResponse(t, client, "smb13set", listResponse([]string{"x"}))
+
+	// keys holding other types
+	checkRequestResponse(t, client, "inc13set111", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "smb13cnt", formatError(reasonWrongType))
+	checkRequestResponse(t, peer3, "crm13cnt"+formatArgument(`{"Set":{}}`), "err")
+	checkRequestResponse(t, client, "get13cnt0", "val"+formatArgument(`{"Counter":{"P":{"a":5,"b":3},"N":{"b":1}}}`))
+
+	checkRequestResponse(t, client, "bye", "")
+	checkRequestResponse(t, peer3, "bye", "")
+}
+
+func Test_handle_CRDTDisabled(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "inc13cnt115", formatError(reasonCommandDisabled))
+	checkRequestResponse(t, client, "bye", "")
+}
@@ -0,0 +1,17 @@
+package server
+
+import "errors"
+
+// Durabilities a client can request for a write, by sending the durability command before it,
+// overriding the replication mode for that write. Ignored in Raft mode and for writes forwarded to
+// the servers holding their key.
+const (
+	// acknowledged once applied locally, still replicated to the peers
+	durabilityLocal = "local"
+
+	// acknowledged once applied by the peers, as in sync replication mode, even in async mode (where
+	// earlier writes still queued may be applied by the peers after it)
+	durabilityReplicated = "replicated"
+)
+
+var errUnknownDurability = errors.New("unknown durability")
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_DurabilityLocal(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2), &handlerConfig{})
+
+	// acknowledged before the peer applies it
+	write(t, client, "dur15localput11a111")
+	read(t, client, "ack")
+	read(t, server2, "put11a111")
+	write(t, server2, "ack")
+
+	// only applies to the next write
+	checkDistributedRequestResponse(t, client, "put11a112", []net.Conn{server2}, "ack")
+
+	checkRequestResponse(t, client, "dur14fast", formatError(reasonInvalidCommand))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_DurabilityReplicated(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+	done := make(chan struct{})
+
+	defer close(done)
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2),
+		&handlerConfig{replicator: newAsyncReplicator(testLogger, 0, done)})
+
+	// acknowledged once the peer applies it, despite async replication
+	write(t, client, "dur210replicatedput11a111")
+	read(t, server2, "put11a111")
+	write(t, server2, "ack")
+	read(t, client, "ack")
+
+	checkRequestResponse(t, client, "bye", "")
+}
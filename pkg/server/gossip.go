@@ -0,0 +1,256 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how often each server gossips, if not configured
+	defaultGossipInterval = time.Second
+
+	// how many gossip intervals without a newer heartbeat before a member is considered to have failed,
+	// and how many more before it is forgotten
+	gossipFailureIntervals = 10
+	gossipForgetIntervals  = 30
+)
+
+var errInvalidGossip = errors.New("invalid gossip")
+
+// member is a server in the cluster, as known from gossip.
+type member struct {
+	// incremented by the server every gossip interval, so newer information replaces older, starting
+	// from when the server started, so a restarted server's heartbeats are newer than before
+	heartbeat int64
+
+	// whether the server has announced it is leaving the cluster
+	left bool
+
+	// when the heartbeat last increased
+	updated time.Time
+}
+
+// membership tracks the servers in the cluster by gossip: each server periodically sends what it knows
+// (every member and its heartbeat) to another, which merges it with its own, so membership changes
+// spread through the cluster without every server being reconfigured.
+type membership struct {
+	self  string
+	seeds []string
+
+	// how long without a newer heartbeat before a member is considered to have failed
+	failAfter time.Duration
+
+	mutex   sync.Mutex
+	members map[string]*member
+}
+
+// newMembership returns the membership of this server (self), which initially knows no other members,
+// so gossips with the seeds until it does.
+func newMembership(self string, seeds []string, interval time.Duration, now time.Time) *membership {
+	return &membership{
+		self:      self,
+		seeds:     seeds,
+		failAfter: gossipFailureIntervals * interval,
+		members:   map[string]*member{self: {heartbeat: now.UnixNano(), updated: now}},
+	}
+}
+
+// beat increments this server's heartbeat, and forgets members not heard from for a long time.
+func (m *membership) beat(now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.members[m.self].heartbeat++
+	m.members[m.self].updated = now
+
+	for address, member := range m.members {
+		if address != m.self && now.Sub(member.updated) > m.failAfter*gossipForgetIntervals/gossipFailureIntervals {
+			delete(m.members, address)
+		}
+	}
+}
+
+// leave announces that this server is leaving the cluster, the next time it gossips.
+func (m *membership) leave() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.members[m.self].heartbeat++
+	m.members[m.self].left = true
+}
+
+// encode returns what this server knows, one line per member with its address, heartbeat, and
+// whether it has left.
+func (m *membership) encode() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	lines := make([]string, 0, len(m.members))
+
+	for address, member := range m.members {
+		lines = append(lines, fmt.Sprintf("%s %d %t", address, member.heartbeat, member.left))
+	}
+
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n")
+}
+
+// merge updates the members from what another server knows, keeping the newest heartbeat of each.
+func (m *membership) merge(gossip string, now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, line := range strings.Split(gossip, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("%w: %s", errInvalidGossip, line)
+		}
+
+		heartbeat, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errInvalidGossip, line)
+		}
+
+		address, left := fields[0], fields[2] == "true"
+
+		// only this server knows its own heartbeat
+		if address == m.self {
+			continue
+		}
+
+		if known, found := m.members[address]; !found || heartbeat > known.heartbeat {
+			m.members[address] = &member{heartbeat, left, now}
+		}
+	}
+
+	return nil
+}
+
+// peers returns the other members that haven't left or failed, sorted.
+func (m *membership) peers(now time.Time) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var peers []string
+
+	for address, member := range m.members {
+		if address != m.self && !member.left && now.Sub(member.updated) <= m.failAfter {
+			peers = append(peers, address)
+		}
+	}
+
+	sort.Strings(peers)
+
+	return peers
+}
+
+// target returns a random peer to gossip with, or a random seed if there are no peers, or "" if neither.
+func (m *membership) target(now time.Time) string {
+	candidates := m.peers(now)
+
+	if len(candidates) == 0 {
+		for _, seed := range m.seeds {
+			if seed != m.self {
+				candidates = append(candidates, seed)
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	//nolint:gosec // choosing a peer doesn't need a secure random number
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// gossipInterval returns how often the server gossips.
+func (s *Server) gossipInterval() time.Duration {
+	if s.config.GossipInterval > 0 {
+		return s.config.GossipInterval
+	}
+
+	return defaultGossipInterval
+}
+
+// handleGossip merges the gossip from a peer, replying with what this server knows.
+func (s *Server) handleGossip(gossip string) (string, error) {
+	now := time.Now()
+
+	if err := s.membership.merge(gossip, now); err != nil {
+		return "", err
+	}
+
+	s.updateMembers(now)
+
+	return s.membership.encode(), nil
+}
+
+// gossip exchanges what this server knows with another member every interval, until shut down.
+func (s *Server) gossip(logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.membership.beat(now)
+			s.gossipWith(logger, s.membership.target(now), now)
+			s.updateMembers(now)
+
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// gossipWith exchanges what this server knows with the peer.
+func (s *Server) gossipWith(logger *slog.Logger, peer string, now time.Time) {
+	if peer == "" {
+		return
+	}
+
+	gossip, err := s.peerPool.get([]string{peer})[0].call("gsp"+formatArgument(s.membership.encode()), now)
+	if err != nil {
+		logger.Debug("unable to gossip", "peer", peer, "error", err)
+		return
+	}
+
+	if err := s.membership.merge(gossip, now); err != nil {
+		logger.Warn("invalid gossip", "peer", peer, "error", err)
+	}
+}
+
+// leaveCluster tells every peer this server is leaving, so they stop replicating to it.
+func (s *Server) leaveCluster(logger *slog.Logger) {
+	s.membership.leave()
+
+	now := time.Now()
+
+	for _, peer := range s.membership.peers(now) {
+		s.gossipWith(logger, peer, now)
+	}
+}
+
+// updateMembers replicates to the members found by gossip, if they have changed.
+func (s *Server) updateMembers(now time.Time) {
+	peers := s.membership.peers(now)
+	current := append([]string(nil), s.peerList()...)
+
+	sort.Strings(current)
+
+	if strings.Join(peers, ",") == strings.Join(current, ",") {
+		return
+	}
+
+	s.serverLogger.Info("cluster membership changed", "peers", peers)
+	s.setOtherServers(peers)
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_membership_merge(t *testing.T) {
+	now := time.Now()
+	m := newMembership("a", nil, time.Second, now)
+
+	if err := m.merge("a 1 false\nb 5 false\nc 3 false", now); err != nil {
+		t.Fatal("Expected merged but got: ", err)
+	}
+
+	if peers := m.peers(now); fmt.Sprint(peers) != "[b c]" {
+		t.Error("Expected peers b and c but got: ", peers)
+	}
+
+	// older heartbeats are ignored, newer ones replace what is known
+	if err := m.merge("b 4 true\nc 4 true", now); err != nil {
+		t.Fatal("Expected merged but got: ", err)
+	}
+
+	if peers := m.peers(now); fmt.Sprint(peers) != "[b]" {
+		t.Error("Expected peer b but got: ", peers)
+	}
+
+	// members not heard from are considered to have failed
+	if peers := m.peers(now.Add(11 * time.Second)); len(peers) != 0 {
+		t.Error("Expected no peers but got: ", peers)
+	}
+
+	if err := m.merge("b five false", now); err == nil {
+		t.Error("Expected error but got merged")
+	}
+}
+
+func Test_membership_encode(t *testing.T) {
+	now := time.Unix(0, 100)
+	m := newMembership("a", nil, time.Second, now)
+
+	m.beat(now)
+	m.leave()
+
+	if err := m.merge("b 5 false", now); err != nil {
+		t.Fatal("Expected merged but got: ", err)
+	}
+
+	if encoded := m.encode(); encoded != "a 102 true\nb 5 false" {
+		t.Error("Wrong encoding: ", encoded)
+	}
+}
+
+func Test_Server_Gossip(t *testing.T) {
+	dir := t.TempDir()
+
+	var peers []string
+
+	for i := 0; i < 3; i++ {
+		peers = append(peers, unixScheme+filepath.Join(dir, fmt.Sprintf("peer%d.sock", i)))
+	}
+
+	servers := make([]*Server, 0, len(peers))
+
+	for _, peer := range peers {
+		// every server only knows the first
+		srv := NewServer(kvstore.NewKVStore(), Config{
+			ServerHostnamePort: "127.0.0.1:0",
+			PeerHostnamePort:   peer,
+			Seeds:              peers[:1],
+			GossipInterval:     10 * time.Millisecond,
+		})
+
+		if err := srv.Listen(); err != nil {
+			t.Fatal("Unable to listen: ", err)
+		}
+
+		go func() {
+			_ = srv.Serve()
+		}()
+
+		servers = append(servers, srv)
+	}
+
+	// the last is shut down by the test
+	defer servers[0].Shutdown(context.Background())
+	defer servers[1].Shutdown(context.Background())
+
+	// every server finds the others
+	for i, srv := range servers {
+		others := append(append([]string(nil), peers[:i]...), peers[i+1:]...)
+		waitForPeers(t, srv, others)
+	}
+
+	// the others stop replicating to a server once it leaves
+	if err := servers[2].Shutdown(context.Background()); err != nil {
+		t.Fatal("Unable to shut down: ", err)
+	}
+
+	waitForPeers(t, servers[0], peers[1:2])
+	waitForPeers(t, servers[1], peers[:1])
+}
+
+func waitForPeers(t *testing.T, srv *Server, expected []string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		peers := strings.Join(srv.peerList(), ",")
+
+		if peers == strings.Join(expected, ",") {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected peers %v but got %s", expected, peers)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
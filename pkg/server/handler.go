@@ -2,76 +2,878 @@
 package server
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
+	"strings"
 	"tcp/pkg/kvstore"
+	"tcp/pkg/version"
 	"time"
 )
 
+var (
+	errPeerAuthFailed     = errors.New("peer rejected authentication")
+	errUnexpectedResponse = errors.New("unexpected response")
+)
+
 const (
-	commandTimeout = 500 * time.Millisecond
+	readBufferSize = 4096
 	closeRequest   = "bye"
 	ackResponse    = "ack"
 	errorResponse  = "err"
+
+	// write applied locally only, since peers were unreachable
+	warningResponse = "wrn"
+
+	// connection refused, since the server has too many connections
+	busyResponse = "bsy"
+
+	// command rejected, since the client has exceeded its rate limit
+	throttleResponse = "thr"
+
+	// response to a ping, used to health check connections
+	pongResponse = "pong"
 )
 
-func handle(logger *log.Logger, clientConn io.ReadWriteCloser, store *kvstore.KVStore, serverConns []net.Conn) {
-	logger.Print("opened new client connection")
+// handlerConfig holds the settings applied to every connection accepted by a listener.
+type handlerConfig struct {
+	// peer hostnames and ports of the other servers to replicate commands to
+	otherServers []string
 
-	defer func() {
-		_ = clientConn.Close()
+	// how long a connection can be idle before it is closed, if not zero
+	idleTimeout time.Duration
 
-		for _, serverConn := range serverConns {
-			_ = serverConn.Close()
-		}
+	// maximum number of concurrent connections, if not zero
+	maxConnections int
+
+	// if not nil, connections must first send an auth command with a valid user token before
+	// any data commands are accepted, and are then restricted to what that user is permitted
+	acl *ACL
+
+	// if not nil, records the timing breakdown of a sample of the commands performed
+	sampler *Sampler
+
+	// how writes are handled when every peer is unreachable
+	outagePolicy OutagePolicy
+
+	// writes queued for unreachable peers, when using the handoff policy
+	handoff *handoffQueue
+
+	// if not nil, writes are acknowledged once applied locally, then replicated to the peers in the background
+	replicator *asyncReplicator
+
+	// number of servers, including this one, that must apply a write before it is acknowledged,
+	// WriteQuorumAll for every server, or zero to wait for every reachable peer without failing
+	writeQuorum int
+
+	// if not nil, limits the rate of commands from each client IP address
+	rateLimiter *rateLimiter
+
+	// default time to live of keys in each namespace, applied to puts without a TTL
+	namespaceTTLs NamespaceTTLs
+
+	// if not nil, counts the commands handled
+	commands *commandCounter
+
+	// if not nil, returns the status reported by the info command
+	info func() string
+
+	// if not nil, returns how keys are assigned to servers, reported by the topology command
+	topology func() string
+
+	// if not nil, remembers the responses to writes sent with idempotency keys
+	idempotency *idempotencyCache
+
+	// whether error responses are a bare err, without a code or reason
+	bareErrors bool
+
+	// whether connections are from peers, which alone may prefix writes with the timestamp or origin they
+	// were made with
+	peer bool
+
+	// how long commands wait for the local store and every peer to respond (defaultCommandTimeout if zero),
+	// unless overridden for the command
+	commandTimeout  time.Duration
+	commandTimeouts CommandTimeouts
+
+	// if not nil, records every command
+	accessLog *AccessLog
+
+	// whether the flush all command may be used, always allowed from peers so flushes are replicated
+	allowFlushAll bool
+
+	// if not nil, keeps the most recent slow commands, reported by the slow log command
+	slowLog *slowLog
+
+	// applied to every connection accepted
+	tcpOptions TCPOptions
+
+	// most unparsed input, and invalid commands in a row, allowed before the connection is closed
+	// (defaults if zero)
+	maxCommandSize    int
+	maxProtocolErrors int
+
+	// if not nil, counts the keys read, reported by the hot keys command
+	hotKeys *hotKeys
+
+	// if not nil, sends the changes to each key to the connections watching it
+	watches *watchHub
+
+	// if not nil, reports the data that would move if a change was made to the cluster
+	whatIf func(change string, server string) (string, error)
+
+	// if not nil, describes every client connection, and closes the client connection with an id
+	clients    func() []string
+	killClient func(id int) bool
+
+	// if not nil, asks for the whole server to be shut down
+	shutdown func()
+
+	// if not nil, returns up to limit keys after the key specified, and their values, reported by the
+	// scan command
+	scan func(after string, limit int) []kvstore.Entry
+
+	// if not nil, returns every key and its value, for peers syncing their state, or a chunk of them
+	snapshot      func() []string
+	snapshotChunk func(after string, limit int) []string
+
+	// if not nil, keys are only held by the servers the ring assigns them to, with commands for keys
+	// this server doesn't hold forwarded to those servers
+	ring *hashRing
+
+	// if not nil, handles the Raft requests from peers, and proposes writes to the Raft log
+	// (waiting up to the timeout for them to be applied) instead of replicating them to the peers
+	raft    func(kind string, body string) (string, error)
+	propose func(mutation string, timeout time.Duration) (string, string)
+
+	// if not nil, merges the members of the cluster gossiped by a peer, returning those known here
+	gossip func(gossip string) (string, error)
+
+	// if not nil, handles the anti-entropy requests from peers comparing their keys with this server's
+	antiEntropy func(kind string, body string) (string, error)
+
+	// whether this is a read replica, rejecting client writes with a redirect to the primary's
+	// client address (if not empty)
+	replica bool
+	primary string
+
+	// if not nil, keys can hold conflict-free replicated data types
+	crdts *crdtStore
+
+	// if not nil, writes are tagged with the server they were made on, and dropped if seen before
+	origins *originTracker
+
+	// if not nil, writes are timestamped, and only applied if later than the last write to their key
+	lww *lastWriteWins
+
+	// if not nil, the client addresses of the other servers, by peer address, which clients are redirected
+	// to rather than commands being forwarded when sharding, or when shutting down
+	clientAddresses ClientAddresses
+
+	// if not nil, returns whether the server is shutting down
+	shuttingDown func() bool
+
+	// if not nil, returns whether writes are rejected, and turns read-only mode on or off
+	readOnly    func() bool
+	setReadOnly func(readOnly bool)
+
+	// features enabled, reported by the version command
+	features []string
+}
+
+// commandPrefixes are sent before a write, and apply to it.
+type commandPrefixes struct {
+	idempotencyKey string
+	timestamp      string
+	durability     string
+
+	// the server the write was made on, and its sequence number there
+	origin   string
+	sequence string
+}
+
+// session holds the state of a single connection being handled.
+type session struct {
+	logger *slog.Logger
+	conn   *connection
+	config *handlerConfig
+
+	// authenticated user, if any
+	user *User
+
+	// other servers to replicate to, with the channel of each used to replicate a command
+	peers []*pooledPeer
+
+	// sent for the next command, if any
+	prefixes commandPrefixes
+
+	// requested for the write being handled, if any
+	durability string
+
+	// number of invalid commands sent in a row
+	protocolErrors int
+
+	// whether applying a batch of writes from a peer, and the response to each so far
+	batching       bool
+	batchResponses []string
+
+	// sends the changes to the keys the connection watches, and every response once watching, if any
+	watcher *watcher
+
+	localStoreChannel chan<- *commandRequest
+	responseChannel   <-chan string
+	peerChannels      []chan<- *commandRequest
+	ackChannel        <-chan peerAck
+
+	// whether the close command has been sent to the local store and replication go routines, which then exit
+	handlersClosed bool
+}
+
+// handle processes commands from a single connection, replicating changes to the other
+// servers (if any), until the connection is closed by either side. Changes can't be replicated
+// to servers that are unreachable at the time, and are handled according to the outage policy.
+func handle(logger *slog.Logger, conn *connection, store *kvstore.KVStore, peers []*pooledPeer,
+	config *handlerConfig) {
+	logger = logger.With("remote", conn.remoteAddr())
+	logger.Info("opened new connection")
+
+	defer func() {
+		_ = conn.Close()
 	}()
 
-	var buffer string
+	var parser commandParser
 
-	localStoreChannel, responseChannel := initialiseLocalStoreHandler(logger, store)
-	peerChannels, ackChannel := initialiseReplicationHandler(logger, serverConns)
+	reader := bufio.NewReaderSize(conn, readBufferSize)
+
+	s := &session{logger: logger, conn: conn, config: config, peers: peers}
+	defer s.stopWatching()
+	s.localStoreChannel, s.responseChannel = initialiseLocalStoreHandler(logger, store)
+	s.peerChannels, s.ackChannel = initialiseReplicationHandler(logger, peers)
+
+	if config.lww != nil {
+		s.localStoreChannel, s.responseChannel = config.lww.resolveConflicts(logger, s.localStoreChannel,
+			s.responseChannel)
+	}
+
+	if config.crdts != nil {
+		s.localStoreChannel, s.responseChannel = config.crdts.mergeReplicated(logger, s.localStoreChannel,
+			s.responseChannel)
+	}
+
+	// however the connection ends, unless by the close command
+	defer s.closeHandlers()
 
 	for {
-		input, err := reliableRead(clientConn, 1)
-		if err != nil {
-			if errors.Is(io.EOF, errors.Unwrap(err)) {
-				logger.Print("TCP connection closed")
+		// any further commands the client has pipelined are kept by the parser, responses are written in order
+		command, err := parser.next()
+
+		if command == nil && err == nil {
+			// incomplete command, so read more input
+			if !s.readInput(&parser, reader) {
 				return
 			}
 
-			logger.Print("Read error: ", err)
+			continue
 		}
 
-		buffer += input
+		if command != nil {
+			if !conn.beginCommand() {
+				logger.Info("connection closing, ignoring command", "command", command.originalText)
+				s.redirectPipelined(command.originalText + parser.pending())
+
+				return
+			}
 
-		command, err := parseCommand(buffer)
+			s.protocolErrors = 0
+			conn.recordCommand(command.name())
 
-		if command != nil {
-			logger.Print("found command: ", buffer)
+			closed := s.handleCommand(command)
+
+			if conn.endCommand() || closed {
+				logger.Info("closing connection")
+
+				if !closed {
+					s.redirectPipelined(parser.pending())
+				}
 
-			response := performCommand(logger, localStoreChannel, responseChannel, peerChannels, ackChannel, command)
-			if response == closeRequest {
-				logger.Print("closing connection")
 				return
 			}
+		}
 
-			if response != "" {
-				logger.Print("writing response: ", response)
-				_ = reliableWrite(clientConn, response)
+		if err != nil {
+			if s.rejectInvalidCommand(err) {
+				return
 			}
 
-			buffer = ""
+			parser.reset()
 		}
+	}
+}
+
+// readInput reads more input for the parser, returning false if the connection should be closed.
+// Whatever input is available is read, unless the command declares a value larger than the reader's buffer,
+// in which case the rest of the value is read straight into a buffer of the command's size, rather than
+// growing the buffer a read at a time. The reader's buffer is always passed to the parser whole, so any
+// input pipelined after the command is with the parser, not left in the reader.
+func (s *session) readInput(parser *commandParser, reader *bufio.Reader) bool {
+	// rejected as soon as the command declares it is too large, rather than once it has all been read
+	size := parser.required()
+
+	if size > s.config.commandSizeLimit() {
+		s.rejectTooLarge(size)
+
+		return false
+	}
+
+	if size > parser.buffered()+reader.Size() {
+		if err := parser.readFrom(reader, size); err != nil {
+			s.logClosed(err)
 
+			return false
+		}
+
+		s.logger.Debug("read large command", "bytes", size)
+
+		return true
+	}
+
+	// blocks until there is input, then whatever has arrived is passed to the parser
+	_, readErr := reader.Peek(1)
+	numRead := reader.Buffered()
+
+	input, _ := reader.Peek(numRead)
+	parser.add(input)
+	_, _ = reader.Discard(numRead)
+
+	if parser.buffered() > s.config.commandSizeLimit() {
+		s.rejectTooLarge(parser.buffered())
+
+		return false
+	}
+
+	if readErr != nil && numRead == 0 {
+		s.logClosed(readErr)
+
+		return false
+	}
+
+	return true
+}
+
+// rejectTooLarge tells the client the command is too large, before the connection is closed, without
+// reading the rest of it.
+func (s *session) rejectTooLarge(size int) {
+	s.logger.Warn("command too large, closing connection", "bytes", size, "limit", s.config.commandSizeLimit())
+
+	_ = reliableWrite(s.conn, s.errorWithReason(reasonCommandTooLarge))
+
+	s.conn.drain()
+}
+
+// logClosed logs the connection being closed by the client, or failing.
+func (s *session) logClosed(err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		s.logger.Info("connection closed")
+	} else {
+		s.logger.Warn("read error", "error", err)
+	}
+}
+
+// handleCommand performs a command and writes the response, returning whether the connection
+// should now be closed.
+func (s *session) handleCommand(command *commandRequest) bool {
+	var response string
+
+	var timing *commandTiming
+
+	// why the command failed, if it does
+	var reason string
+
+	// an idempotency key, timestamp or origin only applies to the command following it
+	prefixes := s.prefixes
+	s.prefixes = commandPrefixes{}
+	s.durability = prefixes.durability
+
+	start := time.Now()
+	s.config.commands.add(start)
+
+	switch {
+	case command.command == idempotencyCommand:
+		// no response, the key applies to the next command
+		s.prefixes = prefixes
+		s.prefixes.idempotencyKey = command.value
+
+	case (command.command == timestampCommand || command.command == originCommand) && !s.config.peer:
+		s.logger.Info("rejecting peer command from a client", "command", command.name())
+
+		response = errorResponse
+		reason = reasonUnauthorised
+
+	case command.command == timestampCommand:
+		if err := checkTimestamp(command.value, time.Now()); err != nil {
+			s.logger.Warn("rejecting timestamp", "timestamp", command.value, "error", err)
+
+			response = errorResponse
+			reason = reasonInvalidTimestamp
+
+			break
+		}
+
+		// no response, the timestamp applies to the next command
+		s.prefixes = prefixes
+		s.prefixes.timestamp = command.value
+
+	case command.command == durabilityCommand:
+		// no response, the durability applies to the next command
+		s.prefixes = prefixes
+		s.prefixes.durability = command.value
+
+	case command.command == originCommand:
+		// no response, the origin applies to the next command
+		s.prefixes = prefixes
+		s.prefixes.origin, s.prefixes.sequence = command.key, command.value
+
+	case command.command == pingCommand:
+		// always allowed, without touching the store or peers, so is cheap to health check
+		response = pongResponse
+
+	case command.command != closeCommand && !s.config.rateLimiter.allow(clientIP(s.conn), time.Now()):
+		s.logger.Info("throttling command, rate limit exceeded", "command", command.name())
+
+		response = throttleResponse
+
+	case command.command == authCommand:
+		// don't log the token itself
+		s.logger.Debug("found command", "command", "auth")
+
+		s.user = authenticate(s.config.acl, command)
+		response = authResponse(s.config.acl, s.user)
+		reason = reasonAuthFailed
+
+	case !authorise(s.config.acl, s.user, command):
+		s.logger.Info("rejecting unauthorised command", "command", command.originalText)
+
+		response = errorResponse
+		reason = reasonUnauthorised
+
+	case !verifyChecksum(command):
+		s.logger.Info("rejecting command with invalid checksum", "command", command.originalText)
+
+		response = errorResponse
+		reason = reasonChecksumMismatch
+
+	case command.command == flushAllCommand && !s.config.allowFlushAll:
+		s.logger.Info("rejecting flush all, not enabled")
+
+		response = errorResponse
+		reason = reasonCommandDisabled
+
+	case command.command == optionCommand:
+		response = s.setOption(command.value)
+
+	case command.command == helloCommand:
+		s.logger.Info("peer handshake", "protocolVersion", command.length, "features", command.value)
+
+		response = helloResponse()
+
+		if !isCompatibleProtocol(command.length) {
+			s.logger.Warn("closing connection from peer with incompatible protocol version")
+
+			_ = reliableWrite(s.conn, response)
+
+			return true
+		}
+
+	case command.command == versionCommand:
+		response = "val" + formatArgument(version.Info(s.config.features))
+
+	case command.command == infoCommand && s.config.info != nil:
+		response = "val" + formatArgument(s.config.info())
+
+	case isWatchCommand(command) && s.config.watches != nil:
+		response = s.handleWatch(command)
+
+	case command.command == scanCommand && s.config.scan != nil:
+		response = listResponse(s.scan(command.key, command.length))
+
+	case command.command == topologyCommand && s.config.topology != nil:
+		response = "val" + formatArgument(s.config.topology())
+
+	case command.command == hotKeysCommand:
+		response = listResponse(s.config.hotKeys.hottest(command.length))
+
+	case command.command == slowLogCommand:
+		response = listResponse(s.config.slowLog.recent(command.length))
+
+	case command.command == raftCommand && s.config.raft != nil:
+		response, reason = s.raftRequest(command.key, command.value)
+
+	case command.command == gossipCommand && s.config.gossip != nil:
+		response, reason = s.exchangeGossip(command.value)
+
+	case command.command == antiEntropyCommand && s.config.antiEntropy != nil:
+		response, reason = s.antiEntropyRequest(command.key, command.value)
+
+	case command.command == compressedCommand:
+		response, reason = s.handleCompressed(command.value)
+
+	case command.command == batchCommand && !s.batching:
+		s.logger.Debug("applying batch of writes from peer", "bytes", len(command.value))
+
+		response, reason = s.applyBatch(command.value)
+
+	case command.command == snapshotCommand && s.config.snapshot != nil:
+		s.logger.Info("sending snapshot to peer")
+
+		response = listResponse(s.config.snapshot())
+
+	case command.command == snapshotChunkCommand && s.config.snapshotChunk != nil:
+		s.logger.Debug("sending snapshot chunk to peer", "after", command.key)
+
+		response = listResponse(s.config.snapshotChunk(command.key, command.length))
+
+	case command.command == clientListCommand && s.config.clients != nil:
+		response = listResponse(s.config.clients())
+
+	case command.command == clientKillCommand && s.config.killClient != nil:
+		response, reason = s.killClient(command.length)
+
+	case command.command == readOnlyCommand && s.config.setReadOnly != nil:
+		s.logger.Warn("setting read-only mode", "mode", command.value)
+		s.config.setReadOnly(command.value == readOnlyOn)
+
+		response = ackResponse
+
+	case isMutation(command) && s.config.readOnly != nil && s.config.readOnly():
+		s.logger.Info("rejecting write, read-only mode", "command", command.originalText)
+
+		response = errorResponse
+		reason = reasonReadOnly
+
+	case isMutation(command) && s.config.replica:
+		s.logger.Info("rejecting write, read replica", "command", command.originalText)
+
+		response = errorResponse
+		reason = replicaReason(s.config.primary)
+
+	case command.command == shutdownCommand && s.config.shutdown != nil:
+		response, reason = s.shutdown()
+
+	case command.command == whatIfCommand && s.config.whatIf != nil:
+		response, reason = s.whatIf(command.value, command.key)
+
+	case prefixes.origin != "" && s.config.origins != nil && isMutation(command) &&
+		!s.config.origins.firstSeen(prefixes.origin, prefixes.sequence, time.Now()):
+		s.logger.Debug("dropping write already seen", "command", command.originalText, "origin", prefixes.origin)
+
+		response = ackResponse
+
+	case isCRDTCommand(command) && s.config.crdts == nil:
+		s.logger.Info("rejecting replicated data type command, not enabled", "command", command.originalText)
+
+		response = errorResponse
+		reason = reasonCommandDisabled
+
+	case isCRDTUpdate(command):
+		timing = &commandTiming{}
+		response, reason = s.updateCRDT(command, prefixes, timing)
+
+	case command.command == counterCommand || command.command == setMembersCommand:
+		response, reason = s.readCRDT(command)
+
+	case isStateDependent(command):
+		timing = &commandTiming{}
+		response, reason = s.performStateDependent(command, prefixes, timing)
+
+	case prefixes.idempotencyKey != "" && s.config.idempotency != nil && isMutation(command):
+		timing = &commandTiming{}
+		response, reason = s.performIdempotent(s.prefixed(command, prefixes), prefixes.idempotencyKey, timing)
+
+	default:
+		timing = &commandTiming{}
+		response, reason = s.perform(s.prefixed(command, prefixes), timing)
+	}
+
+	if response == closeRequest {
+		return true
+	}
+
+	if response == errorResponse {
+		response = s.errorWithReason(reason)
+	}
+
+	writeStart := time.Now()
+
+	if response != "" {
+		s.logger.Debug("writing response", "response", response)
+
+		if err := s.respond(response); err != nil {
+			s.logger.Warn("write error", "error", err)
+			return true
+		}
+
+		duration := time.Since(start)
+
+		err := s.config.accessLog.record(s.conn.remoteAddr(), command, response, reason, duration)
 		if err != nil {
-			_ = reliableWrite(clientConn, errorResponse)
+			s.logger.Error("unable to record access", "error", err)
+		}
+
+		s.config.slowLog.record(s.conn.remoteAddr(), command, duration, start)
+	}
+
+	if timing != nil {
+		timing.write = time.Since(writeStart)
 
-			buffer = ""
+		if err := s.config.sampler.record(command, timing); err != nil {
+			s.logger.Error("unable to record sample", "error", err)
 		}
 	}
+
+	return false
+}
+
+// prefixed returns the command with its namespace's default TTL, prefixed by its timestamp and origin
+// for the peers, when enabled.
+func (s *session) prefixed(command *commandRequest, prefixes commandPrefixes) *commandRequest {
+	return s.tag(s.stamp(withDefaultTTL(command, s.config.namespaceTTLs), prefixes.timestamp), prefixes)
+}
+
+// killClient closes the client connection with the id, returning the response and the reason if it failed.
+func (s *session) killClient(id int) (string, string) {
+	if !s.config.killClient(id) {
+		return errorResponse, reasonUnknownClient
+	}
+
+	s.logger.Info("killed client connection", "id", id)
+
+	return ackResponse, ""
+}
+
+// raftRequest handles a Raft request from a peer, returning the response and the reason if it failed.
+func (s *session) raftRequest(kind string, body string) (string, string) {
+	response, err := s.config.raft(kind, body)
+	if err != nil {
+		s.logger.Warn("invalid raft request", "kind", kind, "error", err)
+
+		return errorResponse, reasonInvalidCommand
+	}
+
+	return "val" + formatArgument(response), ""
+}
+
+// exchangeGossip merges the members gossiped by a peer, returning the response and the reason if it failed.
+func (s *session) exchangeGossip(gossip string) (string, string) {
+	response, err := s.config.gossip(gossip)
+	if err != nil {
+		s.logger.Warn("invalid gossip", "error", err)
+
+		return errorResponse, reasonInvalidCommand
+	}
+
+	return "val" + formatArgument(response), ""
+}
+
+// antiEntropyRequest handles an anti-entropy request from a peer, returning the response and the reason
+// if it failed.
+func (s *session) antiEntropyRequest(kind string, body string) (string, string) {
+	response, err := s.config.antiEntropy(kind, body)
+	if err != nil {
+		s.logger.Warn("invalid anti-entropy request", "kind", kind, "error", err)
+
+		return errorResponse, reasonInvalidCommand
+	}
+
+	return "val" + formatArgument(response), ""
+}
+
+// shutdown asks for the server to be shut down, which is only allowed for authenticated users
+// (so never when authentication is disabled), returning the response and the reason if it failed.
+func (s *session) shutdown() (string, string) {
+	if s.user == nil {
+		s.logger.Info("rejecting shutdown, not authenticated")
+
+		return errorResponse, reasonUnauthorised
+	}
+
+	s.logger.Warn("shutdown requested", "user", s.user.Name)
+	s.config.shutdown()
+
+	return ackResponse, ""
+}
+
+// whatIf reports the data that would move if the change was made to the server's membership of
+// the cluster, returning the response and the reason if it failed.
+func (s *session) whatIf(change string, server string) (string, string) {
+	report, err := s.config.whatIf(change, server)
+	if err != nil {
+		s.logger.Info("rejecting what-if command", "error", err)
+
+		return errorResponse, reasonInvalidCommand + " " + err.Error()
+	}
+
+	return "val" + formatArgument(report), ""
+}
+
+// perform performs a command locally and on the peers, returning the response and the reason if it failed.
+func (s *session) perform(command *commandRequest, timing *commandTiming) (string, string) {
+	var peerChannels []chan<- *commandRequest
+
+	switch {
+	case isMutation(command) && s.config.propose != nil:
+		s.logger.Debug("found command, proposing to raft log", "command", command.originalText)
+
+		return s.config.propose(command.originalText, s.config.timeout(command))
+
+	case s.sharded(command) && !s.config.ring.local(command.key):
+		return s.performElsewhere(command)
+
+	case isMutation(command) && s.config.replicator != nil && s.durability != durabilityReplicated:
+		return s.performAsync(command, timing)
+
+	case isMutation(command):
+		peerChannels, unreachable := s.availablePeers(command, time.Now())
+		if unreachable != nil {
+			return s.performDuringOutage(command, peerChannels, unreachable, timing)
+		}
+
+		s.logger.Debug("found command", "command", command.originalText)
+
+		return s.performWrite(command, peerChannels, len(peerChannels), timing)
+
+	case command.command == closeCommand:
+		// so every peer's replication go routine exits
+		peerChannels = s.peerChannels
+		s.handlersClosed = true
+	}
+
+	s.logger.Debug("found command", "command", command.originalText)
+
+	if command.command == getCommand || command.command == checksumGetCommand {
+		s.config.hotKeys.record(command.key)
+	}
+
+	response, _ := performCommand(s.logger, s.localStoreChannel, s.responseChannel, peerChannels, s.ackChannel,
+		command, len(peerChannels), s.config.timeout(command), timing)
+
+	return response, reasonTimeout
+}
+
+// performAsync performs a write locally, then queues it to be replicated to every peer, returning the
+// response and the reason if it failed.
+func (s *session) performAsync(command *commandRequest, timing *commandTiming) (string, string) {
+	s.logger.Debug("found command, replicating in the background", "command", command.originalText)
+
+	response, _ := performCommand(s.logger, s.localStoreChannel, s.responseChannel, nil, s.ackChannel,
+		command, 0, s.config.timeout(command), timing)
+
+	if response != ackResponse {
+		return response, reasonTimeout
+	}
+
+	peers := make([]*pooledPeer, 0, len(s.peers))
+
+	for _, peer := range s.peers {
+		if !peer.isRemoved() && s.replicates(peer, command) {
+			peers = append(peers, peer)
+		}
+	}
+
+	s.config.replicator.add(peers, command.originalText)
+
+	return response, ""
+}
+
+// availablePeers returns the replication channels of the peers that can currently be replicated to,
+// along with the addresses of those that can't. Peers removed from the cluster, or not holding the
+// command's key when sharding, are neither.
+func (s *session) availablePeers(command *commandRequest, now time.Time) ([]chan<- *commandRequest, []string) {
+	peerChannels := make([]chan<- *commandRequest, 0, len(s.peers))
+
+	var unreachable []string
+
+	for i, peer := range s.peers {
+		switch {
+		case peer.isRemoved() || !s.replicates(peer, command):
+			continue
+
+		case peer.available(now):
+			peerChannels = append(peerChannels, s.peerChannels[i])
+
+		default:
+			unreachable = append(unreachable, peer.address)
+		}
+	}
+
+	return peerChannels, unreachable
+}
+
+// performDuringOutage performs a write when some peers are unreachable, according to the outage policy,
+// returning the response and the reason if it failed.
+func (s *session) performDuringOutage(command *commandRequest, peerChannels []chan<- *commandRequest,
+	unreachable []string, timing *commandTiming) (string, string) {
+	allUnreachable := len(peerChannels) == 0
+
+	if allUnreachable && s.config.outagePolicy == OutageFail {
+		s.logger.Warn("rejecting write, all peers unreachable", "command", command.originalText)
+		return errorResponse, peerUnreachableReason(unreachable)
+	}
+
+	s.logger.Debug("found command, some peers unreachable", "command", command.originalText)
+
+	response, reason := s.performWrite(command, peerChannels, len(peerChannels)+len(unreachable), timing)
+
+	switch {
+	case !writeApplied(response):
+		return response, reason
+
+	case s.config.outagePolicy == OutageHandoff:
+		s.config.handoff.add(unreachable, command.originalText)
+
+	case allUnreachable && s.config.outagePolicy == OutageWarn:
+		return warningResponse, ""
+	}
+
+	return response, ""
+}
+
+// authenticate returns the user matching the token supplied by an auth command, or nil if none.
+func authenticate(acl *ACL, request *commandRequest) *User {
+	if acl == nil {
+		return nil
+	}
+
+	return acl.authenticate(request.value)
+}
+
+// authResponse acknowledges a successful auth command, which is a no-op if authentication is disabled.
+func authResponse(acl *ACL, user *User) string {
+	if acl == nil || user != nil {
+		return ackResponse
+	}
+
+	return errorResponse
+}
+
+// authorise returns whether the connection's user may run the command. If there is no access
+// control list then everything is allowed, otherwise unauthenticated connections may only set
+// connection options or close.
+func authorise(acl *ACL, user *User, request *commandRequest) bool {
+	switch {
+	case acl == nil:
+		return true
+
+	case user == nil:
+		return request.command == closeCommand || request.command == optionCommand ||
+			request.command == idempotencyCommand || request.command == durabilityCommand
+
+	default:
+		return user.permits(request)
+	}
 }
 
 func reliableWrite(writer io.Writer, message string) error {
@@ -112,143 +914,291 @@ func reliableRead(reader io.Reader, expected int) (string, error) {
 	}
 }
 
-func openServerConnections(logger *log.Logger, otherServers []string) ([]net.Conn, error) {
-	serverConns := make([]net.Conn, 0, len(otherServers))
+// peerDialer opens connections to other servers' peer ports.
+type peerDialer struct {
+	// shared secret peer ports are protected by, not authenticating if empty
+	secret string
 
-	for _, otherServer := range otherServers {
-		logger.Print("opening new server connection to ", otherServer)
+	tcpOptions TCPOptions
 
-		conn, err := net.Dial("tcp4", otherServer)
-		if err != nil {
-			logger.Print(err)
+	// size in bytes above which commands sent to peers supporting it are compressed (never if zero)
+	compressAbove int
+}
 
-			// close any previously successfully opened connections
-			for _, conn = range serverConns {
-				_ = conn.Close()
-			}
+// dial connects to another server's peer port, authenticating (if there is a secret)
+// then checking the server is using a compatible protocol version, and which features it supports.
+func (d peerDialer) dial(otherServer string) (net.Conn, error) {
+	network, address := splitAddress(otherServer)
+
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to peer: %w", err)
+	}
 
-			return nil, fmt.Errorf("error connecting to peer: %w", err)
+	if err := d.tcpOptions.apply(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if d.secret != "" {
+		if err := authenticatePeer(conn, d.secret); err != nil {
+			_ = conn.Close()
+			return nil, err
 		}
+	}
 
-		serverConns = append(serverConns, conn)
+	features, err := handshakePeer(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
 	}
 
-	return serverConns, nil
+	return &peerConn{conn, features, d.compressAbove}, nil
 }
 
-func performCommand(logger *log.Logger, localStoreChannel chan<- *commandRequest, responseChannel <-chan string,
-	peerChannels []chan<- *commandRequest, ackChannel <-chan string, request *commandRequest) string {
+// authenticatePeer sends an auth command over a newly opened peer connection.
+func authenticatePeer(conn io.ReadWriter, authToken string) error {
+	if err := reliableWrite(conn, "auth"+formatArgument(authToken)); err != nil {
+		return err
+	}
+
+	response, err := reliableRead(conn, len(ackResponse))
+	if err != nil {
+		return err
+	}
+
+	if response != ackResponse {
+		return errPeerAuthFailed
+	}
+
+	return nil
+}
+
+// performCommand performs the request locally and on the peers, waiting until the required number of peers
+// have applied it (or every peer has replied), returning the local response and how many peers applied it.
+func performCommand(logger *slog.Logger, localStoreChannel chan<- *commandRequest, responseChannel <-chan string,
+	peerChannels []chan<- *commandRequest, ackChannel <-chan peerAck, request *commandRequest, required int,
+	timeout time.Duration, timing *commandTiming) (string, int) {
+	start := time.Now()
+
 	// fan out, by sending the request to every channel
 	localStoreChannel <- request
 
+	storeStart := time.Now()
+	timing.queueWait = storeStart.Sub(start)
+
+	replies := &peerReplies{request: request}
+
 	for _, peerChannel := range peerChannels {
-		peerChannel <- request
+		replies.send(peerChannel, ackChannel)
 	}
 
 	// request is then processed in parallel, locally and replicating to peers
 
-	// fan in, by waiting for responses (or timeout)
+	// fan in, by waiting for responses (or timeout, which starts once the request has been sent to all)
 	var response string
 
-	var numAcks int
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-	for {
+	for response == "" || (replies.applied < required && replies.replies < len(peerChannels)) {
 		select {
-		case <-ackChannel:
-			numAcks++
+		case ack := <-ackChannel:
+			if replies.add(ack) {
+				timing.replication = time.Since(start)
+			}
 
 		case r := <-responseChannel:
 			response = r
 
-		case <-time.After(commandTimeout):
-			logger.Printf("command timed out, received response: %t, received %d acks", response != "", numAcks)
+			timing.store = time.Since(storeStart)
+
+		case <-timer.C:
+			logger.Warn("command timed out", "command", request.name(),
+				"receivedResponse", response != "", "acks", replies.applied)
 
 			if response == "" {
-				return errorResponse
+				return errorResponse, replies.applied
 			}
 
-			return response
+			return response, replies.applied
+		}
+	}
 
-		default:
-			if numAcks == len(peerChannels) && response != "" {
-				logger.Printf("received response and %d acks", numAcks)
-				return response
+	logger.Debug("received response and acks", "acks", replies.applied)
+
+	return response, replies.applied
+}
+
+// closeHandlers sends the close command to the local store and replication go routines, unless already sent,
+// so they exit. Sent from a go routine of its own, discarding any responses and acknowledgements left over
+// from commands that timed out, which the go routines may be waiting to send.
+func (s *session) closeHandlers() {
+	if s.handlersClosed {
+		return
+	}
+
+	s.handlersClosed = true
+	request := &commandRequest{command: closeCommand, originalText: commandNames[closeCommand]}
+
+	go func() {
+		closeHandler(s.localStoreChannel, s.responseChannel, request)
+
+		acks := 0
+
+		for _, peerChannel := range s.peerChannels {
+			acks += sendDiscarding(peerChannel, s.ackChannel, request)
+		}
+
+		for acks < len(s.peerChannels) {
+			if ack := <-s.ackChannel; ack.request == request {
+				acks++
 			}
 		}
+
+		s.logger.Debug("closed local store and replication handlers")
+	}()
+}
+
+// closeHandler sends the close request to the local store go routine, then waits for its response, discarding
+// those before it.
+func closeHandler(requests chan<- *commandRequest, responses <-chan string, request *commandRequest) {
+	for sent := false; !sent; {
+		select {
+		case requests <- request:
+			sent = true
+		case <-responses:
+		}
+	}
+
+	for <-responses != closeRequest {
+		// a response to a command that timed out
 	}
 }
 
-func initialiseReplicationHandler(logger *log.Logger, serverConns []net.Conn) (
-	[]chan<- *commandRequest, <-chan string) {
-	peerChannels := make([]chan<- *commandRequest, len(serverConns))
-	ackChannel := make(chan string)
+// sendDiscarding sends the request to the peer's replication go routine, discarding the acknowledgements
+// received meanwhile, returning how many of those were of the request.
+func sendDiscarding(peerChannel chan<- *commandRequest, ackChannel <-chan peerAck, request *commandRequest) int {
+	acks := 0
 
-	for i, serverConn := range serverConns {
+	for {
+		select {
+		case peerChannel <- request:
+			return acks
+		case ack := <-ackChannel:
+			if ack.request == request {
+				acks++
+			}
+		}
+	}
+}
+
+// writeApplied returns whether the response is from a write that was applied, acknowledged or, for writes
+// returning what they changed such as the bit set, answered with a value.
+func writeApplied(response string) bool {
+	return response == ackResponse || strings.HasPrefix(response, "val")
+}
+
+// initialiseReplicationHandler starts a go routine for each peer, which replicates the commands sent on its
+// channel in order, acknowledging each on the ack channel. Writes join the peer's outbound queue, shared
+// by every connection, so wait for the writes queued before them.
+func initialiseReplicationHandler(logger *slog.Logger, peers []*pooledPeer) (
+	[]chan<- *commandRequest, <-chan peerAck) {
+	peerChannels := make([]chan<- *commandRequest, len(peers))
+
+	// so peers can reply to requests acknowledged without waiting for them
+	ackChannel := make(chan peerAck, len(peers))
+
+	for i, peer := range peers {
 		channel := make(chan *commandRequest)
 		peerChannels[i] = channel
 
-		go func(conn net.Conn) {
+		go func(peer *pooledPeer) {
 			for {
 				request := <-channel
 
+				applied := true
+
 				// only replicate commands that change data
-				if request.command == putCommand || request.command == deleteCommand {
-					logger.Print("replicating command to peer: ", request.originalText)
-					_ = reliableWrite(conn, request.originalText)
+				if isMutation(request) {
+					logger.Debug("replicating command to peer", "peer", peer.address, "command", request.originalText)
 
-					// in a proper system we could use the response to know if peers are active, up to date, etc
-					response, _ := reliableRead(conn, 3)
-					logger.Print("received peer reply: ", response)
+					response, err := peer.replicateOrRecord(request.originalText, time.Now())
+					logger.Debug("received peer reply", "peer", peer.address, "response", response)
+
+					applied = err == nil && writeApplied(response)
 				}
 
-				ackChannel <- ackResponse
+				ackChannel <- peerAck{request, applied}
 
 				if request.command == closeCommand {
 					// exit this go routine
 					return
 				}
 			}
-		}(serverConn)
+		}(peer)
 	}
 
 	return peerChannels, ackChannel
 }
 
-func initialiseLocalStoreHandler(logger *log.Logger, store *kvstore.KVStore) (chan<- *commandRequest, <-chan string) {
+// isWatchCommand returns whether the command starts or stops watching a key or subscribing to a channel.
+func isWatchCommand(request *commandRequest) bool {
+	switch request.command {
+	case watchCommand, unwatchCommand, subscribeCommand, unsubscribeCommand:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// isMutation returns whether the command changes data, and so needs replicating to peers.
+func isMutation(request *commandRequest) bool {
+	switch request.command {
+	case putCommand, deleteCommand, checksumPutCommand, putExpiryCommand, flushAllCommand, crdtMergeCommand,
+		putOptionsCommand, persistCommand, hashSetCommand, hashDeleteCommand, listPushLeftCommand,
+		listPushRightCommand, listPopLeftCommand, listPopRightCommand, listTrimCommand, memberAddCommand,
+		memberRemoveCommand, scoreAddCommand, scoreRemoveCommand, setBitCommand:
+		return true
+
+	case customCommand:
+		return request.custom.Writes()
+
+	default:
+		return isCRDTUpdate(request)
+	}
+}
+
+func initialiseLocalStoreHandler(logger *slog.Logger, store *kvstore.KVStore) (chan<- *commandRequest, <-chan string) {
 	localStoreChannel := make(chan *commandRequest)
 	responseChannel := make(chan string)
 
 	go func() {
 		for {
 			request := <-localStoreChannel
-			logger.Printf("local store - received command %v", request)
+			logger.Debug("local store received command", "command", request.name(), "key", request.key)
 
 			var response string
 
-			switch request.command {
-			case putCommand:
-				kvstore.Write(store, request.key, request.value)
+			switch {
+			case request.command == closeCommand:
+				// keep store open for other connections
+				response = closeRequest
 
+			case request.applied:
 				response = ackResponse
 
-			case getCommand:
-				response = handleVariableLengthGet(store, *request)
-
-			case deleteCommand:
-				kvstore.Delete(store, request.key)
-
-				response = ackResponse
+			case request.command == flushAllCommand:
+				logger.Warn("flushing all keys")
 
-			case closeCommand:
-				// keep store open for other connections
-				response = closeRequest
+				response = executeLocally(logger, store, request)
 
 			default:
-				// unknown command
-				response = errorResponse
+				response = executeLocally(logger, store, request)
 			}
 
-			logger.Printf("local store - sending response %s", response)
+			logger.Debug("local store sending response", "response", response)
 			responseChannel <- response
 
 			if request.command == closeCommand {
@@ -261,19 +1211,128 @@ func initialiseLocalStoreHandler(logger *log.Logger, store *kvstore.KVStore) (ch
 	return localStoreChannel, responseChannel
 }
 
-func handleVariableLengthGet(store *kvstore.KVStore, request commandRequest) string {
-	value, present := kvstore.Read(store, request.key)
+// storeCommands executes each of the built in commands performed on the store.
+var storeCommands = map[command]func(store kvstore.Store, request *commandRequest) (string, error){
+	putCommand:           executePut,
+	checksumPutCommand:   executePut,
+	putExpiryCommand:     executePutExpiry,
+	putOptionsCommand:    executePutOptions,
+	persistCommand:       executePersist,
+	hashSetCommand:       executeHashSet,
+	hashGetCommand:       executeHashGet,
+	hashDeleteCommand:    executeHashDelete,
+	hashGetAllCommand:    executeHashGetAll,
+	listPushLeftCommand:  executeListPush,
+	listPushRightCommand: executeListPush,
+	listPopLeftCommand:   executeListPop,
+	listPopRightCommand:  executeListPop,
+	listRangeCommand:     executeListRange,
+	listTrimCommand:      executeListTrim,
+	memberAddCommand:     executeMemberAdd,
+	memberRemoveCommand:  executeMemberRemove,
+	isMemberCommand:      executeIsMember,
+	membersCommand:       executeMembers,
+	scoreAddCommand:      executeScoreAdd,
+	scoreRemoveCommand:   executeScoreRemove,
+	rankRangeCommand:     executeRankRange,
+	scoreRangeCommand:    executeScoreRange,
+	setBitCommand:        executeSetBit,
+	getBitCommand:        executeGetBit,
+	bitCountCommand:      executeBitCount,
+	typeCommand:          executeType,
+	getCommand:           handleVariableLengthGet,
+	checksumGetCommand:   handleChecksumGet,
+	deleteCommand:        executeDelete,
+	flushAllCommand:      executeFlushAll,
+}
+
+// executeRequest executes the command on the store, whether built in or registered with RegisterCommand.
+func executeRequest(ctx context.Context, store kvstore.Store, request *commandRequest) (string, error) {
+	if request.custom != nil {
+		return request.custom.Execute(ctx, store) //nolint:wrapcheck // sent to the client as it is
+	}
+
+	execute, found := storeCommands[request.command]
+	if !found {
+		return "", errBuiltinCommand
+	}
+
+	return execute(store, request)
+}
+
+// executeLocally executes the command on the store, returning the response, or the error response if it
+// failed. The connection stops waiting for the response once the command times out, so the command isn't
+// given a deadline.
+func executeLocally(logger *slog.Logger, store kvstore.Store, request *commandRequest) string {
+	response, err := executeRequest(context.Background(), store, request)
 
 	switch {
+	case errors.Is(err, errBuiltinCommand):
+		// unknown command
+		return errorResponse
+
+	case errors.Is(err, kvstore.ErrWrongType):
+		return formatError(reasonWrongType)
+
+	case err != nil:
+		logger.Info("command failed", "command", request.name(), "error", err)
+
+		return formatError(reasonCommandFailed + " " + err.Error())
+	}
+
+	return response
+}
+
+func executePut(store kvstore.Store, request *commandRequest) (string, error) {
+	if err := writeString(store, request.key, request.value, 0); err != nil {
+		return "", err
+	}
+
+	return ackResponse, nil
+}
+
+func executePutExpiry(store kvstore.Store, request *commandRequest) (string, error) {
+	ttl := time.Duration(request.length) * time.Millisecond
+
+	if err := writeString(store, request.key, request.value, ttl); err != nil {
+		return "", err
+	}
+
+	return ackResponse, nil
+}
+
+func executeDelete(store kvstore.Store, request *commandRequest) (string, error) {
+	if err := deleteKey(store, request.key); err != nil {
+		return "", err
+	}
+
+	return ackResponse, nil
+}
+
+func executeFlushAll(store kvstore.Store, _ *commandRequest) (string, error) {
+	if err := clearStore(store); err != nil {
+		return "", err
+	}
+
+	return ackResponse, nil
+}
+
+func handleVariableLengthGet(store kvstore.Store, request *commandRequest) (string, error) {
+	value, present, err := readString(store, request.key)
+
+	switch {
+	case err != nil:
+		return "", err
+
 	case !present:
-		return "nil"
+		return "nil", nil
 
 	case request.length == 0 || request.length > len(value):
 		// return the whole value
-		return "val" + formatArgument(value)
+		return "val" + formatArgument(value), nil
 
 	default:
-		// return part of the value
-		return "val" + formatArgument(value[:request.length])
+		// return part of the value, the length being in bytes, so this may end part way through a character
+		return "val" + formatArgument(value[:request.length]), nil
 	}
 }
@@ -1,13 +1,21 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"net"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"tcp/pkg/backoff"
 	"tcp/pkg/kvstore"
+	"tcp/pkg/version"
 	"testing"
+	"time"
 )
 
 const (
@@ -24,13 +32,13 @@ const (
 )
 
 // to enable logging change ioutil.Discard to os.Stdout.
-var testLogger = log.New(ioutil.Discard, "Code under test: ", log.Ldate|log.Ltime|log.Lshortfile)
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
 
 func Test_handle_HappyPath(t *testing.T) {
 	server, client := net.Pipe()
 	store := kvstore.NewKVStore()
 
-	go handle(testLogger, server, store, nil)
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
 
 	checkRequestResponse(t, client, "get11a0", "nil")       // get key not present
 	checkRequestResponse(t, client, "put12bb13999", "ack")  // put key
@@ -40,11 +48,137 @@ func Test_handle_HappyPath(t *testing.T) {
 	checkRequestResponse(t, client, "bye", "")              // shutdown
 }
 
+// failingEngine holds values in memory, failing every read and change while failing is set.
+type failingEngine struct {
+	kvstore.StorageEngine
+	failing atomic.Bool
+}
+
+var errEngineFailed = errors.New("disk full")
+
+func (f *failingEngine) Get(key string) (string, bool, error) {
+	if f.failing.Load() {
+		return "", false, errEngineFailed
+	}
+
+	return f.StorageEngine.Get(key)
+}
+
+func (f *failingEngine) Put(key string, value string) error {
+	if f.failing.Load() {
+		return errEngineFailed
+	}
+
+	return f.StorageEngine.Put(key, value)
+}
+
+func (f *failingEngine) Delete(key string) error {
+	if f.failing.Load() {
+		return errEngineFailed
+	}
+
+	return f.StorageEngine.Delete(key)
+}
+
+func (f *failingEngine) Clear() error {
+	if f.failing.Load() {
+		return errEngineFailed
+	}
+
+	return f.StorageEngine.Clear()
+}
+
+func Test_handle_StorageFailure(t *testing.T) {
+	server, client := net.Pipe()
+	engine := &failingEngine{StorageEngine: kvstore.NewMemoryEngine()}
+	store := kvstore.NewKVStoreWithEngine(testLogger, engine)
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{allowFlushAll: true})
+
+	checkRequestResponse(t, client, "put12bb13999", "ack")
+	engine.failing.Store(true)
+
+	tests := []struct {
+		request  string
+		response string
+	}{
+		{"put12bb13888", formatError(reasonCommandFailed + " unable to write bb: disk full")},
+		{"pex12bb13888141000", formatError(reasonCommandFailed + " unable to write bb: disk full")},
+		{"pto12bb1388812xx", formatError(reasonCommandFailed + " unable to write bb: disk full")},
+		{"get12bb0", formatError(reasonCommandFailed + " unable to read bb: disk full")},
+		{"del12bb", formatError(reasonCommandFailed + " unable to delete bb: disk full")},
+		{"fla", formatError(reasonCommandFailed + " unable to clear: disk full")},
+		{"sbt12bb110111", formatError(reasonCommandFailed + " unable to read bb: disk full")},
+	}
+
+	for _, test := range tests {
+		checkRequestResponse(t, client, test.request, test.response)
+	}
+
+	// none of the failed writes were acknowledged, leaving the key unchanged
+	engine.failing.Store(false)
+	checkRequestResponse(t, client, "get12bb0", "val13999")
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_Pipelining(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
+
+	// send every command before reading any responses
+	go write(t, client, "put12bb13999get12bb0del12bbget12bb0")
+
+	read(t, client, "ack")      // put key
+	read(t, client, "val13999") // get key just written
+	read(t, client, "ack")      // delete key
+	read(t, client, "nil")      // get key, now not present
+
+	checkRequestResponse(t, client, "bye", "") // shutdown
+}
+
+// Benchmark_handle_Read measures reading pipelined puts of values of each size, sent several at a time.
+func Benchmark_handle_Read(b *testing.B) {
+	for _, size := range []int{10, 1000, 100000} {
+		b.Run(fmt.Sprintf("value=%d", size), func(b *testing.B) {
+			server, client := net.Pipe()
+			store := kvstore.NewKVStore()
+
+			defer kvstore.Close(store)
+
+			go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
+
+			command := "put13key" + formatArgument(strings.Repeat("x", size))
+			pipelined := strings.Repeat(command, max(1, 64<<10/len(command)))
+
+			b.SetBytes(int64(len(command)))
+			b.ResetTimer()
+
+			go func() {
+				for sent := 0; sent < b.N; sent += len(pipelined) / len(command) {
+					remaining := (b.N - sent) * len(command)
+					_, _ = client.Write([]byte(pipelined[:min(len(pipelined), remaining)]))
+				}
+			}()
+
+			if _, err := io.CopyN(io.Discard, client, int64(b.N*len(ackResponse))); err != nil {
+				b.Fatal("Error reading responses: ", err)
+			}
+
+			b.StopTimer()
+
+			_, _ = client.Write([]byte("bye"))
+			_ = client.Close()
+		})
+	}
+}
+
 func Test_handle_LargeEntry(t *testing.T) {
 	server, client := net.Pipe()
 	store := kvstore.NewKVStore()
 
-	go handle(testLogger, server, store, nil)
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
 
 	checkRequestResponse(t, client, "put226"+key+"3513"+value, "ack")  // put key
 	checkRequestResponse(t, client, "get226"+key+"0", "val3513"+value) // get key just written
@@ -57,7 +191,7 @@ func Test_handle_VariableLengthGet(t *testing.T) {
 	server, client := net.Pipe()
 	store := kvstore.NewKVStore()
 
-	go handle(testLogger, server, store, nil)
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
 
 	checkRequestResponse(t, client, "put11a2200123456789abcdefghij", "ack")    // put 20 chars value
 	checkRequestResponse(t, client, "get11a0", "val2200123456789abcdefghij")   // get whole value
@@ -67,23 +201,159 @@ func Test_handle_VariableLengthGet(t *testing.T) {
 	checkRequestResponse(t, client, "bye", "")                                 // shutdown
 }
 
+func Test_handle_MultiByte(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	// lengths are in bytes: the key is 2 characters but 6 bytes, the value 3 characters but 7 bytes
+	checkRequestResponse(t, client, "put16键值17😀é!", "ack")
+	checkRequestResponse(t, client, "get16键值0", "val17😀é!")
+	checkRequestResponse(t, client, "get16键值16", "val16😀é") // first 6 bytes
+
+	// written a byte at a time, splitting each character across reads
+	go func() {
+		for _, b := range []byte("put16键值14🎉" + "get16键值0") {
+			write(t, client, string([]byte{b}))
+		}
+	}()
+
+	read(t, client, "ack")
+	read(t, client, "val14🎉")
+	checkRequestResponse(t, client, "bye", "")
+}
+
 func Test_handle_Errors(t *testing.T) {
 	server, client := net.Pipe()
 	store := kvstore.NewKVStore()
 
-	go handle(testLogger, server, store, nil)
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
 
 	// valid commands intermingled with invalid ones, to test the buffer being wiped
 	// and subsequent commands being successfully recognised
-	checkRequestResponse(t, client, "get11a0", "nil")      // valid - get key not present
-	checkRequestResponse(t, client, "get1xd", "err")       // invalid - get
-	checkRequestResponse(t, client, "put12bb13999", "ack") // valid - put key
-	checkRequestResponse(t, client, "put11a1xa", "err")    // invalid - put
-	checkRequestResponse(t, client, "del12bb", "ack")      // valid - delete
-	checkRequestResponse(t, client, "delx1b", "err")       // invalid - delete
-	checkRequestResponse(t, client, "get11a0", "nil")      // valid - get key not present
-	checkRequestResponse(t, client, "abc", "err")          // invalid - no such command
-	checkRequestResponse(t, client, "bye", "")             // shutdown
+	checkRequestResponse(t, client, "get11a0", "nil")                               // valid - get key not present
+	checkRequestResponse(t, client, "get1xd", formatError(reasonInvalidCommand))    // invalid - get
+	checkRequestResponse(t, client, "put12bb13999", "ack")                          // valid - put key
+	checkRequestResponse(t, client, "put11a1xa", formatError(reasonInvalidCommand)) // invalid - put
+	checkRequestResponse(t, client, "del12bb", "ack")                               // valid - delete
+	checkRequestResponse(t, client, "delx1b", formatError(reasonInvalidCommand))    // invalid - delete
+	checkRequestResponse(t, client, "get11a0", "nil")                               // valid - get key not present
+	checkRequestResponse(t, client, "abc", formatError(reasonUnknownCommand))       // invalid - no such command
+	checkRequestResponse(t, client, "bye", "")                                      // shutdown
+}
+
+func Test_handle_ProtocolErrors(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "abc", formatError(reasonUnknownCommand))
+	checkRequestResponse(t, client, "get1xd", formatError(reasonInvalidCommand))
+	checkRequestResponse(t, client, "get11a0", "nil") // valid, resets the count
+	checkRequestResponse(t, client, "abc", formatError(reasonUnknownCommand))
+	checkRequestResponse(t, client, "abc", formatError(reasonUnknownCommand))
+
+	// too many in a row, so the connection is closed
+	checkRequestResponse(t, client, "abc", formatError(reasonProtocolError))
+	read(t, client, "")
+}
+
+func Test_handle_CommandTooLarge(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{maxCommandSize: 20})
+
+	// declares a key of almost a gigabyte, so rejected without waiting for it to be sent
+	checkRequestResponse(t, client, "wch9999999999", formatError(reasonCommandTooLarge))
+	read(t, client, "")
+}
+
+func Test_handle_DeclaredTooLarge(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{maxCommandSize: 20})
+
+	// rejected as soon as the put declares a value too large, before any of it is sent
+	checkRequestResponse(t, client, "put11a9999999999", formatError(reasonCommandTooLarge))
+	read(t, client, "")
+}
+
+func Test_handle_DeclaredTooLargeDrained(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		if server, err := listener.Accept(); err == nil {
+			handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{maxCommandSize: 20})
+		}
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer client.Close()
+
+	// the client carries on sending the value after it is rejected, which is discarded rather than resetting
+	// the connection, so the response can still be read
+	go func() {
+		_, _ = client.Write([]byte("put11a9999999999" + strings.Repeat("x", 100000)))
+	}()
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	response, err := io.ReadAll(client)
+	if err != nil || string(response) != formatError(reasonCommandTooLarge) {
+		t.Errorf("Expected %s but got %q, %v", formatError(reasonCommandTooLarge), response, err)
+	}
+}
+
+func Test_handle_StreamedValue(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	large := strings.Repeat("0123456789", 100000)
+
+	// the value is written in pieces, read straight into a buffer of its size, then the next command
+	// pipelined after it is parsed as usual
+	go func() {
+		for _, piece := range []string{"put11a71000000" + large[:5000], large[5000:], "get11a14"} {
+			write(t, client, piece)
+		}
+	}()
+
+	read(t, client, "ack")
+	read(t, client, "val14"+large[:4])
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_BufferedInput(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	large := strings.Repeat("0123456789", 100000)
+
+	// a value larger than the read buffer with commands pipelined after it in the same write, then commands
+	// split across writes, each keeping the input buffered after the command being read
+	go func() {
+		for _, piece := range []string{"put11a71000000" + large + "get11a14put11b", "11xget1", "1b0get11a", "15"} {
+			write(t, client, piece)
+		}
+	}()
+
+	read(t, client, "ack")
+	read(t, client, "val14"+large[:4])
+	read(t, client, "ack")
+	read(t, client, "val11x")
+	read(t, client, "val15"+large[:5])
+	checkRequestResponse(t, client, "bye", "")
 }
 
 func Test_handle_Distributed(t *testing.T) {
@@ -94,12 +364,404 @@ func Test_handle_Distributed(t *testing.T) {
 
 	peers := []net.Conn{server2, server3}
 
-	go handle(testLogger, server1, store, []net.Conn{peer2, peer3})
+	go handle(testLogger, newConnection(server1), store, connectedPeers(peer2, peer3), &handlerConfig{})
+
+	checkDistributedRequestResponse(t, client, "put12bb13999", peers, "ack")           // put is distributed
+	checkRequestResponse(t, client, "get12bb0", "val13999")                            // get is not distributed
+	checkDistributedRequestResponse(t, client, "del12bb", peers, "ack")                // delete is distributed
+	checkDistributedRequestResponse(t, client, "pck12bb1399918857a02bf", peers, "ack") // checksum put too
+	checkRequestResponse(t, client, "bye", "")                                         // bye is not distributed
+}
+
+func Test_handle_FlushAll(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server1), store, connectedPeers(peer2), &handlerConfig{allowFlushAll: true})
+
+	checkDistributedRequestResponse(t, client, "put12bb13999", []net.Conn{server2}, "ack")
+	checkDistributedRequestResponse(t, client, "fla", []net.Conn{server2}, "ack") // flush is distributed
+	checkRequestResponse(t, client, "get12bb0", "nil")
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_FlushAllDisabled(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "put12bb13999", "ack")
+	checkRequestResponse(t, client, "fla", formatError(reasonCommandDisabled))
+	checkRequestResponse(t, client, "get12bb0", "val13999") // still present
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_Checksum(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
+
+	// checksum doesn't match value
+	checkRequestResponse(t, client, "pck12bb139991800000000", formatError(reasonChecksumMismatch))
+	checkRequestResponse(t, client, "gck12bb", "nil")                // so wasn't stored
+	checkRequestResponse(t, client, "pck12bb1399918857A02BF", "ack") // checksum matches (any case)
+	checkRequestResponse(t, client, "gck12bb", "val1399918857a02bf") // get value with checksum
+	checkRequestResponse(t, client, "get12bb0", "val13999")          // plain get still works
+	checkRequestResponse(t, client, "bye", "")                       // shutdown
+}
+
+func Test_handle_PeerOutageFail(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, unreachablePeers("peer2"), &handlerConfig{})
+
+	// writes rejected
+	checkRequestResponse(t, client, "put12bb13999", formatError(peerUnreachableReason([]string{"peer2"})))
+	checkRequestResponse(t, client, "get12bb0", "nil") // reads still served
+	checkRequestResponse(t, client, "bye", "")         // shutdown
+}
+
+func Test_handle_PeerOutageWarn(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, unreachablePeers("peer2"),
+		&handlerConfig{outagePolicy: OutageWarn})
+
+	checkRequestResponse(t, client, "put12bb13999", "wrn")  // applied locally only
+	checkRequestResponse(t, client, "get12bb0", "val13999") // get key just written
+	checkRequestResponse(t, client, "bye", "")              // shutdown
+}
+
+func Test_handle_PeerOutageHandoff(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+	handoff := newHandoffQueue(1, backoff.DefaultPolicy)
+
+	go handle(testLogger, newConnection(server), store, unreachablePeers("peer2"),
+		&handlerConfig{outagePolicy: OutageHandoff, handoff: handoff})
+
+	checkRequestResponse(t, client, "put12bb13999", "ack")  // applied locally and queued
+	checkRequestResponse(t, client, "get12bb0", "val13999") // reads aren't queued
+	checkRequestResponse(t, client, "del12bb", "ack")       // queue full, so dropped
+	checkRequestResponse(t, client, "bye", "")              // shutdown
+
+	if pending := handoff.pending("peer2"); !reflect.DeepEqual(pending, []string{"put12bb13999"}) {
+		t.Errorf("Expected put to be queued but got %v", pending)
+	}
+}
+
+func Test_handoffQueue_deliver(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	defer listener.Close()
+
+	peerStore := kvstore.NewKVStore()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			handle(testLogger, newConnection(conn), peerStore, nil, &handlerConfig{})
+		}
+	}()
+
+	peer := listener.Addr().String()
+	handoff := newHandoffQueue(10, backoff.DefaultPolicy)
+	handoff.add([]string{peer}, "put12bb13999")
+	handoff.add([]string{peer}, "put12cc11x")
+
+	handoff.deliver(testLogger, peerDialer{}, time.Now())
+
+	if pending := handoff.pending(peer); len(pending) != 0 {
+		t.Errorf("Expected all writes delivered but %v pending", pending)
+	}
+
+	if value, _ := kvstore.Read(peerStore, "cc"); value != "x" {
+		t.Errorf("Expected x but got %s", value)
+	}
+}
+
+func Test_handoffQueue_deliverBackoff(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	// peer is now unreachable
+	peer := listener.Addr().String()
+	_ = listener.Close()
+
+	handoff := newHandoffQueue(10, backoff.Policy{Initial: time.Minute, Max: time.Minute})
+	handoff.add([]string{peer}, "put12bb13999")
+
+	now := time.Now()
+	handoff.deliver(testLogger, peerDialer{}, now)
+	handoff.deliver(testLogger, peerDialer{}, now.Add(time.Second)) // skipped, still backing off
+
+	if failures := handoff.retry(peer).Failures(); failures != 1 {
+		t.Errorf("Expected 1 failed delivery but got %d", failures)
+	}
+
+	if pending := handoff.pending(peer); len(pending) != 1 {
+		t.Errorf("Expected write still pending but got %v", pending)
+	}
+}
+
+func Test_handle_ReadTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+	conn := newConnection(server)
+	conn.readTimeout = 50 * time.Millisecond
+
+	go handle(testLogger, conn, store, nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "get12bb0", "nil") // responds within the timeout
+
+	// stall part way through a command
+	write(t, client, "get1")
+	time.Sleep(100 * time.Millisecond)
+
+	// check the connection was closed by the server
+	read(t, client, "")
+}
+
+func Test_handle_WriteTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+	conn := newConnection(server)
+	conn.writeTimeout = 50 * time.Millisecond
+
+	closed := make(chan struct{})
+
+	go func() {
+		handle(testLogger, conn, store, nil, &handlerConfig{})
+		close(closed)
+	}()
+
+	// never read the response
+	write(t, client, "get12bb0")
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("Expected connection to be closed")
+	}
+}
+
+func Test_handle_Version(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{features: []string{"auth"}})
+
+	info := version.Info([]string{"auth"})
+
+	checkRequestResponse(t, client, "ver", "val"+formatArgument(info)) // version info
+	checkRequestResponse(t, client, "bye", "")                         // shutdown
+}
+
+func Test_handle_RateLimit(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{rateLimiter: newRateLimiter(0.001, 2)})
+
+	checkRequestResponse(t, client, "put12bb13999", "ack")  // put key
+	checkRequestResponse(t, client, "get12bb0", "val13999") // get key just written
+	checkRequestResponse(t, client, "del12bb", "thr")       // throttled, burst used up
+	checkRequestResponse(t, client, "bye", "")              // shutdown still allowed
+}
+
+func Test_handle_Ping(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{acl: NewSharedSecretACL("secret")})
+
+	checkRequestResponse(t, client, "png", "pong") // allowed without authenticating
+	checkRequestResponse(t, client, "bye", "")     // shutdown
+}
+
+func Test_handle_Auth(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{acl: NewSharedSecretACL("secret")})
+
+	checkRequestResponse(t, client, "put12bb13999", formatError(reasonUnauthorised)) // rejected, not authenticated
+	checkRequestResponse(t, client, "get12bb0", formatError(reasonUnauthorised))     // rejected, not authenticated
+	checkRequestResponse(t, client, "auth15wrong", formatError(reasonAuthFailed))    // wrong token
+	checkRequestResponse(t, client, "del12bb", formatError(reasonUnauthorised))      // still rejected
+	checkRequestResponse(t, client, "auth16secret", "ack")                           // correct token
+	checkRequestResponse(t, client, "put12bb13999", "ack")                           // put key
+	checkRequestResponse(t, client, "get12bb0", "val13999")                          // get key just written
+	checkRequestResponse(t, client, "bye", "")                                       // shutdown
+}
+
+func Test_handle_AuthDisabled(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "auth11x", "ack")  // auth is a no-op when disabled
+	checkRequestResponse(t, client, "get12bb0", "nil") // data commands still allowed
+	checkRequestResponse(t, client, "bye", "")         // shutdown
+}
+
+func Test_handle_ShutdownNotAuthenticated(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+	requested := false
+
+	go handle(testLogger, newConnection(server), store, nil,
+		&handlerConfig{shutdown: func() { requested = true }})
+
+	checkRequestResponse(t, client, "sdn", formatError(reasonUnauthorised)) // authentication disabled
+	checkRequestResponse(t, client, "bye", "")
+
+	if requested {
+		t.Error("Shutdown should not have been requested")
+	}
+}
+
+func Test_authenticatePeer(t *testing.T) {
+	server, peer := net.Pipe()
+
+	go handle(testLogger, newConnection(peer), kvstore.NewKVStore(), nil,
+		&handlerConfig{acl: NewSharedSecretACL("secret")})
+
+	if err := authenticatePeer(server, "secret"); err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if err := authenticatePeer(server, "wrong"); !errors.Is(err, errPeerAuthFailed) {
+		t.Error("Wrong error returned: ", err)
+	}
+
+	_ = server.Close()
+}
+
+func Test_handle_ACL(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+	acl := NewACL([]User{
+		{Name: "reader", Token: "r", Commands: []string{"get"}, KeyPrefixes: []string{"team1/"}},
+	})
+
+	kvstore.Write(store, "team1/a", "1")
+	kvstore.Write(store, "team2/a", "2")
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{acl: acl})
+
+	checkRequestResponse(t, client, "auth11r", "ack")                                   // authenticate as reader
+	checkRequestResponse(t, client, "get17team1/a0", "val111")                          // permitted command and key
+	checkRequestResponse(t, client, "get17team2/a0", formatError(reasonUnauthorised))   // key prefix not permitted
+	checkRequestResponse(t, client, "put17team1/a11x", formatError(reasonUnauthorised)) // command not permitted
+	checkRequestResponse(t, client, "del17team1/a", formatError(reasonUnauthorised))    // command not permitted
+	checkRequestResponse(t, client, "bye", "")                                          // shutdown
+}
+
+func Test_handle_ClosesHandlers(t *testing.T) {
+	store := kvstore.NewKVStore()
+	crdts := newCRDTStore("a", store, time.Unix(0, 0))
+	baseline := runtime.NumGoroutine()
+
+	server1, client := net.Pipe()
+	_, peer2 := net.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		handle(testLogger, newConnection(server1), store, connectedPeers(peer2),
+			&handlerConfig{lww: newLastWriteWins("a"), crdts: crdts})
+		close(done)
+	}()
+
+	checkRequestResponse(t, client, "get11a0", "nil")
+
+	// the client closes the connection without the close command
+	_ = client.Close()
+	<-done
 
-	checkDistributedRequestResponse(t, client, "put12bb13999", peers, "ack") // put is distributed
-	checkRequestResponse(t, client, "get12bb0", "val13999")                  // get is not distributed
-	checkDistributedRequestResponse(t, client, "del12bb", peers, "ack")      // delete is distributed
-	checkRequestResponse(t, client, "bye", "")                               // bye is not distributed
+	// the local store, replication and wrapper go routines exit
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > baseline; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected at most %d go routines but there were %d", baseline, runtime.NumGoroutine())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	kvstore.Close(store)
+}
+
+func Test_handle_Sampler(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	var samples strings.Builder
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{sampler: NewSampler(&samples, 2)})
+
+	checkRequestResponse(t, client, "put12bb13999", "ack")  // not sampled
+	checkRequestResponse(t, client, "get12bb0", "val13999") // sampled
+	checkRequestResponse(t, client, "del12bb", "ack")       // not sampled
+	checkRequestResponse(t, client, "get12bb0", "nil")      // sampled
+	checkRequestResponse(t, client, "bye", "")              // shutdown
+
+	lines := strings.Split(strings.TrimSpace(samples.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 samples but got %d", len(lines))
+	}
+
+	var sample commandSample
+
+	if err := json.Unmarshal([]byte(lines[0]), &sample); err != nil {
+		t.Fatal("Unable to parse sample: ", err)
+	}
+
+	if sample.Command != "get" || sample.Key != "bb" {
+		t.Errorf("Expected get of bb but got %s of %s", sample.Command, sample.Key)
+	}
+}
+
+func Test_handle_AccessLog(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	var entries strings.Builder
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{accessLog: NewAccessLog(&entries)})
+
+	checkRequestResponse(t, client, "put12bb13999", "ack")
+	checkRequestResponse(t, client, "pck12bb139991800000000", formatError(reasonChecksumMismatch))
+	checkRequestResponse(t, client, "bye", "") // shutdown, not logged since there's no response
+
+	lines := strings.Split(strings.TrimSpace(entries.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 entries but got %d", len(lines))
+	}
+
+	var entry accessLogEntry
+
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatal("Unable to parse entry: ", err)
+	}
+
+	if entry.Command != "pck" || entry.Key != "bb" || entry.Result != "err" || entry.Reason != reasonChecksumMismatch {
+		t.Error("Wrong entry: ", lines[1])
+	}
+
+	if entry.Client == "" || entry.DurationNs <= 0 {
+		t.Error("Expected client and duration but got: ", lines[1])
+	}
 }
 
 func checkRequestResponse(t *testing.T, client net.Conn, request string, expectedResponse string) {
@@ -152,7 +814,7 @@ func read(t *testing.T, conn net.Conn, expectedMessage string) {
 
 	if expectedMessage == "" {
 		// client disconnected, check the connection was shut by the server
-		_, err := conn.Read(buffer)
+		_, err := conn.Read(make([]byte, 1))
 		if !errors.Is(err, io.EOF) {
 			t.Error("Wrong error returned: ", err)
 		}
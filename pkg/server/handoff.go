@@ -0,0 +1,165 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"tcp/pkg/backoff"
+	"time"
+)
+
+// OutagePolicy determines how writes are handled when every peer is unreachable.
+type OutagePolicy int
+
+const (
+	// OutageFail rejects writes with an error, reads are still served.
+	OutageFail OutagePolicy = iota
+
+	// OutageHandoff applies writes locally and queues them for delivery to the peers once
+	// they are reachable again.
+	OutageHandoff OutagePolicy = iota
+
+	// OutageWarn applies writes locally only, acknowledging them with a warning response.
+	OutageWarn OutagePolicy = iota
+)
+
+const defaultHandoffLimit = 10000
+
+var errUnknownOutagePolicy = errors.New("unknown peer outage policy")
+
+// ParseOutagePolicy returns the policy with the specified name: fail, handoff or warn.
+func ParseOutagePolicy(name string) (OutagePolicy, error) {
+	switch name {
+	case "fail":
+		return OutageFail, nil
+
+	case "handoff":
+		return OutageHandoff, nil
+
+	case "warn":
+		return OutageWarn, nil
+
+	default:
+		return OutageFail, fmt.Errorf("%w: %s", errUnknownOutagePolicy, name)
+	}
+}
+
+// handoffQueue holds writes that couldn't be replicated because every peer was unreachable,
+// to be delivered once the peers are reachable again. Writes beyond the limit are dropped.
+// Delivery to a peer that is still unreachable is retried according to the backoff policy.
+type handoffQueue struct {
+	mutex   sync.Mutex
+	limit   int
+	hints   map[string][]string
+	dropped int
+	policy  backoff.Policy
+	retries map[string]*backoff.Backoff
+}
+
+func newHandoffQueue(limit int, policy backoff.Policy) *handoffQueue {
+	if limit < 1 {
+		limit = defaultHandoffLimit
+	}
+
+	return &handoffQueue{
+		limit:   limit,
+		hints:   make(map[string][]string),
+		policy:  policy,
+		retries: make(map[string]*backoff.Backoff),
+	}
+}
+
+// add queues the write for each of the peers.
+func (q *handoffQueue) add(peers []string, mutation string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, peer := range peers {
+		if len(q.hints[peer]) >= q.limit {
+			q.dropped++
+			continue
+		}
+
+		q.hints[peer] = append(q.hints[peer], mutation)
+	}
+}
+
+// pending returns the writes queued for the peer.
+func (q *handoffQueue) pending(peer string) []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return append([]string(nil), q.hints[peer]...)
+}
+
+// deliver sends the queued writes to every peer that is now reachable, in the order they were
+// queued, keeping any that couldn't be delivered for next time. Peers that failed recently
+// are skipped until their backoff delay has passed.
+func (q *handoffQueue) deliver(logger *slog.Logger, dialer peerDialer, now time.Time) {
+	q.mutex.Lock()
+	peers := make([]string, 0, len(q.hints))
+
+	for peer, mutations := range q.hints {
+		if len(mutations) > 0 && q.retry(peer).Ready(now) {
+			peers = append(peers, peer)
+		}
+	}
+	q.mutex.Unlock()
+
+	for _, peer := range peers {
+		delivered, err := deliverHints(peer, dialer, q.pending(peer))
+
+		q.mutex.Lock()
+		if err != nil {
+			q.retry(peer).Failure(now)
+			logger.Warn("unable to hand off writes", "peer", peer, "attempt", q.retry(peer).Failures(), "error", err)
+		} else {
+			q.retry(peer).Success()
+		}
+		q.mutex.Unlock()
+
+		if delivered > 0 {
+			logger.Info("handed off writes", "peer", peer, "writes", delivered)
+
+			q.mutex.Lock()
+			q.hints[peer] = q.hints[peer][delivered:]
+			q.mutex.Unlock()
+		}
+	}
+}
+
+// retry returns the backoff of delivery to the peer, must be called with the mutex locked.
+func (q *handoffQueue) retry(peer string) *backoff.Backoff {
+	retry, found := q.retries[peer]
+	if !found {
+		retry = &backoff.Backoff{Policy: q.policy}
+		q.retries[peer] = retry
+	}
+
+	return retry
+}
+
+// deliverHints sends the writes to the peer, returning how many were successfully delivered.
+func deliverHints(peer string, dialer peerDialer, mutations []string) (int, error) {
+	conn, err := dialer.dial(peer)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	for i, mutation := range mutations {
+		if err := reliableWrite(conn, compressed(conn, mutation)); err != nil {
+			return i, err
+		}
+
+		if _, err := reliableRead(conn, len(ackResponse)); err != nil {
+			return i, err
+		}
+	}
+
+	return len(mutations), nil
+}
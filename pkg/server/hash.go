@@ -0,0 +1,82 @@
+package server
+
+import (
+	"sort"
+	"tcp/pkg/kvstore"
+)
+
+func executeHashSet(store kvstore.Store, request *commandRequest) (string, error) {
+	hashes, err := hashStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	if err := hashes.HashSet(request.key, request.field, request.value); err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return ackResponse, nil
+}
+
+func executeHashGet(store kvstore.Store, request *commandRequest) (string, error) {
+	hashes, err := hashStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	value, present, err := hashes.HashGet(request.key, request.field)
+
+	switch {
+	case err != nil:
+		return "", err //nolint:wrapcheck // sent to the client as it is
+
+	case !present:
+		return "nil", nil
+
+	default:
+		return "val" + formatArgument(value), nil
+	}
+}
+
+// executeHashDelete removes the field, acknowledged whether or not it was present, like a delete.
+func executeHashDelete(store kvstore.Store, request *commandRequest) (string, error) {
+	hashes, err := hashStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	if err := hashes.HashDelete(request.key, request.field); err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return ackResponse, nil
+}
+
+// executeHashGetAll returns every field and its value, in field order, as a list alternating between them.
+func executeHashGetAll(store kvstore.Store, request *commandRequest) (string, error) {
+	hashes, err := hashStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	values, err := hashes.HashGetAll(request.key)
+	if err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	fields := make([]string, 0, len(values))
+
+	for field := range values {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	items := make([]string, 0, 2*len(fields))
+
+	for _, field := range fields {
+		items = append(items, field, values[field])
+	}
+
+	return listResponse(items), nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_Hash(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2), &handlerConfig{})
+
+	// writes are replicated as the operations, not the whole hash
+	checkReplicated(t, client, server2, "hst11h11b11x", "hst11h11b11x", ackResponse)
+	checkReplicated(t, client, server2, "hst11h11a11y", "hst11h11a11y", ackResponse)
+	checkRequestResponse(t, client, "hgt11h11a", "val11y")
+	checkRequestResponse(t, client, "hgt11h11c", "nil")
+	checkRequestResponse(t, client, "hga11h", listResponse([]string{"a", "y", "b", "x"}))
+
+	checkReplicated(t, client, server2, "hdl11h11a", "hdl11h11a", ackResponse)
+	checkReplicated(t, client, server2, "hdl11h11c", "hdl11h11c", ackResponse) // not present
+	checkRequestResponse(t, client, "hga11h", listResponse([]string{"b", "x"}))
+	checkRequestResponse(t, client, "hga11z", listResponse(nil))
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_HashWrongType(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "put11s11v", ackResponse)
+	checkRequestResponse(t, client, "hst11s11a11x", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "hga11s", formatError(reasonWrongType))
+
+	checkRequestResponse(t, client, "hst11h11a11x", ackResponse)
+	checkRequestResponse(t, client, "get11h0", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "gck11h", formatError(reasonWrongType))
+
+	// a put replaces the hash
+	checkRequestResponse(t, client, "put11h11v", ackResponse)
+	checkRequestResponse(t, client, "get11h0", "val11v")
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_WatchHash(t *testing.T) {
+	watcherServer, watcherClient := net.Pipe()
+	writerServer, writerClient := net.Pipe()
+
+	store := kvstore.NewKVStore()
+	hub := newWatchHub(0, SlowWatcherDisconnect, 0, 0)
+	kvstore.Observe(store, hub.observe)
+
+	go handle(testLogger, newConnection(watcherServer), store, nil, &handlerConfig{watches: hub})
+	go handle(testLogger, newConnection(writerServer), store, nil, &handlerConfig{watches: hub})
+
+	checkRequestResponse(t, watcherClient, "wch11h", ackResponse)
+
+	checkRequestResponse(t, writerClient, "hst11h11a11x", ackResponse)
+	read(t, watcherClient, setEvent+"11h"+formatArgument(`{"a":"x"}`))
+
+	checkRequestResponse(t, writerClient, "hdl11h11a", ackResponse)
+	read(t, watcherClient, deleteEvent+"11h")
+
+	checkRequestResponse(t, watcherClient, "bye", "")
+	checkRequestResponse(t, writerClient, "bye", "")
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"tcp/pkg/kvstore"
+)
+
+const defaultHotKeysLimit = 10000
+
+// hotKeys counts how often each key is read, so a server that is starting up can fetch the most
+// popular keys from a peer first. Only up to the limit of keys are counted, when full the counts
+// are halved so keys that are no longer popular are forgotten. A nil hotKeys counts nothing.
+type hotKeys struct {
+	mutex  sync.Mutex
+	limit  int
+	counts map[string]uint64
+}
+
+func newHotKeys(limit int) *hotKeys {
+	if limit < 1 {
+		limit = defaultHotKeysLimit
+	}
+
+	return &hotKeys{limit: limit, counts: make(map[string]uint64)}
+}
+
+// record counts a read of the key.
+func (h *hotKeys) record(key string) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, found := h.counts[key]; !found && len(h.counts) >= h.limit {
+		h.decay()
+
+		if len(h.counts) >= h.limit {
+			return
+		}
+	}
+
+	h.counts[key]++
+}
+
+// decay halves every count, forgetting keys only read once since the last decay.
+func (h *hotKeys) decay() {
+	for key, count := range h.counts {
+		if count < 2 {
+			delete(h.counts, key)
+		} else {
+			h.counts[key] = count / 2
+		}
+	}
+}
+
+// hottest returns up to n of the most read keys, most read first.
+func (h *hotKeys) hottest(n int) []string {
+	if h == nil {
+		return nil
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	keys := make([]string, 0, len(h.counts))
+	for key := range h.counts {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if h.counts[keys[i]] != h.counts[keys[j]] {
+			return h.counts[keys[i]] > h.counts[keys[j]]
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+
+	return keys
+}
+
+// listResponse returns a response listing the items: lst, the number of items, then each item,
+// all as arguments.
+func listResponse(items []string) string {
+	response := "lst" + formatArgument(strconv.Itoa(len(items)))
+
+	for _, item := range items {
+		response += formatArgument(item)
+	}
+
+	return response
+}
+
+// readList reads a list response.
+func readList(reader io.Reader) ([]string, error) {
+	response, err := reliableRead(reader, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	if response != "lst" {
+		return nil, fmt.Errorf("%w: %s", errUnexpectedResponse, response)
+	}
+
+	countText, err := readArgument(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(countText)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing number: %w", err)
+	}
+
+	items := make([]string, count)
+
+	for i := range items {
+		if items[i], err = readArgument(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
+// warmUp fetches the peer's n most read keys, and their values, into the store so they can
+// be served as soon as the server starts. Returns how many keys were fetched.
+func warmUp(logger *slog.Logger, peer string, dialer peerDialer, n int, store *kvstore.KVStore) (int, error) {
+	conn, err := dialer.dial(peer)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := reliableWrite(conn, "hot"+formatArgument(strconv.Itoa(n))); err != nil {
+		return 0, err
+	}
+
+	keys, err := readList(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Info("warming up with hot keys", "peer", peer, "keys", len(keys))
+
+	fetched := 0
+
+	for _, key := range keys {
+		if err := reliableWrite(conn, "get"+formatArgument(key)+"0"); err != nil {
+			return fetched, err
+		}
+
+		response, err := reliableRead(conn, 3)
+		if err != nil {
+			return fetched, err
+		}
+
+		if response != "val" {
+			// no longer present
+			continue
+		}
+
+		value, err := readArgument(conn)
+		if err != nil {
+			return fetched, err
+		}
+
+		kvstore.Write(store, key, value)
+		fetched++
+	}
+
+	return fetched, nil
+}
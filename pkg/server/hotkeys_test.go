@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_hotKeys_hottest(t *testing.T) {
+	keys := newHotKeys(10)
+
+	for i := 0; i < 3; i++ {
+		keys.record("a")
+	}
+
+	keys.record("b")
+	keys.record("c")
+	keys.record("c")
+
+	if actual := keys.hottest(2); !reflect.DeepEqual(actual, []string{"a", "c"}) {
+		t.Error("Wrong hottest keys: ", actual)
+	}
+
+	if actual := keys.hottest(10); !reflect.DeepEqual(actual, []string{"a", "c", "b"}) {
+		t.Error("Wrong hottest keys: ", actual)
+	}
+}
+
+func Test_hotKeys_Decay(t *testing.T) {
+	keys := newHotKeys(2)
+
+	keys.record("a")
+	keys.record("a")
+	keys.record("b")
+
+	// full, so counts are halved and b (only read once) is forgotten
+	keys.record("c")
+
+	if actual := keys.hottest(10); !reflect.DeepEqual(actual, []string{"a", "c"}) {
+		t.Error("Wrong hottest keys: ", actual)
+	}
+}
+
+func Test_hotKeys_Nil(t *testing.T) {
+	var keys *hotKeys
+
+	keys.record("a")
+
+	if actual := keys.hottest(10); len(actual) != 0 {
+		t.Error("Expected no keys but got: ", actual)
+	}
+}
+
+func Test_handle_HotKeys(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+	kvstore.Write(store, "a", "1")
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{hotKeys: newHotKeys(0)})
+
+	checkRequestResponse(t, client, "get11a0", "val111")
+	checkRequestResponse(t, client, "get11b0", "nil")
+	checkRequestResponse(t, client, "get11a0", "val111")
+	checkRequestResponse(t, client, "hot115", "lst112"+formatArgument("a")+formatArgument("b"))
+	checkRequestResponse(t, client, "bye", "") // shutdown
+}
+
+func Test_warmUp(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	peerStore := kvstore.NewKVStore()
+	kvstore.Write(peerStore, "a", "1")
+	kvstore.Write(peerStore, "b", "2")
+	kvstore.Write(peerStore, "c", "3")
+
+	hot := newHotKeys(0)
+	hot.record("a")
+	hot.record("a")
+	hot.record("b")
+	hot.record("c")
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		handle(testLogger, newConnection(conn), peerStore, nil, &handlerConfig{hotKeys: hot})
+	}()
+
+	store := kvstore.NewKVStore()
+
+	fetched, err := warmUp(testLogger, listener.Addr().String(), peerDialer{}, 2, store)
+	if err != nil {
+		t.Fatal("Expected successful but got: ", err)
+	}
+
+	if fetched != 2 {
+		t.Errorf("Expected 2 keys fetched but got %d", fetched)
+	}
+
+	for key, expected := range map[string]string{"a": "1", "b": "2"} {
+		if value, present := kvstore.Read(store, key); !present || value != expected {
+			t.Errorf("Expected %s for key %s but got %s", expected, key, value)
+		}
+	}
+
+	if _, present := kvstore.Read(store, "c"); present {
+		t.Error("Expected key c not to be fetched")
+	}
+}
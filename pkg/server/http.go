@@ -0,0 +1,235 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// path of the HTTP gateway's resources, followed by the key
+const keysPath = "/keys/"
+
+// how long the HTTP gateway waits for a request's headers
+const httpReadHeaderTimeout = 10 * time.Second
+
+var errGatewayResponse = errors.New("unexpected response to gateway command")
+
+// gatewayAddr is the address of the HTTP client a gateway command was sent on behalf of,
+// so it is rate limited and logged the same as a client connection from that address.
+type gatewayAddr string
+
+func (a gatewayAddr) Network() string {
+	return "tcp"
+}
+
+func (a gatewayAddr) String() string {
+	return string(a)
+}
+
+// gatewayConn is the server end of the in-process connection a gateway request's commands are sent over.
+type gatewayConn struct {
+	net.Conn
+	remote gatewayAddr
+}
+
+func (c *gatewayConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// serveHTTP handles HTTP requests on the listener until Shutdown is called, when it returns ErrServerClosed.
+// Each request is performed as commands from a client connection, so is authenticated, rate limited,
+// replicated and logged the same as commands from any other client.
+func (s *Server) serveHTTP(logger *slog.Logger, listener net.Listener, httpServer *http.Server) error {
+	httpServer.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handleHTTP(logger, w, r)
+	})
+
+	err := httpServer.Serve(listener)
+	if s.isClosing() || errors.Is(err, http.ErrServerClosed) {
+		return ErrServerClosed
+	}
+
+	return fmt.Errorf("error serving HTTP: %w", err)
+}
+
+// handleHTTP maps GET, PUT and DELETE of /keys/{key} onto the get, put and del commands. A bearer token
+// in the Authorization header is sent as an auth command first. Requests to /ws are upgraded to
+// WebSockets carrying commands.
+func (s *Server) handleHTTP(logger *slog.Logger, w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == websocketPath {
+		s.handleWebSocket(logger, w, r)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, keysPath)
+	if !strings.HasPrefix(r.URL.Path, keysPath) || key == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	command, ok := s.httpCommand(w, r, key)
+	if !ok {
+		return
+	}
+
+	commands := []string{command}
+
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		commands = append([]string{"auth" + formatArgument(token)}, commands...)
+	}
+
+	status, body, err := s.performGatewayCommands(logger, gatewayAddr(r.RemoteAddr), commands)
+	if err != nil {
+		logger.Warn("unable to perform HTTP request", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "unable to perform request", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	if body == "" {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, body)
+}
+
+// httpCommand returns the command equivalent to the HTTP request for the key, or false if the request
+// can't be performed, after writing the error response.
+func (s *Server) httpCommand(w http.ResponseWriter, r *http.Request, key string) (string, bool) {
+	switch r.Method {
+	case http.MethodGet:
+		return "get" + formatArgument(key) + "0", true
+
+	case http.MethodPut:
+		// rejected without reading the value, if its declared length is already too large
+		if r.ContentLength > int64(s.clientConfig.commandSizeLimit()) {
+			http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+			return "", false
+		}
+
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(s.clientConfig.commandSizeLimit())))
+		if err != nil {
+			http.Error(w, "unable to read value", http.StatusRequestEntityTooLarge)
+			return "", false
+		}
+
+		return "put" + formatArgument(key) + formatArgument(string(value)), true
+
+	case http.MethodDelete:
+		return "del" + formatArgument(key), true
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return "", false
+	}
+}
+
+// performGatewayCommands sends the commands over a new in-process client connection, stopping at the first
+// that doesn't succeed, returning the HTTP status and body of the last response.
+func (s *Server) performGatewayCommands(logger *slog.Logger, remote gatewayAddr, commands []string) (
+	int, string, error) {
+	clientEnd, serverEnd := net.Pipe()
+
+	defer func() {
+		_ = clientEnd.Close()
+	}()
+
+	c, err := s.track(&gatewayConn{serverEnd, remote}, s.clientConfig)
+	if err != nil {
+		_ = serverEnd.Close()
+		return 0, "", err
+	}
+
+	go func() {
+		defer s.untrack(c)
+
+		s.openConnectionsAndHandle(logger, c, s.clientConfig)
+	}()
+
+	var status int
+
+	var body string
+
+	for _, command := range commands {
+		if err := reliableWrite(clientEnd, command); err != nil {
+			return 0, "", err
+		}
+
+		status, body, err = readGatewayResponse(clientEnd)
+		if err != nil || status >= http.StatusBadRequest {
+			break
+		}
+	}
+
+	if err == nil {
+		// may fail if the server is shutting down, which has already closed the connection
+		_ = reliableWrite(clientEnd, closeRequest)
+	}
+
+	return status, body, err
+}
+
+// readGatewayResponse reads a command response, returning the equivalent HTTP status and body.
+func readGatewayResponse(reader io.Reader) (int, string, error) {
+	response, err := reliableRead(reader, 3)
+	if err != nil {
+		return 0, "", err
+	}
+
+	switch response {
+	case ackResponse:
+		return http.StatusNoContent, "", nil
+
+	case warningResponse:
+		// applied locally only
+		return http.StatusAccepted, "", nil
+
+	case "nil":
+		return http.StatusNotFound, "", nil
+
+	case "val":
+		value, err := readArgument(reader)
+
+		return http.StatusOK, value, err
+
+	case throttleResponse:
+		return http.StatusTooManyRequests, "", nil
+
+	case errorResponse:
+		code, err := reliableRead(reader, 3)
+		if err != nil {
+			return 0, "", err
+		}
+
+		reason, err := readArgument(reader)
+
+		return errorStatus(code), reason, err
+
+	default:
+		return 0, "", fmt.Errorf("%w: %s", errGatewayResponse, response)
+	}
+}
+
+// errorStatus returns the HTTP status equivalent to the 3 digit code of an error reason.
+func errorStatus(code string) int {
+	switch code[0] {
+	case '1', '4':
+		return http.StatusBadRequest
+
+	case '2':
+		return http.StatusForbidden
+
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_Server_HTTP(t *testing.T) {
+	store := kvstore.NewKVStore()
+	srv := NewServer(store, Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		HTTPHostnamePort:   "127.0.0.1:0",
+		ACL:                NewSharedSecretACL("secret"),
+		MaxCommandSize:     64,
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	served := make(chan error)
+
+	go func() {
+		served <- srv.Serve()
+	}()
+
+	url := "http://" + srv.HTTPAddr().String() + keysPath
+
+	checkHTTP(t, http.MethodPut, url+"a%2Fb", "", "123", http.StatusForbidden, "unauthorised")
+	checkHTTP(t, http.MethodPut, url+"a%2Fb", "wrong", "123", http.StatusForbidden, "auth_failed")
+	checkHTTP(t, http.MethodPut, url+"a%2Fb", "secret", "123", http.StatusNoContent, "")
+	checkHTTP(t, http.MethodGet, url+"a%2Fb", "secret", "", http.StatusOK, "123")
+	checkHTTP(t, http.MethodDelete, url+"a%2Fb", "secret", "", http.StatusNoContent, "")
+	checkHTTP(t, http.MethodGet, url+"a%2Fb", "secret", "", http.StatusNotFound, "")
+	checkHTTP(t, http.MethodPut, url+"a%2Fb", "secret", strings.Repeat("x", 100), http.StatusRequestEntityTooLarge,
+		"value too large\n")
+	checkHTTP(t, http.MethodPost, url+"a%2Fb", "secret", "", http.StatusMethodNotAllowed, "method not allowed\n")
+	checkHTTP(t, http.MethodGet, url, "secret", "", http.StatusNotFound, "404 page not found\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_errorStatus(t *testing.T) {
+	tests := map[string]int{
+		reasonCodes[reasonInvalidCommand]: http.StatusBadRequest,
+		reasonCodes[reasonUnauthorised]:   http.StatusForbidden,
+		reasonCodes[reasonTimeout]:        http.StatusServiceUnavailable,
+		reasonCodes[reasonUnknownOption]:  http.StatusBadRequest,
+		reasonCodes[reasonReadOnly]:       http.StatusServiceUnavailable,
+		unknownReasonCode:                 http.StatusServiceUnavailable,
+	}
+
+	for code, expected := range tests {
+		if status := errorStatus(code); status != expected {
+			t.Errorf("Expected status %d for code %s but got %d", expected, code, status)
+		}
+	}
+}
+
+func checkHTTP(t *testing.T, method string, url string, token string, body string, expectedStatus int,
+	expectedBody string) {
+	t.Helper()
+
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal("Unable to create request: ", err)
+	}
+
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Unable to send request: ", err)
+	}
+
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal("Unable to read response: ", err)
+	}
+
+	if response.StatusCode != expectedStatus || string(responseBody) != expectedBody {
+		t.Errorf("%s %s: expected %d %q but got %d %q", method, url, expectedStatus, expectedBody,
+			response.StatusCode, responseBody)
+	}
+}
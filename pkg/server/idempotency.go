@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIdempotencyWindow = 5 * time.Minute
+	defaultIdempotencyLimit  = 10000
+)
+
+// idempotencyCache remembers the responses to recent writes sent with an idempotency key, so a client
+// retrying a write gets the original response rather than the write being applied again. Keys are
+// forgotten once older than the window, or when over the limit (oldest first).
+type idempotencyCache struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	limit   int
+	entries map[string]idempotencyEntry
+	order   []string
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	response    string
+	added       time.Time
+}
+
+func newIdempotencyCache(window time.Duration, limit int) *idempotencyCache {
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+
+	if limit < 1 {
+		limit = defaultIdempotencyLimit
+	}
+
+	return &idempotencyCache{window: window, limit: limit, entries: make(map[string]idempotencyEntry)}
+}
+
+// lookup returns the response previously sent for the key, if any, and whether the key was
+// previously used with a different write.
+func (c *idempotencyCache) lookup(key string, request *commandRequest, now time.Time) (string, bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.expire(now)
+
+	entry, found := c.entries[key]
+	if !found {
+		return "", false, false
+	}
+
+	return entry.response, true, entry.fingerprint != fingerprint(request)
+}
+
+// add remembers the response sent for the key.
+func (c *idempotencyCache) add(key string, request *commandRequest, response string, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, found := c.entries[key]; !found {
+		c.order = append(c.order, key)
+	}
+
+	c.entries[key] = idempotencyEntry{fingerprint(request), response, now}
+
+	for len(c.order) > c.limit {
+		c.forgetOldest()
+	}
+}
+
+// expire forgets keys older than the window.
+func (c *idempotencyCache) expire(now time.Time) {
+	for len(c.order) > 0 && now.Sub(c.entries[c.order[0]].added) > c.window {
+		c.forgetOldest()
+	}
+}
+
+func (c *idempotencyCache) forgetOldest() {
+	delete(c.entries, c.order[0])
+	c.order = c.order[1:]
+}
+
+// fingerprint identifies the change made by a write, the same for all the ways of writing a value
+// (since a put may be replicated as a put with expiry).
+func fingerprint(request *commandRequest) string {
+	switch request.command {
+	case deleteCommand:
+		return "del " + request.key
+
+	case persistCommand:
+		return "prs " + request.key
+
+	case hashSetCommand, hashDeleteCommand:
+		return request.name() + " " + request.key + " " + request.field + " " + request.value
+
+	case listPushLeftCommand, listPushRightCommand, listPopLeftCommand, listPopRightCommand, memberAddCommand,
+		memberRemoveCommand, scoreRemoveCommand:
+		return request.name() + " " + request.key + " " + request.value
+
+	case scoreAddCommand:
+		return fmt.Sprintf("zad %s %s %g", request.key, request.value, request.score)
+
+	case setBitCommand:
+		return fmt.Sprintf("sbt %s %d %s", request.key, request.length, request.value)
+
+	case listTrimCommand:
+		return fmt.Sprintf("ltr %s %d %d", request.key, request.start, request.stop)
+	}
+
+	return "put " + request.key + " " + request.value
+}
+
+// withIdempotencyKey returns the write prefixed by its idempotency key, so peers also remember it.
+func withIdempotencyKey(request *commandRequest, key string) *commandRequest {
+	prefixed := *request
+	prefixed.originalText = "idk" + formatArgument(key) + request.originalText
+
+	return &prefixed
+}
+
+// performIdempotent performs a write sent with an idempotency key, unless it has already been
+// performed, returning the response and the reason if it failed.
+func (s *session) performIdempotent(command *commandRequest, key string, timing *commandTiming) (string, string) {
+	cache := s.config.idempotency
+
+	previous, found, conflict := cache.lookup(key, command, time.Now())
+
+	switch {
+	case conflict:
+		s.logger.Info("rejecting write, idempotency key used by a different write", "command", command.originalText)
+		return errorResponse, reasonIdempotencyConflict
+
+	case found:
+		s.logger.Info("write already performed, returning original response", "command", command.originalText)
+		return previous, ""
+	}
+
+	response, reason := s.perform(withIdempotencyKey(command, key), timing)
+
+	if writeApplied(response) || response == warningResponse {
+		cache.add(key, command, response, time.Now())
+	}
+
+	return response, reason
+}
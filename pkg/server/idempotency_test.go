@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_idempotencyCache(t *testing.T) {
+	cache := newIdempotencyCache(time.Minute, 2)
+	now := time.Now()
+	put := &commandRequest{command: putCommand, key: "a", value: "b", originalText: "put11a11b"}
+	putExpiry := &commandRequest{command: putExpiryCommand, key: "a", value: "b", length: 1000,
+		originalText: "pex11a11b141000"}
+	del := &commandRequest{command: deleteCommand, key: "a", originalText: "del11a"}
+
+	checkLookup(t, cache, "k1", put, now, "", false, false) // not yet seen
+
+	cache.add("k1", put, "ack", now)
+
+	checkLookup(t, cache, "k1", put, now, "ack", true, false)       // retry
+	checkLookup(t, cache, "k1", putExpiry, now, "ack", true, false) // same write, replicated with a TTL
+	checkLookup(t, cache, "k1", del, now, "ack", true, true)        // key reused for another write
+
+	cache.add("k2", del, "ack", now)
+	cache.add("k3", del, "wrn", now)
+
+	checkLookup(t, cache, "k1", put, now, "", false, false) // forgotten, over the limit
+	checkLookup(t, cache, "k3", del, now, "wrn", true, false)
+
+	checkLookup(t, cache, "k3", del, now.Add(2*time.Minute), "", false, false) // forgotten, outside window
+}
+
+func Test_handle_IdempotencyKey(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server1), store, connectedPeers(peer2),
+		&handlerConfig{idempotency: newIdempotencyCache(0, 0)})
+
+	// replicated along with the key
+	checkDistributedRequestResponse(t, client, "idk13abcput12bb13999", []net.Conn{server2}, "ack")
+
+	// retry returns the original response, without replicating again
+	checkRequestResponse(t, client, "idk13abcput12bb13999", "ack")
+
+	// key reused for a different write
+	checkRequestResponse(t, client, "idk13abcput12bb11x", formatError(reasonIdempotencyConflict))
+
+	checkRequestResponse(t, client, "get12bb0", "val13999") // first write only applied once
+	checkRequestResponse(t, client, "bye", "")              // shutdown
+}
+
+func checkLookup(t *testing.T, cache *idempotencyCache, key string, request *commandRequest, now time.Time,
+	expectedResponse string, expectedFound bool, expectedConflict bool) {
+	t.Helper()
+
+	response, found, conflict := cache.lookup(key, request, now)
+
+	if response != expectedResponse || found != expectedFound || conflict != expectedConflict {
+		t.Errorf("Expected %s, %t, %t for key %s but got %s, %t, %t", expectedResponse, expectedFound,
+			expectedConflict, key, response, found, conflict)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"tcp/pkg/kvstore"
+	"time"
+)
+
+// the number of seconds the commands per second are averaged over
+const opsWindowSeconds = 10
+
+// commandCounter counts the commands handled, overall and in each of the last few seconds.
+// A nil commandCounter counts nothing.
+type commandCounter struct {
+	mutex   sync.Mutex
+	total   uint64
+	buckets [opsWindowSeconds + 1]struct {
+		second int64
+		count  uint64
+	}
+}
+
+// add counts a command handled now.
+func (c *commandCounter) add(now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	second := now.Unix()
+	bucket := &c.buckets[second%int64(len(c.buckets))]
+
+	if bucket.second != second {
+		bucket.second = second
+		bucket.count = 0
+	}
+
+	bucket.count++
+	c.total++
+}
+
+// rate returns the total commands handled, and the average commands per second over the
+// last complete seconds.
+func (c *commandCounter) rate(now time.Time) (uint64, float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var count uint64
+
+	for _, bucket := range c.buckets {
+		if age := now.Unix() - bucket.second; age >= 1 && age <= opsWindowSeconds {
+			count += bucket.count
+		}
+	}
+
+	return c.total, float64(count) / opsWindowSeconds
+}
+
+// info returns the status of the server, its store and replication, as space-separated name=value pairs.
+func (s *Server) info() string {
+	now := time.Now()
+	total, opsPerSecond := s.commands.rate(now)
+
+	var memory runtime.MemStats
+
+	runtime.ReadMemStats(&memory)
+
+	s.mutex.Lock()
+	clients := s.counts[s.clientConfig]
+	readOnly := s.readOnly
+	origins, repairedKeys := s.origins, s.repairedKeys
+	s.mutex.Unlock()
+
+	watches := s.watches.stats()
+	pooled := s.peerPool.all()
+	peers := make([]string, 0, len(pooled))
+
+	var queues map[string]asyncStats
+
+	if s.replicator != nil {
+		queues = s.replicator.stats()
+	}
+
+	for _, pooledPeer := range pooled {
+		peer := pooledPeer.address
+		redo, redoDropped := pooledPeer.redoLength()
+		outbound := pooledPeer.queue.stats()
+
+		fields := fmt.Sprintf("peer.%s=%s peer.%s.handoff=%d peer.%s.redo=%d peer.%s.redo_dropped=%d",
+			peer, pooledPeer.state(), peer, len(s.handoff.pending(peer)), peer, redo, peer, redoDropped)
+
+		fields += fmt.Sprintf(" peer.%s.outbound=%d peer.%s.outbound_peak=%d peer.%s.outbound_shed=%d"+
+			" peer.%s.outbound_degraded=%d", peer, outbound.depth, peer, outbound.peak, peer, outbound.shed, peer,
+			outbound.degraded)
+
+		if s.replicator != nil {
+			fields += fmt.Sprintf(" peer.%s.queue=%d peer.%s.dropped=%d", peer, queues[peer].depth, peer,
+				queues[peer].dropped)
+		}
+
+		peers = append(peers, fields)
+	}
+
+	sort.Strings(peers)
+
+	fields := []string{
+		fmt.Sprintf("uptime_seconds=%d", int(now.Sub(s.started).Seconds())),
+		fmt.Sprintf("keys=%d", kvstore.Count(s.store)),
+		fmt.Sprintf("clients=%d", clients),
+		fmt.Sprintf("read_only=%t", readOnly),
+		fmt.Sprintf("role=%s", s.config.Role),
+		fmt.Sprintf("commands=%d", total),
+		fmt.Sprintf("ops_per_sec=%.1f", opsPerSecond),
+		fmt.Sprintf("watchers=%d watched_keys=%d watch_policy=%s watch_dropped=%d watch_disconnected=%d",
+			watches.watchers, watches.keys, watches.policy, watches.dropped, watches.disconnected),
+		fmt.Sprintf("subscribed_channels=%d keyspace_events=%s", watches.channels, watches.keyspaceEvents),
+	}
+
+	if node := s.raft(); node != nil {
+		fields = append(fields, raftInfo(node)...)
+	}
+
+	if origins != nil {
+		fields = append(fields, fmt.Sprintf("origin_dropped_writes=%d", origins.droppedWrites()))
+	}
+
+	if s.antiEntropyEnabled() {
+		fields = append(fields, fmt.Sprintf("anti_entropy_repaired_keys=%d", repairedKeys))
+	}
+
+	fields = append(fields, peers...)
+	fields = append(fields,
+		fmt.Sprintf("goroutines=%d", runtime.NumGoroutine()),
+		fmt.Sprintf("heap_alloc_bytes=%d", memory.HeapAlloc),
+		fmt.Sprintf("sys_bytes=%d", memory.Sys),
+		fmt.Sprintf("gc_count=%d", memory.NumGC))
+
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_commandCounter_rate(t *testing.T) {
+	counter := &commandCounter{}
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 20; i++ {
+		counter.add(now.Add(-2 * time.Second))
+	}
+
+	counter.add(now.Add(-20 * time.Second)) // too old to affect the rate
+	counter.add(now)                        // current second isn't complete
+
+	total, opsPerSecond := counter.rate(now)
+
+	if total != 22 {
+		t.Errorf("Expected 22 commands but got %d", total)
+	}
+
+	if opsPerSecond != 2 {
+		t.Errorf("Expected 2 commands per second but got %v", opsPerSecond)
+	}
+}
+
+func Test_Server_Info(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		OtherServers:       []string{"127.0.0.1:1"},
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(context.Background())
+
+	client, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	checkRequestResponse(t, client, "get12bb0", "nil")
+	write(t, client, "inf")
+
+	info := readValue(t, client)
+
+	for _, expected := range []string{"keys=0", "clients=1", "commands=2", "peer.127.0.0.1:1=down"} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("Expected info to contain %s but got: %s", expected, info)
+		}
+	}
+}
+
+// readValue reads a val response, returning its argument.
+func readValue(t *testing.T, conn net.Conn) string {
+	t.Helper()
+
+	response := make([]byte, 3)
+	if _, err := io.ReadFull(conn, response); err != nil || string(response) != "val" {
+		t.Fatalf("Expected val response but got %s: %v", response, err)
+	}
+
+	value, err := readArgument(conn)
+	if err != nil {
+		t.Fatal("Error reading value: ", err)
+	}
+
+	return value
+}
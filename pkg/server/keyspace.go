@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"tcp/pkg/kvstore"
+)
+
+// KeyspaceEvents are the classes of change to keys published as keyspace notifications, so clients can
+// subscribe to them (such as to invalidate their caches) rather than watching each key.
+//
+// Each change of an enabled class is published to the key's keyspace channel, __keyspace__:<key>, with
+// the class as the message, and to the class's keyevent channel, __keyevent__:<class>, with the key as the
+// message, as in Redis.
+type KeyspaceEvents int
+
+const (
+	// KeyspaceSet is a key set, or a collection changed.
+	KeyspaceSet KeyspaceEvents = 1 << iota
+
+	// KeyspaceDelete is a key deleted, or removed by every key being flushed.
+	KeyspaceDelete KeyspaceEvents = 1 << iota
+
+	// KeyspaceExpire is a key removed by expiring.
+	KeyspaceExpire KeyspaceEvents = 1 << iota
+
+	// KeyspaceEvict is a key removed to free up space, which the in-memory store never does.
+	KeyspaceEvict KeyspaceEvents = 1 << iota
+
+	// KeyspaceAll is every class of change.
+	KeyspaceAll = KeyspaceSet | KeyspaceDelete | KeyspaceExpire | KeyspaceEvict
+)
+
+const (
+	// message published to a channel, followed by the channel and the message
+	messageEvent = "evm"
+
+	// the channels keyspace notifications are published to, followed by the key or the class of change
+	keyspaceChannelPrefix = "__keyspace__:"
+	keyeventChannelPrefix = "__keyevent__:"
+)
+
+var errUnknownKeyspaceEvent = errors.New("unknown keyspace event")
+
+// keyspaceEventNames lists the name of each class of change, in order.
+var keyspaceEventNames = []struct {
+	events KeyspaceEvents
+	name   string
+}{
+	{KeyspaceSet, "set"},
+	{KeyspaceDelete, "del"},
+	{KeyspaceExpire, "expire"},
+	{KeyspaceEvict, "evict"},
+}
+
+// ParseKeyspaceEvents returns the classes of change in the comma separated list of names: set, del,
+// expire, evict, or all of them. None if the list is empty.
+func ParseKeyspaceEvents(names string) (KeyspaceEvents, error) {
+	var events KeyspaceEvents
+
+	if names == "" {
+		return events, nil
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		parsed, found := keyspaceEventsNamed(strings.TrimSpace(name))
+		if !found {
+			return 0, fmt.Errorf("%w: %s", errUnknownKeyspaceEvent, name)
+		}
+
+		events |= parsed
+	}
+
+	return events, nil
+}
+
+func keyspaceEventsNamed(name string) (KeyspaceEvents, bool) {
+	if name == "all" {
+		return KeyspaceAll, true
+	}
+
+	for _, event := range keyspaceEventNames {
+		if event.name == name {
+			return event.events, true
+		}
+	}
+
+	return 0, false
+}
+
+func (e KeyspaceEvents) String() string {
+	names := make([]string, 0, len(keyspaceEventNames))
+
+	for _, event := range keyspaceEventNames {
+		if e&event.events != 0 {
+			names = append(names, event.name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
+
+// keyspaceEventOf returns the class of the change.
+func keyspaceEventOf(change kvstore.Change) KeyspaceEvents {
+	switch {
+	case change.Evicted:
+		return KeyspaceEvict
+
+	case change.Expired:
+		return KeyspaceExpire
+
+	case change.Deleted:
+		return KeyspaceDelete
+
+	default:
+		return KeyspaceSet
+	}
+}
+
+// isKeyspaceChannel returns whether messages on the channel are about a single key, returning the key.
+func isKeyspaceChannel(channel string) (string, bool) {
+	return strings.CutPrefix(channel, keyspaceChannelPrefix)
+}
+
+// isKeyeventChannel returns whether messages on the channel name the keys with a class of change.
+func isKeyeventChannel(channel string) bool {
+	return strings.HasPrefix(channel, keyeventChannelPrefix)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_ParseKeyspaceEvents(t *testing.T) {
+	tests := []struct {
+		names    string
+		expected KeyspaceEvents
+	}{
+		{names: "", expected: 0},
+		{names: "set", expected: KeyspaceSet},
+		{names: "del, expire", expected: KeyspaceDelete | KeyspaceExpire},
+		{names: "all", expected: KeyspaceAll},
+	}
+
+	for _, test := range tests {
+		if events, err := ParseKeyspaceEvents(test.names); events != test.expected || err != nil {
+			t.Errorf("Expected %s for %q but got %s, %v", test.expected, test.names, events, err)
+		}
+	}
+
+	if _, err := ParseKeyspaceEvents("set,evicted"); err == nil {
+		t.Error("Expected an error for an unknown event")
+	}
+
+	if names := (KeyspaceSet | KeyspaceEvict).String(); names != "set,evict" {
+		t.Errorf("Expected set,evict but got %s", names)
+	}
+}
+
+func Test_handle_KeyspaceNotifications(t *testing.T) {
+	subscriberServer, subscriberClient := net.Pipe()
+	writerServer, writerClient := net.Pipe()
+
+	store := kvstore.NewKVStore()
+	hub := newWatchHub(0, SlowWatcherDisconnect, 0, KeyspaceSet|KeyspaceExpire)
+	kvstore.Observe(store, hub.observe)
+
+	go handle(testLogger, newConnection(subscriberServer), store, nil, &handlerConfig{watches: hub})
+	go handle(testLogger, newConnection(writerServer), store, nil, &handlerConfig{watches: hub})
+
+	checkRequestResponse(t, subscriberClient, "sub214__keyspace__:a", ackResponse)
+	checkRequestResponse(t, subscriberClient, "sub216__keyevent__:set", ackResponse)
+
+	checkRequestResponse(t, writerClient, "put11a13123", ackResponse)
+	read(t, subscriberClient, messageEvent+"214__keyspace__:a13set")
+	read(t, subscriberClient, messageEvent+"216__keyevent__:set11a")
+
+	// deletes aren't enabled, so not published
+	checkRequestResponse(t, writerClient, "del11a", ackResponse)
+
+	checkRequestResponse(t, writerClient, "pex11b13456111", ackResponse)
+	read(t, subscriberClient, messageEvent+"216__keyevent__:set11b")
+
+	checkRequestResponse(t, writerClient, "pex11a13456111", ackResponse)
+	read(t, subscriberClient, messageEvent+"214__keyspace__:a13set")
+	read(t, subscriberClient, messageEvent+"216__keyevent__:set11a")
+
+	time.Sleep(5 * time.Millisecond)
+	checkRequestResponse(t, writerClient, "get11a0", "nil")
+	read(t, subscriberClient, messageEvent+"214__keyspace__:a16expire")
+
+	if stats := hub.stats(); stats.watchers != 1 || stats.channels != 2 {
+		t.Errorf("Expected 1 subscriber to 2 channels but got %d to %d", stats.watchers, stats.channels)
+	}
+
+	checkRequestResponse(t, subscriberClient, "usb216__keyevent__:set", ackResponse)
+	checkRequestResponse(t, writerClient, "put11c13789", ackResponse)
+	checkRequestResponse(t, subscriberClient, "png", pongResponse)
+
+	checkRequestResponse(t, subscriberClient, "bye", "")
+	checkRequestResponse(t, writerClient, "bye", "")
+}
+
+func Test_User_permitsChannel(t *testing.T) {
+	restricted := &User{KeyPrefixes: []string{"team1/"}}
+	unrestricted := &User{}
+
+	tests := []struct {
+		user     *User
+		channel  string
+		expected bool
+	}{
+		{user: restricted, channel: "__keyspace__:team1/a", expected: true},
+		{user: restricted, channel: "__keyspace__:team2/a", expected: false},
+		{user: restricted, channel: "__keyevent__:set", expected: false},
+		{user: restricted, channel: "news", expected: true},
+		{user: unrestricted, channel: "__keyevent__:set", expected: true},
+	}
+
+	for _, test := range tests {
+		if permitted := test.user.permitsChannel(test.channel); permitted != test.expected {
+			t.Errorf("Expected %t for %s but got %t", test.expected, test.channel, permitted)
+		}
+	}
+}
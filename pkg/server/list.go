@@ -0,0 +1,87 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"tcp/pkg/kvstore"
+)
+
+// executeListPush pushes the value, acknowledged rather than returning the list's length, so replicating
+// it to a peer is acknowledged whatever the length of the peer's copy.
+func executeListPush(store kvstore.Store, request *commandRequest) (string, error) {
+	lists, err := listStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	if err := lists.ListPush(request.key, request.value, request.command == listPushLeftCommand); err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return ackResponse, nil
+}
+
+func executeListPop(store kvstore.Store, request *commandRequest) (string, error) {
+	lists, err := listStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	value, found, err := lists.ListPop(request.key, request.command == listPopLeftCommand)
+
+	switch {
+	case err != nil:
+		return "", err //nolint:wrapcheck // sent to the client as it is
+
+	case !found:
+		return "nil", nil
+
+	default:
+		return "val" + formatArgument(value), nil
+	}
+}
+
+func executeListRange(store kvstore.Store, request *commandRequest) (string, error) {
+	lists, err := listStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	elements, err := lists.ListRange(request.key, request.start, request.stop)
+	if err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return listResponse(elements), nil
+}
+
+func executeListTrim(store kvstore.Store, request *commandRequest) (string, error) {
+	lists, err := listStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	if err := lists.ListTrim(request.key, request.start, request.stop); err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return ackResponse, nil
+}
+
+// madePop returns the trim removing the element popped locally, so peers remove the same end's element
+// rather than each popping whatever its copy holds, or nil if the list was empty.
+func madePop(request *commandRequest, response string) *commandRequest {
+	if !strings.HasPrefix(response, "val") {
+		return nil
+	}
+
+	made := &commandRequest{command: listTrimCommand, key: request.key, start: 0, stop: -2, applied: true}
+	if request.command == listPopLeftCommand {
+		made.start, made.stop = 1, -1
+	}
+
+	made.originalText = "ltr" + formatArgument(request.key) + formatArgument(strconv.Itoa(made.start)) +
+		formatArgument(strconv.Itoa(made.stop))
+
+	return made
+}
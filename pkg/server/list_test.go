@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_List(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2), &handlerConfig{})
+
+	// pushes are replicated as the operations, not the whole list
+	checkReplicated(t, client, server2, "rpu11l11b", "rpu11l11b", ackResponse)
+	checkReplicated(t, client, server2, "lpu11l11a", "lpu11l11a", ackResponse)
+	checkReplicated(t, client, server2, "rpu11l11c", "rpu11l11c", ackResponse)
+	checkRequestResponse(t, client, "lrg11l11012-1", listResponse([]string{"a", "b", "c"}))
+	checkRequestResponse(t, client, "lrg11l12-212-1", listResponse([]string{"b", "c"}))
+
+	// pops are replicated as removing the element popped, whatever the peer's copy holds at that end
+	checkReplicated(t, client, server2, "lpo11l", "ltr11l11112-1", "val11a")
+	checkReplicated(t, client, server2, "rpo11l", "ltr11l11012-2", "val11c")
+	checkRequestResponse(t, client, "lrg11l11012-1", listResponse([]string{"b"}))
+
+	checkReplicated(t, client, server2, "ltr11l11112-1", "ltr11l11112-1", ackResponse)
+	checkRequestResponse(t, client, "lpo11l", "nil") // now empty, so nothing replicated
+	checkRequestResponse(t, client, "lrg11l11012-1", listResponse(nil))
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_ListPopIdempotent(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2),
+		&handlerConfig{idempotency: newIdempotencyCache(0, 0)})
+
+	checkReplicated(t, client, server2, "rpu11l11a", "rpu11l11a", ackResponse)
+	checkReplicated(t, client, server2, "rpu11l11b", "rpu11l11b", ackResponse)
+
+	// a retried pop gets the element originally popped, rather than popping another
+	checkReplicated(t, client, server2, "idk12k1lpo11l", "idk12k1ltr11l11112-1", "val11a")
+	checkRequestResponse(t, client, "idk12k1lpo11l", "val11a")
+	checkRequestResponse(t, client, "idk12k1rpo11l", formatError(reasonIdempotencyConflict))
+	checkRequestResponse(t, client, "lrg11l11012-1", listResponse([]string{"b"}))
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_ListWrongType(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "put11s11v", ackResponse)
+	checkRequestResponse(t, client, "lpu11s11a", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "rpo11s", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "lrg11s11012-1", formatError(reasonWrongType))
+
+	checkRequestResponse(t, client, "lpu11l11a", ackResponse)
+	checkRequestResponse(t, client, "get11l0", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "hga11l", formatError(reasonWrongType))
+
+	checkRequestResponse(t, client, "bye", "")
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how long the timestamp of a deleted or expired key is remembered, so older writes to the key
+	// replicated late are still ignored
+	versionRetention = time.Minute
+
+	// how far a timestamp replicated by a peer can be ahead of this server's clock, so a peer whose clock
+	// is far ahead can't make its writes win over every write made for as long
+	maxClockDrift = 5 * time.Second
+
+	// the version described of a key whose last write isn't known
+	unversioned = "0"
+)
+
+var (
+	errInvalidTimestamp = errors.New("invalid timestamp")
+	errClockDrift       = errors.New("timestamp too far ahead of the clock")
+)
+
+// timestamp orders writes using a hybrid logical clock: the time in milliseconds of the server that
+// made the write, a counter ordering writes made in the same millisecond (or while the clock is behind
+// a timestamp seen from a peer), then the server's id to order writes made concurrently by two servers.
+type timestamp struct {
+	wall    int64
+	logical int64
+	node    string
+}
+
+// parseTimestamp parses a timestamp formatted by String.
+func parseTimestamp(text string) (timestamp, error) {
+	parts := strings.SplitN(text, ".", 3)
+	if len(parts) != 3 {
+		return timestamp{}, fmt.Errorf("%w: %s", errInvalidTimestamp, text)
+	}
+
+	wall, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return timestamp{}, fmt.Errorf("%w: %s", errInvalidTimestamp, text)
+	}
+
+	logical, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return timestamp{}, fmt.Errorf("%w: %s", errInvalidTimestamp, text)
+	}
+
+	return timestamp{wall, logical, parts[2]}, nil
+}
+
+// checkTimestamp returns an error if the text isn't a valid timestamp, or is too far ahead of now.
+func checkTimestamp(text string, now time.Time) error {
+	t, err := parseTimestamp(text)
+	if err != nil {
+		return err
+	}
+
+	if limit := now.Add(maxClockDrift).UnixMilli(); t.wall > limit {
+		return fmt.Errorf("%w: %s", errClockDrift, text)
+	}
+
+	return nil
+}
+
+func (t timestamp) String() string {
+	return fmt.Sprintf("%d.%d.%s", t.wall, t.logical, t.node)
+}
+
+// after returns whether the timestamp is later than the other.
+func (t timestamp) after(other timestamp) bool {
+	switch {
+	case t.wall != other.wall:
+		return t.wall > other.wall
+
+	case t.logical != other.logical:
+		return t.logical > other.logical
+
+	default:
+		return t.node > other.node
+	}
+}
+
+// keyVersion is the timestamp of the last write to a key, and when it can be forgotten (never if zero).
+type keyVersion struct {
+	timestamp timestamp
+	expires   time.Time
+}
+
+// lastWriteWins resolves concurrent writes to the same key made on different servers: each write is
+// timestamped by the server it was made on, and replicated with the timestamp, then only applied if
+// it is later than the last write applied to the key, so every server ends up with the same value.
+type lastWriteWins struct {
+	node string
+
+	mutex     sync.Mutex
+	clock     timestamp
+	versions  map[string]keyVersion
+	lastSweep time.Time
+}
+
+func newLastWriteWins(node string) *lastWriteWins {
+	return &lastWriteWins{node: node, versions: make(map[string]keyVersion)}
+}
+
+// now returns a timestamp later than any made or seen by this server.
+func (l *lastWriteWins) now() timestamp {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if wall := time.Now().UnixMilli(); wall > l.clock.wall {
+		l.clock = timestamp{wall, 0, l.node}
+	} else {
+		l.clock.logical++
+	}
+
+	return l.clock
+}
+
+// observe advances the clock past a timestamp seen from a peer, must be called with the mutex locked.
+func (l *lastWriteWins) observe(t timestamp) {
+	if t.wall > l.clock.wall || (t.wall == l.clock.wall && t.logical > l.clock.logical) {
+		l.clock = timestamp{t.wall, t.logical, l.node}
+	}
+}
+
+// apply calls write if the timestamp is later than the key's last write, returning whether it was
+// called, and remembering the timestamp until expires (forever if zero).
+func (l *lastWriteWins) apply(key string, t timestamp, expires time.Time, write func()) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.observe(t)
+
+	if previous, found := l.versions[key]; found && !t.after(previous.timestamp) {
+		return false
+	}
+
+	write()
+
+	l.versions[key] = keyVersion{t, expires}
+
+	return true
+}
+
+// version returns the timestamp of the key's last write, if known.
+func (l *lastWriteWins) version(key string) (timestamp, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	v, found := l.versions[key]
+
+	return v.timestamp, found
+}
+
+// withVersion returns the description of the key's value with its version replaced by the timestamp of
+// its last write, if known.
+func (l *lastWriteWins) withVersion(key string, response string) string {
+	description, _, _, err := parseArgument(strings.TrimPrefix(response, "val"))
+	if !strings.HasPrefix(response, "val") || err != nil {
+		return response
+	}
+
+	if t, found := l.version(key); found {
+		description = strings.TrimSuffix(description, " version="+unversioned)
+
+		return "val" + formatArgument(description+" version="+t.String())
+	}
+
+	return response
+}
+
+// clear forgets every key's last write, when every key is removed.
+func (l *lastWriteWins) clear() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.versions = make(map[string]keyVersion)
+}
+
+// sweep forgets the last writes that have expired, at most once per retention period.
+func (l *lastWriteWins) sweep(now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastSweep) < versionRetention {
+		return
+	}
+
+	l.lastSweep = now
+
+	for key, v := range l.versions {
+		if !v.expires.IsZero() && now.After(v.expires) {
+			delete(l.versions, key)
+		}
+	}
+}
+
+// expiry returns when the timestamp of the write can be forgotten, which is never for keys that
+// don't expire.
+func expiry(request *commandRequest, now time.Time) time.Time {
+	switch request.command {
+	case deleteCommand:
+		return now.Add(versionRetention)
+
+	case putExpiryCommand:
+		return now.Add(time.Duration(request.length)*time.Millisecond + versionRetention)
+
+	case putOptionsCommand:
+		if request.length == 0 {
+			// doesn't expire, or keeps the key's expiry, which isn't known
+			return time.Time{}
+		}
+
+		return now.Add(time.Duration(request.length)*time.Millisecond + versionRetention)
+
+	default:
+		return time.Time{}
+	}
+}
+
+// withTimestamp returns the write prefixed by its timestamp, so peers apply it in the same order.
+func withTimestamp(request *commandRequest, t timestamp) *commandRequest {
+	prefixed := *request
+	prefixed.originalText = "tsp" + formatArgument(t.String()) + request.originalText
+
+	return &prefixed
+}
+
+// timestampOf returns the timestamp the write is prefixed by, if any, skipping any idempotency key
+// or origin.
+func timestampOf(request *commandRequest) (timestamp, bool) {
+	text := request.originalText
+
+	for {
+		prefix, err := parseCommand(text)
+
+		switch {
+		case err != nil || prefix == nil:
+			return timestamp{}, false
+
+		case prefix.command == idempotencyCommand || prefix.command == originCommand:
+			text = text[len(prefix.originalText):]
+
+		case prefix.command == timestampCommand:
+			t, err := parseTimestamp(prefix.value)
+
+			return t, err == nil
+
+		default:
+			return timestamp{}, false
+		}
+	}
+}
+
+// stamp returns the write prefixed by the timestamp sent before it, or if none (or it is invalid),
+// a new timestamp, when resolving conflicts by last write wins.
+func (s *session) stamp(command *commandRequest, sent string) *commandRequest {
+	switch {
+	// Raft already applies writes in the same order everywhere
+	case s.config.lww == nil || s.config.propose != nil || !isMutation(command):
+		return command
+
+	// merging replicated data types is never conflicting
+	case command.command == flushAllCommand || command.command == crdtMergeCommand:
+		return command
+	}
+
+	if sent != "" {
+		t, err := parseTimestamp(sent)
+		if err == nil {
+			return withTimestamp(command, t)
+		}
+
+		s.logger.Warn("ignoring invalid timestamp", "error", err)
+	}
+
+	return withTimestamp(command, s.config.lww.now())
+}
+
+// resolveConflicts passes the requests sent on the returned channel to the local store, except for
+// timestamped writes older than the last write to their key, which are acknowledged without being applied.
+func (l *lastWriteWins) resolveConflicts(logger *slog.Logger, localStoreChannel chan<- *commandRequest,
+	responseChannel <-chan string) (chan<- *commandRequest, <-chan string) {
+	requests := make(chan *commandRequest)
+	responses := make(chan string)
+
+	go func() {
+		for request := range requests {
+			t, found := timestampOf(request)
+
+			switch {
+			case request.command == flushAllCommand:
+				localStoreChannel <- request
+				l.clear()
+				responses <- <-responseChannel
+
+			case !found:
+				localStoreChannel <- request
+				response := <-responseChannel
+
+				if request.command == typeCommand {
+					response = l.withVersion(request.key, response)
+				}
+
+				responses <- response
+
+				if request.command == closeCommand {
+					return
+				}
+
+			default:
+				now := time.Now()
+				l.sweep(now)
+
+				var response string
+
+				applied := l.apply(request.key, t, expiry(request, now), func() {
+					localStoreChannel <- request
+					response = <-responseChannel
+				})
+
+				if !applied {
+					logger.Debug("ignoring write older than the last write to the key", "key", request.key,
+						"timestamp", t)
+
+					response = ackResponse
+				}
+
+				responses <- response
+			}
+		}
+	}()
+
+	return requests, responses
+}
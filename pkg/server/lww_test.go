@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_parseTimestamp(t *testing.T) {
+	parsed, err := parseTimestamp("100.2.peer:1")
+	if err != nil {
+		t.Fatal("Expected parsed but got: ", err)
+	}
+
+	if parsed != (timestamp{100, 2, "peer:1"}) || parsed.String() != "100.2.peer:1" {
+		t.Error("Wrong timestamp: ", parsed)
+	}
+
+	for _, text := range []string{"", "100", "100.2", "x.2.a", "100.x.a"} {
+		if _, err := parseTimestamp(text); err == nil {
+			t.Error("Expected error but got parsed: ", text)
+		}
+	}
+}
+
+func Test_timestamp_after(t *testing.T) {
+	earlier := timestamp{100, 2, "b"}
+
+	for _, later := range []timestamp{{101, 0, "a"}, {100, 3, "a"}, {100, 2, "c"}} {
+		if !later.after(earlier) || earlier.after(later) {
+			t.Errorf("Expected %s after %s", later, earlier)
+		}
+	}
+
+	if earlier.after(earlier) {
+		t.Error("Expected timestamp not after itself")
+	}
+}
+
+func Test_lastWriteWins_now(t *testing.T) {
+	l := newLastWriteWins("a")
+
+	// a timestamp from a peer with a clock far ahead
+	ahead := timestamp{time.Now().Add(time.Hour).UnixMilli(), 5, "b"}
+	l.apply("key", ahead, time.Time{}, func() {})
+
+	if now := l.now(); !now.after(ahead) || now.node != "a" {
+		t.Errorf("Expected %s after %s", now, ahead)
+	}
+}
+
+func Test_lastWriteWins_apply(t *testing.T) {
+	l := newLastWriteWins("a")
+	now := time.Now()
+	writes := 0
+	write := func() { writes++ }
+
+	if !l.apply("key", timestamp{200, 0, "b"}, time.Time{}, write) {
+		t.Error("Expected first write applied")
+	}
+
+	if l.apply("key", timestamp{100, 0, "c"}, time.Time{}, write) {
+		t.Error("Expected older write ignored")
+	}
+
+	if !l.apply("other", timestamp{100, 0, "c"}, now.Add(time.Second), write) {
+		t.Error("Expected write to other key applied")
+	}
+
+	if writes != 2 {
+		t.Error("Expected 2 writes but got: ", writes)
+	}
+
+	// expired versions are forgotten, so older writes are applied again
+	l.sweep(now.Add(2 * time.Second))
+
+	if !l.apply("other", timestamp{50, 0, "c"}, time.Time{}, write) {
+		t.Error("Expected write applied once forgotten")
+	}
+
+	l.clear()
+
+	if !l.apply("key", timestamp{100, 0, "c"}, time.Time{}, write) {
+		t.Error("Expected write applied once cleared")
+	}
+}
+
+func Test_handle_LastWriteWins(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{lww: newLastWriteWins("a"), peer: true})
+
+	checkRequestResponse(t, client, "tsp17200.0.bput11a112", "ack")
+	checkRequestResponse(t, client, "tsp17100.0.cput11a111", "ack") // older, so ignored
+	checkRequestResponse(t, client, "get11a0", "val112")
+
+	checkRequestResponse(t, client, "tsp17100.0.cdel11a", "ack") // older, so ignored
+	checkRequestResponse(t, client, "get11a0", "val112")
+
+	checkRequestResponse(t, client, "put11a113", "ack") // timestamped now, so later
+	checkRequestResponse(t, client, "get11a0", "val113")
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_LastWriteWinsVersion(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil,
+		&handlerConfig{lww: newLastWriteWins("a"), peer: true})
+
+	checkRequestResponse(t, client, "tsp17200.0.bput11a112", "ack")
+	checkRequestResponse(t, client, "typ11a", "val"+formatArgument("type=string size=1 ttl_ms=-1 version=200.0.b"))
+	checkRequestResponse(t, client, "typ11b", "nil")
+
+	// a key written before its last write was tracked
+	kvstore.Write(store, "c", "3")
+	checkRequestResponse(t, client, "typ11c", "val"+formatArgument("type=string size=1 ttl_ms=-1 version=0"))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_TimestampOnlyFromPeers(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{lww: newLastWriteWins("a")})
+
+	checkRequestResponse(t, client, "tsp17200.0.b", formatError(reasonUnauthorised))
+	checkRequestResponse(t, client, "org11b13100", formatError(reasonUnauthorised))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_checkTimestamp(t *testing.T) {
+	now := time.UnixMilli(1000000)
+
+	tests := []struct {
+		text     string
+		expected error
+	}{
+		{text: "1000000.0.a", expected: nil},
+		{text: "1004000.3.a", expected: nil},
+		{text: "1006000.0.a", expected: errClockDrift},
+		{text: "1000000.a", expected: errInvalidTimestamp},
+	}
+
+	for _, test := range tests {
+		if err := checkTimestamp(test.text, now); !errors.Is(err, test.expected) {
+			t.Errorf("Expected %v for %s but got %v", test.expected, test.text, err)
+		}
+	}
+}
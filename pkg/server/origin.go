@@ -0,0 +1,107 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how long a replicated write's origin is remembered, so copies of it arriving later (such as by
+// another route through the cluster) are dropped
+const originRetention = time.Minute
+
+// originTracker tags the writes made on this server with its id and a sequence number, and remembers
+// the tags of the writes seen from peers, so a write arriving more than once, or back at the server
+// it was made on, is only applied once.
+type originTracker struct {
+	self string
+
+	mutex     sync.Mutex
+	sequence  int64
+	seen      map[string]time.Time
+	lastSweep time.Time
+	dropped   int
+}
+
+// newOriginTracker returns the tracker of this server (self), with sequence numbers starting from when
+// it started, so a restarted server's tags differ from before.
+func newOriginTracker(self string, now time.Time) *originTracker {
+	return &originTracker{self: self, sequence: now.UnixNano(), seen: make(map[string]time.Time)}
+}
+
+// next returns the sequence number of the next write made on this server.
+func (o *originTracker) next() int64 {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.sequence++
+
+	return o.sequence
+}
+
+// firstSeen returns whether the write tagged by the origin and sequence number hasn't been seen before,
+// remembering it if not. Writes that originated on this server have always been seen.
+func (o *originTracker) firstSeen(origin string, sequence string, now time.Time) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.sweep(now)
+
+	tag := origin + "#" + sequence
+
+	if _, found := o.seen[tag]; found || origin == o.self {
+		o.dropped++
+		return false
+	}
+
+	o.seen[tag] = now.Add(originRetention)
+
+	return true
+}
+
+// droppedWrites returns how many writes have been dropped, having been seen before.
+func (o *originTracker) droppedWrites() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.dropped
+}
+
+// sweep forgets the tags that have expired, at most once per retention period, must be called with
+// the mutex locked.
+func (o *originTracker) sweep(now time.Time) {
+	if now.Sub(o.lastSweep) < originRetention {
+		return
+	}
+
+	o.lastSweep = now
+
+	for tag, expires := range o.seen {
+		if now.After(expires) {
+			delete(o.seen, tag)
+		}
+	}
+}
+
+// withOrigin returns the write prefixed by the server it was made on and its sequence number there.
+func withOrigin(request *commandRequest, origin string, sequence string) *commandRequest {
+	prefixed := *request
+	prefixed.originalText = "org" + formatArgument(origin) + formatArgument(sequence) + request.originalText
+
+	return &prefixed
+}
+
+// tag returns the write prefixed by the origin sent before it, or if none, this server and a new
+// sequence number, when tracking origins.
+func (s *session) tag(command *commandRequest, sent commandPrefixes) *commandRequest {
+	// Raft already applies each write once
+	if s.config.origins == nil || s.config.propose != nil || !isMutation(command) {
+		return command
+	}
+
+	if sent.origin != "" {
+		return withOrigin(command, sent.origin, sent.sequence)
+	}
+
+	return withOrigin(command, s.config.origins.self, strconv.FormatInt(s.config.origins.next(), 10))
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_originTracker_firstSeen(t *testing.T) {
+	now := time.Now()
+	o := newOriginTracker("a", now)
+
+	if !o.firstSeen("b", "1", now) {
+		t.Error("Expected first write from b seen first")
+	}
+
+	if o.firstSeen("b", "1", now) {
+		t.Error("Expected repeated write from b seen before")
+	}
+
+	if !o.firstSeen("b", "2", now) || !o.firstSeen("c", "1", now) {
+		t.Error("Expected other writes seen first")
+	}
+
+	if o.firstSeen("a", "1", now) {
+		t.Error("Expected write made here seen before")
+	}
+
+	// forgotten once expired
+	if !o.firstSeen("b", "1", now.Add(2*originRetention)) {
+		t.Error("Expected expired write seen first")
+	}
+
+	if dropped := o.droppedWrites(); dropped != 2 {
+		t.Error("Expected 2 dropped writes but got: ", dropped)
+	}
+}
+
+func Test_handle_Origin(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server1), store, connectedPeers(peer2),
+		&handlerConfig{origins: newOriginTracker("a", time.Unix(0, 0)), peer: true})
+
+	// tagged with this server and the next sequence number
+	write(t, client, "put11a111")
+	read(t, server2, "org11a111put11a111")
+	write(t, server2, "ack")
+	read(t, client, "ack")
+
+	// the origin sent is kept when replicated
+	checkDistributedRequestResponse(t, client, "org11b13100put11b111", []net.Conn{server2}, "ack")
+
+	// seen before, so dropped without being applied or replicated
+	checkRequestResponse(t, client, "org11b13100put11b112", "ack")
+	checkRequestResponse(t, client, "org11a111put11a112", "ack")
+
+	checkRequestResponse(t, client, "get11a0", "val111")
+	checkRequestResponse(t, client, "get11b0", "val111")
+	checkRequestResponse(t, client, "bye", "")
+}
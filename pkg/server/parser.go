@@ -3,9 +3,10 @@ package server
 import (
 	"errors"
 	"fmt"
-	"log"
+	"math"
 	"strconv"
-	"strings"
+	"tcp/pkg/kvstore"
+	"time"
 )
 
 type command int
@@ -15,14 +16,125 @@ const (
 	getCommand    command = iota
 	deleteCommand command = iota
 	closeCommand  command = iota
+	authCommand   command = iota
+
+	checksumPutCommand   command = iota
+	checksumGetCommand   command = iota
+	versionCommand       command = iota
+	helloCommand         command = iota
+	putExpiryCommand     command = iota
+	optionCommand        command = iota
+	pingCommand          command = iota
+	infoCommand          command = iota
+	idempotencyCommand   command = iota
+	hotKeysCommand       command = iota
+	whatIfCommand        command = iota
+	clientListCommand    command = iota
+	clientKillCommand    command = iota
+	slowLogCommand       command = iota
+	flushAllCommand      command = iota
+	shutdownCommand      command = iota
+	readOnlyCommand      command = iota
+	snapshotCommand      command = iota
+	raftCommand          command = iota
+	gossipCommand        command = iota
+	timestampCommand     command = iota
+	antiEntropyCommand   command = iota
+	originCommand        command = iota
+	incrementCommand     command = iota
+	counterCommand       command = iota
+	setAddCommand        command = iota
+	setRemoveCommand     command = iota
+	setMembersCommand    command = iota
+	crdtMergeCommand     command = iota
+	snapshotChunkCommand command = iota
+	durabilityCommand    command = iota
+	batchCommand         command = iota
+	compressedCommand    command = iota
+	topologyCommand      command = iota
+	watchCommand         command = iota
+	unwatchCommand       command = iota
+	scanCommand          command = iota
+	putOptionsCommand    command = iota
+	persistCommand       command = iota
+	hashSetCommand       command = iota
+	hashGetCommand       command = iota
+	hashDeleteCommand    command = iota
+	hashGetAllCommand    command = iota
+	listPushLeftCommand  command = iota
+	listPushRightCommand command = iota
+	listPopLeftCommand   command = iota
+	listPopRightCommand  command = iota
+	listRangeCommand     command = iota
+	listTrimCommand      command = iota
+	memberAddCommand     command = iota
+	memberRemoveCommand  command = iota
+	isMemberCommand      command = iota
+	membersCommand       command = iota
+	scoreAddCommand      command = iota
+	scoreRemoveCommand   command = iota
+	rankRangeCommand     command = iota
+	scoreRangeCommand    command = iota
+	setBitCommand        command = iota
+	getBitCommand        command = iota
+	bitCountCommand      command = iota
+	typeCommand          command = iota
+	subscribeCommand     command = iota
+	unsubscribeCommand   command = iota
+
+	// registered with RegisterCommand
+	customCommand command = iota
 )
 
+// commandNames lists the text of every built in command, indexed by command.
+var commandNames = []string{
+	"put", "get", "del", "bye", "auth", "pck", "gck", "ver", "hlo", "pex", "opt", "png", "inf", "idk", "hot", "wif",
+	"cls", "clk", "slg", "fla", "sdn", "rdo", "syn", "rft", "gsp", "tsp", "aen", "org",
+	"inc", "cnt", "sad", "srm", "smb", "crm", "snc", "dur", "bat", "cmp", "top", "wch", "uwc",
+	"scn", "pto", "prs", "hst", "hgt", "hdl", "hga", "lpu", "rpu", "lpo", "rpo", "lrg", "ltr",
+	"sta", "str", "sti", "stm", "zad", "zrm", "zrg", "zrs", "sbt", "gbt", "bct", "typ", "sub", "usb", "",
+}
+
 type commandRequest struct {
 	command      command
 	key          string
 	value        string
 	length       int
+	checksum     string
 	originalText string
+
+	// the field of a hash
+	field string
+
+	// the range of a list's elements, or a sorted set's members by rank, from the start to the stop index
+	// inclusive
+	start int
+	stop  int
+
+	// the score of a sorted set's member
+	score float64
+
+	// the range of a sorted set's members by score, from the lowest to the highest inclusive
+	lowest  float64
+	highest float64
+
+	// the command parsed, if registered with RegisterCommand rather than built in
+	custom Command
+
+	// the conditions and expiry of a put with options
+	options *kvstore.WriteOptions
+
+	// whether the write has already been made locally, so is only replicated to the peers
+	applied bool
+}
+
+// name returns the text the command starts with.
+func (c *commandRequest) name() string {
+	if c.custom != nil {
+		return c.custom.Name()
+	}
+
+	return commandNames[c.command]
 }
 
 var errUnrecognisedCommand = errors.New("unrecognised command")
@@ -31,70 +143,165 @@ var errUnrecognisedCommand = errors.New("unrecognised command")
 // with 3 possible outcomes: a command is found, no command is found (incomplete data,
 // read more input then try again), or an error (invalid command).
 func parseCommand(buffer string) (*commandRequest, error) {
-	var command *commandRequest
-
-	var incomplete bool
+	command := commands.find(buffer)
+	if command == nil {
+		if len(buffer) > 2 && !commands.isPrefix(buffer) {
+			// 3 or more characters that don't start a command, so can't be a valid command
+			return nil, fmt.Errorf("%w: %s", errUnrecognisedCommand, buffer[:3])
+		}
 
-	var err error
+		// otherwise might be an incomplete command
+		return nil, nil
+	}
 
-	switch {
-	case strings.HasPrefix(buffer, "put"):
-		command, incomplete, err = parsePutCommand(buffer)
+	return commands.parse(command, buffer)
+}
 
-	case strings.HasPrefix(buffer, "get"):
-		command, incomplete, err = parseGetCommand(buffer)
+// builtinCommands lists the commands built into the server, with the parser and fields of each.
+var builtinCommands = []*builtinCommand{
+	{"put", parsePutCommand, []field{argumentField, argumentField}, nil},
+	{"get", parseGetCommand, []field{argumentField, lengthField}, nil},
+	{"del", parseDeleteCommand, []field{argumentField}, nil},
+	{"bye", parseBareCommand(closeCommand), nil, nil},
+	{"ver", parseBareCommand(versionCommand), nil, nil},
+	{"auth", parseAuthCommand, []field{argumentField}, nil},
+	{"pck", parseChecksumPutCommand, []field{argumentField, argumentField, argumentField}, nil},
+	{"gck", parseChecksumGetCommand, []field{argumentField}, nil},
+	{"hlo", parseHelloCommand, []field{argumentField, argumentField}, nil},
+	{"pex", parsePutExpiryCommand, []field{argumentField, argumentField, argumentField}, nil},
+	{"png", parseBareCommand(pingCommand), nil, nil},
+	{"wch", parseCommandOf(parseWatchCommand, watchCommand), []field{argumentField}, nil},
+	{"uwc", parseCommandOf(parseWatchCommand, unwatchCommand), []field{argumentField}, nil},
+	{"scn", parseCommandOf(parsePageCommand, scanCommand), []field{argumentField, argumentField}, nil},
+	{"top", parseBareCommand(topologyCommand), nil, nil},
+	{"idk", parseIdempotencyCommand, []field{argumentField}, nil},
+	{"cmp", parseCompressedCommand, []field{argumentField}, nil},
+	{"bat", parseBatchCommand, []field{argumentField}, nil},
+	{"dur", parseDurabilityCommand, []field{argumentField}, nil},
+	{"hot", parseHotKeysCommand, []field{argumentField}, nil},
+	{"wif", parseWhatIfCommand, []field{argumentField, argumentField}, nil},
+	{"cls", parseBareCommand(clientListCommand), nil, nil},
+	{"clk", parseClientKillCommand, []field{argumentField}, nil},
+	{"slg", parseSlowLogCommand, []field{argumentField}, nil},
+	{"fla", parseBareCommand(flushAllCommand), nil, nil},
+	{"sdn", parseBareCommand(shutdownCommand), nil, nil},
+	{"rdo", parseReadOnlyCommand, []field{argumentField}, nil},
+	{"syn", parseBareCommand(snapshotCommand), nil, nil},
+	{"snc", parseCommandOf(parsePageCommand, snapshotChunkCommand), []field{argumentField, argumentField}, nil},
+	{"rft", parseRaftCommand, []field{argumentField, argumentField}, nil},
+	{"gsp", parseGossipCommand, []field{argumentField}, nil},
+	{"tsp", parseTimestampCommand, []field{argumentField}, nil},
+	{"aen", parseAntiEntropyCommand, []field{argumentField, argumentField}, nil},
+	{"org", parseOriginCommand, []field{argumentField, argumentField}, nil},
+	{"inc", parseIncrementCommand, []field{argumentField, argumentField}, nil},
+	{"cnt", parseKeyCommandOf(counterCommand, 1), []field{argumentField}, nil},
+	{"sad", parseKeyCommandOf(setAddCommand, 2), []field{argumentField, argumentField}, nil},
+	{"srm", parseKeyCommandOf(setRemoveCommand, 2), []field{argumentField, argumentField}, nil},
+	{"smb", parseKeyCommandOf(setMembersCommand, 1), []field{argumentField}, nil},
+	{"crm", parseKeyCommandOf(crdtMergeCommand, 2), []field{argumentField, argumentField}, nil},
+	{"inf", parseBareCommand(infoCommand), nil, nil},
+	{"opt", parseOptionCommand, []field{argumentField}, nil},
+	{"pto", parsePutOptionsCommand, []field{argumentField, argumentField, argumentField}, nil},
+	{"prs", parsePersistCommand, []field{argumentField}, nil},
+	{"hst", parseHashCommandOf(hashSetCommand, 3), []field{argumentField, argumentField, argumentField}, nil},
+	{"hgt", parseHashCommandOf(hashGetCommand, 2), []field{argumentField, argumentField}, nil},
+	{"hdl", parseHashCommandOf(hashDeleteCommand, 2), []field{argumentField, argumentField}, nil},
+	{"hga", parseHashCommandOf(hashGetAllCommand, 1), []field{argumentField}, nil},
+	{"lpu", parseListCommandOf(listPushLeftCommand, 2), []field{argumentField, argumentField}, nil},
+	{"rpu", parseListCommandOf(listPushRightCommand, 2), []field{argumentField, argumentField}, nil},
+	{"lpo", parseListCommandOf(listPopLeftCommand, 1), []field{argumentField}, nil},
+	{"rpo", parseListCommandOf(listPopRightCommand, 1), []field{argumentField}, nil},
+	{"lrg", parseListCommandOf(listRangeCommand, 3), []field{argumentField, argumentField, argumentField}, nil},
+	{"ltr", parseListCommandOf(listTrimCommand, 3), []field{argumentField, argumentField, argumentField}, nil},
+	{"sta", parseKeyCommandOf(memberAddCommand, 2), []field{argumentField, argumentField}, nil},
+	{"str", parseKeyCommandOf(memberRemoveCommand, 2), []field{argumentField, argumentField}, nil},
+	{"sti", parseKeyCommandOf(isMemberCommand, 2), []field{argumentField, argumentField}, nil},
+	{"stm", parseKeyCommandOf(membersCommand, 1), []field{argumentField}, nil},
+	{"zad", parseSortedSetCommandOf(scoreAddCommand), []field{argumentField, argumentField, argumentField}, nil},
+	{"zrm", parseSortedSetCommandOf(scoreRemoveCommand), []field{argumentField, argumentField}, nil},
+	{"zrg", parseSortedSetCommandOf(rankRangeCommand), []field{argumentField, argumentField, argumentField}, nil},
+	{"zrs", parseSortedSetCommandOf(scoreRangeCommand), []field{argumentField, argumentField, argumentField}, nil},
+	{"sbt", parseBitCommandOf(setBitCommand, 3), []field{argumentField, argumentField, argumentField}, nil},
+	{"gbt", parseBitCommandOf(getBitCommand, 2), []field{argumentField, argumentField}, nil},
+	{"bct", parseBitCommandOf(bitCountCommand, 1), []field{argumentField}, nil},
+	{"typ", parseKeyCommandOf(typeCommand, 1), []field{argumentField}, nil},
+	{"sub", parseCommandOf(parseSubscribeCommand, subscribeCommand), []field{argumentField}, nil},
+	{"usb", parseCommandOf(parseSubscribeCommand, unsubscribeCommand), []field{argumentField}, nil},
+}
 
-	case strings.HasPrefix(buffer, "del"):
-		command, incomplete, err = parseDeleteCommand(buffer)
+// parseBareCommand returns the parser of a command without arguments.
+func parseBareCommand(bareCommand command) func(buffer string) (*commandRequest, bool, error) {
+	return func(buffer string) (*commandRequest, bool, error) {
+		name := commandNames[bareCommand]
 
-	case strings.HasPrefix(buffer, "bye"):
-		command = &commandRequest{closeCommand, "", "", 0, buffer}
+		return &commandRequest{command: bareCommand, originalText: buffer[:len(name)]}, false, nil
+	}
+}
 
-	default:
-		if len(buffer) > 2 {
-			// 3 or more characters that didn't match above, so can't be a valid command
-			log.Printf("Unrecognised command %s", buffer)
+// parseCommandOf returns the parser of one of the commands the parser given handles.
+func parseCommandOf(parse func(buffer string, parsedCommand command) (*commandRequest, bool, error),
+	parsedCommand command) func(buffer string) (*commandRequest, bool, error) {
+	return func(buffer string) (*commandRequest, bool, error) {
+		return parse(buffer, parsedCommand)
+	}
+}
 
-			err = errUnrecognisedCommand
-		}
+// parseKeyCommandOf returns the parser of a command on a key with the number of arguments.
+func parseKeyCommandOf(keyCommand command, count int) func(buffer string) (*commandRequest, bool, error) {
+	return func(buffer string) (*commandRequest, bool, error) {
+		return parseKeyCommand(buffer, keyCommand, count)
+	}
+}
 
-		// otherwise might be an incomplete command
-		incomplete = true
+// parseHashCommandOf returns the parser of a hash command with the number of arguments.
+func parseHashCommandOf(hashCommand command, count int) func(buffer string) (*commandRequest, bool, error) {
+	return func(buffer string) (*commandRequest, bool, error) {
+		return parseHashCommand(buffer, hashCommand, count)
 	}
+}
 
-	if err != nil {
-		return nil, err
+// parseListCommandOf returns the parser of a list command with the number of arguments.
+func parseListCommandOf(listCommand command, count int) func(buffer string) (*commandRequest, bool, error) {
+	return func(buffer string) (*commandRequest, bool, error) {
+		return parseListCommand(buffer, listCommand, count)
 	}
+}
 
-	if incomplete {
-		return nil, nil
+// parseSortedSetCommandOf returns the parser of a sorted set command.
+func parseSortedSetCommandOf(sortedSetCommand command) func(buffer string) (*commandRequest, bool, error) {
+	return func(buffer string) (*commandRequest, bool, error) {
+		return parseSortedSetCommand(buffer, sortedSetCommand)
 	}
+}
 
-	return command, nil
+// parseBitCommandOf returns the parser of a bit command with the number of arguments.
+func parseBitCommandOf(bitCommand command, count int) func(buffer string) (*commandRequest, bool, error) {
+	return func(buffer string) (*commandRequest, bool, error) {
+		return parseBitCommand(buffer, bitCommand, count)
+	}
 }
 
 func parsePutCommand(buffer string) (*commandRequest, bool, error) {
 	argument1, remaining, incomplete, err := parseArgument(buffer[3:])
 	if err != nil {
-		log.Println("Error with argument 1 of put command: ", err)
-		return nil, false, err
+		return nil, false, fmt.Errorf("error with argument 1 of put command: %w", err)
 	}
 
 	if incomplete {
 		return nil, true, nil
 	}
 
-	argument2, _, incomplete, err := parseArgument(remaining)
+	argument2, remaining, incomplete, err := parseArgument(remaining)
 	if err != nil {
-		log.Println("Error with argument 2 of put command: ", err)
-		return nil, false, err
+		return nil, false, fmt.Errorf("error with argument 2 of put command: %w", err)
 	}
 
 	if incomplete {
 		return nil, true, nil
 	}
 
-	return &commandRequest{putCommand, argument1, argument2, 0, buffer}, false, nil
+	return &commandRequest{command: putCommand, key: argument1, value: argument2,
+		originalText: consumed(buffer, remaining)}, false, nil
 }
 
 func parseGetCommand(buffer string) (*commandRequest, bool, error) {
@@ -104,8 +311,7 @@ func parseGetCommand(buffer string) (*commandRequest, bool, error) {
 	}
 
 	if err != nil {
-		log.Println("Error with argument 1 of get command: ", err)
-		return nil, false, err
+		return nil, false, fmt.Errorf("error with argument 1 of get command: %w", err)
 	}
 
 	if len(remaining) < 1 {
@@ -115,14 +321,14 @@ func parseGetCommand(buffer string) (*commandRequest, bool, error) {
 
 	variableLengthSizeStr := remaining[0:1]
 
-	variableLengthSize, err := strconv.Atoi(variableLengthSizeStr)
+	variableLengthSize, err := parseCount(variableLengthSizeStr)
 	if err != nil {
-		log.Printf("Invalid variable length size: %s", variableLengthSizeStr)
-		return nil, false, fmt.Errorf("error parsing number: %w", err)
+		return nil, false, fmt.Errorf("invalid variable length size %s: %w", variableLengthSizeStr, err)
 	}
 
 	if variableLengthSize == 0 {
-		return &commandRequest{getCommand, argument1, "", 0, buffer}, false, nil
+		return &commandRequest{command: getCommand, key: argument1,
+			originalText: consumed(buffer, remaining[1:])}, false, nil
 	}
 
 	if len(remaining) < variableLengthSize+1 {
@@ -132,27 +338,635 @@ func parseGetCommand(buffer string) (*commandRequest, bool, error) {
 
 	variableLengthStr := remaining[1 : variableLengthSize+1]
 
-	variableLength, err := strconv.Atoi(variableLengthStr)
+	variableLength, err := parseCount(variableLengthStr)
 	if err != nil {
-		log.Printf("Invalid variable length: %s", variableLengthStr)
-		return nil, false, fmt.Errorf("error parsing number: %w", err)
+		return nil, false, fmt.Errorf("invalid variable length %s: %w", variableLengthStr, err)
 	}
 
-	return &commandRequest{getCommand, argument1, "", variableLength, buffer}, false, nil
+	return &commandRequest{command: getCommand, key: argument1, length: variableLength,
+		originalText: consumed(buffer, remaining[variableLengthSize+1:])}, false, nil
 }
 
 func parseDeleteCommand(buffer string) (*commandRequest, bool, error) {
-	argument1, _, incomplete, err := parseArgument(buffer[3:])
+	argument1, remaining, incomplete, err := parseArgument(buffer[3:])
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument 1 of delete command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: deleteCommand, key: argument1,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseWatchCommand parses a request to start or stop watching a key for changes, with the key.
+func parseWatchCommand(buffer string, watchCommand command) (*commandRequest, bool, error) {
+	key, remaining, incomplete, err := parseArgument(buffer[3:])
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of watch command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: watchCommand, key: key, originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseSubscribeCommand parses a request to subscribe or unsubscribe to a channel, with the channel.
+func parseSubscribeCommand(buffer string, subscribeCommand command) (*commandRequest, bool, error) {
+	channel, remaining, incomplete, err := parseArgument(buffer[3:])
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of subscribe command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: subscribeCommand, value: channel, originalText: consumed(buffer, remaining)},
+		false, nil
+}
+
+func parseAuthCommand(buffer string) (*commandRequest, bool, error) {
+	token, remaining, incomplete, err := parseArgument(buffer[4:])
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument 1 of auth command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: authCommand, value: token, originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseChecksumPutCommand parses a put command with a third argument, the client's checksum of the value.
+func parseChecksumPutCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 3)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of checksum put command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: checksumPutCommand, key: arguments[0], value: arguments[1], checksum: arguments[2],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+func parseChecksumGetCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of checksum get command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: checksumGetCommand, key: arguments[0],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseHelloCommand parses a peer handshake, with the peer's protocol version and features.
+func parseHelloCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 2)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of hello command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	peerVersion, err := parseCount(arguments[0])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid protocol version %s: %w", arguments[0], err)
+	}
+
+	return &commandRequest{command: helloCommand, value: arguments[1], length: peerVersion,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseOptionCommand parses a command enabling a connection option, with the option name.
+func parseOptionCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of option command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: optionCommand, value: arguments[0],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseIdempotencyCommand parses an idempotency key, sent before the write it applies to.
+func parseIdempotencyCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of idempotency key command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: idempotencyCommand, value: arguments[0],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseHotKeysCommand parses a request for the most read keys, with the maximum number of keys.
+func parseHotKeysCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of hot keys command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	count, err := parseCount(arguments[0])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid number of keys %s: %w", arguments[0], err)
+	}
+
+	return &commandRequest{command: hotKeysCommand, length: count,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseWhatIfCommand parses a request for what would happen if a change was made to the cluster,
+// with the change then the server it applies to.
+func parseWhatIfCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 2)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of what-if command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: whatIfCommand, key: arguments[1], value: arguments[0],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parsePageCommand parses a request for a page of keys, for a chunk of a snapshot or a scan, with the key
+// it starts after and the maximum number of keys.
+func parsePageCommand(buffer string, pageCommand command) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 2)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of %s command: %w", commandNames[pageCommand], err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	limit, err := parseCount(arguments[1])
+	if err != nil {
+		return nil, false, fmt.Errorf("error parsing number: %w", err)
+	}
+
+	return &commandRequest{command: pageCommand, key: arguments[0], length: limit,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseRaftCommand parses a Raft request from a peer, with the kind of request then its JSON body.
+func parseRaftCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 2)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of raft command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: raftCommand, key: arguments[0], value: arguments[1],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseGossipCommand parses the members of the cluster known to a peer.
+func parseGossipCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of gossip command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: gossipCommand, value: arguments[0],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseBatchCommand parses writes replicated by a peer in a single batch, the commands concatenated.
+func parseBatchCommand(buffer string) (*commandRequest, bool, error) {
+	argument, remaining, incomplete, err := parseArgument(buffer[3:])
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of batch command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: batchCommand, value: argument,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseCompressedCommand parses commands sent compressed by a peer.
+func parseCompressedCommand(buffer string) (*commandRequest, bool, error) {
+	argument, remaining, incomplete, err := parseArgument(buffer[3:])
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of compressed command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: compressedCommand, value: argument,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseDurabilityCommand parses the durability requested for the write following it: local or replicated.
+func parseDurabilityCommand(buffer string) (*commandRequest, bool, error) {
+	argument, remaining, incomplete, err := parseArgument(buffer[3:])
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of durability command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	if argument != durabilityLocal && argument != durabilityReplicated {
+		return nil, false, fmt.Errorf("%w: %s", errUnknownDurability, argument)
+	}
+
+	return &commandRequest{command: durabilityCommand, value: argument,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseTimestampCommand parses the timestamp of a replicated write, sent before the write it applies to.
+func parseTimestampCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of timestamp command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: timestampCommand, value: arguments[0],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseIncrementCommand parses a request to add to a counter, with its key and the amount (which can
+// be negative).
+func parseIncrementCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 2)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of increment command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	delta, err := strconv.Atoi(arguments[1])
+	if err != nil {
+		return nil, false, fmt.Errorf("error parsing number: %w", err)
+	}
+
+	return &commandRequest{command: incrementCommand, key: arguments[0], length: delta,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseKeyCommand parses a command on a key, such as for a conflict-free replicated data type or a set,
+// with its key and, if there are 2 arguments, its value.
+func parseKeyCommand(buffer string, keyCommand command, count int) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], count)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of %s command: %w", commandNames[keyCommand], err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	request := &commandRequest{command: keyCommand, key: arguments[0], originalText: consumed(buffer, remaining)}
+	if count > 1 {
+		request.value = arguments[1]
+	}
+
+	return request, false, nil
+}
+
+// parseOriginCommand parses the server a replicated write was made on and its sequence number there,
+// sent before the write it applies to.
+func parseOriginCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 2)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of origin command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: originCommand, key: arguments[0], value: arguments[1],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseAntiEntropyCommand parses an anti-entropy request from a peer, with its kind and JSON body.
+func parseAntiEntropyCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 2)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of anti-entropy command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	return &commandRequest{command: antiEntropyCommand, key: arguments[0], value: arguments[1],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseClientKillCommand parses a request to close a client connection, with the connection's id.
+func parseClientKillCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of client kill command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	id, err := parseCount(arguments[0])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid client id %s: %w", arguments[0], err)
+	}
+
+	return &commandRequest{command: clientKillCommand, length: id,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+var errInvalidTTL = errors.New("TTL must be a positive number of milliseconds")
+
+// parsePutExpiryCommand parses a put command with a third argument, the time to live of the key
+// in milliseconds.
+func parsePutExpiryCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 3)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of put with expiry command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	ttl, err := strconv.Atoi(arguments[2])
+	if err != nil || ttl < 1 {
+		return nil, false, fmt.Errorf("%w: %s", errInvalidTTL, arguments[2])
+	}
+
+	return &commandRequest{command: putExpiryCommand, key: arguments[0], value: arguments[1], length: ttl,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parsePutOptionsCommand parses a put command with a third argument, its options, such as only writing
+// if the key is absent. The time to live, if any, is also kept as the length, like a put with expiry.
+func parsePutOptionsCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 3)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of put with options command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	options, err := parsePutOptions(arguments[2])
 	if err != nil {
-		log.Println("Error with argument 1 of delete command: ", err)
 		return nil, false, err
 	}
 
+	return &commandRequest{command: putOptionsCommand, key: arguments[0], value: arguments[1],
+		length: int(options.TTL / time.Millisecond), originalText: consumed(buffer, remaining),
+		options: options}, false, nil
+}
+
+// parsePersistCommand parses a request to remove a key's expiry, with the key.
+func parsePersistCommand(buffer string) (*commandRequest, bool, error) {
+	key, remaining, incomplete, err := parseArgument(buffer[3:])
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of persist command: %w", err)
+	}
+
 	if incomplete {
 		return nil, true, nil
 	}
 
-	return &commandRequest{deleteCommand, argument1, "", 0, buffer}, false, nil
+	return &commandRequest{command: persistCommand, key: key, originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseHashCommand parses a command on a hash, with its key and, if there are 2 or more arguments, the
+// field then the value.
+func parseHashCommand(buffer string, hashCommand command, count int) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], count)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of %s command: %w", commandNames[hashCommand], err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	request := &commandRequest{command: hashCommand, key: arguments[0], originalText: consumed(buffer, remaining)}
+
+	if count > 1 {
+		request.field = arguments[1]
+	}
+
+	if count > 2 {
+		request.value = arguments[2]
+	}
+
+	return request, false, nil
+}
+
+var errInvalidIndex = errors.New("index must be a whole number")
+
+// parseListCommand parses a command on a list, with its key then, if there are 2 arguments, the value, or
+// if 3, the start and stop index of a range of its elements.
+func parseListCommand(buffer string, listCommand command, count int) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], count)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of %s command: %w", commandNames[listCommand], err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	request := &commandRequest{command: listCommand, key: arguments[0], originalText: consumed(buffer, remaining)}
+
+	switch count {
+	case 2:
+		request.value = arguments[1]
+
+	case 3:
+		if request.start, err = strconv.Atoi(arguments[1]); err != nil {
+			return nil, false, fmt.Errorf("%w: %s", errInvalidIndex, arguments[1])
+		}
+
+		if request.stop, err = strconv.Atoi(arguments[2]); err != nil {
+			return nil, false, fmt.Errorf("%w: %s", errInvalidIndex, arguments[2])
+		}
+	}
+
+	return request, false, nil
+}
+
+var errInvalidScore = errors.New("score must be a number")
+
+// parseSortedSetCommand parses a command on a sorted set, with its key then: the score and member to add,
+// the member to remove, the start and stop rank of a range of its members, or the lowest and highest
+// score of a range.
+func parseSortedSetCommand(buffer string, sortedSetCommand command) (*commandRequest, bool, error) {
+	count := 3
+	if sortedSetCommand == scoreRemoveCommand {
+		count = 2
+	}
+
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], count)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of %s command: %w", commandNames[sortedSetCommand], err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	request := &commandRequest{command: sortedSetCommand, key: arguments[0], originalText: consumed(buffer, remaining)}
+
+	switch sortedSetCommand {
+	case scoreAddCommand:
+		request.value = arguments[2]
+		request.score, err = parseScore(arguments[1])
+
+	case scoreRemoveCommand:
+		request.value = arguments[1]
+
+	case rankRangeCommand:
+		if request.start, err = strconv.Atoi(arguments[1]); err != nil {
+			return nil, false, fmt.Errorf("%w: %s", errInvalidIndex, arguments[1])
+		}
+
+		if request.stop, err = strconv.Atoi(arguments[2]); err != nil {
+			return nil, false, fmt.Errorf("%w: %s", errInvalidIndex, arguments[2])
+		}
+
+	default:
+		if request.lowest, err = parseScore(arguments[1]); err == nil {
+			request.highest, err = parseScore(arguments[2])
+		}
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return request, false, nil
+}
+
+// parseScore parses a sorted set's score, which can be infinite (inf or -inf) but not NaN.
+func parseScore(text string) (float64, error) {
+	score, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, fmt.Errorf("%w: %s", errInvalidScore, text)
+	}
+
+	return score, nil
+}
+
+// the highest bit offset, so setting a bit can't grow a value past the largest command accepted by default
+const maxBitOffset = 8*defaultMaxCommandSize - 1
+
+var (
+	errInvalidBitOffset = errors.New("bit offset must be a whole number, from 0 up to the limit")
+	errInvalidBit       = errors.New("bit must be 0 or 1")
+)
+
+// parseBitCommand parses a command on a string as a bitmap, with its key and, if there are 2 or more
+// arguments, the offset of the bit then its value, 0 or 1. The offset is kept as the length.
+func parseBitCommand(buffer string, bitCommand command, count int) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], count)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of %s command: %w", commandNames[bitCommand], err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	request := &commandRequest{command: bitCommand, key: arguments[0], originalText: consumed(buffer, remaining)}
+
+	if count > 1 {
+		if request.length, err = strconv.Atoi(arguments[1]); err != nil || request.length < 0 ||
+			request.length > maxBitOffset {
+			return nil, false, fmt.Errorf("%w: %s", errInvalidBitOffset, arguments[1])
+		}
+	}
+
+	if count > 2 {
+		if arguments[2] != "0" && arguments[2] != "1" {
+			return nil, false, fmt.Errorf("%w: %s", errInvalidBit, arguments[2])
+		}
+
+		request.value = arguments[2]
+	}
+
+	return request, false, nil
+}
+
+// parseArguments parses the specified number of consecutive 3 part arguments, returning them
+// along with the remaining string.
+func parseArguments(buffer string, count int) ([]string, string, bool, error) {
+	arguments := make([]string, 0, count)
+	remaining := buffer
+
+	for len(arguments) < count {
+		argument, rest, incomplete, err := parseArgument(remaining)
+		if err != nil || incomplete {
+			return nil, buffer, incomplete, err
+		}
+
+		arguments = append(arguments, argument)
+		remaining = rest
+	}
+
+	return arguments, remaining, false, nil
+}
+
+// consumed returns the start of the buffer parsed as a command, given the remaining unparsed string,
+// so any further commands pipelined after it can be parsed next.
+func consumed(buffer string, remaining string) string {
+	return buffer[:len(buffer)-len(remaining)]
 }
 
 // parseArgument parses the specified string, looking for a valid 3 part argument.
@@ -161,45 +975,74 @@ func parseDeleteCommand(buffer string) (*commandRequest, bool, error) {
 // an err is returned. If parsing fails because the string is incomplete, an incomplete
 // flag is set.
 //
+// Sizes are always in bytes, not characters, so keys and values can hold any UTF-8 (or binary) data.
+//
 // This implementation assumes arguments fit into an int. If data could be larger
 // we could perhaps use math/big.Int.
 func parseArgument(buffer string) (string, string, bool, error) {
-	if len(buffer) < 3 {
-		// string too short for all parts of an argument to be present
+	headerLength, argumentSize, incomplete, err := parseArgumentHeader(buffer)
+	if err != nil || incomplete {
+		return "", buffer, incomplete, err
+	}
+
+	if len(buffer) < headerLength+argumentSize {
+		// string too short for all of part 3 to be present
 		return "", buffer, true, nil
 	}
 
+	return buffer[headerLength : headerLength+argumentSize], buffer[headerLength+argumentSize:], false, nil
+}
+
+// parseArgumentHeader parses the first 2 parts of a 3 part argument, returning their combined length and
+// the size of part 3 they declare, or the incomplete flag if they haven't all been read.
+func parseArgumentHeader(buffer string) (int, int, bool, error) {
+	if len(buffer) < 1 {
+		// string too short for part 1 to be present
+		return 0, 0, true, nil
+	}
+
 	part1String := buffer[0:1]
 
-	argumentSizeLength, err := strconv.Atoi(part1String)
+	argumentSizeLength, err := parseCount(part1String)
 	if err != nil {
-		log.Printf("Invalid part 1 of command argument: %s", part1String)
-		return "", buffer, false, fmt.Errorf("error parsing number: %w", err)
+		return 0, 0, false, fmt.Errorf("invalid part 1 of command argument %s: %w", part1String, err)
 	}
 
 	if len(buffer) < argumentSizeLength+1 {
 		// string too short for all of part 2 to be present
-		return "", buffer, true, nil
+		return 0, 0, true, nil
 	}
 
 	part2String := buffer[1 : argumentSizeLength+1]
 
-	argumentSize, err := strconv.Atoi(part2String)
+	argumentSize, err := parseCount(part2String)
 	if err != nil {
-		log.Printf("Invalid part 2 of command argument: %s", part2String)
-		return "", buffer, false, fmt.Errorf("error parsing number: %w", err)
+		return 0, 0, false, fmt.Errorf("invalid part 2 of command argument %s: %w", part2String, err)
 	}
 
-	if len(buffer) < argumentSize+argumentSizeLength+1 {
-		// string too short for all of part 2 to be present
-		return "", buffer, true, nil
+	return argumentSizeLength + 1, argumentSize, false, nil
+}
+
+var errInvalidCount = errors.New("expected only decimal digits")
+
+// parseCount parses a size or count sent by a client, which unlike strconv.Atoi rejects a sign, so a
+// malformed command can't give a negative size.
+func parseCount(text string) (int, error) {
+	for _, character := range text {
+		if character < '0' || character > '9' {
+			return 0, fmt.Errorf("%w: %s", errInvalidCount, text)
+		}
 	}
 
-	return buffer[argumentSizeLength+1 : argumentSizeLength+argumentSize+1],
-		buffer[argumentSizeLength+argumentSize+1:], false, nil
+	count, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", errInvalidCount, err)
+	}
+
+	return count, nil
 }
 
-// formatArgument outputs the specified string as a 3 part argument.
+// formatArgument outputs the specified string as a 3 part argument, its size in bytes.
 func formatArgument(input string) string {
 	part3 := input
 	part2 := strconv.Itoa(len(part3))
@@ -207,3 +1050,42 @@ func formatArgument(input string) string {
 
 	return part1 + part2 + part3
 }
+
+// parseSlowLogCommand parses a request for the most recent slow commands, with the maximum number of commands.
+func parseSlowLogCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of slow log command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	count, err := parseCount(arguments[0])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid number of commands %s: %w", arguments[0], err)
+	}
+
+	return &commandRequest{command: slowLogCommand, length: count,
+		originalText: consumed(buffer, remaining)}, false, nil
+}
+
+// parseReadOnlyCommand parses a request to turn read-only mode on or off.
+func parseReadOnlyCommand(buffer string) (*commandRequest, bool, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer[3:], 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("error with argument of read-only command: %w", err)
+	}
+
+	if incomplete {
+		return nil, true, nil
+	}
+
+	if arguments[0] != readOnlyOn && arguments[0] != readOnlyOff {
+		return nil, false, fmt.Errorf("%w: %s", errInvalidReadOnlyMode, arguments[0])
+	}
+
+	return &commandRequest{command: readOnlyCommand, value: arguments[0],
+		originalText: consumed(buffer, remaining)}, false, nil
+}
@@ -1,8 +1,12 @@
 package server
 
 import (
+	"math"
 	"reflect"
+	"strings"
+	"tcp/pkg/kvstore"
 	"testing"
+	"time"
 )
 
 func Test_parseCommandBuffer_Empty(t *testing.T) {
@@ -15,35 +19,430 @@ func Test_parseCommandBuffer_Put(t *testing.T) {
 	text := "put11a13foo"
 	command, err := parseCommand(text)
 
-	checkParseCommand(t, &commandRequest{putCommand, "a", "foo", 0, text}, command, false, err)
+	checkParseCommand(t, &commandRequest{command: putCommand, key: "a", value: "foo",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_PutMultiByte(t *testing.T) {
+	text := "put16キー14😀"
+	command, err := parseCommand(text + "del")
+
+	checkParseCommand(t, &commandRequest{command: putCommand, key: "キー", value: "😀",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_PutMultiByteIncomplete(t *testing.T) {
+	// the value's size is in bytes, so 1 character of 4 bytes isn't all of it
+	command, err := parseCommand("put11a18😀")
+
+	checkParseCommand(t, nil, command, false, err)
 }
 
 func Test_parseCommandBuffer_GetAll(t *testing.T) {
 	text := "get11b0"
 	command, err := parseCommand(text)
 
-	checkParseCommand(t, &commandRequest{getCommand, "b", "", 0, text}, command, false, err)
+	checkParseCommand(t, &commandRequest{command: getCommand, key: "b", originalText: text}, command, false, err)
 }
 
 func Test_parseCommandBuffer_GetSome(t *testing.T) {
 	text := "get11b3123"
 	command, err := parseCommand(text)
 
-	checkParseCommand(t, &commandRequest{getCommand, "b", "", 123, text}, command, false, err)
+	checkParseCommand(t, &commandRequest{command: getCommand, key: "b", length: 123,
+		originalText: text}, command, false, err)
 }
 
 func Test_parseCommandBuffer_Delete(t *testing.T) {
-	text := "del11aww"
-	command, err := parseCommand(text)
+	command, err := parseCommand("del11aww")
 
-	checkParseCommand(t, &commandRequest{deleteCommand, "a", "", 0, text}, command, false, err)
+	// trailing characters are left for the next command
+	checkParseCommand(t, &commandRequest{command: deleteCommand, key: "a", originalText: "del11a"}, command, false, err)
 }
 
 func Test_parseCommandBuffer_Close(t *testing.T) {
 	text := "bye"
 	command, err := parseCommand(text)
 
-	checkParseCommand(t, &commandRequest{closeCommand, "", "", 0, text}, command, false, err)
+	checkParseCommand(t, &commandRequest{command: closeCommand, originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Auth(t *testing.T) {
+	text := "auth16secret"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: authCommand, value: "secret",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_ChecksumPut(t *testing.T) {
+	text := "pck11a13foo188c736521"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: checksumPutCommand, key: "a", value: "foo", checksum: "8c736521",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_ChecksumGet(t *testing.T) {
+	text := "gck11a"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: checksumGetCommand, key: "a",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_IncompleteChecksumPut(t *testing.T) {
+	command, err := parseCommand("pck11a13foo188c73")
+
+	checkParseCommand(t, nil, command, false, err)
+}
+
+func Test_parseCommandBuffer_Version(t *testing.T) {
+	text := "ver"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: versionCommand, originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Hello(t *testing.T) {
+	text := "hlo11218checksum"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: helloCommand, value: "checksum", length: 2,
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_PutExpiry(t *testing.T) {
+	text := "pex11a13foo141000"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: putExpiryCommand, key: "a", value: "foo", length: 1000,
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_ErrorPutExpiry(t *testing.T) {
+	command, err := parseCommand("pex11a13foo110")
+
+	checkParseCommand(t, nil, command, true, err)
+}
+
+func Test_parseCommandBuffer_PutOptions(t *testing.T) {
+	text := "pto11a13foo210xx,px=1000"
+	command, err := parseCommand(text)
+
+	options := &kvstore.WriteOptions{IfPresent: true, TTL: time.Second}
+	checkParseCommand(t, &commandRequest{command: putOptionsCommand, key: "a", value: "foo", length: 1000,
+		originalText: text, options: options}, command, false, err)
+}
+
+func Test_parseCommandBuffer_PutNoOptions(t *testing.T) {
+	text := "pto11a13foo10"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: putOptionsCommand, key: "a", value: "foo", originalText: text,
+		options: &kvstore.WriteOptions{}}, command, false, err)
+}
+
+func Test_parseCommandBuffer_ErrorPutOptions(t *testing.T) {
+	command, err := parseCommand("pto11a13foo15nx,xx")
+
+	checkParseCommand(t, nil, command, true, err)
+}
+
+func Test_parseCommandBuffer_Persist(t *testing.T) {
+	text := "prs11a"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: persistCommand, key: "a", originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_HashSet(t *testing.T) {
+	text := "hst11h11a13foo"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: hashSetCommand, key: "h", field: "a", value: "foo",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_HashGet(t *testing.T) {
+	text := "hgt11h11a"
+	command, err := parseCommand(text + "hga11h")
+
+	checkParseCommand(t, &commandRequest{command: hashGetCommand, key: "h", field: "a", originalText: text},
+		command, false, err)
+}
+
+func Test_parseCommandBuffer_HashGetAll(t *testing.T) {
+	text := "hga11h"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: hashGetAllCommand, key: "h", originalText: text},
+		command, false, err)
+}
+
+func Test_parseCommandBuffer_IncompleteHashDelete(t *testing.T) {
+	command, err := parseCommand("hdl11h1")
+
+	checkParseCommand(t, nil, command, false, err)
+}
+
+func Test_parseCommandBuffer_ListPush(t *testing.T) {
+	text := "lpu11l13foo"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: listPushLeftCommand, key: "l", value: "foo", originalText: text},
+		command, false, err)
+}
+
+func Test_parseCommandBuffer_ListPop(t *testing.T) {
+	text := "rpo11l"
+	command, err := parseCommand(text + "rpo11l")
+
+	checkParseCommand(t, &commandRequest{command: listPopRightCommand, key: "l", originalText: text},
+		command, false, err)
+}
+
+func Test_parseCommandBuffer_ListRange(t *testing.T) {
+	text := "lrg11l11212-1"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: listRangeCommand, key: "l", start: 2, stop: -1,
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_InvalidListIndex(t *testing.T) {
+	command, err := parseCommand("ltr11l11a11b")
+
+	checkParseCommand(t, nil, command, true, err)
+}
+
+func Test_parseCommandBuffer_IsMember(t *testing.T) {
+	text := "sti11s13foo"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: isMemberCommand, key: "s", value: "foo", originalText: text},
+		command, false, err)
+}
+
+func Test_parseCommandBuffer_Members(t *testing.T) {
+	text := "stm11s"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: membersCommand, key: "s", originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_ScoreAdd(t *testing.T) {
+	text := "zad11z131.511a"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: scoreAddCommand, key: "z", value: "a", score: 1.5,
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_ScoreRange(t *testing.T) {
+	text := "zrs11z14-inf115"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: scoreRangeCommand, key: "z", lowest: math.Inf(-1), highest: 5,
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_InvalidScore(t *testing.T) {
+	command, err := parseCommand("zad11z13NaN11a")
+
+	checkParseCommand(t, nil, command, true, err)
+}
+
+func Test_parseCommandBuffer_SetBit(t *testing.T) {
+	text := "sbt11b1217111"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: setBitCommand, key: "b", length: 17, value: "1", originalText: text},
+		command, false, err)
+}
+
+func Test_parseCommandBuffer_InvalidBit(t *testing.T) {
+	for _, text := range []string{"sbt11b117112", "gbt11b12-1", "gbt11b19999999999"} {
+		command, err := parseCommand(text)
+
+		checkParseCommand(t, nil, command, true, err)
+	}
+}
+
+func Test_parseCommandBuffer_Option(t *testing.T) {
+	text := "opt17reasons"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: optionCommand, value: "reasons",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Ping(t *testing.T) {
+	text := "png"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: pingCommand, originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Info(t *testing.T) {
+	text := "inf"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: infoCommand, originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Scan(t *testing.T) {
+	command, err := parseCommand("scn11a13100")
+	checkParseCommand(t, &commandRequest{command: scanCommand, key: "a", length: 100,
+		originalText: "scn11a13100"}, command, false, err)
+
+	// incomplete
+	command, err = parseCommand("scn1")
+	checkParseCommand(t, nil, command, false, err)
+
+	// invalid
+	command, err = parseCommand("scn0011x")
+	checkParseCommand(t, nil, command, true, err)
+}
+
+func Test_parseCommandBuffer_Watch(t *testing.T) {
+	command, err := parseCommand("wch11a")
+	checkParseCommand(t, &commandRequest{command: watchCommand, key: "a", originalText: "wch11a"}, command, false, err)
+
+	command, err = parseCommand("uwc11a")
+	checkParseCommand(t, &commandRequest{command: unwatchCommand, key: "a",
+		originalText: "uwc11a"}, command, false, err)
+
+	// incomplete
+	command, err = parseCommand("wch11")
+	checkParseCommand(t, nil, command, false, err)
+}
+
+func Test_parseCommandBuffer_Subscribe(t *testing.T) {
+	command, err := parseCommand("sub11c")
+	checkParseCommand(t, &commandRequest{command: subscribeCommand, value: "c", originalText: "sub11c"}, command,
+		false, err)
+
+	command, err = parseCommand("usb11c")
+	checkParseCommand(t, &commandRequest{command: unsubscribeCommand, value: "c",
+		originalText: "usb11c"}, command, false, err)
+
+	// incomplete
+	command, err = parseCommand("sub11")
+	checkParseCommand(t, nil, command, false, err)
+}
+
+func Test_parseCommandBuffer_Topology(t *testing.T) {
+	text := "top"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: topologyCommand, originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_IdempotencyKey(t *testing.T) {
+	command, err := parseCommand("idk13abcput11a11b")
+
+	checkParseCommand(t, &commandRequest{command: idempotencyCommand, value: "abc",
+		originalText: "idk13abc"}, command, false, err)
+}
+
+func Test_parseCommandBuffer_HotKeys(t *testing.T) {
+	text := "hot1210"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: hotKeysCommand, length: 10, originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_SlowLog(t *testing.T) {
+	text := "slg115"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: slowLogCommand, length: 5, originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_FlushAll(t *testing.T) {
+	command, err := parseCommand("flaget11a0")
+
+	checkParseCommand(t, &commandRequest{command: flushAllCommand, originalText: "fla"}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Shutdown(t *testing.T) {
+	command, err := parseCommand("sdn")
+
+	checkParseCommand(t, &commandRequest{command: shutdownCommand, originalText: "sdn"}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Snapshot(t *testing.T) {
+	command, err := parseCommand("syn")
+
+	checkParseCommand(t, &commandRequest{command: snapshotCommand, originalText: "syn"}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Raft(t *testing.T) {
+	text := "rft14vote12{}"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: raftCommand, key: "vote", value: "{}",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Gossip(t *testing.T) {
+	text := "gsp211a:1 5 false"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: gossipCommand, value: "a:1 5 false",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Timestamp(t *testing.T) {
+	text := "tsp19100.2.a:1"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: timestampCommand, value: "100.2.a:1",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_ReadOnly(t *testing.T) {
+	command, err := parseCommand("rdo12on")
+
+	checkParseCommand(t, &commandRequest{command: readOnlyCommand, value: readOnlyOn,
+		originalText: "rdo12on"}, command, false, err)
+
+	if _, err := parseCommand("rdo13yes"); err == nil {
+		t.Error("Expected error for invalid mode")
+	}
+}
+
+func Test_parseCommandBuffer_WhatIf(t *testing.T) {
+	text := "wif13add210server3:80"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: whatIfCommand, key: "server3:80", value: "add",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_ClientKill(t *testing.T) {
+	text := "clk1212"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: clientKillCommand, length: 12,
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Pipelined(t *testing.T) {
+	command, err := parseCommand("put11a11bget11a0bye")
+
+	checkParseCommand(t, &commandRequest{command: putCommand, key: "a", value: "b",
+		originalText: "put11a11b"}, command, false, err)
+
+	command, err = parseCommand("get11a15bye")
+
+	checkParseCommand(t, &commandRequest{command: getCommand, key: "a", length: 5,
+		originalText: "get11a15"}, command, false, err)
+
+	command, err = parseCommand("byeget11a0")
+
+	checkParseCommand(t, &commandRequest{command: closeCommand, originalText: "bye"}, command, false, err)
 }
 
 func Test_parseCommandBuffer_IncompletePut(t *testing.T) {
@@ -76,6 +475,12 @@ func Test_parseCommandBuffer_IncompleteDelete(t *testing.T) {
 	checkParseCommand(t, nil, command, false, err)
 }
 
+func Test_parseCommandBuffer_IncompleteAuth(t *testing.T) {
+	command, err := parseCommand("aut")
+
+	checkParseCommand(t, nil, command, false, err)
+}
+
 func Test_parseCommandBuffer_ErrorPut(t *testing.T) {
 	command, err := parseCommand("put12aaX7abc")
 
@@ -106,6 +511,24 @@ func Test_parseCommandBuffer_ErrorDelete(t *testing.T) {
 	checkParseCommand(t, nil, command, true, err)
 }
 
+func Test_parseCommandBuffer_ErrorChecksumPut(t *testing.T) {
+	command, err := parseCommand("pck11a13fooX12")
+
+	checkParseCommand(t, nil, command, true, err)
+}
+
+func Test_parseCommandBuffer_ErrorHello(t *testing.T) {
+	command, err := parseCommand("hlo11x18checksum")
+
+	checkParseCommand(t, nil, command, true, err)
+}
+
+func Test_parseCommandBuffer_ErrorAuth(t *testing.T) {
+	command, err := parseCommand("authX1a")
+
+	checkParseCommand(t, nil, command, true, err)
+}
+
 func checkParseCommand(t *testing.T, expectedCommand *commandRequest, actualCommand *commandRequest,
 	isErrorExpected bool, actualErr error) {
 	t.Helper()
@@ -205,3 +628,116 @@ func checkString(t *testing.T, expected string, actual string) {
 		t.Errorf("Expected %s but got %s", expected, actual)
 	}
 }
+
+func Test_parseCommandBuffer_AntiEntropy(t *testing.T) {
+	text := "aen16hashes13[1]"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: antiEntropyCommand, key: "hashes", value: "[1]",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Origin(t *testing.T) {
+	text := "org13a:11242"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: originCommand, key: "a:1", value: "42",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Increment(t *testing.T) {
+	text := "inc13cnt12-5"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: incrementCommand, key: "cnt", length: -5,
+		originalText: text}, command, false, err)
+
+	if _, err := parseCommand("inc13cnt11x"); err == nil {
+		t.Error("Expected error for invalid amount")
+	}
+}
+
+func Test_parseCommandBuffer_SetAdd(t *testing.T) {
+	text := "sad13set11x"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: setAddCommand, key: "set", value: "x",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Batch(t *testing.T) {
+	text := "bat215put11a111del11b"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: batchCommand, value: "put11a111del11b",
+		originalText: text}, command, false, err)
+}
+
+func Test_parseCommandBuffer_Durability(t *testing.T) {
+	text := "dur15local"
+	command, err := parseCommand(text)
+
+	checkParseCommand(t, &commandRequest{command: durabilityCommand, value: "local",
+		originalText: text}, command, false, err)
+}
+
+func Test_ParseArguments_NegativeSize(t *testing.T) {
+	if _, _, _, err := parseArgument("2-5key"); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func Test_ParseArguments_SignedSize(t *testing.T) {
+	if _, _, _, err := parseArgument("2+3key"); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func Test_parseCommandBuffer_ErrorNegativeCount(t *testing.T) {
+	command, err := parseCommand("hot12-1")
+
+	checkParseCommand(t, nil, command, true, err)
+}
+
+func Test_parseCommandBuffer_ErrorNegativeGetLength(t *testing.T) {
+	command, err := parseCommand("get11b2-1")
+
+	checkParseCommand(t, nil, command, true, err)
+}
+
+// FuzzParseCommand checks the parser never panics on arbitrary input, and that a command parsed is always
+// a non-empty start of the input, so the handler always makes progress through pipelined commands.
+func FuzzParseCommand(f *testing.F) {
+	for _, seed := range []string{
+		"put11a13foo", "get11b3123", "del11a", "bye", "auth16secret", "pck11a13foo188c736521", "pex11a11b14100",
+		"hot15", "slg210", "clk11a", "scn10112", "snc11a15", "wch11a", "bat215put11a111del11b", "inc11a12-1",
+		"put12-1a", "get11b9", "912345", "put99999999999", "pto11a11b17nx,px=5", "prs11a",
+		"hst11h11a13foo", "hga11h", "lpu11l13foo", "rpo11l", "lrg11l11212-1", "sta11s13foo", "stm11s",
+		"zad11z131.511a", "zrg11z11012-1", "zrs11z14-inf115",
+		"sbt11b1217111", "gbt11b110", "bct11b", "typ11a", "sub11c",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, buffer string) {
+		command, err := parseCommand(buffer)
+		if err != nil && command != nil {
+			t.Errorf("Expected no command with error %v but got %v", err, command)
+		}
+
+		if command != nil && (command.originalText == "" || !strings.HasPrefix(buffer, command.originalText)) {
+			t.Errorf("Expected %q to start with the command parsed %q", buffer, command.originalText)
+		}
+
+		if command != nil && command.length < 0 && command.command != incrementCommand {
+			t.Errorf("Expected no negative number in %q but got %d", buffer, command.length)
+		}
+	})
+}
+
+func Test_ParseArguments_Empty(t *testing.T) {
+	argument, remaining, incomplete, err := parseArgument("10")
+	if err != nil || incomplete || argument != "" || remaining != "" {
+		t.Errorf("Expected an empty argument but got %q, %q, %t, %v", argument, remaining, incomplete, err)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"tcp/pkg/version"
+)
+
+// peerFeatures lists the optional peer protocol features this server supports, negotiated
+// during the handshake so that only features supported by both servers are used.
+var peerFeatures = []string{"checksum", batchFeature, compressionFeature}
+
+var errIncompatiblePeer = errors.New("incompatible peer protocol version")
+
+// peerConn is a connection to another server's peer port, with the features both servers support,
+// and the size above which commands sent over it are compressed, if both support compression.
+type peerConn struct {
+	net.Conn
+	features      []string
+	compressAbove int
+}
+
+// supportsFeature returns whether the features negotiated over the peer connection include the feature.
+func supportsFeature(conn net.Conn, feature string) bool {
+	negotiated, ok := conn.(*peerConn)
+
+	return ok && contains(negotiated.features, feature)
+}
+
+// isCompatibleProtocol returns whether a peer using the protocol version can replicate with
+// this server. Servers differing by at most one version are compatible, so that a cluster
+// can be upgraded one server at a time.
+func isCompatibleProtocol(peerVersion int) bool {
+	difference := peerVersion - version.PeerProtocolVersion
+
+	return difference >= -1 && difference <= 1
+}
+
+// helloResponse returns this server's protocol version and features, sent in reply to a hello command.
+func helloResponse() string {
+	return "hlo" + formatArgument(strconv.Itoa(version.PeerProtocolVersion)) +
+		formatArgument(strings.Join(peerFeatures, ","))
+}
+
+// handshakePeer sends a hello command over a newly opened peer connection, returning the features
+// supported by both servers. Peers using protocol version 1 predate the handshake so reply with
+// an error, but are still compatible with this version.
+func handshakePeer(conn io.ReadWriter) ([]string, error) {
+	if err := reliableWrite(conn, helloResponse()); err != nil {
+		return nil, err
+	}
+
+	response, err := reliableRead(conn, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	if response == errorResponse {
+		if !isCompatibleProtocol(1) {
+			return nil, fmt.Errorf("%w: 1", errIncompatiblePeer)
+		}
+
+		return nil, nil
+	}
+
+	peerVersionText, err := readArgument(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	features, err := readArgument(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	peerVersion, err := strconv.Atoi(peerVersionText)
+	if err != nil || !isCompatibleProtocol(peerVersion) {
+		return nil, fmt.Errorf("%w: %s", errIncompatiblePeer, peerVersionText)
+	}
+
+	return commonFeatures(strings.Split(features, ",")), nil
+}
+
+// commonFeatures returns the features supported by both this server and the peer.
+func commonFeatures(features []string) []string {
+	var common []string
+
+	for _, feature := range features {
+		for _, supported := range peerFeatures {
+			if feature == supported {
+				common = append(common, feature)
+			}
+		}
+	}
+
+	return common
+}
+
+// readArgument reads a single 3 part argument from the reader.
+func readArgument(reader io.Reader) (string, error) {
+	part1, err := reliableRead(reader, 1)
+	if err != nil {
+		return "", err
+	}
+
+	argumentSizeLength, err := strconv.Atoi(part1)
+	if err != nil {
+		return "", fmt.Errorf("error parsing number: %w", err)
+	}
+
+	part2, err := reliableRead(reader, argumentSizeLength)
+	if err != nil {
+		return "", err
+	}
+
+	argumentSize, err := strconv.Atoi(part2)
+	if err != nil {
+		return "", fmt.Errorf("error parsing number: %w", err)
+	}
+
+	if argumentSize == 0 {
+		return "", nil
+	}
+
+	return reliableRead(reader, argumentSize)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"tcp/pkg/kvstore"
+	"tcp/pkg/version"
+	"testing"
+)
+
+func Test_handshakePeer_SameVersion(t *testing.T) {
+	server, peer := net.Pipe()
+
+	go handle(testLogger, newConnection(peer), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	features, err := handshakePeer(server)
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if !reflect.DeepEqual(features, peerFeatures) {
+		t.Errorf("Expected features %v but got %v", peerFeatures, features)
+	}
+
+	checkRequestResponse(t, server, "bye", "") // shutdown
+}
+
+func Test_handshakePeer_PreviousVersion(t *testing.T) {
+	// protocol version 1 servers don't recognise the hello command
+	features, err := handshakeWithFakePeer(t, "err")
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if features != nil {
+		t.Error("Expected no features but got: ", features)
+	}
+}
+
+func Test_handshakePeer_NextVersion(t *testing.T) {
+	features, err := handshakeWithFakePeer(t, peerHello(version.PeerProtocolVersion+1, "checksum,ttl"))
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if !reflect.DeepEqual(features, []string{"checksum"}) {
+		t.Error("Expected only common features but got: ", features)
+	}
+}
+
+func Test_handshakePeer_IncompatibleVersion(t *testing.T) {
+	_, err := handshakeWithFakePeer(t, peerHello(version.PeerProtocolVersion+2, "checksum"))
+	if !errors.Is(err, errIncompatiblePeer) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_handle_HelloIncompatibleVersion(t *testing.T) {
+	server, peer := net.Pipe()
+
+	go handle(testLogger, newConnection(peer), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	// responds with its own version, then closes the connection
+	checkRequestResponse(t, server, peerHello(version.PeerProtocolVersion-2, "checksum"), helloResponse())
+	read(t, server, "")
+}
+
+func Test_isCompatibleProtocol(t *testing.T) {
+	for difference, expected := range map[int]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		if actual := isCompatibleProtocol(version.PeerProtocolVersion + difference); actual != expected {
+			t.Errorf("Expected %v for version difference %d but got %v", expected, difference, actual)
+		}
+	}
+}
+
+// handshakeWithFakePeer performs a handshake with a peer that sends the response.
+func handshakeWithFakePeer(t *testing.T, response string) ([]string, error) {
+	t.Helper()
+
+	server, peer := net.Pipe()
+
+	defer func() {
+		_ = server.Close()
+	}()
+
+	go func() {
+		if _, err := reliableRead(peer, len(helloResponse())); err != nil {
+			return
+		}
+
+		_ = reliableWrite(peer, response)
+	}()
+
+	return handshakePeer(server)
+}
+
+func peerHello(protocolVersion int, features string) string {
+	return "hlo" + formatArgument(strconv.Itoa(protocolVersion)) + formatArgument(features)
+}
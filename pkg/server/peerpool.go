@@ -0,0 +1,326 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"tcp/pkg/backoff"
+	"time"
+)
+
+var (
+	errPeerClosed      = errors.New("peer removed from pool")
+	errPeerUnreachable = errors.New("peer unreachable, waiting to reconnect")
+)
+
+// peerPool holds a single long-lived connection to each other server, shared by every client connection,
+// so client connections don't each open their own. Connections that fail are reopened when next needed,
+// once the backoff delay after the failure has passed.
+type peerPool struct {
+	logger    *slog.Logger
+	dialer    peerDialer
+	policy    backoff.Policy
+	redoLimit int
+
+	// the size of each peer's outbound queue, and what happens to writes when it is full
+	queueLimit   int
+	backpressure BackpressurePolicy
+
+	mutex sync.Mutex
+	peers map[string]*pooledPeer
+}
+
+func newPeerPool(logger *slog.Logger, dialer peerDialer, policy backoff.Policy, redoLimit int, queueLimit int,
+	backpressure BackpressurePolicy) *peerPool {
+	return &peerPool{
+		logger:       logger,
+		dialer:       dialer,
+		policy:       policy,
+		redoLimit:    redoLimit,
+		queueLimit:   queueLimit,
+		backpressure: backpressure,
+		peers:        make(map[string]*pooledPeer),
+	}
+}
+
+// get returns the pooled peer of each of the servers, adding any not already in the pool.
+func (p *peerPool) get(servers []string) []*pooledPeer {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	peers := make([]*pooledPeer, 0, len(servers))
+
+	for _, server := range servers {
+		peer, found := p.peers[server]
+		if !found {
+			peer = &pooledPeer{
+				address:   server,
+				dial:      p.dialer.dial,
+				logger:    p.logger.With("peer", server),
+				retry:     backoff.Backoff{Policy: p.policy},
+				redoLimit: p.redoLimit,
+				queue:     peerQueue{limit: p.queueLimit, policy: p.backpressure},
+			}
+
+			p.peers[server] = peer
+		}
+
+		peers = append(peers, peer)
+	}
+
+	return peers
+}
+
+// prune closes and removes the peers that aren't one of the servers.
+func (p *peerPool) prune(servers []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for address, peer := range p.peers {
+		if !contains(servers, address) {
+			peer.close()
+			delete(p.peers, address)
+		}
+	}
+}
+
+// close closes every peer connection.
+func (p *peerPool) close() {
+	p.prune(nil)
+}
+
+// all returns every peer in the pool.
+func (p *peerPool) all() []*pooledPeer {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	peers := make([]*pooledPeer, 0, len(p.peers))
+
+	for _, peer := range p.peers {
+		peers = append(peers, peer)
+	}
+
+	return peers
+}
+
+// status returns whether each peer is currently connected, up or down, by address.
+func (p *peerPool) status() map[string]string {
+	peers := p.all()
+	status := make(map[string]string, len(peers))
+
+	for _, peer := range peers {
+		status[peer.address] = peer.state()
+	}
+
+	return status
+}
+
+// catchUp resends the writes in each peer's redo log.
+func (p *peerPool) catchUp(now time.Time) {
+	for _, peer := range p.all() {
+		peer.catchUp(now)
+	}
+}
+
+// pooledPeer is the connection to another server, which one command (or batch of writes) at a time
+// is sent over.
+type pooledPeer struct {
+	address string
+	dial    func(address string) (net.Conn, error)
+	logger  *slog.Logger
+
+	// held while connecting, and for each command and its response
+	mutex sync.Mutex
+	conn  net.Conn
+	retry backoff.Backoff
+
+	// whether the connection has been opened but not yet used, so a failure isn't due to it going stale
+	fresh bool
+
+	// whether removed from the pool, so is no longer replicated to
+	closed bool
+
+	// writes waiting for the mutex, sent together in a batch by whichever is sent next
+	queue peerQueue
+
+	// writes that failed, and those sent since, to be retried in order (disabled if the limit is zero)
+	redo        []string
+	redoLimit   int
+	redoDropped int
+}
+
+// available returns whether the peer can be sent commands, reconnecting if it isn't connected and the
+// backoff delay after the last failure has passed. Peers removed from the pool are never available.
+func (p *pooledPeer) available(now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.connect(now) == nil
+}
+
+// isRemoved returns whether the peer has been removed from the pool.
+func (p *pooledPeer) isRemoved() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.closed
+}
+
+func (p *pooledPeer) isConnected() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.conn != nil
+}
+
+// state returns whether the peer is currently connected, up or down.
+func (p *pooledPeer) state() string {
+	if p.isConnected() {
+		return "up"
+	}
+
+	return "down"
+}
+
+// connect opens the connection if not already open, must be called with the mutex locked.
+func (p *pooledPeer) connect(now time.Time) error {
+	switch {
+	case p.closed:
+		return errPeerClosed
+
+	case p.conn != nil:
+		return nil
+
+	case !p.retry.Ready(now):
+		return errPeerUnreachable
+	}
+
+	conn, err := p.dial(p.address)
+	if err != nil {
+		p.retry.Failure(now)
+		p.logger.Warn("unable to connect to peer", "attempt", p.retry.Failures(), "error", err)
+
+		return err
+	}
+
+	if p.retry.Failures() > 0 {
+		p.logger.Info("reconnected to peer", "attempts", p.retry.Failures())
+	}
+
+	p.retry.Success()
+	p.conn = conn
+	p.fresh = true
+
+	return nil
+}
+
+// replicate sends the command to the peer, returning its response. A connection that fails is closed,
+// to be reopened once the backoff delay has passed, unless it had been idle (so may have been closed
+// by the peer) when the command is sent once more over a new connection.
+func (p *pooledPeer) replicate(command string, now time.Time) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.exchange(command, now, readAck)
+}
+
+// call sends the command to the peer, returning the value of its val response, reconnecting as
+// replicate does.
+func (p *pooledPeer) call(command string, now time.Time) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.exchange(command, now, readValueResponse)
+}
+
+// exchange sends the command as replicate does, then reads the response with receive, must be called
+// with the mutex locked.
+func (p *pooledPeer) exchange(command string, now time.Time, receive func(io.Reader) (string, error)) (
+	string, error) {
+	if err := p.connect(now); err != nil {
+		return "", err
+	}
+
+	stale := !p.fresh
+
+	response, err := p.send(command, receive)
+	if err != nil && stale {
+		p.logger.Debug("peer connection failed, reconnecting", "error", err)
+		p.disconnect()
+
+		if err := p.connect(now); err != nil {
+			return "", err
+		}
+
+		response, err = p.send(command, receive)
+	}
+
+	if err != nil {
+		p.logger.Warn("unable to replicate to peer", "error", err)
+		p.disconnect()
+		p.retry.Failure(now)
+	}
+
+	return response, err
+}
+
+// send writes the command then reads the response, must be called with the mutex locked.
+func (p *pooledPeer) send(command string, receive func(io.Reader) (string, error)) (string, error) {
+	p.fresh = false
+
+	if err := reliableWrite(p.conn, compressed(p.conn, command)); err != nil {
+		return "", err
+	}
+
+	return receive(p.conn)
+}
+
+// readAck reads the response to a write, 3 characters unless a val response returning what the write
+// changed, such as the bit set, when its value is read too.
+func readAck(reader io.Reader) (string, error) {
+	response, err := reliableRead(reader, 3)
+	if err != nil || response != "val" {
+		return response, err
+	}
+
+	value, err := readArgument(reader)
+	if err != nil {
+		return "", err
+	}
+
+	return response + formatArgument(value), nil
+}
+
+// readValueResponse reads a val response, returning the value.
+func readValueResponse(reader io.Reader) (string, error) {
+	response, err := reliableRead(reader, 3)
+	if err != nil {
+		return "", err
+	}
+
+	if response != "val" {
+		return "", fmt.Errorf("%w: %s", errUnexpectedResponse, response)
+	}
+
+	return readArgument(reader)
+}
+
+// disconnect closes the connection, must be called with the mutex locked.
+func (p *pooledPeer) disconnect() {
+	if p.conn != nil {
+		_ = p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// close closes the connection, and stops it being reopened.
+func (p *pooledPeer) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.disconnect()
+	p.closed = true
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"tcp/pkg/backoff"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+var errTestDial = errors.New("test peer can't be dialled")
+
+func Test_pooledPeer_Reconnect(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	defer listener.Close()
+
+	peerStore := kvstore.NewKVStore()
+	accepted := make(chan net.Conn, 2)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			accepted <- conn
+
+			go handle(testLogger, newConnection(conn), peerStore, nil, &handlerConfig{})
+		}
+	}()
+
+	pool := newPeerPool(testLogger, peerDialer{}, backoff.DefaultPolicy, 0, 0, BackpressureBlock)
+	defer pool.close()
+
+	peer := pool.get([]string{listener.Addr().String()})[0]
+
+	checkReplicate(t, peer, "put12bb13999")
+
+	// peer closes the idle connection, so the next command is sent over a new connection
+	_ = (<-accepted).Close()
+
+	checkReplicate(t, peer, "put12cc11x")
+
+	if value, _ := kvstore.Read(peerStore, "cc"); value != "x" {
+		t.Errorf("Expected x but got %s", value)
+	}
+}
+
+func Test_pooledPeer_Backoff(t *testing.T) {
+	dials := 0
+	peer := unreachablePeers("peer2")[0]
+	peer.dial = func(string) (net.Conn, error) {
+		dials++
+		return nil, errTestDial
+	}
+
+	now := time.Now()
+
+	if peer.available(now) || peer.available(now.Add(time.Millisecond)) {
+		t.Error("Peer should not be available")
+	}
+
+	if dials != 1 {
+		t.Errorf("Expected 1 dial, then waiting to retry, but got %d", dials)
+	}
+
+	if _, err := peer.replicate("put12bb13999", now.Add(time.Millisecond)); !errors.Is(err, errPeerUnreachable) {
+		t.Error("Expected unreachable error but got: ", err)
+	}
+}
+
+func Test_peerPool_prune(t *testing.T) {
+	pool := newPeerPool(testLogger, peerDialer{}, backoff.DefaultPolicy, 0, 0, BackpressureBlock)
+	peers := pool.get([]string{"127.0.0.1:1", "127.0.0.1:2"})
+
+	pool.prune([]string{"127.0.0.1:2"})
+
+	if !peers[0].isRemoved() || peers[1].isRemoved() {
+		t.Error("Expected only the first peer to be removed")
+	}
+
+	if peers[0].available(time.Now()) {
+		t.Error("Removed peer should not be available")
+	}
+
+	if status := pool.status(); len(status) != 1 || status["127.0.0.1:2"] != "down" {
+		t.Error("Expected only the remaining peer but got: ", status)
+	}
+}
+
+func Test_Server_SharedPeerConnection(t *testing.T) {
+	peerServer := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := peerServer.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = peerServer.Serve()
+	}()
+
+	defer peerServer.Shutdown(context.Background())
+
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		OtherServers:       []string{peerServer.PeerAddr().String()},
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(context.Background())
+
+	for _, key := range []string{"a", "b", "c"} {
+		client, err := net.Dial("tcp4", srv.ClientAddr().String())
+		if err != nil {
+			t.Fatal("Unable to connect: ", err)
+		}
+
+		checkRequestResponse(t, client, "put11"+key+"11x", "ack")
+		checkRequestResponse(t, client, "bye", "")
+	}
+
+	peerServer.mutex.Lock()
+	connections := len(peerServer.connections)
+	peerServer.mutex.Unlock()
+
+	if connections != 1 {
+		t.Errorf("Expected clients to share 1 peer connection but got %d", connections)
+	}
+
+	if keys := kvstore.Count(peerServer.store); keys != 3 {
+		t.Errorf("Expected 3 keys replicated but got %d", keys)
+	}
+}
+
+func checkReplicate(t *testing.T, peer *pooledPeer, command string) {
+	t.Helper()
+
+	if response, err := peer.replicate(command, time.Now()); response != ackResponse || err != nil {
+		t.Fatalf("Expected ack but got %s: %v", response, err)
+	}
+}
+
+// connectedPeers returns peers already connected over each of the connections.
+func connectedPeers(conns ...net.Conn) []*pooledPeer {
+	peers := make([]*pooledPeer, 0, len(conns))
+
+	for _, conn := range conns {
+		peers = append(peers, &pooledPeer{
+			address: conn.RemoteAddr().String(),
+			dial:    func(string) (net.Conn, error) { return nil, errTestDial },
+			logger:  testLogger,
+			conn:    conn,
+			fresh:   true,
+		})
+	}
+
+	return peers
+}
+
+// unreachablePeers returns peers that can't be connected to.
+func unreachablePeers(addresses ...string) []*pooledPeer {
+	peers := make([]*pooledPeer, 0, len(addresses))
+
+	for _, address := range addresses {
+		peers = append(peers, &pooledPeer{
+			address: address,
+			dial:    func(string) (net.Conn, error) { return nil, errTestDial },
+			logger:  testLogger,
+		})
+	}
+
+	return peers
+}
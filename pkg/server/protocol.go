@@ -0,0 +1,54 @@
+package server
+
+import "errors"
+
+// default limits on input that can't form valid commands, after which the connection is closed
+const (
+	defaultMaxCommandSize    = 16 << 20
+	defaultMaxProtocolErrors = 3
+)
+
+// commandSizeLimit returns the most unparsed input allowed, after which the connection is closed,
+// so malformed lengths or garbage input can't make the server buffer input forever.
+func (c *handlerConfig) commandSizeLimit() int {
+	if c.maxCommandSize > 0 {
+		return c.maxCommandSize
+	}
+
+	return defaultMaxCommandSize
+}
+
+// protocolErrorLimit returns how many invalid commands in a row are allowed, after which the
+// connection is closed.
+func (c *handlerConfig) protocolErrorLimit() int {
+	if c.maxProtocolErrors > 0 {
+		return c.maxProtocolErrors
+	}
+
+	return defaultMaxProtocolErrors
+}
+
+// rejectInvalidCommand sends the error response for a command that couldn't be parsed, returning
+// whether the connection should now be closed, as the client has sent too many in a row.
+func (s *session) rejectInvalidCommand(err error) bool {
+	s.protocolErrors++
+
+	if s.protocolErrors >= s.config.protocolErrorLimit() {
+		s.logger.Warn("protocol error, closing connection", "error", err, "invalidCommands", s.protocolErrors)
+
+		_ = reliableWrite(s.conn, s.errorWithReason(reasonProtocolError))
+
+		return true
+	}
+
+	s.logger.Info("rejecting invalid command", "error", err)
+
+	reason := reasonInvalidCommand
+	if errors.Is(err, errUnrecognisedCommand) {
+		reason = reasonUnknownCommand
+	}
+
+	_ = reliableWrite(s.conn, s.errorWithReason(reason))
+
+	return false
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"tcp/pkg/kvstore"
+	"time"
+)
+
+// Options of the put with options command, separated by commas, as in Redis's SET command. The
+// conditions are checked, and the value written, atomically by the store.
+const (
+	// only write if the key is absent
+	putOptionIfAbsent = "nx"
+
+	// only write if the key is present
+	putOptionIfPresent = "xx"
+
+	// keep the key's existing expiry, rather than removing it
+	putOptionKeepTTL = "keepttl"
+
+	// expire after the time to live in milliseconds, e.g. px=1000
+	putOptionExpireIn = "px="
+)
+
+var (
+	errUnknownPutOption     = errors.New("unknown put option")
+	errConflictingPutOption = errors.New("conflicting put options")
+)
+
+// parsePutOptions parses the options of a put with options command, which can be empty.
+func parsePutOptions(text string) (*kvstore.WriteOptions, error) {
+	options := &kvstore.WriteOptions{}
+
+	if text == "" {
+		return options, nil
+	}
+
+	for _, option := range strings.Split(text, ",") {
+		switch {
+		case option == putOptionIfAbsent:
+			options.IfAbsent = true
+
+		case option == putOptionIfPresent:
+			options.IfPresent = true
+
+		case option == putOptionKeepTTL:
+			options.KeepTTL = true
+
+		case strings.HasPrefix(option, putOptionExpireIn):
+			// in milliseconds, as long as the duration doesn't overflow
+			ttl, err := strconv.ParseInt(option[len(putOptionExpireIn):], 10, 64)
+			if err != nil || ttl < 1 || ttl > int64(math.MaxInt64/time.Millisecond) {
+				return nil, fmt.Errorf("%w: %s", errInvalidTTL, option)
+			}
+
+			options.TTL = time.Duration(ttl) * time.Millisecond
+
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnknownPutOption, option)
+		}
+	}
+
+	if (options.IfAbsent && options.IfPresent) || (options.KeepTTL && options.TTL > 0) {
+		return nil, fmt.Errorf("%w: %s", errConflictingPutOption, text)
+	}
+
+	return options, nil
+}
+
+// withNamespaceTTL returns the put with options with its namespace's default TTL, when neither setting
+// a TTL nor keeping the key's, as a put would be given.
+func withNamespaceTTL(request *commandRequest, ttls NamespaceTTLs) *commandRequest {
+	if request.command != putOptionsCommand || request.options.TTL > 0 || request.options.KeepTTL {
+		return request
+	}
+
+	ttl := ttls.defaultTTL(request.key)
+	if ttl == 0 {
+		return request
+	}
+
+	options := *request.options
+	options.TTL = ttl
+
+	withTTL := *request
+	withTTL.options = &options
+	withTTL.length = int(ttl / time.Millisecond)
+
+	return &withTTL
+}
+
+// madePut returns the unconditional write with the outcome of a conditional write made locally, so
+// is only replicated: a put, a put with expiry, a put keeping the key's expiry, or removing the expiry.
+func madePut(request *commandRequest) *commandRequest {
+	made := &commandRequest{command: putCommand, key: request.key, value: request.value, applied: true}
+	arguments := formatArgument(request.key) + formatArgument(request.value)
+
+	switch {
+	case request.command == persistCommand:
+		made.command = persistCommand
+		made.originalText = "prs" + formatArgument(request.key)
+
+	case request.options.KeepTTL:
+		made.command = putOptionsCommand
+		made.options = &kvstore.WriteOptions{KeepTTL: true}
+		made.originalText = "pto" + arguments + formatArgument(putOptionKeepTTL)
+
+	case request.options.TTL > 0:
+		made.command = putExpiryCommand
+		made.length = request.length
+		made.originalText = "pex" + arguments + formatArgument(strconv.Itoa(request.length))
+
+	default:
+		made.originalText = "put" + arguments
+	}
+
+	return made
+}
+
+func executePutOptions(store kvstore.Store, request *commandRequest) (string, error) {
+	written, err := writeStringIf(store, request.key, request.value, *request.options)
+	if err != nil {
+		return "", err
+	}
+
+	return conditionalResponse(written), nil
+}
+
+func executePersist(store kvstore.Store, request *commandRequest) (string, error) {
+	return conditionalResponse(store.Persist(request.key)), nil
+}
+
+// conditionalResponse returns the response to a conditional write, nil if it wasn't made.
+func conditionalResponse(made bool) string {
+	if made {
+		return ackResponse
+	}
+
+	return "nil"
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_parsePutOptions(t *testing.T) {
+	for text, expected := range map[string]*kvstore.WriteOptions{
+		"":             {},
+		"nx":           {IfAbsent: true},
+		"xx,keepttl":   {IfPresent: true, KeepTTL: true},
+		"px=250,nx":    {IfAbsent: true, TTL: 250 * time.Millisecond},
+		"keepttl,keep": nil,
+	} {
+		options, err := parsePutOptions(text)
+
+		switch {
+		case expected == nil && !errors.Is(err, errUnknownPutOption):
+			t.Errorf("Expected unknown option for %q but got %v", text, err)
+
+		case expected != nil && (err != nil || !reflect.DeepEqual(options, expected)):
+			t.Errorf("Expected %v for %q but got %v, %v", expected, text, options, err)
+		}
+	}
+}
+
+func Test_parsePutOptions_Invalid(t *testing.T) {
+	for text, expected := range map[string]error{
+		"nx,xx":             errConflictingPutOption,
+		"keepttl,px=10":     errConflictingPutOption,
+		"px=0":              errInvalidTTL,
+		"px=soon":           errInvalidTTL,
+		"px=99999999999999": errInvalidTTL,
+	} {
+		if _, err := parsePutOptions(text); !errors.Is(err, expected) {
+			t.Errorf("Expected %v for %q but got %v", expected, text, err)
+		}
+	}
+}
+
+func Test_handle_PutOptions(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2), &handlerConfig{})
+
+	// only the writes made are replicated, as the write made
+	checkRequestResponse(t, client, "pto11a11b12xx", "nil") // not present
+	checkReplicated(t, client, server2, "pto11a11b18nx,px=50", "pex11a11b1250", "ack")
+	checkRequestResponse(t, client, "pto11a11c12nx", "nil") // already present
+	checkReplicated(t, client, server2, "pto11a11d17keepttl", "pto11a11d17keepttl", "ack")
+
+	checkRequestResponse(t, client, "get11a0", "val11d")
+
+	time.Sleep(60 * time.Millisecond)
+
+	checkRequestResponse(t, client, "get11a0", "nil") // expired, the TTL being kept
+	checkRequestResponse(t, client, "pto11a11e12xx", "nil")
+	checkReplicated(t, client, server2, "pto11a11e12nx", "put11a11e", "ack")
+	checkRequestResponse(t, client, "pto11a11b13foo", formatError(reasonInvalidCommand))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_PutOptionsNotAppliedByPeer(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2),
+		&handlerConfig{writeQuorum: WriteQuorumAll})
+
+	write(t, client, "pto11a11b12nx")
+	read(t, server2, "put11a11b")
+	write(t, server2, "nil")
+	read(t, client, formatError(reasonQuorumNotMet))
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_Persist(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2), &handlerConfig{})
+
+	checkRequestResponse(t, client, "prs11a", "nil") // not present
+	checkReplicated(t, client, server2, "pex11a11b1250", "pex11a11b1250", "ack")
+	checkReplicated(t, client, server2, "prs11a", "prs11a", "ack")
+	checkRequestResponse(t, client, "prs11a", "nil") // no longer has an expiry
+
+	time.Sleep(60 * time.Millisecond)
+
+	checkRequestResponse(t, client, "get11a0", "val11b") // no longer expires
+	checkRequestResponse(t, client, "bye", "")
+}
+
+// checkReplicated checks the write is replicated to the peer as expected, which acknowledges it, then the
+// client is sent the response.
+func checkReplicated(t *testing.T, client net.Conn, peer net.Conn, command string, replicated string,
+	response string) {
+	t.Helper()
+
+	write(t, client, command)
+	read(t, peer, replicated)
+	write(t, peer, ackResponse)
+	read(t, client, response)
+}
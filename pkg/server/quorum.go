@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// WriteQuorumAll requires every peer to apply a client write before it is acknowledged.
+const WriteQuorumAll = -1
+
+var errInvalidWriteQuorum = errors.New("invalid write quorum")
+
+// ParseWriteQuorum returns the write quorum with the specified value: all, or the number of servers.
+func ParseWriteQuorum(value string) (int, error) {
+	if value == "all" {
+		return WriteQuorumAll, nil
+	}
+
+	quorum, err := strconv.Atoi(value)
+	if err != nil || quorum < 1 {
+		return 0, fmt.Errorf("%w: %s", errInvalidWriteQuorum, value)
+	}
+
+	return quorum, nil
+}
+
+// peerAck is a peer's reply to a request, and whether the peer applied it.
+type peerAck struct {
+	request *commandRequest
+	applied bool
+}
+
+// peerReplies counts the peers' replies to a request, ignoring late replies to earlier requests
+// that were acknowledged without waiting for every peer.
+type peerReplies struct {
+	request *commandRequest
+	replies int
+	applied int
+}
+
+// add counts the reply, returning false if it was to an earlier request.
+func (r *peerReplies) add(ack peerAck) bool {
+	if ack.request != r.request {
+		return false
+	}
+
+	r.replies++
+
+	if ack.applied {
+		r.applied++
+	}
+
+	return true
+}
+
+// send sends the request to a peer's channel, counting the replies received while waiting, since the
+// peer may still be replicating an earlier request and waiting to reply to it.
+func (r *peerReplies) send(peerChannel chan<- *commandRequest, ackChannel <-chan peerAck) {
+	for {
+		select {
+		case peerChannel <- r.request:
+			return
+
+		case ack := <-ackChannel:
+			r.add(ack)
+		}
+	}
+}
+
+// peerAcksRequired returns how many of the peers in the cluster must apply a write before it is
+// acknowledged, and whether the write fails if fewer do. Without a write quorum, writes wait for
+// every peer they are sent to, but are acknowledged regardless, as are writes only requiring local
+// durability, without waiting.
+func (s *session) peerAcksRequired(sent int, cluster int) (int, bool) {
+	if s.durability == durabilityLocal {
+		return 0, false
+	}
+
+	switch quorum := s.config.writeQuorum; quorum {
+	case 0:
+		return sent, false
+
+	case WriteQuorumAll:
+		return cluster, true
+
+	default:
+		// this server is one of the quorum
+		return min(quorum-1, cluster), true
+	}
+}
+
+// performWrite performs the write locally and on the peers it is sent to, out of the peers in the cluster,
+// returning the response and the reason if it failed. Writes are rejected without being performed if
+// fewer peers than the write quorum requires are reachable, and fail if fewer apply it.
+func (s *session) performWrite(command *commandRequest, peerChannels []chan<- *commandRequest, cluster int,
+	timing *commandTiming) (string, string) {
+	required, strict := s.peerAcksRequired(len(peerChannels), cluster)
+
+	if strict && required > len(peerChannels) {
+		s.logger.Warn("rejecting write, too few peers reachable for the write quorum", "command",
+			command.originalText, "reachable", len(peerChannels), "required", required)
+
+		return errorResponse, reasonQuorumNotMet
+	}
+
+	response, applied := performCommand(s.logger, s.localStoreChannel, s.responseChannel, peerChannels,
+		s.ackChannel, command, required, s.config.timeout(command), timing)
+
+	if strict && writeApplied(response) && applied < required {
+		s.logger.Warn("write not applied by enough peers for the write quorum", "command", command.originalText,
+			"applied", applied, "required", required)
+
+		return errorResponse, reasonQuorumNotMet
+	}
+
+	return response, reasonTimeout
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_ParseWriteQuorum(t *testing.T) {
+	if quorum, err := ParseWriteQuorum("all"); quorum != WriteQuorumAll || err != nil {
+		t.Errorf("Expected all but got %d: %v", quorum, err)
+	}
+
+	if quorum, err := ParseWriteQuorum("2"); quorum != 2 || err != nil {
+		t.Errorf("Expected 2 but got %d: %v", quorum, err)
+	}
+
+	for _, value := range []string{"0", "most"} {
+		if _, err := ParseWriteQuorum(value); err == nil {
+			t.Errorf("Expected an error for %s", value)
+		}
+	}
+}
+
+func Test_handle_WriteQuorum(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+	server3, peer3 := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server1), store, connectedPeers(peer2, peer3), &handlerConfig{writeQuorum: 2})
+
+	// acknowledged once this server and one peer have applied the write
+	write(t, client, "put12bb13999")
+	read(t, server2, "put12bb13999")
+	write(t, server2, "ack")
+	read(t, client, "ack")
+
+	// the other peer's late reply isn't counted for the next write
+	read(t, server3, "put12bb13999")
+	write(t, server3, "ack")
+
+	checkDistributedRequestResponse(t, client, "put12cc13999", []net.Conn{server2, server3}, "ack")
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_WriteQuorumNotApplied(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server1), store, connectedPeers(peer2),
+		&handlerConfig{writeQuorum: WriteQuorumAll})
+
+	write(t, client, "put12bb13999")
+	read(t, server2, "put12bb13999")
+	write(t, server2, "err")
+	read(t, client, formatError(reasonQuorumNotMet))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_WriteQuorumUnreachable(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, unreachablePeers("peer2"),
+		&handlerConfig{writeQuorum: 2, outagePolicy: OutageWarn})
+
+	checkRequestResponse(t, client, "put12bb13999", formatError(reasonQuorumNotMet))
+	checkRequestResponse(t, client, "get12bb0", "nil") // so wasn't applied
+	checkRequestResponse(t, client, "bye", "")
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// buckets unused for this long are discarded, since they will have refilled anyway.
+const rateLimitPruneInterval = time.Minute
+
+// rateLimiter limits the rate of commands from each client IP address, using a token bucket
+// per address so short bursts are allowed. A nil rateLimiter, or one without a positive rate,
+// allows everything.
+type rateLimiter struct {
+	mutex     sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// newRateLimiter returns a limiter allowing rate commands per second from each address, with bursts
+// of up to burst commands (defaulting to 1 second's worth), allowing everything if rate isn't positive.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	limiter := &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	limiter.setLimit(rate, burst)
+
+	return limiter
+}
+
+// setLimit changes the rate and burst, as for newRateLimiter. Addresses keep the tokens they have,
+// up to the new burst.
+func (l *rateLimiter) setLimit(rate float64, burst int) {
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.rate = rate
+	l.burst = float64(burst)
+}
+
+// allow returns whether the address can send another command now, taking a token if so.
+func (l *rateLimiter) allow(address string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.prune(now)
+
+	bucket, found := l.buckets[address]
+	if !found {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[address] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+
+	return true
+}
+
+// prune discards buckets that haven't been used recently, so the map doesn't grow forever.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < rateLimitPruneInterval {
+		return
+	}
+
+	for address, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= rateLimitPruneInterval {
+			delete(l.buckets, address)
+		}
+	}
+
+	l.lastPrune = now
+}
+
+// clientIP returns the IP address part of the connection's remote address, so every
+// connection from the same client shares a rate limit.
+func clientIP(conn *connection) string {
+	address := conn.remoteAddr()
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+
+	return host
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_rateLimiter_allow(t *testing.T) {
+	limiter := newRateLimiter(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !limiter.allow("10.0.0.1", now) {
+			t.Errorf("Expected command %d of burst to be allowed", i+1)
+		}
+	}
+
+	if limiter.allow("10.0.0.1", now) {
+		t.Error("Expected command beyond burst to be throttled")
+	}
+
+	if !limiter.allow("10.0.0.2", now) {
+		t.Error("Expected other address to have its own limit")
+	}
+
+	// refills at 2 tokens per second
+	if !limiter.allow("10.0.0.1", now.Add(500*time.Millisecond)) {
+		t.Error("Expected command to be allowed after refill")
+	}
+
+	if limiter.allow("10.0.0.1", now.Add(500*time.Millisecond)) {
+		t.Error("Expected command to be throttled after using refill")
+	}
+}
+
+func Test_rateLimiter_Disabled(t *testing.T) {
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !newRateLimiter(0, 0).allow("10.0.0.1", now) {
+			t.Error("Expected limiter without a rate to allow everything")
+		}
+	}
+
+	var limiter *rateLimiter
+
+	if !limiter.allow("10.0.0.1", now) {
+		t.Error("Expected nil limiter to allow everything")
+	}
+}
+
+func Test_rateLimiter_setLimit(t *testing.T) {
+	limiter := newRateLimiter(0, 0)
+	now := time.Now()
+
+	limiter.setLimit(1, 1)
+
+	if !limiter.allow("10.0.0.1", now) {
+		t.Error("Expected first command to be allowed")
+	}
+
+	if limiter.allow("10.0.0.1", now) {
+		t.Error("Expected command to be throttled after the limit was set")
+	}
+
+	limiter.setLimit(0, 0)
+
+	if !limiter.allow("10.0.0.1", now) {
+		t.Error("Expected command to be allowed after the limit was removed")
+	}
+}
+
+func Test_rateLimiter_prune(t *testing.T) {
+	limiter := newRateLimiter(1, 1)
+	now := time.Now()
+
+	limiter.allow("10.0.0.1", now)
+	limiter.allow("10.0.0.2", now.Add(rateLimitPruneInterval))
+
+	if _, found := limiter.buckets["10.0.0.1"]; found {
+		t.Error("Expected unused bucket to be pruned")
+	}
+}
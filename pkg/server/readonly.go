@@ -0,0 +1,27 @@
+package server
+
+import "errors"
+
+// arguments of the read-only command
+const (
+	readOnlyOn  = "on"
+	readOnlyOff = "off"
+)
+
+var errInvalidReadOnlyMode = errors.New("read-only mode must be on or off")
+
+// isReadOnly returns whether client writes are being rejected.
+func (s *Server) isReadOnly() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.readOnly
+}
+
+// setReadOnly turns read-only mode on or off, for maintenance and failover.
+func (s *Server) setReadOnly(readOnly bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.readOnly = readOnly
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_ReadOnly(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+	srv := NewServer(store, Config{ReadOnly: true})
+
+	go handle(testLogger, newConnection(server), store, nil,
+		&handlerConfig{readOnly: srv.isReadOnly, setReadOnly: srv.setReadOnly})
+
+	checkRequestResponse(t, client, "put12bb13999", formatError(reasonReadOnly)) // writes rejected
+	checkRequestResponse(t, client, "del12bb", formatError(reasonReadOnly))
+	checkRequestResponse(t, client, "get12bb0", "nil") // reads still served
+	checkRequestResponse(t, client, "rdo13off", "ack")
+	checkRequestResponse(t, client, "put12bb13999", "ack")
+	checkRequestResponse(t, client, "rdo12on", "ack")
+	checkRequestResponse(t, client, "del12bb", formatError(reasonReadOnly))
+	checkRequestResponse(t, client, "get12bb0", "val13999")
+	checkRequestResponse(t, client, "bye", "")
+}
@@ -0,0 +1,102 @@
+package server
+
+import "strings"
+
+// Machine-readable reasons a command was rejected or failed, optionally followed by a space and
+// details, sent with error responses.
+const (
+	reasonInvalidCommand   = "invalid_command"
+	reasonUnknownCommand   = "unknown_command"
+	reasonCommandTooLarge  = "command_too_large"
+	reasonProtocolError    = "protocol_error"
+	reasonAuthFailed       = "auth_failed"
+	reasonUnauthorised     = "unauthorised"
+	reasonCommandDisabled  = "command_disabled"
+	reasonChecksumMismatch = "checksum_mismatch"
+	reasonPeerUnreachable  = "peer_unreachable"
+	reasonTimeout          = "timeout"
+	reasonQuorumNotMet     = "quorum_not_met"
+	reasonNoLeader         = "no_leader"
+	reasonBatchFailed      = "batch_failed"
+	reasonUnknownOption    = "unknown_option"
+	reasonUnknownClient    = "unknown_client"
+	reasonReadOnly         = "read_only"
+	reasonReplica          = "replica"
+	reasonWrongType        = "wrong_type"
+	reasonCommandFailed    = "command_failed"
+	reasonInvalidTimestamp = "invalid_timestamp"
+
+	reasonIdempotencyConflict = "idempotency_conflict"
+)
+
+// reasonCodes holds the 3 digit code of each reason, the first digit giving the kind of error
+// so clients can react to it: 1 invalid command, 2 authentication, 3 timeout or replication,
+// 4 rejected argument, 5 not allowed in the server's current mode.
+var reasonCodes = map[string]string{
+	reasonInvalidCommand:      "100",
+	reasonUnknownCommand:      "101",
+	reasonCommandTooLarge:     "102",
+	reasonProtocolError:       "103",
+	reasonAuthFailed:          "200",
+	reasonUnauthorised:        "201",
+	reasonCommandDisabled:     "202",
+	reasonTimeout:             "300",
+	reasonPeerUnreachable:     "301",
+	reasonQuorumNotMet:        "302",
+	reasonNoLeader:            "303",
+	reasonBatchFailed:         "304",
+	reasonChecksumMismatch:    "400",
+	reasonIdempotencyConflict: "401",
+	reasonUnknownOption:       "402",
+	reasonUnknownClient:       "403",
+	reasonWrongType:           "404",
+	reasonCommandFailed:       "405",
+	reasonInvalidTimestamp:    "406",
+	reasonReadOnly:            "500",
+	reasonReplica:             "501",
+}
+
+// code used for reasons without a code of their own
+const unknownReasonCode = "900"
+
+// reasonsOption was the connection option that enabled reasons in error responses, which are now
+// always included, so is still accepted for older clients.
+const reasonsOption = "reasons"
+
+// formatError returns a structured error response: err followed by the 3 digit code of the reason,
+// then the reason as an argument, e.g. err301228peer_unreachable peer2,peer3.
+func formatError(reason string) string {
+	name, _, _ := strings.Cut(reason, " ")
+
+	code, found := reasonCodes[name]
+	if !found {
+		code = unknownReasonCode
+	}
+
+	return errorResponse + code + formatArgument(reason)
+}
+
+// errorWithReason returns the error response for the reason. Peer connections are sent a bare err,
+// since peers only check writes were acknowledged and older peers don't expect a reason.
+func (s *session) errorWithReason(reason string) string {
+	if s.config.bareErrors {
+		return errorResponse
+	}
+
+	return formatError(reason)
+}
+
+// peerUnreachableReason returns the reason for rejecting a write, listing the unreachable peers.
+func peerUnreachableReason(peers []string) string {
+	return reasonPeerUnreachable + " " + strings.Join(peers, ",")
+}
+
+// setOption enables the connection option, returning the response.
+func (s *session) setOption(name string) string {
+	if name != reasonsOption {
+		s.logger.Info("rejecting unknown option", "option", name)
+		return s.errorWithReason(reasonUnknownOption + " " + name)
+	}
+
+	return ackResponse
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_formatError(t *testing.T) {
+	checkString(t, "err100215invalid_command", formatError(reasonInvalidCommand))
+	checkString(t, "err301228peer_unreachable peer2,peer3",
+		formatError(peerUnreachableReason([]string{"peer2", "peer3"})))
+	checkString(t, "err900213disk_full xyz", formatError("disk_full xyz"))
+}
+
+func Test_handle_StructuredErrors(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, unreachablePeers("peer2", "peer3"),
+		&handlerConfig{acl: NewSharedSecretACL("secret")})
+
+	checkRequestResponse(t, client, "get12bb0", "err201212unauthorised") // not authenticated
+	checkRequestResponse(t, client, "auth15wrong", "err200211auth_failed")
+	checkRequestResponse(t, client, "auth16secret", "ack")
+	checkRequestResponse(t, client, "xyz", "err101215unknown_command")
+	checkRequestResponse(t, client, "get1xd", "err100215invalid_command")
+	checkRequestResponse(t, client, "pck12bb139991800000000", "err400217checksum_mismatch")
+	checkRequestResponse(t, client, "put12bb13999", "err301228peer_unreachable peer2,peer3")
+	checkRequestResponse(t, client, "opt17reasons", "ack") // no longer needed, but still accepted
+	checkRequestResponse(t, client, "opt13foo", "err402218unknown_option foo")
+	checkRequestResponse(t, client, "bye", "") // shutdown
+}
+
+func Test_handle_BareErrors(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{bareErrors: true})
+
+	checkRequestResponse(t, client, "xyz", "err") // peers don't expect a reason
+	checkRequestResponse(t, client, "bye", "")    // shutdown
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// command not performed, the key being held by other servers, the client address of one of which
+	// follows, for the client to send commands for the key to from now on
+	movedResponse = "mov"
+
+	// command not performed, the server shutting down, the client address of another server holding
+	// the key follows, for the client to send the command to this time
+	askResponse = "ask"
+)
+
+var errInvalidClientAddress = errors.New("invalid client address")
+
+// ClientAddresses holds the client address of each of the other servers, keyed by peer address, that
+// clients are redirected to.
+type ClientAddresses map[string]string
+
+// ParseClientAddresses parses a comma-separated list of peer=client address pairs, e.g.
+// "peer2:9001=peer2:8001,peer3:9001=peer3:8001".
+func ParseClientAddresses(list string) (ClientAddresses, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	addresses := make(ClientAddresses)
+
+	for _, pair := range strings.Split(list, ",") {
+		peer, client, found := strings.Cut(pair, "=")
+		if !found || peer == "" || client == "" {
+			return nil, fmt.Errorf("%w: %s", errInvalidClientAddress, pair)
+		}
+
+		addresses[peer] = client
+	}
+
+	return addresses, nil
+}
+
+// redirect returns the response redirecting the command to the client address of the first of the
+// servers that is known, or false if none are.
+func (s *session) redirect(response string, servers []string) (string, bool) {
+	for _, server := range servers {
+		if address, found := s.config.clientAddresses[server]; found {
+			return response + formatArgument(address), true
+		}
+	}
+
+	return "", false
+}
+
+// redirectPipelined redirects the commands the client pipelined after the connection began closing,
+// when the server is shutting down, to another server holding their key, so the client can retry them
+// there.
+func (s *session) redirectPipelined(buffer string) {
+	if s.config.shuttingDown == nil || !s.config.shuttingDown() {
+		return
+	}
+
+	for {
+		command, _ := parseCommand(buffer)
+		if command == nil {
+			return
+		}
+
+		buffer = buffer[len(command.originalText):]
+
+		var servers []string
+
+		if s.sharded(command) {
+			servers = s.config.ring.owners(command.key)
+		} else {
+			for _, peer := range s.peers {
+				if !peer.isRemoved() {
+					servers = append(servers, peer.address)
+				}
+			}
+		}
+
+		response, found := s.redirect(askResponse, servers)
+		if !found {
+			return
+		}
+
+		s.logger.Info("shutting down, redirecting command", "command", command.originalText, "response", response)
+
+		if err := reliableWrite(s.conn, response); err != nil {
+			return
+		}
+	}
+}
+
+// isShuttingDown returns whether the server has started shutting down.
+func (s *Server) isShuttingDown() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.closing
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_ParseClientAddresses(t *testing.T) {
+	addresses, err := ParseClientAddresses("server2:9001=server2:8001,server3:9001=server3:8001")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	expected := ClientAddresses{"server2:9001": "server2:8001", "server3:9001": "server3:8001"}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("Expected %v but got %v", expected, addresses)
+	}
+
+	for _, invalid := range []string{"server2:9001", "=server2:8001", "server2:9001="} {
+		if _, err := ParseClientAddresses(invalid); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
+
+func Test_handle_ShardedRedirect(t *testing.T) {
+	server1, client := net.Pipe()
+	_, peer2 := net.Pipe()
+
+	ring := newHashRing("server1", []string{"server2"}, 1, 0)
+	peers := connectedPeers(peer2)
+	peers[0].address = "server2"
+
+	local, remote := shardedKey(ring, true), shardedKey(ring, false)
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), peers,
+		&handlerConfig{ring: ring, clientAddresses: ClientAddresses{"server2": "client2:8001"}})
+
+	checkRequestResponse(t, client, "put11"+local+"13999", ackResponse)
+
+	// commands for keys held by another server aren't forwarded
+	checkRequestResponse(t, client, "put11"+remote+"13999", "mov"+formatArgument("client2:8001"))
+	checkRequestResponse(t, client, "get11"+remote+"0", "mov"+formatArgument("client2:8001"))
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_session_redirectPipelined(t *testing.T) {
+	server1, client := net.Pipe()
+	_, peer2 := net.Pipe()
+
+	peers := connectedPeers(peer2)
+	peers[0].address = "server2"
+
+	shuttingDown := false
+
+	s := &session{logger: testLogger, conn: newConnection(server1), peers: peers, config: &handlerConfig{
+		clientAddresses: ClientAddresses{"server2": "client2:8001"},
+		shuttingDown:    func() bool { return shuttingDown },
+	}}
+
+	// only when shutting down, rather than the connection closing for any other reason
+	s.redirectPipelined("get11a0")
+
+	shuttingDown = true
+
+	go s.redirectPipelined("get11a0put11b111put11")
+
+	// the last command is incomplete
+	read(t, client, "ask"+formatArgument("client2:8001"))
+	read(t, client, "ask"+formatArgument("client2:8001"))
+}
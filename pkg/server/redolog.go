@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"time"
+)
+
+const defaultRedoLogLimit = 10000
+
+var errPeerBehind = errors.New("peer has earlier writes to retry first")
+
+// replicateOrRecord sends the write to the peer as replicate does, but if it fails records it in the
+// peer's redo log to be retried by catchUp. Writes sent while earlier ones are still in the redo log
+// are recorded without being sent, so the peer applies every write in order. Writes from other
+// connections waiting to be sent meanwhile are sent along with it in a single batch, if the peer
+// supports them, so a burst of writes doesn't wait for an acknowledgement of each in turn. When the
+// peer's queue is full, the write is handled according to the backpressure policy, writes not
+// waited for being reported as not yet applied.
+func (p *pooledPeer) replicateOrRecord(mutation string, now time.Time) (string, error) {
+	write := &pendingWrite{mutation: mutation}
+
+	wait, err := p.queue.add(write)
+	if err != nil {
+		p.logger.Warn("outbound queue full, shedding write", "command", mutation)
+		return "", err
+	}
+
+	if !wait {
+		p.logger.Debug("outbound queue full, sending write in the background", "command", mutation)
+		p.sendInBackground(write)
+
+		return "", errSentInBackground
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// may already have been sent in a batch, while waiting for the mutex
+	for !write.sent {
+		p.sendPending(now)
+	}
+
+	return write.response, write.err
+}
+
+// record adds the write to the redo log, dropping it if the log is full, must be called with the
+// mutex locked.
+func (p *pooledPeer) record(mutation string) {
+	switch {
+	case p.redoLimit == 0:
+		return
+
+	case len(p.redo) >= p.redoLimit:
+		p.redoDropped++
+		p.logger.Warn("redo log full, dropping write", "command", mutation)
+
+	default:
+		p.redo = append(p.redo, mutation)
+	}
+}
+
+// catchUp resends the writes in the redo log in order, stopping at the first that fails, which is
+// retried once the backoff delay has passed. Writes recorded meanwhile are resent too, so the
+// mutex is only held for one write at a time.
+func (p *pooledPeer) catchUp(now time.Time) {
+	delivered := 0
+
+	for p.resendOldest(now) {
+		delivered++
+	}
+
+	if delivered > 0 {
+		remaining, _ := p.redoLength()
+		p.logger.Info("resent writes from redo log", "writes", delivered, "remaining", remaining)
+	}
+}
+
+// resendOldest resends the oldest write in the redo log, removing it if the peer replied,
+// returning whether it was.
+func (p *pooledPeer) resendOldest(now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.redo) == 0 {
+		return false
+	}
+
+	response, err := p.exchange(p.redo[0], now, readAck)
+	if err != nil {
+		return false
+	}
+
+	if !writeApplied(response) {
+		p.logger.Warn("peer didn't apply write from redo log", "command", p.redo[0], "response", response)
+	}
+
+	p.redo = p.redo[1:]
+
+	return true
+}
+
+// redoLength returns the number of writes in the redo log, and how many were dropped since it was full.
+func (p *pooledPeer) redoLength() (int, int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.redo), p.redoDropped
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_pooledPeer_RedoLog(t *testing.T) {
+	failed, _ := net.Pipe()
+	_ = failed.Close()
+
+	peer := connectedPeers(failed)[0]
+	peer.redoLimit = 2
+	now := time.Now()
+
+	if _, err := peer.replicateOrRecord("put12aa11x", now); err == nil {
+		t.Fatal("Expected replicating to fail")
+	}
+
+	// not sent while an earlier write is still to be retried
+	if _, err := peer.replicateOrRecord("put12aa11y", now); !errors.Is(err, errPeerBehind) {
+		t.Error("Expected peer behind error but got: ", err)
+	}
+
+	_, _ = peer.replicateOrRecord("put12bb11z", now) // redo log full
+
+	if length, dropped := peer.redoLength(); length != 2 || dropped != 1 {
+		t.Errorf("Expected 2 writes to retry and 1 dropped but got %d and %d", length, dropped)
+	}
+
+	peerStore := kvstore.NewKVStore()
+	peer.dial = func(string) (net.Conn, error) {
+		server, client := net.Pipe()
+
+		go handle(testLogger, newConnection(server), peerStore, nil, &handlerConfig{})
+
+		return client, nil
+	}
+
+	peer.catchUp(now.Add(time.Hour))
+
+	if length, _ := peer.redoLength(); length != 0 {
+		t.Errorf("Expected every write retried but %d remain", length)
+	}
+
+	// applied in order
+	if value, _ := kvstore.Read(peerStore, "aa"); value != "y" {
+		t.Errorf("Expected y but got %s", value)
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"tcp/pkg/kvstore"
+)
+
+// Command is a command of the protocol. The built in commands are registered as commands, and a program
+// using the server as a library can add its own with RegisterCommand.
+type Command interface {
+	// Name returns the text the command starts with, at least 3 characters long.
+	Name() string
+
+	// Parse parses the command at the start of the buffer, which starts with its name, returning the
+	// command parsed, ready to execute, and its length, or a nil command if all of it hasn't been read
+	// yet. Any further commands pipelined after it are left in the buffer.
+	Parse(buffer string) (Command, int, error)
+
+	// Key returns the key the command parsed accesses, or empty if none, so access control lists' key
+	// prefixes and sharding apply to it.
+	Key() string
+
+	// Writes returns whether the command parsed changes the store, so it is rejected in read-only mode
+	// and by read replicas, and is replicated to the peers.
+	Writes() bool
+
+	// Execute performs the command parsed on the store, returning the response to send, such as
+	// AckResponse. An error is sent to the client as an error response.
+	Execute(ctx context.Context, store kvstore.Store) (string, error)
+}
+
+// responses a registered command can send
+const (
+	AckResponse = ackResponse
+	NilResponse = "nil"
+)
+
+// length of the shortest command name, by which commands are looked up
+const minCommandNameLength = 3
+
+var (
+	errInvalidCommandName = errors.New("invalid command name")
+	errDuplicateCommand   = errors.New("command name clashes with another command")
+	errInvalidParse       = errors.New("invalid length parsed")
+	errBuiltinCommand     = errors.New("built in command performed by the connection")
+)
+
+// commandRegistry holds every command, keyed by the first characters of their names.
+type commandRegistry struct {
+	mutex    sync.RWMutex
+	commands map[string][]Command
+	names    []string
+	longest  int
+}
+
+// commands holds the built in commands and any registered by RegisterCommand.
+var commands = newCommandRegistry(builtinCommands)
+
+func newCommandRegistry(builtins []*builtinCommand) *commandRegistry {
+	r := &commandRegistry{commands: map[string][]Command{}}
+
+	for _, builtin := range builtins {
+		if err := r.register(builtin); err != nil {
+			panic(err)
+		}
+	}
+
+	return r
+}
+
+// RegisterCommand adds the command to the protocol of every server, alongside the built in commands, so
+// must be called before any server is started. Commands registered are performed like the built in
+// commands on the store, one at a time by each connection, those that write being replicated to the peers,
+// which must have registered them too. Their names can't start with, or be the start of, another
+// command's name, as commands are identified by the text they start with.
+func RegisterCommand(command Command) error {
+	return commands.register(command)
+}
+
+func (r *commandRegistry) register(command Command) error {
+	name := command.Name()
+
+	if len(name) < minCommandNameLength {
+		return fmt.Errorf("%w: %q", errInvalidCommandName, name)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, other := range r.names {
+		if strings.HasPrefix(name, other) || strings.HasPrefix(other, name) {
+			return fmt.Errorf("%w: %s and %s", errDuplicateCommand, name, other)
+		}
+	}
+
+	key := name[:minCommandNameLength]
+	r.commands[key] = append(r.commands[key], command)
+	r.names = append(r.names, name)
+	r.longest = max(r.longest, len(name))
+
+	return nil
+}
+
+// find returns the command the buffer starts with, or nil if it doesn't start with one.
+func (r *commandRegistry) find(buffer string) Command {
+	if len(buffer) < minCommandNameLength {
+		return nil
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, command := range r.commands[buffer[:minCommandNameLength]] {
+		if strings.HasPrefix(buffer, command.Name()) {
+			return command
+		}
+	}
+
+	return nil
+}
+
+// isPrefix returns whether the buffer is the start of a command's name.
+func (r *commandRegistry) isPrefix(buffer string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, name := range r.names {
+		if strings.HasPrefix(name, buffer) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isName returns whether there is a command with the name.
+func (r *commandRegistry) isName(name string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, other := range r.names {
+		if other == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// longestName returns the length of the longest command name.
+func (r *commandRegistry) longestName() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.longest
+}
+
+// parse parses the command, found at the start of the buffer, returning the request to perform, or nil if
+// all of it hasn't been read yet.
+func (r *commandRegistry) parse(command Command, buffer string) (*commandRequest, error) {
+	parsed, length, err := command.Parse(buffer)
+	if err != nil || parsed == nil {
+		return nil, err //nolint:wrapcheck // built in commands' errors are already wrapped
+	}
+
+	if builtin, ok := parsed.(*builtinCommand); ok {
+		return builtin.request, nil
+	}
+
+	if length < len(command.Name()) || length > len(buffer) {
+		return nil, fmt.Errorf("%w: %d by %s command", errInvalidParse, length, command.Name())
+	}
+
+	return &commandRequest{command: customCommand, key: parsed.Key(), originalText: buffer[:length], custom: parsed},
+		nil
+}
+
+// builtinCommand is a command built into the server, parsed into a request the connection performs. Those
+// performed on the store are executed by storeCommands, the others depend on the connection and the
+// server's settings rather than just the store, so are performed by the connection itself.
+type builtinCommand struct {
+	name  string
+	parse func(buffer string) (*commandRequest, bool, error)
+
+	// the fields following the name, so the command can be read before it is parsed
+	fields []field
+
+	// once parsed
+	request *commandRequest
+}
+
+func (c *builtinCommand) Name() string {
+	return c.name
+}
+
+func (c *builtinCommand) Parse(buffer string) (Command, int, error) {
+	request, incomplete, err := c.parse(buffer)
+	if err != nil || incomplete {
+		return nil, 0, err
+	}
+
+	return &builtinCommand{name: c.name, request: request}, len(request.originalText), nil
+}
+
+func (c *builtinCommand) Key() string {
+	if !hasKey(c.request) {
+		return ""
+	}
+
+	return c.request.key
+}
+
+func (c *builtinCommand) Writes() bool {
+	return isMutation(c.request)
+}
+
+func (c *builtinCommand) Execute(ctx context.Context, store kvstore.Store) (string, error) {
+	return executeRequest(ctx, store, c.request)
+}
+
+// FormatArgument returns the text as an argument, as sent in commands and responses: the number of digits
+// in its length, its length in bytes, then the text itself, e.g. 13abc.
+func FormatArgument(text string) string {
+	return formatArgument(text)
+}
+
+// ParseArguments parses the number of arguments at the start of the buffer, formatted by FormatArgument,
+// returning them and their combined length, or nil if they haven't all been read yet.
+func ParseArguments(buffer string, count int) ([]string, int, error) {
+	arguments, remaining, incomplete, err := parseArguments(buffer, count)
+	if err != nil || incomplete {
+		return nil, 0, err
+	}
+
+	return arguments, len(buffer) - len(remaining), nil
+}
+
+// ValueResponse returns the response sending a value.
+func ValueResponse(value string) string {
+	return "val" + formatArgument(value)
+}
+
+// ListResponse returns the response sending a list of values.
+func ListResponse(items []string) string {
+	return listResponse(items)
+}
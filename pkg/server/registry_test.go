@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+var errEmptySuffix = errors.New("empty suffix")
+
+// appendCommand appends to the value of a key, as an example of a command registered by a program using
+// the server as a library.
+type appendCommand struct {
+	key    string
+	suffix string
+}
+
+func (c *appendCommand) Name() string {
+	return "app"
+}
+
+func (c *appendCommand) Parse(buffer string) (Command, int, error) {
+	arguments, length, err := ParseArguments(buffer[len(c.Name()):], 2)
+	if err != nil || arguments == nil {
+		return nil, 0, err
+	}
+
+	return &appendCommand{arguments[0], arguments[1]}, len(c.Name()) + length, nil
+}
+
+func (c *appendCommand) Key() string {
+	return c.key
+}
+
+func (c *appendCommand) Writes() bool {
+	return true
+}
+
+func (c *appendCommand) Execute(_ context.Context, store kvstore.Store) (string, error) {
+	if c.suffix == "" {
+		return "", errEmptySuffix
+	}
+
+	value, _ := store.Read(c.key)
+	store.Write(c.key, value+c.suffix)
+
+	return AckResponse, nil
+}
+
+func registerAppend(t *testing.T) {
+	t.Helper()
+
+	// registered once for every test, however many times they run
+	if err := RegisterCommand(&appendCommand{}); err != nil && !errors.Is(err, errDuplicateCommand) {
+		t.Fatal("Unable to register: ", err)
+	}
+}
+
+func Test_RegisterCommand_Invalid(t *testing.T) {
+	registry := newCommandRegistry(builtinCommands)
+
+	// in order, as each registered clashes with those after
+	for _, test := range []struct {
+		name     string
+		expected error
+	}{
+		{"ap", errInvalidCommandName},
+		{"putx", errDuplicateCommand}, // starts with put
+		{"aut", errDuplicateCommand},  // the start of auth
+		{"abc", nil},
+		{"abcd", errDuplicateCommand},
+		{"other", nil},
+	} {
+		if err := registry.register(&builtinCommand{name: test.name}); !errors.Is(err, test.expected) {
+			t.Errorf("Expected %v for %s but got %v", test.expected, test.name, err)
+		}
+	}
+}
+
+func Test_parseCommand_Registered(t *testing.T) {
+	registerAppend(t)
+
+	command, err := parseCommand("app11a13foodel11a")
+	if err != nil || command == nil || command.originalText != "app11a13foo" || command.name() != "app" {
+		t.Fatalf("Expected the registered command but got %v, %v", command, err)
+	}
+
+	if command.key != "a" || !isMutation(command) {
+		t.Errorf("Expected a write to key a but got %v", command)
+	}
+
+	if custom, ok := command.custom.(*appendCommand); !ok || custom.key != "a" || custom.suffix != "foo" {
+		t.Errorf("Expected the command parsed but got %v", command.custom)
+	}
+
+	if command, err := parseCommand("app11a1"); command != nil || err != nil {
+		t.Errorf("Expected incomplete but got %v, %v", command, err)
+	}
+}
+
+func Test_handle_RegisteredCommand(t *testing.T) {
+	registerAppend(t)
+
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "app11a13foo", "ack")
+	checkRequestResponse(t, client, "app11a13bar", "ack")
+	checkRequestResponse(t, client, "get11a0", "val16foobar")
+	checkRequestResponse(t, client, "app11a10", formatError(reasonCommandFailed+" empty suffix"))
+
+	// written a byte at a time, the registered command being parsed as a whole after each
+	go func() {
+		for _, b := range []byte("app11b11x" + "get11b0") {
+			write(t, client, string([]byte{b}))
+		}
+	}()
+
+	read(t, client, "ack")
+	read(t, client, "val11x")
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_RegisteredCommandReplicated(t *testing.T) {
+	registerAppend(t)
+
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2), &handlerConfig{})
+
+	checkDistributedRequestResponse(t, client, "app11a13foo", []net.Conn{server2}, "ack")
+	checkRequestResponse(t, client, "get11a0", "val13foo")
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_RegisteredCommandReadOnly(t *testing.T) {
+	registerAppend(t)
+
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil,
+		&handlerConfig{readOnly: func() bool { return true }})
+
+	checkRequestResponse(t, client, "app11a13foo", formatError(reasonReadOnly))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_RegisteredCommandReplica(t *testing.T) {
+	registerAppend(t)
+
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil,
+		&handlerConfig{replica: true, primary: "primary:1"})
+
+	checkRequestResponse(t, client, "app11a13foo", formatError(replicaReason("primary:1")))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_builtinCommand_Execute(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	for _, test := range []struct {
+		text     string
+		expected string
+	}{
+		{"put11a13foo", "ack"},
+		{"get11a0", "val13foo"},
+		{"del11a", "ack"},
+		{"get11a0", "nil"},
+	} {
+		command, _, err := commands.find(test.text).Parse(test.text)
+		if err != nil {
+			t.Fatal("Unable to parse: ", err)
+		}
+
+		if response, err := command.Execute(context.Background(), store); response != test.expected || err != nil {
+			t.Errorf("Expected %s for %s but got %s, %v", test.expected, test.text, response, err)
+		}
+	}
+
+	// performed by the connection rather than on the store
+	command, _, _ := commands.find("png").Parse("png")
+	if _, err := command.Execute(context.Background(), store); !errors.Is(err, errBuiltinCommand) {
+		t.Errorf("Expected %v but got %v", errBuiltinCommand, err)
+	}
+}
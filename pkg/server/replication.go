@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ReplicationMode determines when client writes are acknowledged, relative to replicating them to the peers.
+type ReplicationMode int
+
+const (
+	// ReplicationSync acknowledges writes once applied locally and by every reachable peer.
+	ReplicationSync ReplicationMode = iota
+
+	// ReplicationAsync acknowledges writes once applied locally, then replicates them to the peers
+	// in the background, so client latency doesn't depend on the slowest peer.
+	ReplicationAsync ReplicationMode = iota
+)
+
+const defaultReplicationQueueLimit = 10000
+
+// how long to wait before retrying a write to a peer that couldn't be replicated to in the background
+const asyncRetryInterval = 100 * time.Millisecond
+
+var errUnknownReplicationMode = errors.New("unknown replication mode")
+
+// ParseReplicationMode returns the mode with the specified name: sync or async.
+func ParseReplicationMode(name string) (ReplicationMode, error) {
+	switch name {
+	case "sync":
+		return ReplicationSync, nil
+
+	case "async":
+		return ReplicationAsync, nil
+
+	default:
+		return ReplicationSync, fmt.Errorf("%w: %s", errUnknownReplicationMode, name)
+	}
+}
+
+// asyncReplicator replicates writes to each peer in the background, in the order they were added,
+// from a queue of up to limit writes per peer. Writes beyond the limit are dropped, and writes to
+// an unreachable peer are retried until it is reachable again (or removed), so the queue absorbs
+// short outages. Writes still queued when the server shuts down are not replicated.
+type asyncReplicator struct {
+	logger *slog.Logger
+	limit  int
+	done   <-chan struct{}
+
+	mutex  sync.Mutex
+	queues map[*pooledPeer]*asyncQueue
+}
+
+// asyncQueue holds the writes waiting to be replicated to a peer.
+type asyncQueue struct {
+	mutations chan string
+	dropped   int
+}
+
+// asyncStats is the state of the queue of a peer, reported by the info command.
+type asyncStats struct {
+	depth   int
+	dropped int
+}
+
+func newAsyncReplicator(logger *slog.Logger, limit int, done <-chan struct{}) *asyncReplicator {
+	if limit < 1 {
+		limit = defaultReplicationQueueLimit
+	}
+
+	return &asyncReplicator{logger: logger, limit: limit, done: done, queues: make(map[*pooledPeer]*asyncQueue)}
+}
+
+// add queues the write for each of the peers, dropping it for those whose queue is full.
+func (r *asyncReplicator) add(peers []*pooledPeer, mutation string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, peer := range peers {
+		queue, found := r.queues[peer]
+		if !found {
+			queue = &asyncQueue{mutations: make(chan string, r.limit)}
+			r.queues[peer] = queue
+
+			go r.drain(peer, queue)
+		}
+
+		select {
+		case queue.mutations <- mutation:
+
+		default:
+			queue.dropped++
+			r.logger.Warn("replication queue full, dropping write", "peer", peer.address, "command", mutation)
+		}
+	}
+}
+
+// drain replicates the queued writes to the peer until shutdown, or the peer is removed.
+func (r *asyncReplicator) drain(peer *pooledPeer, queue *asyncQueue) {
+	for {
+		select {
+		case mutation := <-queue.mutations:
+			if !r.deliver(peer, coalesce(mutation, queue)) {
+				r.mutex.Lock()
+				delete(r.queues, peer)
+				r.mutex.Unlock()
+
+				return
+			}
+
+		case <-r.done:
+			if pending := len(queue.mutations); pending > 0 {
+				r.logger.Warn("shutting down, writes not replicated", "peer", peer.address, "writes", pending)
+			}
+
+			return
+		}
+	}
+}
+
+// coalesce returns the write along with those queued after it, up to a batch's worth, so a burst of
+// writes is replicated in as few batches as possible.
+func coalesce(mutation string, queue *asyncQueue) []string {
+	mutations := []string{mutation}
+
+	for len(mutations) < maxBatchWrites {
+		select {
+		case next := <-queue.mutations:
+			mutations = append(mutations, next)
+
+		default:
+			return mutations
+		}
+	}
+
+	return mutations
+}
+
+// deliver replicates the writes to the peer, in batches if it supports them, retrying each until it
+// succeeds, returning false if the peer was removed or the server shut down first.
+func (r *asyncReplicator) deliver(peer *pooledPeer, mutations []string) bool {
+	for len(mutations) > 0 {
+		sent, response, err := peer.replicateBatch(mutations, time.Now())
+
+		switch {
+		case err == nil:
+			if !writeApplied(response) {
+				r.logger.Warn("peer didn't apply writes", "peer", peer.address, "writes", sent,
+					"response", response)
+			}
+
+			mutations = mutations[sent:]
+
+			continue
+
+		case errors.Is(err, errPeerClosed):
+			r.logger.Info("peer removed, writes not replicated", "peer", peer.address)
+			return false
+		}
+
+		select {
+		case <-time.After(asyncRetryInterval):
+
+		case <-r.done:
+			return false
+		}
+	}
+
+	return true
+}
+
+// stats returns the state of the queue of each peer that has been replicated to, by address.
+func (r *asyncReplicator) stats() map[string]asyncStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats := make(map[string]asyncStats, len(r.queues))
+
+	for peer, queue := range r.queues {
+		stats[peer.address] = asyncStats{depth: len(queue.mutations), dropped: queue.dropped}
+	}
+
+	return stats
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_ParseReplicationMode(t *testing.T) {
+	if mode, err := ParseReplicationMode("async"); mode != ReplicationAsync || err != nil {
+		t.Errorf("Expected async but got %d: %v", mode, err)
+	}
+
+	if _, err := ParseReplicationMode("eventual"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func Test_handle_AsyncReplication(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+	store := kvstore.NewKVStore()
+	done := make(chan struct{})
+
+	defer close(done)
+
+	go handle(testLogger, newConnection(server1), store, connectedPeers(peer2),
+		&handlerConfig{replicator: newAsyncReplicator(testLogger, 10, done)})
+
+	// acknowledged before the peer has received the write
+	checkRequestResponse(t, client, "put12bb13999", "ack")
+	checkRequestResponse(t, client, "get12bb0", "val13999")
+
+	// then replicated in the background
+	if mutation, err := reliableRead(server2, len("put12bb13999")); mutation != "put12bb13999" || err != nil {
+		t.Fatalf("Expected write replicated but got %s: %v", mutation, err)
+	}
+
+	write(t, server2, "ack")
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_asyncReplicator_Drops(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	replicator := newAsyncReplicator(testLogger, 1, done)
+	peers := unreachablePeers("peer2")
+
+	// taken from the queue, then retried while the peer is unreachable
+	replicator.add(peers, "put12aa11x")
+	waitForQueue(t, replicator, "peer2", 0)
+
+	replicator.add(peers, "put12bb11x")
+	replicator.add(peers, "put12cc11x") // queue full
+
+	if stats := replicator.stats()["peer2"]; stats.depth != 1 || stats.dropped != 1 {
+		t.Errorf("Expected 1 queued and 1 dropped but got %+v", stats)
+	}
+}
+
+func Test_Server_AsyncReplicationInfo(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		OtherServers:    []string{"peer2"},
+		ReplicationMode: ReplicationAsync,
+	})
+
+	srv.peerPool.get([]string{"peer2"})
+
+	if info := srv.info(); !strings.Contains(info, "peer.peer2.queue=0 peer.peer2.dropped=0") {
+		t.Error("Expected queue stats but got: ", info)
+	}
+}
+
+// waitForQueue waits for the number of writes queued for the peer to reach the depth.
+func waitForQueue(t *testing.T, replicator *asyncReplicator, peer string, depth int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+
+	for replicator.stats()[peer].depth != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d writes queued but got %d", depth, replicator.stats()[peer].depth)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
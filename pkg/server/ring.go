@@ -0,0 +1,35 @@
+package server
+
+import "tcp/pkg/hashring"
+
+// hashRing is the ring of this server (self) and the others, assigning each key to a subset of them.
+type hashRing struct {
+	self string
+	ring *hashring.Ring
+}
+
+// newHashRing returns a ring assigning each key to the number of replicas of this server (self)
+// and the others.
+func newHashRing(self string, others []string, replicas int, virtualNodes int) *hashRing {
+	return &hashRing{self: self, ring: hashring.New(append([]string{self}, others...), replicas, virtualNodes)}
+}
+
+// setServers replaces the other servers on the ring.
+func (r *hashRing) setServers(others []string) {
+	r.ring.SetServers(append([]string{r.self}, others...))
+}
+
+// owners returns the servers holding the key.
+func (r *hashRing) owners(key string) []string {
+	return r.ring.Owners(key)
+}
+
+// owns returns whether the server holds the key.
+func (r *hashRing) owns(server string, key string) bool {
+	return contains(r.owners(key), server)
+}
+
+// local returns whether this server holds the key.
+func (r *hashRing) local(key string) bool {
+	return r.owns(r.self, key)
+}
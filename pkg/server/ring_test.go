@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_hashRing_owners(t *testing.T) {
+	servers := []string{"server1", "server2", "server3", "server4"}
+
+	ring := newHashRing(servers[0], servers[1:], 2, 0)
+	other := newHashRing(servers[2], []string{servers[3], servers[1], servers[0]}, 2, 0)
+
+	counts := make(map[string]int)
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owners := ring.owners(key)
+
+		if len(owners) != 2 || owners[0] == owners[1] {
+			t.Fatalf("Expected 2 different owners of %s but got %v", key, owners)
+		}
+
+		// every server assigns the key to the same servers
+		if fmt.Sprint(owners) != fmt.Sprint(other.owners(key)) {
+			t.Errorf("Expected owners of %s %v but got %v", key, owners, other.owners(key))
+		}
+
+		if ring.local(key) != contains(owners, "server1") {
+			t.Errorf("Expected %s held locally %t", key, contains(owners, "server1"))
+		}
+
+		for _, owner := range owners {
+			counts[owner]++
+		}
+	}
+
+	// each server holds half the keys, give or take
+	for _, server := range servers {
+		if counts[server] < 350 || counts[server] > 650 {
+			t.Errorf("Expected %s to hold about 500 keys but got %d", server, counts[server])
+		}
+	}
+}
+
+func Test_hashRing_setServers(t *testing.T) {
+	ring := newHashRing("server1", []string{"server2", "server3"}, 1, 0)
+
+	before := make(map[string]string)
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		before[key] = ring.owners(key)[0]
+	}
+
+	ring.setServers([]string{"server2", "server3", "server4"})
+
+	moved := 0
+
+	for key, owner := range before {
+		switch after := ring.owners(key)[0]; {
+		case after == owner:
+
+		case after == "server4":
+			moved++
+
+		default:
+			t.Errorf("Expected %s to stay on %s or move to server4, but moved to %s", key, owner, after)
+		}
+	}
+
+	// a quarter of the keys move to the new server, give or take
+	if moved < 150 || moved > 350 {
+		t.Errorf("Expected about 250 keys to move but got %d", moved)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Role determines which writes a server accepts.
+type Role int
+
+const (
+	// RolePrimary accepts writes from clients, replicating them to its peers.
+	RolePrimary Role = iota
+
+	// RoleReplica only serves reads to clients, rejecting writes with a redirect to the primary,
+	// and applies the writes replicated to its peer port.
+	RoleReplica Role = iota
+)
+
+var errUnknownRole = errors.New("unknown role")
+
+// ParseRole returns the role with the specified name: primary or replica.
+func ParseRole(name string) (Role, error) {
+	switch name {
+	case "primary":
+		return RolePrimary, nil
+
+	case "replica":
+		return RoleReplica, nil
+
+	default:
+		return RolePrimary, fmt.Errorf("%w: %s", errUnknownRole, name)
+	}
+}
+
+func (r Role) String() string {
+	if r == RoleReplica {
+		return "replica"
+	}
+
+	return "primary"
+}
+
+// replicaReason returns the reason for rejecting a write sent to a replica, with the primary's
+// client address to send it to instead, if known.
+func replicaReason(primary string) string {
+	if primary == "" {
+		return reasonReplica
+	}
+
+	return reasonReplica + " " + primary
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_ParseRole(t *testing.T) {
+	if role, err := ParseRole("replica"); role != RoleReplica || err != nil {
+		t.Error("Expected replica role but got: ", role, err)
+	}
+
+	if _, err := ParseRole("secondary"); err == nil {
+		t.Error("Expected error for unknown role")
+	}
+}
+
+func Test_handle_Replica(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	// client connection
+	go handle(testLogger, newConnection(server1), store, nil,
+		&handlerConfig{replica: true, primary: "primary:8080"})
+
+	// peer connection, applying writes replicated by the primary
+	go handle(testLogger, newConnection(server2), store, nil, &handlerConfig{bareErrors: true})
+
+	checkRequestResponse(t, client, "put12bb13999", formatError(replicaReason("primary:8080")))
+	checkRequestResponse(t, client, "del12bb", formatError(replicaReason("primary:8080")))
+	checkRequestResponse(t, peer, "put12bb13999", "ack")
+	checkRequestResponse(t, client, "get12bb0", "val13999") // reads served
+	checkRequestResponse(t, client, "bye", "")
+	checkRequestResponse(t, peer, "bye", "")
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Sampler records the timing breakdown of 1 in every N commands, as one JSON object per line,
+// for offline latency analysis. A nil Sampler records nothing.
+type Sampler struct {
+	mutex   sync.Mutex
+	every   int
+	count   int
+	encoder *json.Encoder
+}
+
+// commandTiming holds how long each stage of handling a command took.
+type commandTiming struct {
+	queueWait   time.Duration
+	store       time.Duration
+	replication time.Duration
+	write       time.Duration
+}
+
+type commandSample struct {
+	Time          time.Time `json:"time"`
+	Command       string    `json:"command"`
+	Key           string    `json:"key"`
+	QueueWaitNs   int64     `json:"queueWaitNs"`
+	StoreNs       int64     `json:"storeNs"`
+	ReplicationNs int64     `json:"replicationNs"`
+	WriteNs       int64     `json:"writeNs"`
+}
+
+// NewSampler returns a sampler writing 1 in every N commands to the writer.
+func NewSampler(writer io.Writer, every int) *Sampler {
+	if every < 1 {
+		every = 1
+	}
+
+	return &Sampler{every: every, encoder: json.NewEncoder(writer)}
+}
+
+// record writes the command's timings if it is one of the 1 in N sampled.
+func (s *Sampler) record(request *commandRequest, timing *commandTiming) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.count++
+	if s.count%s.every != 0 {
+		return nil
+	}
+
+	sample := commandSample{
+		Time:          time.Now(),
+		Command:       request.name(),
+		Key:           request.key,
+		QueueWaitNs:   timing.queueWait.Nanoseconds(),
+		StoreNs:       timing.store.Nanoseconds(),
+		ReplicationNs: timing.replication.Nanoseconds(),
+		WriteNs:       timing.write.Nanoseconds(),
+	}
+
+	if err := s.encoder.Encode(sample); err != nil {
+		return fmt.Errorf("error writing sample: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package server
+
+import "tcp/pkg/kvstore"
+
+// how many keys are scanned at once, if not requested, and at most
+const (
+	defaultScanCount = 100
+	maxScanCount     = 10000
+)
+
+// scan returns up to limit keys after the key specified (from the first key if empty) and their values,
+// in key order.
+func (s *Server) scan(after string, limit int) []kvstore.Entry {
+	return kvstore.Page(s.store, after, limit)
+}
+
+// scan scans up to limit keys after the key specified, returning the key to continue the scan after
+// (empty once every key has been scanned), then the keys the user is permitted to access and their values
+// alternately. When sharding, only the keys this server holds are scanned.
+func (s *session) scan(after string, limit int) []string {
+	if limit < 1 || limit > maxScanCount {
+		limit = defaultScanCount
+	}
+
+	entries := s.config.scan(after, limit)
+	items := make([]string, 1, 1+2*len(entries))
+
+	if len(entries) == limit {
+		items[0] = entries[len(entries)-1].Key
+	}
+
+	for _, entry := range entries {
+		if s.user == nil || s.user.permitsKey(entry.Key) {
+			items = append(items, entry.Key, entry.Value)
+		}
+	}
+
+	return items
+}
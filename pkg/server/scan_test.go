@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_Scan(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	kvstore.Write(store, "a", "1")
+	kvstore.Write(store, "b", "2")
+	kvstore.Write(store, "c", "3")
+
+	scan := func(after string, limit int) []kvstore.Entry {
+		return kvstore.Page(store, after, limit)
+	}
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{scan: scan})
+
+	checkRequestResponse(t, client, "scn10112", listResponse([]string{"b", "a", "1", "b", "2"}))
+	checkRequestResponse(t, client, "scn11b112", listResponse([]string{"", "c", "3"}))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_ScanACL(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+	acl := NewACL([]User{
+		{Name: "reader", Token: "r", Commands: []string{"scn"}, KeyPrefixes: []string{"team1/"}},
+	})
+
+	kvstore.Write(store, "team1/a", "1")
+	kvstore.Write(store, "team2/a", "2")
+
+	scan := func(after string, limit int) []kvstore.Entry {
+		return kvstore.Page(store, after, limit)
+	}
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{acl: acl, scan: scan})
+
+	checkRequestResponse(t, client, "auth11r", "ack")
+
+	// keys not permitted are skipped, though the scan continues after them
+	checkRequestResponse(t, client, "scn10112", listResponse([]string{"team2/a", "team1/a", "1"}))
+	checkRequestResponse(t, client, "scn17team2/a112", listResponse([]string{""}))
+	checkRequestResponse(t, client, "bye", "")
+}
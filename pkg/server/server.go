@@ -2,52 +2,1010 @@
 package server
 
 import (
-	"io"
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
-	"os"
+	"net/http"
+	"sync"
+	"tcp/pkg/backoff"
 	"tcp/pkg/kvstore"
+	"tcp/pkg/logging"
+	"tcp/pkg/raft"
+	"time"
 )
 
-// StartServer starts the tcp key value store server.
-func StartServer(store *kvstore.KVStore, serverHostnamePort string, peerHostnamePort string, otherServers []string) {
+// ErrServerClosed is returned by ListenAndServe after Shutdown has been called.
+var ErrServerClosed = errors.New("server closed")
+
+var (
+	errNotListening       = errors.New("server is not listening, call Listen first")
+	errTooManyConnections = errors.New("too many connections")
+)
+
+// Config holds the settings of a key value store server.
+type Config struct {
+	// hostname and port to listen on for clients
+	ServerHostnamePort string
+
+	// hostname and port to listen on for server peers
+	PeerHostnamePort string
+
+	// if not empty, hostname and port to listen on for HTTP requests, with GET, PUT and DELETE
+	// of /keys/{key} performed as client commands, and WebSockets to /ws carrying client commands
+	HTTPHostnamePort string
+
+	// peer hostnames and ports of the other servers to replicate client commands to
+	OtherServers []string
+
+	// if not nil, clients must authenticate as one of its users before sending data commands
+	ACL *ACL
+
+	// if not empty, peers must authenticate with this secret before sending data commands,
+	// and this server authenticates with it when connecting to the other servers
+	PeerSecret string
+
+	// if not nil, records the timing breakdown of a sample of client commands
+	Sampler *Sampler
+
+	// where the server logs to (slog.Default() if nil), with the subsystems server for client
+	// connections and peer for peer connections, see logging.Subsystem
+	Logger *slog.Logger
+
+	// if not nil, records every client command, with its result and latency
+	AccessLog *AccessLog
+
+	// if not nil, records every change to the store, so it can be replayed
+	Changelog *Changelog
+
+	// whether the server starts in read-only mode, rejecting writes from clients (but not peers) until
+	// turned off by the read-only command
+	ReadOnly bool
+
+	// which writes the server accepts (defaults to primary): a replica only serves reads to clients,
+	// rejecting writes with a redirect to the Primary client address, and applies the writes its
+	// primaries replicate to it (so must be in their OtherServers)
+	Role    Role
+	Primary string
+
+	// whether clients may clear every key with the flush all command, which is replicated to the peers
+	AllowFlushAll bool
+
+	// if not zero, the most recent client commands (up to SlowLogLength, default 128) that took longer
+	// than the threshold to handle are kept, and reported by the slow log command
+	SlowLogThreshold time.Duration
+	SlowLogLength    int
+
+	// most unparsed input from a client (16 MiB if zero), and number of invalid commands in a row
+	// (3 if zero), allowed before the connection is closed, so garbage input is abandoned
+	MaxCommandSize    int
+	MaxProtocolErrors int
+
+	// how client connections, and connections between peers (both accepted and opened), are tuned
+	ClientTCPOptions TCPOptions
+	PeerTCPOptions   TCPOptions
+
+	// size in bytes above which commands sent to peers are compressed, if they support it, to reduce
+	// the bandwidth used replicating large values between sites (never if zero)
+	PeerCompressionThreshold int
+
+	// whether client writes are acknowledged after being replicated to the peers (sync, the default),
+	// or once applied locally and then replicated in the background (async) from a queue of up to
+	// ReplicationQueueLimit writes per peer (default 10000), beyond which writes are dropped
+	ReplicationMode       ReplicationMode
+	ReplicationQueueLimit int
+
+	// in sync replication mode, the number of servers including this one (or WriteQuorumAll for every server)
+	// that must apply a client write before it is acknowledged. Writes are rejected with a quorum_not_met
+	// error if too few peers are reachable, or apply them in time, though may still have been applied by
+	// some. If zero, writes wait for every reachable peer until the command timeout, and are acknowledged
+	// regardless.
+	WriteQuorum int
+
+	// how client writes are handled when every peer is unreachable, in sync replication mode
+	PeerOutagePolicy OutagePolicy
+
+	// maximum number of writes queued for each unreachable peer, when using the handoff policy
+	HandoffLimit int
+
+	// maximum number of writes kept for each peer (default 10000) after replicating to it failed,
+	// along with those sent since, to be retried in order until the peer catches up
+	RedoLogLimit int
+
+	// maximum number of writes waiting to be sent to each peer (default 1000), in sync replication mode,
+	// and what happens to further writes: wait for space (block, the default), don't replicate them to
+	// the peer (shed), or replicate them without waiting (async)
+	PeerQueueLimit int
+	Backpressure   BackpressurePolicy
+
+	// maximum number of changes and responses waiting to be sent to each connection watching keys
+	// (default 1000), and what happens to further changes: disconnect the connection (the default), drop
+	// the oldest changes waiting, sending a gap marker in their place, or keep them waiting beyond the
+	// buffer, up to twice its size, for up to WatchBlockTimeout (default 100ms) before disconnecting
+	WatchBuffer       int
+	SlowWatcherPolicy SlowWatcherPolicy
+	WatchBlockTimeout time.Duration
+
+	// the classes of change to keys published as keyspace notifications, to the connections subscribed to
+	// their channels (none by default)
+	KeyspaceEvents KeyspaceEvents
+
+	// maximum time to wait for each read from, or write to, a connection (no limit if zero),
+	// so stalled clients can't hold connections open forever
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// how long a client connection can be idle before it is closed (no limit if zero)
+	IdleTimeout time.Duration
+
+	// maximum number of concurrent client connections (no limit if zero), further clients are sent
+	// a busy response and closed.
+	MaxConnections int
+
+	// maximum number of commands per second from each client IP address (no limit if zero),
+	// allowing bursts of up to RateLimitBurst commands (defaults to 1 second's worth).
+	// Further commands are sent a throttle response instead of being performed.
+	RateLimit      float64
+	RateLimitBurst int
+
+	// default time to live of client writes to keys in each namespace, see NamespaceTTLs for precedence
+	NamespaceTTLs NamespaceTTLs
+
+	// how long (default 5 minutes), and for how many writes (default 10000), the responses to writes
+	// sent with idempotency keys are remembered, so client retries aren't applied twice
+	IdempotencyWindow time.Duration
+	IdempotencyLimit  int
+
+	// how long commands wait for the local store and every peer to respond (defaults to 500ms),
+	// and overrides for particular commands, such as longer for large puts
+	CommandTimeout  time.Duration
+	CommandTimeouts CommandTimeouts
+
+	// how retries are delayed, such as handing off writes to peers (backoff.DefaultPolicy if zero)
+	RetryPolicy backoff.Policy
+
+	// if not zero, when starting the server fetches this many of the most read keys from the first
+	// reachable peer, so the most popular keys can be served immediately
+	WarmUpKeys int
+
+	// whether, when starting, the server replaces its keys with a snapshot of those of the first reachable
+	// peer (without their TTLs), before handling any connections, so it doesn't serve stale answers
+	// for writes made before it started
+	SyncOnStart bool
+
+	// whether client writes are agreed by the servers using Raft consensus, instead of being replicated
+	// to the peers, so every server applies the same writes in the same order. ReplicationMode,
+	// WriteQuorum, PeerOutagePolicy and RedoLogLimit are then ignored, and OtherServers can't be
+	// changed by Reload. Reads are still served from the local store, so may be stale on followers.
+	// Applied writes are discarded from the log, a server that is too far behind, or restarts, being sent
+	// a snapshot of every key instead, which must fit in a peer command (16MB). The Raft term and vote
+	// aren't persisted, so a server restarted during an election could vote twice, see package raft.
+	Raft bool
+
+	// if not zero, each key is only held by this many of the servers, assigned by consistent hashing
+	// with VirtualNodes points on the ring per server (defaults to 128), so the cluster can hold more
+	// keys than any one server. Commands for keys this server doesn't hold are forwarded to the servers
+	// that do over the peer connections, returning their response, so clients can send any key to any
+	// server (proxy mode), unless ClientAddresses holds the client address of one of those servers, when
+	// the client is redirected there instead. Every server must have the same settings, and the same
+	// servers in the cluster.
+	ShardReplicas int
+	VirtualNodes  int
+
+	// if not empty, the client address of each of the OtherServers, by peer address, so clients are
+	// redirected to the servers holding a key (sent mov), rather than commands being forwarded when
+	// sharding, and commands pipelined as the server shuts down are redirected to another server (sent ask).
+	// Commands for keys held only by servers missing from it are still forwarded.
+	ClientAddresses ClientAddresses
+
+	// whether concurrent writes to the same key on different servers are resolved by last write wins:
+	// writes are timestamped by a hybrid logical clock, and replicated with their timestamp, then only
+	// applied if later than the last write to their key, so the servers agree on the key's value.
+	// Every server must have the same setting, and it is ignored in Raft mode.
+	LastWriteWins bool
+
+	// whether replicated writes are tagged with the id of the server they were made on and a sequence
+	// number, so a write arriving more than once, or back at the server it was made on (as it can when
+	// peers relay writes), is only applied once. Ignored in Raft mode.
+	TrackOrigins bool
+
+	// if not empty, the servers in the cluster are found by gossiping with these servers' peer ports,
+	// and the other servers they know of, every GossipInterval (defaults to 1 second), instead of only
+	// replicating to OtherServers (which are also gossiped with). Servers can then join the cluster by
+	// gossiping with any server in it, and leave by being shut down.
+	Seeds          []string
+	GossipInterval time.Duration
+
+	// if not zero, every interval the keys are compared with those of a random peer, by exchanging the
+	// hashes of Merkle trees of their keys, and the ranges of the keyspace that differ are repaired
+	// from the peer's keys, so writes a peer missed are eventually applied. Ignored in Raft mode
+	// (which already repairs followers) and when sharding.
+	AntiEntropyInterval time.Duration
+
+	// identifies this server to the others, for Raft, sharding and gossip, which must be the address they
+	// have in OtherServers (defaults to the bound peer address)
+	NodeID string
+}
+
+const handoffInterval = time.Second
+
+// Server is a tcp key value store server.
+type Server struct {
+	config      Config
+	store       *kvstore.KVStore
+	handoff     *handoffQueue
+	replicator  *asyncReplicator
+	idempotency *idempotencyCache
+	hotKeys     *hotKeys
+	watches     *watchHub
+	rateLimiter *rateLimiter
+	peerPool    *peerPool
+	done        chan struct{}
+
+	// closed when a client asks for the server to be shut down
+	shutdownRequested chan struct{}
+	requestShutdown   sync.Once
+
+	serverLogger *slog.Logger
+	peerLogger   *slog.Logger
+	httpLogger   *slog.Logger
+
+	mutex          sync.Mutex
+	closing        bool
+	readOnly       bool
+	clientListener net.Listener
+	peerListener   net.Listener
+	httpListener   net.Listener
+	httpServer     *http.Server
+	id             string
+	raftNode       *raft.Node
+	ring           *hashRing
+	membership     *membership
+	lww            *lastWriteWins
+	origins        *originTracker
+	crdts          *crdtStore
+	repairedKeys   int
+	listeners      []net.Listener
+	connections    map[*connection]struct{}
+	lastID         uint64
+	counts         map[*handlerConfig]int
+	handlers       sync.WaitGroup
+
+	// for the info command
+	started      time.Time
+	commands     *commandCounter
+	clientConfig *handlerConfig
+}
+
+// NewServer returns a server for the key value store, which is closed when the server is shut down.
+func NewServer(store *kvstore.KVStore, config Config) *Server {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	peerLogger := logging.Subsystem(logger, "peer").With("listener", config.PeerHostnamePort)
+	dialer := peerDialer{config.PeerSecret, config.PeerTCPOptions, config.PeerCompressionThreshold}
+	retryPolicy := config.RetryPolicy.OrDefault()
+	done := make(chan struct{})
+
+	redoLogLimit := config.RedoLogLimit
+	if redoLogLimit < 1 {
+		redoLogLimit = defaultRedoLogLimit
+	}
+
+	peerPool := newPeerPool(peerLogger, dialer, retryPolicy, redoLogLimit, config.PeerQueueLimit, config.Backpressure)
+
+	var replicator *asyncReplicator
+
+	if config.ReplicationMode == ReplicationAsync {
+		replicator = newAsyncReplicator(peerLogger, config.ReplicationQueueLimit, done)
+	}
+
+	return &Server{
+		config:      config,
+		store:       store,
+		handoff:     newHandoffQueue(config.HandoffLimit, retryPolicy),
+		replicator:  replicator,
+		idempotency: newIdempotencyCache(config.IdempotencyWindow, config.IdempotencyLimit),
+		hotKeys:     newHotKeys(defaultHotKeysLimit),
+		watches: newWatchHub(config.WatchBuffer, config.SlowWatcherPolicy, config.WatchBlockTimeout,
+			config.KeyspaceEvents),
+		rateLimiter: newRateLimiter(config.RateLimit, config.RateLimitBurst),
+		peerPool:    peerPool,
+		done:        done,
+
+		shutdownRequested: make(chan struct{}),
+		readOnly:          config.ReadOnly,
+		connections:       make(map[*connection]struct{}),
+		counts:            make(map[*handlerConfig]int),
+		started:           time.Now(),
+		commands:          &commandCounter{},
+
+		serverLogger: logging.Subsystem(logger, "server").With("listener", config.ServerHostnamePort),
+		peerLogger:   peerLogger,
+		httpLogger:   logging.Subsystem(logger, "http").With("listener", config.HTTPHostnamePort),
+	}
+}
+
+// ListenAndServe binds to the client and peer ports then handles connections until Shutdown
+// is called, when it returns ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+
+	return s.Serve()
+}
+
+// Listen binds to the client, peer and HTTP (if any) ports, returning an error if any can't be bound.
+// The bound addresses are then available from ClientAddr and PeerAddr, which is useful
+// when listening on port 0 to be allocated an ephemeral port.
+func (s *Server) Listen() error {
+	peerListener, err := s.listen(s.peerLogger, s.config.PeerHostnamePort)
+	if err != nil {
+		return err
+	}
+
+	clientListener, err := s.listen(s.serverLogger, s.config.ServerHostnamePort)
+	if err != nil {
+		_ = peerListener.Close()
+		return err
+	}
+
+	var httpListener net.Listener
+
+	if s.config.HTTPHostnamePort != "" {
+		httpListener, err = s.listen(s.httpLogger, s.config.HTTPHostnamePort)
+		if err != nil {
+			_ = peerListener.Close()
+			_ = clientListener.Close()
+
+			return err
+		}
+	}
+
+	id := s.config.NodeID
+	if id == "" {
+		id = listenerAddress(peerListener)
+	}
+
+	var raftNode *raft.Node
+
+	if s.config.Raft {
+		raftNode = s.newRaftNode(id)
+	}
+
+	var membership *membership
+
+	if len(s.config.Seeds) > 0 {
+		membership = newMembership(id, append(append([]string(nil), s.config.Seeds...), s.config.OtherServers...),
+			s.gossipInterval(), time.Now())
+	}
+
+	var lww *lastWriteWins
+
+	if s.config.LastWriteWins && !s.config.Raft {
+		lww = newLastWriteWins(id)
+	}
+
+	var origins *originTracker
+
+	if s.config.TrackOrigins && !s.config.Raft {
+		origins = newOriginTracker(id, time.Now())
+	}
+
+	// Raft applies every write in the same order, so has no need of replicated data types
+	var crdts *crdtStore
+
+	if !s.config.Raft {
+		crdts = newCRDTStore(id, s.store, time.Now())
+	}
+
+	var ring *hashRing
+
+	if s.config.ShardReplicas > 0 {
+		ring = newHashRing(id, s.config.OtherServers, s.config.ShardReplicas, s.config.VirtualNodes)
+	}
+
+	s.mutex.Lock()
+	s.peerListener = peerListener
+	s.clientListener = clientListener
+	s.httpListener = httpListener
+	s.id = id
+	s.raftNode = raftNode
+	s.ring = ring
+	s.membership = membership
+	s.lww = lww
+	s.origins = origins
+	s.crdts = crdts
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// ClientAddr returns the address clients can connect to, or nil if not yet listening.
+func (s *Server) ClientAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.clientListener == nil {
+		return nil
+	}
+
+	return s.clientListener.Addr()
+}
+
+// HTTPAddr returns the address HTTP requests can be sent to, or nil if not listening for HTTP.
+func (s *Server) HTTPAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.httpListener == nil {
+		return nil
+	}
+
+	return s.httpListener.Addr()
+}
+
+// PeerAddr returns the address peers can connect to, or nil if not yet listening.
+func (s *Server) PeerAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.peerListener == nil {
+		return nil
+	}
+
+	return s.peerListener.Addr()
+}
+
+// Serve handles connections on the ports bound by Listen until Shutdown is called,
+// when it returns ErrServerClosed.
+func (s *Server) Serve() error {
+	s.mutex.Lock()
+	peerListener, clientListener, httpListener := s.peerListener, s.clientListener, s.httpListener
+	raftNode, ring, membership, lww, origins, crdts := s.raftNode, s.ring, s.membership, s.lww, s.origins, s.crdts
+	s.mutex.Unlock()
+
+	if clientListener == nil {
+		return errNotListening
+	}
+
+	var peerACL *ACL
+	if s.config.PeerSecret != "" {
+		peerACL = NewSharedSecretACL(s.config.PeerSecret)
+	}
+
+	// every change, however it was made, is sent to the clients watching the key, published as a keyspace
+	// notification if enabled, and recorded in the changelog, so from before the state synced on start, the
+	// writes replicated by peers and the keys fetched while warming up are applied
+	kvstore.Observe(s.store, func(change kvstore.Change) {
+		s.watches.observe(change)
+
+		if err := s.config.Changelog.record(change, time.Now()); err != nil {
+			s.serverLogger.Error("unable to record change", "error", err)
+		}
+	})
+
+	// before any peer connections are handled, so replicated writes aren't overwritten by the snapshot
+	if s.config.SyncOnStart {
+		s.syncState(s.serverLogger)
+	}
+
+	peerConfig := &handlerConfig{
+		acl:             peerACL,
+		info:            s.info,
+		commandTimeout:  s.config.CommandTimeout,
+		commandTimeouts: s.config.CommandTimeouts,
+		tcpOptions:      s.config.PeerTCPOptions,
+		idempotency:     s.idempotency,
+		hotKeys:         s.hotKeys,
+		snapshot:        s.snapshot,
+		snapshotChunk:   s.snapshotChunk,
+		lww:             lww,
+		origins:         origins,
+		crdts:           crdts,
+		allowFlushAll:   true,
+		bareErrors:      true,
+		peer:            true,
+	}
+
+	if raftNode != nil {
+		peerConfig.raft = s.handleRaft
+	}
+
+	if membership != nil {
+		peerConfig.gossip = s.handleGossip
+	}
+
+	if s.antiEntropyEnabled() {
+		peerConfig.antiEntropy = s.handleAntiEntropy
+	}
+
 	// async - peer commands are not replicated any further
-	go startConnections("peer "+peerHostnamePort+" ", store, peerHostnamePort, nil)
+	go func() {
+		_ = s.serve(s.peerLogger, peerListener, peerConfig)
+	}()
+
+	if raftNode != nil {
+		raftNode.Start()
+	}
+
+	if s.config.WarmUpKeys > 0 {
+		s.warmUp(s.serverLogger)
+	}
+
+	go s.handOffWrites(s.serverLogger)
+
+	if membership != nil {
+		go s.gossip(s.peerLogger, s.gossipInterval())
+	}
+
+	if s.antiEntropyEnabled() {
+		go s.antiEntropy(s.peerLogger, s.config.AntiEntropyInterval)
+	}
+
+	if s.config.IdleTimeout > 0 {
+		go s.reapIdleConnections(s.serverLogger)
+	}
+
+	clientConfig := &handlerConfig{
+		otherServers:      s.peerList(),
+		idleTimeout:       s.config.IdleTimeout,
+		maxConnections:    s.config.MaxConnections,
+		acl:               s.config.ACL,
+		sampler:           s.config.Sampler,
+		accessLog:         s.config.AccessLog,
+		allowFlushAll:     s.config.AllowFlushAll,
+		slowLog:           newSlowLog(s.config.SlowLogThreshold, s.config.SlowLogLength),
+		tcpOptions:        s.config.ClientTCPOptions,
+		maxCommandSize:    s.config.MaxCommandSize,
+		maxProtocolErrors: s.config.MaxProtocolErrors,
+		outagePolicy:      s.config.PeerOutagePolicy,
+		handoff:           s.handoff,
+		replicator:        s.replicator,
+		writeQuorum:       s.config.WriteQuorum,
+		ring:              ring,
+		lww:               lww,
+		origins:           origins,
+		crdts:             crdts,
+		rateLimiter:       s.rateLimiter,
+		namespaceTTLs:     s.config.NamespaceTTLs,
+		commands:          s.commands,
+		info:              s.info,
+		topology:          s.topology,
+		scan:              s.scan,
+		whatIf:            s.whatIf,
+		clients:           s.clients,
+		killClient:        s.killClient,
+		shutdown:          s.shutdown,
+		readOnly:          s.isReadOnly,
+		replica:           s.config.Role == RoleReplica,
+		primary:           s.config.Primary,
+		clientAddresses:   s.config.ClientAddresses,
+		shuttingDown:      s.isShuttingDown,
+		setReadOnly:       s.setReadOnly,
+		commandTimeout:    s.config.CommandTimeout,
+		commandTimeouts:   s.config.CommandTimeouts,
+		idempotency:       s.idempotency,
+		hotKeys:           s.hotKeys,
+		watches:           s.watches,
+		features:          s.features(),
+	}
+
+	if raftNode != nil {
+		clientConfig.propose = s.propose
+	}
+
+	httpServer := &http.Server{ReadHeaderTimeout: httpReadHeaderTimeout}
+
+	s.mutex.Lock()
+	s.clientConfig = clientConfig
+	s.httpServer = httpServer
+	s.mutex.Unlock()
+
+	// async - HTTP requests are performed as client commands, so needs the client settings
+	if httpListener != nil {
+		go func() {
+			_ = s.serveHTTP(s.httpLogger, httpListener, httpServer)
+		}()
+	}
 
 	// sync - client commands are replicated to peers
-	startConnections("server "+serverHostnamePort+" ", store, serverHostnamePort, otherServers)
+	return s.serve(s.serverLogger, clientListener, clientConfig)
 }
 
-func startConnections(description string, store *kvstore.KVStore, hostnamePort string, otherServers []string) {
-	logger := log.New(os.Stdout, description, log.Ldate|log.Ltime|log.Lshortfile)
+// Reload applies the settings in the config that can be changed while the server is running, ignoring
+// the rest. RateLimit and RateLimitBurst apply to every connection. Peers removed from OtherServers are
+// disconnected and no longer replicated to, while peers added are replicated to by connections opened afterwards.
+// OtherServers is ignored when the servers in the cluster are found by gossip.
+func (s *Server) Reload(config Config) {
+	s.rateLimiter.setLimit(config.RateLimit, config.RateLimitBurst)
+
+	// found by gossip instead
+	if len(s.config.Seeds) == 0 {
+		s.setOtherServers(config.OtherServers)
+	}
 
-	logger.Print("binding server to TCP port ", hostnamePort)
+	s.serverLogger.Info("reloaded config", "rateLimit", config.RateLimit, "rateLimitBurst", config.RateLimitBurst,
+		"peers", s.peerList())
+}
 
-	clientListener, err := net.Listen("tcp4", hostnamePort)
-	if err != nil {
-		logger.Fatal("Unable to bind to port: ", err)
+// setOtherServers replicates to the servers, instead of the current peers, which are disconnected if
+// removed. Raft membership is fixed when the server starts, so is never changed.
+func (s *Server) setOtherServers(otherServers []string) {
+	if s.config.Raft {
+		return
+	}
+
+	// close the connections to peers that have been removed
+	s.peerPool.prune(otherServers)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.config.OtherServers = otherServers
+
+	if s.ring != nil {
+		s.ring.setServers(otherServers)
+	}
+
+	if s.clientConfig != nil {
+		s.clientConfig.otherServers = otherServers
+	}
+}
+
+// dialer returns the dialer used to connect to other servers' peer ports.
+func (s *Server) dialer() peerDialer {
+	return peerDialer{s.config.PeerSecret, s.config.PeerTCPOptions, s.config.PeerCompressionThreshold}
+}
+
+// peerList returns the other servers that writes are replicated to.
+func (s *Server) peerList() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.config.OtherServers
+}
+
+func contains(list []string, item string) bool {
+	for _, listItem := range list {
+		if listItem == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// warmUp fetches the most read keys from the first reachable peer.
+func (s *Server) warmUp(logger *slog.Logger) {
+	for _, peer := range s.peerList() {
+		fetched, err := warmUp(logger, peer, s.dialer(), s.config.WarmUpKeys, s.store)
+		if err != nil {
+			logger.Warn("unable to warm up", "peer", peer, "error", err)
+			continue
+		}
+
+		logger.Info("warmed up", "peer", peer, "keys", fetched)
+
+		return
+	}
+}
+
+// handOffWrites periodically delivers writes queued for unreachable peers, and resends writes
+// that failed to replicate, until shutdown.
+func (s *Server) handOffWrites(logger *slog.Logger) {
+	ticker := time.NewTicker(handoffInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.handoff.deliver(logger, s.dialer(), now)
+			s.peerPool.catchUp(now)
+
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// features lists the optional features enabled by the server's config.
+func (s *Server) features() []string {
+	var features []string
+
+	if s.config.ACL != nil {
+		features = append(features, "auth")
+	}
+
+	if s.config.PeerSecret != "" {
+		features = append(features, "peerAuth")
+	}
+
+	if s.config.Sampler != nil {
+		features = append(features, "sampling")
+	}
+
+	if s.config.PeerOutagePolicy == OutageHandoff {
+		features = append(features, "handoff")
+	}
+
+	if s.config.ReplicationMode == ReplicationAsync {
+		features = append(features, "asyncReplication")
+	}
+
+	if s.config.IdleTimeout > 0 {
+		features = append(features, "idleTimeout")
+	}
+
+	if s.config.RateLimit > 0 {
+		features = append(features, "rateLimit")
+	}
+
+	if len(s.config.NamespaceTTLs) > 0 {
+		features = append(features, "namespaceTTL")
 	}
 
-	defer func() {
-		_ = clientListener.Close()
+	if s.config.Raft {
+		features = append(features, "raft")
+	}
+
+	if s.config.ShardReplicas > 0 {
+		features = append(features, "sharding")
+	}
+
+	if len(s.config.Seeds) > 0 {
+		features = append(features, "gossip")
+	}
+
+	if s.config.LastWriteWins {
+		features = append(features, "lastWriteWins")
+	}
+
+	if s.antiEntropyEnabled() {
+		features = append(features, "antiEntropy")
+	}
+
+	if s.config.TrackOrigins {
+		features = append(features, "origins")
+	}
+
+	if !s.config.Raft {
+		features = append(features, "crdt")
+	}
+
+	if s.config.Role == RoleReplica {
+		features = append(features, "replica")
+	}
+
+	if len(s.config.ClientAddresses) > 0 {
+		features = append(features, "redirects")
+	}
+
+	if s.config.PeerCompressionThreshold > 0 {
+		features = append(features, "peerCompression")
+	}
+
+	return features
+}
+
+// reapIdleConnections periodically closes connections that have been idle for too long, until shutdown.
+func (s *Server) reapIdleConnections(logger *slog.Logger) {
+	ticker := time.NewTicker(s.config.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			for conn := range s.connections {
+				if conn.isIdle(now) {
+					logger.Info("closing idle connection", "remote", conn.remoteAddr(),
+						"idleTimeout", s.config.IdleTimeout)
+					conn.closeWhenIdle()
+				}
+			}
+			s.mutex.Unlock()
+
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// ShutdownRequested returns a channel that is closed when an authenticated client sends the shutdown
+// command, after which the caller should call Shutdown. The server keeps running until then.
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.shutdownRequested
+}
+
+func (s *Server) shutdown() {
+	s.requestShutdown.Do(func() {
+		close(s.shutdownRequested)
+	})
+}
+
+// Shutdown stops accepting connections, waits for in-flight commands to complete, closes every
+// connection then the connections to peers, then closes the store. If the context expires first,
+// the remaining connections are closed immediately and the context's error is returned, leaving
+// the store open since commands may still be using it.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	if !s.closing {
+		close(s.done)
+	}
+
+	s.closing = true
+
+	for _, listener := range s.listeners {
+		_ = listener.Close()
+	}
+
+	for conn := range s.connections {
+		conn.closeWhenIdle()
+	}
+
+	httpServer := s.httpServer
+	raftNode, membership := s.raftNode, s.membership
+	s.mutex.Unlock()
+
+	drained := make(chan struct{})
+
+	go func() {
+		if httpServer != nil {
+			// closes idle HTTP connections, and waits for requests in progress
+			_ = httpServer.Shutdown(ctx)
+		}
+
+		s.handlers.Wait()
+		close(drained)
 	}()
 
+	select {
+	case <-drained:
+		if raftNode != nil {
+			raftNode.Stop()
+		}
+
+		if membership != nil {
+			s.leaveCluster(s.peerLogger)
+		}
+
+		s.peerPool.close()
+		kvstore.Close(s.store)
+
+		return nil
+
+	case <-ctx.Done():
+		s.mutex.Lock()
+		for conn := range s.connections {
+			_ = conn.Close()
+		}
+		s.mutex.Unlock()
+
+		return fmt.Errorf("error draining connections: %w", ctx.Err())
+	}
+}
+
+func (s *Server) listen(logger *slog.Logger, hostnamePort string) (net.Listener, error) {
+	network, address := splitAddress(hostnamePort)
+
+	logger.Info("binding server", "network", network, "address", address)
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to bind to address: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closing {
+		_ = listener.Close()
+		return nil, ErrServerClosed
+	}
+
+	s.listeners = append(s.listeners, listener)
+
+	return listener, nil
+}
+
+func (s *Server) serve(logger *slog.Logger, listener net.Listener, config *handlerConfig) error {
 	for {
-		conn, err := clientListener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			break
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+
+			return fmt.Errorf("error accepting connection: %w", err)
 		}
 
-		go openConnectionsAndHandle(logger, conn, store, otherServers)
+		if err := config.tcpOptions.apply(conn); err != nil {
+			logger.Warn("unable to tune connection", "remote", conn.RemoteAddr().String(), "error", err)
+		}
+
+		c, err := s.track(conn, config)
+		if err != nil {
+			if errors.Is(err, errTooManyConnections) {
+				logger.Warn("refusing connection", "remote", conn.RemoteAddr().String(), "error", err)
+
+				_ = reliableWrite(conn, busyResponse)
+			}
+
+			_ = conn.Close()
+
+			continue
+		}
+
+		go func() {
+			defer s.untrack(c)
+
+			s.openConnectionsAndHandle(logger, c, config)
+		}()
 	}
 }
 
-func openConnectionsAndHandle(logger *log.Logger, clientConn io.ReadWriteCloser,
-	store *kvstore.KVStore, otherServers []string) {
-	serverConns, err := openServerConnections(logger, otherServers)
-	if err != nil {
-		return
+func (s *Server) isClosing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.closing
+}
+
+// track records a newly accepted connection, returning an error if the server is shutting down
+// or the listener already has its maximum number of connections.
+func (s *Server) track(conn net.Conn, config *handlerConfig) (*connection, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closing {
+		return nil, ErrServerClosed
+	}
+
+	if config.maxConnections > 0 && s.counts[config] >= config.maxConnections {
+		return nil, errTooManyConnections
+	}
+
+	c := newConnection(conn)
+	c.readTimeout = s.config.ReadTimeout
+	c.writeTimeout = s.config.WriteTimeout
+	c.idleTimeout = config.idleTimeout
+	c.config = config
+
+	s.lastID++
+	c.id = s.lastID
+
+	s.connections[c] = struct{}{}
+	s.counts[config]++
+	s.handlers.Add(1)
+
+	return c, nil
+}
+
+func (s *Server) untrack(c *connection) {
+	s.mutex.Lock()
+	delete(s.connections, c)
+	s.counts[c.config]--
+	s.mutex.Unlock()
+
+	s.handlers.Done()
+}
+
+func (s *Server) openConnectionsAndHandle(logger *slog.Logger, conn *connection, config *handlerConfig) {
+	s.mutex.Lock()
+	otherServers := config.otherServers
+	s.mutex.Unlock()
+
+	peers := s.peerPool.get(otherServers)
+
+	// connect to any peers not already connected, so their status is known
+	for _, peer := range peers {
+		peer.available(time.Now())
 	}
 
-	handle(logger, clientConn, store, serverConns)
+	handle(logger, conn, s.store, peers, config)
 }
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_Server_Shutdown(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	served := make(chan error)
+
+	go func() {
+		served <- srv.Serve()
+	}()
+
+	client, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	checkRequestResponse(t, client, "put12bb13999", "ack")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Error("Wrong error returned: ", err)
+	}
+
+	// the idle client connection was closed by the server
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_Server_ShutdownCommand(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		ACL:                NewSharedSecretACL("secret"),
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	served := make(chan error)
+
+	go func() {
+		served <- srv.Serve()
+	}()
+
+	client1, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	client2, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	checkRequestResponse(t, client1, "auth16secret", "ack")
+	checkRequestResponse(t, client2, "auth16secret", "ack")
+	checkRequestResponse(t, client1, "put12bb13999", "ack")
+	checkRequestResponse(t, client1, "bye", "") // only closes this connection
+
+	checkRequestResponse(t, client2, "get12bb0", "val13999") // store is still open
+	checkRequestResponse(t, client2, "sdn", "ack")
+
+	select {
+	case <-srv.ShutdownRequested():
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown wasn't requested")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_Server_ShutdownTimeout(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	client, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	checkRequestResponse(t, client, "get12bb0", "nil")
+
+	// simulate a command in progress, so the connection can't be drained
+	srv.mutex.Lock()
+	for conn := range srv.connections {
+		conn.beginCommand()
+	}
+	srv.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_Server_ListenError(t *testing.T) {
+	first := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := first.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	defer first.Shutdown(context.Background())
+
+	// client port already in use
+	second := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: first.ClientAddr().String(),
+		PeerHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := second.ListenAndServe(); err == nil || errors.Is(err, ErrServerClosed) {
+		t.Error("Wrong error returned: ", err)
+	}
+
+	if second.ClientAddr() != nil {
+		t.Error("Expected no client address")
+	}
+}
+
+func Test_Server_ServeWithoutListen(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{})
+
+	if err := srv.Serve(); !errors.Is(err, errNotListening) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_Server_IdleTimeout(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		IdleTimeout:        100 * time.Millisecond,
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(context.Background())
+
+	client, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	// activity keeps the connection open
+	for i := 0; i < 3; i++ {
+		time.Sleep(60 * time.Millisecond)
+		checkRequestResponse(t, client, "get12bb0", "nil")
+	}
+
+	// check the idle connection is closed by the server
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_Server_MaxConnections(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		MaxConnections:     1,
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(context.Background())
+
+	client1, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	checkRequestResponse(t, client1, "get12bb0", "nil")
+
+	// second connection is refused
+	client2, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	read(t, client2, "bsy")
+
+	// once the first client disconnects, another can connect
+	checkRequestResponse(t, client1, "bye", "")
+	time.Sleep(50 * time.Millisecond)
+
+	client3, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	checkRequestResponse(t, client3, "get12bb0", "nil")
+}
+
+func Test_Server_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.sock")
+
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: unixScheme + path,
+		PeerHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(context.Background())
+
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	checkRequestResponse(t, client, "put12bb13999", "ack")
+	checkRequestResponse(t, client, "get12bb0", "val13999")
+}
+
+func Test_Server_Reload(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(context.Background())
+
+	client1, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	checkRequestResponse(t, client1, "get12bb0", "nil")
+
+	srv.Reload(Config{RateLimit: 1, RateLimitBurst: 1, OtherServers: []string{"127.0.0.1:1"}})
+
+	// rate limit applies to the existing connection
+	checkRequestResponse(t, client1, "get12bb0", "nil")
+	checkRequestResponse(t, client1, "get12bb0", throttleResponse)
+
+	srv.Reload(Config{OtherServers: []string{"127.0.0.1:1"}})
+
+	// peers apply to new connections
+	client2, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	checkRequestResponse(t, client2, "put12bb13999", formatError(peerUnreachableReason([]string{"127.0.0.1:1"})))
+}
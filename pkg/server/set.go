@@ -0,0 +1,65 @@
+package server
+
+import "tcp/pkg/kvstore"
+
+// executeMemberAdd adds the member, acknowledged whether or not it was already present.
+func executeMemberAdd(store kvstore.Store, request *commandRequest) (string, error) {
+	sets, err := setStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	if err := sets.SetAdd(request.key, request.value); err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return ackResponse, nil
+}
+
+// executeMemberRemove removes the member, acknowledged whether or not it was present, like a delete.
+func executeMemberRemove(store kvstore.Store, request *commandRequest) (string, error) {
+	sets, err := setStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	if err := sets.SetRemove(request.key, request.value); err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return ackResponse, nil
+}
+
+// executeIsMember returns 1 if the value is a member of the set, otherwise 0, as in Redis.
+func executeIsMember(store kvstore.Store, request *commandRequest) (string, error) {
+	sets, err := setStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	present, err := sets.SetIsMember(request.key, request.value)
+	if err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	if present {
+		return "val" + formatArgument("1"), nil
+	}
+
+	return "val" + formatArgument("0"), nil
+}
+
+// executeMembers returns every member of the set, in order.
+func executeMembers(store kvstore.Store, request *commandRequest) (string, error) {
+	sets, err := setStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	members, err := sets.SetMembers(request.key)
+	if err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return listResponse(members), nil
+}
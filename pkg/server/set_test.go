@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_Set(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2), &handlerConfig{})
+
+	// writes are replicated as the operations, not the whole set
+	checkReplicated(t, client, server2, "sta11s11b", "sta11s11b", ackResponse)
+	checkReplicated(t, client, server2, "sta11s11a", "sta11s11a", ackResponse)
+	checkReplicated(t, client, server2, "sta11s11a", "sta11s11a", ackResponse) // already a member
+	checkRequestResponse(t, client, "sti11s11a", "val111")
+	checkRequestResponse(t, client, "sti11s11c", "val110")
+	checkRequestResponse(t, client, "stm11s", listResponse([]string{"a", "b"}))
+
+	checkReplicated(t, client, server2, "str11s11a", "str11s11a", ackResponse)
+	checkReplicated(t, client, server2, "str11s11c", "str11s11c", ackResponse) // not a member
+	checkRequestResponse(t, client, "stm11s", listResponse([]string{"b"}))
+	checkRequestResponse(t, client, "sti11z11a", "val110")
+	checkRequestResponse(t, client, "stm11z", listResponse(nil))
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_SetWrongType(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "put11k11v", ackResponse)
+	checkRequestResponse(t, client, "sta11k11a", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "sti11k11a", formatError(reasonWrongType))
+
+	checkRequestResponse(t, client, "sta11s11a", ackResponse)
+	checkRequestResponse(t, client, "get11s0", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "lpo11s", formatError(reasonWrongType))
+
+	checkRequestResponse(t, client, "bye", "")
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// sharded returns whether the command is only performed on the servers holding its key, commands
+// without a key (such as flushing every key) being performed on every server.
+func (s *session) sharded(command *commandRequest) bool {
+	if s.config.ring == nil {
+		return false
+	}
+
+	switch command.command {
+	case putCommand, getCommand, deleteCommand, checksumPutCommand, checksumGetCommand, putExpiryCommand,
+		putOptionsCommand, persistCommand, hashSetCommand, hashGetCommand, hashDeleteCommand, hashGetAllCommand,
+		listPushLeftCommand, listPushRightCommand, listPopLeftCommand, listPopRightCommand, listRangeCommand,
+		listTrimCommand, memberAddCommand, memberRemoveCommand, isMemberCommand, membersCommand, scoreAddCommand,
+		scoreRemoveCommand, rankRangeCommand, scoreRangeCommand, setBitCommand, getBitCommand, bitCountCommand,
+		typeCommand:
+		return true
+
+	case customCommand:
+		return command.key != ""
+
+	default:
+		return false
+	}
+}
+
+// replicates returns whether the command is replicated to the peer, which is every peer unless sharding.
+func (s *session) replicates(peer *pooledPeer, command *commandRequest) bool {
+	return !s.sharded(command) || s.config.ring.owns(peer.address, command.key)
+}
+
+// performElsewhere performs a command on the servers holding its key, when this server doesn't,
+// returning the response and the reason if it failed. If the client address of one of them is known,
+// the client is redirected there instead.
+func (s *session) performElsewhere(command *commandRequest) (string, string) {
+	owners := s.config.ring.owners(command.key)
+	peers := make([]*pooledPeer, 0, len(owners))
+
+	for _, owner := range owners {
+		for _, peer := range s.peers {
+			if peer.address == owner && !peer.isRemoved() {
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	if response, found := s.redirect(movedResponse, owners); found {
+		s.logger.Debug("found command, redirecting to a server holding the key", "command", command.originalText,
+			"response", response)
+
+		return response, ""
+	}
+
+	s.logger.Debug("found command, forwarding to the servers holding the key", "command", command.originalText,
+		"owners", owners)
+
+	if isMutation(command) {
+		return s.forwardWrite(command, peers, owners)
+	}
+
+	return s.forwardRead(command, peers, owners)
+}
+
+// forwardWrite sends the write to every peer holding its key, returning the response and the reason
+// if it failed. The write fails unless the write quorum applies it, or at least one peer without one.
+func (s *session) forwardWrite(command *commandRequest, peers []*pooledPeer, owners []string) (string, string) {
+	required := 1
+
+	switch s.config.writeQuorum {
+	case 0:
+
+	case WriteQuorumAll:
+		required = len(owners)
+
+	default:
+		required = min(s.config.writeQuorum, len(owners))
+	}
+
+	// buffered, so late replies don't block once the write has been acknowledged
+	acks := make(chan bool, len(peers))
+
+	for _, peer := range peers {
+		go func(peer *pooledPeer) {
+			response, err := peer.replicateOrRecord(command.originalText, time.Now())
+			acks <- err == nil && writeApplied(response)
+		}(peer)
+	}
+
+	timer := time.NewTimer(s.config.timeout(command))
+	defer timer.Stop()
+
+	applied := 0
+
+	for replies := 0; replies < len(peers) && applied < required; replies++ {
+		select {
+		case ack := <-acks:
+			if ack {
+				applied++
+			}
+
+		case <-timer.C:
+			s.logger.Warn("forwarded command timed out", "command", command.name(), "acks", applied)
+			return errorResponse, reasonTimeout
+		}
+	}
+
+	switch {
+	case applied >= required:
+		return ackResponse, ""
+
+	case s.config.writeQuorum != 0:
+		return errorResponse, reasonQuorumNotMet
+
+	default:
+		return errorResponse, peerUnreachableReason(owners)
+	}
+}
+
+// forwardRead sends the read to the first reachable peer holding its key, returning the response
+// and the reason if it failed.
+func (s *session) forwardRead(command *commandRequest, peers []*pooledPeer, owners []string) (string, string) {
+	arguments := 1
+	if command.command == checksumGetCommand {
+		arguments = 2
+	}
+
+	for _, peer := range peers {
+		if !peer.available(time.Now()) {
+			continue
+		}
+
+		response, err := peer.forward(command.originalText, time.Now(), arguments)
+		if err == nil {
+			return response, ""
+		}
+	}
+
+	s.logger.Warn("unable to forward read, every server holding the key is unreachable", "key", command.key,
+		"owners", owners)
+
+	return errorResponse, peerUnreachableReason(owners)
+}
+
+// forward sends a read to the peer, returning its nil or val response, with the number of arguments.
+func (p *pooledPeer) forward(command string, now time.Time, arguments int) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.exchange(command, now, func(reader io.Reader) (string, error) {
+		response, err := reliableRead(reader, 3)
+
+		switch {
+		case err != nil:
+			return "", err
+
+		case response == "nil":
+			return response, nil
+
+		case response != "val":
+			return "", fmt.Errorf("%w: %s", errUnexpectedResponse, response)
+		}
+
+		for i := 0; i < arguments; i++ {
+			argument, err := readArgument(reader)
+			if err != nil {
+				return "", err
+			}
+
+			response += formatArgument(argument)
+		}
+
+		return response, nil
+	})
+}
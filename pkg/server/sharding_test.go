@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_Sharded(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	ring := newHashRing("server1", []string{"server2"}, 1, 0)
+	peers := connectedPeers(peer2)
+	peers[0].address = "server2"
+
+	local, remote := shardedKey(ring, true), shardedKey(ring, false)
+
+	go handle(testLogger, newConnection(server1), store, peers, &handlerConfig{ring: ring})
+
+	// keys held locally aren't replicated
+	checkRequestResponse(t, client, "put11"+local+"13999", "ack")
+	checkRequestResponse(t, client, "get11"+local+"0", "val13999")
+
+	// while commands for other keys are forwarded to the server holding them
+	checkDistributedRequestResponse(t, client, "put11"+remote+"13999", []net.Conn{server2}, "ack")
+
+	write(t, client, "get11"+remote+"0")
+	read(t, server2, "get11"+remote+"0")
+	write(t, server2, "val13999")
+	read(t, client, "val13999")
+
+	if _, present := kvstore.Read(store, remote); present {
+		t.Error("Expected key held by another server not written locally")
+	}
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+// shardedKey returns a single character key that is, or isn't, held locally.
+func shardedKey(ring *hashRing, local bool) string {
+	for _, key := range strings.Split("abcdefghijklmnopqrstuvwxyz", "") {
+		if ring.local(key) == local {
+			return key
+		}
+	}
+
+	panic("no key found")
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// default maximum number of commands kept in the slow log
+const defaultSlowLogLength = 128
+
+// slowLog keeps the most recent commands that took longer than the threshold to handle, from being
+// parsed until the response was written, so latency spikes can be diagnosed. A nil slowLog records nothing.
+type slowLog struct {
+	mutex     sync.Mutex
+	threshold time.Duration
+	entries   []string
+	next      int
+	count     uint64
+}
+
+// newSlowLog returns a slow log keeping up to length commands (defaultSlowLogLength if zero)
+// slower than the threshold, or nil if the threshold isn't positive.
+func newSlowLog(threshold time.Duration, length int) *slowLog {
+	if threshold <= 0 {
+		return nil
+	}
+
+	if length < 1 {
+		length = defaultSlowLogLength
+	}
+
+	return &slowLog{threshold: threshold, entries: make([]string, 0, length)}
+}
+
+// record keeps the command if it was slower than the threshold, replacing the oldest kept if full.
+func (l *slowLog) record(client string, request *commandRequest, duration time.Duration, now time.Time) {
+	if l == nil || duration < l.threshold {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.count++
+
+	entry := fmt.Sprintf("id=%d time=%d duration_us=%d client=%s command=%s key=%s",
+		l.count, now.Unix(), duration.Microseconds(), client, request.name(), request.key)
+
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, entry)
+	} else {
+		l.entries[l.next] = entry
+	}
+
+	l.next = (l.next + 1) % cap(l.entries)
+}
+
+// recent returns up to n of the commands kept, most recent first.
+func (l *slowLog) recent(n int) []string {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if n > len(l.entries) {
+		n = len(l.entries)
+	}
+
+	recent := make([]string, 0, n)
+
+	for i := 1; i <= n; i++ {
+		recent = append(recent, l.entries[(l.next-i+cap(l.entries))%cap(l.entries)])
+	}
+
+	return recent
+}
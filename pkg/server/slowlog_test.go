@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_slowLog_Disabled(t *testing.T) {
+	if newSlowLog(0, 10) != nil {
+		t.Error("Expected no slow log without a threshold")
+	}
+
+	var log *slowLog
+
+	log.record("client", &commandRequest{command: getCommand, key: "a"}, time.Hour, time.Now())
+
+	if recent := log.recent(10); len(recent) != 0 {
+		t.Error("Expected nothing recorded but got: ", recent)
+	}
+}
+
+func Test_slowLog_Record(t *testing.T) {
+	log := newSlowLog(time.Millisecond, 2)
+	now := time.Unix(1000, 0)
+
+	log.record("client", &commandRequest{command: putCommand, key: "a"}, 2*time.Millisecond, now)
+	log.record("client", &commandRequest{command: getCommand, key: "b"}, time.Microsecond, now) // too fast
+	log.record("client", &commandRequest{command: getCommand, key: "c"}, 3*time.Millisecond, now)
+	log.record("client", &commandRequest{command: deleteCommand, key: "d"}, 4*time.Millisecond, now)
+
+	recent := log.recent(10)
+
+	expected := []string{
+		"id=3 time=1000 duration_us=4000 client=client command=del key=d",
+		"id=2 time=1000 duration_us=3000 client=client command=get key=c",
+	}
+
+	if strings.Join(recent, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %v but got %v", expected, recent)
+	}
+
+	if recent = log.recent(1); len(recent) != 1 || recent[0] != expected[0] {
+		t.Error("Expected only the most recent but got: ", recent)
+	}
+}
+
+func Test_handle_SlowLog(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil,
+		&handlerConfig{slowLog: newSlowLog(time.Nanosecond, 10)})
+
+	checkRequestResponse(t, client, "put12bb13999", "ack")
+
+	write(t, client, "slg1210")
+
+	entries, err := readList(client)
+	if err != nil {
+		t.Fatal("Unable to read slow log: ", err)
+	}
+
+	if len(entries) != 1 || !strings.Contains(entries[0], "command=put key=bb") {
+		t.Error("Expected the put command but got: ", entries)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"strconv"
+	"tcp/pkg/kvstore"
+)
+
+// executeScoreAdd adds the member with its score, acknowledged whether or not it was already present.
+func executeScoreAdd(store kvstore.Store, request *commandRequest) (string, error) {
+	sortedSets, err := sortedSetStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	if err := sortedSets.SortedSetAdd(request.key, request.value, request.score); err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return ackResponse, nil
+}
+
+// executeScoreRemove removes the member, acknowledged whether or not it was present, like a delete.
+func executeScoreRemove(store kvstore.Store, request *commandRequest) (string, error) {
+	sortedSets, err := sortedSetStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	if err := sortedSets.SortedSetRemove(request.key, request.value); err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return ackResponse, nil
+}
+
+func executeRankRange(store kvstore.Store, request *commandRequest) (string, error) {
+	sortedSets, err := sortedSetStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	members, err := sortedSets.SortedSetRange(request.key, request.start, request.stop)
+	if err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return scoredListResponse(members), nil
+}
+
+func executeScoreRange(store kvstore.Store, request *commandRequest) (string, error) {
+	sortedSets, err := sortedSetStore(store)
+	if err != nil {
+		return "", err
+	}
+
+	members, err := sortedSets.SortedSetRangeByScore(request.key, request.lowest, request.highest)
+	if err != nil {
+		return "", err //nolint:wrapcheck // sent to the client as it is
+	}
+
+	return scoredListResponse(members), nil
+}
+
+// scoredListResponse returns the members in order, as a list alternating between each member and its
+// score, like Redis's WITHSCORES.
+func scoredListResponse(members []kvstore.ScoredMember) string {
+	items := make([]string, 0, 2*len(members))
+
+	for _, member := range members {
+		items = append(items, member.Member, strconv.FormatFloat(member.Score, 'g', -1, 64))
+	}
+
+	return listResponse(items)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_SortedSet(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+
+	go handle(testLogger, newConnection(server1), kvstore.NewKVStore(), connectedPeers(peer2), &handlerConfig{})
+
+	// writes are replicated as the operations, not the whole sorted set
+	checkReplicated(t, client, server2, "zad11z11311c", "zad11z11311c", ackResponse)
+	checkReplicated(t, client, server2, "zad11z11111a", "zad11z11111a", ackResponse)
+	checkReplicated(t, client, server2, "zad11z132.511b", "zad11z132.511b", ackResponse)
+	checkRequestResponse(t, client, "zrg11z11012-1", listResponse([]string{"a", "1", "b", "2.5", "c", "3"}))
+	checkRequestResponse(t, client, "zrg11z12-2112", listResponse([]string{"b", "2.5", "c", "3"}))
+	checkRequestResponse(t, client, "zrs11z11213inf", listResponse([]string{"b", "2.5", "c", "3"}))
+	checkRequestResponse(t, client, "zrs11z14-inf110", listResponse(nil))
+
+	checkReplicated(t, client, server2, "zrm11z11b", "zrm11z11b", ackResponse)
+	checkReplicated(t, client, server2, "zrm11z11x", "zrm11z11x", ackResponse) // not a member
+	checkRequestResponse(t, client, "zrg11z11012-1", listResponse([]string{"a", "1", "c", "3"}))
+
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_SortedSetWrongType(t *testing.T) {
+	server, client := net.Pipe()
+
+	go handle(testLogger, newConnection(server), kvstore.NewKVStore(), nil, &handlerConfig{})
+
+	checkRequestResponse(t, client, "put11k11v", ackResponse)
+	checkRequestResponse(t, client, "zad11k11111a", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "zrs11k110111", formatError(reasonWrongType))
+
+	checkRequestResponse(t, client, "zad11z11111a", ackResponse)
+	checkRequestResponse(t, client, "get11z0", formatError(reasonWrongType))
+	checkRequestResponse(t, client, "stm11z", formatError(reasonWrongType))
+
+	checkRequestResponse(t, client, "bye", "")
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"strings"
+	"time"
+)
+
+// isStateDependent returns whether the write made depends on the key's current state: a put only made
+// if the key is absent or present, removing the key's expiry, only made if it has one, or popping an
+// element off a list, whichever element is at that end.
+func isStateDependent(request *commandRequest) bool {
+	switch request.command {
+	case putOptionsCommand:
+		return request.options.IfAbsent || request.options.IfPresent
+
+	case persistCommand, listPopLeftCommand, listPopRightCommand:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// performStateDependent performs a write depending on the key's current state, returning the response
+// and the reason if it failed. With an idempotency key, a retry gets the original response rather than
+// the write being made again, depending on the key's state by then.
+func (s *session) performStateDependent(command *commandRequest, prefixes commandPrefixes,
+	timing *commandTiming) (string, string) {
+	cache := s.config.idempotency
+	if prefixes.idempotencyKey == "" || cache == nil {
+		return s.performMadeLocally(command, prefixes, timing)
+	}
+
+	previous, found, conflict := cache.lookup(prefixes.idempotencyKey, command, time.Now())
+
+	switch {
+	case conflict:
+		s.logger.Info("rejecting write, idempotency key used by a different write", "command", command.name())
+		return errorResponse, reasonIdempotencyConflict
+
+	case found:
+		s.logger.Info("write already performed, returning original response", "command", command.name())
+		return previous, ""
+	}
+
+	response, reason := s.performMadeLocally(command, prefixes, timing)
+
+	if !strings.HasPrefix(response, errorResponse) {
+		cache.add(prefixes.idempotencyKey, command, response, time.Now())
+	}
+
+	return response, reason
+}
+
+// performMadeLocally makes the write locally, then only replicates a write actually made, as the write
+// made, so every peer makes the same write whatever its copy of the key.
+func (s *session) performMadeLocally(command *commandRequest, prefixes commandPrefixes,
+	timing *commandTiming) (string, string) {
+	switch {
+	// Raft applies the write in the same order everywhere, and sharded keys are checked by the servers
+	// holding them, while with no peers there is nothing to replicate
+	case s.config.propose != nil, s.sharded(command) && !s.config.ring.local(command.key), len(s.peers) == 0:
+		return s.perform(s.withIdempotencyKey(s.prefixed(command, prefixes), prefixes), timing)
+	}
+
+	command = withNamespaceTTL(command, s.config.namespaceTTLs)
+
+	response, _ := performCommand(s.logger, s.localStoreChannel, s.responseChannel, nil, s.ackChannel,
+		command, 0, s.config.timeout(command), timing)
+
+	made := madeWrite(command, response)
+	if made == nil {
+		s.logger.Debug("write not made", "command", command.name(), "key", command.key)
+
+		return response, reasonTimeout
+	}
+
+	replicated, reason := s.perform(s.withIdempotencyKey(s.prefixed(made, prefixes), prefixes), timing)
+	if replicated != ackResponse {
+		return replicated, reason
+	}
+
+	return response, ""
+}
+
+// withIdempotencyKey returns the write prefixed by the idempotency key, if any, so peers also remember it.
+func (s *session) withIdempotencyKey(command *commandRequest, prefixes commandPrefixes) *commandRequest {
+	if prefixes.idempotencyKey == "" || s.config.idempotency == nil {
+		return command
+	}
+
+	return withIdempotencyKey(command, prefixes.idempotencyKey)
+}
+
+// madeWrite returns the write made locally by a write depending on the key's state, to be replicated, or
+// nil if none was made.
+func madeWrite(request *commandRequest, response string) *commandRequest {
+	switch request.command {
+	case listPopLeftCommand, listPopRightCommand:
+		return madePop(request, response)
+
+	default:
+		if response != ackResponse {
+			return nil
+		}
+
+		return madePut(request)
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"tcp/pkg/kvstore"
+	"time"
+)
+
+const (
+	// how long to wait for each chunk of a peer's snapshot, so servers started together, each waiting
+	// for the other to sync, give up rather than wait forever
+	stateSyncTimeout = 30 * time.Second
+
+	// how many keys are sent in each chunk of a snapshot, if not requested, and at most
+	snapshotChunkSize  = 1000
+	snapshotChunkLimit = 10000
+
+	// how many times in a row a chunk is requested again after failing, before giving up
+	snapshotRetries = 3
+)
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// snapshot returns every key in the store, each a record encoded as JSON, so collections are copied too.
+func (s *Server) snapshot() []string {
+	return encodeRecords(kvstore.Dump(s.store))
+}
+
+// snapshotChunk returns the checksum of up to limit keys after the key specified (from the first key if
+// empty), then the keys, each a record encoded as JSON, in key order.
+func (s *Server) snapshotChunk(after string, limit int) []string {
+	if limit < 1 || limit > snapshotChunkLimit {
+		limit = snapshotChunkSize
+	}
+
+	records := encodeRecords(kvstore.DumpPage(s.store, after, limit))
+
+	return append([]string{chunkChecksum(records)}, records...)
+}
+
+// encodeRecords returns the records, each encoded as JSON.
+func encodeRecords(records []kvstore.Record) []string {
+	encoded := make([]string, 0, len(records))
+
+	for _, record := range records {
+		item, err := json.Marshal(record)
+		if err != nil {
+			// never happens, the records only holding strings, JSON and times
+			continue
+		}
+
+		encoded = append(encoded, string(item))
+	}
+
+	return encoded
+}
+
+// chunkChecksum returns the checksum of the encoded records in a chunk of a snapshot.
+func chunkChecksum(records []string) string {
+	var chunk strings.Builder
+
+	for _, record := range records {
+		chunk.WriteString(formatArgument(record))
+	}
+
+	return checksum(chunk.String())
+}
+
+// streamSnapshot fetches the peer's keys a chunk at a time, calling apply with each chunk once its
+// checksum is verified, so the keys are never all held in memory. A chunk that fails is requested
+// again over a new connection, resuming after the last key applied, up to snapshotRetries times in a
+// row, so a dropped connection doesn't restart the whole transfer. Returns how many keys there are, or
+// the error apply returns.
+func streamSnapshot(peer string, dialer peerDialer, apply func(records []kvstore.Record) error) (int, error) {
+	var conn net.Conn
+
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	after, total, failures := "", 0, 0
+
+	for {
+		var records []kvstore.Record
+
+		var err error
+
+		if conn == nil {
+			conn, err = dialer.dial(peer)
+		}
+
+		if err == nil {
+			records, err = fetchChunk(conn, after)
+		}
+
+		if err != nil {
+			if failures++; failures > snapshotRetries {
+				return total, err
+			}
+
+			if conn != nil {
+				_ = conn.Close()
+				conn = nil
+			}
+
+			continue
+		}
+
+		failures = 0
+
+		if len(records) == 0 {
+			return total, nil
+		}
+
+		if err := apply(records); err != nil {
+			return total, err
+		}
+
+		after = records[len(records)-1].Key
+		total += len(records)
+	}
+}
+
+// fetchChunk requests the chunk of keys after the key specified, returning them once the chunk's
+// checksum is verified.
+func fetchChunk(conn net.Conn, after string) ([]kvstore.Record, error) {
+	if err := conn.SetDeadline(time.Now().Add(stateSyncTimeout)); err != nil {
+		return nil, fmt.Errorf("error setting deadline: %w", err)
+	}
+
+	err := reliableWrite(conn, "snc"+formatArgument(after)+formatArgument(strconv.Itoa(snapshotChunkSize)))
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := readList(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: snapshot chunk without a checksum", errUnexpectedResponse)
+	}
+
+	if chunkChecksum(items[1:]) != items[0] {
+		return nil, fmt.Errorf("%w: snapshot chunk after %q", errChecksumMismatch, after)
+	}
+
+	records := make([]kvstore.Record, len(items)-1)
+
+	for i, item := range items[1:] {
+		if err := json.Unmarshal([]byte(item), &records[i]); err != nil {
+			return nil, fmt.Errorf("%w: snapshot chunk with invalid key: %w", errUnexpectedResponse, err)
+		}
+	}
+
+	return records, nil
+}
+
+// syncState replaces the keys in the store with those of the first reachable peer.
+func (s *Server) syncState(logger *slog.Logger) {
+	for _, peer := range s.peerList() {
+		synced, err := syncState(peer, s.dialer(), s.store)
+		if err != nil {
+			logger.Warn("unable to sync state", "peer", peer, "error", err)
+			continue
+		}
+
+		logger.Info("synced state", "peer", peer, "keys", synced)
+
+		return
+	}
+
+	logger.Warn("no peer to sync state from, serving local keys")
+}
+
+// syncState streams a snapshot of the peer's keys into the store, then removes the keys the peer
+// doesn't have, returning how many keys there are. If the transfer fails, keys already received have
+// been written, but none are removed.
+func syncState(peer string, dialer peerDialer, store *kvstore.KVStore) (int, error) {
+	received := make(map[string]bool)
+
+	synced, err := streamSnapshot(peer, dialer, func(records []kvstore.Record) error {
+		for _, record := range records {
+			if err := kvstore.Restore(store, record); err != nil {
+				return fmt.Errorf("error restoring key: %w", err)
+			}
+
+			received[record.Key] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return synced, err
+	}
+
+	for _, record := range kvstore.Dump(store) {
+		if !received[record.Key] {
+			kvstore.Delete(store, record.Key)
+		}
+	}
+
+	return synced, nil
+}
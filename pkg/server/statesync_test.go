@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_handle_Snapshot(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{
+		snapshot: func() []string { return []string{"a", "1"} },
+	})
+
+	checkRequestResponse(t, client, "syn", "lst112"+formatArgument("a")+formatArgument("1"))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_handle_SnapshotChunk(t *testing.T) {
+	server, client := net.Pipe()
+	store := kvstore.NewKVStore()
+	srv := NewServer(store, Config{})
+
+	kvstore.Write(store, "a", "1")
+	kvstore.Write(store, "b", "2")
+	_ = store.SetAdd("c", "3")
+
+	go handle(testLogger, newConnection(server), store, nil, &handlerConfig{snapshotChunk: srv.snapshotChunk})
+
+	// collections are sent as well as strings
+	chunk := []string{`{"key":"b","type":"string","value":"2","expiry":"0001-01-01T00:00:00Z"}`,
+		`{"key":"c","type":"set","contents":["3"],"expiry":"0001-01-01T00:00:00Z"}`}
+
+	checkRequestResponse(t, client, "snc11a13100", listResponse(append([]string{chunkChecksum(chunk)}, chunk...)))
+	checkRequestResponse(t, client, "snc11c13100", listResponse([]string{chunkChecksum(nil)}))
+	checkRequestResponse(t, client, "snc11a11x", formatError(reasonInvalidCommand))
+	checkRequestResponse(t, client, "bye", "")
+}
+
+func Test_Server_SyncOnStart(t *testing.T) {
+	peerStore := kvstore.NewKVStore()
+	kvstore.Write(peerStore, "a", "1")
+	kvstore.Write(peerStore, "b", "2")
+	_ = peerStore.HashSet("h", "f", "3")
+
+	// sent in several chunks
+	for i := 0; i < 2*snapshotChunkSize+1; i++ {
+		kvstore.Write(peerStore, fmt.Sprintf("key%d", i), "x")
+	}
+
+	peerServer := NewServer(peerStore, Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := peerServer.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = peerServer.Serve()
+	}()
+
+	defer peerServer.Shutdown(context.Background())
+
+	store := kvstore.NewKVStore()
+	kvstore.Write(store, "a", "0")
+	kvstore.Write(store, "stale", "x")
+	_ = store.ListPush("stale list", "x", false)
+
+	srv := NewServer(store, Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		OtherServers:       []string{peerServer.PeerAddr().String()},
+		SyncOnStart:        true,
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(context.Background())
+
+	client, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	// answered once synced
+	checkRequestResponse(t, client, "get11a0", "val111")
+	checkRequestResponse(t, client, "get11b0", "val112")
+	checkRequestResponse(t, client, "get15stale0", "nil")
+	checkRequestResponse(t, client, "hgt11h11f", "val113")
+	checkRequestResponse(t, client, "bye", "")
+
+	if count := kvstore.Count(store); count != 2*snapshotChunkSize+4 {
+		t.Error("Expected every key synced but got: ", count)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// TCPOptions tunes TCP connections, e.g. a longer keep-alive period and larger buffers for high-latency
+// replication between regions, or small writes sent immediately for low-latency clients.
+// Zero values keep the defaults.
+type TCPOptions struct {
+	// how often keep-alive probes are sent on idle connections (15 seconds if zero, disabled if negative)
+	KeepAlive time.Duration
+
+	// whether small writes are delayed so they can be combined (Nagle's algorithm), which is off by default
+	Nagle bool
+
+	// sizes of the socket receive and send buffers, in bytes
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+// apply sets the options on a TCP connection, other connections (e.g. Unix domain sockets) are unchanged.
+func (o TCPOptions) apply(conn net.Conn) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if err := tcpConn.SetNoDelay(!o.Nagle); err != nil {
+		return fmt.Errorf("error setting Nagle's algorithm: %w", err)
+	}
+
+	if err := o.applyKeepAlive(tcpConn); err != nil {
+		return err
+	}
+
+	if o.ReadBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(o.ReadBuffer); err != nil {
+			return fmt.Errorf("error setting read buffer size: %w", err)
+		}
+	}
+
+	if o.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return fmt.Errorf("error setting write buffer size: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (o TCPOptions) applyKeepAlive(conn *net.TCPConn) error {
+	switch {
+	case o.KeepAlive < 0:
+		if err := conn.SetKeepAlive(false); err != nil {
+			return fmt.Errorf("error disabling keep-alive: %w", err)
+		}
+
+	case o.KeepAlive > 0:
+		if err := conn.SetKeepAlive(true); err != nil {
+			return fmt.Errorf("error enabling keep-alive: %w", err)
+		}
+
+		if err := conn.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+			return fmt.Errorf("error setting keep-alive period: %w", err)
+		}
+	}
+
+	return nil
+}
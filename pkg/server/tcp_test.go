@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_TCPOptions_apply(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	conn, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	for _, options := range []TCPOptions{
+		{},
+		{KeepAlive: time.Minute, Nagle: true, ReadBuffer: 1 << 20, WriteBuffer: 1 << 20},
+		{KeepAlive: -1},
+	} {
+		if err := options.apply(conn); err != nil {
+			t.Errorf("Expected successful for %+v but got: %v", options, err)
+		}
+	}
+}
+
+func Test_TCPOptions_applyNotTCP(t *testing.T) {
+	server, client := net.Pipe()
+
+	defer func() {
+		_ = server.Close()
+		_ = client.Close()
+	}()
+
+	if err := (TCPOptions{KeepAlive: time.Minute}).apply(server); err != nil {
+		t.Error("Expected other connections to be unchanged but got: ", err)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// default time a command waits for the local store and every peer to respond
+const defaultCommandTimeout = 500 * time.Millisecond
+
+var errInvalidCommandTimeout = errors.New("invalid command timeout, expected command=duration")
+
+// CommandTimeouts holds how long each command waits for the local store and every peer to respond,
+// keyed by command name (e.g. put), overriding the server's command timeout. Puts to namespaces with a
+// default TTL are performed as pex, so use the pex timeout.
+type CommandTimeouts map[string]time.Duration
+
+// ParseCommandTimeouts parses a comma-separated list of command=duration pairs, e.g. "put=2s,pck=2s".
+func ParseCommandTimeouts(list string) (CommandTimeouts, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	timeouts := make(CommandTimeouts)
+
+	for _, pair := range strings.Split(list, ",") {
+		name, duration, found := strings.Cut(pair, "=")
+		if !found || !isCommandName(name) {
+			return nil, fmt.Errorf("%w: %s", errInvalidCommandTimeout, pair)
+		}
+
+		timeout, err := time.ParseDuration(duration)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("%w: %s", errInvalidCommandTimeout, pair)
+		}
+
+		timeouts[name] = timeout
+	}
+
+	return timeouts, nil
+}
+
+// timeout returns how long the command waits for the local store and every peer to respond.
+func (c *handlerConfig) timeout(request *commandRequest) time.Duration {
+	if timeout, found := c.commandTimeouts[request.name()]; found {
+		return timeout
+	}
+
+	if c.commandTimeout > 0 {
+		return c.commandTimeout
+	}
+
+	return defaultCommandTimeout
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_ParseCommandTimeouts(t *testing.T) {
+	timeouts, err := ParseCommandTimeouts("put=2s,pck=1500ms")
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	expected := CommandTimeouts{"put": 2 * time.Second, "pck": 1500 * time.Millisecond}
+	if !reflect.DeepEqual(timeouts, expected) {
+		t.Errorf("Expected %v but got %v", expected, timeouts)
+	}
+}
+
+func Test_ParseCommandTimeouts_Invalid(t *testing.T) {
+	for _, list := range []string{"put", "xyz=1s", "put=soon", "put=0s"} {
+		if _, err := ParseCommandTimeouts(list); !errors.Is(err, errInvalidCommandTimeout) {
+			t.Errorf("Wrong error returned for %s: %v", list, err)
+		}
+	}
+}
+
+func Test_handlerConfig_timeout(t *testing.T) {
+	put := &commandRequest{command: putCommand}
+	get := &commandRequest{command: getCommand}
+
+	config := &handlerConfig{}
+	checkTimeout(t, defaultCommandTimeout, config.timeout(put))
+
+	config = &handlerConfig{commandTimeout: time.Second, commandTimeouts: CommandTimeouts{"put": 2 * time.Second}}
+	checkTimeout(t, 2*time.Second, config.timeout(put))
+	checkTimeout(t, time.Second, config.timeout(get))
+}
+
+func Test_performCommand_Timeout(t *testing.T) {
+	localStoreChannel, responseChannel := initialiseLocalStoreHandler(testLogger, kvstore.NewKVStore())
+
+	// a peer that never acknowledges
+	peerChannel := make(chan *commandRequest, 1)
+	ackChannel := make(chan peerAck)
+
+	request := &commandRequest{command: putCommand, key: "a", value: "1", originalText: "put11a111"}
+	start := time.Now()
+
+	response, _ := performCommand(testLogger, localStoreChannel, responseChannel,
+		[]chan<- *commandRequest{peerChannel}, ackChannel, request, 1, 50*time.Millisecond, &commandTiming{})
+
+	// the local response is still returned once the timeout expires
+	if response != ackResponse {
+		t.Errorf("Expected %s but got %s", ackResponse, response)
+	}
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Error("Expected to wait for the timeout but took: ", elapsed)
+	}
+}
+
+func checkTimeout(t *testing.T, expected time.Duration, actual time.Duration) {
+	t.Helper()
+
+	if actual != expected {
+		t.Errorf("Expected timeout %v but got %v", expected, actual)
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// topology returns how keys are assigned to servers, for clients to send the commands for each key
+// straight to a server holding it, as space-separated name=value pairs: the replicas of each key (0 if
+// the keys aren't sharded), the virtual nodes of each server, then each server on the hash ring with its
+// client address, empty if not known.
+func (s *Server) topology() string {
+	s.mutex.Lock()
+	ring, self, others := s.ring, s.id, s.config.OtherServers
+	selfAddress := ""
+
+	if s.clientListener != nil {
+		selfAddress = listenerAddress(s.clientListener)
+	}
+	s.mutex.Unlock()
+
+	if ring == nil {
+		return fmt.Sprintf("replicas=0 server.%s=%s", self, selfAddress)
+	}
+
+	fields := []string{
+		fmt.Sprintf("replicas=%d virtual_nodes=%d", ring.ring.Replicas(), ring.ring.VirtualNodes()),
+		fmt.Sprintf("server.%s=%s", self, selfAddress),
+	}
+
+	for _, other := range others {
+		fields = append(fields, fmt.Sprintf("server.%s=%s", other, s.config.ClientAddresses[other]))
+	}
+
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_Server_Topology(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		NodeID:             "server1",
+		OtherServers:       []string{"server2:9001", "server3:9001"},
+		ShardReplicas:      2,
+		VirtualNodes:       16,
+		ClientAddresses:    ClientAddresses{"server2:9001": "server2:8001"},
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer srv.Shutdown(context.Background())
+
+	client, err := net.Dial("tcp4", srv.ClientAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	expected := strings.Join([]string{
+		"replicas=2 virtual_nodes=16",
+		"server.server1=" + srv.ClientAddr().String(),
+		"server.server2:9001=server2:8001",
+		"server.server3:9001=",
+	}, " ")
+
+	checkRequestResponse(t, client, "top", "val"+formatArgument(expected))
+	checkRequestResponse(t, client, "bye", "")
+}
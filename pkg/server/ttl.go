@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidNamespaceTTL = errors.New("invalid namespace TTL, expected prefix=duration")
+
+// NamespaceTTLs holds the default time to live of keys in each namespace, keyed by key prefix.
+//
+// The TTL of a key is determined by, in order of precedence:
+//  1. the TTL given with the pex command
+//  2. the default TTL of the namespace with the longest prefix matching the key
+//  3. otherwise the key never expires
+type NamespaceTTLs map[string]time.Duration
+
+// ParseNamespaceTTLs parses a comma-separated list of prefix=duration pairs, e.g. "cache/=5m,session/=30m".
+func ParseNamespaceTTLs(list string) (NamespaceTTLs, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	ttls := make(NamespaceTTLs)
+
+	for _, pair := range strings.Split(list, ",") {
+		prefix, duration, found := strings.Cut(pair, "=")
+		if !found || prefix == "" {
+			return nil, fmt.Errorf("%w: %s", errInvalidNamespaceTTL, pair)
+		}
+
+		ttl, err := time.ParseDuration(duration)
+		if err != nil || ttl < time.Millisecond {
+			return nil, fmt.Errorf("%w: %s", errInvalidNamespaceTTL, pair)
+		}
+
+		ttls[prefix] = ttl
+	}
+
+	return ttls, nil
+}
+
+// defaultTTL returns the TTL of the namespace the key belongs to, or zero if none.
+func (n NamespaceTTLs) defaultTTL(key string) time.Duration {
+	var ttl time.Duration
+
+	longest := -1
+
+	for prefix, prefixTTL := range n {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			ttl = prefixTTL
+			longest = len(prefix)
+		}
+	}
+
+	return ttl
+}
+
+// withDefaultTTL returns the request as a put with expiry if it is a put without a TTL to a key
+// in a namespace with a default TTL, so the TTL is applied locally and replicated to peers.
+func withDefaultTTL(request *commandRequest, ttls NamespaceTTLs) *commandRequest {
+	if request.command != putCommand && request.command != checksumPutCommand {
+		return request
+	}
+
+	ttl := ttls.defaultTTL(request.key)
+	if ttl == 0 {
+		return request
+	}
+
+	milliseconds := int(ttl / time.Millisecond)
+	text := "pex" + formatArgument(request.key) + formatArgument(request.value) +
+		formatArgument(strconv.Itoa(milliseconds))
+
+	return &commandRequest{command: putExpiryCommand, key: request.key, value: request.value, length: milliseconds,
+		originalText: text}
+}
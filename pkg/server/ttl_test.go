@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_ParseNamespaceTTLs(t *testing.T) {
+	ttls, err := ParseNamespaceTTLs("cache/=5m,cache/short/=1s")
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	expected := NamespaceTTLs{"cache/": 5 * time.Minute, "cache/short/": time.Second}
+	if !reflect.DeepEqual(ttls, expected) {
+		t.Errorf("Expected %v but got %v", expected, ttls)
+	}
+}
+
+func Test_ParseNamespaceTTLs_Invalid(t *testing.T) {
+	for _, list := range []string{"cache/", "=5m", "cache/=soon", "cache/=0s"} {
+		if _, err := ParseNamespaceTTLs(list); !errors.Is(err, errInvalidNamespaceTTL) {
+			t.Errorf("Wrong error returned for %s: %v", list, err)
+		}
+	}
+}
+
+func Test_NamespaceTTLs_defaultTTL(t *testing.T) {
+	ttls := NamespaceTTLs{"cache/": 5 * time.Minute, "cache/short/": time.Second}
+
+	checkTTL(t, 5*time.Minute, ttls.defaultTTL("cache/a"))
+	checkTTL(t, time.Second, ttls.defaultTTL("cache/short/a")) // longest prefix wins
+	checkTTL(t, 0, ttls.defaultTTL("other/a"))                 // never expires
+}
+
+func Test_withDefaultTTL(t *testing.T) {
+	ttls := NamespaceTTLs{"cache/": time.Second}
+
+	put := &commandRequest{command: putCommand, key: "cache/a", value: "b", originalText: "put17cache/a11b"}
+	expected := &commandRequest{command: putExpiryCommand, key: "cache/a", value: "b", length: 1000,
+		originalText: "pex17cache/a11b141000"}
+	checkParseCommand(t, expected, withDefaultTTL(put, ttls), false, nil)
+
+	// a TTL given by the client takes precedence
+	putExpiry := &commandRequest{command: putExpiryCommand, key: "cache/a", value: "b", length: 5,
+		originalText: "pex17cache/a11b115"}
+	checkParseCommand(t, putExpiry, withDefaultTTL(putExpiry, ttls), false, nil)
+
+	other := &commandRequest{command: putCommand, key: "other", value: "b", originalText: "put15other11b"}
+	checkParseCommand(t, other, withDefaultTTL(other, ttls), false, nil)
+}
+
+func Test_handle_NamespaceTTL(t *testing.T) {
+	server1, client := net.Pipe()
+	server2, peer2 := net.Pipe()
+	store := kvstore.NewKVStore()
+
+	go handle(testLogger, newConnection(server1), store, connectedPeers(peer2),
+		&handlerConfig{namespaceTTLs: NamespaceTTLs{"c/": 50 * time.Millisecond}})
+
+	// replicated with the namespace TTL
+	write(t, client, "put13c/a11b")
+	read(t, server2, "pex13c/a11b1250")
+	write(t, server2, "ack")
+	read(t, client, "ack")
+
+	checkRequestResponse(t, client, "get13c/a0", "val11b") // get key just written
+
+	time.Sleep(60 * time.Millisecond)
+
+	checkRequestResponse(t, client, "get13c/a0", "nil") // key expired
+	checkRequestResponse(t, client, "bye", "")          // shutdown
+}
+
+func checkTTL(t *testing.T, expected time.Duration, actual time.Duration) {
+	t.Helper()
+
+	if actual != expected {
+		t.Errorf("Expected TTL %v but got %v", expected, actual)
+	}
+}
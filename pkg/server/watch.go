@@ -0,0 +1,583 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"tcp/pkg/kvstore"
+	"time"
+)
+
+// SlowWatcherPolicy determines what happens to a change when a watching connection's buffer is full, because
+// it isn't reading the changes as fast as keys are being changed.
+type SlowWatcherPolicy int
+
+const (
+	// SlowWatcherDisconnect closes the connection, so the client can reconnect and read the current values.
+	SlowWatcherDisconnect SlowWatcherPolicy = iota
+
+	// SlowWatcherDropOldest drops the oldest change waiting to be sent, so the connection receives the most
+	// recent changes, with a gap marker sent in place of those dropped.
+	SlowWatcherDropOldest SlowWatcherPolicy = iota
+
+	// SlowWatcherBlock keeps the changes waiting beyond the buffer, up to twice its size, for up to the block
+	// timeout before disconnecting, so every change is sent to a connection that catches up in time, without
+	// the store waiting for it.
+	SlowWatcherBlock SlowWatcherPolicy = iota
+)
+
+// ParseSlowWatcherPolicy returns the policy with the specified name: disconnect, drop or block.
+func ParseSlowWatcherPolicy(name string) (SlowWatcherPolicy, error) {
+	switch name {
+	case "disconnect":
+		return SlowWatcherDisconnect, nil
+
+	case "drop":
+		return SlowWatcherDropOldest, nil
+
+	case "block":
+		return SlowWatcherBlock, nil
+
+	default:
+		return SlowWatcherDisconnect, fmt.Errorf("%w: %s", errUnknownSlowWatcherPolicy, name)
+	}
+}
+
+func (p SlowWatcherPolicy) String() string {
+	switch p {
+	case SlowWatcherDropOldest:
+		return "drop"
+
+	case SlowWatcherBlock:
+		return "block"
+
+	default:
+		return "disconnect"
+	}
+}
+
+const (
+	// key set, followed by the key and its new value
+	setEvent = "evs"
+
+	// key removed, by being deleted, expiring or every key being flushed, followed by the key
+	deleteEvent = "evd"
+
+	// changes dropped because the connection was too slow, followed by how many, sent before the next change
+	gapEvent = "evg"
+
+	// the most changes and responses waiting to be sent to a watching connection, and how long a change
+	// waits for space when blocking, by default
+	defaultWatchBuffer       = 1000
+	defaultWatchBlockTimeout = 100 * time.Millisecond
+
+	// how many times its buffer a blocking connection can have waiting to be sent, before it is disconnected
+	watchOverflowFactor = 2
+)
+
+var (
+	errUnknownSlowWatcherPolicy = errors.New("unknown slow watcher policy")
+	errWatcherStopped           = errors.New("watcher stopped")
+)
+
+// watchHub sends the changes to each key to the connections watching it, and the keyspace notifications
+// enabled to the connections subscribed to their channels, handling connections too slow to keep up
+// according to the policy, once limit changes and responses (default 1000) are waiting to be sent.
+type watchHub struct {
+	limit          int
+	policy         SlowWatcherPolicy
+	blockTimeout   time.Duration
+	keyspaceEvents KeyspaceEvents
+
+	mutex    sync.Mutex
+	watchers map[string]map[*watcher]struct{}
+	channels map[string]map[*watcher]struct{}
+
+	// how many changes were dropped, and connections disconnected, for being too slow
+	dropped      atomic.Int64
+	disconnected atomic.Int64
+}
+
+// watchStats is the state of the watching connections, reported by the info command.
+type watchStats struct {
+	watchers       int
+	keys           int
+	channels       int
+	keyspaceEvents KeyspaceEvents
+	policy         SlowWatcherPolicy
+	dropped        int64
+	disconnected   int64
+}
+
+func newWatchHub(limit int, policy SlowWatcherPolicy, blockTimeout time.Duration,
+	keyspaceEvents KeyspaceEvents) *watchHub {
+	if limit < 1 {
+		limit = defaultWatchBuffer
+	}
+
+	if blockTimeout <= 0 {
+		blockTimeout = defaultWatchBlockTimeout
+	}
+
+	return &watchHub{
+		limit:          limit,
+		policy:         policy,
+		blockTimeout:   blockTimeout,
+		keyspaceEvents: keyspaceEvents,
+		watchers:       make(map[string]map[*watcher]struct{}),
+		channels:       make(map[string]map[*watcher]struct{}),
+	}
+}
+
+// watcher sends the changes to the keys a connection watches, and the messages on the channels it
+// subscribes to, in the order they were made, from its own go routine so a slow connection doesn't slow
+// down the store.
+type watcher struct {
+	conn     *connection
+	limit    int
+	keys     map[string]struct{}
+	channels map[string]struct{}
+
+	mutex   sync.Mutex
+	pending []watchEntry
+
+	// changes dropped since the last pending entry, to be reported before the next entry
+	gap int
+
+	// when the pending entries filled the buffer, if still full, and the timer disconnecting the watcher if
+	// they stay full for longer than the timeout, when blocking
+	fullSince time.Time
+	fullTimer *time.Timer
+
+	// signalled when an entry is added to, or sent from, the pending entries
+	ready chan struct{}
+	space chan struct{}
+
+	stopping sync.Once
+	done     chan struct{}
+}
+
+// watchEntry is a change or response waiting to be sent, after reporting the changes dropped before it.
+type watchEntry struct {
+	response string
+	change   bool
+	gap      int
+}
+
+// watch sends the changes to the key to the watcher.
+func (h *watchHub) watch(w *watcher, key string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	addWatcher(h.watchers, w, key)
+	w.keys[key] = struct{}{}
+}
+
+// unwatch stops sending the changes to the key to the watcher.
+func (h *watchHub) unwatch(w *watcher, key string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(w.keys, key)
+	removeWatcher(h.watchers, w, key)
+}
+
+// subscribe sends the messages published to the channel to the watcher.
+func (h *watchHub) subscribe(w *watcher, channel string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	addWatcher(h.channels, w, channel)
+	w.channels[channel] = struct{}{}
+}
+
+// unsubscribe stops sending the messages published to the channel to the watcher.
+func (h *watchHub) unsubscribe(w *watcher, channel string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(w.channels, channel)
+	removeWatcher(h.channels, w, channel)
+}
+
+// stop stops the watcher, which no longer watches any key or subscribes to any channel. Stopped first, so
+// a change blocked waiting for space for it gives up.
+func (h *watchHub) stop(w *watcher) {
+	w.stop()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for key := range w.keys {
+		delete(w.keys, key)
+		removeWatcher(h.watchers, w, key)
+	}
+
+	for channel := range w.channels {
+		delete(w.channels, channel)
+		removeWatcher(h.channels, w, channel)
+	}
+}
+
+// addWatcher adds the watcher to those of the key or channel.
+func addWatcher(watchers map[string]map[*watcher]struct{}, w *watcher, name string) {
+	if watchers[name] == nil {
+		watchers[name] = make(map[*watcher]struct{})
+	}
+
+	watchers[name][w] = struct{}{}
+}
+
+// removeWatcher removes the watcher from those of the key or channel.
+func removeWatcher(watchers map[string]map[*watcher]struct{}, w *watcher, name string) {
+	delete(watchers[name], w)
+
+	if len(watchers[name]) == 0 {
+		delete(watchers, name)
+	}
+}
+
+// observe queues the change to be sent to each connection watching the key, and publishes its keyspace
+// notifications if enabled, handling connections too slow to keep up according to the policy. Called by
+// the store for every change.
+func (h *watchHub) observe(change kvstore.Change) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.notify(change)
+
+	watchers := h.watchers[change.Key]
+	if len(watchers) == 0 {
+		return
+	}
+
+	value := change.Value
+	if change.Type != "" {
+		// a collection changed in place, sent whole
+		value = change.Contents()
+	}
+
+	event := deleteEvent + formatArgument(change.Key)
+	if !change.Deleted {
+		event = setEvent + formatArgument(change.Key) + formatArgument(value)
+	}
+
+	for w := range watchers {
+		h.deliver(w, event)
+	}
+}
+
+// notify publishes the change to the key's keyspace channel and its class's keyevent channel, if its class
+// is enabled. Must be called while holding the mutex.
+func (h *watchHub) notify(change kvstore.Change) {
+	event := keyspaceEventOf(change)
+	if h.keyspaceEvents&event == 0 {
+		return
+	}
+
+	h.publish(keyspaceChannelPrefix+change.Key, event.String())
+	h.publish(keyeventChannelPrefix+event.String(), change.Key)
+}
+
+// publish queues the message to be sent to each connection subscribed to the channel. Must be called while
+// holding the mutex.
+func (h *watchHub) publish(channel string, message string) {
+	subscribers := h.channels[channel]
+	if len(subscribers) == 0 {
+		return
+	}
+
+	event := messageEvent + formatArgument(channel) + formatArgument(message)
+
+	for w := range subscribers {
+		h.deliver(w, event)
+	}
+}
+
+// deliver queues the change to be sent to the watcher, dropping the oldest change, waiting for space or
+// disconnecting the watcher if its buffer is full.
+func (h *watchHub) deliver(w *watcher, event string) {
+	switch h.policy {
+	case SlowWatcherDropOldest:
+		if w.add(event, true, true) {
+			h.dropped.Add(1)
+		}
+
+		return
+
+	case SlowWatcherBlock:
+		if !w.overflow(event, h.blockTimeout, time.Now(), func() { h.disconnect(w) }) {
+			return
+		}
+
+	default:
+		if !w.add(event, true, false) {
+			return
+		}
+	}
+
+	h.disconnect(w)
+}
+
+// disconnect closes the connection of a watcher too slow to keep up, which ends its session, stopping the
+// watcher.
+func (h *watchHub) disconnect(w *watcher) {
+	if w.stop() {
+		h.disconnected.Add(1)
+		_ = w.conn.Close()
+	}
+}
+
+// stats returns how many connections are watching keys or subscribed to channels, how many keys are
+// watched and channels subscribed to, and how slow connections have been handled.
+func (h *watchHub) stats() watchStats {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	watchers := make(map[*watcher]struct{})
+
+	for _, all := range []map[string]map[*watcher]struct{}{h.watchers, h.channels} {
+		for _, nameWatchers := range all {
+			for w := range nameWatchers {
+				watchers[w] = struct{}{}
+			}
+		}
+	}
+
+	return watchStats{
+		watchers:       len(watchers),
+		keys:           len(h.watchers),
+		channels:       len(h.channels),
+		keyspaceEvents: h.keyspaceEvents,
+		policy:         h.policy,
+		dropped:        h.dropped.Load(),
+		disconnected:   h.disconnected.Load(),
+	}
+}
+
+// newWatcher returns a watcher sending to the connection, buffering up to the hub's limit.
+func (h *watchHub) newWatcher(conn *connection) *watcher {
+	w := &watcher{
+		conn:     conn,
+		limit:    h.limit,
+		keys:     make(map[string]struct{}),
+		channels: make(map[string]struct{}),
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	go w.send()
+
+	return w
+}
+
+// send writes each pending entry to the connection, preceded by a gap marker if changes were dropped
+// before it, until stopped.
+func (w *watcher) send() {
+	for {
+		w.mutex.Lock()
+
+		if len(w.pending) == 0 {
+			w.mutex.Unlock()
+
+			select {
+			case <-w.ready:
+				continue
+			case <-w.done:
+				return
+			}
+		}
+
+		entry := w.pending[0]
+		w.pending = w.pending[1:]
+
+		if len(w.pending) < w.limit && !w.fullSince.IsZero() {
+			w.fullSince = time.Time{}
+			w.fullTimer.Stop()
+		}
+
+		w.mutex.Unlock()
+
+		signal(w.space)
+
+		response := entry.response
+		if entry.gap > 0 {
+			response = gapEvent + formatArgument(strconv.Itoa(entry.gap)) + response
+		}
+
+		if err := reliableWrite(w.conn, response); err != nil {
+			return
+		}
+	}
+}
+
+// add adds the change or response to the pending entries, unless full, in which case if dropping, the
+// oldest pending change is dropped instead (or this change, if none are pending). Returns whether full.
+func (w *watcher) add(response string, change bool, dropping bool) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	full := len(w.pending) >= w.limit
+
+	if full {
+		if !dropping {
+			return true
+		}
+
+		if !w.dropOldest() {
+			w.gap++
+			return true
+		}
+	}
+
+	w.pending = append(w.pending, watchEntry{response, change, w.gap})
+	w.gap = 0
+
+	signal(w.ready)
+
+	return full
+}
+
+// overflow adds the change to the pending entries, even if full, unless they have been full for longer than
+// the timeout, or hold watchOverflowFactor times the limit. Returns whether too slow. Once full, tooSlow is
+// called if they are still full after the timeout, even if no further change is made.
+func (w *watcher) overflow(response string, timeout time.Duration, now time.Time, tooSlow func()) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.pending) >= watchOverflowFactor*w.limit {
+		return true
+	}
+
+	if len(w.pending) >= w.limit {
+		if w.fullSince.IsZero() {
+			w.fullSince = now
+			w.fullTimer = time.AfterFunc(timeout, func() {
+				if w.fullFor(timeout, time.Now()) {
+					tooSlow()
+				}
+			})
+		} else if now.Sub(w.fullSince) > timeout {
+			return true
+		}
+	}
+
+	w.pending = append(w.pending, watchEntry{response, true, w.gap})
+	w.gap = 0
+
+	signal(w.ready)
+
+	return false
+}
+
+// fullFor returns whether the pending entries have been full for at least the time.
+func (w *watcher) fullFor(duration time.Duration, now time.Time) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return !w.fullSince.IsZero() && now.Sub(w.fullSince) >= duration
+}
+
+// dropOldest removes the oldest pending change, to be reported by the entry after it, returning whether
+// there was one. Must be called while holding the mutex.
+func (w *watcher) dropOldest() bool {
+	for i, entry := range w.pending {
+		if !entry.change {
+			continue
+		}
+
+		if i+1 < len(w.pending) {
+			w.pending[i+1].gap += entry.gap + 1
+		} else {
+			w.gap += entry.gap + 1
+		}
+
+		w.pending = append(w.pending[:i], w.pending[i+1:]...)
+
+		return true
+	}
+
+	return false
+}
+
+// queue queues the response to be sent after the changes already queued, waiting for space if needed.
+func (w *watcher) queue(response string) error {
+	for w.add(response, false, false) {
+		select {
+		case <-w.space:
+		case <-w.done:
+			return errWatcherStopped
+		}
+	}
+
+	return nil
+}
+
+// stop stops sending entries, returning whether this call stopped it.
+func (w *watcher) stop() bool {
+	stopped := false
+
+	w.stopping.Do(func() {
+		close(w.done)
+
+		stopped = true
+	})
+
+	return stopped
+}
+
+// signal wakes up whatever is waiting on the channel, if not already woken.
+func signal(channel chan struct{}) {
+	select {
+	case channel <- struct{}{}:
+	default:
+	}
+}
+
+// handleWatch starts or stops sending the changes to the key, or the messages published to the channel, to
+// the connection, acknowledging before any change or message is sent. Returns the response, if not already
+// sent.
+func (s *session) handleWatch(command *commandRequest) string {
+	watches := s.config.watches
+
+	switch command.command {
+	case unwatchCommand, unsubscribeCommand:
+		if s.watcher == nil {
+			return ackResponse
+		}
+
+		if command.command == unwatchCommand {
+			watches.unwatch(s.watcher, command.key)
+		} else {
+			watches.unsubscribe(s.watcher, command.value)
+		}
+
+		return ackResponse
+	}
+
+	if s.watcher == nil {
+		s.watcher = watches.newWatcher(s.conn)
+	}
+
+	if err := s.respond(ackResponse); err != nil {
+		return ""
+	}
+
+	if command.command == watchCommand {
+		watches.watch(s.watcher, command.key)
+	} else {
+		watches.subscribe(s.watcher, command.value)
+	}
+
+	return ""
+}
+
+// stopWatching stops sending changes to the connection, when the session ends.
+func (s *session) stopWatching() {
+	if s.watcher != nil {
+		s.config.watches.stop(s.watcher)
+	}
+}
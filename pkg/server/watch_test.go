@@ -0,0 +1,190 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_ParseSlowWatcherPolicy(t *testing.T) {
+	if policy, err := ParseSlowWatcherPolicy("drop"); policy != SlowWatcherDropOldest || err != nil {
+		t.Errorf("Expected drop oldest but got %v, %v", policy, err)
+	}
+
+	if _, err := ParseSlowWatcherPolicy("shed"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func Test_handle_Watch(t *testing.T) {
+	watcherServer, watcherClient := net.Pipe()
+	writerServer, writerClient := net.Pipe()
+
+	store := kvstore.NewKVStore()
+	hub := newWatchHub(0, SlowWatcherDisconnect, 0, 0)
+	kvstore.Observe(store, hub.observe)
+
+	go handle(testLogger, newConnection(watcherServer), store, nil, &handlerConfig{watches: hub})
+	go handle(testLogger, newConnection(writerServer), store, nil, &handlerConfig{watches: hub})
+
+	checkRequestResponse(t, watcherClient, "wch11a", ackResponse)
+
+	checkRequestResponse(t, writerClient, "put11a13123", ackResponse)
+	read(t, watcherClient, setEvent+"11a13123")
+
+	checkRequestResponse(t, writerClient, "put11b13456", ackResponse)
+	checkRequestResponse(t, writerClient, "del11a", ackResponse)
+	read(t, watcherClient, deleteEvent+"11a")
+
+	// other commands still work, their responses following the changes already sent
+	checkRequestResponse(t, watcherClient, "get11b0", "val13456")
+
+	if stats := hub.stats(); stats.watchers != 1 || stats.keys != 1 {
+		t.Errorf("Expected 1 watcher of 1 key but got %d of %d", stats.watchers, stats.keys)
+	}
+
+	checkRequestResponse(t, watcherClient, "uwc11a", ackResponse)
+	checkRequestResponse(t, writerClient, "put11a13789", ackResponse)
+	checkRequestResponse(t, watcherClient, "png", pongResponse)
+
+	checkRequestResponse(t, watcherClient, "bye", "")
+	checkRequestResponse(t, writerClient, "bye", "")
+}
+
+func Test_watchHub_SlowWatcher(t *testing.T) {
+	server, client := net.Pipe()
+
+	hub := newWatchHub(0, SlowWatcherDisconnect, 0, 0)
+	w := hub.newWatcher(newConnection(server))
+	hub.watch(w, "a")
+
+	defer hub.stop(w)
+
+	// the first change is being sent, the rest fill the buffer, then the connection is closed
+	for i := 0; i < defaultWatchBuffer+2; i++ {
+		hub.observe(kvstore.Change{Key: "a", Value: "1"})
+	}
+
+	if _, err := io.ReadAll(client); err != nil {
+		t.Error("Expected the connection to be closed but got: ", err)
+	}
+
+	if stats := hub.stats(); stats.disconnected != 1 || stats.dropped != 0 {
+		t.Errorf("Expected 1 disconnected and none dropped but got %d and %d", stats.disconnected, stats.dropped)
+	}
+}
+
+func Test_watchHub_DropOldest(t *testing.T) {
+	server, client := net.Pipe()
+
+	hub := newWatchHub(2, SlowWatcherDropOldest, 0, 0)
+	w := hub.newWatcher(newConnection(server))
+	hub.watch(w, "a")
+
+	defer hub.stop(w)
+
+	// the first change is being sent, until read
+	hub.observe(kvstore.Change{Key: "a", Value: "0"})
+
+	for !waitingToSend(w) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// then the buffer holds the last 2 changes, with 1 and 2 dropped
+	for i := 1; i <= 4; i++ {
+		hub.observe(kvstore.Change{Key: "a", Value: strconv.Itoa(i)})
+	}
+
+	read(t, client, setEvent+"11a110")
+	read(t, client, gapEvent+"112"+setEvent+"11a113")
+	read(t, client, setEvent+"11a114")
+
+	if stats := hub.stats(); stats.policy != SlowWatcherDropOldest || stats.dropped != 2 {
+		t.Errorf("Expected 2 dropped by the drop policy but got %d by %s", stats.dropped, stats.policy)
+	}
+}
+
+func Test_watchHub_Block(t *testing.T) {
+	server, client := net.Pipe()
+
+	hub := newWatchHub(2, SlowWatcherBlock, 100*time.Millisecond, 0)
+	w := hub.newWatcher(newConnection(server))
+	hub.watch(w, "a")
+
+	defer hub.stop(w)
+
+	// the changes wait beyond the buffer, without the store waiting for them
+	for i := 0; i < 3; i++ {
+		hub.observe(kvstore.Change{Key: "a", Value: strconv.Itoa(i)})
+	}
+
+	// none are dropped while read in time
+	for i := 0; i < 3; i++ {
+		read(t, client, setEvent+"11a11"+strconv.Itoa(i))
+	}
+
+	// then the buffer stays full for longer than the timeout, so the connection is disconnected, without
+	// waiting for another change
+	for i := 3; i < 7; i++ {
+		hub.observe(kvstore.Change{Key: "a", Value: strconv.Itoa(i)})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for hub.stats().disconnected == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := io.ReadAll(client); err != nil {
+		t.Error("Expected the connection to be closed but got: ", err)
+	}
+
+	if stats := hub.stats(); stats.disconnected != 1 || stats.dropped != 0 {
+		t.Errorf("Expected 1 disconnected and none dropped but got %d and %d", stats.disconnected, stats.dropped)
+	}
+}
+
+func Test_watchHub_BlockLimit(t *testing.T) {
+	server, client := net.Pipe()
+
+	hub := newWatchHub(1, SlowWatcherBlock, time.Hour, 0)
+	w := hub.newWatcher(newConnection(server))
+	hub.watch(w, "a")
+
+	defer hub.stop(w)
+
+	hub.observe(kvstore.Change{Key: "a", Value: "0"})
+
+	for !waitingToSend(w) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// twice the buffer waits, then the connection is disconnected however long the timeout
+	hub.observe(kvstore.Change{Key: "a", Value: "1"})
+	hub.observe(kvstore.Change{Key: "a", Value: "2"})
+
+	if stats := hub.stats(); stats.disconnected != 0 {
+		t.Error("Expected none disconnected but got: ", stats.disconnected)
+	}
+
+	hub.observe(kvstore.Change{Key: "a", Value: "3"})
+
+	if _, err := io.ReadAll(client); err != nil {
+		t.Error("Expected the connection to be closed but got: ", err)
+	}
+
+	if stats := hub.stats(); stats.disconnected != 1 {
+		t.Error("Expected 1 disconnected but got: ", stats.disconnected)
+	}
+}
+
+// waitingToSend returns whether the watcher has no pending entries, since it is sending the last one.
+func waitingToSend(w *watcher) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return len(w.pending) == 0
+}
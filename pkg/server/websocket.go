@@ -0,0 +1,300 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1" //nolint:gosec // required by the WebSocket handshake, not used for security
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// path of the HTTP gateway's WebSocket endpoint
+const websocketPath = "/ws"
+
+// appended to the client's key to accept a WebSocket handshake, see RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// longest payload of a control frame
+const maxControlPayload = 125
+
+var (
+	errUnmaskedFrame = errors.New("client frame not masked")
+	errFrameTooLarge = errors.New("frame too large")
+)
+
+// handleWebSocket upgrades the request to a WebSocket, then handles the commands sent in its messages
+// as a client connection, sending each response as a message. Commands may span messages, or several
+// may be sent in one, since messages are read as a stream the same as a TCP connection.
+func (s *Server) handleWebSocket(logger *slog.Logger, w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "unable to upgrade connection", http.StatusInternalServerError)
+		return
+	}
+
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		logger.Warn("unable to upgrade connection", "remote", r.RemoteAddr, "error", err)
+		return
+	}
+
+	accept := sha1.Sum([]byte(key + websocketGUID)) //nolint:gosec // see import
+
+	_, err = fmt.Fprintf(buffered, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n"+
+		"Connection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(accept[:]))
+	if err == nil {
+		err = buffered.Flush()
+	}
+
+	if err != nil {
+		logger.Warn("unable to upgrade connection", "remote", r.RemoteAddr, "error", err)
+		_ = conn.Close()
+
+		return
+	}
+
+	ws := &websocketConn{Conn: conn, reader: buffered.Reader, maxPayload: s.clientConfig.commandSizeLimit()}
+
+	c, err := s.track(ws, s.clientConfig)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	defer s.untrack(c)
+
+	s.openConnectionsAndHandle(logger, c, s.clientConfig)
+}
+
+// headerContains returns whether the comma-separated header contains the token, ignoring case.
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// websocketConn reads and writes the payload of WebSocket messages as a stream, answering pings
+// and close frames itself.
+type websocketConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	maxPayload int
+
+	// payload of the latest data frame not yet read
+	pending []byte
+
+	// guards writes, and whether a close frame has been received
+	mutex  sync.Mutex
+	closed bool
+}
+
+// Read reads the payload of data frames, waiting for the next if none is pending.
+func (c *websocketConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.isClosed() {
+			return 0, io.EOF
+		}
+
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+// readFrame reads the next frame, keeping the payload of a data frame to be read.
+func (c *websocketConn) readFrame() error {
+	opcode, payload, err := c.readFramePayload()
+	if err != nil {
+		return err
+	}
+
+	switch opcode {
+	case opText, opBinary, opContinuation:
+		c.pending = payload
+
+	case opPing:
+		return c.writeFrame(opPong, payload)
+
+	case opClose:
+		// echo the status code, if any
+		err := c.writeFrame(opClose, payload[:min(len(payload), 2)])
+
+		c.mutex.Lock()
+		c.closed = true
+		c.mutex.Unlock()
+
+		return err
+	}
+
+	// pongs are ignored
+	return nil
+}
+
+// readFramePayload reads a frame, returning its opcode and unmasked payload.
+func (c *websocketConn) readFramePayload() (byte, []byte, error) {
+	var header [2]byte
+
+	if err := c.readFull(header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	opcode := header[0] & 0x0F
+
+	if header[1]&0x80 == 0 {
+		return 0, nil, errUnmaskedFrame
+	}
+
+	length, err := c.readLength(header[1] & 0x7F)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if length > uint64(c.maxPayload) || (opcode >= opClose && length > maxControlPayload) {
+		return 0, nil, fmt.Errorf("%w: %d bytes", errFrameTooLarge, length)
+	}
+
+	var mask [4]byte
+
+	payload := make([]byte, length)
+
+	if err := c.readFull(mask[:]); err != nil {
+		return 0, nil, err
+	}
+
+	if err := c.readFull(payload); err != nil {
+		return 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return opcode, payload, nil
+}
+
+// readLength returns the payload length, reading the extended length if the 7 bit length indicates one.
+func (c *websocketConn) readLength(length byte) (uint64, error) {
+	switch length {
+	case 126:
+		var extended [2]byte
+
+		err := c.readFull(extended[:])
+
+		return uint64(binary.BigEndian.Uint16(extended[:])), err
+
+	case 127:
+		var extended [8]byte
+
+		err := c.readFull(extended[:])
+
+		return binary.BigEndian.Uint64(extended[:]), err
+
+	default:
+		return uint64(length), nil
+	}
+}
+
+func (c *websocketConn) readFull(p []byte) error {
+	if _, err := io.ReadFull(c.reader, p); err != nil {
+		return fmt.Errorf("error reading frame: %w", err)
+	}
+
+	return nil
+}
+
+// Write sends the bytes as a single message, as text if valid UTF-8 (so browsers receive a string)
+// otherwise binary.
+func (c *websocketConn) Write(p []byte) (int, error) {
+	opcode := byte(opBinary)
+	if utf8.Valid(p) {
+		opcode = opText
+	}
+
+	if err := c.writeFrame(opcode, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// writeFrame sends an unmasked, unfragmented frame.
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+
+	switch {
+	case len(payload) <= maxControlPayload:
+		frame = append(frame, byte(len(payload)))
+
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, err := c.Conn.Write(append(frame, payload...)); err != nil {
+		return fmt.Errorf("error writing frame: %w", err)
+	}
+
+	return nil
+}
+
+func (c *websocketConn) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.closed
+}
+
+// Close sends a close frame, unless the client already closed the connection, then closes it.
+func (c *websocketConn) Close() error {
+	if !c.isClosed() {
+		_ = c.writeFrame(opClose, nil)
+	}
+
+	return c.Conn.Close() //nolint:wrapcheck // callers wrap errors
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"tcp/pkg/kvstore"
+	"testing"
+	"time"
+)
+
+func Test_Server_WebSocket(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		HTTPHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_ = srv.Shutdown(ctx)
+	}()
+
+	conn, err := net.Dial("tcp4", srv.HTTPAddr().String())
+	if err != nil {
+		t.Fatal("Unable to connect: ", err)
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// example handshake from RFC 6455
+	write(t, conn, "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal("Unable to read handshake response: ", err)
+	}
+
+	if response.StatusCode != http.StatusSwitchingProtocols ||
+		response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal("Wrong handshake response: ", response.Status, response.Header)
+	}
+
+	writeClientFrame(t, conn, opText, "put12bb13999")
+	checkServerFrame(t, reader, opText, "ack")
+
+	// a command can span messages
+	writeClientFrame(t, conn, opText, "get12b")
+	writeClientFrame(t, conn, opText, "b0")
+	checkServerFrame(t, reader, opText, "val13999")
+
+	writeClientFrame(t, conn, opPing, "hello")
+	checkServerFrame(t, reader, opPong, "hello")
+
+	writeClientFrame(t, conn, opClose, "\x03\xe8")
+	checkServerFrame(t, reader, opClose, "\x03\xe8")
+}
+
+func Test_Server_WebSocketNotUpgrade(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		ServerHostnamePort: "127.0.0.1:0",
+		PeerHostnamePort:   "127.0.0.1:0",
+		HTTPHostnamePort:   "127.0.0.1:0",
+	})
+
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	go func() {
+		_ = srv.Serve()
+	}()
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_ = srv.Shutdown(ctx)
+	}()
+
+	checkHTTP(t, http.MethodGet, "http://"+srv.HTTPAddr().String()+websocketPath, "", "", http.StatusBadRequest,
+		"expected a WebSocket handshake\n")
+}
+
+// writeClientFrame writes a masked frame, as sent by clients.
+func writeClientFrame(t *testing.T, conn net.Conn, opcode byte, payload string) {
+	t.Helper()
+
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x80 | opcode, 0x80 | byte(len(payload))}, mask...)
+
+	for i := range payload {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal("Unable to write frame: ", err)
+	}
+}
+
+// checkServerFrame reads an unmasked, short frame, as sent by the server.
+func checkServerFrame(t *testing.T, reader io.Reader, expectedOpcode byte, expectedPayload string) {
+	t.Helper()
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatal("Unable to read frame: ", err)
+	}
+
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal("Unable to read frame: ", err)
+	}
+
+	if header[0] != 0x80|expectedOpcode || string(payload) != expectedPayload {
+		t.Errorf("Expected frame %x %q but got %x %q", 0x80|expectedOpcode, expectedPayload, header[0], payload)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"tcp/pkg/kvstore"
+)
+
+// Proposed changes to the cluster that can be analysed with the what-if command.
+const (
+	addServerChange    = "add"
+	removeServerChange = "remove"
+)
+
+var (
+	errUnknownChange = errors.New("unknown change")
+	errAlreadyMember = errors.New("already a member of the cluster")
+	errNotMember     = errors.New("not a member of the cluster")
+)
+
+// whatIf reports how many keys and bytes would move if the proposed change were made to the cluster,
+// and from and to which servers, without moving any data. Without sharding every server holds a copy
+// of every key, so adding a server copies every key to it from this server, and removing one moves nothing.
+func (s *Server) whatIf(change string, server string) (string, error) {
+	s.mutex.Lock()
+	ring := s.ring
+	s.mutex.Unlock()
+
+	member := server == s.config.PeerHostnamePort || contains(s.peerList(), server)
+	if ring != nil {
+		member = member || server == ring.self
+	}
+
+	var keys, bytes int
+
+	switch {
+	case change != addServerChange && change != removeServerChange:
+		return "", fmt.Errorf("%w: %s", errUnknownChange, change)
+
+	case change == addServerChange && member:
+		return "", fmt.Errorf("%s %w", server, errAlreadyMember)
+
+	case change == removeServerChange && !member:
+		return "", fmt.Errorf("%s %w", server, errNotMember)
+
+	case ring != nil:
+		return s.whatIfSharded(change, server, ring), nil
+
+	case change == removeServerChange:
+		return "keys=0 bytes=0 from= to=", nil
+	}
+
+	kvstore.Scan(s.store, "", func(key string, value string) bool {
+		keys++
+		bytes += len(key) + len(value)
+
+		return true
+	})
+
+	return fmt.Sprintf("keys=%d bytes=%d from=%s to=%s", keys, bytes, s.config.PeerHostnamePort, server), nil
+}
+
+// whatIfSharded reports how many of this server's keys would move if the proposed change were made
+// to the sharded cluster. Adding a server copies the keys the new ring assigns to it from this server,
+// while removing one copies the keys it held to the servers the new ring assigns them to instead.
+func (s *Server) whatIfSharded(change string, server string, ring *hashRing) string {
+	peers := s.peerList()
+
+	var others []string
+
+	if change == addServerChange {
+		others = append(append(others, peers...), server)
+	} else {
+		for _, peer := range peers {
+			if peer != server {
+				others = append(others, peer)
+			}
+		}
+	}
+
+	changed := newHashRing(ring.self, others, ring.ring.Replicas(), ring.ring.VirtualNodes())
+
+	var keys, bytes int
+
+	var to []string
+
+	kvstore.Scan(s.store, "", func(key string, value string) bool {
+		switch {
+		case change == addServerChange && changed.owns(server, key):
+			keys++
+			bytes += len(key) + len(value)
+
+		case change == removeServerChange && ring.owns(server, key):
+			keys++
+			bytes += len(key) + len(value)
+
+			for _, owner := range changed.owners(key) {
+				if !ring.owns(owner, key) && !contains(to, owner) {
+					to = append(to, owner)
+				}
+			}
+		}
+
+		return true
+	})
+
+	if change == addServerChange {
+		return fmt.Sprintf("keys=%d bytes=%d from=%s to=%s", keys, bytes, ring.self, server)
+	}
+
+	sort.Strings(to)
+
+	return fmt.Sprintf("keys=%d bytes=%d from=%s to=%s", keys, bytes, server, strings.Join(to, ","))
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"tcp/pkg/kvstore"
+	"testing"
+)
+
+func Test_Server_whatIf(t *testing.T) {
+	store := kvstore.NewKVStore()
+	kvstore.Write(store, "ab", "123")
+	kvstore.Write(store, "c", "4")
+
+	srv := NewServer(store, Config{
+		PeerHostnamePort: "server1:8001",
+		OtherServers:     []string{"server2:8001"},
+	})
+
+	report, err := srv.whatIf(addServerChange, "server3:8001")
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if expected := "keys=2 bytes=7 from=server1:8001 to=server3:8001"; report != expected {
+		t.Errorf("Expected %s but got %s", expected, report)
+	}
+
+	report, err = srv.whatIf(removeServerChange, "server2:8001")
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if expected := "keys=0 bytes=0 from= to="; report != expected {
+		t.Errorf("Expected %s but got %s", expected, report)
+	}
+}
+
+func Test_Server_whatIfInvalid(t *testing.T) {
+	srv := NewServer(kvstore.NewKVStore(), Config{
+		PeerHostnamePort: "server1:8001",
+		OtherServers:     []string{"server2:8001"},
+	})
+
+	if _, err := srv.whatIf(addServerChange, "server2:8001"); !errors.Is(err, errAlreadyMember) {
+		t.Error("Wrong error returned: ", err)
+	}
+
+	if _, err := srv.whatIf(removeServerChange, "server3:8001"); !errors.Is(err, errNotMember) {
+		t.Error("Wrong error returned: ", err)
+	}
+
+	if _, err := srv.whatIf("rf", "server2:8001"); !errors.Is(err, errUnknownChange) {
+		t.Error("Wrong error returned: ", err)
+	}
+}
+
+func Test_Server_whatIfSharded(t *testing.T) {
+	store := kvstore.NewKVStore()
+
+	for i := 0; i < 100; i++ {
+		kvstore.Write(store, fmt.Sprintf("key%d", i), "1")
+	}
+
+	srv := NewServer(store, Config{
+		PeerHostnamePort: "server1:8001",
+		OtherServers:     []string{"server2:8001"},
+	})
+	srv.ring = newHashRing("server1:8001", []string{"server2:8001"}, 1, 0)
+
+	// keys are only copied to the new server if the new ring assigns them to it
+	report, err := srv.whatIf(addServerChange, "server3:8001")
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	added := newHashRing("server1:8001", []string{"server2:8001", "server3:8001"}, 1, 0)
+
+	var keys, bytes int
+
+	for i := 0; i < 100; i++ {
+		if key := fmt.Sprintf("key%d", i); added.owns("server3:8001", key) {
+			keys++
+			bytes += len(key) + 1
+		}
+	}
+
+	if keys == 0 || keys == 100 {
+		t.Error("Expected some keys assigned to the new server, but got: ", keys)
+	}
+
+	if expected := fmt.Sprintf("keys=%d bytes=%d from=server1:8001 to=server3:8001", keys, bytes); report != expected {
+		t.Errorf("Expected %s but got %s", expected, report)
+	}
+
+	// removing a server moves the keys it held to this server, the only one left
+	report, err = srv.whatIf(removeServerChange, "server2:8001")
+	if err != nil {
+		t.Error("Expected successful but got: ", err)
+	}
+
+	if !strings.HasSuffix(report, "from=server2:8001 to=server1:8001") {
+		t.Error("Expected keys moved from server2 to server1 but got: ", report)
+	}
+}
@@ -0,0 +1,33 @@
+// Package version reports the build information of the key value store, injected at build time with
+//
+//	go build -ldflags "-X tcp/pkg/version.Version=1.2.3 -X tcp/pkg/version.GitCommit=abc1234"
+package version
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Version is the semantic version of this build.
+var Version = "0.0.0-dev"
+
+// GitCommit is the git commit this build was made from.
+var GitCommit = "unknown"
+
+// PeerProtocolVersion is the version of the protocol used between servers, incremented whenever
+// replication changes incompatibly. Servers accept peers using the previous or next version.
+const PeerProtocolVersion = 2
+
+// Info returns the build information in a parseable format, as space-separated key=value pairs,
+// followed by the features enabled (if any).
+func Info(features []string) string {
+	info := "version=" + Version + " commit=" + GitCommit + " go=" + runtime.Version() +
+		" protocol=" + strconv.Itoa(PeerProtocolVersion)
+
+	if len(features) > 0 {
+		info += " features=" + strings.Join(features, ",")
+	}
+
+	return info
+}
@@ -0,0 +1,23 @@
+package version_test
+
+import (
+	"runtime"
+	"tcp/pkg/version"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	expected := "version=0.0.0-dev commit=unknown go=" + runtime.Version() + " protocol=2 features=auth,checksum"
+
+	if info := version.Info([]string{"auth", "checksum"}); info != expected {
+		t.Fatalf("Expected %s but was: %s", expected, info)
+	}
+}
+
+func TestInfoNoFeatures(t *testing.T) {
+	expected := "version=0.0.0-dev commit=unknown go=" + runtime.Version() + " protocol=2"
+
+	if info := version.Info(nil); info != expected {
+		t.Fatalf("Expected %s but was: %s", expected, info)
+	}
+}